@@ -0,0 +1,501 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/reconciler"
+	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
+	"github.com/marvinvr/docktail/version"
+)
+
+// Controller is the subset of the reconciler exposed over the HTTP API
+type Controller interface {
+	TriggerReconcile()
+	Interval() time.Duration
+	SetInterval(interval time.Duration) error
+	State() reconciler.State
+	History(service string) []reconciler.HistoryEvent
+	Pause()
+	Resume()
+	SetMaintenance(on bool)
+}
+
+// ServiceRegistry registers services at runtime, outside of container labels
+type ServiceRegistry interface {
+	RegisterService(entry docker.StaticService, ttl time.Duration) (docker.AdhocService, error)
+	UnregisterService(name string) error
+	AdhocServices() []docker.AdhocService
+}
+
+// Server serves the DockTail HTTP API
+type Server struct {
+	controller Controller
+	registry   ServiceRegistry
+	token      string
+	mux        *http.ServeMux
+}
+
+// IntervalResponse is the body of the interval endpoints
+type IntervalResponse struct {
+	Interval string `json:"interval"`
+}
+
+// StateResponse is the body of GET /state
+type StateResponse struct {
+	Version       version.Info       `json:"version"`
+	Interval      string             `json:"interval"`
+	DryRun        bool               `json:"dry_run"`
+	Paused        bool               `json:"paused"`
+	Maintenance   bool               `json:"maintenance"`
+	Node          tailscale.NodeInfo `json:"node"`
+	LastReconcile *ReconcileSummary  `json:"last_reconcile,omitempty"`
+	// Aliases maps alias services to the service they mirror
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Services reports services whose state differs from served and
+	// advertised, such as "configured, not advertised"
+	Services map[string]string `json:"services,omitempty"`
+	// ServiceTimes holds when each managed service was first advertised and
+	// last changed
+	ServiceTimes map[string]ServiceTimesResponse `json:"service_times,omitempty"`
+	// ConfigDiff is how the services on the node differed from the desired
+	// ones in the last cycle, omitted when they were the same
+	ConfigDiff *tailscale.ConfigDiff `json:"config_diff,omitempty"`
+	// ShadowCyclesLeft is how many cycles are left before changes are
+	// applied, omitted once they are
+	ShadowCyclesLeft int `json:"shadow_cycles_left,omitempty"`
+	// Skipped lists the containers the last discovery left out, and why
+	Skipped []SkippedContainer `json:"skipped,omitempty"`
+}
+
+// SkippedContainer is a container the last discovery left out
+type SkippedContainer struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	DockerHost    string `json:"docker_host,omitempty"`
+	Reason        string `json:"reason"`
+	Error         string `json:"error"`
+}
+
+// HealthResponse is the body of GET /healthz
+type HealthResponse struct {
+	// Status is "ok", or "stale" when no cycle succeeded recently
+	Status      string     `json:"status"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// ServiceTimesResponse reports when a service was first advertised and last changed
+type ServiceTimesResponse struct {
+	FirstAdvertised time.Time `json:"first_advertised"`
+	LastChanged     time.Time `json:"last_changed"`
+}
+
+// ServiceStatusUnadvertised is reported for services whose serve config is on
+// the node while docktail.service.advertise=false keeps them unadvertised
+const ServiceStatusUnadvertised = "configured, not advertised"
+
+// ServiceRequest is the body of POST /api/services
+type ServiceRequest struct {
+	Name            string   `json:"name"`
+	Destination     string   `json:"destination"`
+	Protocol        string   `json:"protocol,omitempty"`
+	ServicePort     string   `json:"service_port,omitempty"`
+	ServiceProtocol string   `json:"service_protocol,omitempty"`
+	Path            string   `json:"path,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+	// TTL removes the service after the given duration, such as "2h"
+	TTL string `json:"ttl,omitempty"`
+}
+
+// ServiceResponse describes a service registered over the API
+type ServiceResponse struct {
+	Name            string     `json:"name"`
+	Service         string     `json:"service"`
+	Destination     string     `json:"destination"`
+	Protocol        string     `json:"protocol,omitempty"`
+	ServicePort     string     `json:"service_port,omitempty"`
+	ServiceProtocol string     `json:"service_protocol,omitempty"`
+	Path            string     `json:"path,omitempty"`
+	Tags            []string   `json:"tags,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+}
+
+// ReconcileSummary describes a single reconciliation cycle
+type ReconcileSummary struct {
+	StartedAt      time.Time `json:"started_at"`
+	Duration       string    `json:"duration"`
+	Added          []string  `json:"added"`
+	Removed        []string  `json:"removed"`
+	Changed        []string  `json:"changed"`
+	FunnelsAdded   []string  `json:"funnels_added"`
+	FunnelsRemoved []string  `json:"funnels_removed"`
+	Collisions     []string  `json:"collisions"`
+	Deferred       []string  `json:"deferred"`
+	DryRun         bool      `json:"dry_run"`
+	Error          string    `json:"error,omitempty"`
+
+	// Failed maps the services that failed to apply to what went wrong
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// HistoryEvent is an entry of GET /history
+type HistoryEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Trigger string    `json:"trigger,omitempty"`
+	Service string    `json:"service,omitempty"`
+	Key     string    `json:"key,omitempty"`
+	DryRun  bool      `json:"dry_run,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Result summarizes the cycle of cycle_end events
+	Result *ReconcileSummary `json:"result,omitempty"`
+}
+
+// NewServer creates an API server. Mutating endpoints require the given bearer
+// token and are disabled when it is empty.
+func NewServer(controller Controller, token string) *Server {
+	s := &Server{
+		controller: controller,
+		token:      token,
+		mux:        http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /state", s.handleState)
+	s.mux.HandleFunc("GET /history", s.handleHistory)
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	s.mux.HandleFunc("POST /reconcile", s.requireToken(s.handleReconcile))
+	s.mux.HandleFunc("POST /pause", s.requireToken(s.handlePause))
+	s.mux.HandleFunc("POST /resume", s.requireToken(s.handleResume))
+	s.mux.HandleFunc("POST /maintenance", s.requireToken(s.handleStartMaintenance))
+	s.mux.HandleFunc("DELETE /maintenance", s.requireToken(s.handleEndMaintenance))
+	s.mux.HandleFunc("GET /config/interval", s.handleGetInterval)
+	s.mux.HandleFunc("PUT /config/interval", s.requireToken(s.handlePutInterval))
+
+	return s
+}
+
+// HandleServices serves the ad-hoc service endpoints from the given registry
+func (s *Server) HandleServices(registry ServiceRegistry) {
+	s.registry = registry
+	s.mux.HandleFunc("GET /api/services", s.handleListServices)
+	s.mux.HandleFunc("POST /api/services", s.requireToken(s.handleRegisterService))
+	s.mux.HandleFunc("DELETE /api/services/{name}", s.requireToken(s.handleUnregisterService))
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Str("addr", addr).Msg("HTTP API listening")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// requireToken rejects requests that don't carry the configured bearer token
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusForbidden, "mutating endpoints are disabled: API_TOKEN is not set")
+			return
+		}
+
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	state := s.controller.State()
+	resp := StateResponse{
+		Version:     version.Get(),
+		Interval:    state.Interval.String(),
+		DryRun:      state.DryRun,
+		Paused:      state.Paused,
+		Maintenance: state.Maintenance,
+		Node:        state.Node,
+		Aliases:     state.Aliases,
+
+		ShadowCyclesLeft: state.ShadowCyclesLeft,
+	}
+
+	if len(state.Unadvertised) > 0 {
+		resp.Services = make(map[string]string, len(state.Unadvertised))
+		for _, serviceName := range state.Unadvertised {
+			resp.Services[serviceName] = ServiceStatusUnadvertised
+		}
+	}
+
+	if len(state.Services) > 0 {
+		resp.ServiceTimes = make(map[string]ServiceTimesResponse, len(state.Services))
+		for serviceName, times := range state.Services {
+			resp.ServiceTimes[serviceName] = ServiceTimesResponse{
+				FirstAdvertised: times.FirstAdvertised.UTC(),
+				LastChanged:     times.LastChanged.UTC(),
+			}
+		}
+	}
+
+	if !state.ConfigDiff.Empty() {
+		resp.ConfigDiff = &state.ConfigDiff
+	}
+
+	for _, skipped := range state.Skipped {
+		resp.Skipped = append(resp.Skipped, SkippedContainer(skipped))
+	}
+
+	if last := state.LastReconcile; last != nil {
+		resp.LastReconcile = reconcileSummary(state.LastReconcileAt, last)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleHistory lists the recent events of the reconciler, oldest first,
+// only those of the service named by ?service= when given
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	events := s.controller.History(r.URL.Query().Get("service"))
+	resp := make([]HistoryEvent, len(events))
+	for i, event := range events {
+		resp[i] = HistoryEvent{
+			Time:    event.Time.UTC(),
+			Kind:    event.Kind,
+			Trigger: event.Trigger,
+			Service: event.Service,
+			Key:     event.Key,
+			DryRun:  event.DryRun,
+			Error:   event.Error,
+		}
+		if event.Result != nil {
+			resp[i].Result = reconcileSummary(event.Time.Add(-event.Result.Duration), event.Result)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// reconcileSummary describes the result of a cycle started at startedAt
+func reconcileSummary(startedAt time.Time, result *apptypes.ReconcileResult) *ReconcileSummary {
+	summary := &ReconcileSummary{
+		StartedAt:      startedAt,
+		Duration:       result.Duration.String(),
+		Added:          nonNil(result.Added),
+		Removed:        nonNil(result.Removed),
+		Changed:        nonNil(result.Changed),
+		FunnelsAdded:   nonNil(result.FunnelsAdded),
+		FunnelsRemoved: nonNil(result.FunnelsRemoved),
+		Collisions:     nonNil(result.Collisions),
+		Deferred:       nonNil(result.Deferred),
+		DryRun:         result.DryRun,
+		Failed:         result.Failed,
+	}
+	if result.Err != nil {
+		summary.Error = result.Err.Error()
+	}
+	return summary
+}
+
+// handleHealth reports whether a cycle succeeded within the last few
+// intervals, answering 503 otherwise
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	state := s.controller.State()
+	resp := HealthResponse{Status: "ok"}
+	if !state.LastSuccessAt.IsZero() {
+		lastSuccess := state.LastSuccessAt.UTC()
+		resp.LastSuccess = &lastSuccess
+	}
+
+	if !reconciler.Healthy(state.LastSuccessAt, state.Interval, time.Now()) {
+		resp.Status = "stale"
+		writeJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	log.Info().Str("remote_addr", r.RemoteAddr).Msg("Reconciliation requested over HTTP API")
+	s.controller.TriggerReconcile()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.controller.Pause()
+	log.Warn().Str("remote_addr", r.RemoteAddr).Msg("Reconciliation paused over HTTP API, changes are logged but not applied")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.controller.Resume()
+	log.Info().Str("remote_addr", r.RemoteAddr).Msg("Reconciliation resumed over HTTP API")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "resumed"})
+}
+
+func (s *Server) handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.controller.SetMaintenance(true)
+	log.Warn().Str("remote_addr", r.RemoteAddr).Msg("Maintenance mode started over HTTP API, service removals are deferred")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "maintenance"})
+}
+
+func (s *Server) handleEndMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.controller.SetMaintenance(false)
+	log.Info().Str("remote_addr", r.RemoteAddr).Msg("Maintenance mode ended over HTTP API, applying deferred removals")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "resumed"})
+}
+
+func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
+	services := s.registry.AdhocServices()
+	resp := make([]ServiceResponse, 0, len(services))
+	for _, svc := range services {
+		resp = append(resp, serviceResponse(svc))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleRegisterService(w http.ResponseWriter, r *http.Request) {
+	var body ServiceRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	ttl, err := docker.ParseTTL(body.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	svc, err := s.registry.RegisterService(docker.StaticService{
+		Name:            body.Name,
+		Destination:     body.Destination,
+		Protocol:        body.Protocol,
+		ServicePort:     body.ServicePort,
+		ServiceProtocol: body.ServiceProtocol,
+		Path:            body.Path,
+		Tags:            body.Tags,
+	}, ttl)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info().
+		Str("remote_addr", r.RemoteAddr).
+		Str("service", svc.ServiceName).
+		Msg("Service registered over HTTP API")
+	s.controller.TriggerReconcile()
+	writeJSON(w, http.StatusCreated, serviceResponse(svc))
+}
+
+func (s *Server) handleUnregisterService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.registry.UnregisterService(name); err != nil {
+		if errors.Is(err, docker.ErrAdhocServiceNotFound) {
+			writeError(w, http.StatusNotFound, "no service registered as "+name)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Info().
+		Str("remote_addr", r.RemoteAddr).
+		Str("name", name).
+		Msg("Service unregistered over HTTP API")
+	s.controller.TriggerReconcile()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetInterval(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, IntervalResponse{Interval: s.controller.Interval().String()})
+}
+
+func (s *Server) handlePutInterval(w http.ResponseWriter, r *http.Request) {
+	var body IntervalResponse
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	interval, err := time.ParseDuration(body.Interval)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid interval: "+err.Error())
+		return
+	}
+
+	if err := s.controller.SetInterval(interval); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info().
+		Str("remote_addr", r.RemoteAddr).
+		Dur("interval", interval).
+		Msg("Reconcile interval updated over HTTP API")
+
+	writeJSON(w, http.StatusOK, IntervalResponse{Interval: interval.String()})
+}
+
+func serviceResponse(svc docker.AdhocService) ServiceResponse {
+	return ServiceResponse{
+		Name:            svc.Name,
+		Service:         svc.ServiceName,
+		Destination:     svc.Destination,
+		Protocol:        svc.Protocol,
+		ServicePort:     svc.ServicePort,
+		ServiceProtocol: svc.ServiceProtocol,
+		Path:            svc.Path,
+		Tags:            svc.Tags,
+		ExpiresAt:       svc.ExpiresAt,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// nonNil returns an empty slice for nil so lists encode as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,515 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/reconciler"
+	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
+	"github.com/marvinvr/docktail/version"
+)
+
+type fakeController struct {
+	triggers int
+	interval time.Duration
+	state    reconciler.State
+	history  []reconciler.HistoryEvent
+}
+
+func (f *fakeController) Pause() {
+	f.state.Paused = true
+}
+
+func (f *fakeController) Resume() {
+	f.state.Paused = false
+}
+
+func (f *fakeController) SetMaintenance(on bool) {
+	f.state.Maintenance = on
+}
+
+func (f *fakeController) State() reconciler.State {
+	return f.state
+}
+
+func (f *fakeController) History(service string) []reconciler.HistoryEvent {
+	var events []reconciler.HistoryEvent
+	for _, event := range f.history {
+		if service == "" || event.Service == service {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (f *fakeController) TriggerReconcile() {
+	f.triggers++
+}
+
+func (f *fakeController) Interval() time.Duration {
+	return f.interval
+}
+
+func (f *fakeController) SetInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	f.interval = interval
+	return nil
+}
+
+func doRequest(t *testing.T, server *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestForceReconcile(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverToken  string
+		requestToken string
+		wantStatus   int
+		wantTriggers int
+	}{
+		{"valid token", "secret", "secret", http.StatusAccepted, 1},
+		{"missing token", "secret", "", http.StatusUnauthorized, 0},
+		{"wrong token", "secret", "guess", http.StatusUnauthorized, 0},
+		{"no token configured", "", "anything", http.StatusForbidden, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{}
+			server := NewServer(controller, tt.serverToken)
+
+			rec := doRequest(t, server, http.MethodPost, "/reconcile", tt.requestToken, "")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if controller.triggers != tt.wantTriggers {
+				t.Errorf("triggers = %d, want %d", controller.triggers, tt.wantTriggers)
+			}
+		})
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		paused     bool
+		wantStatus int
+		wantPaused bool
+	}{
+		{"pause", "/pause", "secret", false, http.StatusOK, true},
+		{"resume", "/resume", "secret", true, http.StatusOK, false},
+		{"pause without token", "/pause", "", false, http.StatusUnauthorized, false},
+		{"resume without token", "/resume", "", true, http.StatusUnauthorized, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{state: reconciler.State{Paused: tt.paused}}
+			server := NewServer(controller, "secret")
+
+			rec := doRequest(t, server, http.MethodPost, tt.path, tt.token, "")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if controller.state.Paused != tt.wantPaused {
+				t.Errorf("paused = %v, want %v", controller.state.Paused, tt.wantPaused)
+			}
+
+			var state StateResponse
+			if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/state", "", "").Body).Decode(&state); err != nil {
+				t.Fatalf("failed to decode state: %v", err)
+			}
+			if state.Paused != tt.wantPaused {
+				t.Errorf("state paused = %v, want %v", state.Paused, tt.wantPaused)
+			}
+		})
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		token           string
+		maintenance     bool
+		wantStatus      int
+		wantMaintenance bool
+	}{
+		{"start", http.MethodPost, "secret", false, http.StatusOK, true},
+		{"end", http.MethodDelete, "secret", true, http.StatusOK, false},
+		{"start without token", http.MethodPost, "", false, http.StatusUnauthorized, false},
+		{"end without token", http.MethodDelete, "", true, http.StatusUnauthorized, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{state: reconciler.State{Maintenance: tt.maintenance}}
+			server := NewServer(controller, "secret")
+
+			rec := doRequest(t, server, tt.method, "/maintenance", tt.token, "")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var state StateResponse
+			if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/state", "", "").Body).Decode(&state); err != nil {
+				t.Fatalf("failed to decode state: %v", err)
+			}
+			if state.Maintenance != tt.wantMaintenance {
+				t.Errorf("state maintenance = %v, want %v", state.Maintenance, tt.wantMaintenance)
+			}
+		})
+	}
+}
+
+func TestGetInterval(t *testing.T) {
+	server := NewServer(&fakeController{interval: time.Minute}, "")
+
+	rec := doRequest(t, server, http.MethodGet, "/config/interval", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var body IntervalResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body.Interval != "1m0s" {
+		t.Errorf("interval = %q, want %q", body.Interval, "1m0s")
+	}
+}
+
+func TestPutInterval(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		body         string
+		wantStatus   int
+		wantInterval time.Duration
+	}{
+		{"valid interval", "secret", `{"interval":"30s"}`, http.StatusOK, 30 * time.Second},
+		{"unparsable interval", "secret", `{"interval":"soon"}`, http.StatusBadRequest, time.Minute},
+		{"non-positive interval", "secret", `{"interval":"0s"}`, http.StatusBadRequest, time.Minute},
+		{"invalid JSON", "secret", `interval=30s`, http.StatusBadRequest, time.Minute},
+		{"missing token", "", `{"interval":"30s"}`, http.StatusUnauthorized, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{interval: time.Minute}
+			server := NewServer(controller, "secret")
+
+			rec := doRequest(t, server, http.MethodPut, "/config/interval", tt.token, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if controller.interval != tt.wantInterval {
+				t.Errorf("interval = %v, want %v", controller.interval, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestState(t *testing.T) {
+	startedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	controller := &fakeController{state: reconciler.State{
+		Interval: time.Minute,
+		Node:     tailscale.NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"},
+		LastReconcile: &apptypes.ReconcileResult{
+			Added:    []string{"svc:web:443"},
+			Duration: 25 * time.Millisecond,
+			Err:      errors.New("failed to add 1 services"),
+		},
+		LastReconcileAt: startedAt,
+		Aliases:         map[string]string{"svc:monitoring": "svc:grafana"},
+		Unadvertised:    []string{"svc:staging"},
+		Services: map[string]reconciler.ServiceTimes{
+			"svc:web": {FirstAdvertised: startedAt.Add(-time.Hour), LastChanged: startedAt},
+		},
+		ConfigDiff: tailscale.ConfigDiff{
+			Added:     []string{"svc:web"},
+			Endpoints: map[string][]tailscale.ConfigChange{"svc:web": {{Kind: tailscale.ChangeAdd, Key: "svc:web:443", To: "http://172.17.0.2:80"}}},
+		},
+		ShadowCyclesLeft: 4,
+		Skipped: []apptypes.SkippedContainer{
+			{ContainerID: "abcdef123456", ContainerName: "api", Reason: docker.SkipPortNotPublished, Error: "container port 8080 is NOT published to host"},
+		},
+	}}
+	server := NewServer(controller, "")
+
+	rec := doRequest(t, server, http.MethodGet, "/state", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var body StateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body.Node.DNSName != "docker-host.tail1234.ts.net" {
+		t.Errorf("node dns name = %q, want %q", body.Node.DNSName, "docker-host.tail1234.ts.net")
+	}
+	if body.LastReconcile == nil {
+		t.Fatal("last_reconcile missing")
+	}
+	if !body.LastReconcile.StartedAt.Equal(startedAt) || body.LastReconcile.Duration != "25ms" {
+		t.Errorf("last_reconcile timing = %v/%s, want %v/25ms", body.LastReconcile.StartedAt, body.LastReconcile.Duration, startedAt)
+	}
+	if len(body.LastReconcile.Added) != 1 || body.LastReconcile.Removed == nil {
+		t.Errorf("last_reconcile lists = %+v", body.LastReconcile)
+	}
+	if body.LastReconcile.Error != "failed to add 1 services" {
+		t.Errorf("last_reconcile error = %q", body.LastReconcile.Error)
+	}
+	if body.Aliases["svc:monitoring"] != "svc:grafana" {
+		t.Errorf("aliases = %v, want svc:monitoring marked as an alias of svc:grafana", body.Aliases)
+	}
+	if body.Services["svc:staging"] != "configured, not advertised" || len(body.Services) != 1 {
+		t.Errorf("services = %v, want svc:staging configured, not advertised", body.Services)
+	}
+	if times := body.ServiceTimes["svc:web"]; !times.FirstAdvertised.Equal(startedAt.Add(-time.Hour)) || !times.LastChanged.Equal(startedAt) {
+		t.Errorf("service_times = %+v, want svc:web first advertised an hour before its last change", body.ServiceTimes)
+	}
+	if diff := body.ConfigDiff; diff == nil || len(diff.Added) != 1 || diff.Endpoints["svc:web"][0].To != "http://172.17.0.2:80" {
+		t.Errorf("config_diff = %+v, want svc:web added", diff)
+	}
+	if body.ShadowCyclesLeft != 4 {
+		t.Errorf("shadow_cycles_left = %d, want 4", body.ShadowCyclesLeft)
+	}
+	if len(body.Skipped) != 1 || body.Skipped[0].ContainerName != "api" || body.Skipped[0].Reason != "port_not_published" {
+		t.Errorf("skipped = %+v, want api skipped for port_not_published", body.Skipped)
+	}
+}
+
+func TestStateBeforeFirstReconcile(t *testing.T) {
+	server := NewServer(&fakeController{state: reconciler.State{Interval: time.Minute}}, "")
+
+	rec := doRequest(t, server, http.MethodGet, "/state", "", "")
+	for _, field := range []string{"last_reconcile", "config_diff", "shadow_cycles_left", "skipped"} {
+		if strings.Contains(rec.Body.String(), field) {
+			t.Errorf("body %s should omit %s", rec.Body.String(), field)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	controller := &fakeController{history: []reconciler.HistoryEvent{
+		{Time: at, Kind: reconciler.EventCycleStart, Trigger: "event"},
+		{Time: at, Kind: reconciler.EventAdd, Trigger: "event", Service: "svc:web", Key: "svc:web:443"},
+		{Time: at, Kind: reconciler.EventCycleEnd, Trigger: "event", Result: &apptypes.ReconcileResult{
+			Added:    []string{"svc:web:443"},
+			Duration: 2 * time.Second,
+		}},
+	}}
+	server := NewServer(controller, "")
+
+	rec := doRequest(t, server, http.MethodGet, "/history", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var events []HistoryEvent
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("events = %+v, want 3", events)
+	}
+	if events[1].Kind != reconciler.EventAdd || events[1].Key != "svc:web:443" || events[1].Result != nil {
+		t.Errorf("event = %+v, want the addition of svc:web:443", events[1])
+	}
+	result := events[2].Result
+	if result == nil || len(result.Added) != 1 || result.Duration != "2s" || !result.StartedAt.Equal(at.Add(-2*time.Second)) {
+		t.Errorf("cycle_end result = %+v, want the summary of the cycle", result)
+	}
+
+	rec = doRequest(t, server, http.MethodGet, "/history?service=svc:web", "", "")
+	events = nil
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if len(events) != 1 || events[0].Service != "svc:web" {
+		t.Errorf("filtered events = %+v, want the svc:web event", events)
+	}
+
+	rec = doRequest(t, server, http.MethodGet, "/history?service=svc:none", "", "")
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("body = %s, want an empty list", body)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastSuccess time.Time
+		wantCode    int
+		wantStatus  string
+	}{
+		{name: "recent success", lastSuccess: time.Now().Add(-time.Minute), wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "stale", lastSuccess: time.Now().Add(-time.Hour), wantCode: http.StatusServiceUnavailable, wantStatus: "stale"},
+		{name: "no success yet", wantCode: http.StatusServiceUnavailable, wantStatus: "stale"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(&fakeController{state: reconciler.State{Interval: time.Minute, LastSuccessAt: tt.lastSuccess}}, "")
+
+			rec := doRequest(t, server, http.MethodGet, "/healthz", "", "")
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var health HealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+				t.Fatalf("failed to decode health: %v", err)
+			}
+			if health.Status != tt.wantStatus || (health.LastSuccess == nil) != tt.lastSuccess.IsZero() {
+				t.Errorf("health = %+v, want status %q", health, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStateReportsVersion(t *testing.T) {
+	oldVersion, oldCommit, oldBuildDate := version.Version, version.Commit, version.BuildDate
+	version.Version, version.Commit, version.BuildDate = "v1.2.3", "abc1234", "2026-01-02T03:04:05Z"
+	t.Cleanup(func() { version.Version, version.Commit, version.BuildDate = oldVersion, oldCommit, oldBuildDate })
+	want := version.Info{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-01-02T03:04:05Z"}
+
+	server := NewServer(&fakeController{state: reconciler.State{Interval: time.Minute}}, "")
+
+	var state StateResponse
+	if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/state", "", "").Body).Decode(&state); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	if state.Version != want {
+		t.Errorf("state version = %+v, want %+v", state.Version, want)
+	}
+
+	var vars struct {
+		BuildInfo version.Info `json:"docktail_build_info"`
+	}
+	if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/debug/vars", "", "").Body).Decode(&vars); err != nil {
+		t.Fatalf("failed to decode vars: %v", err)
+	}
+	if vars.BuildInfo != want {
+		t.Errorf("docktail_build_info = %+v, want %+v", vars.BuildInfo, want)
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	server := NewServer(&fakeController{}, "")
+
+	rec := doRequest(t, server, http.MethodGet, "/debug/vars", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(rec.Body).Decode(&vars); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Error("expvar output is missing memstats")
+	}
+}
+
+type fakeRegistry struct {
+	services map[string]docker.AdhocService
+}
+
+func (f *fakeRegistry) RegisterService(entry docker.StaticService, ttl time.Duration) (docker.AdhocService, error) {
+	if entry.Destination == "" {
+		return docker.AdhocService{}, errors.New("missing required field: destination")
+	}
+	svc := docker.AdhocService{StaticService: entry, ServiceName: entry.Name}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		svc.ExpiresAt = &expiresAt
+	}
+	f.services[entry.Name] = svc
+	return svc, nil
+}
+
+func (f *fakeRegistry) UnregisterService(name string) error {
+	if _, ok := f.services[name]; !ok {
+		return docker.ErrAdhocServiceNotFound
+	}
+	delete(f.services, name)
+	return nil
+}
+
+func (f *fakeRegistry) AdhocServices() []docker.AdhocService {
+	var services []docker.AdhocService
+	for _, svc := range f.services {
+		services = append(services, svc)
+	}
+	return services
+}
+
+func TestServices(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		body         string
+		wantStatus   int
+		wantTriggers int
+		wantServices int
+	}{
+		{"register", http.MethodPost, "/api/services", "secret", `{"name":"preview","destination":"http://10.0.0.5:3000","ttl":"2h"}`, http.StatusCreated, 1, 2},
+		{"register without token", http.MethodPost, "/api/services", "", `{"name":"preview","destination":"http://10.0.0.5:3000"}`, http.StatusUnauthorized, 0, 1},
+		{"invalid ttl", http.MethodPost, "/api/services", "secret", `{"name":"preview","destination":"http://10.0.0.5:3000","ttl":"soon"}`, http.StatusBadRequest, 0, 1},
+		{"unknown field", http.MethodPost, "/api/services", "secret", `{"name":"preview","target":"http://10.0.0.5:3000"}`, http.StatusBadRequest, 0, 1},
+		{"invalid service", http.MethodPost, "/api/services", "secret", `{"name":"preview"}`, http.StatusBadRequest, 0, 1},
+		{"unregister", http.MethodDelete, "/api/services/docs", "secret", "", http.StatusNoContent, 1, 0},
+		{"unregister unknown", http.MethodDelete, "/api/services/preview", "secret", "", http.StatusNotFound, 0, 1},
+		{"unregister without token", http.MethodDelete, "/api/services/docs", "", "", http.StatusUnauthorized, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeController{}
+			registry := &fakeRegistry{services: map[string]docker.AdhocService{
+				"docs": {StaticService: docker.StaticService{Name: "docs", Destination: "http://10.0.0.6:8080"}, ServiceName: "docs"},
+			}}
+			server := NewServer(controller, "secret")
+			server.HandleServices(registry)
+
+			rec := doRequest(t, server, tt.method, tt.path, tt.token, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if controller.triggers != tt.wantTriggers {
+				t.Errorf("triggers = %d, want %d", controller.triggers, tt.wantTriggers)
+			}
+
+			var listed []ServiceResponse
+			if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/api/services", "", "").Body).Decode(&listed); err != nil {
+				t.Fatalf("failed to decode services: %v", err)
+			}
+			if len(listed) != tt.wantServices {
+				t.Errorf("listed %d services, want %d", len(listed), tt.wantServices)
+			}
+		})
+	}
+}
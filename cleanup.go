@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marvinvr/docktail/tailscale"
+)
+
+// cleanupTimeout bounds how long `docktail cleanup` runs
+const cleanupTimeout = 5 * time.Minute
+
+// cleanupTarget is a tailscaled socket whose managed services are removed
+type cleanupTarget struct {
+	name   string // empty for TAILSCALE_SOCKET
+	client *tailscale.Client
+	plan   *tailscale.CleanupPlan
+}
+
+// runCleanup removes every service and funnel DockTail manages on its
+// tailscaled sockets, for retiring DockTail from a host. `docktail clear` is
+// an alias. It returns the exit code.
+func runCleanup(cfg *config, args []string, in io.Reader, out io.Writer) int {
+	flags := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	dryRun := flags.Bool("dry-run", false, "show what would be removed without removing it")
+	force := flags.Bool("force", false, "don't ask for confirmation")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	targets := cleanupTargets(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	services, funnels := 0, 0
+	for _, target := range targets {
+		target.client.DetectVersionMismatch(ctx)
+		plan, err := target.client.PlanCleanup(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", target.label(), err)
+			return 1
+		}
+		target.plan = plan
+		services += len(plan.Services)
+		funnels += len(plan.Funnels)
+		writeCleanupPlan(out, target, len(targets) > 1)
+	}
+
+	if services+funnels == 0 {
+		fmt.Fprintln(out, "Nothing to remove")
+		return 0
+	}
+	if *dryRun {
+		fmt.Fprintln(out, "Dry run: nothing was removed")
+		return 0
+	}
+	if !*force && !confirm(in, out, fmt.Sprintf("Remove %d service(s) and %d funnel(s)? [y/N] ", services, funnels)) {
+		fmt.Fprintln(out, "Aborted")
+		return 1
+	}
+
+	failed := false
+	for _, target := range targets {
+		if err := target.client.Cleanup(ctx, target.plan); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", target.label(), err)
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Fprintln(out, "Cleanup finished with errors")
+		return 1
+	}
+	fmt.Fprintf(out, "Removed %d service(s) and %d funnel(s)\n", services, funnels)
+	return 0
+}
+
+// cleanupTargets creates a client per tailscaled socket the way the daemon
+// does, so each finds the state DockTail kept for it
+func cleanupTargets(cfg *config) []*cleanupTarget {
+	clientConfig := tailscale.ClientConfig{
+		SocketPath:         cfg.TailscaleSocket,
+		IgnoreServiceNames: cfg.IgnoreServiceNames,
+		ServiceNamePrefix:  cfg.Docker.ServiceNamePrefix,
+		StateDir:           cfg.Docker.StateDir,
+	}
+	targets := []*cleanupTarget{{client: tailscale.NewClient(clientConfig)}}
+
+	for _, name := range cfg.socketNames() {
+		socketConfig := clientConfig
+		socketConfig.SocketPath = cfg.TailscaleSockets[name]
+		socketConfig.StateDir = ""
+		if cfg.Docker.StateDir != "" {
+			socketConfig.StateDir = filepath.Join(cfg.Docker.StateDir, name)
+		}
+		targets = append(targets, &cleanupTarget{name: name, client: tailscale.NewClient(socketConfig)})
+	}
+	return targets
+}
+
+func (t *cleanupTarget) label() string {
+	if t.name == "" {
+		return "default socket"
+	}
+	return "socket " + t.name
+}
+
+// writeCleanupPlan prints what a target's cleanup removes and keeps
+func writeCleanupPlan(w io.Writer, target *cleanupTarget, named bool) {
+	indent := "  "
+	if named {
+		fmt.Fprintf(w, "%s:\n", target.label())
+		indent = "    "
+	}
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s%s:\n", indent[2:], title)
+		for _, line := range lines {
+			fmt.Fprintf(w, "%s%s\n", indent, line)
+		}
+	}
+
+	funnels := make([]string, len(target.plan.Funnels))
+	for i, funnel := range target.plan.Funnels {
+		funnels[i] = funnel.PublicPort + "/" + funnel.Protocol
+	}
+	section("Services to remove", target.plan.Services)
+	section("Funnels to remove", funnels)
+	section("Left untouched", target.plan.Skipped)
+}
+
+// confirm asks a yes/no question, defaulting to no
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprint(out, question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeCleanupCLI is a tailscale CLI reporting two managed services, one
+// hand-created service and no funnels, and recording every other call
+const fakeCleanupCLI = `#!/bin/sh
+dir=$(dirname "$0")
+case "$*" in
+*"serve status --json")
+	printf '%s' '{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}},
+		"svc:db":{"TCP":{"5432":{"TCPForward":"172.17.0.3:5432"}}},
+		"svc:manual":{"TCP":{"443":{"HTTPS":true}},"Web":{"manual.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.9:80"}}}}}
+	}}'
+	;;
+*"status --json")
+	printf '{}'
+	;;
+version)
+	echo 1.80.0
+	;;
+*)
+	echo "$*" >> "$dir/calls.log"
+	;;
+esac
+`
+
+func TestRunCleanupRemovesOnlyManagedServices(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tailscale CLI requires a POSIX shell")
+	}
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "tailscale"), []byte(fakeCleanupCLI), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	stateDir := t.TempDir()
+	desired := `{"version":"0.0.1","services":{
+		"svc:web":{"endpoints":{"tcp:443":"http://172.17.0.2:80"}},
+		"svc:db":{"endpoints":{"tcp:5432":"tcp://172.17.0.3:5432"}}
+	}}`
+	if err := os.WriteFile(filepath.Join(stateDir, "desired-config.json"), []byte(desired), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{}
+	cfg.Docker.StateDir = stateDir
+	var out bytes.Buffer
+	if code := runCleanup(cfg, []string{"--force"}, strings.NewReader(""), &out); code != 0 {
+		t.Fatalf("runCleanup() = %d, output:\n%s", code, out.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(bin, "calls.log"))
+	if err != nil {
+		t.Fatalf("no calls recorded: %v", err)
+	}
+	want := []string{
+		"serve drain svc:db",
+		"serve clear svc:db",
+		"serve drain svc:web",
+		"serve clear svc:web",
+	}
+	if calls := strings.Split(strings.TrimSpace(string(data)), "\n"); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	for _, line := range []string{"svc:manual: not created by DockTail", "Removed 2 service(s) and 0 funnel(s)"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("output missing %q:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/reconciler"
+	"github.com/marvinvr/docktail/report"
+	"github.com/marvinvr/docktail/tailscale"
+)
+
+// defaultTailscaleSocket is where tailscaled listens unless TAILSCALE_SOCKET says otherwise
+const defaultTailscaleSocket = "/var/run/tailscale/tailscaled.sock"
+
+// config is DockTail's configuration. Every option is a command line flag, an
+// environment variable and a key of the CONFIG_FILE; a flag takes precedence
+// over the variable, which takes precedence over the file, which takes
+// precedence over the default.
+type config struct {
+	ConfigFile string
+
+	ReconcileInterval time.Duration
+	ShutdownTimeout   time.Duration
+	DryRun            bool
+	AdvertiseOnly     bool
+	MaintenanceMode   bool
+	PrefetchCerts     bool
+	RemoveGracePeriod time.Duration
+	// ShadowCycles is how many cycles log changes without applying them
+	// before DockTail starts applying them
+	ShadowCycles int
+	// ApplyMinInterval is the least time between cycles that apply changes
+	ApplyMinInterval time.Duration
+	// ReconcileRetries is how many times a failed cycle is attempted
+	ReconcileRetries int
+	// VerifyInterval is how long periodic cycles may trust the serve config
+	// DockTail applied instead of reading it back
+	VerifyInterval time.Duration
+	// LogLevel is empty when unset, so each command picks its own default
+	LogLevel string
+	// ModuleLogLevels override LogLevel for the loggers of logModules
+	ModuleLogLevels map[string]string
+	// LogFormat is empty when unset, for console output on a terminal and
+	// JSON otherwise
+	LogFormat string
+	// LogFile, if set, also receives the logs as JSON, rotated past LogMaxSize
+	// megabytes
+	LogFile              string
+	LogMaxSize           int
+	LogMaxBackups        int
+	LogMaxAge            time.Duration
+	LogMaxServicesDetail int
+	ReconcileConcurrency int
+	CollisionPolicy      string
+	ApplyMode            string
+
+	TailscaleSocket   string
+	TailscaleSockets  map[string]string // additional sockets by name
+	Tailnet           string
+	APIKey            string
+	OAuthClientID     string
+	OAuthClientSecret string
+	// IgnoreServiceNames lists services DockTail never removes
+	IgnoreServiceNames []string
+	// AllowUnmanagedOverwrite lets DockTail remove services the previous run
+	// didn't record
+	AllowUnmanagedOverwrite bool
+
+	APIAddr    string
+	APIToken   string
+	AuditLog   string
+	DumpConfig string
+	// CommandLog, if set, receives a JSON line per mutating tailscale
+	// command, and per read-only one too with CommandLogReadOnly
+	CommandLog         string
+	CommandLogReadOnly bool
+	// SentryDSN, if set, is where failures are reported
+	SentryDSN string
+	// TriggerFile, if set, requests a reconciliation whenever it is touched
+	TriggerFile string
+	// HistorySize is how many events GET /history keeps
+	HistorySize int
+
+	Docker docker.ClientConfig
+
+	// fileKeys are the keys of the options CONFIG_FILE set, by variable, so
+	// errors name the key that needs fixing
+	fileKeys map[string]string
+	// values are the options as given, by variable, for comparing reloads
+	values map[string]string
+}
+
+// configOption ties a flag to the environment variable and config file key
+// that set it when the flag isn't given
+type configOption struct {
+	env  string
+	key  string // in the config file, with a dot per nesting level; empty for none
+	kind string // what a valid value looks like, for errors
+	list bool   // comma-separated, a YAML sequence in the config file
+}
+
+// flagName derives the flag of an environment variable, e.g.
+// RECONCILE_INTERVAL becomes reconcile-interval
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// optionName names an option in errors by its variable and its flag, or by
+// its key when the config file set it
+func (cfg *config) optionName(env string) string {
+	if key, ok := cfg.fileKeys[env]; ok {
+		return fmt.Sprintf("%s in %s", key, cfg.ConfigFile)
+	}
+	return fmt.Sprintf("%s (--%s)", env, flagName(env))
+}
+
+// loadConfig reads the configuration from command line arguments and, for
+// options without a flag, the environment and then the CONFIG_FILE. Malformed
+// or invalid values and unknown config file keys are
+// errors rather than falling back to the default; like flag parsing errors,
+// they are written to output before being returned. flag.ErrHelp is returned
+// after the usage was written to output for -h and --help.
+func loadConfig(args []string, lookupEnv func(string) (string, bool), output io.Writer) (*config, error) {
+	cfg := &config{fileKeys: make(map[string]string), values: make(map[string]string)}
+	var (
+		tailscaleSockets   string
+		ignoreServiceNames string
+		defaultTags        string
+		dockerEvents       string
+		imageAllowlist     string
+		proxyMode          string
+		dockerHost         string
+	)
+
+	flags := flag.NewFlagSet("docktail", flag.ContinueOnError)
+	flags.SetOutput(output)
+	options := make(map[string]configOption)
+	add := func(option configOption) string {
+		options[flagName(option.env)] = option
+		return flagName(option.env)
+	}
+	str := func(p *string, env, key, value, usage string) {
+		flags.StringVar(p, add(configOption{env: env, key: key, kind: "a string"}), value, usage+" ($"+env+")")
+	}
+	list := func(p *string, env, key, value, usage string) {
+		flags.StringVar(p, add(configOption{env: env, key: key, kind: "a list", list: true}), value, usage+" ($"+env+")")
+	}
+	boolean := func(p *bool, env, key string, value bool, usage string) {
+		flags.BoolVar(p, add(configOption{env: env, key: key, kind: "true or false"}), value, usage+" ($"+env+")")
+	}
+	integer := func(p *int, env, key string, value int, usage string) {
+		flags.IntVar(p, add(configOption{env: env, key: key, kind: "an integer"}), value, usage+" ($"+env+")")
+	}
+	duration := func(p *time.Duration, env, key string, value time.Duration, usage string) {
+		flags.DurationVar(p, add(configOption{env: env, key: key, kind: "a duration such as 30s"}), value, usage+" ($"+env+")")
+	}
+
+	str(&cfg.ConfigFile, "CONFIG_FILE", "", "", "YAML file of further options, such as /etc/docktail/config.yaml")
+
+	duration(&cfg.ReconcileInterval, "RECONCILE_INTERVAL", "reconcile.interval", reconciler.DefaultInterval, "interval between periodic reconciliations")
+	duration(&cfg.ShutdownTimeout, "SHUTDOWN_TIMEOUT", "reconcile.shutdown_timeout", defaultShutdownTimeout, "how long the reconciler may take to stop on shutdown")
+	boolean(&cfg.DryRun, "DRY_RUN", "reconcile.dry_run", false, "log changes without applying them")
+	boolean(&cfg.AdvertiseOnly, "ADVERTISE_ONLY", "reconcile.advertise_only", false, "only advertise and unadvertise services, leaving serve config alone")
+	boolean(&cfg.MaintenanceMode, "MAINTENANCE_MODE", "reconcile.maintenance_mode", false, "add and update services but defer removals")
+	integer(&cfg.ShadowCycles, "SHADOW_CYCLES", "reconcile.shadow_cycles", 0, "successful cycles that log changes without applying them before applying starts")
+	boolean(&cfg.PrefetchCerts, "PREFETCH_CERTS", "reconcile.prefetch_certs", false, "fetch TLS certificates of new https services in the background")
+	duration(&cfg.RemoveGracePeriod, "REMOVE_GRACE_PERIOD", "reconcile.remove_grace_period", tailscale.DefaultRemoveGracePeriod, "how long services of stopped containers stay drained before removal")
+	integer(&cfg.ReconcileRetries, "RECONCILE_RETRIES", "reconcile.retries", 1, "times a failed reconciliation is attempted before waiting for the next trigger")
+	duration(&cfg.ApplyMinInterval, "APPLY_MIN_INTERVAL", "reconcile.apply_min_interval", 0, "least time between reconciliations that change services, later changes are coalesced")
+	duration(&cfg.VerifyInterval, "VERIFY_INTERVAL", "reconcile.verify_interval", 0, "how long periodic reconciliations skip reading the serve config while the desired services are unchanged, 0 reads it every time")
+	str(&cfg.LogLevel, "LOG_LEVEL", "logging.level", "", "trace, debug, info, warn or error (default info, error for subcommands)")
+	moduleLogLevels := make(map[string]*string, len(logModules))
+	for _, module := range logModules {
+		moduleLogLevels[module] = new(string)
+		str(moduleLogLevels[module], "LOG_LEVEL_"+strings.ToUpper(module), "logging.levels."+module, "", "log level of the "+module+" package (default LOG_LEVEL)")
+	}
+	str(&cfg.LogFormat, "LOG_FORMAT", "logging.format", "", "json or console (default console on a terminal, json otherwise)")
+	duration(&cfg.Docker.RepeatedErrorWindow, "LOG_REPEAT_WINDOW", "logging.repeat_window", docker.DefaultRepeatedErrorWindow, "how long repeats of the same container error are held back, 0 logs every one")
+	str(&cfg.LogFile, "LOG_FILE", "logging.file", "", "file the logs are also written to as JSON, reopened on SIGHUP")
+	integer(&cfg.LogMaxSize, "LOG_MAX_SIZE", "logging.max_size", defaultLogMaxSize, "megabytes LOG_FILE grows to before it is rotated")
+	integer(&cfg.LogMaxBackups, "LOG_MAX_BACKUPS", "logging.max_backups", defaultLogMaxBackups, "rotated log files kept, 0 keeps all")
+	duration(&cfg.LogMaxAge, "LOG_MAX_AGE", "logging.max_age", 0, "age after which rotated log files are removed, 0 keeps them")
+	integer(&cfg.LogMaxServicesDetail, "LOG_MAX_SERVICES_DETAIL", "logging.max_services_detail", tailscale.DefaultMaxServicesDetail, "services above which the desired config is logged as a summary")
+	integer(&cfg.ReconcileConcurrency, "RECONCILE_CONCURRENCY", "reconcile.concurrency", tailscale.DefaultConcurrency, "tailscale CLI calls run in parallel")
+	str(&cfg.CollisionPolicy, "SERVICE_COLLISION_POLICY", "tailscale.collision_policy", tailscale.CollisionPolicyWarn, "what to do when another node advertises a service: warn or skip")
+	str(&cfg.ApplyMode, "APPLY_MODE", "reconcile.apply_mode", tailscale.ApplyModeBulk, "how failing services are applied: bulk or per-service")
+
+	str(&cfg.TailscaleSocket, "TAILSCALE_SOCKET", "tailscale.socket", defaultTailscaleSocket, "tailscaled socket")
+	list(&tailscaleSockets, "TAILSCALE_SOCKETS", "tailscale.sockets", "", "additional tailscaled sockets as name=path, comma-separated")
+	str(&cfg.Tailnet, "TAILSCALE_TAILNET", "tailscale.tailnet", "-", "tailnet of the Tailscale API")
+	str(&cfg.APIKey, "TAILSCALE_API_KEY", "tailscale.api_key", "", "Tailscale API key, prefer the environment variable")
+	str(&cfg.OAuthClientID, "TAILSCALE_OAUTH_CLIENT_ID", "tailscale.oauth_client_id", "", "Tailscale OAuth client ID")
+	str(&cfg.OAuthClientSecret, "TAILSCALE_OAUTH_CLIENT_SECRET", "tailscale.oauth_client_secret", "", "Tailscale OAuth client secret, prefer the environment variable")
+	list(&ignoreServiceNames, "IGNORE_SERVICE_NAMES", "tailscale.ignore_service_names", "", "services never removed, comma-separated")
+	boolean(&cfg.AllowUnmanagedOverwrite, "ALLOW_UNMANAGED_OVERWRITE", "tailscale.allow_unmanaged_overwrite", false, "remove services not recorded in STATE_DIR instead of keeping them")
+
+	str(&cfg.APIAddr, "API_ADDR", "integrations.api.addr", "", "address of the HTTP API, disabled when empty")
+	str(&cfg.APIToken, "API_TOKEN", "integrations.api.token", "", "bearer token of mutating HTTP API endpoints, prefer the environment variable")
+	integer(&cfg.HistorySize, "HISTORY_SIZE", "integrations.api.history_size", reconciler.DefaultHistorySize, "recent reconciliation events kept for GET /history")
+	str(&cfg.TriggerFile, "TRIGGER_FILE", "integrations.trigger_file", "", "file whose creation or modification requests a reconciliation")
+	str(&cfg.SentryDSN, "SENTRY_DSN", "integrations.sentry.dsn", "", "DSN of a Sentry compatible error tracker failures are reported to")
+	str(&cfg.AuditLog, "AUDIT_LOG", "logging.audit_log", "", "file receiving a JSON line per applied change")
+	str(&cfg.CommandLog, "COMMAND_LOG", "logging.command_log", "", "file receiving a JSON line per tailscale command that changes the node, reopened on SIGHUP")
+	boolean(&cfg.CommandLogReadOnly, "COMMAND_LOG_READ_ONLY", "logging.command_log_read_only", false, "also record read-only tailscale commands in COMMAND_LOG")
+	str(&cfg.DumpConfig, "DUMP_CONFIG", "logging.dump_config", "", "file the desired service configuration is written to every reconciliation")
+
+	list(&defaultTags, "DEFAULT_SERVICE_TAGS", "docker.default_service_tags", "tag:container", "tags of services without docktail.service.tags, comma-separated")
+	list(&dockerEvents, "DOCKER_EVENTS", "docker.events", "", "container events that trigger a reconciliation, comma-separated")
+	list(&imageAllowlist, "IMAGE_ALLOWLIST", "docker.image_allowlist", "", "image patterns of containers DockTail manages, comma-separated")
+	str(&proxyMode, "PROXY_MODE", "docker.proxy_mode", docker.ProxyModeContainerIP, "how backends are reached: container-ip or host-port")
+	str(&cfg.Docker.ServiceNamePrefix, "SERVICE_NAME_PREFIX", "docker.service_name_prefix", "", "prefix of the services DockTail manages")
+	boolean(&cfg.Docker.NormalizeServiceNames, "NORMALIZE_SERVICE_NAMES", "docker.normalize_service_names", false, "turn invalid service names into valid ones")
+	boolean(&cfg.Docker.ReadEnvConfig, "READ_ENV_CONFIG", "docker.read_env_config", false, "read DOCKTAIL_ environment variables of containers like labels")
+	boolean(&cfg.Docker.AutoTargetPort, "AUTO_TARGET_PORT", "docker.auto_target_port", false, "use the only exposed port when docktail.service.port is missing")
+	str(&cfg.Docker.DefaultNetwork, "DOCKER_NETWORK", "docker.network", "", "network whose container IP is used as the backend")
+	str(&cfg.Docker.HostGateway, "HOST_GATEWAY", "docker.host_gateway", "", "address of the Docker host as seen by a tailscaled outside the host network, instead of localhost")
+	str(&cfg.Docker.ProxyBindAddress, "PROXY_BIND_ADDRESS", "docker.proxy_bind_address", "", "address used instead of localhost to reach ports on the Docker host")
+	boolean(&cfg.Docker.AllowDestinationOverride, "ALLOW_DESTINATION_OVERRIDE", "docker.allow_destination_override", false, "allow docktail.service.destination labels")
+	list(&dockerHost, "DOCKER_HOST", "docker.host", "", "Docker endpoints, comma-separated")
+	str(&cfg.Docker.StaticServicesFile, "STATIC_SERVICES_FILE", "docker.static_services_file", "", "file of services outside Docker")
+	str(&cfg.Docker.StateDir, "STATE_DIR", "reconcile.state_dir", "", "directory DockTail keeps state in")
+
+	flags.Usage = func() {
+		fmt.Fprintln(output, "Usage: docktail [flags]")
+		fmt.Fprintln(output, "       docktail list|validate|export|cleanup|clear|doctor|healthcheck|version [flags]")
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, "Every flag can also be set with the environment variable shown after it,")
+		fmt.Fprintln(output, "or in the CONFIG_FILE. A flag takes precedence over the variable, which")
+		fmt.Fprintln(output, "takes precedence over the file.")
+		fmt.Fprintln(output)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		fmt.Fprintln(output, err)
+		flags.Usage()
+		return nil, err
+	}
+
+	// Variables and then the config file fill in the options no flag was
+	// given for. The file is found first, since it can only be named by a
+	// flag or variable.
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if value, ok := lookupEnv("CONFIG_FILE"); ok && value != "" && !given[flagName("CONFIG_FILE")] {
+		cfg.ConfigFile = value
+	}
+	var fileValues map[string]string
+	if cfg.ConfigFile != "" {
+		var err error
+		if fileValues, err = readConfigFile(cfg.ConfigFile, options); err != nil {
+			fmt.Fprintln(output, err)
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		option := options[name]
+		if given[name] {
+			continue
+		}
+		if value, ok := lookupEnv(option.env); ok && value != "" {
+			if err := flags.Set(name, value); err != nil {
+				err = fmt.Errorf("invalid %s %q: must be %s", option.env, value, option.kind)
+				fmt.Fprintln(output, err)
+				return nil, err
+			}
+			continue
+		}
+		if value, ok := fileValues[option.env]; ok {
+			if err := flags.Set(name, value); err != nil {
+				err = fmt.Errorf("invalid %s %q in %s: must be %s", option.key, value, cfg.ConfigFile, option.kind)
+				fmt.Fprintln(output, err)
+				return nil, err
+			}
+			cfg.fileKeys[option.env] = option.key
+		}
+	}
+	flags.VisitAll(func(f *flag.Flag) { cfg.values[options[f.Name].env] = f.Value.String() })
+
+	cfg.ModuleLogLevels = make(map[string]string)
+	for module, level := range moduleLogLevels {
+		if *level != "" {
+			cfg.ModuleLogLevels[module] = *level
+		}
+	}
+	if err := cfg.parse(tailscaleSockets, ignoreServiceNames, defaultTags, dockerEvents, imageAllowlist, proxyMode, dockerHost); err != nil {
+		fmt.Fprintln(output, err)
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parse validates the options and parses those given as lists
+func (cfg *config) parse(tailscaleSockets, ignoreServiceNames, defaultTags, dockerEvents, imageAllowlist, proxyMode, dockerHost string) error {
+	var err error
+	if cfg.ReconcileInterval <= 0 {
+		return fmt.Errorf("invalid %s: must be positive, got %s", cfg.optionName("RECONCILE_INTERVAL"), cfg.ReconcileInterval)
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return fmt.Errorf("invalid %s: must be positive, got %s", cfg.optionName("SHUTDOWN_TIMEOUT"), cfg.ShutdownTimeout)
+	}
+	if cfg.RemoveGracePeriod < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %s", cfg.optionName("REMOVE_GRACE_PERIOD"), cfg.RemoveGracePeriod)
+	}
+	if cfg.ShadowCycles < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %d", cfg.optionName("SHADOW_CYCLES"), cfg.ShadowCycles)
+	}
+	if cfg.ReconcileRetries < 1 {
+		return fmt.Errorf("invalid %s: must be at least 1, got %d", cfg.optionName("RECONCILE_RETRIES"), cfg.ReconcileRetries)
+	}
+	if cfg.ApplyMinInterval < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %s", cfg.optionName("APPLY_MIN_INTERVAL"), cfg.ApplyMinInterval)
+	}
+	if cfg.VerifyInterval < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %s", cfg.optionName("VERIFY_INTERVAL"), cfg.VerifyInterval)
+	}
+	if cfg.LogMaxSize < 1 {
+		return fmt.Errorf("invalid %s: must be at least 1, got %d", cfg.optionName("LOG_MAX_SIZE"), cfg.LogMaxSize)
+	}
+	if cfg.LogMaxBackups < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %d", cfg.optionName("LOG_MAX_BACKUPS"), cfg.LogMaxBackups)
+	}
+	if cfg.LogMaxAge < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %s", cfg.optionName("LOG_MAX_AGE"), cfg.LogMaxAge)
+	}
+	if cfg.Docker.RepeatedErrorWindow < 0 {
+		return fmt.Errorf("invalid %s: must not be negative, got %s", cfg.optionName("LOG_REPEAT_WINDOW"), cfg.Docker.RepeatedErrorWindow)
+	}
+	if cfg.ReconcileConcurrency < 1 {
+		return fmt.Errorf("invalid %s: must be at least 1, got %d", cfg.optionName("RECONCILE_CONCURRENCY"), cfg.ReconcileConcurrency)
+	}
+	if cfg.HistorySize < 1 {
+		return fmt.Errorf("invalid %s: must be at least 1, got %d", cfg.optionName("HISTORY_SIZE"), cfg.HistorySize)
+	}
+	if !validLogLevel(cfg.LogLevel) {
+		return fmt.Errorf("invalid %s %q: must be trace, debug, info, warn or error", cfg.optionName("LOG_LEVEL"), cfg.LogLevel)
+	}
+	for _, module := range logModules {
+		if env := "LOG_LEVEL_" + strings.ToUpper(module); !validLogLevel(cfg.ModuleLogLevels[module]) {
+			return fmt.Errorf("invalid %s %q: must be trace, debug, info, warn or error", cfg.optionName(env), cfg.ModuleLogLevels[module])
+		}
+	}
+	switch cfg.LogFormat {
+	case "", logFormatJSON, logFormatConsole:
+	default:
+		return fmt.Errorf("invalid %s %q: must be %s or %s", cfg.optionName("LOG_FORMAT"), cfg.LogFormat, logFormatJSON, logFormatConsole)
+	}
+	if cfg.CollisionPolicy, err = tailscale.ParseCollisionPolicy(cfg.CollisionPolicy); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("SERVICE_COLLISION_POLICY"), err)
+	}
+	if cfg.ApplyMode, err = tailscale.ParseApplyMode(cfg.ApplyMode); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("APPLY_MODE"), err)
+	}
+	if cfg.TailscaleSockets, err = tailscale.ParseSockets(tailscaleSockets); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("TAILSCALE_SOCKETS"), err)
+	}
+	cfg.IgnoreServiceNames = parseServiceNames(ignoreServiceNames)
+	if cfg.SentryDSN != "" {
+		if err := report.ValidateDSN(cfg.SentryDSN); err != nil {
+			return fmt.Errorf("invalid %s: %w", cfg.optionName("SENTRY_DSN"), err)
+		}
+	}
+
+	cfg.Docker.DefaultTags = parseServiceNames(defaultTags)
+	cfg.Docker.WatchedEvents = docker.DefaultWatchedEvents
+	if dockerEvents != "" {
+		if cfg.Docker.WatchedEvents, err = docker.ParseWatchedEvents(dockerEvents); err != nil {
+			return fmt.Errorf("invalid %s: %w", cfg.optionName("DOCKER_EVENTS"), err)
+		}
+	}
+	// An invalid pattern must not silently allow every image
+	if cfg.Docker.ImageAllowlist, err = docker.ParseImageAllowlist(imageAllowlist); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("IMAGE_ALLOWLIST"), err)
+	}
+	if cfg.Docker.ProxyMode, err = docker.ParseProxyMode(proxyMode); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("PROXY_MODE"), err)
+	}
+	if cfg.Docker.ProxyBindAddress, err = docker.ParseBindAddress(cfg.Docker.ProxyBindAddress, cfg.Docker.AllowDestinationOverride); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("PROXY_BIND_ADDRESS"), err)
+	}
+	// An invalid prefix would make every service name invalid
+	if err := docker.ValidateServiceNamePrefix(cfg.Docker.ServiceNamePrefix); err != nil {
+		return fmt.Errorf("invalid %s: %w", cfg.optionName("SERVICE_NAME_PREFIX"), err)
+	}
+	cfg.Docker.Hosts = docker.ParseDockerHosts(dockerHost)
+	return nil
+}
+
+// validLogLevel reports whether value is a log level, or empty for the default
+func validLogLevel(value string) bool {
+	switch value {
+	case "", "trace", "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// moduleLogLevels returns the levels that override the log level of modules
+func (cfg *config) moduleLogLevels() map[string]zerolog.Level {
+	levels := make(map[string]zerolog.Level, len(cfg.ModuleLogLevels))
+	for module, level := range cfg.ModuleLogLevels {
+		levels[module] = parseLogLevel(level)
+	}
+	return levels
+}
+
+// logLevel returns the configured log level, or defaultLevel when it is unset
+func (cfg *config) logLevel(defaultLevel string) string {
+	if cfg.LogLevel == "" {
+		return defaultLevel
+	}
+	return cfg.LogLevel
+}
+
+// tailscaleConfig returns the configuration of the client for the default
+// tailscaled socket
+func (cfg *config) tailscaleConfig() tailscale.ClientConfig {
+	return tailscale.ClientConfig{
+		SocketPath:         cfg.TailscaleSocket,
+		Tailnet:            cfg.Tailnet,
+		APIKey:             cfg.APIKey,
+		OAuthClientID:      cfg.OAuthClientID,
+		OAuthClientSecret:  cfg.OAuthClientSecret,
+		IgnoreServiceNames: cfg.IgnoreServiceNames,
+		ServiceNamePrefix:  cfg.Docker.ServiceNamePrefix,
+		CollisionPolicy:    cfg.CollisionPolicy,
+		Concurrency:        cfg.ReconcileConcurrency,
+		StateDir:           cfg.Docker.StateDir,
+		DumpConfigPath:     cfg.DumpConfig,
+		MaxServicesDetail:  cfg.LogMaxServicesDetail,
+		AdvertiseOnly:      cfg.AdvertiseOnly,
+		PrefetchCerts:      cfg.PrefetchCerts,
+		RemoveGracePeriod:  cfg.RemoveGracePeriod,
+		CommandLogReadOnly: cfg.CommandLogReadOnly,
+
+		AllowUnmanagedOverwrite: cfg.AllowUnmanagedOverwrite,
+		ApplyMode:               cfg.ApplyMode,
+		VerifyInterval:          cfg.VerifyInterval,
+	}
+}
+
+// socketNames returns the names of the additional tailscaled sockets, sorted
+func (cfg *config) socketNames() []string {
+	names := make([]string, 0, len(cfg.TailscaleSockets))
+	for name := range cfg.TailscaleSockets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseServiceNames parses a comma-separated list such as IGNORE_SERVICE_NAMES
+func parseServiceNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(name); trimmed != "" {
+			names = append(names, trimmed)
+		}
+	}
+	return names
+}
+
+// changedOptions returns the variables of the options whose values differ
+// between two configurations, sorted
+func changedOptions(old, updated *config) []string {
+	var changed []string
+	for env, value := range updated.values {
+		if old.values[env] != value {
+			changed = append(changed, env)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/reconciler"
+	"github.com/marvinvr/docktail/tailscale"
+)
+
+// envLookup returns a lookupEnv answering from env
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, envLookup(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if cfg.ReconcileInterval != reconciler.DefaultInterval {
+		t.Errorf("ReconcileInterval = %s, want %s", cfg.ReconcileInterval, reconciler.DefaultInterval)
+	}
+	if cfg.ShutdownTimeout != defaultShutdownTimeout {
+		t.Errorf("ShutdownTimeout = %s, want %s", cfg.ShutdownTimeout, defaultShutdownTimeout)
+	}
+	if cfg.TailscaleSocket != defaultTailscaleSocket {
+		t.Errorf("TailscaleSocket = %q, want %q", cfg.TailscaleSocket, defaultTailscaleSocket)
+	}
+	if cfg.Tailnet != "-" {
+		t.Errorf("Tailnet = %q, want -", cfg.Tailnet)
+	}
+	if cfg.ReconcileConcurrency != tailscale.DefaultConcurrency {
+		t.Errorf("ReconcileConcurrency = %d, want %d", cfg.ReconcileConcurrency, tailscale.DefaultConcurrency)
+	}
+	if cfg.CollisionPolicy != tailscale.CollisionPolicyWarn {
+		t.Errorf("CollisionPolicy = %q, want %q", cfg.CollisionPolicy, tailscale.CollisionPolicyWarn)
+	}
+	if !reflect.DeepEqual(cfg.Docker.DefaultTags, []string{"tag:container"}) {
+		t.Errorf("DefaultTags = %v, want [tag:container]", cfg.Docker.DefaultTags)
+	}
+	if cfg.Docker.ProxyMode != docker.ProxyModeContainerIP {
+		t.Errorf("ProxyMode = %q, want %q", cfg.Docker.ProxyMode, docker.ProxyModeContainerIP)
+	}
+	if cfg.Docker.HostGateway != "" {
+		t.Errorf("HostGateway = %q, want none so the Docker host is localhost", cfg.Docker.HostGateway)
+	}
+	if got := cfg.logLevel("info"); got != "info" {
+		t.Errorf("logLevel() = %q, want the command default", got)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want time.Duration
+	}{
+		{name: "default", want: reconciler.DefaultInterval},
+		{name: "env", env: map[string]string{"RECONCILE_INTERVAL": "30s"}, want: 30 * time.Second},
+		{name: "empty env is unset", env: map[string]string{"RECONCILE_INTERVAL": ""}, want: reconciler.DefaultInterval},
+		{name: "flag", args: []string{"--reconcile-interval=10s"}, want: 10 * time.Second},
+		{
+			name: "flag over env",
+			args: []string{"--reconcile-interval", "10s"},
+			env:  map[string]string{"RECONCILE_INTERVAL": "30s"},
+			want: 10 * time.Second,
+		},
+		{
+			// A valid flag wins even over a malformed variable
+			name: "flag over malformed env",
+			args: []string{"-reconcile-interval=10s"},
+			env:  map[string]string{"RECONCILE_INTERVAL": "soon"},
+			want: 10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(tt.args, envLookup(tt.env), io.Discard)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if cfg.ReconcileInterval != tt.want {
+				t.Errorf("ReconcileInterval = %s, want %s", cfg.ReconcileInterval, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigParsesLists(t *testing.T) {
+	cfg, err := loadConfig([]string{"--docker-host", "tcp://a:2375, tcp://b:2375", "--dry-run"}, envLookup(map[string]string{
+		"IGNORE_SERVICE_NAMES": "web, api",
+		"TAILSCALE_SOCKETS":    "work=/run/work.sock",
+		"STATE_DIR":            "/data",
+		"DRY_RUN":              "false",
+	}), io.Discard)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if !cfg.DryRun {
+		t.Error("DryRun = false, want the flag to override DRY_RUN")
+	}
+	if want := []string{"tcp://a:2375", "tcp://b:2375"}; !reflect.DeepEqual(cfg.Docker.Hosts, want) {
+		t.Errorf("Hosts = %v, want %v", cfg.Docker.Hosts, want)
+	}
+	if want := []string{"web", "api"}; !reflect.DeepEqual(cfg.IgnoreServiceNames, want) {
+		t.Errorf("IgnoreServiceNames = %v, want %v", cfg.IgnoreServiceNames, want)
+	}
+	if want := map[string]string{"work": "/run/work.sock"}; !reflect.DeepEqual(cfg.TailscaleSockets, want) {
+		t.Errorf("TailscaleSockets = %v, want %v", cfg.TailscaleSockets, want)
+	}
+	if cfg.tailscaleConfig().StateDir != "/data" {
+		t.Errorf("tailscaleConfig().StateDir = %q, want /data", cfg.tailscaleConfig().StateDir)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "malformed duration env", env: map[string]string{"RECONCILE_INTERVAL": "soon"}, wantErr: "invalid RECONCILE_INTERVAL"},
+		{name: "malformed integer env", env: map[string]string{"RECONCILE_CONCURRENCY": "many"}, wantErr: "invalid RECONCILE_CONCURRENCY"},
+		{name: "malformed boolean env", env: map[string]string{"DRY_RUN": "yes please"}, wantErr: "invalid DRY_RUN"},
+		{name: "malformed flag", args: []string{"--shutdown-timeout=later"}, wantErr: "shutdown-timeout"},
+		{name: "unknown flag", args: []string{"--reconcile-intervall=10s"}, wantErr: "reconcile-intervall"},
+		{name: "positional argument", args: []string{"lsit"}, wantErr: `unexpected argument "lsit"`},
+		{name: "zero interval", env: map[string]string{"RECONCILE_INTERVAL": "0s"}, wantErr: "RECONCILE_INTERVAL"},
+		{name: "negative grace period", args: []string{"--remove-grace-period=-1s"}, wantErr: "REMOVE_GRACE_PERIOD"},
+		{name: "zero log size", env: map[string]string{"LOG_MAX_SIZE": "0"}, wantErr: "LOG_MAX_SIZE"},
+		{name: "negative log backups", env: map[string]string{"LOG_MAX_BACKUPS": "-1"}, wantErr: "LOG_MAX_BACKUPS"},
+		{name: "negative repeat window", env: map[string]string{"LOG_REPEAT_WINDOW": "-1m"}, wantErr: "LOG_REPEAT_WINDOW"},
+		{name: "zero concurrency", env: map[string]string{"RECONCILE_CONCURRENCY": "0"}, wantErr: "RECONCILE_CONCURRENCY"},
+		{name: "zero history size", env: map[string]string{"HISTORY_SIZE": "0"}, wantErr: "HISTORY_SIZE"},
+		{name: "negative shadow cycles", env: map[string]string{"SHADOW_CYCLES": "-1"}, wantErr: "SHADOW_CYCLES"},
+		{name: "no reconcile attempts", env: map[string]string{"RECONCILE_RETRIES": "0"}, wantErr: "RECONCILE_RETRIES"},
+		{name: "negative apply interval", env: map[string]string{"APPLY_MIN_INTERVAL": "-1s"}, wantErr: "APPLY_MIN_INTERVAL"},
+		{name: "negative verify interval", env: map[string]string{"VERIFY_INTERVAL": "-1m"}, wantErr: "VERIFY_INTERVAL"},
+		{name: "unknown log level", env: map[string]string{"LOG_LEVEL": "verbose"}, wantErr: "LOG_LEVEL"},
+		{name: "unknown collision policy", env: map[string]string{"SERVICE_COLLISION_POLICY": "panic"}, wantErr: "SERVICE_COLLISION_POLICY"},
+		{name: "remote bind address", env: map[string]string{"PROXY_BIND_ADDRESS": "192.168.1.10"}, wantErr: "PROXY_BIND_ADDRESS"},
+		{name: "unknown apply mode", env: map[string]string{"APPLY_MODE": "atomic"}, wantErr: "APPLY_MODE"},
+		{name: "unknown proxy mode", args: []string{"--proxy-mode=magic"}, wantErr: "PROXY_MODE"},
+		{name: "unknown docker event", env: map[string]string{"DOCKER_EVENTS": "start,explode"}, wantErr: "DOCKER_EVENTS"},
+		{name: "malformed sockets", env: map[string]string{"TAILSCALE_SOCKETS": "work"}, wantErr: "TAILSCALE_SOCKETS"},
+		{name: "invalid prefix", env: map[string]string{"SERVICE_NAME_PREFIX": "Bad_"}, wantErr: "SERVICE_NAME_PREFIX"},
+		{name: "sentry DSN without key", env: map[string]string{"SENTRY_DSN": "https://sentry.example.com/42"}, wantErr: "SENTRY_DSN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			_, err := loadConfig(tt.args, envLookup(tt.env), &output)
+			if err == nil {
+				t.Fatal("loadConfig() error = nil, want an error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %q, want it to contain %q", err, tt.wantErr)
+			}
+			if !strings.Contains(output.String(), tt.wantErr) {
+				t.Errorf("output = %q, want the error written", output.String())
+			}
+		})
+	}
+}
+
+func TestLoadConfigHelp(t *testing.T) {
+	var output bytes.Buffer
+	_, err := loadConfig([]string{"--help"}, envLookup(nil), &output)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("loadConfig() error = %v, want flag.ErrHelp", err)
+	}
+
+	help := output.String()
+	for _, want := range []string{"-reconcile-interval", "$RECONCILE_INTERVAL", "-docker-host", "$DOCKER_HOST", "-log-level", "$LOG_LEVEL", "precedence"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help doesn't mention %q:\n%s", want, help)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readConfigFile reads a CONFIG_FILE such as
+//
+//	reconcile:
+//	  interval: 30s
+//	docker:
+//	  image_allowlist: [ghcr.io/acme/*, nginx]
+//	tailscale:
+//	  sockets:
+//	    work: /var/run/tailscale-work/tailscaled.sock
+//
+// and returns the values it sets by environment variable, in the form the
+// variable takes. Keys that are no option are errors, so a typo doesn't
+// silently leave an option at its default.
+func readConfigFile(path string, options map[string]configOption) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var root yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&root); err != nil {
+		if errors.Is(err, io.EOF) {
+			// An empty file sets nothing
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	byKey := make(map[string]configOption, len(options))
+	for _, option := range options {
+		if option.key != "" {
+			byKey[option.key] = option
+		}
+	}
+
+	values := make(map[string]string)
+	if err := readConfigNode(path, "", root.Content[0], byKey, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// readConfigNode reads the mapping node found at prefix into values
+func readConfigNode(path, prefix string, node *yaml.Node, byKey map[string]configOption, values map[string]string) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid %s in %s at line %d: must be a mapping", configKeyName(prefix), path, node.Line)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := keyNode.Value
+		if prefix != "" {
+			key = prefix + "." + keyNode.Value
+		}
+
+		option, ok := byKey[key]
+		if !ok {
+			if !hasConfigSection(byKey, key) {
+				return fmt.Errorf("unknown key %s in %s at line %d", key, path, keyNode.Line)
+			}
+			if err := readConfigNode(path, key, valueNode, byKey, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := configValue(option, valueNode)
+		if err != nil {
+			return fmt.Errorf("invalid %s in %s at line %d: %w", key, path, valueNode.Line, err)
+		}
+		if valueNode.Tag != "!!null" {
+			values[option.env] = value
+		}
+	}
+	return nil
+}
+
+// configValue turns the value of an option into the form its variable takes.
+// Lists are YAML sequences or comma-separated strings, and the name=path
+// pairs of TAILSCALE_SOCKETS can be a mapping.
+func configValue(option configOption, node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		if !option.list {
+			return "", errors.New("must be a single value")
+		}
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("must be a list of values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	case yaml.MappingNode:
+		if option.env != "TAILSCALE_SOCKETS" {
+			return "", errors.New("must be a single value")
+		}
+		pairs := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Kind != yaml.ScalarNode {
+				return "", errors.New("must map names to socket paths")
+			}
+			pairs = append(pairs, node.Content[i].Value+"="+node.Content[i+1].Value)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", errors.New("must be a single value")
+	}
+}
+
+// hasConfigSection reports whether any option key is nested below key
+func hasConfigSection(byKey map[string]configOption, key string) bool {
+	for optionKey := range byKey {
+		if strings.HasPrefix(optionKey, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// configKeyName names a key in errors, the document for the root
+func configKeyName(key string) string {
+	if key == "" {
+		return "document"
+	}
+	return key
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+)
+
+// writeConfigFile writes a config file and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testConfigFile = `
+reconcile:
+  interval: 2m
+  dry_run: true
+  concurrency: 4
+  state_dir: /data
+docker:
+  host: [tcp://a:2375, tcp://b:2375]
+  image_allowlist: ghcr.io/acme/*, nginx
+  proxy_mode: host-port
+tailscale:
+  socket: /run/tailscale.sock
+  sockets:
+    work: /run/work.sock
+    home: /run/home.sock
+  ignore_service_names: [legacy]
+logging:
+  level: debug
+integrations:
+  api:
+    addr: 127.0.0.1:8080
+`
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+	cfg, err := loadConfig([]string{"--config-file", path}, envLookup(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if cfg.ReconcileInterval != 2*time.Minute {
+		t.Errorf("ReconcileInterval = %s, want 2m", cfg.ReconcileInterval)
+	}
+	if !cfg.DryRun {
+		t.Error("DryRun = false, want true")
+	}
+	if cfg.ReconcileConcurrency != 4 {
+		t.Errorf("ReconcileConcurrency = %d, want 4", cfg.ReconcileConcurrency)
+	}
+	if cfg.Docker.StateDir != "/data" {
+		t.Errorf("StateDir = %q, want /data", cfg.Docker.StateDir)
+	}
+	if want := []string{"tcp://a:2375", "tcp://b:2375"}; !reflect.DeepEqual(cfg.Docker.Hosts, want) {
+		t.Errorf("Hosts = %v, want %v", cfg.Docker.Hosts, want)
+	}
+	if want := []string{"ghcr.io/acme/*", "nginx"}; !reflect.DeepEqual(cfg.Docker.ImageAllowlist, want) {
+		t.Errorf("ImageAllowlist = %v, want %v", cfg.Docker.ImageAllowlist, want)
+	}
+	if cfg.Docker.ProxyMode != docker.ProxyModeHostPort {
+		t.Errorf("ProxyMode = %q, want %q", cfg.Docker.ProxyMode, docker.ProxyModeHostPort)
+	}
+	if cfg.TailscaleSocket != "/run/tailscale.sock" {
+		t.Errorf("TailscaleSocket = %q, want /run/tailscale.sock", cfg.TailscaleSocket)
+	}
+	if want := map[string]string{"work": "/run/work.sock", "home": "/run/home.sock"}; !reflect.DeepEqual(cfg.TailscaleSockets, want) {
+		t.Errorf("TailscaleSockets = %v, want %v", cfg.TailscaleSockets, want)
+	}
+	if want := []string{"legacy"}; !reflect.DeepEqual(cfg.IgnoreServiceNames, want) {
+		t.Errorf("IgnoreServiceNames = %v, want %v", cfg.IgnoreServiceNames, want)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("LogLevel = %q, want debug", cfg.LogLevel)
+	}
+	if cfg.APIAddr != "127.0.0.1:8080" {
+		t.Errorf("APIAddr = %q, want 127.0.0.1:8080", cfg.APIAddr)
+	}
+	// Options the file leaves out keep their defaults
+	if cfg.Tailnet != "-" {
+		t.Errorf("Tailnet = %q, want -", cfg.Tailnet)
+	}
+}
+
+func TestLoadConfigFilePrecedence(t *testing.T) {
+	path := writeConfigFile(t, "reconcile:\n  interval: 2m\n  concurrency: 4\nlogging:\n  level: debug\n")
+
+	tests := []struct {
+		name            string
+		args            []string
+		env             map[string]string
+		wantInterval    time.Duration
+		wantConcurrency int
+	}{
+		{
+			name:            "file",
+			env:             map[string]string{"CONFIG_FILE": path},
+			wantInterval:    2 * time.Minute,
+			wantConcurrency: 4,
+		},
+		{
+			name:            "env over file",
+			env:             map[string]string{"CONFIG_FILE": path, "RECONCILE_INTERVAL": "30s"},
+			wantInterval:    30 * time.Second,
+			wantConcurrency: 4,
+		},
+		{
+			name:            "flag over env and file",
+			args:            []string{"--reconcile-interval=10s", "--reconcile-concurrency=2"},
+			env:             map[string]string{"CONFIG_FILE": path, "RECONCILE_INTERVAL": "30s"},
+			wantInterval:    10 * time.Second,
+			wantConcurrency: 2,
+		},
+		{
+			// The flag names the file even when CONFIG_FILE is set
+			name:            "config file flag over env",
+			args:            []string{"--config-file", path},
+			env:             map[string]string{"CONFIG_FILE": "/nonexistent.yaml"},
+			wantInterval:    2 * time.Minute,
+			wantConcurrency: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(tt.args, envLookup(tt.env), io.Discard)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if cfg.ReconcileInterval != tt.wantInterval {
+				t.Errorf("ReconcileInterval = %s, want %s", cfg.ReconcileInterval, tt.wantInterval)
+			}
+			if cfg.ReconcileConcurrency != tt.wantConcurrency {
+				t.Errorf("ReconcileConcurrency = %d, want %d", cfg.ReconcileConcurrency, tt.wantConcurrency)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown key", content: "docker:\n  hots: tcp://a:2375\n", wantErr: "unknown key docker.hots"},
+		{name: "unknown section", content: "dockr:\n  host: tcp://a:2375\n", wantErr: "unknown key dockr"},
+		{name: "unknown nested key", content: "integrations:\n  api:\n    adr: :8080\n", wantErr: "unknown key integrations.api.adr"},
+		{name: "section given a value", content: "docker: tcp://a:2375\n", wantErr: "invalid docker"},
+		{name: "malformed duration", content: "reconcile:\n  interval: soon\n", wantErr: `invalid reconcile.interval "soon"`},
+		{name: "malformed boolean", content: "reconcile:\n  dry_run: yes\n", wantErr: "invalid reconcile.dry_run"},
+		{name: "list for a single value", content: "docker:\n  proxy_mode: [host-port]\n", wantErr: "invalid docker.proxy_mode"},
+		{name: "invalid value", content: "reconcile:\n  concurrency: 0\n", wantErr: "reconcile.concurrency in"},
+		{name: "invalid list entry", content: "docker:\n  events: [start, explode]\n", wantErr: "docker.events in"},
+		{name: "invalid yaml", content: "reconcile: [\n", wantErr: "invalid config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.content)
+			_, err := loadConfig([]string{"--config-file", path}, envLookup(nil), io.Discard)
+			if err == nil {
+				t.Fatal("loadConfig() error = nil, want an error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	_, err := loadConfig(nil, envLookup(map[string]string{"CONFIG_FILE": filepath.Join(t.TempDir(), "missing.yaml")}), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Errorf("loadConfig() error = %v, want a read error", err)
+	}
+}
+
+func TestLoadConfigFileEmpty(t *testing.T) {
+	path := writeConfigFile(t, "# nothing configured yet\n")
+	if _, err := loadConfig([]string{"--config-file", path}, envLookup(nil), io.Discard); err != nil {
+		t.Errorf("loadConfig() error = %v, want an empty file to be valid", err)
+	}
+}
+
+func TestChangedOptions(t *testing.T) {
+	path := writeConfigFile(t, "reconcile:\n  interval: 2m\nlogging:\n  level: debug\n")
+	old, err := loadConfig([]string{"--config-file", path}, envLookup(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("reconcile:\n  interval: 5m\n  dry_run: true\nlogging:\n  level: debug\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := loadConfig([]string{"--config-file", path}, envLookup(nil), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := changedOptions(old, updated), []string{"DRY_RUN", "RECONCILE_INTERVAL"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changedOptions() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marvinvr/docktail/atomicfile"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// adhocServicesFile is the file (inside the state directory) that keeps
+// services registered over the HTTP API across restarts
+const adhocServicesFile = "adhoc-services.json"
+
+// adhocContainerName stands in for the container name of ad-hoc services
+const adhocContainerName = "api"
+
+// AdhocService is a service registered at runtime over the HTTP API
+type AdhocService struct {
+	StaticService
+	// ExpiresAt is when the service is removed (nil = never)
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ServiceName is the resolved service name, after SERVICE_NAME_PREFIX and
+	// normalization
+	ServiceName string `json:"-"`
+}
+
+// adhocEntry is a registered service with its parsed form
+type adhocEntry struct {
+	AdhocService
+	service *apptypes.ContainerService
+}
+
+// ErrAdhocServiceNotFound is returned when unregistering an unknown service
+var ErrAdhocServiceNotFound = errors.New("ad-hoc service not found")
+
+// RegisterService validates and registers an ad-hoc service, replacing one
+// registered under the same name. A positive ttl makes it expire.
+func (c *Client) RegisterService(entry StaticService, ttl time.Duration) (AdhocService, error) {
+	svc, err := c.parseStaticService("", entry)
+	if err != nil {
+		return AdhocService{}, err
+	}
+	svc.ContainerName = adhocContainerName
+
+	adhoc := AdhocService{StaticService: entry, ServiceName: svc.ServiceName}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl).UTC()
+		adhoc.ExpiresAt = &expiresAt
+	}
+
+	c.adhocMu.Lock()
+	defer c.adhocMu.Unlock()
+	if c.adhocServices == nil {
+		c.adhocServices = make(map[string]adhocEntry)
+	}
+	c.adhocServices[entry.Name] = adhocEntry{AdhocService: adhoc, service: svc}
+	c.saveAdhocServices()
+
+	log.Info().
+		Str("name", entry.Name).
+		Str("service", svc.ServiceName).
+		Str("destination", entry.Destination).
+		Dur("ttl", ttl).
+		Msg("Registered ad-hoc service")
+	return adhoc, nil
+}
+
+// UnregisterService removes an ad-hoc service
+func (c *Client) UnregisterService(name string) error {
+	c.adhocMu.Lock()
+	defer c.adhocMu.Unlock()
+
+	if _, ok := c.adhocServices[name]; !ok {
+		return ErrAdhocServiceNotFound
+	}
+	delete(c.adhocServices, name)
+	c.saveAdhocServices()
+
+	log.Info().Str("name", name).Msg("Unregistered ad-hoc service")
+	return nil
+}
+
+// AdhocServices returns the registered ad-hoc services sorted by name
+func (c *Client) AdhocServices() []AdhocService {
+	c.adhocMu.Lock()
+	defer c.adhocMu.Unlock()
+
+	services := make([]AdhocService, 0, len(c.adhocServices))
+	for _, entry := range c.adhocServices {
+		services = append(services, entry.AdhocService)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// expireAdhocServices removes ad-hoc services whose TTL has passed
+func (c *Client) expireAdhocServices(now time.Time) {
+	c.adhocMu.Lock()
+	defer c.adhocMu.Unlock()
+
+	expired := false
+	for name, entry := range c.adhocServices {
+		if entry.ExpiresAt == nil || now.Before(*entry.ExpiresAt) {
+			continue
+		}
+		delete(c.adhocServices, name)
+		expired = true
+		log.Info().
+			Str("name", name).
+			Str("service", entry.ServiceName).
+			Time("expired_at", *entry.ExpiresAt).
+			Msg("Ad-hoc service expired, removing it")
+	}
+	if expired {
+		c.saveAdhocServices()
+	}
+}
+
+// adhocContainerServices returns the parsed ad-hoc services sorted by name
+func (c *Client) adhocContainerServices() []*apptypes.ContainerService {
+	c.adhocMu.Lock()
+	defer c.adhocMu.Unlock()
+
+	names := make([]string, 0, len(c.adhocServices))
+	for name := range c.adhocServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := make([]*apptypes.ContainerService, 0, len(names))
+	for _, name := range names {
+		services = append(services, c.adhocServices[name].service)
+	}
+	return services
+}
+
+// loadAdhocServices restores the ad-hoc services saved in the state
+// directory. Entries that expired or no longer validate are dropped.
+func (c *Client) loadAdhocServices() {
+	if c.stateDir == "" {
+		return
+	}
+
+	path := filepath.Join(c.stateDir, adhocServicesFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to read ad-hoc services")
+		return
+	}
+
+	var saved []AdhocService
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to parse ad-hoc services, ignoring them")
+		return
+	}
+
+	c.adhocMu.Lock()
+	defer c.adhocMu.Unlock()
+	c.adhocServices = make(map[string]adhocEntry, len(saved))
+	for _, adhoc := range saved {
+		svc, err := c.parseStaticService("", adhoc.StaticService)
+		if err != nil {
+			log.Warn().Err(err).Str("name", adhoc.Name).Msg("Dropping saved ad-hoc service that is no longer valid")
+			continue
+		}
+		svc.ContainerName = adhocContainerName
+		adhoc.ServiceName = svc.ServiceName
+		c.adhocServices[adhoc.Name] = adhocEntry{AdhocService: adhoc, service: svc}
+	}
+
+	log.Info().Str("path", path).Int("count", len(c.adhocServices)).Msg("Restored ad-hoc services")
+}
+
+// saveAdhocServices writes the ad-hoc services to the state directory. The
+// caller holds adhocMu.
+func (c *Client) saveAdhocServices() {
+	if c.stateDir == "" {
+		return
+	}
+
+	names := make([]string, 0, len(c.adhocServices))
+	for name := range c.adhocServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	saved := make([]AdhocService, 0, len(names))
+	for _, name := range names {
+		saved = append(saved, c.adhocServices[name].AdhocService)
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to marshal ad-hoc services")
+		return
+	}
+	path := filepath.Join(c.stateDir, adhocServicesFile)
+	if err := atomicfile.Write(path, append(data, '\n')); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to save ad-hoc services")
+	}
+}
+
+// ParseTTL parses the TTL of an ad-hoc service. An empty value never expires.
+func ParseTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q: %w", value, err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q: must be positive", value)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestAdhocServices(t *testing.T) {
+	stateDir := t.TempDir()
+	client := &Client{cli: &fakeDockerAPI{}, stateDir: stateDir}
+
+	if _, err := client.RegisterService(StaticService{Name: "Bad_Name", Destination: "http://10.0.0.1:80"}, 0); err == nil {
+		t.Fatal("RegisterService() should reject an invalid name")
+	}
+	if _, err := client.RegisterService(StaticService{Name: "preview", Destination: "http://10.0.0.5:3000"}, time.Hour); err != nil {
+		t.Fatalf("RegisterService() error = %v", err)
+	}
+	if _, err := client.RegisterService(StaticService{Name: "docs", Destination: "http://10.0.0.6:8080"}, 0); err != nil {
+		t.Fatalf("RegisterService() error = %v", err)
+	}
+
+	services := func(c *Client) map[string]string {
+		containers, err := c.GetEnabledContainers(context.Background())
+		if err != nil {
+			t.Fatalf("GetEnabledContainers() error = %v", err)
+		}
+		got := make(map[string]string)
+		for _, svc := range containers {
+			got[svc.ServiceName] = svc.Destination
+		}
+		return got
+	}
+
+	want := map[string]string{"docs": "http://10.0.0.6:8080", "preview": "http://10.0.0.5:3000"}
+	if got := services(client); !reflect.DeepEqual(got, want) {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+
+	// Services survive a restart through the state directory
+	restarted := &Client{cli: &fakeDockerAPI{}, stateDir: stateDir}
+	restarted.loadAdhocServices()
+	if got := services(restarted); !reflect.DeepEqual(got, want) {
+		t.Errorf("services after restart = %v, want %v", got, want)
+	}
+
+	// An expired service is removed on the next cycle
+	restarted.expireAdhocServices(time.Now().Add(2 * time.Hour))
+	if got, want := services(restarted), map[string]string{"docs": "http://10.0.0.6:8080"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services after expiry = %v, want %v", got, want)
+	}
+
+	if err := restarted.UnregisterService("docs"); err != nil {
+		t.Fatalf("UnregisterService() error = %v", err)
+	}
+	if err := restarted.UnregisterService("docs"); !errors.Is(err, ErrAdhocServiceNotFound) {
+		t.Errorf("UnregisterService() error = %v, want ErrAdhocServiceNotFound", err)
+	}
+	if got := restarted.AdhocServices(); len(got) != 0 {
+		t.Errorf("AdhocServices() = %v, want none", got)
+	}
+}
+
+func TestAdhocServicesYieldToContainers(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "preview",
+		apptypes.LabelTarget:  "3000",
+		apptypes.LabelDirect:  "false",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "preview", labels, map[string]string{"3000": "13000"})
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}}
+	if _, err := client.RegisterService(StaticService{Name: "preview", Destination: "http://10.0.0.5:3000"}, 0); err != nil {
+		t.Fatalf("RegisterService() error = %v", err)
+	}
+
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("GetEnabledContainers() error = %v", err)
+	}
+	if len(services) != 1 || services[0].ContainerName != "preview" {
+		t.Errorf("services = %+v, want only the container's", services)
+	}
+}
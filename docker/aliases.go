@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// parseAliases returns the validated service names from the aliases label,
+// without duplicates or the primary service name
+func (c *Client) parseAliases(containerName, primaryName string, labels map[string]string) ([]string, error) {
+	var aliases []string
+	seen := map[string]bool{primaryName: true}
+	for _, part := range strings.Split(labels[apptypes.LabelAliases], ",") {
+		alias := strings.TrimSpace(part)
+		if alias == "" {
+			continue
+		}
+		alias, err := c.resolveServiceName(containerName, apptypes.LabelAliases, alias)
+		if err != nil {
+			return nil, err
+		}
+		if seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+// aliasServices copies every endpoint of the primary service to each alias
+func aliasServices(services []*apptypes.ContainerService, primaryName string, aliases []string) []*apptypes.ContainerService {
+	var result []*apptypes.ContainerService
+	for _, alias := range aliases {
+		for _, svc := range services {
+			if svc.ServiceName != primaryName {
+				continue
+			}
+			copied := *svc
+			copied.ServiceName = alias
+			copied.AliasOf = primaryName
+			copied.FunnelEnabled = false
+			result = append(result, &copied)
+		}
+	}
+	return result
+}
+
+// resolveAliasCollisions drops aliases that collide with another container's
+// service. Primary names always win over aliases, and an alias claimed by
+// several containers goes to the container whose name sorts first, so the
+// outcome doesn't depend on the order Docker lists containers in.
+func resolveAliasCollisions(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	primaries := make(map[string]bool)
+	owners := make(map[string]string)
+	for _, svc := range services {
+		if !svc.ServiceEnabled {
+			continue
+		}
+		if svc.AliasOf == "" {
+			primaries[svc.ServiceName] = true
+			continue
+		}
+		if owner, ok := owners[svc.ServiceName]; !ok || svc.ContainerName < owner {
+			owners[svc.ServiceName] = svc.ContainerName
+		}
+	}
+
+	result := make([]*apptypes.ContainerService, 0, len(services))
+	dropped := make(map[string]bool)
+	for _, svc := range services {
+		if svc.AliasOf != "" && (primaries[svc.ServiceName] || owners[svc.ServiceName] != svc.ContainerName) {
+			key := svc.ContainerName + "/" + svc.ServiceName
+			if !dropped[key] {
+				dropped[key] = true
+				event := log.Warn().
+					Str("container", svc.ContainerName).
+					Str("alias", svc.ServiceName).
+					Str("alias_of", svc.AliasOf)
+				if primaries[svc.ServiceName] {
+					event.Msg("Alias collides with another container's service name, skipping alias")
+				} else {
+					event.Str("owner", owners[svc.ServiceName]).Msg("Alias is claimed by another container, skipping alias")
+				}
+			}
+			continue
+		}
+		result = append(result, svc)
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestParseAliases(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		normalize bool
+		expected  []string
+		wantErr   bool
+	}{
+		{"unset", "", false, nil, false},
+		{"single", "monitoring", false, []string{"monitoring"}, false},
+		{"list with spaces", " monitoring , dashboards ", false, []string{"monitoring", "dashboards"}, false},
+		{"duplicates and primary dropped", "monitoring,grafana,monitoring", false, []string{"monitoring"}, false},
+		{"invalid name", "Monitoring", false, nil, true},
+		{"invalid name normalized", "Monitoring", true, []string{"monitoring"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{normalizeServiceNames: tt.normalize}
+			got, err := client.parseAliases("grafana", "grafana", map[string]string{apptypes.LabelAliases: tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAliases(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseAliases(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseContainerAliases(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:          "true",
+		apptypes.LabelService:         "grafana",
+		apptypes.LabelTarget:          "3000",
+		apptypes.LabelServiceProtocol: "https",
+		apptypes.LabelRedirectHTTP:    "true",
+		apptypes.LabelAliases:         "monitoring",
+		apptypes.LabelDirect:          "false",
+	}
+	_, inspect := newFakeContainer("abcdef1234567890", "grafana", labels, map[string]string{"3000": "13000"})
+	client := &Client{cli: &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}}}
+
+	services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+	if err != nil {
+		t.Fatalf("parseContainer() error = %v", err)
+	}
+
+	var got []string
+	for _, svc := range services {
+		got = append(got, svc.ServiceName+":"+svc.Port+"<"+svc.AliasOf)
+	}
+	want := []string{"grafana:443<", "grafana:80<", "monitoring:443<grafana", "monitoring:80<grafana"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+	if services[2].TargetPort != services[0].TargetPort || services[2].IPAddress != services[0].IPAddress {
+		t.Error("alias should proxy to the same backend as the primary service")
+	}
+}
+
+func TestResolveAliasCollisions(t *testing.T) {
+	svc := func(container, name, aliasOf string) *apptypes.ContainerService {
+		return &apptypes.ContainerService{ContainerName: container, ServiceEnabled: true, ServiceName: name, AliasOf: aliasOf, Port: "443"}
+	}
+
+	services := []*apptypes.ContainerService{
+		svc("grafana-new", "grafana", ""),
+		svc("grafana-new", "monitoring", "grafana"),
+		svc("grafana-new", "web", "grafana"),
+		svc("web", "web", ""),
+		svc("zabbix", "dashboards", "zabbix"),
+		svc("grafana-new", "dashboards", "grafana"),
+	}
+
+	var got []string
+	for _, s := range resolveAliasCollisions(services) {
+		got = append(got, s.ContainerName+"/"+s.ServiceName)
+	}
+	// "web" is another container's primary name, and "dashboards" goes to the
+	// container whose name sorts first
+	want := []string{"grafana-new/grafana", "grafana-new/monitoring", "web/web", "grafana-new/dashboards"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveAliasCollisions() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// publishedTCPPorts returns the container ports of TCP ports published on the
+// Docker host, sorted numerically
+func publishedTCPPorts(inspect container.InspectResponse) []string {
+	seen := make(map[int]struct{})
+	add := func(port nat.Port, bindings []nat.PortBinding) {
+		if port.Proto() != "tcp" || len(bindings) == 0 {
+			return
+		}
+		if n := port.Int(); n > 0 {
+			seen[n] = struct{}{}
+		}
+	}
+
+	if inspect.HostConfig != nil {
+		for port, bindings := range inspect.HostConfig.PortBindings {
+			add(port, bindings)
+		}
+	}
+	if inspect.NetworkSettings != nil {
+		for port, bindings := range inspect.NetworkSettings.Ports {
+			add(port, bindings)
+		}
+	}
+
+	numbers := make([]int, 0, len(seen))
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	ports := make([]string, len(numbers))
+	for i, n := range numbers {
+		ports[i] = strconv.Itoa(n)
+	}
+	return ports
+}
+
+// parseAllPorts serves the published ports of a container that sets
+// docktail.service.all-ports under the primary service name. Ports already
+// proxied by the primary or an indexed service are skipped. The others get
+// service ports counting up from the primary's, in container port order, so
+// each cycle assigns the same ports.
+func (c *Client) parseAllPorts(cctx *containerCtx, primary *apptypes.ContainerService, parsed []*apptypes.ContainerService) []*apptypes.ContainerService {
+	if primary.Destination != "" {
+		log.Warn().
+			Str("container", cctx.containerName).
+			Msg("all-ports cannot be combined with a destination override, ignoring it")
+		return nil
+	}
+
+	ports := publishedTCPPorts(cctx.inspect)
+	if len(ports) == 0 {
+		log.Warn().
+			Str("container", cctx.containerName).
+			Msg("all-ports is set but the container publishes no TCP ports")
+		return nil
+	}
+
+	proxied := make(map[string]struct{})
+	used := make(map[string]struct{})
+	for _, svc := range parsed {
+		if svc.IPAddress != "" {
+			proxied[svc.IPAddress+":"+svc.TargetPort] = struct{}{}
+		}
+		if svc.ServiceName == primary.ServiceName {
+			used[svc.Port] = struct{}{}
+		}
+	}
+
+	next, _ := strconv.Atoi(primary.Port)
+	var services []*apptypes.ContainerService
+	for _, targetPort := range ports {
+		destIP, destPort, err := c.resolveDestPort(cctx, targetPort)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("container", cctx.containerName).
+				Str("target_port", targetPort).
+				Msg("Failed to resolve destination for published port, skipping")
+			continue
+		}
+		if _, ok := proxied[destIP+":"+destPort]; ok {
+			continue
+		}
+
+		for {
+			next++
+			if _, ok := used[strconv.Itoa(next)]; !ok {
+				break
+			}
+		}
+		if next > 65535 {
+			log.Warn().
+				Str("container", cctx.containerName).
+				Str("service", primary.ServiceName).
+				Str("target_port", targetPort).
+				Msg("No service ports left for published port, skipping")
+			break
+		}
+		servicePort := strconv.Itoa(next)
+		used[servicePort] = struct{}{}
+
+		protocol, _, serviceProtocol, err := resolveProtocols(cctx.containerID, targetPort, servicePort, "", "")
+		if err != nil {
+			continue
+		}
+
+		services = append(services, &apptypes.ContainerService{
+			ContainerID:     cctx.containerID[:12],
+			ContainerName:   cctx.containerName,
+			ServiceEnabled:  true,
+			ServiceName:     primary.ServiceName,
+			Port:            servicePort,
+			TargetPort:      destPort,
+			ServiceProtocol: serviceProtocol,
+			Protocol:        protocol,
+			Tags:            cctx.tags,
+			IPAddress:       destIP,
+			Socket:          cctx.socket,
+			Unadvertised:    cctx.unadvertised,
+		})
+
+		log.Info().
+			Str("container", cctx.containerName).
+			Str("service", primary.ServiceName).
+			Str("target_port", targetPort).
+			Str("service_port", servicePort).
+			Str("protocol", protocol).
+			Msg("Parsed published port for all-ports")
+	}
+	return services
+}
@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestParseContainerAllPorts(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:   "true",
+		apptypes.LabelService:  "db",
+		apptypes.LabelAllPorts: "true",
+		apptypes.LabelDirect:   "false",
+		// The indexed service already serves 9000, so all-ports leaves it out
+		"docktail.service.1.name": "metrics",
+		"docktail.service.1.port": "9000",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "db", labels, map[string]string{
+		"443":  "10443",
+		"8080": "18080",
+		"5432": "15432",
+		"9000": "19000",
+	})
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}}
+
+	type endpoint struct {
+		Service, Port, ServiceProtocol, Protocol, Target string
+	}
+	want := []endpoint{
+		{"db", "80", "http", "https", "10443"},
+		{"metrics", "80", "http", "http", "19000"},
+		{"db", "81", "http", "http", "15432"},
+		{"db", "82", "http", "http", "18080"},
+	}
+
+	// Every cycle assigns the same service ports
+	for cycle := 0; cycle < 2; cycle++ {
+		services, err := client.parseContainer(context.Background(), summary.ID, labels)
+		if err != nil {
+			t.Fatalf("parseContainer() error = %v", err)
+		}
+		var got []endpoint
+		for _, svc := range services {
+			got = append(got, endpoint{svc.ServiceName, svc.Port, svc.ServiceProtocol, svc.Protocol, svc.TargetPort})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("cycle %d: services = %+v, want %+v", cycle, got, want)
+		}
+	}
+}
+
+func TestParseContainerAllPortsOnlyPrimaryPublished(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:   "true",
+		apptypes.LabelService:  "web",
+		apptypes.LabelTarget:   "8080",
+		apptypes.LabelAllPorts: "true",
+		apptypes.LabelDirect:   "false",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}}
+
+	services, err := client.parseContainer(context.Background(), summary.ID, labels)
+	if err != nil {
+		t.Fatalf("parseContainer() error = %v", err)
+	}
+	if len(services) != 1 || services[0].Port != "80" {
+		t.Errorf("services = %+v, want only the primary service", services)
+	}
+}
@@ -2,116 +2,472 @@ package docker
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net"
+	"net/url"
 	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
-	"github.com/rs/zerolog/log"
 
 	apptypes "github.com/marvinvr/docktail/types"
 )
 
-// indexedPortRegex matches labels like "docktail.service.1.port", "docktail.service.2.port", etc.
-// Each indexed entry defines a separate Tailscale service (requires docktail.service.N.name).
-var indexedPortRegex = regexp.MustCompile(`^docktail\.service\.(\d+)\.port$`)
+// indexedPortRegex matches the labels that define an indexed service, like
+// "docktail.service.1.port" or "docktail.service.2.serve-text"
+var indexedPortRegex = regexp.MustCompile(`^docktail\.service\.(\d+)\.(?:port|serve-text|serve-file)$`)
 
 // containerCtx holds shared container context used across multi-port parsing.
 type containerCtx struct {
 	containerID      string
 	containerName    string
 	specifiedNetwork string
+	socket           string // named tailscaled socket, empty for the default
+	unadvertised     bool   // docktail.service.advertise=false
+	allPorts         bool   // docktail.service.all-ports=true
 	inspect          container.InspectResponse
 	tags             []string
 	destIP           string
 	isHostNetwork    bool
 	isNoNetwork      bool
 	isDirectMode     bool
+	hostAddress      string // address of the Docker host, replaces localhost
+	hostSource       string // where hostAddress came from, for logging
+}
+
+// dockerHost returns the address of the Docker host for this container and its source
+func (cctx *containerCtx) dockerHost() (string, string) {
+	if cctx.hostAddress == "" {
+		return "localhost", hostSourceLocalhost
+	}
+	return cctx.hostAddress, cctx.hostSource
+}
+
+// dockerAPI is the subset of the Docker SDK client used by Client
+type dockerAPI interface {
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+	Ping(ctx context.Context) (types.Ping, error)
+	Close() error
 }
 
 // Client wraps the Docker client with our business logic
 type Client struct {
-	cli         *client.Client
-	defaultTags []string
+	cli                   dockerAPI
+	endpoint              string // Docker endpoint when watching several hosts
+	remoteAddress         string // host name of a remote endpoint, replaces localhost
+	hosts                 []*Client
+	defaultTags           []string
+	normalizeServiceNames bool
+	serviceNamePrefix     string
+	readEnvConfig         bool
+	autoTargetPort        bool // AUTO_TARGET_PORT
+	watchedEvents         []string
+	imageAllowlist        []string
+	proxyMode             string
+	defaultNetwork        string
+	hostGateway           string
+	// bindAddress replaces localhost as the address of the Docker host
+	// (PROXY_BIND_ADDRESS)
+	bindAddress string
+	// allowDestinationOverride enables the docktail.service.destination label
+	allowDestinationOverride bool
+	lookupHost               func(ctx context.Context, host string) ([]string, error)
+	// offline skips connecting to backends, for containers that don't run
+	offline bool
+
+	// errorLog holds back repeats of the same container error
+	errorLog *errorLog
+
+	gatewayMu   sync.Mutex
+	gatewayAddr string // resolved host gateway, cached after the first success
+
+	// warned holds the warnings already logged about a container, such as a
+	// port published on a random host port, so they aren't repeated every cycle
+	warnedMu sync.Mutex
+	warned   map[string]bool
+
+	// staticServicesFile is the STATIC_SERVICES_FILE merged into the container
+	// services. Only the top-level client of several hosts loads it.
+	staticServicesFile string
+	staticMu           sync.RWMutex
+	staticServices     []*apptypes.ContainerService
+
+	// stateDir keeps the ad-hoc services registered over the HTTP API
+	stateDir      string
+	adhocMu       sync.Mutex
+	adhocServices map[string]adhocEntry // by the name they were registered under
+
+	// skips holds the containers skipped by the discovery in progress, and
+	// lastSkips those of the last discovery
+	skipsMu   sync.Mutex
+	skips     []apptypes.SkippedContainer
+	lastSkips []apptypes.SkippedContainer
+}
+
+// ClientConfig holds configuration for creating a Docker client
+type ClientConfig struct {
+	DefaultTags []string
+	// NormalizeServiceNames rewrites invalid service names instead of rejecting the container
+	NormalizeServiceNames bool
+	// ReadEnvConfig also reads DockTail settings from container environment
+	// variables, such as DOCKTAIL_SERVICE_NAME, when the label is not set
+	ReadEnvConfig bool
+	// AutoTargetPort uses the only port a container exposes when the container
+	// port label is missing
+	AutoTargetPort bool
+	// ServiceNamePrefix is prepended to every service name, namespacing the
+	// services of this host (SERVICE_NAME_PREFIX)
+	ServiceNamePrefix string
+	// WatchedEvents replaces the default container events that trigger reconciliation
+	WatchedEvents []string
+	// ImageAllowlist restricts management to containers whose image matches one of
+	// these globs (empty = all images)
+	ImageAllowlist []string
+	// ProxyMode selects how backends are reached when a container doesn't set
+	// docktail.service.direct (default: ProxyModeContainerIP)
+	ProxyMode string
+	// DefaultNetwork is the Docker network used for container IPs when a container
+	// doesn't set docktail.service.network (default: bridge or first available)
+	DefaultNetwork string
+	// HostGateway, if set, is the host name or IP of the Docker host as seen
+	// by a tailscaled outside the host network, used instead of localhost. A
+	// name that doesn't resolve falls back to the bridge gateway.
+	HostGateway string
+	// AllowDestinationOverride enables the docktail.service.destination label,
+	// which lets containers proxy their service to arbitrary addresses
+	AllowDestinationOverride bool
+	// ProxyBindAddress replaces localhost in destinations on the Docker host,
+	// such as a loopback alias on a multi-homed host (see ParseBindAddress)
+	ProxyBindAddress string
+	// Hosts lists the Docker endpoints to discover containers on. With more than
+	// one, a client is created per host and their containers are aggregated;
+	// otherwise the daemon is configured from the environment.
+	Hosts []string
+	// StaticServicesFile is a JSON or YAML file of services that don't run in Docker
+	StaticServicesFile string
+	// StateDir is where ad-hoc services are saved across restarts. Without it
+	// they only live in memory.
+	StateDir string
+	// RepeatedErrorWindow is how long repeats of the same container error are
+	// suppressed before a summary is logged. Zero logs every occurrence.
+	RepeatedErrorWindow time.Duration
 }
 
 // NewClient creates a new Docker client
-func NewClient(defaultTags []string) (*Client, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+func NewClient(cfg ClientConfig) (*Client, error) {
+	if len(cfg.Hosts) <= 1 {
+		opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+		// The host may come from the --docker-host flag rather than DOCKER_HOST
+		if len(cfg.Hosts) == 1 {
+			opts = append(opts, client.WithHost(cfg.Hosts[0]))
+		}
+		cli, err := client.NewClientWithOpts(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Docker client: %w", err)
+		}
+		return withStaticServices(newHostClient(cfg, cli, ""), cfg)
 	}
 
-	return &Client{cli: cli, defaultTags: defaultTags}, nil
+	// The top-level client validates static services like a host client would
+	multi := &Client{
+		defaultTags:           cfg.DefaultTags,
+		normalizeServiceNames: cfg.NormalizeServiceNames,
+		serviceNamePrefix:     cfg.ServiceNamePrefix,
+	}
+	for _, endpoint := range cfg.Hosts {
+		// FromEnv would parse the whole DOCKER_HOST list, so only take the TLS
+		// and API version settings from the environment
+		cli, err := client.NewClientWithOpts(
+			client.WithTLSClientConfigFromEnv(),
+			client.WithVersionFromEnv(),
+			client.WithHost(endpoint),
+			client.WithAPIVersionNegotiation(),
+		)
+		if err != nil {
+			_ = multi.closeHosts()
+			return nil, fmt.Errorf("failed to create Docker client for %s: %w", endpoint, err)
+		}
+		multi.hosts = append(multi.hosts, newHostClient(cfg, cli, endpoint))
+	}
+	return withStaticServices(multi, cfg)
+}
+
+// withStaticServices loads the static services file and the saved ad-hoc
+// services into a new client. An invalid static services file is fatal at
+// startup, unlike on reload.
+func withStaticServices(c *Client, cfg ClientConfig) (*Client, error) {
+	c.staticServicesFile = cfg.StaticServicesFile
+	if err := c.ReloadStaticServices(); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	c.stateDir = cfg.StateDir
+	c.loadAdhocServices()
+	return c, nil
+}
+
+// Proxy modes control how DockTail reaches container backends by default
+const (
+	// ProxyModeContainerIP proxies to the container's IP and container port, so no
+	// ports need to be published. DockTail must share a Docker network with the
+	// container (or run with host networking on the Docker host).
+	ProxyModeContainerIP = "container-ip"
+	// ProxyModeHostPort proxies to localhost and the published host port
+	ProxyModeHostPort = "host-port"
+)
+
+// ParseProxyMode validates a PROXY_MODE value
+func ParseProxyMode(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case ProxyModeContainerIP, ProxyModeHostPort:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown proxy mode %q: must be %s or %s", value, ProxyModeContainerIP, ProxyModeHostPort)
+	}
 }
 
 // Close closes the Docker client
 func (c *Client) Close() error {
+	if len(c.hosts) > 0 {
+		return c.closeHosts()
+	}
 	return c.cli.Close()
 }
 
+// DefaultWatchedEvents are the container events that trigger reconciliation by default
+var DefaultWatchedEvents = []string{"start", "stop", "die", "restart", "pause", "unpause", "destroy"}
+
+// knownContainerEvents lists the Docker container events that may be watched
+var knownContainerEvents = map[string]bool{
+	"attach": true, "commit": true, "copy": true, "create": true, "destroy": true,
+	"detach": true, "die": true, "exec_create": true, "exec_detach": true,
+	"exec_die": true, "exec_start": true, "export": true, "health_status": true,
+	"kill": true, "oom": true, "pause": true, "rename": true, "resize": true,
+	"restart": true, "start": true, "stop": true, "top": true, "unpause": true,
+	"update": true,
+}
+
+// ParseWatchedEvents parses a comma-separated list of container event names,
+// validating each against the known Docker container events
+func ParseWatchedEvents(value string) ([]string, error) {
+	var parsed []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		name := strings.TrimSpace(part)
+		if name == "" || seen[name] {
+			continue
+		}
+		if !knownContainerEvents[name] {
+			return nil, fmt.Errorf("unknown Docker container event: %q", name)
+		}
+		seen[name] = true
+		parsed = append(parsed, name)
+	}
+
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no Docker container events specified")
+	}
+
+	return parsed, nil
+}
+
+// eventFilters builds the Docker event filter for the watched container events
+func (c *Client) eventFilters() filters.Args {
+	watched := c.watchedEvents
+	if len(watched) == 0 {
+		watched = DefaultWatchedEvents
+	}
+
+	args := filters.NewArgs(filters.Arg("type", "container"))
+	for _, event := range watched {
+		args.Add("event", event)
+	}
+	return args
+}
+
 // WatchEvents streams Docker container events
 func (c *Client) WatchEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	if len(c.hosts) > 0 {
+		return c.watchHostEvents(ctx)
+	}
+
 	eventsChan, errChan := c.cli.Events(ctx, events.ListOptions{
-		Filters: filters.NewArgs(
-			filters.Arg("type", "container"),
-			filters.Arg("event", "start"),
-			filters.Arg("event", "stop"),
-			filters.Arg("event", "die"),
-			filters.Arg("event", "restart"),
-		),
+		Filters: c.eventFilters(),
 	})
 
 	return eventsChan, errChan
 }
 
 func isServiceEnabled(labels map[string]string) bool {
-	return labels[apptypes.LabelEnable] == "true"
+	return boolLabel(labels, apptypes.LabelEnable, false)
 }
 
 func isFunnelEnabled(labels map[string]string) bool {
-	return labels[apptypes.LabelFunnelEnable] == "true"
+	return boolLabel(labels, apptypes.LabelFunnelEnable, false)
 }
 
 func isManagedContainer(labels map[string]string) bool {
 	return isServiceEnabled(labels) || isFunnelEnabled(labels)
 }
 
+// ContainerError is a managed container that was skipped because its labels
+// could not be parsed
+type ContainerError struct {
+	ContainerID   string
+	ContainerName string
+	DockerHost    string // Docker endpoint when several hosts are watched
+	Err           error
+}
+
+// Discovery is the result of discovering the managed containers
+type Discovery struct {
+	Services []*apptypes.ContainerService
+	Errors   []ContainerError
+	// Skipped lists every container left out, including those of Errors
+	Skipped []apptypes.SkippedContainer
+}
+
 // GetEnabledContainers returns all running containers managed by DockTail.
 // A container can be managed by a Tailscale service, a funnel, or both.
 func (c *Client) GetEnabledContainers(ctx context.Context) ([]*apptypes.ContainerService, error) {
+	discovery, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return discovery.Services, nil
+}
+
+// Discover returns the services of the managed containers along with the
+// containers that were skipped because they could not be parsed
+func (c *Client) Discover(ctx context.Context) (Discovery, error) {
+	var discovery Discovery
+	var err error
+	if len(c.hosts) > 0 {
+		discovery, err = c.discoverHosts(ctx)
+	} else {
+		discovery, err = c.discover(ctx)
+	}
+	if err != nil {
+		return Discovery{}, err
+	}
+
+	c.expireAdhocServices(time.Now())
+	services := c.mergeStaticServices(discovery.Services)
+	discovery.Services = resolveEndpointOwners(resolveDuplicatePrimaries(resolveAliasCollisions(services)))
+
+	c.skipsMu.Lock()
+	c.lastSkips = discovery.Skipped
+	c.skipsMu.Unlock()
+	return discovery, nil
+}
+
+// discover parses the managed containers on this client's Docker host. Their
+// services are tagged with the host when several are watched.
+func (c *Client) discover(ctx context.Context) (Discovery, error) {
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list containers: %w", err)
+		return Discovery{}, fmt.Errorf("failed to list containers: %w", err)
 	}
 
-	var services []*apptypes.ContainerService
+	since := c.errorLog.cycleStart()
+	c.takeSkips()
+	var discovery Discovery
 	for _, cont := range containers {
-		if !isManagedContainer(cont.Labels) {
+		name := summaryName(cont)
+		labels, err := c.containerLabels(ctx, cont.ID, cont.Labels)
+		if err != nil {
+			c.skipContainer(cont.ID, name, SkipEnvError, err)
+			if isManagedContainer(cont.Labels) {
+				discovery.Errors = append(discovery.Errors, c.containerError(cont.ID, name, err))
+			}
+			continue
+		}
+		warnInvalidBoolLabels(name, labels)
+
+		if !isManagedContainer(labels) {
+			if hasDocktailLabels(labels) {
+				c.skipContainer(cont.ID, name, SkipDisabled, fmt.Errorf("neither %s nor %s is true", apptypes.LabelEnable, apptypes.LabelFunnelEnable))
+			}
 			continue
 		}
 
-		parsed, err := c.parseContainer(ctx, cont.ID, cont.Labels)
+		parsed, err := c.parseContainerSafely(ctx, cont.ID, labels)
 		if err != nil {
-			log.Warn().
-				Err(err).
-				Str("container_id", cont.ID[:12]).
-				Str("container_name", strings.TrimPrefix(cont.Names[0], "/")).
-				Msg("Failed to parse container, skipping")
+			parseErrors.Add(1)
+			c.skipContainer(cont.ID, name, skipReason(err), err)
+			discovery.Errors = append(discovery.Errors, c.containerError(cont.ID, name, err))
 			continue
 		}
-		services = append(services, parsed...)
+		for _, svc := range parsed {
+			svc.DockerHost = c.endpoint
+		}
+		discovery.Services = append(discovery.Services, parsed...)
 	}
 
-	return services, nil
+	discovery.Skipped = c.takeSkips()
+	c.errorLog.resolve(since)
+	return discovery, nil
+}
+
+func (c *Client) containerError(containerID, name string, err error) ContainerError {
+	return ContainerError{
+		ContainerID:   shortID(containerID),
+		ContainerName: name,
+		DockerHost:    c.endpoint,
+		Err:           err,
+	}
+}
+
+// parseErrors counts containers skipped because they could not be parsed
+var parseErrors = expvar.NewInt("docktail_parse_errors_total")
+
+// parseContainerSafely runs parseContainer, turning a panic caused by one
+// container's unexpected state into an error so the other containers are still
+// discovered
+func (c *Client) parseContainerSafely(ctx context.Context, containerID string, labels map[string]string) (services []*apptypes.ContainerService, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().
+				Str("container_id", shortID(containerID)).
+				Str("panic", fmt.Sprint(r)).
+				Str("stack", string(debug.Stack())).
+				Msg("Recovered from panic while parsing container")
+			services, err = nil, fmt.Errorf("panic while parsing container: %v", r)
+		}
+	}()
+	return c.parseContainer(ctx, containerID, labels)
+}
+
+// summaryName returns a container's name from a list entry, or its short ID
+// when Docker reports no name
+func summaryName(cont container.Summary) string {
+	if len(cont.Names) == 0 {
+		return shortID(cont.ID)
+	}
+	return strings.TrimPrefix(cont.Names[0], "/")
+}
+
+// shortID returns the 12-character short form of a container ID
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
 }
 
 // resolveProtocols applies smart defaults for container protocol, service port, and service protocol.
@@ -126,7 +482,7 @@ func resolveProtocols(containerID, targetPort, servicePort, serviceProtocol, pro
 			protocol = "http"
 		}
 		log.Debug().
-			Str("container", containerID[:12]).
+			Str("container", shortID(containerID)).
 			Str("container_port", targetPort).
 			Str("defaulted_protocol", protocol).
 			Msg("Container protocol not specified, defaulted based on container port")
@@ -150,14 +506,14 @@ func resolveProtocols(containerID, targetPort, servicePort, serviceProtocol, pro
 			servicePort = "80"
 			serviceProtocol = protocol
 			log.Debug().
-				Str("container", containerID[:12]).
+				Str("container", shortID(containerID)).
 				Str("backend_protocol", protocol).
 				Msg("No port or service protocol specified, defaulting to TCP on port 80 to match backend")
 		} else {
 			servicePort = "80"
 			serviceProtocol = "http"
 			log.Debug().
-				Str("container", containerID[:12]).
+				Str("container", shortID(containerID)).
 				Msg("No port or protocol specified, defaulting to HTTP on port 80")
 		}
 	} else if servicePort == "" && serviceProtocol != "" {
@@ -168,7 +524,7 @@ func resolveProtocols(containerID, targetPort, servicePort, serviceProtocol, pro
 			servicePort = "80"
 		}
 		log.Debug().
-			Str("container", containerID[:12]).
+			Str("container", shortID(containerID)).
 			Str("service_protocol", serviceProtocol).
 			Str("defaulted_service_port", servicePort).
 			Msg("Service port not specified, defaulted based on protocol")
@@ -176,7 +532,7 @@ func resolveProtocols(containerID, targetPort, servicePort, serviceProtocol, pro
 		if protocol == "tcp" || protocol == "tls-terminated-tcp" {
 			serviceProtocol = protocol
 			log.Debug().
-				Str("container", containerID[:12]).
+				Str("container", shortID(containerID)).
 				Str("service_port", servicePort).
 				Str("backend_protocol", protocol).
 				Str("defaulted_service_protocol", serviceProtocol).
@@ -191,7 +547,7 @@ func resolveProtocols(containerID, targetPort, servicePort, serviceProtocol, pro
 				serviceProtocol = "http"
 			}
 			log.Debug().
-				Str("container", containerID[:12]).
+				Str("container", shortID(containerID)).
 				Str("service_port", servicePort).
 				Str("defaulted_service_protocol", serviceProtocol).
 				Msg("Service protocol not specified, defaulted based on port")
@@ -212,15 +568,46 @@ func resolveProtocols(containerID, targetPort, servicePort, serviceProtocol, pro
 	return protocol, servicePort, serviceProtocol, nil
 }
 
+// resolveInsecureSkipVerify reports whether backend TLS verification should be skipped.
+// The option only applies to https backends and is ignored with a warning otherwise.
+// Either warning is logged once per service rather than every cycle.
+func (c *Client) resolveInsecureSkipVerify(containerName, serviceName, protocol, value string) bool {
+	if enabled, err := parseBool(value); err != nil || !enabled {
+		return false
+	}
+
+	if protocol != "https" {
+		if c.firstWarning("insecure-skip-verify-ignored:" + containerName + ":" + serviceName) {
+			log.Warn().
+				Str("container", containerName).
+				Str("service", serviceName).
+				Str("protocol", protocol).
+				Msg("insecure-skip-verify only applies to https backends, ignoring")
+		}
+		return false
+	}
+
+	if c.firstWarning("insecure-skip-verify:" + containerName + ":" + serviceName) {
+		log.Warn().
+			Str("container", containerName).
+			Str("service", serviceName).
+			Msg("Backend TLS certificate verification is DISABLED (insecure-skip-verify=true)")
+	}
+	return true
+}
+
 // resolveDestPort determines the destination IP and port based on networking mode.
 // Returns (destIP, destPort, error).
 func (c *Client) resolveDestPort(cctx *containerCtx, targetPort string) (string, string, error) {
 	if cctx.isHostNetwork {
+		host, source := cctx.dockerHost()
 		log.Info().
 			Str("container", cctx.containerName).
 			Str("port", targetPort).
-			Msg("Container uses host networking, port is directly accessible on localhost")
-		return "localhost", targetPort, nil
+			Str("host_address", host).
+			Str("host_address_source", source).
+			Msg("Container uses host networking, port is directly accessible on the Docker host")
+		return host, targetPort, nil
 	}
 
 	if cctx.isDirectMode {
@@ -230,7 +617,8 @@ func (c *Client) resolveDestPort(cctx *containerCtx, targetPort string) (string,
 
 		containerIP, networkName, err := c.getContainerIP(cctx.inspect, cctx.specifiedNetwork, cctx.containerName)
 		if err != nil {
-			return "", "", err
+			return "", "", fmt.Errorf("%w (container-ip mode requires DockTail to reach the container over a shared Docker network; "+
+				"set docktail.service.direct=false or PROXY_MODE=host-port to use published ports instead)", err)
 		}
 
 		if err := c.checkReachability(containerIP, targetPort); err != nil {
@@ -254,7 +642,7 @@ func (c *Client) resolveDestPort(cctx *containerCtx, targetPort string) (string,
 
 	// Published port mode
 	targetPortKey := nat.Port(fmt.Sprintf("%s/tcp", targetPort))
-	var hostPort string
+	var hostPort, hostIP string
 
 	log.Debug().
 		Str("container", cctx.containerName).
@@ -262,27 +650,41 @@ func (c *Client) resolveDestPort(cctx *containerCtx, targetPort string) (string,
 		Msg("Direct mode disabled, looking for published port binding")
 
 	if cctx.inspect.HostConfig != nil && cctx.inspect.HostConfig.PortBindings != nil {
-		if bindings, ok := cctx.inspect.HostConfig.PortBindings[targetPortKey]; ok && len(bindings) > 0 {
-			hostPort = bindings[0].HostPort
+		if binding, ok := selectPortBinding(cctx.inspect.HostConfig.PortBindings[targetPortKey]); ok {
+			hostPort, hostIP = binding.HostPort, binding.HostIP
 			log.Debug().
 				Str("container", cctx.containerName).
 				Str("target_port", targetPort).
+				Str("host_ip", hostIP).
 				Str("host_port", hostPort).
 				Msg("Detected published port binding")
 		}
 	}
 
 	if hostPort == "" && cctx.inspect.NetworkSettings != nil && cctx.inspect.NetworkSettings.Ports != nil {
-		if bindings, ok := cctx.inspect.NetworkSettings.Ports[targetPortKey]; ok && len(bindings) > 0 {
-			hostPort = bindings[0].HostPort
+		if binding, ok := selectPortBinding(cctx.inspect.NetworkSettings.Ports[targetPortKey]); ok {
+			hostPort, hostIP = binding.HostPort, binding.HostIP
 			log.Debug().
 				Str("container", cctx.containerName).
 				Str("target_port", targetPort).
+				Str("host_ip", hostIP).
 				Str("host_port", hostPort).
 				Msg("Detected published port from NetworkSettings")
 		}
 	}
 
+	randomPort := cctx.inspect.HostConfig != nil && isRandomlyPublished(cctx.inspect.HostConfig.PortBindings[targetPortKey])
+	if hostPort == "" && randomPort {
+		return "", "", notPublishedError{fmt.Errorf(
+			"container port %s is published on a random host port that Docker has not assigned yet. "+
+				"Fix: pin the host port with 'ports: [\"<host port>:%s\"]' on container '%s'",
+			targetPort, targetPort, cctx.containerName,
+		)}
+	}
+	if randomPort {
+		c.warnRandomPort(cctx.containerName, targetPort, hostPort)
+	}
+
 	if hostPort == "" {
 		var availablePorts []string
 		if cctx.inspect.HostConfig != nil && cctx.inspect.HostConfig.PortBindings != nil {
@@ -291,29 +693,161 @@ func (c *Client) resolveDestPort(cctx *containerCtx, targetPort string) (string,
 			}
 		}
 
-		log.Warn().
+		log.Debug().
 			Str("container", cctx.containerName).
 			Str("needed_port", string(targetPortKey)).
 			Strs("available_ports", availablePorts).
 			Msg("Port not found in bindings (direct mode is disabled)")
 
-		return "", "", fmt.Errorf(
-			"container port %s is NOT published to host (direct mode disabled via docktail.service.direct=false). "+
+		return "", "", notPublishedError{fmt.Errorf(
+			"container port %s is NOT published to host (direct mode disabled via docktail.service.direct=false or PROXY_MODE=host-port). "+
 				"Fix: Add 'ports: [\"%s:%s\"]' to container '%s' in docker-compose.yaml, "+
-				"or remove 'docktail.service.direct=false' to use container IP directly. "+
+				"or set 'docktail.service.direct=true' to use the container IP directly (DockTail must share a Docker network with the container). "+
 				"Available published ports: %v",
 			targetPort, targetPort, targetPort, cctx.containerName, availablePorts,
-		)
+		)}
+	}
+
+	destHost := bindingDestHost(hostIP, c.localhost())
+	hostSource := "binding"
+	if host, source := cctx.dockerHost(); isWildcardBinding(hostIP) && source != hostSourceLocalhost {
+		// Wildcard bindings accept connections on every host address, including
+		// HOST_GATEWAY and the address of a remote Docker host
+		destHost, hostSource = host, source
 	}
 
 	log.Info().
 		Str("container", cctx.containerName).
 		Str("container_port", targetPort).
+		Str("host_ip", hostIP).
 		Str("host_port", hostPort).
-		Str("will_proxy_to", fmt.Sprintf("localhost:%s", hostPort)).
+		Str("host_address_source", hostSource).
+		Str("will_proxy_to", net.JoinHostPort(destHost, hostPort)).
 		Msg("Direct mode disabled - using published port binding")
 
-	return "localhost", hostPort, nil
+	return destHost, hostPort, nil
+}
+
+// bindingPriority ranks a published port's host IP; lower is preferred. Wildcard
+// IPv4 bindings are reachable through localhost and through HOST_GATEWAY, so they win
+// over loopback, then IPv6, then explicit addresses.
+func bindingPriority(hostIP string) int {
+	switch hostIP {
+	case "", "0.0.0.0":
+		return 0
+	case "127.0.0.1":
+		return 1
+	case "::", "::1":
+		return 2
+	}
+	if ip := net.ParseIP(hostIP); ip != nil && ip.To4() != nil {
+		return 3
+	}
+	return 4
+}
+
+// selectPortBinding picks the most reachable binding of a published port
+func selectPortBinding(bindings []nat.PortBinding) (nat.PortBinding, bool) {
+	var best nat.PortBinding
+	found := false
+	for _, binding := range bindings {
+		// A host port of 0 asks Docker for a random port; the assigned one is
+		// only found in NetworkSettings
+		if binding.HostPort == "" || binding.HostPort == "0" {
+			continue
+		}
+		if !found || bindingPriority(binding.HostIP) < bindingPriority(best.HostIP) {
+			best = binding
+			found = true
+		}
+	}
+	return best, found
+}
+
+// isRandomlyPublished reports whether a port is published without a fixed host
+// port, as with 'ports: ["8080"]' or 'ports: ["0:8080"]', so Docker picks a new
+// host port every time the container starts
+func isRandomlyPublished(bindings []nat.PortBinding) bool {
+	if len(bindings) == 0 {
+		return false
+	}
+	for _, binding := range bindings {
+		if binding.HostPort != "" && binding.HostPort != "0" {
+			return false
+		}
+	}
+	return true
+}
+
+// firstWarning reports whether the warning identified by key hasn't been
+// logged yet, and remembers it so later cycles skip it
+func (c *Client) firstWarning(key string) bool {
+	c.warnedMu.Lock()
+	defer c.warnedMu.Unlock()
+	if c.warned[key] {
+		return false
+	}
+	if c.warned == nil {
+		c.warned = make(map[string]bool)
+	}
+	c.warned[key] = true
+	return true
+}
+
+// warnRandomPort warns once per container port that its host port is random.
+// Each restart moves the service to the new port, which reconfigures it.
+func (c *Client) warnRandomPort(containerName, targetPort, hostPort string) {
+	if !c.firstWarning("random-port:" + containerName + ":" + targetPort) {
+		return
+	}
+
+	log.Warn().
+		Str("container", containerName).
+		Str("container_port", targetPort).
+		Str("host_port", hostPort).
+		Msg("Port is published on a random host port that changes on every restart, reconfiguring the service each time; pin the host port to avoid this")
+}
+
+// bindingDestHost returns the address to proxy to for a binding's host IP.
+// Wildcard bindings are reached through loopback, IPv4 ones through
+// localhost; explicit addresses are used as-is.
+func bindingDestHost(hostIP, localhost string) string {
+	switch hostIP {
+	case "", "0.0.0.0":
+		return localhost
+	case "::":
+		return "::1"
+	default:
+		return hostIP
+	}
+}
+
+// destinationOverride returns the parsed docktail.service.destination label, or
+// nil when it is unset. The label lets a container point its service at any
+// backend, so it is refused unless ALLOW_DESTINATION_OVERRIDE is enabled.
+func (c *Client) destinationOverride(labels map[string]string) (*url.URL, error) {
+	value := strings.TrimSpace(labels[apptypes.LabelDestination])
+	if value == "" {
+		return nil, nil
+	}
+	if !c.allowDestinationOverride {
+		return nil, fmt.Errorf("label %s is set but destination overrides are disabled (set ALLOW_DESTINATION_OVERRIDE=true to allow them)", apptypes.LabelDestination)
+	}
+	return parseDestinationOverride(apptypes.LabelDestination, value)
+}
+
+// isWildcardBinding reports whether a binding listens on every host address
+func isWildcardBinding(hostIP string) bool {
+	return hostIP == "" || hostIP == "0.0.0.0" || hostIP == "::"
+}
+
+// networkFor returns the Docker network to use for a container's IP: its
+// docktail.service.network label, or the configured default network
+func (c *Client) networkFor(labels map[string]string) string {
+	if network := strings.TrimSpace(labels[apptypes.LabelNetwork]); network != "" {
+		return network
+	}
+	return c.defaultNetwork
 }
 
 type funnelConfig struct {
@@ -322,44 +856,84 @@ type funnelConfig struct {
 	TargetPort string
 	PublicPort string
 	Protocol   string
+	// TargetProtocol is the backend scheme of http/https funnels (empty = http)
+	TargetProtocol string
 }
 
-func (c *Client) parseFunnelConfig(cctx *containerCtx, labels map[string]string) (*funnelConfig, error) {
-	if !isFunnelEnabled(labels) {
-		return nil, nil
-	}
-
-	funnelPort := labels[apptypes.LabelFunnelPort]
-	if funnelPort == "" {
-		return nil, fmt.Errorf("funnel enabled but missing required label: %s (container port)", apptypes.LabelFunnelPort)
-	}
-
+// funnelSettings validates the funnel protocol, public port and backend
+// protocol labels and applies their defaults
+func funnelSettings(containerID string, labels map[string]string) (string, string, string, error) {
 	funnelProtocol := labels[apptypes.LabelFunnelProtocol]
 	if funnelProtocol == "" {
 		funnelProtocol = "https"
 		log.Debug().
-			Str("container", cctx.containerID[:12]).
+			Str("container", shortID(containerID)).
 			Msg("Funnel protocol not specified, defaulting to HTTPS")
 	}
 
-	funnelFunnelPort := labels[apptypes.LabelFunnelFunnelPort]
+	funnelFunnelPort, err := validateOptionalPort(apptypes.LabelFunnelFunnelPort, labels[apptypes.LabelFunnelFunnelPort])
+	if err != nil {
+		return "", "", "", err
+	}
 	if funnelFunnelPort == "" {
 		funnelFunnelPort = "443"
 		log.Debug().
-			Str("container", cctx.containerID[:12]).
+			Str("container", shortID(containerID)).
 			Msg("Funnel public port not specified, defaulting to 443")
 	}
 
 	if funnelProtocol == "https" || funnelProtocol == "http" {
 		validFunnelPorts := map[string]bool{"443": true, "8443": true, "10000": true}
 		if !validFunnelPorts[funnelFunnelPort] {
-			return nil, fmt.Errorf("invalid funnel-port: %s for HTTPS/HTTP (must be 443, 8443, or 10000)", funnelFunnelPort)
+			return "", "", "", fmt.Errorf("invalid funnel-port: %s for HTTPS/HTTP (must be 443, 8443, or 10000)", funnelFunnelPort)
 		}
 	}
 
 	validFunnelProtocols := map[string]bool{"http": true, "https": true, "tcp": true, "tls-terminated-tcp": true}
 	if !validFunnelProtocols[funnelProtocol] {
-		return nil, fmt.Errorf("invalid funnel protocol: %s (must be http, https, tcp, or tls-terminated-tcp)", funnelProtocol)
+		return "", "", "", fmt.Errorf("invalid funnel protocol: %s (must be http, https, tcp, or tls-terminated-tcp)", funnelProtocol)
+	}
+
+	targetProtocol := strings.TrimSpace(labels[apptypes.LabelFunnelTargetProtocol])
+	if targetProtocol != "" {
+		if funnelProtocol != "https" && funnelProtocol != "http" {
+			return "", "", "", fmt.Errorf("invalid %s: only https funnels proxy to an http or https backend, not %s funnels", apptypes.LabelFunnelTargetProtocol, funnelProtocol)
+		}
+		validTargetProtocols := map[string]bool{"http": true, "https": true, "https+insecure": true}
+		if !validTargetProtocols[targetProtocol] {
+			return "", "", "", fmt.Errorf("invalid %s: %s (must be http, https, or https+insecure)", apptypes.LabelFunnelTargetProtocol, targetProtocol)
+		}
+	}
+
+	return funnelProtocol, funnelFunnelPort, targetProtocol, nil
+}
+
+func (c *Client) parseFunnelConfig(cctx *containerCtx, labels map[string]string) (*funnelConfig, error) {
+	if !isFunnelEnabled(labels) {
+		return nil, nil
+	}
+	// Funnel exposes the container to the public internet, so a label set
+	// copied from another container must not enable it on its own
+	if !boolLabel(labels, apptypes.LabelFunnelConfirm, false) {
+		log.Warn().
+			Str("container", cctx.containerName).
+			Str("label", apptypes.LabelFunnelConfirm).
+			Msg("FUNNEL NOT ENABLED: the container asks for public internet access but lacks docktail.funnel.confirm=true, set it to expose the container")
+		return nil, nil
+	}
+
+	funnelPort := labels[apptypes.LabelFunnelPort]
+	if funnelPort == "" {
+		return nil, fmt.Errorf("funnel enabled but missing required label: %s (container port)", apptypes.LabelFunnelPort)
+	}
+	funnelPort, err := validatePort(apptypes.LabelFunnelPort, funnelPort)
+	if err != nil {
+		return nil, err
+	}
+
+	funnelProtocol, funnelFunnelPort, targetProtocol, err := funnelSettings(cctx.containerID, labels)
+	if err != nil {
+		return nil, err
 	}
 
 	funnelDestIP, funnelTargetPort, err := c.resolveDestPort(cctx, funnelPort)
@@ -376,11 +950,12 @@ func (c *Client) parseFunnelConfig(cctx *containerCtx, labels map[string]string)
 		Msg("Funnel enabled for public internet access")
 
 	return &funnelConfig{
-		IPAddress:  funnelDestIP,
-		Port:       funnelPort,
-		TargetPort: funnelTargetPort,
-		PublicPort: funnelFunnelPort,
-		Protocol:   funnelProtocol,
+		IPAddress:      funnelDestIP,
+		Port:           funnelPort,
+		TargetPort:     funnelTargetPort,
+		PublicPort:     funnelFunnelPort,
+		Protocol:       funnelProtocol,
+		TargetProtocol: targetProtocol,
 	}, nil
 }
 
@@ -401,14 +976,33 @@ func (c *Client) parseContainer(ctx context.Context, containerID string, labels
 
 	containerName := strings.TrimPrefix(inspect.Name, "/")
 
+	if image := containerImage(inspect); !imageAllowed(image, c.imageAllowlist) {
+		c.skipContainer(containerID, containerName, SkipImageNotAllowed,
+			fmt.Errorf("image %s does not match IMAGE_ALLOWLIST, leaving its services untouched", image))
+		return c.preservedServices(containerID, containerName, labels), nil
+	}
+
+	// A paused container keeps its network config but doesn't serve traffic
+	if inspect.State != nil && inspect.State.Paused && boolLabel(labels, apptypes.LabelRemoveOnPause, false) {
+		c.skipContainer(containerID, containerName, SkipPaused,
+			fmt.Errorf("container is paused and %s is set, treating as not present", apptypes.LabelRemoveOnPause))
+		return nil, nil
+	}
+
 	cctx := &containerCtx{
 		containerID:      containerID,
 		containerName:    containerName,
-		specifiedNetwork: labels[apptypes.LabelNetwork],
+		specifiedNetwork: c.networkFor(labels),
+		socket:           strings.TrimSpace(labels[apptypes.LabelSocket]),
+		unadvertised:     !boolLabel(labels, apptypes.LabelAdvertise, true),
+		allPorts:         boolLabel(labels, apptypes.LabelAllPorts, false),
 		inspect:          inspect,
 		isHostNetwork:    inspect.HostConfig != nil && string(inspect.HostConfig.NetworkMode) == "host",
 		isNoNetwork:      inspect.HostConfig != nil && string(inspect.HostConfig.NetworkMode) == "none",
-		isDirectMode:     labels[apptypes.LabelDirect] != "false",
+		isDirectMode:     boolLabel(labels, apptypes.LabelDirect, c.proxyMode != ProxyModeHostPort),
+	}
+	if cctx.isHostNetwork || !cctx.isDirectMode {
+		cctx.hostAddress, cctx.hostSource = c.hostAddress(ctx, containerName, labels)
 	}
 
 	// Parse tags
@@ -432,6 +1026,11 @@ func (c *Client) parseContainer(ctx context.Context, containerID string, labels
 	}
 	cctx.tags = tags
 
+	startupDelay, err := parseStartupDelay(labels)
+	if err != nil {
+		return nil, err
+	}
+
 	var result []*apptypes.ContainerService
 	if serviceEnabled {
 		// Validate required labels
@@ -439,50 +1038,94 @@ func (c *Client) parseContainer(ctx context.Context, containerID string, labels
 		if serviceName == "" {
 			return nil, fmt.Errorf("missing required label: %s", apptypes.LabelService)
 		}
+		serviceName, err = c.resolveServiceName(cctx.containerName, apptypes.LabelService, serviceName)
+		if err != nil {
+			return nil, err
+		}
 
-		targetPort := labels[apptypes.LabelTarget]
-		if targetPort == "" {
-			return nil, fmt.Errorf("missing required label: %s", apptypes.LabelTarget)
+		readiness, err := parseReadinessCheck(labels)
+		if err != nil {
+			return nil, err
 		}
 
-		// Resolve protocols for the primary port
-		protocol, port, serviceProtocol, err := resolveProtocols(
-			containerID, targetPort,
-			labels[apptypes.LabelPort],
-			labels[apptypes.LabelServiceProtocol],
-			labels[apptypes.LabelTargetProtocol],
-		)
+		role, err := parseRole(labels)
 		if err != nil {
 			return nil, err
 		}
 
-		// Resolve destination for primary port
-		destIP, destPort, err := c.resolveDestPort(cctx, targetPort)
+		handler, content, err := parseStaticHandler(labels, "docktail.service.")
 		if err != nil {
 			return nil, err
 		}
-		cctx.destIP = destIP
 
-		primary := &apptypes.ContainerService{
-			ContainerID:     cctx.containerID[:12],
-			ContainerName:   cctx.containerName,
-			ServiceEnabled:  true,
-			ServiceName:     serviceName,
-			Port:            port,
-			TargetPort:      destPort,
-			ServiceProtocol: serviceProtocol,
-			Protocol:        protocol,
-			Tags:            tags,
-			IPAddress:       destIP,
+		var primary *apptypes.ContainerService
+		if handler != "" {
+			primary, err = staticService(cctx, labels, "docktail.service.", serviceName, handler, content)
+			if err == nil {
+				primary.Path, err = validateMountPath(apptypes.LabelPath, labels[apptypes.LabelPath], primary.ServiceProtocol)
+			}
+		} else {
+			primary, err = c.parseProxyService(cctx, labels, serviceName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if readiness != nil {
+			if _, _, err := readinessURL(primary, readiness.path); err != nil {
+				return nil, err
+			}
 		}
 		result = append(result, primary)
 
 		// Parse indexed services (one container can define multiple separate Tailscale services)
-		indexedServices, err := c.parseIndexedPorts(cctx, labels, serviceName, port)
+		indexedServices, err := c.parseIndexedPorts(cctx, labels, serviceName, primary.Port)
 		if err != nil {
 			return nil, err
 		}
 		result = append(result, indexedServices...)
+
+		if cctx.allPorts {
+			result = append(result, c.parseAllPorts(cctx, primary, result)...)
+		}
+
+		// Compose replicas of a port-offset service each get their own ports
+		offset, err := replicaPortOffset(labels)
+		if err != nil {
+			return nil, err
+		}
+		if err := offsetServicePorts(result, offset); err != nil {
+			return nil, err
+		}
+
+		if boolLabel(labels, apptypes.LabelRedirectHTTP, false) {
+			if redirect := redirectService(primary, result); redirect != nil {
+				result = append(result, redirect)
+			}
+		}
+
+		aliases, err := c.parseAliases(cctx.containerName, serviceName, labels)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, aliasServices(result, serviceName, aliases)...)
+
+		// Used to pick one container when several claim the same endpoint
+		health, startedAt := containerState(inspect)
+		for _, svc := range result {
+			svc.Health = health
+			svc.StartedAt = startedAt
+			svc.Role = role
+			svc.StartupDelay = startupDelay
+		}
+
+		// A backend that listens but isn't serving yet, such as one warming
+		// up behind a 503, is left out until it answers
+		if readiness != nil && !c.offline {
+			if err := checkReadiness(ctx, readiness, primary); err != nil {
+				c.skipContainer(containerID, cctx.containerName, SkipNotReady, fmt.Errorf("backend of %s is not ready yet: %w", serviceName, err))
+				return nil, nil
+			}
+		}
 	}
 
 	funnelCfg, err := c.parseFunnelConfig(cctx, labels)
@@ -497,27 +1140,213 @@ func (c *Client) parseContainer(ctx context.Context, containerID string, labels
 		result[0].FunnelEnabled = true
 		result[0].FunnelPort = funnelCfg.Port
 		result[0].FunnelTargetPort = funnelCfg.TargetPort
+		if funnelCfg.IPAddress != result[0].IPAddress {
+			// The funnel port may be published on a different host IP than the service port
+			result[0].FunnelIPAddress = funnelCfg.IPAddress
+		}
 		result[0].FunnelFunnelPort = funnelCfg.PublicPort
 		result[0].FunnelProtocol = funnelCfg.Protocol
+		result[0].FunnelTargetProtocol = funnelCfg.TargetProtocol
 		return result, nil
 	}
 
 	result = append(result, &apptypes.ContainerService{
-		ContainerID:      cctx.containerID[:12],
-		ContainerName:    cctx.containerName,
-		ServiceEnabled:   false,
-		Tags:             tags,
-		IPAddress:        funnelCfg.IPAddress,
-		FunnelEnabled:    true,
-		FunnelPort:       funnelCfg.Port,
-		FunnelTargetPort: funnelCfg.TargetPort,
-		FunnelFunnelPort: funnelCfg.PublicPort,
-		FunnelProtocol:   funnelCfg.Protocol,
+		ContainerID:          cctx.containerID[:12],
+		ContainerName:        cctx.containerName,
+		ServiceEnabled:       false,
+		Tags:                 tags,
+		IPAddress:            funnelCfg.IPAddress,
+		FunnelEnabled:        true,
+		FunnelPort:           funnelCfg.Port,
+		FunnelTargetPort:     funnelCfg.TargetPort,
+		FunnelFunnelPort:     funnelCfg.PublicPort,
+		FunnelProtocol:       funnelCfg.Protocol,
+		FunnelTargetProtocol: funnelCfg.TargetProtocol,
+		Socket:               cctx.socket,
+		Unadvertised:         cctx.unadvertised,
+		StartupDelay:         startupDelay,
 	})
 
 	return result, nil
 }
 
+// parseProxyService parses the primary service of a container that proxies to
+// a backend: the container port, or the destination override.
+func (c *Client) parseProxyService(cctx *containerCtx, labels map[string]string, serviceName string) (*apptypes.ContainerService, error) {
+	destination, err := c.destinationOverride(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	targetPort := labels[apptypes.LabelTarget]
+	targetProtocol := labels[apptypes.LabelTargetProtocol]
+	if destination != nil {
+		// The destination names the backend, so its port and scheme stand in
+		// for the target labels
+		if targetPort == "" {
+			targetPort = destination.Port()
+		}
+		if targetProtocol == "" {
+			targetProtocol = destination.Scheme
+		}
+	}
+	if targetPort == "" {
+		targetPort, err = c.missingTargetPort(cctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	targetPort, err = validatePort(apptypes.LabelTarget, targetPort)
+	if err != nil {
+		return nil, err
+	}
+
+	servicePort, err := validateOptionalPort(apptypes.LabelPort, labels[apptypes.LabelPort])
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve protocols for the primary port
+	protocol, port, serviceProtocol, err := resolveProtocols(
+		cctx.containerID, targetPort,
+		servicePort,
+		labels[apptypes.LabelServiceProtocol],
+		targetProtocol,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if destination != nil {
+		if err := validateDestinationProtocol(apptypes.LabelDestination, destination, protocol); err != nil {
+			return nil, err
+		}
+		if err := validateDestinationLabels(destination, targetPort, labels); err != nil {
+			return nil, err
+		}
+	}
+
+	proxyProtocol, err := validateProxyProtocol(apptypes.LabelProxyProtocol, labels[apptypes.LabelProxyProtocol], serviceProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	mountPath, err := validateMountPath(apptypes.LabelPath, labels[apptypes.LabelPath], serviceProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve destination for primary port
+	var destIP, destPort, destinationURL string
+	if destination != nil {
+		destIP, destPort, destinationURL = destination.Hostname(), destination.Port(), destination.String()
+		log.Info().
+			Str("container", cctx.containerName).
+			Str("service", serviceName).
+			Str("will_proxy_to", destinationURL).
+			Msg("Using destination override, skipping port lookup")
+	} else {
+		destIP, destPort, err = c.resolveDestPort(cctx, targetPort)
+		if err != nil {
+			return nil, err
+		}
+		cctx.destIP = destIP
+	}
+
+	primary := &apptypes.ContainerService{
+		ContainerID:        cctx.containerID[:12],
+		ContainerName:      cctx.containerName,
+		ServiceEnabled:     true,
+		ServiceName:        serviceName,
+		Port:               port,
+		TargetPort:         destPort,
+		ServiceProtocol:    serviceProtocol,
+		Protocol:           protocol,
+		InsecureSkipVerify: c.resolveInsecureSkipVerify(cctx.containerName, serviceName, protocol, labels[apptypes.LabelInsecureSkipVerify]),
+		ProxyProtocol:      proxyProtocol,
+		Tags:               cctx.tags,
+		IPAddress:          destIP,
+		Destination:        destinationURL,
+		Path:               mountPath,
+		Socket:             cctx.socket,
+		Unadvertised:       cctx.unadvertised,
+	}
+	return primary, nil
+}
+
+// staticService builds a service that answers with text or a file instead of
+// proxying to the container. Only http and https services can serve them.
+func staticService(cctx *containerCtx, labels map[string]string, prefix, serviceName, handler, content string) (*apptypes.ContainerService, error) {
+	servicePort, err := validateOptionalPort(prefix+"service-port", labels[prefix+"service-port"])
+	if err != nil {
+		return nil, err
+	}
+
+	_, port, serviceProtocol, err := resolveProtocols(cctx.containerID, "", servicePort, labels[prefix+"service-protocol"], "http")
+	if err != nil {
+		return nil, err
+	}
+	if serviceProtocol != "http" && serviceProtocol != "https" {
+		return nil, fmt.Errorf("invalid %sservice-protocol: %s handlers require http or https, not %s", prefix, handler, serviceProtocol)
+	}
+
+	log.Info().
+		Str("container", cctx.containerName).
+		Str("service", serviceName).
+		Str("handler", handler).
+		Msg("Serving static content, skipping port lookup")
+
+	return &apptypes.ContainerService{
+		ContainerID:     cctx.containerID[:12],
+		ContainerName:   cctx.containerName,
+		ServiceEnabled:  true,
+		ServiceName:     serviceName,
+		Port:            port,
+		ServiceProtocol: serviceProtocol,
+		Handler:         handler,
+		HandlerContent:  content,
+		Tags:            cctx.tags,
+		Socket:          cctx.socket,
+		Unadvertised:    cctx.unadvertised,
+	}, nil
+}
+
+// redirectService returns the http:80 endpoint that redirects to an https
+// service, or nil with a warning when the service can't have one
+func redirectService(primary *apptypes.ContainerService, services []*apptypes.ContainerService) *apptypes.ContainerService {
+	reason := ""
+	switch {
+	case primary.ServiceProtocol != "https":
+		reason = "only https services can redirect http, ignoring redirect-http"
+	case primary.Handler != "":
+		reason = "static content services can't redirect http, ignoring redirect-http"
+	}
+	for _, svc := range services {
+		if reason == "" && svc.ServiceName == primary.ServiceName && svc.Port == "80" {
+			reason = "service already uses port 80, ignoring redirect-http"
+		}
+	}
+	if reason != "" {
+		log.Warn().
+			Str("container", primary.ContainerName).
+			Str("service", primary.ServiceName).
+			Str("service_protocol", primary.ServiceProtocol).
+			Msg(reason)
+		return nil
+	}
+
+	// The redirect keeps the backend so it can fall back to proxying when the
+	// installed Tailscale has no redirect handlers
+	redirect := *primary
+	redirect.Port = "80"
+	redirect.ServiceProtocol = "http"
+	redirect.Handler = apptypes.HandlerRedirect
+	redirect.HandlerContent = "https://${HOST}${REQUEST_URI}"
+	if primary.Port != "443" {
+		redirect.HandlerContent = "https://${HOST}:" + primary.Port + "${REQUEST_URI}"
+	}
+	return &redirect
+}
+
 // parseIndexedPorts scans labels for indexed service definitions (docktail.service.N.*)
 // and returns a ContainerService for each valid index. Each index defines a separate
 // Tailscale service and requires its own name (docktail.service.N.name).
@@ -572,13 +1401,66 @@ func (c *Client) parseIndexedPorts(
 				Msg("Missing required name label for indexed service, skipping")
 			continue
 		}
+		idxServiceName, err := c.resolveServiceName(cctx.containerName, prefix+"name", idxServiceName)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("container", cctx.containerName).
+				Int("index", idx).
+				Msg("Invalid name for indexed service, skipping")
+			continue
+		}
+
+		handler, content, err := parseStaticHandler(labels, prefix)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("container", cctx.containerName).
+				Int("index", idx).
+				Msg("Invalid static handler for indexed service, skipping")
+			continue
+		}
+		if handler != "" {
+			svc, err := staticService(cctx, labels, prefix, idxServiceName, handler, content)
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Str("container", cctx.containerName).
+					Str("service", idxServiceName).
+					Int("index", idx).
+					Msg("Invalid static indexed service, skipping")
+				continue
+			}
+			if !claimServicePort(usedServicePorts, cctx.containerName, svc, idx) {
+				continue
+			}
+			services = append(services, svc)
+			continue
+		}
 
 		targetPort := labels[prefix+"port"]
 		if targetPort == "" {
 			continue
 		}
+		targetPort, err = validatePort(prefix+"port", targetPort)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("container", cctx.containerName).
+				Int("index", idx).
+				Msg("Invalid port for indexed service, skipping")
+			continue
+		}
 
-		idxServicePort := labels[prefix+"service-port"]
+		idxServicePort, err := validateOptionalPort(prefix+"service-port", labels[prefix+"service-port"])
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("container", cctx.containerName).
+				Int("index", idx).
+				Msg("Invalid service port for indexed service, skipping")
+			continue
+		}
 		idxServiceProtocol := labels[prefix+"service-protocol"]
 		idxProtocol := labels[prefix+"protocol"]
 
@@ -595,16 +1477,21 @@ func (c *Client) parseIndexedPorts(
 			continue
 		}
 
-		// Check for duplicate service name + port combo
-		dedupKey := idxServiceName + ":" + servicePort
-		if prevIdx, exists := usedServicePorts[dedupKey]; exists {
+		idxProxyProtocol, err := validateProxyProtocol(prefix+"proxy-protocol", labels[prefix+"proxy-protocol"], serviceProtocol)
+		if err != nil {
 			log.Warn().
+				Err(err).
 				Str("container", cctx.containerName).
 				Str("service", idxServiceName).
 				Int("index", idx).
-				Int("conflicts_with", prevIdx).
-				Str("service_port", servicePort).
-				Msg("Duplicate service name and port across indices, skipping")
+				Msg("Invalid proxy protocol for indexed service, skipping")
+			continue
+		}
+
+		// Check for duplicate service name + port combo
+		dedupKey := idxServiceName + ":" + servicePort
+		if prevIdx, exists := usedServicePorts[dedupKey]; exists {
+			warnDuplicateServicePort(cctx.containerName, idxServiceName, servicePort, idx, prevIdx)
 			continue
 		}
 		usedServicePorts[dedupKey] = idx
@@ -629,17 +1516,21 @@ func (c *Client) parseIndexedPorts(
 		}
 
 		svc := &apptypes.ContainerService{
-			ContainerID:     cctx.containerID[:12],
-			ContainerName:   cctx.containerName,
-			ServiceEnabled:  true,
-			ServiceName:     idxServiceName,
-			Port:            servicePort,
-			TargetPort:      idxDestPort,
-			ServiceProtocol: serviceProtocol,
-			Protocol:        protocol,
-			Tags:            cctx.tags,
-			IPAddress:       idxDestIP,
-			FunnelEnabled:   false,
+			ContainerID:        cctx.containerID[:12],
+			ContainerName:      cctx.containerName,
+			ServiceEnabled:     true,
+			ServiceName:        idxServiceName,
+			Port:               servicePort,
+			TargetPort:         idxDestPort,
+			ServiceProtocol:    serviceProtocol,
+			Protocol:           protocol,
+			InsecureSkipVerify: c.resolveInsecureSkipVerify(cctx.containerName, idxServiceName, protocol, labels[prefix+"insecure-skip-verify"]),
+			ProxyProtocol:      idxProxyProtocol,
+			Tags:               cctx.tags,
+			IPAddress:          idxDestIP,
+			FunnelEnabled:      false,
+			Socket:             cctx.socket,
+			Unadvertised:       cctx.unadvertised,
 		}
 
 		services = append(services, svc)
@@ -658,6 +1549,28 @@ func (c *Client) parseIndexedPorts(
 	return services, nil
 }
 
+// claimServicePort records the service name and port of an indexed service,
+// returning false if an earlier index already uses them
+func claimServicePort(used map[string]int, containerName string, svc *apptypes.ContainerService, idx int) bool {
+	dedupKey := svc.ServiceName + ":" + svc.Port
+	if prevIdx, exists := used[dedupKey]; exists {
+		warnDuplicateServicePort(containerName, svc.ServiceName, svc.Port, idx, prevIdx)
+		return false
+	}
+	used[dedupKey] = idx
+	return true
+}
+
+func warnDuplicateServicePort(containerName, serviceName, servicePort string, idx, prevIdx int) {
+	log.Warn().
+		Str("container", containerName).
+		Str("service", serviceName).
+		Int("index", idx).
+		Int("conflicts_with", prevIdx).
+		Str("service_port", servicePort).
+		Msg("Duplicate service name and port across indices, skipping")
+}
+
 // getContainerIP extracts the container's IP address from the specified or default network
 func (c *Client) getContainerIP(inspect container.InspectResponse, specifiedNetwork string, containerName string) (string, string, error) {
 	if inspect.NetworkSettings == nil || inspect.NetworkSettings.Networks == nil {
@@ -726,6 +1639,9 @@ func getNetworkNames[V any](networks map[string]V) []string {
 
 // checkReachability performs a quick TCP connection test (best-effort, non-blocking)
 func (c *Client) checkReachability(ip string, port string) error {
+	if c.offline {
+		return nil
+	}
 	address := net.JoinHostPort(ip, port)
 	conn, err := net.DialTimeout("tcp", address, 1*time.Second)
 	if err != nil {
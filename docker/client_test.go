@@ -1,9 +1,20 @@
 package docker
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+
 	apptypes "github.com/marvinvr/docktail/types"
 )
 
@@ -248,6 +259,9 @@ func TestIndexedPortRegex(t *testing.T) {
 		{"standard indexed port", "docktail.service.1.port", true, "1"},
 		{"higher index", "docktail.service.42.port", true, "42"},
 		{"zero index", "docktail.service.0.port", true, "0"},
+		{"indexed serve-text", "docktail.service.3.serve-text", true, "3"},
+		{"indexed serve-file", "docktail.service.4.serve-file", true, "4"},
+		{"primary serve-text label", "docktail.service.serve-text", false, ""},
 		{"primary port label", "docktail.service.port", false, ""},
 		{"indexed service-port", "docktail.service.1.service-port", false, ""},
 		{"indexed protocol", "docktail.service.1.protocol", false, ""},
@@ -468,3 +482,1384 @@ func TestCollectIndexedPorts(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveInsecureSkipVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		value    string
+		expected bool
+	}{
+		{"https backend enabled", "https", "true", true},
+		{"https backend default off", "https", "", false},
+		{"https backend explicitly off", "https", "false", false},
+		{"http backend ignored", "http", "true", false},
+		{"tcp backend ignored", "tcp", "true", false},
+		{"already https+insecure ignored", "https+insecure", "true", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{}
+			if got := client.resolveInsecureSkipVerify("web", "web", tt.protocol, tt.value); got != tt.expected {
+				t.Errorf("resolveInsecureSkipVerify(%q, %q) = %v, want %v", tt.protocol, tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInsecureSkipVerifyWarnsOncePerService(t *testing.T) {
+	client := &Client{}
+	for range 3 {
+		client.resolveInsecureSkipVerify("web", "web", "https", "true")
+		client.resolveInsecureSkipVerify("web", "web-admin", "https", "true")
+		client.resolveInsecureSkipVerify("web", "web-api", "http", "true")
+	}
+	if len(client.warned) != 3 {
+		t.Errorf("warned = %v, want one warning for each of the three services", client.warned)
+	}
+}
+
+// fakeDockerAPI serves canned container list and inspect responses
+type fakeDockerAPI struct {
+	containers []container.Summary
+	inspects   map[string]container.InspectResponse
+	networks   map[string]network.Inspect
+}
+
+func (f *fakeDockerAPI) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	return f.containers, nil
+}
+
+func (f *fakeDockerAPI) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	inspect, ok := f.inspects[containerID]
+	if !ok {
+		return container.InspectResponse{}, fmt.Errorf("no such container: %s", containerID)
+	}
+	return inspect, nil
+}
+
+func (f *fakeDockerAPI) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	inspect, ok := f.networks[networkID]
+	if !ok {
+		return network.Inspect{}, fmt.Errorf("network %s not found", networkID)
+	}
+	return inspect, nil
+}
+
+func (f *fakeDockerAPI) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	return make(chan events.Message), make(chan error)
+}
+
+func (f *fakeDockerAPI) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{APIVersion: "1.47"}, nil
+}
+
+func (f *fakeDockerAPI) Close() error {
+	return nil
+}
+
+// newFakeContainer builds a container summary and inspect response using published ports
+func newFakeContainer(id, name string, labels map[string]string, bindings map[string]string) (container.Summary, container.InspectResponse) {
+	portBindings := nat.PortMap{}
+	for containerPort, hostPort := range bindings {
+		portBindings[nat.Port(containerPort+"/tcp")] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}}
+	}
+
+	summary := container.Summary{ID: id, Names: []string{"/" + name}, Labels: labels}
+	inspect := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + name,
+			State:      &container.State{Running: true},
+			HostConfig: &container.HostConfig{PortBindings: portBindings},
+		},
+		Config:          &container.Config{Labels: labels},
+		NetworkSettings: &container.NetworkSettings{},
+	}
+	return summary, inspect
+}
+
+func TestGetEnabledContainersServeAndFunnel(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:           "true",
+		apptypes.LabelService:          "web",
+		apptypes.LabelTarget:           "8080",
+		apptypes.LabelPort:             "443",
+		apptypes.LabelDirect:           "false",
+		apptypes.LabelTags:             "tag:web",
+		apptypes.LabelFunnelEnable:     "true",
+		apptypes.LabelFunnelConfirm:    "true",
+		apptypes.LabelFunnelPort:       "3000",
+		apptypes.LabelFunnelFunnelPort: "8443",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080", "3000": "13000"})
+
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}}
+
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("got %d services, want 1", len(services))
+	}
+
+	want := apptypes.ContainerService{
+		ContainerID:      "abcdef123456",
+		ContainerName:    "web",
+		ServiceEnabled:   true,
+		ServiceName:      "web",
+		Port:             "443",
+		TargetPort:       "18080",
+		ServiceProtocol:  "https",
+		Protocol:         "http",
+		Tags:             []string{"tag:web"},
+		IPAddress:        "localhost",
+		FunnelEnabled:    true,
+		FunnelPort:       "3000",
+		FunnelTargetPort: "13000",
+		FunnelFunnelPort: "8443",
+		FunnelProtocol:   "https",
+	}
+	if got := services[0]; !reflect.DeepEqual(*got, want) {
+		t.Errorf("parsed service = %+v, want %+v", *got, want)
+	}
+}
+
+func TestParseFunnelTargetProtocol(t *testing.T) {
+	tests := []struct {
+		name           string
+		protocol       string
+		targetProtocol string
+		want           string
+		wantErr        bool
+	}{
+		{"default", "", "", "", false},
+		{"self-signed https backend", "", "https+insecure", "https+insecure", false},
+		{"https backend on http funnel", "http", "https", "https", false},
+		{"invalid backend protocol", "", "tcp", "", true},
+		{"tcp funnel", "tcp", "https", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelFunnelEnable:         "true",
+				apptypes.LabelFunnelConfirm:        "true",
+				apptypes.LabelFunnelPort:           "8006",
+				apptypes.LabelFunnelProtocol:       tt.protocol,
+				apptypes.LabelFunnelTargetProtocol: tt.targetProtocol,
+				apptypes.LabelDirect:               "false",
+			}
+			_, inspect := newFakeContainer("abcdef1234567890", "pve", labels, map[string]string{"8006": "18006"})
+			client := &Client{cli: &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}}}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(services) != 1 {
+				t.Fatalf("got %d services, want 1", len(services))
+			}
+			if got := services[0].FunnelTargetProtocol; got != tt.want {
+				t.Errorf("FunnelTargetProtocol = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnabledContainersFunnelOnly(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelFunnelEnable:   "true",
+		apptypes.LabelFunnelConfirm:  "true",
+		apptypes.LabelFunnelPort:     "3000",
+		apptypes.LabelFunnelProtocol: "tcp",
+		apptypes.LabelDirect:         "false",
+	}
+	summary, inspect := newFakeContainer("123456abcdef7890", "public", labels, map[string]string{"3000": "13000"})
+
+	client := &Client{
+		cli: &fakeDockerAPI{
+			containers: []container.Summary{summary},
+			inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+		},
+		defaultTags: []string{"tag:container"},
+	}
+
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("got %d services, want 1", len(services))
+	}
+
+	svc := services[0]
+	if svc.ServiceEnabled {
+		t.Error("funnel-only container should not enable a service")
+	}
+	if !svc.FunnelEnabled || svc.FunnelProtocol != "tcp" || svc.FunnelFunnelPort != "443" || svc.FunnelTargetPort != "13000" {
+		t.Errorf("unexpected funnel fields: %+v", *svc)
+	}
+	if !reflect.DeepEqual(svc.Tags, []string{"tag:container"}) {
+		t.Errorf("Tags = %v, want default tags", svc.Tags)
+	}
+}
+
+func TestFunnelRequiresConfirmation(t *testing.T) {
+	tests := []struct {
+		name        string
+		confirm     string // docktail.funnel.confirm, empty for none
+		service     bool   // the container also enables a service
+		wantNames   []string
+		wantFunnels int
+	}{
+		{name: "confirmed funnel only", confirm: "true", wantNames: []string{""}, wantFunnels: 1},
+		{name: "unconfirmed funnel only", wantNames: nil},
+		{name: "confirm set to false", confirm: "false", wantNames: nil},
+		{name: "confirmed funnel with service", confirm: "yes", service: true, wantNames: []string{"web"}, wantFunnels: 1},
+		{name: "unconfirmed funnel keeps service", service: true, wantNames: []string{"web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelFunnelEnable: "true",
+				apptypes.LabelFunnelPort:   "3000",
+				apptypes.LabelDirect:       "false",
+			}
+			if tt.confirm != "" {
+				labels[apptypes.LabelFunnelConfirm] = tt.confirm
+			}
+			if tt.service {
+				labels[apptypes.LabelEnable] = "true"
+				labels[apptypes.LabelService] = "web"
+				labels[apptypes.LabelTarget] = "3000"
+			}
+			summary, inspect := newFakeContainer("123456abcdef7890", "public", labels, map[string]string{"3000": "13000"})
+			client := &Client{cli: &fakeDockerAPI{
+				containers: []container.Summary{summary},
+				inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+			}}
+
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			funnels := 0
+			for _, svc := range services {
+				names = append(names, svc.ServiceName)
+				if svc.FunnelEnabled {
+					funnels++
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) || funnels != tt.wantFunnels {
+				t.Errorf("services = %v with %d funnel(s), want %v with %d", names, funnels, tt.wantNames, tt.wantFunnels)
+			}
+		})
+	}
+}
+
+func TestRemoveOnPause(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:        "true",
+		apptypes.LabelService:       "web",
+		apptypes.LabelTarget:        "8080",
+		apptypes.LabelDirect:        "false",
+		apptypes.LabelRemoveOnPause: "true",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+	api := &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}
+	client := &Client{cli: api}
+
+	steps := []struct {
+		name         string
+		paused       bool
+		wantServices int
+	}{
+		{"running", false, 1},
+		{"paused removes service", true, 0},
+		{"unpaused re-adds service", false, 1},
+	}
+
+	for _, step := range steps {
+		inspect.State.Paused = step.paused
+		services, err := client.GetEnabledContainers(context.Background())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if len(services) != step.wantServices {
+			t.Errorf("%s: got %d services, want %d", step.name, len(services), step.wantServices)
+		}
+	}
+}
+
+func TestPausedContainerKeptWithoutRemoveOnPause(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "web",
+		apptypes.LabelTarget:  "8080",
+		apptypes.LabelDirect:  "false",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+	inspect.State.Paused = true
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}}
+
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services) != 1 {
+		t.Errorf("got %d services, want 1", len(services))
+	}
+}
+
+func TestParseContainerServiceProtocol(t *testing.T) {
+	tests := []struct {
+		name                string
+		labels              map[string]string
+		wantServices        int
+		wantPort            string
+		wantServiceProtocol string
+		wantProtocol        string
+	}{
+		{
+			name:                "defaults to http on 80",
+			labels:              map[string]string{},
+			wantServices:        1,
+			wantPort:            "80",
+			wantServiceProtocol: "http",
+			wantProtocol:        "http",
+		},
+		{
+			name:                "service-protocol label sets https and defaults port 443",
+			labels:              map[string]string{apptypes.LabelServiceProtocol: "https"},
+			wantServices:        1,
+			wantPort:            "443",
+			wantServiceProtocol: "https",
+			wantProtocol:        "http",
+		},
+		{
+			name:                "tcp backend defaults service protocol to tcp",
+			labels:              map[string]string{apptypes.LabelTargetProtocol: "tcp", apptypes.LabelPort: "5432"},
+			wantServices:        1,
+			wantPort:            "5432",
+			wantServiceProtocol: "tcp",
+			wantProtocol:        "tcp",
+		},
+		{
+			name:         "unknown service-protocol rejects container",
+			labels:       map[string]string{apptypes.LabelServiceProtocol: "quic"},
+			wantServices: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+				apptypes.LabelTarget:  "8080",
+				apptypes.LabelDirect:  "false",
+			}
+			for k, v := range tt.labels {
+				labels[k] = v
+			}
+			summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+			client := &Client{cli: &fakeDockerAPI{
+				containers: []container.Summary{summary},
+				inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+			}}
+
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(services) != tt.wantServices {
+				t.Fatalf("got %d services, want %d", len(services), tt.wantServices)
+			}
+			if tt.wantServices == 0 {
+				return
+			}
+
+			svc := services[0]
+			if svc.Port != tt.wantPort {
+				t.Errorf("Port = %q, want %q", svc.Port, tt.wantPort)
+			}
+			if svc.ServiceProtocol != tt.wantServiceProtocol {
+				t.Errorf("ServiceProtocol = %q, want %q", svc.ServiceProtocol, tt.wantServiceProtocol)
+			}
+			if svc.Protocol != tt.wantProtocol {
+				t.Errorf("Protocol = %q, want %q", svc.Protocol, tt.wantProtocol)
+			}
+		})
+	}
+}
+
+func TestParseWatchedEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		wantErr  bool
+	}{
+		{"single event", "start", []string{"start"}, false},
+		{"multiple events with whitespace", " start , health_status,destroy ", []string{"start", "health_status", "destroy"}, false},
+		{"duplicates removed", "start,start,die", []string{"start", "die"}, false},
+		{"unknown event", "start,explode", nil, true},
+		{"empty list", " , ", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWatchedEvents(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWatchedEvents(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseWatchedEvents(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEventFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		watched  []string
+		expected []string
+	}{
+		{"defaults", nil, DefaultWatchedEvents},
+		{"configured events replace defaults", []string{"start", "health_status"}, []string{"start", "health_status"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{watchedEvents: tt.watched}
+			args := c.eventFilters()
+
+			if got := args.Get("type"); !reflect.DeepEqual(got, []string{"container"}) {
+				t.Errorf("type filter = %v, want [container]", got)
+			}
+
+			got := args.Get("event")
+			sort.Strings(got)
+			want := append([]string(nil), tt.expected...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("event filter = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseContainerRejectsInvalidPorts(t *testing.T) {
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		wantServices []string
+	}{
+		{
+			name:         "invalid primary port rejects container",
+			labels:       map[string]string{apptypes.LabelTarget: "eighty"},
+			wantServices: nil,
+		},
+		{
+			name:         "invalid service-port rejects container",
+			labels:       map[string]string{apptypes.LabelPort: "70000"},
+			wantServices: nil,
+		},
+		{
+			name: "invalid indexed port skips only that entry",
+			labels: map[string]string{
+				"docktail.service.1.name": "bad",
+				"docktail.service.1.port": "-1",
+				"docktail.service.2.name": "good",
+				"docktail.service.2.port": "9090",
+			},
+			wantServices: []string{"web", "good"},
+		},
+		{
+			name: "invalid indexed service-port skips only that entry",
+			labels: map[string]string{
+				"docktail.service.1.name":         "bad",
+				"docktail.service.1.port":         "9090",
+				"docktail.service.1.service-port": "0",
+			},
+			wantServices: []string{"web"},
+		},
+		{
+			name: "invalid funnel port rejects container",
+			labels: map[string]string{
+				apptypes.LabelFunnelEnable:  "true",
+				apptypes.LabelFunnelConfirm: "true",
+				apptypes.LabelFunnelPort:    "3000x",
+			},
+			wantServices: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+				apptypes.LabelTarget:  "8080",
+				apptypes.LabelDirect:  "false",
+			}
+			for k, v := range tt.labels {
+				labels[k] = v
+			}
+			summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080", "9090": "19090"})
+			client := &Client{cli: &fakeDockerAPI{
+				containers: []container.Summary{summary},
+				inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+			}}
+
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, svc := range services {
+				names = append(names, svc.ServiceName)
+			}
+			if !reflect.DeepEqual(names, tt.wantServices) {
+				t.Errorf("services = %v, want %v", names, tt.wantServices)
+			}
+		})
+	}
+}
+
+// withNetworks attaches the container to the given networks (name -> IP)
+func withNetworks(inspect container.InspectResponse, networks map[string]string) container.InspectResponse {
+	inspect.NetworkSettings.Networks = make(map[string]*network.EndpointSettings, len(networks))
+	for name, ip := range networks {
+		inspect.NetworkSettings.Networks[name] = &network.EndpointSettings{IPAddress: ip}
+	}
+	return inspect
+}
+
+func TestGetContainerIP(t *testing.T) {
+	multiNetwork := map[string]string{
+		"bridge":         "127.0.0.11",
+		"myproj_backend": "127.0.0.12",
+		"frontend":       "127.0.0.13",
+		"detached":       "",
+	}
+
+	tests := []struct {
+		name            string
+		networks        map[string]string
+		network         string
+		expectedIP      string
+		expectedNetwork string
+		wantError       string
+	}{
+		{"default prefers bridge", multiNetwork, "", "127.0.0.11", "bridge", ""},
+		{"exact match", multiNetwork, "frontend", "127.0.0.13", "frontend", ""},
+		{"compose prefix match", multiNetwork, "backend", "127.0.0.12", "myproj_backend", ""},
+		{"not connected", multiNetwork, "database", "", "", "is not connected to network 'database'"},
+		{"no IP on network", multiNetwork, "detached", "", "", "has no IP address on network 'detached'"},
+		{"first available without bridge", map[string]string{"only": "127.0.0.14"}, "", "127.0.0.14", "only", ""},
+		{"no IP anywhere", map[string]string{"only": ""}, "", "", "", "has no IP address on any network"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, inspect := newFakeContainer("abcdef1234567890", "app", nil, nil)
+			inspect = withNetworks(inspect, tt.networks)
+
+			ip, networkName, err := (&Client{}).getContainerIP(inspect, tt.network, "app")
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("getContainerIP() error = %v, want containing %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getContainerIP() unexpected error: %v", err)
+			}
+			if ip != tt.expectedIP || networkName != tt.expectedNetwork {
+				t.Errorf("getContainerIP() = %s on %s, want %s on %s", ip, networkName, tt.expectedIP, tt.expectedNetwork)
+			}
+		})
+	}
+}
+
+func TestParseContainerNetworkLabel(t *testing.T) {
+	tests := []struct {
+		name       string
+		network    string
+		expectedIP string
+		wantError  string
+	}{
+		{"named network uses its IP", "backend", "127.0.0.12", ""},
+		{"no label falls back to bridge", "", "127.0.0.11", ""},
+		{"missing network", "database", "", "is not connected to network 'database'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+				apptypes.LabelTarget:  "8080",
+			}
+			if tt.network != "" {
+				labels[apptypes.LabelNetwork] = tt.network
+			}
+			_, inspect := newFakeContainer("abcdef1234567890", "web", labels, nil)
+			inspect = withNetworks(inspect, map[string]string{
+				"bridge":         "127.0.0.11",
+				"myproj_backend": "127.0.0.12",
+			})
+
+			client := &Client{cli: &fakeDockerAPI{
+				inspects: map[string]container.InspectResponse{inspect.ID: inspect},
+			}}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("parseContainer() error = %v, want containing %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseContainer() unexpected error: %v", err)
+			}
+			if len(services) != 1 {
+				t.Fatalf("got %d services, want 1", len(services))
+			}
+			if services[0].IPAddress != tt.expectedIP || services[0].TargetPort != "8080" {
+				t.Errorf("destination = %s:%s, want %s:8080 (container port, not a host port)",
+					services[0].IPAddress, services[0].TargetPort, tt.expectedIP)
+			}
+		})
+	}
+}
+
+func TestParseProxyMode(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  string
+		wantError bool
+	}{
+		{"container-ip", ProxyModeContainerIP, false},
+		{"host-port", ProxyModeHostPort, false},
+		{" Host-Port ", ProxyModeHostPort, false},
+		{"direct", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseProxyMode(tt.value)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseProxyMode(%q) error = %v, wantError %v", tt.value, err, tt.wantError)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseProxyMode(%q) = %q, want %q", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProxyModes(t *testing.T) {
+	tests := []struct {
+		name             string
+		proxyMode        string
+		defaultNetwork   string
+		labels           map[string]string
+		expectedDest     string
+		expectedFunnel   string
+		expectedErrorMsg string
+	}{
+		{
+			name:           "container-ip mode uses container port",
+			proxyMode:      ProxyModeContainerIP,
+			expectedDest:   "127.0.0.11:8080",
+			expectedFunnel: "127.0.0.11:3000",
+		},
+		{
+			name:           "unset mode defaults to container-ip",
+			expectedDest:   "127.0.0.11:8080",
+			expectedFunnel: "127.0.0.11:3000",
+		},
+		{
+			name:           "container-ip mode with default network",
+			proxyMode:      ProxyModeContainerIP,
+			defaultNetwork: "backend",
+			expectedDest:   "127.0.0.12:8080",
+			expectedFunnel: "127.0.0.12:3000",
+		},
+		{
+			name:           "network label overrides default network",
+			proxyMode:      ProxyModeContainerIP,
+			defaultNetwork: "backend",
+			labels:         map[string]string{apptypes.LabelNetwork: "bridge"},
+			expectedDest:   "127.0.0.11:8080",
+			expectedFunnel: "127.0.0.11:3000",
+		},
+		{
+			name:             "container-ip mode on unattached default network",
+			proxyMode:        ProxyModeContainerIP,
+			defaultNetwork:   "database",
+			expectedErrorMsg: "PROXY_MODE=host-port",
+		},
+		{
+			name:           "host-port mode uses published ports",
+			proxyMode:      ProxyModeHostPort,
+			expectedDest:   "localhost:18080",
+			expectedFunnel: "localhost:13000",
+		},
+		{
+			name:           "direct label overrides host-port mode",
+			proxyMode:      ProxyModeHostPort,
+			labels:         map[string]string{apptypes.LabelDirect: "true"},
+			expectedDest:   "127.0.0.11:8080",
+			expectedFunnel: "127.0.0.11:3000",
+		},
+		{
+			name:           "direct label overrides container-ip mode",
+			proxyMode:      ProxyModeContainerIP,
+			labels:         map[string]string{apptypes.LabelDirect: "false"},
+			expectedDest:   "localhost:18080",
+			expectedFunnel: "localhost:13000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:        "true",
+				apptypes.LabelService:       "web",
+				apptypes.LabelTarget:        "8080",
+				apptypes.LabelFunnelEnable:  "true",
+				apptypes.LabelFunnelConfirm: "true",
+				apptypes.LabelFunnelPort:    "3000",
+			}
+			for key, value := range tt.labels {
+				labels[key] = value
+			}
+			_, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080", "3000": "13000"})
+			inspect = withNetworks(inspect, map[string]string{
+				"bridge":         "127.0.0.11",
+				"myproj_backend": "127.0.0.12",
+			})
+
+			client := &Client{
+				cli:            &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}},
+				proxyMode:      tt.proxyMode,
+				defaultNetwork: tt.defaultNetwork,
+			}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if tt.expectedErrorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErrorMsg) {
+					t.Fatalf("parseContainer() error = %v, want containing %q", err, tt.expectedErrorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseContainer() unexpected error: %v", err)
+			}
+			if len(services) != 1 {
+				t.Fatalf("got %d services, want 1", len(services))
+			}
+
+			svc := services[0]
+			if got := svc.IPAddress + ":" + svc.TargetPort; got != tt.expectedDest {
+				t.Errorf("service destination = %s, want %s", got, tt.expectedDest)
+			}
+			if got := svc.IPAddress + ":" + svc.FunnelTargetPort; got != tt.expectedFunnel {
+				t.Errorf("funnel destination = %s, want %s", got, tt.expectedFunnel)
+			}
+		})
+	}
+}
+
+func TestSelectPortBinding(t *testing.T) {
+	tests := []struct {
+		name         string
+		bindings     []nat.PortBinding
+		expectedIP   string
+		expectedPort string
+		expectedHost string
+	}{
+		{
+			name:         "wildcard only",
+			bindings:     []nat.PortBinding{{HostIP: "", HostPort: "8080"}},
+			expectedIP:   "",
+			expectedPort: "8080",
+			expectedHost: "localhost",
+		},
+		{
+			name:         "v6 listed before v4 wildcard",
+			bindings:     []nat.PortBinding{{HostIP: "::", HostPort: "8081"}, {HostIP: "0.0.0.0", HostPort: "8080"}},
+			expectedIP:   "0.0.0.0",
+			expectedPort: "8080",
+			expectedHost: "localhost",
+		},
+		{
+			name:         "v6 wildcard only",
+			bindings:     []nat.PortBinding{{HostIP: "::", HostPort: "8081"}},
+			expectedIP:   "::",
+			expectedPort: "8081",
+			expectedHost: "::1",
+		},
+		{
+			name:         "loopback preferred over v6",
+			bindings:     []nat.PortBinding{{HostIP: "::1", HostPort: "8081"}, {HostIP: "127.0.0.1", HostPort: "8080"}},
+			expectedIP:   "127.0.0.1",
+			expectedPort: "8080",
+			expectedHost: "127.0.0.1",
+		},
+		{
+			name:         "v6 wildcard preferred over explicit v4",
+			bindings:     []nat.PortBinding{{HostIP: "192.168.1.10", HostPort: "8080"}, {HostIP: "::", HostPort: "8081"}},
+			expectedIP:   "::",
+			expectedPort: "8081",
+			expectedHost: "::1",
+		},
+		{
+			name:         "explicit v4 preferred over explicit v6",
+			bindings:     []nat.PortBinding{{HostIP: "fd00::10", HostPort: "8081"}, {HostIP: "192.168.1.10", HostPort: "8080"}},
+			expectedIP:   "192.168.1.10",
+			expectedPort: "8080",
+			expectedHost: "192.168.1.10",
+		},
+		{
+			name:         "explicit v6 only",
+			bindings:     []nat.PortBinding{{HostIP: "fd00::10", HostPort: "8081"}},
+			expectedIP:   "fd00::10",
+			expectedPort: "8081",
+			expectedHost: "fd00::10",
+		},
+		{
+			name:         "binding without host port is ignored",
+			bindings:     []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: ""}, {HostIP: "::1", HostPort: "8081"}},
+			expectedIP:   "::1",
+			expectedPort: "8081",
+			expectedHost: "::1",
+		},
+		{
+			name:         "random host port is ignored",
+			bindings:     []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "0"}, {HostIP: "127.0.0.1", HostPort: "8080"}},
+			expectedIP:   "127.0.0.1",
+			expectedPort: "8080",
+			expectedHost: "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binding, ok := selectPortBinding(tt.bindings)
+			if !ok {
+				t.Fatal("selectPortBinding() found no binding")
+			}
+			if binding.HostIP != tt.expectedIP || binding.HostPort != tt.expectedPort {
+				t.Errorf("selectPortBinding() = %s:%s, want %s:%s", binding.HostIP, binding.HostPort, tt.expectedIP, tt.expectedPort)
+			}
+			if host := bindingDestHost(binding.HostIP, "localhost"); host != tt.expectedHost {
+				t.Errorf("bindingDestHost(%q) = %q, want %q", binding.HostIP, host, tt.expectedHost)
+			}
+		})
+	}
+
+	if _, ok := selectPortBinding(nil); ok {
+		t.Error("selectPortBinding(nil) should find no binding")
+	}
+}
+
+func TestPublishedPortUsesBindingHostIP(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:        "true",
+		apptypes.LabelService:       "web",
+		apptypes.LabelTarget:        "8080",
+		apptypes.LabelDirect:        "false",
+		apptypes.LabelFunnelEnable:  "true",
+		apptypes.LabelFunnelConfirm: "true",
+		apptypes.LabelFunnelPort:    "3000",
+	}
+	_, inspect := newFakeContainer("abcdef1234567890", "web", labels, nil)
+	inspect.HostConfig.PortBindings = nat.PortMap{
+		"8080/tcp": {{HostIP: "::1", HostPort: "18081"}, {HostIP: "127.0.0.1", HostPort: "18080"}},
+		"3000/tcp": {{HostIP: "::", HostPort: "13000"}},
+	}
+
+	client := &Client{cli: &fakeDockerAPI{
+		inspects: map[string]container.InspectResponse{inspect.ID: inspect},
+	}}
+
+	services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+	if err != nil {
+		t.Fatalf("parseContainer() error = %v", err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("got %d services, want 1", len(services))
+	}
+
+	svc := services[0]
+	if svc.IPAddress != "127.0.0.1" || svc.TargetPort != "18080" {
+		t.Errorf("service destination = %s:%s, want 127.0.0.1:18080", svc.IPAddress, svc.TargetPort)
+	}
+	if svc.FunnelIPAddress != "::1" || svc.FunnelTargetPort != "13000" {
+		t.Errorf("funnel destination = %s:%s, want ::1:13000", svc.FunnelIPAddress, svc.FunnelTargetPort)
+	}
+}
+
+func TestPublishedPortFollowsRandomHostPort(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "web",
+		apptypes.LabelTarget:  "8080",
+		apptypes.LabelDirect:  "false",
+	}
+	// ports: ["0:8080"] asks Docker for a new host port on every start
+	_, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "0"})
+	api := &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}}
+	client := &Client{cli: api}
+
+	for _, hostPort := range []string{"32768", "32771", "32769"} {
+		inspect.NetworkSettings = &container.NetworkSettings{NetworkSettingsBase: container.NetworkSettingsBase{
+			Ports: nat.PortMap{"8080/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		}}
+		api.inspects[inspect.ID] = inspect
+
+		services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+		if err != nil {
+			t.Fatalf("parseContainer() with host port %s error = %v", hostPort, err)
+		}
+		if len(services) != 1 || services[0].TargetPort != hostPort {
+			t.Errorf("services = %+v, want the service on host port %s", services, hostPort)
+		}
+	}
+	if len(client.warned) != 1 {
+		t.Errorf("warned = %v, want a single warning for web:8080", client.warned)
+	}
+
+	// Before Docker assigns the port, the error says to pin it
+	inspect.NetworkSettings = &container.NetworkSettings{}
+	api.inspects[inspect.ID] = inspect
+	if _, err := client.parseContainer(context.Background(), inspect.ID, labels); err == nil || !strings.Contains(err.Error(), "random host port") {
+		t.Errorf("parseContainer() error = %v, want a random host port error", err)
+	}
+}
+
+func TestDestinationOverride(t *testing.T) {
+	tests := []struct {
+		name            string
+		allow           bool
+		labels          map[string]string
+		wantErr         bool
+		wantDestination string
+		wantProtocol    string
+		wantPort        string
+	}{
+		{
+			name:            "http backend",
+			allow:           true,
+			labels:          map[string]string{apptypes.LabelDestination: "http://192.168.1.40:8006"},
+			wantDestination: "http://192.168.1.40:8006",
+			wantProtocol:    "http",
+			wantPort:        "80",
+		},
+		{
+			name:  "tcp backend with declared protocol",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination:    "tcp://10.0.0.2:5432",
+				apptypes.LabelTargetProtocol: "tcp",
+				apptypes.LabelPort:           "5432",
+			},
+			wantDestination: "tcp://10.0.0.2:5432",
+			wantProtocol:    "tcp",
+			wantPort:        "5432",
+		},
+		{
+			name:    "disabled without ALLOW_DESTINATION_OVERRIDE",
+			labels:  map[string]string{apptypes.LabelDestination: "http://192.168.1.40:8006"},
+			wantErr: true,
+		},
+		{
+			name:  "scheme does not match protocol",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination:    "http://10.0.0.2:5432",
+				apptypes.LabelTargetProtocol: "tcp",
+			},
+			wantErr: true,
+		},
+		{
+			name:  "https protocol needs https scheme",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination:    "http://192.168.1.40:8006",
+				apptypes.LabelTargetProtocol: "https",
+			},
+			wantErr: true,
+		},
+		{
+			name:  "matching backend port",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination: "http://192.168.1.40:8006",
+				apptypes.LabelTarget:      "8006",
+			},
+			wantDestination: "http://192.168.1.40:8006",
+			wantProtocol:    "http",
+			wantPort:        "80",
+		},
+		{
+			name:  "backend port does not match destination",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination: "http://192.168.1.40:8006",
+				apptypes.LabelTarget:      "8080",
+			},
+			wantErr: true,
+		},
+		{
+			name:  "insecure-skip-verify with destination",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination:        "https://192.168.1.40:8006",
+				apptypes.LabelInsecureSkipVerify: "true",
+			},
+			wantErr: true,
+		},
+		{
+			name:  "https+insecure destination",
+			allow: true,
+			labels: map[string]string{
+				apptypes.LabelDestination:        "https+insecure://192.168.1.40:8006",
+				apptypes.LabelInsecureSkipVerify: "false",
+			},
+			wantDestination: "https+insecure://192.168.1.40:8006",
+			wantProtocol:    "https+insecure",
+			wantPort:        "80",
+		},
+		{
+			name:    "invalid URL",
+			allow:   true,
+			labels:  map[string]string{apptypes.LabelDestination: "192.168.1.40:8006"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "pve",
+			}
+			for k, v := range tt.labels {
+				labels[k] = v
+			}
+			// The placeholder container publishes nothing, so any port lookup would fail
+			_, inspect := newFakeContainer("abcdef1234567890", "pve-placeholder", labels, nil)
+			client := &Client{
+				cli:                      &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}},
+				allowDestinationOverride: tt.allow,
+			}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(services) != 1 {
+				t.Fatalf("got %d services, want 1", len(services))
+			}
+
+			svc := services[0]
+			if svc.Destination != tt.wantDestination {
+				t.Errorf("Destination = %q, want %q", svc.Destination, tt.wantDestination)
+			}
+			if svc.Protocol != tt.wantProtocol {
+				t.Errorf("Protocol = %q, want %q", svc.Protocol, tt.wantProtocol)
+			}
+			if svc.Port != tt.wantPort {
+				t.Errorf("Port = %q, want %q", svc.Port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestParseContainerStaticHandlers(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		wantErr     bool
+		wantHandler []string // Handler of each parsed service, in order
+	}{
+		{
+			name: "primary serve-text",
+			labels: map[string]string{
+				apptypes.LabelServeText:       "ok",
+				apptypes.LabelServiceProtocol: "https",
+			},
+			wantHandler: []string{apptypes.HandlerText},
+		},
+		{
+			name:        "primary serve-file",
+			labels:      map[string]string{apptypes.LabelServeFile: "/srv/www"},
+			wantHandler: []string{apptypes.HandlerFile},
+		},
+		{
+			name: "primary serve-text with port",
+			labels: map[string]string{
+				apptypes.LabelServeText: "ok",
+				apptypes.LabelTarget:    "80",
+			},
+			wantErr: true,
+		},
+		{
+			name: "primary serve-text on tcp service",
+			labels: map[string]string{
+				apptypes.LabelServeText:       "ok",
+				apptypes.LabelServiceProtocol: "tcp",
+			},
+			wantErr: true,
+		},
+		{
+			name: "indexed serve-text next to proxied primary",
+			labels: map[string]string{
+				apptypes.LabelTarget:                  "80",
+				"docktail.service.1.name":             "health",
+				"docktail.service.1.serve-text":       "ok",
+				"docktail.service.1.service-protocol": "https",
+			},
+			wantHandler: []string{"", apptypes.HandlerText},
+		},
+		{
+			name: "indexed serve-text with port is skipped",
+			labels: map[string]string{
+				apptypes.LabelTarget:            "80",
+				"docktail.service.1.name":       "health",
+				"docktail.service.1.serve-text": "ok",
+				"docktail.service.1.port":       "8080",
+			},
+			wantHandler: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+			}
+			for k, v := range tt.labels {
+				labels[k] = v
+			}
+			_, inspect := newFakeContainer("abcdef1234567890", "web", labels, nil)
+			inspect.NetworkSettings = &container.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+				"bridge": {IPAddress: "172.17.0.2"},
+			}}
+			client := &Client{
+				cli:       &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}},
+				proxyMode: ProxyModeContainerIP,
+			}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(services) != len(tt.wantHandler) {
+				t.Fatalf("got %d services, want %d", len(services), len(tt.wantHandler))
+			}
+			for i, svc := range services {
+				if svc.Handler != tt.wantHandler[i] {
+					t.Errorf("services[%d].Handler = %q, want %q", i, svc.Handler, tt.wantHandler[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRedirectService(t *testing.T) {
+	https := func(port string) *apptypes.ContainerService {
+		return &apptypes.ContainerService{ServiceName: "web", Port: port, ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080"}
+	}
+
+	tests := []struct {
+		name        string
+		primary     *apptypes.ContainerService
+		others      []*apptypes.ContainerService
+		wantContent string // empty when no redirect is added
+	}{
+		{"https on 443", https("443"), nil, "https://${HOST}${REQUEST_URI}"},
+		{"https on another port", https("8443"), nil, "https://${HOST}:8443${REQUEST_URI}"},
+		{"http service", &apptypes.ContainerService{ServiceName: "web", Port: "80", ServiceProtocol: "http"}, nil, ""},
+		{"static content", &apptypes.ContainerService{ServiceName: "web", Port: "443", ServiceProtocol: "https", Handler: apptypes.HandlerText}, nil, ""},
+		{"port 80 already used", https("443"), []*apptypes.ContainerService{{ServiceName: "web", Port: "80"}}, ""},
+		{"port 80 used by another service", https("443"), []*apptypes.ContainerService{{ServiceName: "api", Port: "80"}}, "https://${HOST}${REQUEST_URI}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirect := redirectService(tt.primary, append([]*apptypes.ContainerService{tt.primary}, tt.others...))
+			if tt.wantContent == "" {
+				if redirect != nil {
+					t.Errorf("redirectService() = %+v, want nil", redirect)
+				}
+				return
+			}
+			if redirect == nil {
+				t.Fatal("redirectService() = nil, want a redirect")
+			}
+			if redirect.Port != "80" || redirect.ServiceProtocol != "http" || redirect.Handler != apptypes.HandlerRedirect {
+				t.Errorf("redirect endpoint = %s/%s/%s, want 80/http/redirect", redirect.Port, redirect.ServiceProtocol, redirect.Handler)
+			}
+			if redirect.HandlerContent != tt.wantContent {
+				t.Errorf("HandlerContent = %q, want %q", redirect.HandlerContent, tt.wantContent)
+			}
+			if redirect.IPAddress != tt.primary.IPAddress || redirect.TargetPort != tt.primary.TargetPort {
+				t.Error("redirect endpoint should keep the backend for the proxy fallback")
+			}
+		})
+	}
+}
+
+func TestGetEnabledContainersRecoversFromParsePanic(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "web",
+		apptypes.LabelTarget:  "8080",
+		apptypes.LabelDirect:  "false",
+	}
+	good, goodInspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+
+	// A truncated ID and no names make parsing index out of range
+	badLabels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "broken",
+		apptypes.LabelTarget:  "8080",
+		apptypes.LabelDirect:  "false",
+	}
+	bad, badInspect := newFakeContainer("abc", "broken", badLabels, map[string]string{"8080": "18081"})
+	bad.Names = nil
+
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{bad, good},
+		inspects: map[string]container.InspectResponse{
+			bad.ID:  badInspect,
+			good.ID: goodInspect,
+		},
+	}}
+
+	before := parseErrors.Value()
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("GetEnabledContainers() error = %v", err)
+	}
+	if len(services) != 1 || services[0].ServiceName != "web" {
+		t.Errorf("services = %+v, want only web", services)
+	}
+	if got := parseErrors.Value() - before; got != 1 {
+		t.Errorf("parse errors increased by %d, want 1", got)
+	}
+}
+
+func TestDiscoverReportsParseErrors(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "web",
+		apptypes.LabelTarget:  "8080",
+		apptypes.LabelDirect:  "false",
+	}
+	good, goodInspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+	bad, badInspect := newFakeContainer("123456789abcdef0", "nameless", map[string]string{
+		apptypes.LabelEnable: "true",
+		apptypes.LabelTarget: "8080",
+	}, nil)
+	unmanaged, unmanagedInspect := newFakeContainer("fedcba9876543210", "db", nil, nil)
+
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{good, bad, unmanaged},
+		inspects: map[string]container.InspectResponse{
+			good.ID:      goodInspect,
+			bad.ID:       badInspect,
+			unmanaged.ID: unmanagedInspect,
+		},
+	}}
+
+	discovery, err := client.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(discovery.Services) != 1 || discovery.Services[0].ServiceName != "web" {
+		t.Errorf("services = %+v, want only web", discovery.Services)
+	}
+	if len(discovery.Errors) != 1 {
+		t.Fatalf("errors = %+v, want one for nameless", discovery.Errors)
+	}
+	got := discovery.Errors[0]
+	if got.ContainerID != "123456789abc" || got.ContainerName != "nameless" || !strings.Contains(got.Err.Error(), apptypes.LabelService) {
+		t.Errorf("error = %+v, want nameless missing %s", got, apptypes.LabelService)
+	}
+}
+
+func TestParseContainerAdvertiseLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{"unset", "", false},
+		{"advertised", "true", false},
+		{"not advertised", "false", true},
+		{"invalid falls back to advertised", "maybe", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:          "true",
+				apptypes.LabelService:         "web",
+				apptypes.LabelTarget:          "8080",
+				apptypes.LabelServiceProtocol: "https",
+				apptypes.LabelRedirectHTTP:    "true",
+				apptypes.LabelDirect:          "false",
+			}
+			if tt.value != "" {
+				labels[apptypes.LabelAdvertise] = tt.value
+			}
+			_, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+			client := &Client{cli: &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}}}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if err != nil {
+				t.Fatalf("parseContainer() error = %v", err)
+			}
+			if len(services) != 2 {
+				t.Fatalf("got %d services, want 2", len(services))
+			}
+			for _, svc := range services {
+				if svc.Unadvertised != tt.want {
+					t.Errorf("%s:%s Unadvertised = %v, want %v", svc.ServiceName, svc.Port, svc.Unadvertised, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,578 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// composeFileRegex matches the compose files picked up from a directory
+var composeFileRegex = regexp.MustCompile(`^(docker-)?compose([.-].*)?\.ya?ml$`)
+
+// composePlaceholderIP stands in for container IPs, which only exist once
+// the containers run (TEST-NET-1, never routed)
+const composePlaceholderIP = "192.0.2.1"
+
+// ComposeIssue is a problem found while validating compose files
+type ComposeIssue struct {
+	File    string
+	Service string // compose service, empty for problems with the file itself
+	Warning bool
+	Message string
+}
+
+func (i ComposeIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	if i.Service == "" {
+		return fmt.Sprintf("%s: %s: %s", i.File, level, i.Message)
+	}
+	return fmt.Sprintf("%s: service %s: %s: %s", i.File, i.Service, level, i.Message)
+}
+
+// composeProject is the subset of a compose file DockTail reads
+type composeProject struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	ContainerName string          `yaml:"container_name"`
+	Image         string          `yaml:"image"`
+	Labels        composeMap      `yaml:"labels"`
+	Environment   composeMap      `yaml:"environment"`
+	Ports         []composePort   `yaml:"ports"`
+	Expose        []string        `yaml:"expose"`
+	NetworkMode   string          `yaml:"network_mode"`
+	Networks      composeNetworks `yaml:"networks"`
+}
+
+// composeMap holds labels or environment variables, written either as a
+// mapping or as a list of KEY=VALUE entries
+type composeMap map[string]string
+
+func (m *composeMap) UnmarshalYAML(node *yaml.Node) error {
+	values := make(map[string]string)
+	switch node.Kind {
+	case yaml.MappingNode:
+		var raw map[string]*string
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+		for key, value := range raw {
+			if value != nil {
+				values[key] = *value
+			}
+		}
+	case yaml.SequenceNode:
+		var entries []string
+		if err := node.Decode(&entries); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key, value, _ := strings.Cut(entry, "=")
+			values[key] = value
+		}
+	default:
+		return fmt.Errorf("line %d: expected a mapping or a list", node.Line)
+	}
+	*m = values
+	return nil
+}
+
+// composeNetworks holds the networks of a service, written either as a list
+// or as a mapping of per-network settings
+type composeNetworks []string
+
+func (n *composeNetworks) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		*n = names
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			*n = append(*n, node.Content[i].Value)
+		}
+	default:
+		return fmt.Errorf("line %d: expected a mapping or a list", node.Line)
+	}
+	return nil
+}
+
+// composePort is one entry of a service's ports, in short or long syntax
+type composePort struct {
+	spec     string
+	bindings nat.PortMap
+}
+
+func (p *composePort) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		p.spec = node.Value
+	case yaml.MappingNode:
+		var long struct {
+			Target    string `yaml:"target"`
+			Published string `yaml:"published"`
+			HostIP    string `yaml:"host_ip"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return err
+		}
+		p.spec = long.Target
+		if long.Published != "" {
+			p.spec = long.Published + ":" + p.spec
+			if long.HostIP != "" {
+				p.spec = "[" + long.HostIP + "]:" + p.spec
+			}
+		}
+		if long.Protocol != "" {
+			p.spec += "/" + long.Protocol
+		}
+	default:
+		return fmt.Errorf("line %d: expected a port mapping", node.Line)
+	}
+	return nil
+}
+
+// parseComposePort parses a port mapping such as 8080:80, 127.0.0.1:8080:80/tcp
+// or 8000-8001:8000-8001. A port published without a host port, or with host
+// port 0, is bound to the container port as a stand-in for the one Docker picks.
+func parseComposePort(spec string) (nat.PortMap, error) {
+	spec, proto, _ := strings.Cut(spec, "/")
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	var hostIP, hostPorts, containerPorts string
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		containerPorts = spec[i+1:]
+		rest := spec[:i]
+		if j := strings.LastIndex(rest, ":"); j >= 0 {
+			hostIP = strings.Trim(rest[:j], "[]")
+			hostPorts = rest[j+1:]
+		} else {
+			hostPorts = rest
+		}
+	} else {
+		containerPorts = spec
+	}
+
+	targets, err := composePortRange(containerPorts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port mapping %q: %w", spec, err)
+	}
+	published := targets
+	if hostPorts != "" && hostPorts != "0" {
+		if published, err = composePortRange(hostPorts); err != nil {
+			return nil, fmt.Errorf("invalid port mapping %q: %w", spec, err)
+		}
+		if len(published) != len(targets) {
+			// A single host port for a container range takes the first port
+			published = append(published[:1:1], targets[1:]...)
+		}
+	}
+
+	bindings := nat.PortMap{}
+	for i, target := range targets {
+		port := nat.Port(strconv.Itoa(target) + "/" + proto)
+		bindings[port] = append(bindings[port], nat.PortBinding{HostIP: hostIP, HostPort: strconv.Itoa(published[i])})
+	}
+	return bindings, nil
+}
+
+// composePortRange parses a port or a range of ports such as 8000-8010
+func composePortRange(value string) ([]int, error) {
+	first, last, isRange := strings.Cut(value, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 1 || start > 65535 {
+		return nil, fmt.Errorf("%q is not a port", value)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(last); err != nil || end < start || end > 65535 {
+			return nil, fmt.Errorf("%q is not a port range", value)
+		}
+	}
+	ports := make([]int, 0, end-start+1)
+	for port := start; port <= end; port++ {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// interpolateRegex matches compose variable references: $$, $VAR, ${VAR},
+// ${VAR:-default} and ${VAR-default}
+var interpolateRegex = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?])([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// interpolate substitutes environment variables the way compose does
+func interpolate(value string) string {
+	return interpolateRegex.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := interpolateRegex.FindStringSubmatch(match)
+		if groups[4] != "" {
+			return os.Getenv(groups[4])
+		}
+		current, set := os.LookupEnv(groups[1])
+		switch groups[2] {
+		case ":-":
+			if current == "" {
+				return groups[3]
+			}
+		case "-":
+			if !set {
+				return groups[3]
+			}
+		}
+		return current
+	})
+}
+
+// composeContainer is a compose service turned into the container it creates
+type composeContainer struct {
+	file    string
+	service string
+	summary container.Summary
+	inspect container.InspectResponse
+	issues  []ComposeIssue
+}
+
+// composeAPI serves the containers described by compose files in place of a
+// Docker daemon
+type composeAPI struct {
+	containers []container.Summary
+	inspects   map[string]container.InspectResponse
+}
+
+func (a *composeAPI) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	return a.containers, nil
+}
+
+func (a *composeAPI) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	inspect, ok := a.inspects[containerID]
+	if !ok {
+		return container.InspectResponse{}, fmt.Errorf("no such container: %s", containerID)
+	}
+	return inspect, nil
+}
+
+func (a *composeAPI) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	return network.Inspect{}, errors.New("networks are not available when validating compose files")
+}
+
+func (a *composeAPI) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	return make(chan events.Message), make(chan error)
+}
+
+func (a *composeAPI) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{}, errors.New("no Docker daemon when validating compose files")
+}
+
+func (a *composeAPI) Close() error {
+	return nil
+}
+
+// ValidateCompose checks the DockTail labels of compose files without a
+// Docker daemon. paths are compose files or directories holding them. Labels
+// go through the same parsing as running containers; a container port that
+// no ports mapping publishes is only a warning, since the port may be
+// published another way. Service names, ports and funnel ports are checked
+// across all files.
+func ValidateCompose(cfg ClientConfig, paths []string) []ComposeIssue {
+	var issues []ComposeIssue
+	var files []string
+	for _, path := range paths {
+		found, err := composeFiles(path)
+		if err != nil {
+			issues = append(issues, ComposeIssue{File: path, Message: err.Error()})
+			continue
+		}
+		files = append(files, found...)
+	}
+
+	// Containers only exist offline, so nothing may reach out to the network
+	cfg.HostGateway = ""
+	client := newHostClient(cfg, nil, "")
+	client.offline = true
+
+	api := &composeAPI{inspects: make(map[string]container.InspectResponse)}
+	byID := make(map[string]*composeContainer)
+	for _, file := range files {
+		containers, err := loadComposeFile(file, len(byID))
+		if err != nil {
+			issues = append(issues, ComposeIssue{File: file, Message: err.Error()})
+			continue
+		}
+		for _, cont := range containers {
+			client.checkComposePorts(cont)
+			client.checkComposeFunnel(cont)
+			issues = append(issues, cont.issues...)
+			api.containers = append(api.containers, cont.summary)
+			api.inspects[cont.summary.ID] = cont.inspect
+			byID[shortID(cont.summary.ID)] = cont
+		}
+	}
+	client.cli = api
+
+	discovery, err := client.discover(context.Background())
+	if err != nil {
+		return append(issues, ComposeIssue{Message: err.Error()})
+	}
+	for _, failed := range discovery.Errors {
+		cont := byID[failed.ContainerID]
+		issues = append(issues, ComposeIssue{File: cont.file, Service: cont.service, Message: failed.Err.Error()})
+	}
+	issues = append(issues, composeConflicts(discovery.Services, byID)...)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Service < issues[j].Service
+	})
+	return issues
+}
+
+// composeFiles returns path when it is a file, or the compose files in it
+// when it is a directory
+func composeFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && composeFileRegex.MatchString(entry.Name()) {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no compose files found in directory")
+	}
+	return files, nil
+}
+
+// loadComposeFile reads a compose file into the containers it creates. first
+// numbers the container IDs so they are unique across files.
+func loadComposeFile(path string, first int) ([]*composeContainer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var project composeProject
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&project); err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %w", err)
+	}
+	if project.Name == "" {
+		abs, _ := filepath.Abs(path)
+		project.Name = strings.ToLower(filepath.Base(filepath.Dir(abs)))
+	}
+
+	names := make([]string, 0, len(project.Services))
+	for name := range project.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	containers := make([]*composeContainer, 0, len(names))
+	for i, name := range names {
+		containers = append(containers, newComposeContainer(path, project.Name, name, project.Services[name], first+i))
+	}
+	return containers, nil
+}
+
+// newComposeContainer builds the container a compose service creates
+func newComposeContainer(file, projectName, serviceName string, svc composeService, index int) *composeContainer {
+	cont := &composeContainer{file: file, service: serviceName}
+
+	labels := make(map[string]string, len(svc.Labels))
+	for key, value := range svc.Labels {
+		labels[key] = interpolate(value)
+	}
+	var env []string
+	for key, value := range svc.Environment {
+		env = append(env, key+"="+interpolate(value))
+	}
+	sort.Strings(env)
+
+	name := svc.ContainerName
+	if name == "" {
+		name = projectName + "-" + serviceName + "-1"
+	}
+	// Short IDs are the first 12 characters, so the index leads
+	id := fmt.Sprintf("%012x%052x", index+1, 0)
+
+	bindings := nat.PortMap{}
+	for _, port := range svc.Ports {
+		parsed, err := parseComposePort(interpolate(port.spec))
+		if err != nil {
+			cont.issues = append(cont.issues, ComposeIssue{File: file, Service: serviceName, Message: err.Error()})
+			continue
+		}
+		for key, value := range parsed {
+			bindings[key] = append(bindings[key], value...)
+		}
+	}
+	exposed := nat.PortSet{}
+	for _, port := range svc.Expose {
+		exposed[nat.Port(interpolate(port)+"/tcp")] = struct{}{}
+	}
+
+	networks := make(map[string]*network.EndpointSettings)
+	if svc.NetworkMode == "" {
+		names := svc.Networks
+		if len(names) == 0 {
+			names = []string{"default"}
+		}
+		for _, networkName := range names {
+			networks[projectName+"_"+networkName] = &network.EndpointSettings{IPAddress: composePlaceholderIP}
+		}
+	}
+
+	cont.summary = container.Summary{ID: id, Names: []string{"/" + name}, Labels: labels}
+	cont.inspect = container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + name,
+			State:      &container.State{Running: true},
+			HostConfig: &container.HostConfig{PortBindings: bindings, NetworkMode: container.NetworkMode(svc.NetworkMode)},
+		},
+		Config:          &container.Config{Image: svc.Image, Labels: labels, Env: env, ExposedPorts: exposed},
+		NetworkSettings: &container.NetworkSettings{Networks: networks},
+	}
+	return cont
+}
+
+// checkComposePorts warns about container ports DockTail needs published that
+// no ports mapping covers, and publishes them so parsing can go on
+func (c *Client) checkComposePorts(cont *composeContainer) {
+	labels, err := c.containerLabels(context.Background(), cont.summary.ID, cont.summary.Labels)
+	if err != nil || !isManagedContainer(labels) {
+		return
+	}
+	if string(cont.inspect.HostConfig.NetworkMode) == "host" {
+		return
+	}
+	if boolLabel(labels, apptypes.LabelDirect, c.proxyMode != ProxyModeHostPort) {
+		return
+	}
+
+	var ports []string
+	for key, value := range labels {
+		if key == apptypes.LabelTarget || key == apptypes.LabelFunnelPort || indexedPortRegex.MatchString(key) && strings.HasSuffix(key, ".port") {
+			ports = append(ports, strings.TrimSpace(value))
+		}
+	}
+	sort.Strings(ports)
+
+	bindings := cont.inspect.HostConfig.PortBindings
+	for _, port := range ports {
+		if _, err := strconv.Atoi(port); err != nil {
+			continue
+		}
+		key := nat.Port(port + "/tcp")
+		if _, ok := selectPortBinding(bindings[key]); ok {
+			continue
+		}
+		cont.issues = append(cont.issues, ComposeIssue{
+			File:    cont.file,
+			Service: cont.service,
+			Warning: true,
+			Message: fmt.Sprintf("container port %s is not published by a ports mapping, which docktail.service.direct=false needs", port),
+		})
+		bindings[key] = []nat.PortBinding{{HostPort: port}}
+	}
+}
+
+// checkComposeFunnel warns about funnels left disabled because they lack
+// docktail.funnel.confirm=true
+func (c *Client) checkComposeFunnel(cont *composeContainer) {
+	labels, err := c.containerLabels(context.Background(), cont.summary.ID, cont.summary.Labels)
+	if err != nil || !isFunnelEnabled(labels) || boolLabel(labels, apptypes.LabelFunnelConfirm, false) {
+		return
+	}
+	cont.issues = append(cont.issues, ComposeIssue{
+		File:    cont.file,
+		Service: cont.service,
+		Warning: true,
+		Message: fmt.Sprintf("funnel is not enabled without %s=true", apptypes.LabelFunnelConfirm),
+	})
+}
+
+// composeConflicts reports endpoints and funnel ports that several compose
+// services claim, since only one of them would be served
+func composeConflicts(services []*apptypes.ContainerService, byID map[string]*composeContainer) []ComposeIssue {
+	var issues []ComposeIssue
+	endpoints := make(map[string]*composeContainer)
+	funnels := make(map[string]*composeContainer)
+	conflict := func(claimed map[string]*composeContainer, key, what string, cont *composeContainer) {
+		first, ok := claimed[key]
+		if !ok {
+			claimed[key] = cont
+			return
+		}
+		if first == cont {
+			return
+		}
+		issues = append(issues, ComposeIssue{
+			File:    cont.file,
+			Service: cont.service,
+			Message: fmt.Sprintf("%s is also used by service %s in %s", what, first.service, first.file),
+		})
+	}
+
+	for _, svc := range services {
+		cont := byID[svc.ContainerID]
+		if cont == nil {
+			continue
+		}
+		if svc.ServiceEnabled {
+			path := svc.Path
+			if path == "" {
+				path = "/"
+			}
+			what := fmt.Sprintf("svc:%s port %s", svc.ServiceName, svc.Port)
+			if path != "/" {
+				what += " path " + path
+			}
+			conflict(endpoints, svc.ServiceName+":"+svc.Port+path, what, cont)
+		}
+		if svc.FunnelEnabled {
+			conflict(funnels, svc.Socket+":"+svc.FunnelFunnelPort, "funnel port "+svc.FunnelFunnelPort, cont)
+		}
+	}
+	return issues
+}
@@ -0,0 +1,137 @@
+package docker
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestValidateComposeGolden(t *testing.T) {
+	t.Setenv("API_NAME", "")
+
+	dirs, err := filepath.Glob(filepath.Join("testdata", "compose", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			var got strings.Builder
+			for _, issue := range ValidateCompose(ClientConfig{}, []string{dir}) {
+				got.WriteString(filepath.ToSlash(issue.String()) + "\n")
+			}
+
+			golden := dir + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("issues differ from %s:\ngot:\n%s\nwant:\n%s", golden, got.String(), want)
+			}
+		})
+	}
+}
+
+func TestValidateComposeMissingPath(t *testing.T) {
+	issues := ValidateCompose(ClientConfig{}, []string{filepath.Join("testdata", "compose", "missing.yaml")})
+	if len(issues) != 1 || issues[0].Warning || issues[0].Service != "" {
+		t.Fatalf("expected a single file error, got %v", issues)
+	}
+}
+
+func TestParseComposePort(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    nat.PortMap
+		wantErr bool
+	}{
+		{
+			spec: "8080:80",
+			want: nat.PortMap{"80/tcp": {{HostPort: "8080"}}},
+		},
+		{
+			spec: "127.0.0.1:8080:80/tcp",
+			want: nat.PortMap{"80/tcp": {{HostIP: "127.0.0.1", HostPort: "8080"}}},
+		},
+		{
+			spec: "[::1]:8080:80",
+			want: nat.PortMap{"80/tcp": {{HostIP: "::1", HostPort: "8080"}}},
+		},
+		{
+			spec: "53:53/udp",
+			want: nat.PortMap{"53/udp": {{HostPort: "53"}}},
+		},
+		{
+			spec: "9000",
+			want: nat.PortMap{"9000/tcp": {{HostPort: "9000"}}},
+		},
+		{
+			spec: "127.0.0.1:0:9000",
+			want: nat.PortMap{"9000/tcp": {{HostIP: "127.0.0.1", HostPort: "9000"}}},
+		},
+		{
+			spec: "8000-8001:9000-9001",
+			want: nat.PortMap{
+				"9000/tcp": {{HostPort: "8000"}},
+				"9001/tcp": {{HostPort: "8001"}},
+			},
+		},
+		{spec: "http:80", wantErr: true},
+		{spec: "8080:70000", wantErr: true},
+		{spec: "9001-9000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseComposePort(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseComposePort(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseComposePort(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("DOCKTAIL_TEST_SET", "value")
+	t.Setenv("DOCKTAIL_TEST_EMPTY", "")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"$DOCKTAIL_TEST_SET", "value"},
+		{"${DOCKTAIL_TEST_SET}-suffix", "value-suffix"},
+		{"${DOCKTAIL_TEST_UNSET:-default}", "default"},
+		{"${DOCKTAIL_TEST_EMPTY:-default}", "default"},
+		{"${DOCKTAIL_TEST_EMPTY-default}", ""},
+		{"${DOCKTAIL_TEST_UNSET-default}", "default"},
+		{"$${DOCKTAIL_TEST_SET}", "${DOCKTAIL_TEST_SET}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := interpolate(tt.value); got != tt.want {
+				t.Errorf("interpolate(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// envConfigLabels are the labels that can also be set through container
+// environment variables when READ_ENV_CONFIG is enabled
+var envConfigLabels = []string{
+	apptypes.LabelEnable, apptypes.LabelService, apptypes.LabelPort, apptypes.LabelServiceProtocol,
+	apptypes.LabelTarget, apptypes.LabelTargetProtocol, apptypes.LabelInsecureSkipVerify,
+	apptypes.LabelProxyProtocol, apptypes.LabelTags, apptypes.LabelFunnelEnable, apptypes.LabelFunnelPort,
+	apptypes.LabelFunnelFunnelPort, apptypes.LabelFunnelProtocol, apptypes.LabelFunnelTargetProtocol,
+	apptypes.LabelDirect, apptypes.LabelNetwork, apptypes.LabelPath, apptypes.LabelDestination,
+	apptypes.LabelHostGateway, apptypes.LabelServeText, apptypes.LabelServeFile, apptypes.LabelAliases,
+	apptypes.LabelRedirectHTTP, apptypes.LabelScaleMode, apptypes.LabelAdvertise,
+	apptypes.LabelRemoveOnPause, apptypes.LabelSocket, apptypes.LabelAllPorts,
+	apptypes.LabelReadinessPath, apptypes.LabelReadinessTimeout, apptypes.LabelReadinessStatus, apptypes.LabelRole,
+	apptypes.LabelStartupDelay,
+}
+
+// indexedEnvSuffixes are the label suffixes of indexed services
+// (docktail.service.<n>.<suffix>) that can be set through the environment
+var indexedEnvSuffixes = []string{
+	"name", "port", "protocol", "proxy-protocol", "insecure-skip-verify",
+	"service-port", "service-protocol", "serve-text", "serve-file",
+}
+
+// envNameReplacer turns a label key into an environment variable name
+var envNameReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// labelEnvName returns the environment variable that stands in for a label,
+// e.g. docktail.service.service-port -> DOCKTAIL_SERVICE_SERVICE_PORT
+func labelEnvName(label string) string {
+	return strings.ToUpper(envNameReplacer.Replace(label))
+}
+
+// envLabels maps environment variable names to the labels they stand in for
+var envLabels, indexedEnvLabels = func() (map[string]string, map[string]string) {
+	fixed := make(map[string]string, len(envConfigLabels))
+	for _, label := range envConfigLabels {
+		fixed[labelEnvName(label)] = label
+	}
+	indexed := make(map[string]string, len(indexedEnvSuffixes))
+	for _, suffix := range indexedEnvSuffixes {
+		indexed[labelEnvName(suffix)] = suffix
+	}
+	return fixed, indexed
+}()
+
+// indexedEnvRegex matches environment variables of indexed services
+var indexedEnvRegex = regexp.MustCompile(`^DOCKTAIL_SERVICE_(\d+)_([A-Z_]+)$`)
+
+// envLabel returns the label an environment variable stands in for, or ""
+func envLabel(name string) string {
+	if label, ok := envLabels[name]; ok {
+		return label
+	}
+	if matches := indexedEnvRegex.FindStringSubmatch(name); matches != nil {
+		if suffix, ok := indexedEnvLabels[matches[2]]; ok {
+			return "docktail.service." + matches[1] + "." + suffix
+		}
+	}
+	return ""
+}
+
+// labelsWithEnv returns the labels merged with the DockTail settings found in
+// a container's environment (KEY=value entries). Labels take precedence.
+func labelsWithEnv(labels map[string]string, env []string) map[string]string {
+	merged := make(map[string]string, len(labels))
+	for key, value := range labels {
+		merged[key] = value
+	}
+	for _, entry := range env {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		label := envLabel(name)
+		if label == "" {
+			continue
+		}
+		if _, set := merged[label]; !set {
+			merged[label] = value
+		}
+	}
+	return merged
+}
+
+// containerLabels returns the labels DockTail reads for a container, including
+// its environment when READ_ENV_CONFIG is enabled
+func (c *Client) containerLabels(ctx context.Context, containerID string, labels map[string]string) (map[string]string, error) {
+	if !c.readEnvConfig {
+		return labels, nil
+	}
+
+	inspect, err := c.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.Config == nil {
+		return labels, nil
+	}
+	return labelsWithEnv(labels, inspect.Config.Env), nil
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestEnvLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"DOCKTAIL_SERVICE_NAME", apptypes.LabelService},
+		{"DOCKTAIL_SERVICE_SERVICE_PORT", apptypes.LabelPort},
+		{"DOCKTAIL_SERVICE_ENABLE", apptypes.LabelEnable},
+		{"DOCKTAIL_FUNNEL_FUNNEL_PORT", apptypes.LabelFunnelFunnelPort},
+		{"DOCKTAIL_TAGS", apptypes.LabelTags},
+		{"DOCKTAIL_SERVICE_1_SERVICE_PROTOCOL", "docktail.service.1.service-protocol"},
+		{"DOCKTAIL_SERVICE_12_NAME", "docktail.service.12.name"},
+		{"DOCKTAIL_SERVICE_1_UNKNOWN", ""},
+		{"docktail_service_name", ""},
+		{"PATH", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envLabel(tt.name); got != tt.want {
+				t.Errorf("envLabel(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelsWithEnv(t *testing.T) {
+	labels := map[string]string{apptypes.LabelService: "from-label"}
+	env := []string{
+		"DOCKTAIL_SERVICE_NAME=from-env",
+		"DOCKTAIL_SERVICE_PORT=8080",
+		"DOCKTAIL_SERVICE_PATH=/a=b",
+		"PATH=/usr/bin",
+		"MALFORMED",
+	}
+
+	got := labelsWithEnv(labels, env)
+	want := map[string]string{
+		apptypes.LabelService: "from-label",
+		apptypes.LabelTarget:  "8080",
+		apptypes.LabelPath:    "/a=b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("labelsWithEnv() = %v, want %v", got, want)
+	}
+	if len(labels) != 1 {
+		t.Error("labelsWithEnv modified the container's labels")
+	}
+}
+
+func TestGetEnabledContainersReadEnvConfig(t *testing.T) {
+	labels := map[string]string{apptypes.LabelTarget: "9000"}
+	summary, inspect := newFakeContainer("abcdef1234567890", "app", labels, map[string]string{"8080": "18080", "9000": "19000"})
+	inspect.Config.Env = []string{
+		"DOCKTAIL_SERVICE_ENABLE=true",
+		"DOCKTAIL_SERVICE_NAME=app",
+		"DOCKTAIL_SERVICE_PORT=8080",
+		"DOCKTAIL_SERVICE_DIRECT=false",
+		"DOCKTAIL_TAGS=tag:env",
+	}
+	api := &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}
+
+	tests := []struct {
+		name          string
+		readEnvConfig bool
+		wantServices  int
+	}{
+		{"disabled", false, 0},
+		{"enabled", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{cli: api, readEnvConfig: tt.readEnvConfig}
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(services) != tt.wantServices {
+				t.Fatalf("got %d services, want %d", len(services), tt.wantServices)
+			}
+			if tt.wantServices == 0 {
+				return
+			}
+
+			svc := services[0]
+			if svc.ServiceName != "app" || !reflect.DeepEqual(svc.Tags, []string{"tag:env"}) {
+				t.Errorf("service = %s %v, want app [tag:env]", svc.ServiceName, svc.Tags)
+			}
+			// The label wins over DOCKTAIL_SERVICE_PORT
+			if svc.TargetPort != "19000" {
+				t.Errorf("target port = %s, want the published port of the labeled 9000", svc.TargetPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRepeatedErrorWindow is how long repeats of a container error are
+// suppressed when LOG_REPEAT_WINDOW is not set
+const DefaultRepeatedErrorWindow = 10 * time.Minute
+
+// errorLogAction tells the caller of errorLog.observe what to log
+type errorLogAction int
+
+const (
+	errorLogFirst    errorLogAction = iota // First occurrence: log it in full
+	errorLogSuppress                       // Repeat within the window: log nothing
+	errorLogSummary                        // The window elapsed: log that it still fails
+)
+
+// errorLog rate-limits warnings about containers that fail the same way every
+// cycle. The first occurrence of an error is logged, repeats are counted, and
+// once per window a summary says the container still fails. Errors that stop
+// occurring are forgotten, so a recurrence is logged in full again.
+type errorLog struct {
+	// window is how long repeats are suppressed, zero logs every occurrence
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[errorLogKey]*errorLogEntry
+}
+
+// errorLogKey identifies an error of a container
+type errorLogKey struct {
+	containerID string
+	message     string
+}
+
+type errorLogEntry struct {
+	containerName string
+	count         int       // occurrences since the error first occurred
+	lastLogged    time.Time // when the error or its summary was last logged
+	lastSeen      time.Time
+}
+
+func newErrorLog(window time.Duration) *errorLog {
+	return &errorLog{window: window, now: time.Now, entries: make(map[errorLogKey]*errorLogEntry)}
+}
+
+// cycleStart returns the start of a discovery, to pass to resolve
+func (l *errorLog) cycleStart() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	return l.now()
+}
+
+// observe records an occurrence of err for a container and returns what to log
+// along with how often the error occurred so far
+func (l *errorLog) observe(containerID, containerName string, err error) (errorLogAction, int) {
+	if l == nil || l.window <= 0 {
+		return errorLogFirst, 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	key := errorLogKey{containerID: containerID, message: err.Error()}
+	entry, ok := l.entries[key]
+	if !ok {
+		l.entries[key] = &errorLogEntry{containerName: containerName, count: 1, lastLogged: now, lastSeen: now}
+		return errorLogFirst, 1
+	}
+
+	entry.count++
+	entry.lastSeen = now
+	if now.Sub(entry.lastLogged) < l.window {
+		return errorLogSuppress, entry.count
+	}
+	entry.lastLogged = now
+	return errorLogSummary, entry.count
+}
+
+// resolve forgets the errors not observed since the discovery that started at
+// since, logging that they no longer occur
+func (l *errorLog) resolve(since time.Time) {
+	if l == nil || l.window <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, entry := range l.entries {
+		if !entry.lastSeen.Before(since) {
+			continue
+		}
+		delete(l.entries, key)
+		log.Info().
+			Str("container_id", shortID(key.containerID)).
+			Str("container_name", entry.containerName).
+			Str("error", key.message).
+			Int("occurrences", entry.count).
+			Msg("Container error no longer occurs")
+	}
+}
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorLogEmissionPattern(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	l := newErrorLog(10 * time.Minute)
+	l.now = func() time.Time { return now }
+
+	errPort := errors.New("container port 8080 is NOT published to host")
+	errLabel := errors.New("invalid docktail.service.port")
+
+	type step struct {
+		advance     time.Duration
+		err         error // observed during the cycle, nil for a clean cycle
+		wantAction  errorLogAction
+		wantCount   int
+		wantEntries int // after the cycle
+	}
+	steps := []step{
+		{err: errPort, wantAction: errorLogFirst, wantCount: 1, wantEntries: 1},
+		{advance: time.Minute, err: errPort, wantAction: errorLogSuppress, wantCount: 2, wantEntries: 1},
+		{advance: 8 * time.Minute, err: errPort, wantAction: errorLogSuppress, wantCount: 3, wantEntries: 1},
+		// The window elapsed since the first occurrence was logged
+		{advance: time.Minute, err: errPort, wantAction: errorLogSummary, wantCount: 4, wantEntries: 1},
+		{advance: time.Minute, err: errPort, wantAction: errorLogSuppress, wantCount: 5, wantEntries: 1},
+		// A different error of the same container is logged on its own; the old one is resolved
+		{advance: time.Minute, err: errLabel, wantAction: errorLogFirst, wantCount: 1, wantEntries: 1},
+		// The container recovers, clearing the state
+		{advance: time.Minute, wantEntries: 0},
+		// So a recurrence is logged in full
+		{advance: time.Minute, err: errLabel, wantAction: errorLogFirst, wantCount: 1, wantEntries: 1},
+	}
+
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		since := l.cycleStart()
+		if s.err != nil {
+			action, count := l.observe("abcdef1234567890", "web", s.err)
+			if action != s.wantAction || count != s.wantCount {
+				t.Errorf("step %d: observe() = %d, %d, want %d, %d", i, action, count, s.wantAction, s.wantCount)
+			}
+		}
+		l.resolve(since)
+		if len(l.entries) != s.wantEntries {
+			t.Errorf("step %d: %d errors tracked, want %d", i, len(l.entries), s.wantEntries)
+		}
+	}
+}
+
+func TestErrorLogDisabled(t *testing.T) {
+	for _, l := range []*errorLog{nil, newErrorLog(0)} {
+		for i := 0; i < 3; i++ {
+			if action, _ := l.observe("abcdef1234567890", "web", errors.New("boom")); action != errorLogFirst {
+				t.Errorf("observe() = %d, want every occurrence logged", action)
+			}
+		}
+	}
+}
+
+func TestErrorLogKeysByContainer(t *testing.T) {
+	l := newErrorLog(time.Hour)
+	err := errors.New("container port 8080 is NOT published to host")
+
+	if action, _ := l.observe("aaaaaaaaaaaa", "web-1", err); action != errorLogFirst {
+		t.Errorf("first container: observe() = %d, want errorLogFirst", action)
+	}
+	if action, _ := l.observe("bbbbbbbbbbbb", "web-2", err); action != errorLogFirst {
+		t.Errorf("second container: observe() = %d, want errorLogFirst", action)
+	}
+}
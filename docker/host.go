@@ -0,0 +1,150 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types/network"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Sources of the address used for ports on the Docker host, as logged per service
+const (
+	hostSourceLocalhost = "localhost"
+	hostSourceGateway   = "host-gateway"
+	hostSourceLabel     = "label"
+)
+
+// localhost returns the address of the Docker host for a DockTail on that
+// host: PROXY_BIND_ADDRESS, or localhost
+func (c *Client) localhost() string {
+	if c.bindAddress != "" {
+		return c.bindAddress
+	}
+	return "localhost"
+}
+
+// ParseBindAddress validates a PROXY_BIND_ADDRESS value. Unless remote
+// destinations are allowed (ALLOW_DESTINATION_OVERRIDE), it must be localhost
+// or a loopback IP, so it can't point every service at another host.
+func ParseBindAddress(value string, allowRemote bool) (string, error) {
+	addr := strings.TrimSpace(value)
+	if addr == "" || strings.EqualFold(addr, "localhost") {
+		return addr, nil
+	}
+
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	switch {
+	case ip != nil && ip.IsLoopback():
+		return ip.String(), nil
+	case ip == nil && strings.ContainsAny(addr, ":/ \t"):
+		return "", fmt.Errorf("%q must be a host name or IP address, without a port", value)
+	case !allowRemote:
+		return "", fmt.Errorf("%q is not a loopback address; set ALLOW_DESTINATION_OVERRIDE=true to proxy to other addresses", value)
+	case ip != nil:
+		return ip.String(), nil
+	}
+	return addr, nil
+}
+
+// hostAddress returns the address DockTail proxies to for ports on the Docker
+// host (published ports and host-network containers) and where it came from.
+// tailscaled dials it, and in both supported setups (Tailscale on the host, or
+// a sidecar with network_mode: host) tailscaled shares the host's network, so
+// it is localhost, or PROXY_BIND_ADDRESS. The docktail.service.host-gateway
+// label wins, then HOST_GATEWAY for a tailscaled with a network of its own.
+// Containers on a remote DOCKER_HOST are reached through that host's address.
+func (c *Client) hostAddress(ctx context.Context, containerName string, labels map[string]string) (string, string) {
+	if override := strings.TrimSpace(labels[apptypes.LabelHostGateway]); override != "" {
+		return override, hostSourceLabel
+	}
+	if c.remoteAddress != "" {
+		return c.remoteAddress, hostSourceDockerHost
+	}
+	if c.hostGateway == "" {
+		return c.localhost(), hostSourceLocalhost
+	}
+
+	gateway, err := c.resolveHostGateway(ctx)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("container", containerName).
+			Msg("Could not resolve the Docker host gateway, falling back to localhost")
+		return c.localhost(), hostSourceLocalhost
+	}
+	return gateway, hostSourceGateway
+}
+
+// resolveHostGateway resolves HOST_GATEWAY to an IP address, falling back to
+// the gateway of the default bridge network. Successful lookups are cached.
+func (c *Client) resolveHostGateway(ctx context.Context) (string, error) {
+	c.gatewayMu.Lock()
+	defer c.gatewayMu.Unlock()
+
+	if c.gatewayAddr != "" {
+		return c.gatewayAddr, nil
+	}
+
+	gateway := c.hostGateway
+
+	// Resolve to an IP so the destination works for tailscaled even when it
+	// can't resolve container-only names like host.docker.internal
+	if ip := net.ParseIP(gateway); ip != nil {
+		c.gatewayAddr = gateway
+		return c.gatewayAddr, nil
+	}
+
+	lookup := c.lookupHost
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	addrs, lookupErr := lookup(ctx, gateway)
+	if lookupErr == nil && len(addrs) > 0 {
+		c.gatewayAddr = preferIPv4(addrs)
+		log.Info().
+			Str("host_gateway", gateway).
+			Str("address", c.gatewayAddr).
+			Msg("Resolved Docker host gateway")
+		return c.gatewayAddr, nil
+	}
+
+	bridge, err := c.bridgeGateway(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s (%v) and to find the bridge gateway: %w", gateway, lookupErr, err)
+	}
+
+	c.gatewayAddr = bridge
+	log.Info().
+		Str("host_gateway", gateway).
+		Str("address", bridge).
+		Msg("Host gateway name does not resolve, using the Docker bridge gateway")
+	return c.gatewayAddr, nil
+}
+
+// bridgeGateway returns the gateway IP of Docker's default bridge network
+func (c *Client) bridgeGateway(ctx context.Context) (string, error) {
+	bridge, err := c.cli.NetworkInspect(ctx, "bridge", network.InspectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect bridge network: %w", err)
+	}
+	for _, cfg := range bridge.IPAM.Config {
+		if ip := net.ParseIP(cfg.Gateway); ip != nil && ip.To4() != nil {
+			return cfg.Gateway, nil
+		}
+	}
+	return "", fmt.Errorf("bridge network has no IPv4 gateway")
+}
+
+// preferIPv4 returns the first IPv4 address, or the first address if there is none
+func preferIPv4(addrs []string) string {
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr
+		}
+	}
+	return addrs[0]
+}
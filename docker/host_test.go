@@ -0,0 +1,197 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func bridgeNetwork(gateways ...string) map[string]network.Inspect {
+	var configs []network.IPAMConfig
+	for _, gateway := range gateways {
+		configs = append(configs, network.IPAMConfig{Gateway: gateway})
+	}
+	return map[string]network.Inspect{"bridge": {Name: "bridge", IPAM: network.IPAM{Config: configs}}}
+}
+
+func TestResolveHostGateway(t *testing.T) {
+	resolves := func(addrs ...string) func(context.Context, string) ([]string, error) {
+		return func(context.Context, string) ([]string, error) { return addrs, nil }
+	}
+	fails := func(context.Context, string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		name        string
+		hostGateway string
+		lookup      func(context.Context, string) ([]string, error)
+		networks    map[string]network.Inspect
+		expected    string
+		wantError   bool
+	}{
+		{"configured IP", "192.168.1.10", fails, nil, "192.168.1.10", false},
+		{"name resolves", "host.docker.internal", resolves("fd00::1", "192.168.65.254"), bridgeNetwork("172.17.0.1"), "192.168.65.254", false},
+		{"falls back to bridge gateway", "host.docker.internal", fails, bridgeNetwork("fd00::1", "172.17.0.1"), "172.17.0.1", false},
+		{"custom name falls back to bridge gateway", "docker-host", resolves(), bridgeNetwork("172.18.0.1"), "172.18.0.1", false},
+		{"no bridge network", "host.docker.internal", fails, nil, "", true},
+		{"bridge without gateway", "host.docker.internal", fails, bridgeNetwork(""), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{
+				cli:         &fakeDockerAPI{networks: tt.networks},
+				hostGateway: tt.hostGateway,
+				lookupHost:  tt.lookup,
+			}
+
+			got, err := client.resolveHostGateway(context.Background())
+			if (err != nil) != tt.wantError {
+				t.Fatalf("resolveHostGateway() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.expected {
+				t.Errorf("resolveHostGateway() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHostGatewayDestinations(t *testing.T) {
+	resolvesTo := func(context.Context, string) ([]string, error) {
+		return []string{"192.168.65.254"}, nil
+	}
+
+	tests := []struct {
+		name        string
+		hostGateway string
+		override    string
+		hostIP      string
+		hostNetwork bool
+		expected    string
+	}{
+		{"localhost by default", "", "", "0.0.0.0", false, "localhost"},
+		{"host network container uses localhost by default", "", "", "", true, "localhost"},
+		{"gateway when set", "host.docker.internal", "", "0.0.0.0", false, "192.168.65.254"},
+		{"gateway for IPv6 wildcard", "host.docker.internal", "", "::", false, "192.168.65.254"},
+		{"explicit binding is kept", "host.docker.internal", "", "10.0.0.5", false, "10.0.0.5"},
+		{"loopback binding is kept", "host.docker.internal", "", "127.0.0.1", false, "127.0.0.1"},
+		{"label overrides gateway", "host.docker.internal", "localhost", "0.0.0.0", false, "localhost"},
+		{"label applies without gateway", "", "172.17.0.1", "0.0.0.0", false, "172.17.0.1"},
+		{"host network container uses gateway", "host.docker.internal", "", "", true, "192.168.65.254"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+				apptypes.LabelTarget:  "8080",
+				apptypes.LabelDirect:  "false",
+			}
+			if tt.override != "" {
+				labels[apptypes.LabelHostGateway] = tt.override
+			}
+			summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080"})
+			inspect.HostConfig.PortBindings["8080/tcp"][0].HostIP = tt.hostIP
+			if tt.hostNetwork {
+				inspect.HostConfig.NetworkMode = container.NetworkMode("host")
+			}
+
+			client := &Client{
+				cli: &fakeDockerAPI{
+					containers: []container.Summary{summary},
+					inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+				},
+				hostGateway: tt.hostGateway,
+				lookupHost:  resolvesTo,
+			}
+
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("GetEnabledContainers() error = %v", err)
+			}
+			if len(services) != 1 {
+				t.Fatalf("got %d services, want 1", len(services))
+			}
+			if services[0].IPAddress != tt.expected {
+				t.Errorf("IPAddress = %q, want %q", services[0].IPAddress, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProxyBindAddressDestinations(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostIP      string
+		hostNetwork bool
+		expected    string
+	}{
+		{"wildcard binding", "0.0.0.0", false, "127.0.0.2"},
+		{"host network container", "", true, "127.0.0.2"},
+		{"explicit binding is kept", "127.0.0.1", false, "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, inspect := newFakeContainer("abcdef1234567890", "web", map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+				apptypes.LabelTarget:  "8080",
+				apptypes.LabelDirect:  "false",
+			}, map[string]string{"8080": "18080"})
+			inspect.HostConfig.PortBindings["8080/tcp"][0].HostIP = tt.hostIP
+			if tt.hostNetwork {
+				inspect.HostConfig.NetworkMode = container.NetworkMode("host")
+			}
+
+			client := &Client{
+				cli: &fakeDockerAPI{
+					containers: []container.Summary{summary},
+					inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+				},
+				bindAddress: "127.0.0.2",
+			}
+
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("GetEnabledContainers() error = %v", err)
+			}
+			if len(services) != 1 || services[0].IPAddress != tt.expected {
+				t.Fatalf("services = %+v, want IPAddress %q", services, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseBindAddress(t *testing.T) {
+	tests := []struct {
+		value       string
+		allowRemote bool
+		want        string
+		wantErr     bool
+	}{
+		{value: "", want: ""},
+		{value: "localhost", want: "localhost"},
+		{value: " 127.0.0.2 ", want: "127.0.0.2"},
+		{value: "[::1]", want: "::1"},
+		{value: "192.168.1.10", wantErr: true},
+		{value: "backend.lan", wantErr: true},
+		{value: "192.168.1.10", allowRemote: true, want: "192.168.1.10"},
+		{value: "backend.lan", allowRemote: true, want: "backend.lan"},
+		{value: "127.0.0.1:8080", allowRemote: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBindAddress(tt.value, tt.allowRemote)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBindAddress(%q, %v) = %q, %v, want %q, error %v", tt.value, tt.allowRemote, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
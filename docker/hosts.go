@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// hostSourceDockerHost marks ports reached through the address of a remote DOCKER_HOST
+const hostSourceDockerHost = "docker-host"
+
+// ParseDockerHosts splits a comma-separated DOCKER_HOST value into endpoints,
+// dropping empty entries and duplicates
+func ParseDockerHosts(value string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		host := strings.TrimSpace(part)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// remoteHostAddress returns the host name of a network Docker endpoint such as
+// tcp://node1:2376 or ssh://user@node1, or "" for local sockets
+func remoteHostAddress(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https", "ssh":
+		return u.Hostname()
+	default:
+		return ""
+	}
+}
+
+// newHostClient creates the client for a single Docker endpoint. endpoint is
+// empty when DockTail watches only the daemon configured by the environment.
+func newHostClient(cfg ClientConfig, cli dockerAPI, endpoint string) *Client {
+	return &Client{
+		cli:                      cli,
+		endpoint:                 endpoint,
+		remoteAddress:            remoteHostAddress(endpoint),
+		defaultTags:              cfg.DefaultTags,
+		normalizeServiceNames:    cfg.NormalizeServiceNames,
+		serviceNamePrefix:        cfg.ServiceNamePrefix,
+		readEnvConfig:            cfg.ReadEnvConfig,
+		autoTargetPort:           cfg.AutoTargetPort,
+		watchedEvents:            cfg.WatchedEvents,
+		imageAllowlist:           cfg.ImageAllowlist,
+		proxyMode:                cfg.ProxyMode,
+		defaultNetwork:           cfg.DefaultNetwork,
+		hostGateway:              cfg.HostGateway,
+		bindAddress:              cfg.ProxyBindAddress,
+		allowDestinationOverride: cfg.AllowDestinationOverride,
+		errorLog:                 newErrorLog(cfg.RepeatedErrorWindow),
+	}
+}
+
+// discoverHosts aggregates the discovery of every Docker host. A host that
+// can't be listed fails the whole call, so its services aren't removed while
+// it is unreachable.
+func (c *Client) discoverHosts(ctx context.Context) (Discovery, error) {
+	var discovery Discovery
+	for _, host := range c.hosts {
+		hostDiscovery, err := host.discover(ctx)
+		if err != nil {
+			return Discovery{}, fmt.Errorf("docker host %s: %w", host.endpoint, err)
+		}
+		discovery.Services = append(discovery.Services, hostDiscovery.Services...)
+		discovery.Errors = append(discovery.Errors, hostDiscovery.Errors...)
+		discovery.Skipped = append(discovery.Skipped, hostDiscovery.Skipped...)
+	}
+	return discovery, nil
+}
+
+// HostPing is the answer of one Docker host to a ping
+type HostPing struct {
+	Endpoint   string // empty for the host configured from the environment
+	APIVersion string
+	Err        error
+}
+
+// Ping checks that every Docker host answers
+func (c *Client) Ping(ctx context.Context) []HostPing {
+	if len(c.hosts) > 0 {
+		var pings []HostPing
+		for _, host := range c.hosts {
+			pings = append(pings, host.Ping(ctx)...)
+		}
+		return pings
+	}
+	ping, err := c.cli.Ping(ctx)
+	return []HostPing{{Endpoint: c.endpoint, APIVersion: ping.APIVersion, Err: err}}
+}
+
+// watchHostEvents merges the event streams of every Docker host. The first
+// stream error stops the other streams and is reported once, so the caller can
+// restart watching all hosts.
+func (c *Client) watchHostEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	ctx, cancel := context.WithCancel(ctx)
+	merged := make(chan events.Message)
+	errs := make(chan error, 1)
+
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			errs <- err
+			cancel()
+		})
+	}
+
+	for _, host := range c.hosts {
+		eventsChan, errChan := host.WatchEvents(ctx)
+		go func(host *Client) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err := <-errChan:
+					if err != nil {
+						fail(fmt.Errorf("docker host %s: %w", host.endpoint, err))
+					}
+					return
+				case event := <-eventsChan:
+					select {
+					case merged <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(host)
+	}
+
+	return merged, errs
+}
+
+// closeHosts closes the clients of every Docker host
+func (c *Client) closeHosts() error {
+	var errs []error
+	for _, host := range c.hosts {
+		if err := host.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("docker host %s: %w", host.endpoint, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestParseDockerHosts(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"unset", "", nil},
+		{"single host", "unix:///var/run/docker.sock", []string{"unix:///var/run/docker.sock"}},
+		{"list", "tcp://node1:2376, tcp://node2:2376", []string{"tcp://node1:2376", "tcp://node2:2376"}},
+		{"duplicates and empty entries", "tcp://node1:2376,,tcp://node1:2376", []string{"tcp://node1:2376"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseDockerHosts(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDockerHosts(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteHostAddress(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"tcp://node1:2376", "node1"},
+		{"ssh://deploy@node2", "node2"},
+		{"tcp://10.0.0.5:2375", "10.0.0.5"},
+		{"unix:///var/run/docker.sock", ""},
+		{"npipe:////./pipe/docker_engine", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			if got := remoteHostAddress(tt.endpoint); got != tt.want {
+				t.Errorf("remoteHostAddress(%q) = %q, want %q", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnabledContainersMultipleHosts(t *testing.T) {
+	newHost := func(endpoint, id, name string) *Client {
+		labels := map[string]string{
+			apptypes.LabelEnable:  "true",
+			apptypes.LabelService: name,
+			apptypes.LabelTarget:  "8080",
+			apptypes.LabelDirect:  "false",
+		}
+		summary, inspect := newFakeContainer(id, name, labels, map[string]string{"8080": "18080"})
+		return newHostClient(ClientConfig{}, &fakeDockerAPI{
+			containers: []container.Summary{summary},
+			inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+		}, endpoint)
+	}
+
+	client := &Client{hosts: []*Client{
+		newHost("tcp://node1:2376", "aaaaaaaaaaaa1111", "web"),
+		newHost("tcp://node2:2376", "bbbbbbbbbbbb2222", "api"),
+	}}
+
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("got %d services, want 2", len(services))
+	}
+
+	want := []struct{ service, host, ip string }{
+		{"web", "tcp://node1:2376", "node1"},
+		{"api", "tcp://node2:2376", "node2"},
+	}
+	for i, w := range want {
+		svc := services[i]
+		if svc.ServiceName != w.service || svc.DockerHost != w.host {
+			t.Errorf("service %d = %s on %q, want %s on %q", i, svc.ServiceName, svc.DockerHost, w.service, w.host)
+		}
+		if svc.IPAddress != w.ip || svc.TargetPort != "18080" {
+			t.Errorf("service %s backend = %s:%s, want %s:18080", svc.ServiceName, svc.IPAddress, svc.TargetPort, w.ip)
+		}
+	}
+}
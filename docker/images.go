@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// ParseImageAllowlist parses a comma-separated list of image reference globs
+// (e.g. "ghcr.io/acme/*,nginx"), rejecting malformed patterns
+func ParseImageAllowlist(value string) ([]string, error) {
+	var patterns []string
+	for _, part := range strings.Split(value, ",") {
+		pattern := strings.TrimSpace(part)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid image pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// imageAllowed reports whether an image reference matches one of the patterns.
+// Patterns are matched against the full reference and against the reference
+// without its tag or digest, so "nginx" allows "nginx:alpine". An empty
+// allowlist allows every image.
+func imageAllowed(image string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	candidates := []string{image, imageRepository(image)}
+	for _, pattern := range patterns {
+		for _, candidate := range candidates {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(image string) string {
+	if idx := strings.Index(image, "@"); idx != -1 {
+		image = image[:idx]
+	}
+	// A colon after the last slash separates the tag; earlier colons belong to a registry port
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		image = image[:idx]
+	}
+	return image
+}
+
+// containerImage returns the image reference a container was created from
+func containerImage(inspect container.InspectResponse) string {
+	if inspect.Config != nil && inspect.Config.Image != "" {
+		return inspect.Config.Image
+	}
+	if inspect.ContainerJSONBase != nil {
+		return inspect.Image
+	}
+	return ""
+}
+
+// indexedNameRegex matches the name labels of indexed services
+var indexedNameRegex = regexp.MustCompile(`^docktail\.service\.\d+\.name$`)
+
+// preservedServices describes the services and funnel a skipped container
+// declares, so their existing Tailscale configuration is left alone instead of
+// being removed. The funnel is recorded by its public port without enabling
+// it; a container with only funnel labels gets one entry without a service.
+func (c *Client) preservedServices(containerID, containerName string, labels map[string]string) []*apptypes.ContainerService {
+	var names []string
+	for key, name := range labels {
+		if name == "" || (key != apptypes.LabelService && !indexedNameRegex.MatchString(key)) {
+			continue
+		}
+		name = c.serviceNamePrefix + name
+		if validateServiceName(name) != nil && c.normalizeServiceNames {
+			name = sanitizeServiceName(name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var funnelPort string
+	if isFunnelEnabled(labels) {
+		port, err := validateOptionalPort(apptypes.LabelFunnelFunnelPort, labels[apptypes.LabelFunnelFunnelPort])
+		switch {
+		case err != nil:
+			// DockTail never applied a funnel with an invalid port
+		case port == "":
+			funnelPort = "443"
+		default:
+			funnelPort = port
+		}
+	}
+	if len(names) == 0 && funnelPort != "" {
+		names = []string{""}
+	}
+
+	services := make([]*apptypes.ContainerService, 0, len(names))
+	for _, name := range names {
+		services = append(services, &apptypes.ContainerService{
+			ContainerID:      containerID[:12],
+			ContainerName:    containerName,
+			ServiceName:      name,
+			FunnelFunnelPort: funnelPort,
+			Socket:           strings.TrimSpace(labels[apptypes.LabelSocket]),
+			Preserve:         true,
+		})
+	}
+	return services
+}
@@ -0,0 +1,200 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestParseImageAllowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  []string
+		wantError bool
+	}{
+		{"empty", "", nil, false},
+		{"single", "nginx", []string{"nginx"}, false},
+		{"multiple with whitespace", " ghcr.io/acme/* , nginx,", []string{"ghcr.io/acme/*", "nginx"}, false},
+		{"malformed glob", "ghcr.io/acme/[", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseImageAllowlist(tt.value)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseImageAllowlist(%q) error = %v, wantError %v", tt.value, err, tt.wantError)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseImageAllowlist(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageAllowed(t *testing.T) {
+	patterns := []string{"nginx", "ghcr.io/acme/*", "registry.local:5000/team/app:v*"}
+
+	tests := []struct {
+		name     string
+		image    string
+		patterns []string
+		expected bool
+	}{
+		{"empty allowlist allows everything", "anything:latest", nil, true},
+		{"bare name", "nginx", patterns, true},
+		{"bare name with tag", "nginx:alpine", patterns, true},
+		{"bare name with digest", "nginx@sha256:abc123", patterns, true},
+		{"registry glob", "ghcr.io/acme/api:1.2.3", patterns, true},
+		{"glob does not cross slashes", "ghcr.io/acme/team/api:1.2.3", patterns, false},
+		{"registry port and tag glob", "registry.local:5000/team/app:v2", patterns, true},
+		{"tag glob mismatch", "registry.local:5000/team/app:latest", patterns, false},
+		{"similar prefix", "nginx-proxy:latest", patterns, false},
+		{"other registry", "docker.io/library/nginx:latest", patterns, false},
+		{"empty image", "", patterns, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageAllowed(tt.image, tt.patterns); got != tt.expected {
+				t.Errorf("imageAllowed(%q) = %v, want %v", tt.image, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{"nginx", "nginx"},
+		{"nginx:alpine", "nginx"},
+		{"ghcr.io/acme/api:1.2.3", "ghcr.io/acme/api"},
+		{"registry.local:5000/app", "registry.local:5000/app"},
+		{"registry.local:5000/app:v1", "registry.local:5000/app"},
+		{"nginx@sha256:abc123", "nginx"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := imageRepository(tt.image); got != tt.expected {
+				t.Errorf("imageRepository(%q) = %q, want %q", tt.image, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseContainerImageAllowlist(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:      "true",
+		apptypes.LabelService:     "web",
+		apptypes.LabelTarget:      "8080",
+		apptypes.LabelDirect:      "false",
+		"docktail.service.1.name": "admin",
+		"docktail.service.1.port": "9090",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": "18080", "9090": "19090"})
+	inspect.Config.Image = "docker.io/unvetted/web:latest"
+
+	client := &Client{
+		cli: &fakeDockerAPI{
+			containers: []container.Summary{summary},
+			inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+		},
+		imageAllowlist: []string{"ghcr.io/acme/*"},
+	}
+
+	services, err := client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("GetEnabledContainers() error = %v", err)
+	}
+
+	var names []string
+	for _, svc := range services {
+		if !svc.Preserve || svc.ServiceEnabled || svc.FunnelEnabled {
+			t.Errorf("service %s should be preserved and not enabled: %+v", svc.ServiceName, svc)
+		}
+		names = append(names, svc.ServiceName)
+	}
+	if want := []string{"admin", "web"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("preserved services = %v, want %v", names, want)
+	}
+
+	client.imageAllowlist = []string{"docker.io/unvetted/*"}
+	services, err = client.GetEnabledContainers(context.Background())
+	if err != nil {
+		t.Fatalf("GetEnabledContainers() error = %v", err)
+	}
+	if len(services) != 2 || !services[0].ServiceEnabled || services[0].Preserve {
+		t.Errorf("allowlisted container should be managed, got %+v", services)
+	}
+}
+
+func TestParseContainerImageAllowlistPreservesFunnels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string // service names
+	}{
+		{
+			name: "service with funnel",
+			labels: map[string]string{
+				apptypes.LabelEnable:           "true",
+				apptypes.LabelService:          "web",
+				apptypes.LabelTarget:           "8080",
+				apptypes.LabelFunnelEnable:     "true",
+				apptypes.LabelFunnelConfirm:    "true",
+				apptypes.LabelFunnelPort:       "8080",
+				apptypes.LabelFunnelFunnelPort: "8443",
+			},
+			want: []string{"web"},
+		},
+		{
+			name: "funnel only",
+			labels: map[string]string{
+				apptypes.LabelFunnelEnable:     "true",
+				apptypes.LabelFunnelConfirm:    "true",
+				apptypes.LabelFunnelPort:       "8080",
+				apptypes.LabelFunnelFunnelPort: "8443",
+			},
+			want: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, inspect := newFakeContainer("abcdef1234567890", "web", tt.labels, map[string]string{"8080": "18080"})
+			inspect.Config.Image = "docker.io/unvetted/web:latest"
+			client := &Client{
+				cli: &fakeDockerAPI{
+					containers: []container.Summary{summary},
+					inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+				},
+				imageAllowlist: []string{"ghcr.io/acme/*"},
+			}
+
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("GetEnabledContainers() error = %v", err)
+			}
+			var names []string
+			for _, svc := range services {
+				if !svc.Preserve || svc.ServiceEnabled || svc.FunnelEnabled {
+					t.Errorf("entry %q should be preserved and not enabled: %+v", svc.ServiceName, svc)
+				}
+				if svc.FunnelFunnelPort != "8443" {
+					t.Errorf("entry %q funnel port = %q, want 8443", svc.ServiceName, svc.FunnelFunnelPort)
+				}
+				names = append(names, svc.ServiceName)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("preserved services = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
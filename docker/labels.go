@@ -0,0 +1,331 @@
+package docker
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// maxServiceNameLength is the DNS label length limit that applies to service names
+const maxServiceNameLength = 63
+
+// validateServiceName checks a service name against Tailscale/DNS naming rules:
+// lowercase letters, digits and hyphens only, at most 63 characters, and no
+// leading or trailing hyphen.
+func validateServiceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("service name is empty")
+	}
+
+	if len(name) > maxServiceNameLength {
+		return fmt.Errorf("service name %q is %d characters long (max %d)", name, len(name), maxServiceNameLength)
+	}
+
+	for _, r := range name {
+		if !isServiceNameChar(r) {
+			return fmt.Errorf("service name %q contains invalid character %q (only lowercase letters, digits and hyphens are allowed)", name, r)
+		}
+	}
+
+	if strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+		return fmt.Errorf("service name %q must not start or end with a hyphen", name)
+	}
+
+	return nil
+}
+
+func isServiceNameChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-'
+}
+
+// sanitizeServiceName converts an arbitrary name into a valid service name by
+// lowercasing it, replacing invalid characters with hyphens, collapsing repeated
+// hyphens and trimming the result to the maximum length (e.g. "MY_App.v2" -> "my-app-v2").
+func sanitizeServiceName(name string) string {
+	var b strings.Builder
+	lastHyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if !isServiceNameChar(r) {
+			r = '-'
+		}
+		if r == '-' {
+			if lastHyphen {
+				continue
+			}
+			lastHyphen = true
+		} else {
+			lastHyphen = false
+		}
+		b.WriteRune(r)
+	}
+
+	normalized := strings.Trim(b.String(), "-")
+	if len(normalized) > maxServiceNameLength {
+		normalized = strings.TrimRight(normalized[:maxServiceNameLength], "-")
+	}
+	return normalized
+}
+
+// ValidateServiceNamePrefix checks that a SERVICE_NAME_PREFIX can start a valid
+// service name
+func ValidateServiceNamePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if err := validateServiceName(prefix + "a"); err != nil {
+		return fmt.Errorf("invalid service name prefix %q: it may only contain lowercase letters, digits and hyphens and must not start with a hyphen", prefix)
+	}
+	return nil
+}
+
+// resolveServiceName prefixes and validates the service name from the given
+// label. Invalid names are rejected unless normalization is enabled, in which case they are rewritten
+// into a valid name and a warning describes the transformation.
+func (c *Client) resolveServiceName(containerName, label, name string) (string, error) {
+	name = c.serviceNamePrefix + name
+	err := validateServiceName(name)
+	if err == nil {
+		return name, nil
+	}
+
+	if !c.normalizeServiceNames {
+		return "", fmt.Errorf("invalid %s label: %w. Fix: rename the service (e.g. %q) or set NORMALIZE_SERVICE_NAMES=true", label, err, sanitizeServiceName(name))
+	}
+
+	normalized := sanitizeServiceName(name)
+	if validateServiceName(normalized) != nil {
+		return "", fmt.Errorf("invalid %s label: %w (could not be normalized)", label, err)
+	}
+
+	log.Warn().
+		Str("container", containerName).
+		Str("label", label).
+		Str("original", name).
+		Str("normalized", normalized).
+		Msg("Service name is not a valid Tailscale service name, normalized it")
+
+	return normalized, nil
+}
+
+// validatePort checks that a port label value is an integer in 1-65535 and returns
+// it in canonical form. Surrounding whitespace and leading zeros are tolerated.
+func validatePort(label, value string) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return "", fmt.Errorf("invalid %s label: port is empty", label)
+	}
+
+	for _, r := range trimmed {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("invalid %s label: %q is not a port number (must be an integer between 1 and 65535)", label, value)
+		}
+	}
+
+	port, err := strconv.Atoi(trimmed)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid %s label: port %q is out of range (must be between 1 and 65535)", label, value)
+	}
+
+	return strconv.Itoa(port), nil
+}
+
+// validateOptionalPort is like validatePort but accepts an unset label
+func validateOptionalPort(label, value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	return validatePort(label, value)
+}
+
+// validateProxyProtocol checks a proxy-protocol label value. Tailscale only sends
+// PROXY protocol headers when forwarding raw TCP, so it is rejected for http and
+// https services. An empty value disables PROXY protocol.
+func validateProxyProtocol(label, value, serviceProtocol string) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return "", nil
+	}
+	if trimmed != "1" && trimmed != "2" {
+		return "", fmt.Errorf("invalid %s %q: must be 1 or 2", label, value)
+	}
+	if serviceProtocol != "tcp" && serviceProtocol != "tls-terminated-tcp" {
+		return "", fmt.Errorf("invalid %s: PROXY protocol is only supported for tcp and tls-terminated-tcp services, not %s", label, serviceProtocol)
+	}
+	return trimmed, nil
+}
+
+// validateMountPath checks a path label value and returns it without a trailing
+// slash. The root path is returned as "". Tailscale only routes by path for
+// http and https services.
+func validateMountPath(label, value, serviceProtocol string) (string, error) {
+	trimmed := strings.TrimRight(strings.TrimSpace(value), "/")
+	if trimmed == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(trimmed, "/") {
+		return "", fmt.Errorf("invalid %s %q: must start with /", label, value)
+	}
+	if strings.ContainsAny(trimmed, " \t?#") {
+		return "", fmt.Errorf("invalid %s %q: must not contain whitespace, a query or a fragment", label, value)
+	}
+	if serviceProtocol != "http" && serviceProtocol != "https" {
+		return "", fmt.Errorf("invalid %s: paths are only supported for http and https services, not %s", label, serviceProtocol)
+	}
+	return trimmed, nil
+}
+
+// destinationSchemes maps the schemes accepted by the destination label to the
+// backend protocols they may be declared with
+var destinationSchemes = map[string][]string{
+	"http":           {"http"},
+	"https":          {"https"},
+	"https+insecure": {"https", "https+insecure"},
+	"tcp":            {"tcp", "tls-terminated-tcp"},
+}
+
+// parseDestinationOverride validates a destination label value. It must be an
+// absolute URL with a supported scheme, a host and a port and nothing else,
+// because it is used verbatim as the serve destination.
+func parseDestinationOverride(label, value string) (*url.URL, error) {
+	trimmed := strings.TrimSpace(value)
+	parsed, err := url.Parse(trimmed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", label, value, err)
+	}
+	if _, ok := destinationSchemes[parsed.Scheme]; !ok {
+		return nil, fmt.Errorf("invalid %s %q: scheme must be http, https, https+insecure or tcp", label, value)
+	}
+	if parsed.Hostname() == "" || parsed.Port() == "" {
+		return nil, fmt.Errorf("invalid %s %q: must include a host and a port", label, value)
+	}
+	if _, err := validatePort(label, parsed.Port()); err != nil {
+		return nil, err
+	}
+	if parsed.User != nil || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, fmt.Errorf("invalid %s %q: must not include credentials, a path, a query or a fragment", label, value)
+	}
+	parsed.Path = ""
+	return parsed, nil
+}
+
+// validateDestinationProtocol checks that a destination's scheme can carry the
+// declared backend protocol
+func validateDestinationProtocol(label string, destination *url.URL, protocol string) error {
+	for _, allowed := range destinationSchemes[destination.Scheme] {
+		if allowed == protocol {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q: scheme %s does not match protocol %s", label, destination.String(), destination.Scheme, protocol)
+}
+
+// validateDestinationLabels rejects labels a destination would otherwise
+// silently override: a backend port other than the destination's, and
+// insecure-skip-verify, which a destination used verbatim can't apply
+func validateDestinationLabels(destination *url.URL, targetPort string, labels map[string]string) error {
+	if targetPort != destination.Port() {
+		return fmt.Errorf("invalid %s %q: port %s does not match %s %s", apptypes.LabelDestination, destination.String(), destination.Port(), apptypes.LabelTarget, targetPort)
+	}
+	if enabled, err := parseBool(labels[apptypes.LabelInsecureSkipVerify]); err == nil && enabled {
+		return fmt.Errorf("%s cannot be combined with %s; use an https+insecure destination to skip certificate verification", apptypes.LabelInsecureSkipVerify, apptypes.LabelDestination)
+	}
+	return nil
+}
+
+// parseStaticHandler reads the serve-text and serve-file labels of the service
+// whose labels start with prefix. It returns an empty handler for services that
+// proxy to the container. A static service has no backend, so combining it with
+// the container port or a destination label is rejected.
+func parseStaticHandler(labels map[string]string, prefix string) (string, string, error) {
+	textLabel, fileLabel := prefix+"serve-text", prefix+"serve-file"
+	text := labels[textLabel]
+	file := strings.TrimSpace(labels[fileLabel])
+
+	var handler, content, label string
+	switch {
+	case text != "" && file != "":
+		return "", "", fmt.Errorf("%s and %s cannot be combined", textLabel, fileLabel)
+	case text != "":
+		handler, content, label = apptypes.HandlerText, text, textLabel
+	case file != "":
+		if !strings.HasPrefix(file, "/") {
+			return "", "", fmt.Errorf("invalid %s %q: must be an absolute path", fileLabel, file)
+		}
+		handler, content, label = apptypes.HandlerFile, file, fileLabel
+	default:
+		return "", "", nil
+	}
+
+	for _, conflicting := range []string{prefix + "port", prefix + "destination"} {
+		if strings.TrimSpace(labels[conflicting]) != "" {
+			return "", "", fmt.Errorf("%s cannot be combined with %s", label, conflicting)
+		}
+	}
+	return handler, content, nil
+}
+
+// boolLabels are the container labels that hold boolean values
+var boolLabels = map[string]bool{
+	apptypes.LabelEnable:             true,
+	apptypes.LabelFunnelEnable:       true,
+	apptypes.LabelDirect:             true,
+	apptypes.LabelInsecureSkipVerify: true,
+	apptypes.LabelRemoveOnPause:      true,
+	apptypes.LabelRedirectHTTP:       true,
+	apptypes.LabelAdvertise:          true,
+	apptypes.LabelAllPorts:           true,
+}
+
+// indexedBoolLabelRegex matches boolean labels of indexed services
+var indexedBoolLabelRegex = regexp.MustCompile(`^docktail\.service\.\d+\.insecure-skip-verify$`)
+
+// parseBool parses a boolean label value. It accepts everything strconv.ParseBool
+// does plus yes/no and on/off, case-insensitively and ignoring surrounding whitespace.
+func parseBool(value string) (bool, error) {
+	normalized := strings.ToLower(strings.TrimSpace(value))
+	switch normalized {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(normalized)
+}
+
+// boolLabel returns the boolean value of a label, or defaultValue when the label is
+// unset or unrecognized. Unrecognized values are reported by warnInvalidBoolLabels.
+func boolLabel(labels map[string]string, key string, defaultValue bool) bool {
+	value, ok := labels[key]
+	if !ok || strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+	parsed, err := parseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return parsed
+}
+
+// warnInvalidBoolLabels logs a warning for every boolean label whose value is not
+// recognized, so a typo doesn't silently disable a container
+func warnInvalidBoolLabels(containerName string, labels map[string]string) {
+	for key, value := range labels {
+		if !boolLabels[key] && !indexedBoolLabelRegex.MatchString(key) {
+			continue
+		}
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		if _, err := parseBool(value); err != nil {
+			log.Warn().
+				Str("container", containerName).
+				Str("label", key).
+				Str("value", value).
+				Msg("Unrecognized boolean label value (use true/false, yes/no, on/off or 1/0), using default")
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// containerState returns the health status and start time of a container
+func containerState(inspect container.InspectResponse) (string, time.Time) {
+	if inspect.ContainerJSONBase == nil || inspect.State == nil {
+		return "", time.Time{}
+	}
+
+	var health string
+	if inspect.State.Health != nil {
+		health = inspect.State.Health.Status
+	}
+	startedAt, _ := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	return health, startedAt
+}
+
+// healthRank orders health states, best first. Containers without a health
+// check rank with healthy ones, so a new container that is still starting
+// doesn't take over from one that has no health check.
+func healthRank(health string) int {
+	switch health {
+	case "", container.Healthy:
+		return 0
+	case container.Starting:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// withinStartupDelay reports whether a container started less than its
+// docktail.service.startup-delay before now, so the reconciler holds it back
+func withinStartupDelay(svc *apptypes.ContainerService, now time.Time) bool {
+	return svc.StartupDelay > 0 && !svc.StartedAt.IsZero() && now.Before(svc.StartedAt.Add(svc.StartupDelay))
+}
+
+// preferredContainer reports whether a should serve an endpoint that b also
+// claims, and why: the healthier container wins, then the one past its
+// docktail.service.startup-delay, then the one whose docktail.service.role is
+// preferred, then the one started most recently, then the container ID that
+// sorts first so the choice never depends on the order Docker lists
+// containers in. A backup thus only takes over while the primary is stopped
+// or less healthy, and the old container of a deploy keeps serving while the
+// new one waits out its startup delay.
+func preferredContainer(a, b *apptypes.ContainerService) (bool, string) {
+	if ra, rb := healthRank(a.Health), healthRank(b.Health); ra != rb {
+		return ra < rb, "healthier"
+	}
+	now := time.Now()
+	if da, db := withinStartupDelay(a, now), withinStartupDelay(b, now); da != db {
+		return db, "startup delay elapsed"
+	}
+	if ra, rb := roleRank(a.Role), roleRank(b.Role); ra != rb {
+		return ra < rb, "role"
+	}
+	if !a.StartedAt.Equal(b.StartedAt) {
+		return a.StartedAt.After(b.StartedAt), "started more recently"
+	}
+	return a.ContainerID < b.ContainerID, "container id sorts first"
+}
+
+// endpointOwnerKey identifies the service endpoint a container claims
+func endpointOwnerKey(svc *apptypes.ContainerService) string {
+	return svc.Socket + "/svc:" + svc.ServiceName + ":" + svc.Port + svc.Path
+}
+
+// resolveEndpointOwners keeps a single container per service endpoint when
+// several claim it, e.g. the old and new container of a blue/green deploy.
+// The winner is chosen by preferredContainer; the other claims are dropped.
+func resolveEndpointOwners(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	owners := make(map[string]*apptypes.ContainerService)
+	for _, svc := range services {
+		if !svc.ServiceEnabled || svc.Preserve {
+			continue
+		}
+		key := endpointOwnerKey(svc)
+		if owner, ok := owners[key]; !ok {
+			owners[key] = svc
+		} else if wins, _ := preferredContainer(svc, owner); wins {
+			owners[key] = svc
+		}
+	}
+
+	result := make([]*apptypes.ContainerService, 0, len(services))
+	for _, svc := range services {
+		if svc.ServiceEnabled && !svc.Preserve {
+			if owner := owners[endpointOwnerKey(svc)]; owner != svc {
+				_, reason := preferredContainer(owner, svc)
+				log.Info().
+					Str("service", "svc:"+svc.ServiceName).
+					Str("port", svc.Port).
+					Str("winner", owner.ContainerName).
+					Str("winner_health", owner.Health).
+					Str("winner_role", owner.Role).
+					Str("container", svc.ContainerName).
+					Str("container_health", svc.Health).
+					Str("container_role", svc.Role).
+					Str("reason", reason).
+					Msg("Several containers claim the same service endpoint, using the preferred one")
+				continue
+			}
+			if svc.Role == RoleBackup {
+				log.Debug().
+					Str("service", "svc:"+svc.ServiceName).
+					Str("port", svc.Port).
+					Str("container", svc.ContainerName).
+					Msg("Service endpoint is served by its backup container")
+			}
+		}
+		result = append(result, svc)
+	}
+	return result
+}
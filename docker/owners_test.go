@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestPreferredContainer(t *testing.T) {
+	older := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		a, b       apptypes.ContainerService
+		wantWins   bool
+		wantReason string
+	}{
+		{"healthy beats starting", apptypes.ContainerService{Health: "healthy", StartedAt: older}, apptypes.ContainerService{Health: "starting", StartedAt: newer}, true, "healthier"},
+		{"starting beats unhealthy", apptypes.ContainerService{Health: "starting"}, apptypes.ContainerService{Health: "unhealthy"}, true, "healthier"},
+		{"no health check ranks as healthy", apptypes.ContainerService{StartedAt: older}, apptypes.ContainerService{Health: "starting", StartedAt: newer}, true, "healthier"},
+		{"newer wins between healthy", apptypes.ContainerService{Health: "healthy", StartedAt: older}, apptypes.ContainerService{Health: "healthy", StartedAt: newer}, false, "started more recently"},
+		{"newer wins without health checks", apptypes.ContainerService{StartedAt: newer}, apptypes.ContainerService{Health: "healthy", StartedAt: older}, true, "started more recently"},
+		{"container id breaks ties", apptypes.ContainerService{ContainerID: "aaa", StartedAt: older}, apptypes.ContainerService{ContainerID: "bbb", StartedAt: older}, true, "container id sorts first"},
+		{"primary beats newer backup", apptypes.ContainerService{Role: "primary", StartedAt: older}, apptypes.ContainerService{Role: "backup", StartedAt: newer}, true, "role"},
+		{"no role beats backup", apptypes.ContainerService{StartedAt: older}, apptypes.ContainerService{Role: "backup", StartedAt: newer}, true, "role"},
+		{"healthy backup beats starting primary", apptypes.ContainerService{Role: "backup", Health: "healthy"}, apptypes.ContainerService{Role: "primary", Health: "starting"}, true, "healthier"},
+		{"old container serves while the new one waits out its startup delay", apptypes.ContainerService{StartedAt: older}, apptypes.ContainerService{StartedAt: time.Now(), StartupDelay: time.Hour}, true, "startup delay elapsed"},
+		{"newer wins once its startup delay elapsed", apptypes.ContainerService{StartedAt: older}, apptypes.ContainerService{StartedAt: newer, StartupDelay: time.Second}, false, "started more recently"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wins, reason := preferredContainer(&tt.a, &tt.b)
+			if wins != tt.wantWins || reason != tt.wantReason {
+				t.Errorf("preferredContainer() = %v, %q, want %v, %q", wins, reason, tt.wantWins, tt.wantReason)
+			}
+			// The comparison must be antisymmetric for the choice to be order independent
+			if reverse, _ := preferredContainer(&tt.b, &tt.a); reverse == wins {
+				t.Errorf("preferredContainer() is not antisymmetric: both orders return %v", wins)
+			}
+		})
+	}
+}
+
+// permutations returns every ordering of services
+func permutations(services []*apptypes.ContainerService) [][]*apptypes.ContainerService {
+	if len(services) <= 1 {
+		return [][]*apptypes.ContainerService{services}
+	}
+	var result [][]*apptypes.ContainerService
+	for i := range services {
+		rest := make([]*apptypes.ContainerService, 0, len(services)-1)
+		rest = append(rest, services[:i]...)
+		rest = append(rest, services[i+1:]...)
+		for _, perm := range permutations(rest) {
+			result = append(result, append([]*apptypes.ContainerService{services[i]}, perm...))
+		}
+	}
+	return result
+}
+
+func TestResolveEndpointOwners(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	claim := func(id, health string, startedAt time.Time) *apptypes.ContainerService {
+		return &apptypes.ContainerService{ContainerID: id, ContainerName: id, ServiceEnabled: true, ServiceName: "web", Port: "443", Health: health, StartedAt: startedAt}
+	}
+
+	tests := []struct {
+		name       string
+		candidates []*apptypes.ContainerService
+		want       string
+	}{
+		{"old stays while new is starting", []*apptypes.ContainerService{
+			claim("blue", "healthy", t0),
+			claim("green", "starting", t0.Add(time.Minute)),
+			claim("broken", "unhealthy", t0.Add(2*time.Minute)),
+		}, "blue"},
+		{"new takes over once healthy", []*apptypes.ContainerService{
+			claim("blue", "healthy", t0),
+			claim("green", "healthy", t0.Add(time.Minute)),
+			claim("broken", "unhealthy", t0.Add(2*time.Minute)),
+		}, "green"},
+		{"newest without health checks", []*apptypes.ContainerService{
+			claim("blue", "", t0),
+			claim("green", "", t0.Add(time.Minute)),
+			claim("red", "", t0.Add(-time.Minute)),
+		}, "green"},
+		{"identical claims fall back to container id", []*apptypes.ContainerService{
+			claim("c2", "", t0),
+			claim("c1", "", t0),
+			claim("c3", "", t0),
+		}, "c1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, perm := range permutations(tt.candidates) {
+				other := &apptypes.ContainerService{ContainerID: "api", ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443"}
+				funnel := &apptypes.ContainerService{ContainerID: "pub", ContainerName: "pub", FunnelEnabled: true}
+				input := append(append([]*apptypes.ContainerService{other}, perm...), funnel)
+
+				got := resolveEndpointOwners(input)
+				if len(got) != 3 {
+					t.Fatalf("got %d services, want the winner plus the unrelated ones", len(got))
+				}
+				if got[1].ContainerName != tt.want {
+					t.Errorf("order %v: winner = %s, want %s", names(perm), got[1].ContainerName, tt.want)
+				}
+				if got[0] != other || got[2] != funnel {
+					t.Error("services without competing claims were dropped or reordered")
+				}
+			}
+		})
+	}
+}
+
+func names(services []*apptypes.ContainerService) []string {
+	result := make([]string, len(services))
+	for i, svc := range services {
+		result[i] = svc.ContainerName
+	}
+	return result
+}
+
+func TestContainerState(t *testing.T) {
+	inspect := container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{State: &container.State{
+		StartedAt: "2026-01-02T03:04:05.123456789Z",
+		Health:    &container.Health{Status: container.Starting},
+	}}}
+
+	health, startedAt := containerState(inspect)
+	if health != "starting" {
+		t.Errorf("health = %q, want starting", health)
+	}
+	if want := time.Date(2026, 1, 2, 3, 4, 5, 123456789, time.UTC); !startedAt.Equal(want) {
+		t.Errorf("startedAt = %v, want %v", startedAt, want)
+	}
+
+	if health, startedAt := containerState(container.InspectResponse{}); health != "" || !startedAt.IsZero() {
+		t.Errorf("containerState(empty) = %q, %v, want no state", health, startedAt)
+	}
+}
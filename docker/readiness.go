@@ -0,0 +1,184 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Defaults of the readiness labels
+const (
+	defaultReadinessTimeout = 2 * time.Second
+	defaultReadinessStatus  = "200-399"
+)
+
+// readinessCheck is an HTTP GET a container's backend must answer before its
+// services are advertised (docktail.service.readiness-path)
+type readinessCheck struct {
+	path    string
+	timeout time.Duration
+	status  []statusRange
+}
+
+// statusRange is an inclusive range of HTTP status codes
+type statusRange struct {
+	min, max int
+}
+
+// parseReadinessCheck parses the readiness labels of a container, returning
+// nil when docktail.service.readiness-path is not set
+func parseReadinessCheck(labels map[string]string) (*readinessCheck, error) {
+	path := strings.TrimSpace(labels[apptypes.LabelReadinessPath])
+	if path == "" {
+		for _, label := range []string{apptypes.LabelReadinessTimeout, apptypes.LabelReadinessStatus} {
+			if strings.TrimSpace(labels[label]) != "" {
+				return nil, fmt.Errorf("%s requires %s", label, apptypes.LabelReadinessPath)
+			}
+		}
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t#") {
+		return nil, fmt.Errorf("invalid %s %q: must start with / and not contain whitespace or a fragment", apptypes.LabelReadinessPath, path)
+	}
+
+	check := &readinessCheck{path: path, timeout: defaultReadinessTimeout}
+	if value := strings.TrimSpace(labels[apptypes.LabelReadinessTimeout]); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a positive duration such as 2s", apptypes.LabelReadinessTimeout, value)
+		}
+		check.timeout = timeout
+	}
+
+	status := strings.TrimSpace(labels[apptypes.LabelReadinessStatus])
+	if status == "" {
+		status = defaultReadinessStatus
+	}
+	ranges, err := parseStatusRanges(status)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", apptypes.LabelReadinessStatus, status, err)
+	}
+	check.status = ranges
+	return check, nil
+}
+
+// parseStartupDelay returns how long docktail.service.startup-delay holds
+// back the services of a container, zero when unset
+func parseStartupDelay(labels map[string]string) (time.Duration, error) {
+	value := strings.TrimSpace(labels[apptypes.LabelStartupDelay])
+	if value == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a duration such as 30s", apptypes.LabelStartupDelay, value)
+	}
+	return delay, nil
+}
+
+// parseStatusRanges parses a comma-separated list of status codes and ranges,
+// such as "200-399,401"
+func parseStatusRanges(value string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(part, "-")
+		if !isRange {
+			high = low
+		}
+		min, errMin := strconv.Atoi(strings.TrimSpace(low))
+		max, errMax := strconv.Atoi(strings.TrimSpace(high))
+		if errMin != nil || errMax != nil || min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("%q is not a status code or range between 100 and 599", part)
+		}
+		ranges = append(ranges, statusRange{min: min, max: max})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no status codes given")
+	}
+	return ranges, nil
+}
+
+// expects reports whether the check accepts a response status code
+func (r *readinessCheck) expects(code int) bool {
+	for _, status := range r.status {
+		if code >= status.min && code <= status.max {
+			return true
+		}
+	}
+	return false
+}
+
+// readinessURL returns the URL the check requests: its path on the backend
+// the service proxies to, so it fails the same way tailscaled would
+func readinessURL(svc *apptypes.ContainerService, path string) (string, bool, error) {
+	if svc.Handler != "" && svc.Handler != apptypes.HandlerProxy {
+		return "", false, fmt.Errorf("%s requires a proxied service, not a %s handler", apptypes.LabelReadinessPath, svc.Handler)
+	}
+
+	base := svc.Destination
+	if base == "" {
+		base = svc.Protocol + "://" + net.JoinHostPort(svc.IPAddress, svc.TargetPort)
+	}
+	backend, err := url.Parse(base)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid backend %q: %w", base, err)
+	}
+
+	insecure := svc.InsecureSkipVerify
+	switch backend.Scheme {
+	case "http", "https":
+	case "https+insecure":
+		backend.Scheme, insecure = "https", true
+	default:
+		return "", false, fmt.Errorf("%s requires an http or https backend, not %s", apptypes.LabelReadinessPath, backend.Scheme)
+	}
+
+	// The check's path replaces the path of a destination override
+	return backend.Scheme + "://" + backend.Host + path, insecure, nil
+}
+
+// checkReadiness requests the readiness path of a service's backend and
+// returns an error unless it answers with an expected status
+func checkReadiness(ctx context.Context, check *readinessCheck, svc *apptypes.ContainerService) error {
+	target, insecure, err := readinessURL(svc, check.path)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: check.timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecure}, //nolint:gosec // only for https+insecure backends
+			DisableKeepAlives: true,
+		},
+		// A redirect is an answer; following it could leave the backend
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if !check.expects(resp.StatusCode) {
+		return fmt.Errorf("%s answered %s", target, resp.Status)
+	}
+	return nil
+}
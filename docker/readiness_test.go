@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestReadinessPathGatesContainer(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	var requested atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.URL.Path)
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(backend.Close)
+	_, hostPort, _ := net.SplitHostPort(strings.TrimPrefix(backend.URL, "http://"))
+
+	labels := map[string]string{
+		apptypes.LabelEnable:          "true",
+		apptypes.LabelService:         "web",
+		apptypes.LabelTarget:          "8080",
+		apptypes.LabelDirect:          "false",
+		apptypes.LabelHostGateway:     "127.0.0.1",
+		apptypes.LabelReadinessPath:   "/ready",
+		apptypes.LabelReadinessStatus: "200-299",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "web", labels, map[string]string{"8080": hostPort})
+	client := &Client{cli: &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}}
+
+	steps := []struct {
+		name         string
+		status       int
+		wantServices int
+	}{
+		{"warming up", http.StatusServiceUnavailable, 0},
+		{"ready", http.StatusOK, 1},
+		{"unexpected status", http.StatusFound, 0},
+		{"ready again", http.StatusNoContent, 1},
+	}
+
+	for _, step := range steps {
+		status.Store(int32(step.status))
+		services, err := client.GetEnabledContainers(context.Background())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if len(services) != step.wantServices {
+			t.Errorf("%s: got %d services, want %d", step.name, len(services), step.wantServices)
+		}
+		if path, _ := requested.Load().(string); path != "/ready" {
+			t.Errorf("%s: requested %q, want /ready", step.name, path)
+		}
+	}
+}
+
+func TestParseReadinessCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		wantTimeout time.Duration
+		wantErr     string
+		accepts     []int
+		rejects     []int
+	}{
+		{name: "unset", labels: map[string]string{}},
+		{
+			name:        "defaults",
+			labels:      map[string]string{apptypes.LabelReadinessPath: "/healthz"},
+			wantTimeout: 2 * time.Second,
+			accepts:     []int{200, 204, 301, 399},
+			rejects:     []int{404, 500, 503},
+		},
+		{
+			name: "custom",
+			labels: map[string]string{
+				apptypes.LabelReadinessPath:    "/healthz?full=1",
+				apptypes.LabelReadinessTimeout: "500ms",
+				apptypes.LabelReadinessStatus:  "200, 401",
+			},
+			wantTimeout: 500 * time.Millisecond,
+			accepts:     []int{200, 401},
+			rejects:     []int{204, 302},
+		},
+		{name: "relative path", labels: map[string]string{apptypes.LabelReadinessPath: "healthz"}, wantErr: "must start with /"},
+		{
+			name:    "invalid timeout",
+			labels:  map[string]string{apptypes.LabelReadinessPath: "/healthz", apptypes.LabelReadinessTimeout: "0s"},
+			wantErr: "must be a positive duration",
+		},
+		{
+			name:    "invalid status",
+			labels:  map[string]string{apptypes.LabelReadinessPath: "/healthz", apptypes.LabelReadinessStatus: "399-200"},
+			wantErr: "not a status code or range",
+		},
+		{
+			name:    "status without path",
+			labels:  map[string]string{apptypes.LabelReadinessStatus: "200"},
+			wantErr: "requires docktail.service.readiness-path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, err := parseReadinessCheck(tt.labels)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseReadinessCheck() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseReadinessCheck() error = %v", err)
+			}
+			if tt.wantTimeout == 0 {
+				if check != nil {
+					t.Errorf("parseReadinessCheck() = %+v, want nil", check)
+				}
+				return
+			}
+			if check.timeout != tt.wantTimeout {
+				t.Errorf("timeout = %v, want %v", check.timeout, tt.wantTimeout)
+			}
+			for _, code := range tt.accepts {
+				if !check.expects(code) {
+					t.Errorf("expects(%d) = false, want true", code)
+				}
+			}
+			for _, code := range tt.rejects {
+				if check.expects(code) {
+					t.Errorf("expects(%d) = true, want false", code)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStartupDelay(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "30s", want: 30 * time.Second},
+		{value: " 1m30s ", want: 90 * time.Second},
+		{value: "0s", want: 0},
+		{value: "-5s", wantErr: true},
+		{value: "30", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		delay, err := parseStartupDelay(map[string]string{apptypes.LabelStartupDelay: tt.value})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStartupDelay(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if delay != tt.want {
+			t.Errorf("parseStartupDelay(%q) = %v, want %v", tt.value, delay, tt.want)
+		}
+	}
+}
+
+func TestReadinessURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		svc          apptypes.ContainerService
+		wantURL      string
+		wantInsecure bool
+		wantErr      bool
+	}{
+		{
+			name:    "published port",
+			svc:     apptypes.ContainerService{Protocol: "http", IPAddress: "localhost", TargetPort: "18080"},
+			wantURL: "http://localhost:18080/ready",
+		},
+		{
+			name:         "https+insecure",
+			svc:          apptypes.ContainerService{Protocol: "https", IPAddress: "172.17.0.2", TargetPort: "8443", InsecureSkipVerify: true},
+			wantURL:      "https://172.17.0.2:8443/ready",
+			wantInsecure: true,
+		},
+		{
+			name:         "destination override",
+			svc:          apptypes.ContainerService{Protocol: "https", Destination: "https+insecure://10.0.0.5:8443/app"},
+			wantURL:      "https://10.0.0.5:8443/ready",
+			wantInsecure: true,
+		},
+		{name: "tcp backend", svc: apptypes.ContainerService{Protocol: "tcp", IPAddress: "172.17.0.2", TargetPort: "5432"}, wantErr: true},
+		{name: "static handler", svc: apptypes.ContainerService{Handler: apptypes.HandlerText}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, insecure, err := readinessURL(&tt.svc, "/ready")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readinessURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantURL || insecure != tt.wantInsecure {
+				t.Errorf("readinessURL() = %q, %v, want %q, %v", got, insecure, tt.wantURL, tt.wantInsecure)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Failover roles of containers claiming the same service endpoint
+const (
+	// RolePrimary serves the endpoint whenever it is running and healthy
+	RolePrimary = "primary"
+	// RoleBackup only serves the endpoint while no other container can
+	RoleBackup = "backup"
+)
+
+// parseRole returns the role set by docktail.service.role, empty when unset
+func parseRole(labels map[string]string) (string, error) {
+	role := strings.ToLower(strings.TrimSpace(labels[apptypes.LabelRole]))
+	switch role {
+	case "", RolePrimary, RoleBackup:
+		return role, nil
+	}
+	return "", fmt.Errorf("invalid %s label: unknown role %q (must be %s or %s)", apptypes.LabelRole, role, RolePrimary, RoleBackup)
+}
+
+// roleRank orders roles, preferred first. Containers without a role rank
+// between a primary and a backup.
+func roleRank(role string) int {
+	switch role {
+	case RolePrimary:
+		return 0
+	case RoleBackup:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// resolveDuplicatePrimaries clears the role of every primary of a service
+// that more than one container claims to be the primary of, so neither is
+// preferred over the other by its role. Which one should be is a question
+// only the user can answer, so it is logged rather than guessed.
+func resolveDuplicatePrimaries(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	primaries := make(map[string]map[string]string) // container names by ID, by service
+	for _, svc := range services {
+		if !svc.ServiceEnabled || svc.Preserve || svc.Role != RolePrimary {
+			continue
+		}
+		key := svc.Socket + "/svc:" + svc.ServiceName
+		if primaries[key] == nil {
+			primaries[key] = make(map[string]string)
+		}
+		primaries[key][svc.ContainerID] = svc.ContainerName
+	}
+
+	keys := make([]string, 0, len(primaries))
+	for key, containers := range primaries {
+		if len(containers) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return services
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		names := make([]string, 0, len(primaries[key]))
+		for _, name := range primaries[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		_, serviceName, _ := strings.Cut(key, "/")
+		log.Warn().
+			Str("service", serviceName).
+			Strs("containers", names).
+			Msg("Several containers are the primary of the service, ignoring their role")
+	}
+
+	result := make([]*apptypes.ContainerService, 0, len(services))
+	for _, svc := range services {
+		if svc.Role == RolePrimary && len(primaries[svc.Socket+"/svc:"+svc.ServiceName]) > 1 {
+			copied := *svc
+			copied.Role = ""
+			svc = &copied
+		}
+		result = append(result, svc)
+	}
+	return result
+}
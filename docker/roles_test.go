@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestParseRole(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "primary", want: RolePrimary},
+		{value: " Backup ", want: RoleBackup},
+		{value: "standby", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRole(map[string]string{apptypes.LabelRole: tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRole(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRole(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrimaryAndBackupPair(t *testing.T) {
+	claim := func(name, role, health string) *apptypes.ContainerService {
+		return &apptypes.ContainerService{ContainerID: name, ContainerName: name, ServiceEnabled: true, ServiceName: "app", Port: "443", Role: role, Health: health}
+	}
+
+	tests := []struct {
+		name       string
+		candidates []*apptypes.ContainerService
+		want       string
+	}{
+		{"primary serves while up", []*apptypes.ContainerService{claim("app", RolePrimary, ""), claim("standby", RoleBackup, "")}, "app"},
+		{"backup serves while primary is unhealthy", []*apptypes.ContainerService{claim("app", RolePrimary, "unhealthy"), claim("standby", RoleBackup, "")}, "standby"},
+		{"backup serves while primary is stopped", []*apptypes.ContainerService{claim("standby", RoleBackup, "")}, "standby"},
+		{"duplicate primaries fall back to container id", []*apptypes.ContainerService{claim("b", RolePrimary, ""), claim("a", RolePrimary, ""), claim("standby", RoleBackup, "")}, "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, perm := range permutations(tt.candidates) {
+				got := resolveEndpointOwners(resolveDuplicatePrimaries(perm))
+				if len(got) != 1 || got[0].ContainerName != tt.want {
+					t.Errorf("order %v: got %v, want %s", names(perm), names(got), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveDuplicatePrimaries(t *testing.T) {
+	services := []*apptypes.ContainerService{
+		{ContainerID: "a", ContainerName: "a", ServiceEnabled: true, ServiceName: "app", Port: "443", Role: RolePrimary},
+		{ContainerID: "a", ContainerName: "a", ServiceEnabled: true, ServiceName: "app", Port: "8443", Role: RolePrimary},
+		{ContainerID: "b", ContainerName: "b", ServiceEnabled: true, ServiceName: "api", Port: "443", Role: RolePrimary},
+		{ContainerID: "c", ContainerName: "c", ServiceEnabled: true, ServiceName: "api", Port: "443", Role: RolePrimary},
+	}
+
+	got := resolveDuplicatePrimaries(services)
+	roles := []string{got[0].Role, got[1].Role, got[2].Role, got[3].Role}
+	want := []string{RolePrimary, RolePrimary, "", ""}
+	for i := range want {
+		if roles[i] != want[i] {
+			t.Fatalf("roles = %q, want %q: a container with several ports is one primary", roles, want)
+		}
+	}
+	if services[2].Role != RolePrimary {
+		t.Error("resolveDuplicatePrimaries modified its input")
+	}
+}
@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Scale modes for compose services scaled to several replicas
+const (
+	// ScaleModeSingle routes the service to one replica, picked like any other
+	// containers claiming the same endpoint
+	ScaleModeSingle = "single"
+	// ScaleModePortOffset gives every replica its own service ports, shifted
+	// by the replica number minus one
+	ScaleModePortOffset = "port-offset"
+)
+
+// composeContainerNumberLabel is set by Docker Compose to the replica number,
+// starting at 1
+const composeContainerNumberLabel = "com.docker.compose.container-number"
+
+// replicaPortOffset returns how far the service ports of a container are
+// shifted according to docktail.service.scale-mode
+func replicaPortOffset(labels map[string]string) (int, error) {
+	mode := strings.ToLower(strings.TrimSpace(labels[apptypes.LabelScaleMode]))
+	switch mode {
+	case "", ScaleModeSingle:
+		return 0, nil
+	case ScaleModePortOffset:
+	default:
+		return 0, fmt.Errorf("invalid %s label: unknown mode %q (must be %s or %s)", apptypes.LabelScaleMode, mode, ScaleModeSingle, ScaleModePortOffset)
+	}
+
+	value := strings.TrimSpace(labels[composeContainerNumberLabel])
+	if value == "" {
+		// Not started by Compose, so it is the only replica
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		return 0, fmt.Errorf("invalid %s label %q", composeContainerNumberLabel, value)
+	}
+	return number - 1, nil
+}
+
+// offsetServicePorts shifts the service port of every endpoint by offset
+func offsetServicePorts(services []*apptypes.ContainerService, offset int) error {
+	if offset == 0 {
+		return nil
+	}
+	for _, svc := range services {
+		port, err := strconv.Atoi(svc.Port)
+		if err != nil {
+			return fmt.Errorf("invalid service port %q: %w", svc.Port, err)
+		}
+		if port+offset > 65535 {
+			return fmt.Errorf("service port %d of replica offset %d exceeds 65535", port, offset)
+		}
+		svc.Port = strconv.Itoa(port + offset)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestReplicaPortOffset(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		number  string
+		want    int
+		wantErr bool
+	}{
+		{"default mode", "", "3", 0, false},
+		{"single mode", "single", "3", 0, false},
+		{"first replica", "port-offset", "1", 0, false},
+		{"third replica", "Port-Offset", "3", 2, false},
+		{"not a compose container", "port-offset", "", 0, false},
+		{"invalid replica number", "port-offset", "zero", 0, true},
+		{"unknown mode", "round-robin", "1", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{apptypes.LabelScaleMode: tt.mode, composeContainerNumberLabel: tt.number}
+			got, err := replicaPortOffset(labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replicaPortOffset() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("replicaPortOffset() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// composeReplicas builds the fake Docker state of `docker compose up --scale api=n`
+func composeReplicas(n int) *fakeDockerAPI {
+	api := &fakeDockerAPI{inspects: map[string]container.InspectResponse{}}
+	for i := 1; i <= n; i++ {
+		labels := map[string]string{
+			apptypes.LabelEnable:              "true",
+			apptypes.LabelService:             "api",
+			apptypes.LabelTarget:              "8080",
+			apptypes.LabelDirect:              "false",
+			apptypes.LabelScaleMode:           ScaleModePortOffset,
+			"com.docker.compose.project":      "shop",
+			"com.docker.compose.service":      "api",
+			composeContainerNumberLabel:       fmt.Sprint(i),
+			"docktail.service.1.name":         "api-metrics",
+			"docktail.service.1.port":         "9090",
+			"docktail.service.1.direct":       "false",
+			"docktail.service.1.service-port": "9090",
+		}
+		summary, inspect := newFakeContainer(fmt.Sprintf("replica%d0000000000", i), fmt.Sprintf("shop-api-%d", i), labels,
+			map[string]string{"8080": fmt.Sprint(18080 + i), "9090": fmt.Sprint(19090 + i)})
+		api.containers = append(api.containers, summary)
+		api.inspects[summary.ID] = inspect
+	}
+	return api
+}
+
+func TestGetEnabledContainersComposeReplicas(t *testing.T) {
+	endpoints := func(services []*apptypes.ContainerService) []string {
+		var got []string
+		for _, svc := range services {
+			got = append(got, fmt.Sprintf("%s:%s->%s", svc.ServiceName, svc.Port, svc.TargetPort))
+		}
+		return got
+	}
+
+	tests := []struct {
+		replicas int
+		want     []string
+	}{
+		{3, []string{
+			"api:80->18081", "api-metrics:9090->19091",
+			"api:81->18082", "api-metrics:9091->19092",
+			"api:82->18083", "api-metrics:9092->19093",
+		}},
+		// Scaling down only drops the departed replica's endpoints
+		{2, []string{
+			"api:80->18081", "api-metrics:9090->19091",
+			"api:81->18082", "api-metrics:9091->19092",
+		}},
+		{1, []string{"api:80->18081", "api-metrics:9090->19091"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("scale %d", tt.replicas), func(t *testing.T) {
+			client := &Client{cli: composeReplicas(tt.replicas)}
+			services, err := client.GetEnabledContainers(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := endpoints(services); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("endpoints = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"errors"
+	"expvar"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Reasons a container is skipped, the reason label of
+// docktail_containers_skipped_total
+const (
+	// SkipEnvError: the container's environment could not be read (READ_ENV_CONFIG)
+	SkipEnvError = "env_error"
+	// SkipDisabled: the container has docktail labels but enables neither a
+	// service nor a funnel
+	SkipDisabled = "disabled"
+	// SkipParseError: the container's labels could not be parsed
+	SkipParseError = "parse_error"
+	// SkipPortNotPublished: the target port isn't published to the host,
+	// without container-ip mode
+	SkipPortNotPublished = "port_not_published"
+	// SkipImageNotAllowed: the container's image doesn't match IMAGE_ALLOWLIST,
+	// so its services are left untouched
+	SkipImageNotAllowed = "image_not_allowed"
+	// SkipPaused: the container is paused and sets docktail.remove-on-pause
+	SkipPaused = "paused"
+	// SkipNotReady: the backend doesn't answer its readiness check yet
+	SkipNotReady = "not_ready"
+)
+
+// containersSkipped counts skipped containers by reason, every discovery
+var containersSkipped = expvar.NewMap("docktail_containers_skipped_total")
+
+// notPublishedError is the error of a target port that isn't published to
+// the host
+type notPublishedError struct {
+	error
+}
+
+func (e notPublishedError) Unwrap() error {
+	return e.error
+}
+
+// skipReason returns the reason of a container that failed to parse with err
+func skipReason(err error) string {
+	var notPublished notPublishedError
+	if errors.As(err, &notPublished) {
+		return SkipPortNotPublished
+	}
+	return SkipParseError
+}
+
+// hasDocktailLabels reports whether any label configures DockTail
+func hasDocktailLabels(labels map[string]string) bool {
+	for key := range labels {
+		if strings.HasPrefix(key, "docktail.") {
+			return true
+		}
+	}
+	return false
+}
+
+// skipContainer counts a skipped container, logs it with its reason and keeps
+// it for the current discovery. Repeats are held back like container errors;
+// reasons that need no fixing are logged below warning level.
+func (c *Client) skipContainer(containerID, name, reason string, err error) {
+	containersSkipped.Add(reason, 1)
+
+	action, count := c.errorLog.observe(containerID, name, err)
+	var event *zerolog.Event
+	switch {
+	case action == errorLogSuppress || reason == SkipDisabled:
+		event = log.Debug()
+	case reason == SkipPaused || reason == SkipNotReady:
+		event = log.Info()
+	default:
+		event = log.Warn()
+	}
+	msg := "Skipping container"
+	if action == errorLogSummary {
+		msg = "Container still skipped"
+	}
+	if count > 1 {
+		event = event.Int("occurrences", count)
+	}
+	event.
+		Err(err).
+		Str("reason", reason).
+		Str("container_id", shortID(containerID)).
+		Str("container_name", name).
+		Msg(msg)
+
+	c.skipsMu.Lock()
+	defer c.skipsMu.Unlock()
+	c.skips = append(c.skips, apptypes.SkippedContainer{
+		ContainerID:   shortID(containerID),
+		ContainerName: name,
+		DockerHost:    c.endpoint,
+		Reason:        reason,
+		Error:         err.Error(),
+	})
+}
+
+// takeSkips returns the containers skipped since the last call
+func (c *Client) takeSkips() []apptypes.SkippedContainer {
+	c.skipsMu.Lock()
+	defer c.skipsMu.Unlock()
+	skips := c.skips
+	c.skips = nil
+	return skips
+}
+
+// SkippedContainers returns the containers the last discovery skipped
+func (c *Client) SkippedContainers() []apptypes.SkippedContainer {
+	c.skipsMu.Lock()
+	defer c.skipsMu.Unlock()
+	return append([]apptypes.SkippedContainer(nil), c.lastSkips...)
+}
@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// skippedCount returns docktail_containers_skipped_total for reason
+func skippedCount(reason string) int64 {
+	if v, ok := containersSkipped.Get(reason).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestDiscoverRecordsSkipReasons(t *testing.T) {
+	unready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(unready.Close)
+	_, unreadyPort, _ := net.SplitHostPort(strings.TrimPrefix(unready.URL, "http://"))
+
+	web := func(extra map[string]string) map[string]string {
+		labels := map[string]string{
+			apptypes.LabelEnable:  "true",
+			apptypes.LabelService: "web",
+			apptypes.LabelTarget:  "8080",
+			apptypes.LabelDirect:  "false",
+		}
+		for key, value := range extra {
+			labels[key] = value
+		}
+		return labels
+	}
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		bindings  map[string]string
+		configure func(client *Client, api *fakeDockerAPI, inspect container.InspectResponse)
+		want      string // expected reason, empty when the container is served
+	}{
+		{name: "served", labels: web(nil), bindings: map[string]string{"8080": "18080"}},
+		{name: "unmanaged", labels: map[string]string{"com.example.app": "db"}},
+		{name: "disabled", labels: map[string]string{apptypes.LabelEnable: "false", apptypes.LabelService: "web"}, want: SkipDisabled},
+		{name: "parse error", labels: map[string]string{apptypes.LabelEnable: "true", apptypes.LabelTarget: "8080"}, want: SkipParseError},
+		{name: "port not published", labels: web(nil), want: SkipPortNotPublished},
+		{
+			name:     "image not allowed",
+			labels:   web(nil),
+			bindings: map[string]string{"8080": "18080"},
+			configure: func(client *Client, api *fakeDockerAPI, inspect container.InspectResponse) {
+				client.imageAllowlist = []string{"ghcr.io/example/*"}
+				inspect.Config.Image = "nginx:latest"
+			},
+			want: SkipImageNotAllowed,
+		},
+		{
+			name:     "paused",
+			labels:   web(map[string]string{apptypes.LabelRemoveOnPause: "true"}),
+			bindings: map[string]string{"8080": "18080"},
+			configure: func(client *Client, api *fakeDockerAPI, inspect container.InspectResponse) {
+				inspect.State.Paused = true
+			},
+			want: SkipPaused,
+		},
+		{
+			name:     "not ready",
+			labels:   web(map[string]string{apptypes.LabelHostGateway: "127.0.0.1", apptypes.LabelReadinessPath: "/ready"}),
+			bindings: map[string]string{"8080": unreadyPort},
+			want:     SkipNotReady,
+		},
+		{
+			name:   "environment unreadable",
+			labels: web(nil),
+			configure: func(client *Client, api *fakeDockerAPI, inspect container.InspectResponse) {
+				client.readEnvConfig = true
+				delete(api.inspects, inspect.ID)
+			},
+			want: SkipEnvError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, inspect := newFakeContainer("abcdef1234567890", "app", tt.labels, tt.bindings)
+			api := &fakeDockerAPI{
+				containers: []container.Summary{summary},
+				inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+			}
+			client := &Client{cli: api}
+			if tt.configure != nil {
+				tt.configure(client, api, inspect)
+			}
+
+			before := skippedCount(tt.want)
+			discovery, err := client.Discover(context.Background())
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+
+			skipped := client.SkippedContainers()
+			if tt.want == "" {
+				if len(skipped) != 0 {
+					t.Errorf("skipped = %+v, want nothing", skipped)
+				}
+				return
+			}
+			if len(skipped) != 1 || skipped[0].Reason != tt.want || skipped[0].ContainerID != "abcdef123456" || skipped[0].ContainerName != "app" || skipped[0].Error == "" {
+				t.Fatalf("skipped = %+v, want app skipped for %s", skipped, tt.want)
+			}
+			if len(discovery.Skipped) != 1 {
+				t.Errorf("discovery skipped = %+v, want app", discovery.Skipped)
+			}
+			if got := skippedCount(tt.want) - before; got != 1 {
+				t.Errorf("docktail_containers_skipped_total{reason=%q} increased by %d, want 1", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSkippedContainersAreOfTheLastDiscovery(t *testing.T) {
+	summary, inspect := newFakeContainer("abcdef1234567890", "app", map[string]string{
+		apptypes.LabelEnable: "true",
+		apptypes.LabelTarget: "8080",
+	}, nil)
+	api := &fakeDockerAPI{
+		containers: []container.Summary{summary},
+		inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+	}
+	client := &Client{cli: api}
+
+	if _, err := client.Discover(context.Background()); err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if skipped := client.SkippedContainers(); len(skipped) != 1 {
+		t.Fatalf("skipped = %+v, want app", skipped)
+	}
+
+	api.containers = nil
+	if _, err := client.Discover(context.Background()); err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if skipped := client.SkippedContainers(); len(skipped) != 0 {
+		t.Errorf("skipped = %+v, want nothing once the container is gone", skipped)
+	}
+}
@@ -0,0 +1,258 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// staticContainerName stands in for the container name of services defined in
+// STATIC_SERVICES_FILE, in logs and wherever a container is expected
+const staticContainerName = "static"
+
+// StaticServicesFile is the format of STATIC_SERVICES_FILE, read as YAML when
+// the file name ends in .yaml or .yml and as JSON otherwise
+type StaticServicesFile struct {
+	Services []StaticService `json:"services" yaml:"services"`
+}
+
+// StaticService is a service that doesn't run in Docker, such as a process on
+// the Docker host or another machine on the LAN
+type StaticService struct {
+	Name string `json:"name" yaml:"name"`
+	// Destination is the backend URL, such as http://192.168.1.50:8080
+	Destination string `json:"destination" yaml:"destination"`
+	// Protocol is the backend protocol (default: the destination scheme)
+	Protocol        string        `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	ServicePort     string        `json:"service-port,omitempty" yaml:"service-port,omitempty"`
+	ServiceProtocol string        `json:"service-protocol,omitempty" yaml:"service-protocol,omitempty"`
+	Path            string        `json:"path,omitempty" yaml:"path,omitempty"`
+	Tags            []string      `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Funnel          *StaticFunnel `json:"funnel,omitempty" yaml:"funnel,omitempty"`
+}
+
+// StaticFunnel exposes a static service's backend through Tailscale Funnel
+type StaticFunnel struct {
+	// FunnelPort is the public port (default: 443)
+	FunnelPort string `json:"funnel-port,omitempty" yaml:"funnel-port,omitempty"`
+	// Protocol is the funnel protocol (default: https)
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+}
+
+// ReloadStaticServices reads STATIC_SERVICES_FILE again. An invalid file is
+// rejected as a whole and the services loaded before stay in effect.
+func (c *Client) ReloadStaticServices() error {
+	if c.staticServicesFile == "" {
+		return nil
+	}
+
+	services, err := c.loadStaticServices(c.staticServicesFile)
+	if err != nil {
+		return err
+	}
+
+	c.staticMu.Lock()
+	c.staticServices = services
+	c.staticMu.Unlock()
+
+	log.Info().
+		Str("path", c.staticServicesFile).
+		Int("count", len(services)).
+		Msg("Loaded static services")
+	return nil
+}
+
+// loadStaticServices reads and validates a static services file
+func (c *Client) loadStaticServices(path string) ([]*apptypes.ContainerService, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read static services file: %w", err)
+	}
+
+	file, err := decodeStaticServices(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse static services file %s: %w", path, err)
+	}
+
+	var services []*apptypes.ContainerService
+	endpoints := make(map[string]int)
+	for i, entry := range file.Services {
+		svc, err := c.parseStaticService(fmt.Sprintf("services[%d].", i), entry)
+		if err != nil {
+			return nil, fmt.Errorf("static services file %s: %w", path, err)
+		}
+
+		key := "svc:" + svc.ServiceName + ":" + svc.Port + svc.Path
+		if first, ok := endpoints[key]; ok {
+			return nil, fmt.Errorf("static services file %s: services[%d] repeats the endpoint %s of services[%d]", path, i, key, first)
+		}
+		endpoints[key] = i
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// decodeStaticServices decodes a static services file by its extension.
+// Unknown fields are errors in both formats, so a typo doesn't silently drop
+// a setting.
+func decodeStaticServices(path string, data []byte) (StaticServicesFile, error) {
+	var file StaticServicesFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file defines no services
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return file, err
+		}
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return file, err
+		}
+	}
+	return file, nil
+}
+
+// parseStaticService validates a static service with the rules used for
+// labels. field prefixes the field names in errors.
+func (c *Client) parseStaticService(field string, entry StaticService) (*apptypes.ContainerService, error) {
+	if strings.TrimSpace(entry.Name) == "" {
+		return nil, fmt.Errorf("missing required field: %sname", field)
+	}
+	serviceName, err := c.resolveServiceName(staticContainerName, field+"name", strings.TrimSpace(entry.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(entry.Destination) == "" {
+		return nil, fmt.Errorf("missing required field: %sdestination", field)
+	}
+	destination, err := parseDestinationOverride(field+"destination", entry.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	protocol := strings.TrimSpace(entry.Protocol)
+	if protocol == "" {
+		protocol = destination.Scheme
+	}
+	servicePort, err := validateOptionalPort(field+"service-port", entry.ServicePort)
+	if err != nil {
+		return nil, err
+	}
+	protocol, port, serviceProtocol, err := resolveProtocols(staticContainerName, destination.Port(), servicePort, entry.ServiceProtocol, protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDestinationProtocol(field+"destination", destination, protocol); err != nil {
+		return nil, err
+	}
+
+	mountPath, err := validateMountPath(field+"path", entry.Path, serviceProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := entry.Tags
+	if len(tags) == 0 {
+		tags = append([]string(nil), c.defaultTags...)
+	}
+
+	svc := &apptypes.ContainerService{
+		ContainerName:   staticContainerName,
+		ServiceEnabled:  true,
+		ServiceName:     serviceName,
+		Port:            port,
+		TargetPort:      destination.Port(),
+		ServiceProtocol: serviceProtocol,
+		Protocol:        protocol,
+		Tags:            tags,
+		IPAddress:       destination.Hostname(),
+		Destination:     destination.String(),
+		Path:            mountPath,
+	}
+
+	if entry.Funnel != nil {
+		labels := map[string]string{
+			apptypes.LabelFunnelFunnelPort: entry.Funnel.FunnelPort,
+			apptypes.LabelFunnelProtocol:   entry.Funnel.Protocol,
+		}
+		funnelProtocol, funnelPort, _, err := funnelSettings(staticContainerName, labels)
+		if err != nil {
+			return nil, fmt.Errorf("%sfunnel: %w", field, err)
+		}
+		svc.FunnelEnabled = true
+		svc.FunnelPort = destination.Port()
+		svc.FunnelTargetPort = destination.Port()
+		svc.FunnelFunnelPort = funnelPort
+		svc.FunnelProtocol = funnelProtocol
+		if funnelProtocol == "https" || funnelProtocol == "http" {
+			if protocol == "tcp" || protocol == "tls-terminated-tcp" {
+				return nil, fmt.Errorf("%sfunnel: %s funnels need an http or https destination, not %s", field, funnelProtocol, protocol)
+			}
+			svc.FunnelTargetProtocol = protocol
+		}
+	}
+
+	return svc, nil
+}
+
+// mergeStaticServices adds the static and ad-hoc services to the container
+// services. A container service wins over a static service of the same name,
+// which wins over an ad-hoc one, so starting a container for a service moves
+// it off the other definitions.
+func (c *Client) mergeStaticServices(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	c.staticMu.RLock()
+	static := c.staticServices
+	c.staticMu.RUnlock()
+	adhoc := c.adhocContainerServices()
+	if len(static) == 0 && len(adhoc) == 0 {
+		return services
+	}
+
+	claimed := make(map[string]string)
+	for _, svc := range services {
+		if svc.ServiceName != "" {
+			if _, ok := claimed[svc.ServiceName]; !ok {
+				claimed[svc.ServiceName] = svc.ContainerName
+			}
+		}
+	}
+
+	merged := services
+	for _, group := range [][]*apptypes.ContainerService{static, adhoc} {
+		added := make(map[string]string)
+		skipped := make(map[string]struct{})
+		for _, svc := range group {
+			if owner, ok := claimed[svc.ServiceName]; ok {
+				if _, logged := skipped[svc.ServiceName]; !logged {
+					skipped[svc.ServiceName] = struct{}{}
+					log.Warn().
+						Str("service", svc.ServiceName).
+						Str("source", svc.ContainerName).
+						Str("container", owner).
+						Msg("Service name is already used, skipping")
+				}
+				continue
+			}
+			copied := *svc
+			merged = append(merged, &copied)
+			added[svc.ServiceName] = svc.ContainerName
+		}
+		for serviceName, source := range added {
+			claimed[serviceName] = source
+		}
+	}
+	return merged
+}
@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// writeStaticFile writes a static services file into a temporary directory
+func writeStaticFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "services.json")
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write static services file: %v", err)
+	}
+	return path
+}
+
+func TestLoadStaticServices(t *testing.T) {
+	path := writeStaticFile(t, "", `{"services":[
+		{"name":"prometheus","destination":"http://172.17.0.1:9090"},
+		{"name":"vm","destination":"https+insecure://192.168.1.50:8443","service-protocol":"https","tags":["tag:vm"],
+		 "funnel":{"funnel-port":"8443"}}
+	]}`)
+
+	client := &Client{defaultTags: []string{"tag:container"}, staticServicesFile: path}
+	if err := client.ReloadStaticServices(); err != nil {
+		t.Fatalf("ReloadStaticServices() error = %v", err)
+	}
+
+	want := []apptypes.ContainerService{
+		{
+			ContainerName:   "static",
+			ServiceEnabled:  true,
+			ServiceName:     "prometheus",
+			Port:            "80",
+			TargetPort:      "9090",
+			ServiceProtocol: "http",
+			Protocol:        "http",
+			Tags:            []string{"tag:container"},
+			IPAddress:       "172.17.0.1",
+			Destination:     "http://172.17.0.1:9090",
+		},
+		{
+			ContainerName:        "static",
+			ServiceEnabled:       true,
+			ServiceName:          "vm",
+			Port:                 "443",
+			TargetPort:           "8443",
+			ServiceProtocol:      "https",
+			Protocol:             "https+insecure",
+			Tags:                 []string{"tag:vm"},
+			IPAddress:            "192.168.1.50",
+			Destination:          "https+insecure://192.168.1.50:8443",
+			FunnelEnabled:        true,
+			FunnelPort:           "8443",
+			FunnelTargetPort:     "8443",
+			FunnelFunnelPort:     "8443",
+			FunnelProtocol:       "https",
+			FunnelTargetProtocol: "https+insecure",
+		},
+	}
+	if len(client.staticServices) != len(want) {
+		t.Fatalf("got %d static services, want %d", len(client.staticServices), len(want))
+	}
+	for i, got := range client.staticServices {
+		if !reflect.DeepEqual(*got, want[i]) {
+			t.Errorf("services[%d] = %+v, want %+v", i, *got, want[i])
+		}
+	}
+}
+
+func TestLoadStaticServicesYAML(t *testing.T) {
+	jsonPath := writeStaticFile(t, "", `{"services":[
+		{"name":"prometheus","destination":"http://172.17.0.1:9090","service-port":"8080"},
+		{"name":"vm","destination":"https+insecure://192.168.1.50:8443","tags":["tag:vm"],"funnel":{"funnel-port":"8443"}}
+	]}`)
+	yamlPath := writeStaticFile(t, filepath.Join(t.TempDir(), "services.yaml"), `
+services:
+  - name: prometheus
+    destination: http://172.17.0.1:9090
+    service-port: 8080
+  - name: vm
+    destination: https+insecure://192.168.1.50:8443
+    tags: [tag:vm]
+    funnel:
+      funnel-port: 8443
+`)
+
+	fromJSON := &Client{staticServicesFile: jsonPath}
+	fromYAML := &Client{staticServicesFile: yamlPath}
+	for _, client := range []*Client{fromJSON, fromYAML} {
+		if err := client.ReloadStaticServices(); err != nil {
+			t.Fatalf("ReloadStaticServices(%s) error = %v", client.staticServicesFile, err)
+		}
+	}
+	if !reflect.DeepEqual(fromYAML.staticServices, fromJSON.staticServices) {
+		t.Errorf("services from YAML = %+v, want the same as from JSON %+v", fromYAML.staticServices, fromJSON.staticServices)
+	}
+
+	// A file with a .yml extension may also be plain JSON, which is YAML too
+	ymlPath := writeStaticFile(t, filepath.Join(t.TempDir(), "services.yml"), `{"services":[{"name":"prometheus","destination":"http://172.17.0.1:9090"}]}`)
+	fromYML := &Client{staticServicesFile: ymlPath}
+	if err := fromYML.ReloadStaticServices(); err != nil || len(fromYML.staticServices) != 1 {
+		t.Errorf("ReloadStaticServices(.yml with JSON) = %d services, error %v, want 1 service", len(fromYML.staticServices), err)
+	}
+}
+
+func TestLoadStaticServicesInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // file name, services.json when empty
+		content string
+		wantErr string
+	}{
+		{"not JSON", "", `services: []`, "failed to parse"},
+		{"not YAML", "services.yaml", "services:\n\t- name: web", "failed to parse"},
+		{"unknown YAML field", "services.yaml", "services:\n  - name: web\n    destination: http://10.0.0.1:80\n    port: 80", "field port not found"},
+		{"invalid YAML service", "services.yml", "services:\n  - destination: http://10.0.0.1:80", "services[0].name"},
+		{"unknown field", "", `{"services":[{"name":"web","destination":"http://10.0.0.1:80","port":"80"}]}`, "unknown field"},
+		{"missing name", "", `{"services":[{"destination":"http://10.0.0.1:80"}]}`, "services[0].name"},
+		{"invalid name", "", `{"services":[{"name":"Web_App","destination":"http://10.0.0.1:80"}]}`, "services[0].name"},
+		{"missing destination", "", `{"services":[{"name":"web"}]}`, "services[0].destination"},
+		{"destination without port", "", `{"services":[{"name":"web","destination":"http://10.0.0.1"}]}`, "must include a host and a port"},
+		{"scheme does not match protocol", "", `{"services":[{"name":"web","destination":"http://10.0.0.1:80","protocol":"https"}]}`, "does not match protocol"},
+		{"invalid service port", "", `{"services":[{"name":"web","destination":"http://10.0.0.1:80","service-port":"70000"}]}`, "out of range"},
+		{"invalid funnel port", "", `{"services":[{"name":"web","destination":"http://10.0.0.1:80","funnel":{"funnel-port":"8080"}}]}`, "services[0].funnel"},
+		{"http funnel to tcp backend", "", `{"services":[{"name":"db","destination":"tcp://10.0.0.1:5432","funnel":{}}]}`, "need an http or https destination"},
+		{"repeated endpoint", "", `{"services":[{"name":"web","destination":"http://10.0.0.1:80"},{"name":"web","destination":"http://10.0.0.2:80"}]}`, "services[1] repeats the endpoint svc:web:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = filepath.Join(t.TempDir(), tt.file)
+			}
+			client := &Client{staticServicesFile: writeStaticFile(t, path, tt.content)}
+			err := client.ReloadStaticServices()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReloadStaticServices() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetEnabledContainersMergesStaticServices(t *testing.T) {
+	labels := map[string]string{
+		apptypes.LabelEnable:  "true",
+		apptypes.LabelService: "grafana",
+		apptypes.LabelTarget:  "3000",
+		apptypes.LabelDirect:  "false",
+	}
+	summary, inspect := newFakeContainer("abcdef1234567890", "grafana", labels, map[string]string{"3000": "13000"})
+
+	path := writeStaticFile(t, "", `{"services":[
+		{"name":"grafana","destination":"http://192.168.1.20:3000"},
+		{"name":"prometheus","destination":"http://192.168.1.20:9090"}
+	]}`)
+	client := &Client{
+		cli: &fakeDockerAPI{
+			containers: []container.Summary{summary},
+			inspects:   map[string]container.InspectResponse{summary.ID: inspect},
+		},
+		staticServicesFile: path,
+	}
+	if err := client.ReloadStaticServices(); err != nil {
+		t.Fatalf("ReloadStaticServices() error = %v", err)
+	}
+
+	names := func() map[string]string {
+		services, err := client.GetEnabledContainers(context.Background())
+		if err != nil {
+			t.Fatalf("GetEnabledContainers() error = %v", err)
+		}
+		owners := make(map[string]string)
+		for _, svc := range services {
+			owners[svc.ServiceName] = svc.ContainerName
+		}
+		return owners
+	}
+
+	// The container keeps its service; the static definition of the same name is skipped
+	if got, want := names(), map[string]string{"grafana": "grafana", "prometheus": "static"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("service owners = %v, want %v", got, want)
+	}
+
+	// Removing an entry from the file drops its service on the next reload
+	writeStaticFile(t, path, `{"services":[{"name":"grafana","destination":"http://192.168.1.20:3000"}]}`)
+	if err := client.ReloadStaticServices(); err != nil {
+		t.Fatalf("ReloadStaticServices() error = %v", err)
+	}
+	if got, want := names(), map[string]string{"grafana": "grafana"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("service owners = %v, want %v", got, want)
+	}
+
+	// An invalid file keeps the services loaded before
+	writeStaticFile(t, path, `{"services":[{"name":"grafana"}]}`)
+	if err := client.ReloadStaticServices(); err == nil {
+		t.Fatal("ReloadStaticServices() should reject an invalid file")
+	}
+	if len(client.staticServices) != 1 {
+		t.Errorf("static services = %d, want the previous 1", len(client.staticServices))
+	}
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// exposedTCPPorts returns the TCP ports a container exposes or publishes,
+// sorted numerically
+func exposedTCPPorts(inspect container.InspectResponse) []string {
+	seen := make(map[int]struct{})
+	add := func(port nat.Port) {
+		if port.Proto() != "tcp" {
+			return
+		}
+		if n := port.Int(); n > 0 {
+			seen[n] = struct{}{}
+		}
+	}
+
+	if inspect.Config != nil {
+		for port := range inspect.Config.ExposedPorts {
+			add(port)
+		}
+	}
+	if inspect.HostConfig != nil {
+		for port := range inspect.HostConfig.PortBindings {
+			add(port)
+		}
+	}
+	if inspect.NetworkSettings != nil {
+		for port := range inspect.NetworkSettings.Ports {
+			add(port)
+		}
+	}
+
+	numbers := make([]int, 0, len(seen))
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	ports := make([]string, len(numbers))
+	for i, n := range numbers {
+		ports[i] = strconv.Itoa(n)
+	}
+	return ports
+}
+
+// missingTargetPort handles a service without the container port label. With
+// docktail.service.all-ports the lowest published port is used, and with
+// AUTO_TARGET_PORT the only port the container exposes; otherwise the error
+// lists the exposed ports to choose from.
+func (c *Client) missingTargetPort(cctx *containerCtx) (string, error) {
+	if cctx.allPorts {
+		if published := publishedTCPPorts(cctx.inspect); len(published) > 0 {
+			log.Info().
+				Str("container", cctx.containerName).
+				Str("port", published[0]).
+				Msg("Container port label not set, using the lowest published port for all-ports")
+			return published[0], nil
+		}
+	}
+
+	ports := exposedTCPPorts(cctx.inspect)
+	if c.autoTargetPort && len(ports) == 1 {
+		log.Info().
+			Str("container", cctx.containerName).
+			Str("port", ports[0]).
+			Msg("Container port label not set, using the only port the container exposes")
+		return ports[0], nil
+	}
+
+	switch len(ports) {
+	case 0:
+		return "", fmt.Errorf("missing required label: %s", apptypes.LabelTarget)
+	case 1:
+		return "", fmt.Errorf("missing required label: %s (the container exposes port %s; set AUTO_TARGET_PORT=true to use it automatically)", apptypes.LabelTarget, ports[0])
+	default:
+		return "", fmt.Errorf("missing required label: %s (the container exposes ports %s; set the label to pick one)", apptypes.LabelTarget, strings.Join(ports, ", "))
+	}
+}
@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestMissingTargetPort(t *testing.T) {
+	tests := []struct {
+		name       string
+		auto       bool
+		bindings   map[string]string
+		exposed    []string
+		wantTarget string
+		wantErr    string
+	}{
+		{"single published port is inferred", true, map[string]string{"8080": "18080"}, nil, "18080", ""},
+		{"single exposed port is inferred", true, map[string]string{"3000": "13000"}, []string{"3000/tcp", "53/udp"}, "13000", ""},
+		{"several ports are ambiguous", true, map[string]string{"8080": "18080"}, []string{"9090/tcp", "443/tcp"}, "", "exposes ports 443, 8080, 9090"},
+		{"no ports", true, nil, nil, "", "missing required label: " + apptypes.LabelTarget},
+		{"inference is opt-in", false, map[string]string{"8080": "18080"}, nil, "", "set AUTO_TARGET_PORT=true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{
+				apptypes.LabelEnable:  "true",
+				apptypes.LabelService: "web",
+				apptypes.LabelDirect:  "false",
+			}
+			_, inspect := newFakeContainer("abcdef1234567890", "web", labels, tt.bindings)
+			inspect.Config.ExposedPorts = nat.PortSet{}
+			for _, port := range tt.exposed {
+				inspect.Config.ExposedPorts[nat.Port(port)] = struct{}{}
+			}
+			client := &Client{
+				cli:            &fakeDockerAPI{inspects: map[string]container.InspectResponse{inspect.ID: inspect}},
+				autoTargetPort: tt.auto,
+			}
+
+			services, err := client.parseContainer(context.Background(), inspect.ID, labels)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseContainer() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseContainer() error = %v", err)
+			}
+			if len(services) != 1 || services[0].TargetPort != tt.wantTarget {
+				t.Errorf("services = %+v, want one proxying to port %s", services, tt.wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+)
+
+// doctorTimeout bounds each check of `docktail doctor`
+const doctorTimeout = 10 * time.Second
+
+// Outcomes of a doctor check. Only failures make the command exit non-zero.
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// checkResult is the outcome of a doctor check
+type checkResult struct {
+	Status  string
+	Message string
+	Hint    string // how to fix a warning or failure
+}
+
+// doctorEnv is the environment the checks inspect. Tests replace its
+// functions with fakes.
+type doctorEnv struct {
+	socket       string
+	dockerHost   string // shown for a host without an endpoint
+	pingDocker   func(ctx context.Context) []docker.HostPing
+	lookPath     func(file string) (string, error)
+	statFile     func(name string) (os.FileInfo, error)
+	runTailscale func(ctx context.Context, args ...string) ([]byte, error)
+
+	statusOnce sync.Once
+	status     *doctorStatus
+	statusErr  error
+}
+
+// doctorStatus is the subset of 'tailscale status --json' the checks read
+type doctorStatus struct {
+	BackendState string `json:"BackendState"`
+	Self         *struct {
+		Tags   []string                   `json:"Tags"`
+		CapMap map[string]json.RawMessage `json:"CapMap"`
+	} `json:"Self"`
+}
+
+// tailscaleStatus runs 'tailscale status --json' once and shares the result
+// between the checks
+func (e *doctorEnv) tailscaleStatus(ctx context.Context) (*doctorStatus, error) {
+	e.statusOnce.Do(func() {
+		output, err := e.runTailscale(ctx, "status", "--json")
+		if err != nil {
+			e.statusErr = fmt.Errorf("tailscale status failed: %w: %s", err, strings.TrimSpace(string(output)))
+			return
+		}
+		// The CLI may print warnings before the JSON
+		if i := bytes.IndexByte(output, '{'); i > 0 {
+			output = output[i:]
+		}
+		var status doctorStatus
+		if err := json.Unmarshal(output, &status); err != nil {
+			e.statusErr = fmt.Errorf("failed to parse tailscale status: %w", err)
+			return
+		}
+		e.status = &status
+	})
+	return e.status, e.statusErr
+}
+
+// doctorCheck is a named diagnostic. New checks are added to doctorChecks.
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context, env *doctorEnv) checkResult
+}
+
+var doctorChecks = []doctorCheck{
+	{"docker", checkDocker},
+	{"tailscale cli", checkTailscaleCLI},
+	{"tailscaled", checkTailscaled},
+	{"login", checkLogin},
+	{"node tags", checkNodeTags},
+	{"serve", checkServe},
+	{"https", checkHTTPS},
+	{"funnel", checkFunnel},
+}
+
+// runDoctor checks the environment DockTail depends on and prints the
+// results. It returns 1 when any check failed.
+func runDoctor(cfg *config, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	env := &doctorEnv{
+		socket:     cfg.TailscaleSocket,
+		dockerHost: "unix:///var/run/docker.sock",
+		lookPath:   exec.LookPath,
+		statFile:   os.Stat,
+	}
+	if len(cfg.Docker.Hosts) > 0 {
+		env.dockerHost = cfg.Docker.Hosts[0]
+	}
+	env.runTailscale = func(ctx context.Context, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, "tailscale", append([]string{"--socket=" + env.socket}, args...)...).CombinedOutput()
+	}
+
+	dockerClient, err := docker.NewClient(cfg.Docker)
+	if err != nil {
+		env.pingDocker = func(context.Context) []docker.HostPing {
+			return []docker.HostPing{{Err: err}}
+		}
+	} else {
+		defer func() { _ = dockerClient.Close() }()
+		env.pingDocker = dockerClient.Ping
+	}
+
+	return runDoctorChecks(context.Background(), env, doctorChecks, out)
+}
+
+// runDoctorChecks runs the checks in order, printing a line per check and the
+// hint of those that didn't pass
+func runDoctorChecks(ctx context.Context, env *doctorEnv, checks []doctorCheck, out io.Writer) int {
+	failed := 0
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+		result := check.run(checkCtx, env)
+		cancel()
+
+		fmt.Fprintf(out, "[%s] %s: %s\n", strings.ToUpper(result.Status), check.name, result.Message)
+		if result.Hint != "" && result.Status != checkPass {
+			fmt.Fprintf(out, "       %s\n", result.Hint)
+		}
+		if result.Status == checkFail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(out, "%d check(s) failed\n", failed)
+		return 1
+	}
+	return 0
+}
+
+// checkDocker pings every Docker host
+func checkDocker(ctx context.Context, env *doctorEnv) checkResult {
+	var versions, failures []string
+	for _, ping := range env.pingDocker(ctx) {
+		host := ping.Endpoint
+		if host == "" {
+			host = env.dockerHost
+		}
+		if ping.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", host, ping.Err))
+			continue
+		}
+		versions = append(versions, fmt.Sprintf("%s (API %s)", host, ping.APIVersion))
+	}
+
+	if len(failures) > 0 {
+		return checkResult{
+			Status:  checkFail,
+			Message: "Docker is unreachable: " + strings.Join(failures, "; "),
+			Hint:    "Mount the Docker socket into the container (-v /var/run/docker.sock:/var/run/docker.sock:ro) or point DOCKER_HOST at the daemon",
+		}
+	}
+	return checkResult{Status: checkPass, Message: strings.Join(versions, ", ")}
+}
+
+// checkTailscaleCLI checks that the tailscale CLI is installed and runs
+func checkTailscaleCLI(ctx context.Context, env *doctorEnv) checkResult {
+	path, err := env.lookPath("tailscale")
+	if err != nil {
+		return checkResult{
+			Status:  checkFail,
+			Message: "tailscale CLI not found in PATH",
+			Hint:    "Install the tailscale CLI, or run the DockTail image, which ships it",
+		}
+	}
+
+	output, err := env.runTailscale(ctx, "version")
+	if err != nil {
+		return checkResult{
+			Status:  checkFail,
+			Message: fmt.Sprintf("%s version failed: %v", path, err),
+			Hint:    "Check that the tailscale CLI matches this platform",
+		}
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return checkResult{Status: checkPass, Message: fmt.Sprintf("%s %s", path, version)}
+}
+
+// checkTailscaled checks that tailscaled answers on the configured socket
+func checkTailscaled(ctx context.Context, env *doctorEnv) checkResult {
+	if _, err := env.statFile(env.socket); err != nil {
+		return checkResult{
+			Status:  checkFail,
+			Message: fmt.Sprintf("socket %s: %v", env.socket, err),
+			Hint:    "Mount tailscaled's socket (-v /var/run/tailscale:/var/run/tailscale) or set TAILSCALE_SOCKET to its path",
+		}
+	}
+	if _, err := env.tailscaleStatus(ctx); err != nil {
+		return checkResult{
+			Status:  checkFail,
+			Message: err.Error(),
+			Hint:    "Check that tailscaled is running and that DockTail may read and write its socket",
+		}
+	}
+	return checkResult{Status: checkPass, Message: "reachable at " + env.socket}
+}
+
+// skipped is the result of checks that need the tailscale status when
+// tailscaled can't be queried, which the tailscaled check reports
+func skipped() checkResult {
+	return checkResult{Status: checkSkip, Message: "tailscale status is unavailable"}
+}
+
+// checkLogin checks that the node is logged in and running
+func checkLogin(ctx context.Context, env *doctorEnv) checkResult {
+	status, err := env.tailscaleStatus(ctx)
+	if err != nil {
+		return skipped()
+	}
+
+	switch status.BackendState {
+	case "Running":
+		return checkResult{Status: checkPass, Message: "logged in, backend running"}
+	case "NeedsLogin":
+		return checkResult{
+			Status:  checkFail,
+			Message: "node is not logged in",
+			Hint:    "Run tailscale up, or set TS_AUTHKEY on the Tailscale sidecar",
+		}
+	case "NeedsMachineAuth":
+		return checkResult{
+			Status:  checkFail,
+			Message: "node is waiting for approval",
+			Hint:    "Approve the machine at https://login.tailscale.com/admin/machines",
+		}
+	case "Stopped":
+		return checkResult{
+			Status:  checkFail,
+			Message: "tailscale is stopped",
+			Hint:    "Run tailscale up",
+		}
+	default:
+		return checkResult{
+			Status:  checkWarn,
+			Message: fmt.Sprintf("backend state is %q", status.BackendState),
+			Hint:    "Wait for tailscaled to finish starting and run the doctor again",
+		}
+	}
+}
+
+// checkNodeTags checks that the node is tagged, which service hosts must be
+func checkNodeTags(ctx context.Context, env *doctorEnv) checkResult {
+	status, err := env.tailscaleStatus(ctx)
+	if err != nil {
+		return skipped()
+	}
+	if status.Self == nil || len(status.Self.Tags) == 0 {
+		return checkResult{
+			Status:  checkFail,
+			Message: "node has no ACL tags",
+			Hint:    "Tailscale Services need a tagged host: tailscale up --advertise-tags=tag:server, or tag the node in the admin console",
+		}
+	}
+	return checkResult{Status: checkPass, Message: strings.Join(status.Self.Tags, ", ")}
+}
+
+// checkServe checks that tailscaled supports serve status
+func checkServe(ctx context.Context, env *doctorEnv) checkResult {
+	if _, err := env.tailscaleStatus(ctx); err != nil {
+		return skipped()
+	}
+	output, err := env.runTailscale(ctx, "serve", "status", "--json")
+	if err != nil {
+		return checkResult{
+			Status:  checkFail,
+			Message: fmt.Sprintf("tailscale serve status failed: %v: %s", err, strings.TrimSpace(string(output))),
+			Hint:    "Update Tailscale to a release with Tailscale Services support",
+		}
+	}
+	return checkResult{Status: checkPass, Message: "serve is available"}
+}
+
+// checkHTTPS checks that HTTPS certificates are enabled for the tailnet,
+// which https services and funnels need
+func checkHTTPS(ctx context.Context, env *doctorEnv) checkResult {
+	status, err := env.tailscaleStatus(ctx)
+	if err != nil {
+		return skipped()
+	}
+	if !status.hasCap("https") {
+		return checkResult{
+			Status:  checkWarn,
+			Message: "HTTPS certificates are not enabled, https services and funnels will fail",
+			Hint:    "Enable HTTPS Certificates at https://login.tailscale.com/admin/dns",
+		}
+	}
+	return checkResult{Status: checkPass, Message: "HTTPS certificates enabled"}
+}
+
+// checkFunnel checks that the node may use Funnel. Only containers with
+// funnel labels need it, so a missing attribute is a warning.
+func checkFunnel(ctx context.Context, env *doctorEnv) checkResult {
+	status, err := env.tailscaleStatus(ctx)
+	if err != nil {
+		return skipped()
+	}
+	if !status.hasCap("funnel") {
+		return checkResult{
+			Status:  checkWarn,
+			Message: "node is not allowed to use Funnel",
+			Hint:    `Grant the funnel attribute in the tailnet policy: "nodeAttrs": [{"target": ["tag:server"], "attr": ["funnel"]}]`,
+		}
+	}
+	return checkResult{Status: checkPass, Message: "Funnel allowed"}
+}
+
+// hasCap reports whether the node has a capability or node attribute
+func (s *doctorStatus) hasCap(name string) bool {
+	if s.Self == nil {
+		return false
+	}
+	_, ok := s.Self.CapMap[name]
+	return ok
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/marvinvr/docktail/docker"
+)
+
+const healthyStatus = `{"BackendState":"Running","Self":{"Tags":["tag:server"],"CapMap":{"https":null,"funnel":null}}}`
+
+// fakeDoctorEnv returns an environment where every check passes, answering
+// tailscale commands from outputs keyed by their arguments
+func fakeDoctorEnv(outputs map[string]string) *doctorEnv {
+	defaults := map[string]string{
+		"version":             "1.90.0\n  tailscale commit: abc",
+		"status --json":       healthyStatus,
+		"serve status --json": "{}",
+	}
+	for args, output := range outputs {
+		defaults[args] = output
+	}
+
+	return &doctorEnv{
+		socket:     "/var/run/tailscale/tailscaled.sock",
+		dockerHost: "unix:///var/run/docker.sock",
+		pingDocker: func(context.Context) []docker.HostPing {
+			return []docker.HostPing{{Endpoint: "unix:///var/run/docker.sock", APIVersion: "1.47"}}
+		},
+		lookPath: func(file string) (string, error) { return "/usr/bin/" + file, nil },
+		statFile: func(name string) (os.FileInfo, error) { return nil, nil },
+		runTailscale: func(ctx context.Context, args ...string) ([]byte, error) {
+			output, ok := defaults[strings.Join(args, " ")]
+			if !ok || strings.HasPrefix(output, "error:") {
+				return []byte(output), errors.New("exit status 1")
+			}
+			return []byte(output), nil
+		},
+	}
+}
+
+func TestDoctorChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  func(ctx context.Context, env *doctorEnv) checkResult
+		env    func() *doctorEnv
+		status string
+		want   string // part of the message
+	}{
+		{
+			name:   "docker reachable",
+			check:  checkDocker,
+			env:    func() *doctorEnv { return fakeDoctorEnv(nil) },
+			status: checkPass,
+			want:   "API 1.47",
+		},
+		{
+			name:  "docker socket not mounted",
+			check: checkDocker,
+			env: func() *doctorEnv {
+				env := fakeDoctorEnv(nil)
+				env.pingDocker = func(context.Context) []docker.HostPing {
+					return []docker.HostPing{{Endpoint: "unix:///var/run/docker.sock", Err: errors.New("no such file or directory")}}
+				}
+				return env
+			},
+			status: checkFail,
+			want:   "no such file or directory",
+		},
+		{
+			name:  "tailscale binary missing",
+			check: checkTailscaleCLI,
+			env: func() *doctorEnv {
+				env := fakeDoctorEnv(nil)
+				env.lookPath = func(string) (string, error) { return "", errors.New("not found") }
+				return env
+			},
+			status: checkFail,
+			want:   "not found in PATH",
+		},
+		{
+			name:   "tailscale version",
+			check:  checkTailscaleCLI,
+			env:    func() *doctorEnv { return fakeDoctorEnv(nil) },
+			status: checkPass,
+			want:   "/usr/bin/tailscale 1.90.0",
+		},
+		{
+			name:  "socket missing",
+			check: checkTailscaled,
+			env: func() *doctorEnv {
+				env := fakeDoctorEnv(nil)
+				env.statFile = func(name string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+				return env
+			},
+			status: checkFail,
+			want:   "/var/run/tailscale/tailscaled.sock",
+		},
+		{
+			name:  "tailscaled not answering",
+			check: checkTailscaled,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": "error: connection refused"})
+			},
+			status: checkFail,
+			want:   "connection refused",
+		},
+		{
+			name:  "needs login",
+			check: checkLogin,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": `{"BackendState":"NeedsLogin"}`})
+			},
+			status: checkFail,
+			want:   "not logged in",
+		},
+		{
+			name:  "login skipped without status",
+			check: checkLogin,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": "error: connection refused"})
+			},
+			status: checkSkip,
+		},
+		{
+			name:  "untagged node",
+			check: checkNodeTags,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": `{"BackendState":"Running","Self":{"Tags":null}}`})
+			},
+			status: checkFail,
+			want:   "no ACL tags",
+		},
+		{
+			name:   "tagged node",
+			check:  checkNodeTags,
+			env:    func() *doctorEnv { return fakeDoctorEnv(nil) },
+			status: checkPass,
+			want:   "tag:server",
+		},
+		{
+			name:  "serve unsupported",
+			check: checkServe,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"serve status --json": "error: unknown flag"})
+			},
+			status: checkFail,
+			want:   "unknown flag",
+		},
+		{
+			name:  "https disabled",
+			check: checkHTTPS,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": `{"BackendState":"Running","Self":{"CapMap":{"funnel":null}}}`})
+			},
+			status: checkWarn,
+			want:   "HTTPS certificates are not enabled",
+		},
+		{
+			name:  "funnel not allowed",
+			check: checkFunnel,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": `{"BackendState":"Running","Self":{"CapMap":{"https":null}}}`})
+			},
+			status: checkWarn,
+			want:   "not allowed to use Funnel",
+		},
+		{
+			name:  "status after CLI warnings",
+			check: checkFunnel,
+			env: func() *doctorEnv {
+				return fakeDoctorEnv(map[string]string{"status --json": "Warning: client version mismatch\n" + healthyStatus})
+			},
+			status: checkPass,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.check(context.Background(), tt.env())
+			if result.Status != tt.status {
+				t.Errorf("status = %q, want %q (%s)", result.Status, tt.status, result.Message)
+			}
+			if !strings.Contains(result.Message, tt.want) {
+				t.Errorf("message = %q, want it to contain %q", result.Message, tt.want)
+			}
+			if result.Status != checkPass && result.Status != checkSkip && result.Hint == "" {
+				t.Error("a check that didn't pass should give a hint")
+			}
+		})
+	}
+}
+
+func TestRunDoctorChecks(t *testing.T) {
+	t.Run("all pass", func(t *testing.T) {
+		var out strings.Builder
+		if code := runDoctorChecks(context.Background(), fakeDoctorEnv(nil), doctorChecks, &out); code != 0 {
+			t.Errorf("exit code = %d, want 0; output:\n%s", code, out.String())
+		}
+		if strings.Contains(out.String(), "[FAIL]") || strings.Contains(out.String(), "[WARN]") {
+			t.Errorf("output has problems:\n%s", out.String())
+		}
+	})
+
+	t.Run("warnings don't fail", func(t *testing.T) {
+		env := fakeDoctorEnv(map[string]string{"status --json": `{"BackendState":"Running","Self":{"Tags":["tag:server"],"CapMap":{"https":null}}}`})
+		var out strings.Builder
+		if code := runDoctorChecks(context.Background(), env, doctorChecks, &out); code != 0 {
+			t.Errorf("exit code = %d, want 0; output:\n%s", code, out.String())
+		}
+		if !strings.Contains(out.String(), "[WARN] funnel: ") {
+			t.Errorf("output lacks the funnel warning:\n%s", out.String())
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		env := fakeDoctorEnv(map[string]string{"status --json": "error: connection refused"})
+		var out strings.Builder
+		if code := runDoctorChecks(context.Background(), env, doctorChecks, &out); code != 1 {
+			t.Errorf("exit code = %d, want 1; output:\n%s", code, out.String())
+		}
+		for _, line := range []string{"[FAIL] tailscaled: ", "[SKIP] node tags: ", "1 check(s) failed"} {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("output lacks %q:\n%s", line, out.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// exportTimeout bounds how long `docktail export` waits for Docker and tailscaled
+const exportTimeout = 30 * time.Second
+
+// exportDocument is what `docktail export --include-current` writes
+type exportDocument struct {
+	Desired *apptypes.TailscaleServiceConfig `json:"desired"`
+	Current *apptypes.TailscaleServiceConfig `json:"current"`
+}
+
+// runExport discovers the managed containers and writes the service
+// configuration DockTail would apply, without applying it. It returns the exit
+// code.
+func runExport(cfg *config, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	file := flags.String("file", "", "write to this file instead of stdout")
+	socket := flags.String("socket", "", "export the services of this TAILSCALE_SOCKETS name instead of the default socket")
+	includeCurrent := flags.Bool("include-current", false, "also write the configuration currently on the node")
+	diff := flags.Bool("diff", false, "print the changes from the current configuration instead of JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var client *tailscale.Client
+	for _, target := range cleanupTargets(cfg) {
+		if target.name == *socket {
+			client = target.client
+		}
+	}
+	if client == nil {
+		fmt.Fprintf(os.Stderr, "unknown socket %q: not in TAILSCALE_SOCKETS\n", *socket)
+		return 2
+	}
+
+	dockerClient, err := docker.NewClient(cfg.Docker)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create Docker client: %v\n", err)
+		return 1
+	}
+	defer func() { _ = dockerClient.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	discovery, err := dockerClient.Discover(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to discover containers: %v\n", err)
+		return 1
+	}
+	for _, skipped := range discovery.Errors {
+		fmt.Fprintf(os.Stderr, "skipped container %s: %v\n", skipped.ContainerName, skipped.Err)
+	}
+	desired := client.DesiredConfig(socketServices(discovery.Services, *socket))
+
+	var current *apptypes.TailscaleServiceConfig
+	if *includeCurrent || *diff {
+		client.DetectVersionMismatch(ctx)
+		if current, err = client.CurrentConfig(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read the current configuration: %v\n", err)
+			return 1
+		}
+	}
+
+	var buf bytes.Buffer
+	switch {
+	case *diff:
+		writeConfigDiff(&buf, tailscale.DiffConfig(current, desired))
+	case *includeCurrent:
+		err = writeExportJSON(&buf, exportDocument{Desired: desired, Current: current})
+	default:
+		err = writeExportJSON(&buf, desired)
+	}
+	if err == nil {
+		if *file != "" {
+			err = os.WriteFile(*file, buf.Bytes(), 0o644)
+		} else {
+			_, err = out.Write(buf.Bytes())
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// socketServices returns the services routed to the named socket, the default
+// socket for an empty name
+func socketServices(services []*apptypes.ContainerService, socket string) []*apptypes.ContainerService {
+	var routed []*apptypes.ContainerService
+	for _, svc := range services {
+		if svc.Socket == socket {
+			routed = append(routed, svc)
+		}
+	}
+	return routed
+}
+
+// writeExportJSON writes v as indented JSON. Map keys are sorted, so exports
+// of the same configuration are byte-for-byte identical.
+func writeExportJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeConfigDiff writes a line per changed endpoint
+func writeConfigDiff(w io.Writer, changes []tailscale.ConfigChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	for _, change := range changes {
+		fmt.Fprintln(w, change.String())
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestWriteExportJSONIsSorted(t *testing.T) {
+	services := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "8443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "8080"},
+		{ContainerName: "db", ServiceEnabled: true, ServiceName: "db", Port: "5432", ServiceProtocol: "tcp", Protocol: "tcp", IPAddress: "172.17.0.4", TargetPort: "5432"},
+		{ContainerName: "other", ServiceEnabled: true, ServiceName: "other", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.5", TargetPort: "80", Socket: "work"},
+	}
+
+	var buf bytes.Buffer
+	client := tailscale.NewClient(tailscale.ClientConfig{})
+	if err := writeExportJSON(&buf, client.DesiredConfig(socketServices(services, ""))); err != nil {
+		t.Fatalf("writeExportJSON() error = %v", err)
+	}
+
+	want := `{
+  "version": "0.0.1",
+  "services": {
+    "svc:api": {
+      "endpoints": {
+        "tcp:8443": "http://172.17.0.3:8080"
+      }
+    },
+    "svc:db": {
+      "endpoints": {
+        "tcp:5432": "tcp://172.17.0.4:5432"
+      }
+    },
+    "svc:web": {
+      "endpoints": {
+        "tcp:443": "http://172.17.0.2:80"
+      }
+    }
+  }
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("export =\n%s\nwant\n%s", got, want)
+	}
+}
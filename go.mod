@@ -5,10 +5,12 @@ go 1.25.1
 require (
 	github.com/docker/docker v28.5.2+incompatible
 	github.com/docker/go-connections v0.6.0
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/rs/zerolog v1.34.0
 	github.com/yuin/goldmark v1.8.2
 	golang.org/x/oauth2 v0.34.0
 	golang.org/x/term v0.40.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/marvinvr/docktail/api"
+	"github.com/marvinvr/docktail/reconciler"
+)
+
+// healthcheckTimeout bounds the request to the HTTP API, well within the
+// timeout of the image's HEALTHCHECK
+const healthcheckTimeout = 3 * time.Second
+
+// runHealthcheck reports whether the running DockTail reconciled successfully
+// within the last few intervals, for Docker's HEALTHCHECK. It asks the HTTP
+// API when API_ADDR is set and otherwise reads the state directory, so it
+// needs neither the Docker nor the Tailscale socket. It returns 1 when
+// DockTail is unhealthy.
+func runHealthcheck(cfg *config, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: docktail healthcheck")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	client := &http.Client{Timeout: healthcheckTimeout}
+	healthy, message := checkHealth(cfg, client, time.Now())
+	fmt.Fprintln(out, message)
+	if !healthy {
+		return 1
+	}
+	return 0
+}
+
+// checkHealth returns whether DockTail is healthy and a line explaining why
+func checkHealth(cfg *config, client *http.Client, now time.Time) (bool, string) {
+	switch {
+	case cfg.APIAddr != "":
+		return checkHealthAPI(client, cfg.APIAddr, now)
+	case cfg.Docker.StateDir != "":
+		return checkHealthState(cfg.Docker.StateDir, cfg.ReconcileInterval, now)
+	default:
+		// Failing would mark every container without the options unhealthy
+		return true, "healthy: set API_ADDR or STATE_DIR to check reconciliation"
+	}
+}
+
+// checkHealthAPI asks the health endpoint of the HTTP API listening on addr
+func checkHealthAPI(client *http.Client, addr string, now time.Time) (bool, string) {
+	url, err := localHealthURL(addr)
+	if err != nil {
+		return false, "unhealthy: " + err.Error()
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return false, "unhealthy: " + err.Error()
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var health api.HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return false, fmt.Sprintf("unhealthy: %s answered %s", url, resp.Status)
+	}
+	var lastSuccess time.Time
+	if health.LastSuccess != nil {
+		lastSuccess = *health.LastSuccess
+	}
+	return resp.StatusCode == http.StatusOK, healthMessage(resp.StatusCode == http.StatusOK, lastSuccess, now)
+}
+
+// checkHealthState reads the last successful cycle from the state directory
+func checkHealthState(stateDir string, interval time.Duration, now time.Time) (bool, string) {
+	lastSuccess, err := reconciler.ReadLastSuccess(stateDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, "unhealthy: no successful reconciliation recorded in " + stateDir
+	}
+	if err != nil {
+		return false, "unhealthy: " + err.Error()
+	}
+
+	healthy := reconciler.Healthy(lastSuccess, interval, now)
+	return healthy, healthMessage(healthy, lastSuccess, now)
+}
+
+func healthMessage(healthy bool, lastSuccess, now time.Time) string {
+	status := "healthy"
+	if !healthy {
+		status = "unhealthy"
+	}
+	if lastSuccess.IsZero() {
+		return status + ": no successful reconciliation yet"
+	}
+	return fmt.Sprintf("%s: last successful reconciliation %s ago", status, now.Sub(lastSuccess).Round(time.Second))
+}
+
+// localHealthURL returns the URL of the health endpoint of an API listening
+// on addr, reaching a wildcard address through loopback
+func localHealthURL(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid API_ADDR %q: %w", addr, err)
+	}
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/healthz", nil
+}
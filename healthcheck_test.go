@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marvinvr/docktail/api"
+	"github.com/marvinvr/docktail/reconciler"
+)
+
+func TestHealthcheckState(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		file      string // contents of the last-success file, empty for none
+		wantCode  int
+		wantInOut string
+	}{
+		{name: "fresh", file: now.Add(-90 * time.Second).Format(time.RFC3339Nano), wantCode: 0, wantInOut: "healthy: last successful reconciliation 1m30s ago"},
+		{name: "stale", file: now.Add(-time.Hour).Format(time.RFC3339Nano), wantCode: 1, wantInOut: "unhealthy: last successful reconciliation 1h0m0s ago"},
+		{name: "missing", wantCode: 1, wantInOut: "unhealthy: no successful reconciliation recorded"},
+		{name: "corrupt", file: "yesterday", wantCode: 1, wantInOut: "unhealthy: invalid last-success"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			if tt.file != "" {
+				if err := os.WriteFile(filepath.Join(stateDir, "last-success"), []byte(tt.file+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := &config{ReconcileInterval: time.Minute}
+			cfg.Docker.StateDir = stateDir
+
+			healthy, message := checkHealth(cfg, http.DefaultClient, now)
+			if code := map[bool]int{true: 0, false: 1}[healthy]; code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d (%s)", code, tt.wantCode, message)
+			}
+			if !strings.Contains(message, tt.wantInOut) {
+				t.Errorf("message = %q, want it to contain %q", message, tt.wantInOut)
+			}
+		})
+	}
+}
+
+func TestHealthcheckAPI(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastSuccess time.Time
+		wantCode    int
+	}{
+		{name: "fresh", lastSuccess: time.Now().Add(-time.Minute), wantCode: 0},
+		{name: "stale", lastSuccess: time.Now().Add(-time.Hour), wantCode: 1},
+		{name: "no success yet", wantCode: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &healthController{state: reconciler.State{Interval: time.Minute, LastSuccessAt: tt.lastSuccess}}
+			server := httptest.NewServer(api.NewServer(controller, "").Handler())
+			t.Cleanup(server.Close)
+
+			cfg := &config{ReconcileInterval: time.Minute, APIAddr: strings.TrimPrefix(server.URL, "http://")}
+			var out bytes.Buffer
+			if code := runHealthcheck(cfg, nil, &out); code != tt.wantCode {
+				t.Errorf("runHealthcheck() = %d, want %d (%s)", code, tt.wantCode, out.String())
+			}
+		})
+	}
+
+	// Nothing listening is unhealthy
+	cfg := &config{APIAddr: "127.0.0.1:1"}
+	if code := runHealthcheck(cfg, nil, &bytes.Buffer{}); code != 1 {
+		t.Errorf("runHealthcheck() without a listener = %d, want 1", code)
+	}
+}
+
+func TestLocalHealthURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: ":8080", want: "http://127.0.0.1:8080/healthz"},
+		{addr: "0.0.0.0:8080", want: "http://127.0.0.1:8080/healthz"},
+		{addr: "[::]:8080", want: "http://[::1]:8080/healthz"},
+		{addr: "100.64.0.1:8080", want: "http://100.64.0.1:8080/healthz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := localHealthURL(tt.addr)
+			if err != nil || got != tt.want {
+				t.Errorf("localHealthURL(%q) = %q, %v, want %q", tt.addr, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// healthController serves a fixed reconciler state over the HTTP API
+type healthController struct {
+	state reconciler.State
+}
+
+func (c *healthController) TriggerReconcile()               {}
+func (c *healthController) Interval() time.Duration         { return c.state.Interval }
+func (c *healthController) SetInterval(time.Duration) error { return nil }
+func (c *healthController) State() reconciler.State         { return c.state }
+func (c *healthController) Pause()                          {}
+func (c *healthController) Resume()                         {}
+func (c *healthController) SetMaintenance(bool)             {}
+
+func (c *healthController) History(string) []reconciler.HistoryEvent { return nil }
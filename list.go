@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/tailscale"
+)
+
+// listTimeout bounds how long `docktail list` waits for Docker
+const listTimeout = 30 * time.Second
+
+// listEntry is one row of `docktail list`: a service endpoint, a funnel, or a
+// container that was skipped
+type listEntry struct {
+	Container       string `json:"container"`
+	DockerHost      string `json:"docker_host,omitempty"`
+	Service         string `json:"service,omitempty"`
+	Destination     string `json:"destination,omitempty"`
+	ServicePort     string `json:"service_port,omitempty"`
+	ServiceProtocol string `json:"service_protocol,omitempty"`
+	Funnel          string `json:"funnel,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// runList discovers the managed containers the way the reconciler does and
+// prints their services, without talking to Tailscale. It returns the exit code.
+func runList(cfg *config, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	output := flags.String("output", "table", "output format: table or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid --output %q: must be table or json\n", *output)
+		return 2
+	}
+
+	dockerClient, err := docker.NewClient(cfg.Docker)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create Docker client: %v\n", err)
+		return 1
+	}
+	defer func() { _ = dockerClient.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	discovery, err := dockerClient.Discover(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to discover containers: %v\n", err)
+		return 1
+	}
+
+	entries := listEntries(discovery)
+	if *output == "json" {
+		err = writeListJSON(out, entries)
+	} else {
+		err = writeListTable(out, entries)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// listEntries turns a discovery into rows sorted by container, service and port
+func listEntries(discovery docker.Discovery) []listEntry {
+	entries := make([]listEntry, 0, len(discovery.Services)+len(discovery.Errors))
+	for _, svc := range discovery.Services {
+		entry := listEntry{
+			Container:  svc.ContainerName,
+			DockerHost: svc.DockerHost,
+			Service:    svc.ServiceName,
+		}
+		switch {
+		case svc.Preserve:
+			entry.Error = "image not allowed by IMAGE_ALLOWLIST, existing services left untouched"
+		case svc.ServiceEnabled:
+			entry.Destination = tailscale.BuildDestination(svc)
+			entry.ServicePort = svc.Port
+			entry.ServiceProtocol = svc.ServiceProtocol
+		default:
+			// A funnel without a service proxies to its own backend
+			entry.Destination = tailscale.FunnelDestination(svc)
+		}
+		if svc.FunnelEnabled {
+			entry.Funnel = svc.FunnelFunnelPort + "/" + svc.FunnelProtocol
+		}
+		entries = append(entries, entry)
+	}
+	for _, skipped := range discovery.Errors {
+		entries = append(entries, listEntry{
+			Container:  skipped.ContainerName,
+			DockerHost: skipped.DockerHost,
+			Error:      skipped.Err.Error(),
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.DockerHost != b.DockerHost {
+			return a.DockerHost < b.DockerHost
+		}
+		if a.Container != b.Container {
+			return a.Container < b.Container
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.ServicePort < b.ServicePort
+	})
+	return entries
+}
+
+// writeListTable prints the entries as an aligned table
+func writeListTable(w io.Writer, entries []listEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tSERVICE\tDESTINATION\tPORT\tFUNNEL\tERROR")
+	for _, entry := range entries {
+		container := entry.Container
+		if entry.DockerHost != "" {
+			container = entry.DockerHost + "/" + container
+		}
+		port := ""
+		if entry.ServicePort != "" {
+			port = entry.ServicePort + "/" + entry.ServiceProtocol
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			container,
+			orDash(entry.Service),
+			orDash(entry.Destination),
+			orDash(port),
+			orDash(entry.Funnel),
+			orDash(entry.Error),
+		)
+	}
+	return tw.Flush()
+}
+
+// writeListJSON prints the entries as a JSON array
+func writeListJSON(w io.Writer, entries []listEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/marvinvr/docktail/docker"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// listFixture covers a service with a funnel, a funnel without a service, a
+// container outside the image allowlist and one that failed to parse
+func listFixture() docker.Discovery {
+	return docker.Discovery{
+		Services: []*apptypes.ContainerService{
+			{
+				ContainerName: "web", ServiceEnabled: true, ServiceName: "web",
+				Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080",
+				FunnelEnabled: true, FunnelFunnelPort: "443", FunnelProtocol: "https", FunnelTargetPort: "8080",
+			},
+			{
+				ContainerName: "blog", FunnelEnabled: true, FunnelFunnelPort: "8443", FunnelProtocol: "https",
+				FunnelTargetPort: "2368", IPAddress: "172.17.0.3",
+			},
+			{ContainerName: "legacy", ServiceName: "legacy", Preserve: true},
+		},
+		Errors: []docker.ContainerError{
+			{ContainerID: "123456789abc", ContainerName: "api", Err: errors.New("missing required label: docktail.service.name")},
+		},
+	}
+}
+
+func TestWriteListTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeListTable(&buf, listEntries(listFixture())); err != nil {
+		t.Fatalf("writeListTable() error = %v", err)
+	}
+
+	want := `CONTAINER  SERVICE  DESTINATION             PORT       FUNNEL      ERROR
+api        -        -                       -          -           missing required label: docktail.service.name
+blog       -        http://172.17.0.3:2368  -          8443/https  -
+legacy     legacy   -                       -          -           image not allowed by IMAGE_ALLOWLIST, existing services left untouched
+web        web      http://172.17.0.2:8080  443/https  443/https   -
+`
+	if got := buf.String(); got != want {
+		t.Errorf("table =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteListJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeListJSON(&buf, listEntries(listFixture())); err != nil {
+		t.Fatalf("writeListJSON() error = %v", err)
+	}
+
+	var got []listEntry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	want := []listEntry{
+		{Container: "api", Error: "missing required label: docktail.service.name"},
+		{Container: "blog", Destination: "http://172.17.0.3:2368", Funnel: "8443/https"},
+		{Container: "legacy", Service: "legacy", Error: "image not allowed by IMAGE_ALLOWLIST, existing services left untouched"},
+		{Container: "web", Service: "web", Destination: "http://172.17.0.2:8080", ServicePort: "443", ServiceProtocol: "https", Funnel: "443/https"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the LOG_FILE rotation options
+const (
+	defaultLogMaxSize    = 100 // megabytes
+	defaultLogMaxBackups = 5
+)
+
+// logBackupTimeFormat is the time in the names of rotated log files, such as
+// docktail-2026-01-02T03-04-05.000.log
+const logBackupTimeFormat = "2006-01-02T15-04-05.000"
+
+var errLogFileClosed = errors.New("log file is closed")
+
+// logFile is a LOG_FILE that is rotated when a write would grow it past
+// maxSize. Rotated files are renamed with the time of the rotation; only the
+// newest maxBackups of them, none older than maxAge, are kept (zero keeps all).
+// Writes are serialized, so the loggers of every goroutine can share it.
+type logFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	now        func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openLogFile opens path for appending, creating it if needed
+func openLogFile(path string, maxSize int64, maxBackups int, maxAge time.Duration) (*logFile, error) {
+	f := &logFile{path: path, maxSize: maxSize, maxBackups: maxBackups, maxAge: maxAge, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at path. Must be called with f.mu held, or before f is shared.
+func (f *logFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *logFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, errLogFileClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing the entry
+			fmt.Fprintf(os.Stderr, "failed to rotate log file %s: %v\n", f.path, err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file to a backup, starts a new one and removes the
+// backups beyond maxBackups and maxAge. Must be called with f.mu held.
+func (f *logFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	renameErr := os.Rename(f.path, f.backupName(f.now()))
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	f.removeOldBackups()
+	return nil
+}
+
+// backupName returns the name of a backup rotated at t
+func (f *logFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.UTC().Format(logBackupTimeFormat) + ext
+}
+
+// removeOldBackups removes the backups beyond maxBackups and maxAge
+func (f *logFile) removeOldBackups() {
+	if f.maxBackups <= 0 && f.maxAge <= 0 {
+		return
+	}
+
+	backups := f.backups()
+	cutoff := f.now().Add(-f.maxAge)
+	for i, backup := range backups {
+		if (f.maxBackups > 0 && i >= f.maxBackups) || (f.maxAge > 0 && backup.rotated.Before(cutoff)) {
+			if err := os.Remove(backup.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "failed to remove old log file %s: %v\n", backup.path, err)
+			}
+		}
+	}
+}
+
+type logBackup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups lists the rotated files of the log file, newest first
+func (f *logFile) backups() []logBackup {
+	dir := filepath.Dir(f.path)
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(filepath.Base(f.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var backups []logBackup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		rotated, err := time.Parse(logBackupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{path: filepath.Join(dir, name), rotated: rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+	return backups
+}
+
+// Reopen closes the file and opens path again, so writes go to a new file
+// after logrotate moved the old one away
+func (f *logFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close closes the file; later writes fail
+func (f *logFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLogFile opens a log file in a temporary directory whose clock moves
+// a second per rotation, so every backup gets its own name
+func newTestLogFile(t *testing.T, maxSize int64, maxBackups int, maxAge time.Duration) *logFile {
+	t.Helper()
+	f, err := openLogFile(filepath.Join(t.TempDir(), "docktail.log"), maxSize, maxBackups, maxAge)
+	if err != nil {
+		t.Fatalf("openLogFile() error = %v", err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return f
+}
+
+func TestLogFileRotates(t *testing.T) {
+	f := newTestLogFile(t, 100, 0, 0)
+	line := []byte(strings.Repeat("x", 39) + "\n") // 40 bytes
+
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	// Two lines fit in 100 bytes, so five lines make two backups and a current file
+	backups := f.backups()
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2", len(backups))
+	}
+	for _, backup := range backups {
+		data, err := os.ReadFile(backup.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 80 {
+			t.Errorf("backup %s has %d bytes, want 80", backup.path, len(data))
+		}
+	}
+	if want := filepath.Join(filepath.Dir(f.path), "docktail-2026-01-02T03-04-07.000.log"); backups[0].path != want {
+		t.Errorf("newest backup = %s, want %s", backups[0].path, want)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 40 {
+		t.Errorf("current file has %d bytes, want 40", len(data))
+	}
+}
+
+func TestLogFileRemovesOldBackups(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxBackups  int
+		maxAge      time.Duration
+		wantBackups int
+	}{
+		{name: "keep all", wantBackups: 5},
+		{name: "max backups", maxBackups: 2, wantBackups: 2},
+		// The clock moves a second per rotation
+		{name: "max age", maxAge: 3 * time.Second, wantBackups: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestLogFile(t, 10, tt.maxBackups, tt.maxAge)
+			for i := 0; i < 6; i++ {
+				if _, err := f.Write([]byte("0123456789")); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if got := len(f.backups()); got != tt.wantBackups {
+				t.Errorf("got %d backups, want %d", got, tt.wantBackups)
+			}
+		})
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	f := newTestLogFile(t, 1<<20, 0, 0)
+	if _, err := f.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// logrotate moves the file away, then sends SIGHUP
+	moved := f.path + ".1"
+	if err := os.Rename(f.path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	if _, err := f.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{moved: "before\n", f.path: "after\n"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", path, data, want)
+		}
+	}
+}
+
+func TestLogFileConcurrentWrites(t *testing.T) {
+	f := newTestLogFile(t, 1000, 0, 0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := fmt.Fprintf(f, "goroutine %d line %03d\n", g, i); err != nil {
+					t.Errorf("Write() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every line ends up whole in exactly one file
+	var all bytes.Buffer
+	for _, backup := range f.backups() {
+		data, err := os.ReadFile(backup.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all.Write(data)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all.Write(data)
+
+	lines := strings.Split(strings.TrimSuffix(all.String(), "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("got %d lines, want 400", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "goroutine ") || len(line) != len("goroutine 0 line 000") {
+			t.Errorf("mangled line %q", line)
+		}
+	}
+}
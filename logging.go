@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+
+	"github.com/marvinvr/docktail/api"
+	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/reconciler"
+	"github.com/marvinvr/docktail/report"
+	"github.com/marvinvr/docktail/tailscale"
+)
+
+// Log formats (LOG_FORMAT)
+const (
+	logFormatConsole = "console"
+	logFormatJSON    = "json"
+)
+
+// logModules are the packages with a logger of their own, whose level
+// LOG_LEVEL_<MODULE> overrides
+var logModules = []string{"api", "docker", "reconciler", "report", "tailscale"}
+
+// setupLogging configures zerolog and the loggers of the packages to write to
+// out in format at levels and, unless it is nil, to file as JSON
+func setupLogging(out *os.File, format string, file io.Writer, levels *logLevels) {
+	// Configure zerolog
+	zerolog.TimeFieldFormat = time.RFC3339
+	_, noColor := os.LookupEnv("NO_COLOR") // adheres no-color.org
+	isTTY := term.IsTerminal(int(out.Fd()))
+	w := logWriter(out, format, isTTY, noColor || os.Getenv("TERM") == "dumb")
+	if file != nil {
+		w = zerolog.MultiLevelWriter(w, file)
+	}
+	main, modules := newLoggers(w, levels)
+
+	log.Logger = main
+	api.SetLogger(modules["api"])
+	docker.SetLogger(modules["docker"])
+	reconciler.SetLogger(modules["reconciler"])
+	report.SetLogger(modules["report"])
+	tailscale.SetLogger(modules["tailscale"])
+
+	log.Debug().Str("level", levels.level("").String()).Msg("Log level set")
+}
+
+// newLoggers returns the main logger and a logger per module, named by a
+// module field, writing to w at levels
+func newLoggers(w io.Writer, levels *logLevels) (zerolog.Logger, map[string]zerolog.Logger) {
+	main := zerolog.New(levelFilter{out: w, level: levels.levels[""]}).With().Timestamp().Logger()
+	modules := make(map[string]zerolog.Logger, len(logModules))
+	for _, module := range logModules {
+		modules[module] = zerolog.New(levelFilter{out: w, level: levels.levels[module]}).
+			With().
+			Timestamp().
+			Str("module", module).
+			Logger()
+	}
+	return main, modules
+}
+
+// logWriter returns the writer of a LOG_FORMAT. Without a format, terminals
+// get console output and everything else, such as log collectors, JSON.
+func logWriter(out io.Writer, format string, isTTY, noColor bool) io.Writer {
+	if format == "" {
+		format = logFormatJSON
+		if isTTY {
+			format = logFormatConsole
+		}
+	}
+	if format == logFormatJSON {
+		return out
+	}
+	return zerolog.ConsoleWriter{
+		Out:        out,
+		TimeFormat: time.RFC3339,
+		NoColor:    noColor || !isTTY,
+	}
+}
+
+// levelFilter drops the events below its level. Unlike the level of a
+// zerolog.Logger, it can change while the logger is in use.
+type levelFilter struct {
+	out   io.Writer
+	level *atomic.Int32
+}
+
+func (f levelFilter) Write(p []byte) (int, error) {
+	return f.out.Write(p)
+}
+
+func (f levelFilter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < zerolog.Level(f.level.Load()) {
+		return len(p), nil
+	}
+	return f.out.Write(p)
+}
+
+// debugMode is what SIGUSR1 last did to the log levels
+type debugMode int
+
+const (
+	debugConfigured debugMode = iota // the configured levels
+	debugOn                          // every logger at debug or below
+	debugOff                         // the configured levels, with info instead of debug or trace for the main logger
+)
+
+// logLevels holds the levels of the main logger and of the modules' loggers.
+// zerolog's global level is kept at the lowest of them, so it lets through
+// what any logger needs.
+type logLevels struct {
+	mu        sync.Mutex
+	main      zerolog.Level            // LOG_LEVEL
+	overrides map[string]zerolog.Level // LOG_LEVEL_<MODULE>, by module
+	mode      debugMode
+	levels    map[string]*atomic.Int32 // in effect, by module and "" for the main logger
+}
+
+// newLogLevels returns the levels of main and modules overriding it
+func newLogLevels(main zerolog.Level, overrides map[string]zerolog.Level) *logLevels {
+	l := &logLevels{levels: map[string]*atomic.Int32{"": new(atomic.Int32)}}
+	for _, module := range logModules {
+		l.levels[module] = new(atomic.Int32)
+	}
+	l.set(main, overrides)
+	return l
+}
+
+// set replaces the configured levels, undoing SIGUSR1
+func (l *logLevels) set(main zerolog.Level, overrides map[string]zerolog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.main, l.overrides, l.mode = main, overrides, debugConfigured
+	l.apply()
+}
+
+// toggleDebug switches between debug logging and the configured levels (info
+// when debug is configured) and returns the new level of the main logger
+func (l *logLevels) toggleDebug() zerolog.Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.level("") <= zerolog.DebugLevel {
+		l.mode = debugOff
+	} else {
+		l.mode = debugOn
+	}
+	l.apply()
+	return l.level("")
+}
+
+// level returns the level in effect of a module, or of the main logger for ""
+func (l *logLevels) level(module string) zerolog.Level {
+	return zerolog.Level(l.levels[module].Load())
+}
+
+// apply stores the levels in effect. Modules without an override follow the
+// main logger.
+func (l *logLevels) apply() {
+	main := l.main
+	switch {
+	case l.mode == debugOn:
+		main = min(main, zerolog.DebugLevel)
+	case l.mode == debugOff && main <= zerolog.DebugLevel:
+		main = zerolog.InfoLevel
+	}
+	l.levels[""].Store(int32(main))
+
+	lowest := main
+	for _, module := range logModules {
+		level, ok := l.overrides[module]
+		switch {
+		case !ok:
+			level = main
+		case l.mode == debugOn:
+			level = min(level, zerolog.DebugLevel)
+		}
+		l.levels[module].Store(int32(level))
+		lowest = min(lowest, level)
+	}
+	zerolog.SetGlobalLevel(lowest)
+}
+
+// parseLogLevel maps a LOG_LEVEL value to a zerolog level, defaulting to info
+func parseLogLevel(value string) zerolog.Level {
+	switch value {
+	case "trace":
+		return zerolog.TraceLevel
+	case "debug":
+		return zerolog.DebugLevel
+	case "warn":
+		return zerolog.WarnLevel
+	case "error":
+		return zerolog.ErrorLevel
+	default:
+		return zerolog.InfoLevel
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected zerolog.Level
+	}{
+		{"trace", zerolog.TraceLevel},
+		{"debug", zerolog.DebugLevel},
+		{"info", zerolog.InfoLevel},
+		{"warn", zerolog.WarnLevel},
+		{"error", zerolog.ErrorLevel},
+		{"verbose", zerolog.InfoLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseLogLevel(tt.value); got != tt.expected {
+				t.Errorf("parseLogLevel(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		isTTY       bool
+		wantConsole bool
+		wantNoColor bool
+	}{
+		{name: "terminal defaults to console", isTTY: true, wantConsole: true},
+		{name: "pipe defaults to json"},
+		{name: "json on a terminal", format: "json", isTTY: true},
+		{name: "console on a pipe is plain", format: "console", wantConsole: true, wantNoColor: true},
+		{name: "console on a terminal", format: "console", isTTY: true, wantConsole: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writer := logWriter(&out, tt.format, tt.isTTY, false)
+
+			console, isConsole := writer.(zerolog.ConsoleWriter)
+			if isConsole != tt.wantConsole {
+				t.Fatalf("logWriter() = %T, want console %v", writer, tt.wantConsole)
+			}
+			if isConsole && console.NoColor != tt.wantNoColor {
+				t.Errorf("NoColor = %v, want %v", console.NoColor, tt.wantNoColor)
+			}
+		})
+	}
+}
+
+func TestModuleLogLevels(t *testing.T) {
+	previous := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(previous) })
+
+	var out bytes.Buffer
+	levels := newLogLevels(zerolog.InfoLevel, map[string]zerolog.Level{
+		"docker":    zerolog.WarnLevel,
+		"tailscale": zerolog.TraceLevel,
+	})
+	main, modules := newLoggers(&out, levels)
+	dockerLog, tailscaleLog, reconcilerLog := modules["docker"], modules["tailscale"], modules["reconciler"]
+
+	main.Debug().Msg("main debug")
+	main.Info().Msg("main info")
+	dockerLog.Info().Msg("docker info")
+	dockerLog.Warn().Msg("docker warn")
+	tailscaleLog.Trace().Msg("tailscale trace")
+	reconcilerLog.Debug().Msg("reconciler debug")
+	reconcilerLog.Info().Msg("reconciler info")
+
+	got := out.String()
+	for _, want := range []string{"main info", "docker warn", "tailscale trace", "reconciler info", `"module":"tailscale"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output is missing %q:\n%s", want, got)
+		}
+	}
+	for _, suppressed := range []string{"main debug", "docker info", "reconciler debug"} {
+		if strings.Contains(got, suppressed) {
+			t.Errorf("output has %q below its logger's level:\n%s", suppressed, got)
+		}
+	}
+	if zerolog.GlobalLevel() != zerolog.TraceLevel {
+		t.Errorf("GlobalLevel() = %v, want the lowest module level", zerolog.GlobalLevel())
+	}
+}
+
+func TestToggleDebugLogging(t *testing.T) {
+	previous := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(previous) })
+
+	tests := []struct {
+		name       string
+		configured zerolog.Level
+		toggles    int
+		expected   zerolog.Level
+	}{
+		{"enable debug", zerolog.InfoLevel, 1, zerolog.DebugLevel},
+		{"restore configured level", zerolog.WarnLevel, 2, zerolog.WarnLevel},
+		{"debug configured falls back to info", zerolog.DebugLevel, 1, zerolog.InfoLevel},
+		{"back to debug", zerolog.DebugLevel, 2, zerolog.DebugLevel},
+		{"trace configured falls back to info", zerolog.TraceLevel, 1, zerolog.InfoLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels := newLogLevels(tt.configured, nil)
+			var got zerolog.Level
+			for range tt.toggles {
+				got = levels.toggleDebug()
+			}
+			if got != tt.expected {
+				t.Errorf("toggleDebug() = %v, want %v", got, tt.expected)
+			}
+			if levels.level("docker") != tt.expected {
+				t.Errorf("docker level = %v, want it to follow the main logger", levels.level("docker"))
+			}
+		})
+	}
+
+	t.Run("overrides", func(t *testing.T) {
+		levels := newLogLevels(zerolog.InfoLevel, map[string]zerolog.Level{"docker": zerolog.WarnLevel, "tailscale": zerolog.TraceLevel})
+		levels.toggleDebug()
+		if levels.level("docker") != zerolog.DebugLevel || levels.level("tailscale") != zerolog.TraceLevel {
+			t.Errorf("levels with debug on = docker %v, tailscale %v, want debug and trace", levels.level("docker"), levels.level("tailscale"))
+		}
+		levels.toggleDebug()
+		if levels.level("docker") != zerolog.WarnLevel || levels.level("tailscale") != zerolog.TraceLevel {
+			t.Errorf("levels with debug off = docker %v, tailscale %v, want warn and trace", levels.level("docker"), levels.level("tailscale"))
+		}
+	})
+}
@@ -2,101 +2,277 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"golang.org/x/term"
-
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/marvinvr/docktail/api"
 	"github.com/marvinvr/docktail/docker"
 	"github.com/marvinvr/docktail/reconciler"
+	"github.com/marvinvr/docktail/report"
 	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
+	"github.com/marvinvr/docktail/version"
 )
 
-func main() {
-	// Setup logging
-	setupLogging()
-
-	log.Info().Msg("Starting DockTail")
-
-	// Get configuration from environment
-	reconcileInterval := getEnvDuration("RECONCILE_INTERVAL", 60*time.Second)
-	tailscaleSocket := getEnv("TAILSCALE_SOCKET", "/var/run/tailscale/tailscaled.sock")
+// defaultShutdownTimeout bounds how long the reconciler may take to stop after a
+// shutdown signal, below the 10s grace period Docker gives before SIGKILL
+const defaultShutdownTimeout = 8 * time.Second
 
-	// Control Plane Configuration
-	tailscaleAPIKey := getEnv("TAILSCALE_API_KEY", "")
-	tailscaleOAuthClientID := getEnv("TAILSCALE_OAUTH_CLIENT_ID", "")
-	tailscaleOAuthClientSecret := getEnv("TAILSCALE_OAUTH_CLIENT_SECRET", "")
-	tailscaleTailnet := getEnv("TAILSCALE_TAILNET", "-")
-	defaultTagsStr := getEnv("DEFAULT_SERVICE_TAGS", "tag:container")
-	ignoreServiceNamesStr := getEnv("IGNORE_SERVICE_NAMES", "")
+// errShutdownTimeout is returned when the reconciler outlives the shutdown timeout
+var errShutdownTimeout = errors.New("reconciler did not stop within the shutdown timeout")
 
-	// Parse default tags
-	var defaultTags []string
-	for _, tag := range strings.Split(defaultTagsStr, ",") {
-		if trimmed := strings.TrimSpace(tag); trimmed != "" {
-			defaultTags = append(defaultTags, trimmed)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "version", "--version", "-version":
+			fmt.Println(version.Get())
+			os.Exit(0)
+		case "list":
+			os.Exit(runList(subcommandConfig(), os.Args[2:], os.Stdout))
+		case "validate":
+			os.Exit(runValidate(subcommandConfig(), os.Args[2:], os.Stdout))
+		case "doctor":
+			os.Exit(runDoctor(subcommandConfig(), os.Args[2:], os.Stdout))
+		case "export":
+			os.Exit(runExport(subcommandConfig(), os.Args[2:], os.Stdout))
+		case "cleanup", "clear":
+			os.Exit(runCleanup(subcommandConfig(), os.Args[2:], os.Stdin, os.Stdout))
+		case "healthcheck":
+			os.Exit(runHealthcheck(subcommandConfig(), os.Args[2:], os.Stdout))
 		}
 	}
 
-	// Parse ignored service names
-	var ignoreServiceNames []string
-	for _, name := range strings.Split(ignoreServiceNamesStr, ",") {
-		if trimmed := strings.TrimSpace(name); trimmed != "" {
-			ignoreServiceNames = append(ignoreServiceNames, trimmed)
+	// Errors were written with the usage
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	// Setup logging
+	// The levels change on SIGUSR1 and SIGHUP
+	logLevels := newLogLevels(parseLogLevel(cfg.logLevel("info")), cfg.moduleLogLevels())
+	// LOG_FILE is reopened on SIGHUP
+	var logOutput *logFile
+	var fileWriter io.Writer
+	if cfg.LogFile != "" {
+		logOutput, err = openLogFile(cfg.LogFile, int64(cfg.LogMaxSize)<<20, cfg.LogMaxBackups, cfg.LogMaxAge)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		defer func() { _ = logOutput.Close() }()
+		fileWriter = logOutput
 	}
+	setupLogging(os.Stdout, cfg.LogFormat, fileWriter, logLevels)
+
+	build := version.Get()
+	log.Info().
+		Str("version", build.Version).
+		Str("commit", build.Commit).
+		Str("build_date", build.BuildDate).
+		Msg("Starting DockTail")
+
+	dockerConfig := cfg.Docker
+	stateDir := dockerConfig.StateDir
+	socketNames := cfg.socketNames()
 
 	// Determine API sync method for logging
 	apiSyncMethod := "disabled"
-	if tailscaleOAuthClientID != "" && tailscaleOAuthClientSecret != "" {
+	if cfg.OAuthClientID != "" && cfg.OAuthClientSecret != "" {
 		apiSyncMethod = "oauth"
-	} else if tailscaleAPIKey != "" {
+	} else if cfg.APIKey != "" {
 		apiSyncMethod = "api_key"
 	}
 
-	logCredentialWarnings(tailscaleAPIKey, tailscaleOAuthClientID, tailscaleOAuthClientSecret)
+	logCredentialWarnings(cfg.APIKey, cfg.OAuthClientID, cfg.OAuthClientSecret)
 
 	log.Info().
-		Dur("reconcile_interval", reconcileInterval).
-		Str("tailscale_socket", tailscaleSocket).
+		Str("config_file", cfg.ConfigFile).
+		Dur("reconcile_interval", cfg.ReconcileInterval).
+		Bool("dry_run", cfg.DryRun).
+		Bool("advertise_only", cfg.AdvertiseOnly).
+		Bool("maintenance_mode", cfg.MaintenanceMode).
+		Int("shadow_cycles", cfg.ShadowCycles).
+		Dur("remove_grace_period", cfg.RemoveGracePeriod).
+		Dur("apply_min_interval", cfg.ApplyMinInterval).
+		Int("reconcile_retries", cfg.ReconcileRetries).
+		Dur("verify_interval", cfg.VerifyInterval).
+		Str("tailscale_socket", cfg.TailscaleSocket).
+		Strs("tailscale_sockets", socketNames).
 		Str("api_sync_method", apiSyncMethod).
-		Str("tailnet", tailscaleTailnet).
-		Strs("default_tags", defaultTags).
-		Strs("ignore_service_names", ignoreServiceNames).
+		Str("tailnet", cfg.Tailnet).
+		Strs("default_tags", dockerConfig.DefaultTags).
+		Strs("ignore_service_names", cfg.IgnoreServiceNames).
+		Bool("allow_unmanaged_overwrite", cfg.AllowUnmanagedOverwrite).
+		Str("apply_mode", cfg.ApplyMode).
+		Bool("normalize_service_names", dockerConfig.NormalizeServiceNames).
+		Strs("docker_events", dockerConfig.WatchedEvents).
+		Strs("image_allowlist", dockerConfig.ImageAllowlist).
+		Str("proxy_mode", dockerConfig.ProxyMode).
+		Str("docker_network", dockerConfig.DefaultNetwork).
+		Str("host_gateway", dockerConfig.HostGateway).
+		Str("proxy_bind_address", dockerConfig.ProxyBindAddress).
+		Bool("allow_destination_override", dockerConfig.AllowDestinationOverride).
+		Bool("read_env_config", dockerConfig.ReadEnvConfig).
+		Bool("auto_target_port", dockerConfig.AutoTargetPort).
+		Str("static_services_file", dockerConfig.StaticServicesFile).
+		Str("state_dir", stateDir).
+		Str("audit_log", cfg.AuditLog).
+		Str("command_log", cfg.CommandLog).
+		Str("dump_config", cfg.DumpConfig).
+		Str("log_file", cfg.LogFile).
+		Int("log_max_services_detail", cfg.LogMaxServicesDetail).
+		Int("reconcile_concurrency", cfg.ReconcileConcurrency).
+		Str("trigger_file", cfg.TriggerFile).
+		Str("api_addr", cfg.APIAddr).
+		Bool("api_token_set", cfg.APIToken != "").
+		Int("history_size", cfg.HistorySize).
+		Bool("sentry_dsn_set", cfg.SentryDSN != "").
 		Msg("Configuration loaded")
 
+	if stateDir != "" {
+		if err := os.MkdirAll(stateDir, 0o755); err != nil {
+			log.Warn().Err(err).Str("state_dir", stateDir).Msg("Failed to create state directory")
+		}
+	}
+
+	// Report failures to the error tracker, holding back repeats so a service
+	// failing every cycle is reported once per window
+	var errorReporter report.Reporter
+	if cfg.SentryDSN != "" {
+		sentry, err := report.NewSentry(cfg.SentryDSN)
+		if err != nil {
+			log.Fatal().Err(err).Str("key", "SENTRY_DSN").Msg("Failed to set up error reporting")
+		}
+		defer sentry.Close()
+		errorReporter = report.RateLimit(sentry, report.DefaultWindow, report.DefaultMaxPerWindow)
+	}
+
 	// Create Docker client
-	dockerClient, err := docker.NewClient(defaultTags)
+	dockerClient, err := docker.NewClient(dockerConfig)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create Docker client")
 	}
 	defer func() { _ = dockerClient.Close() }()
 
-	log.Info().Msg("Docker client initialized")
+	log.Info().Int("hosts", max(len(dockerConfig.Hosts), 1)).Msg("Docker client initialized")
+
+	// Open the command log, reopened on SIGHUP like LOG_FILE. It is never
+	// rotated by DockTail, so no record is ever removed by it.
+	var commandLog *logFile
+	var commandLogWriter io.Writer
+	if cfg.CommandLog != "" {
+		commandLog, err = openLogFile(cfg.CommandLog, math.MaxInt64, 0, 0)
+		if err != nil {
+			log.Fatal().Err(err).Str("key", "COMMAND_LOG").Msg("Failed to open command log")
+		}
+		defer func() { _ = commandLog.Close() }()
+		commandLogWriter = commandLog
+	}
 
 	// Create Tailscale client
-	tailscaleClient := tailscale.NewClient(tailscale.ClientConfig{
-		SocketPath:         tailscaleSocket,
-		Tailnet:            tailscaleTailnet,
-		APIKey:             tailscaleAPIKey,
-		OAuthClientID:      tailscaleOAuthClientID,
-		OAuthClientSecret:  tailscaleOAuthClientSecret,
-		IgnoreServiceNames: ignoreServiceNames,
-	})
+	tailscaleConfig := cfg.tailscaleConfig()
+	tailscaleConfig.ErrorReporter = errorReporter
+	tailscaleConfig.CommandLog = commandLogWriter
+	tailscaleClient := tailscale.NewClient(tailscaleConfig)
 
 	// Detect CLI/daemon version mismatch (common with host-mode Tailscale)
 	tailscaleClient.DetectVersionMismatch(context.Background())
+	tailscaleClient.RefreshNodeInfo(context.Background())
 
 	log.Info().Msg("Tailscale client initialized")
 
+	// Create a client per additional socket. These may belong to other tailnets,
+	// so control plane sync is only done through the default socket.
+	socketClients := make(map[string]*tailscale.Client, len(cfg.TailscaleSockets))
+	reconcilerSockets := make(map[string]reconciler.TailscaleClient, len(cfg.TailscaleSockets))
+	for _, name := range socketNames {
+		socketStateDir := ""
+		if stateDir != "" {
+			socketStateDir = filepath.Join(stateDir, name)
+			if err := os.MkdirAll(socketStateDir, 0o755); err != nil {
+				log.Warn().Err(err).Str("state_dir", socketStateDir).Msg("Failed to create state directory")
+			}
+		}
+
+		client := tailscale.NewClient(tailscale.ClientConfig{
+			SocketPath:         cfg.TailscaleSockets[name],
+			IgnoreServiceNames: cfg.IgnoreServiceNames,
+			ServiceNamePrefix:  dockerConfig.ServiceNamePrefix,
+			CollisionPolicy:    cfg.CollisionPolicy,
+			Concurrency:        cfg.ReconcileConcurrency,
+			StateDir:           socketStateDir,
+			MaxServicesDetail:  cfg.LogMaxServicesDetail,
+			AdvertiseOnly:      cfg.AdvertiseOnly,
+			PrefetchCerts:      cfg.PrefetchCerts,
+			RemoveGracePeriod:  cfg.RemoveGracePeriod,
+			ErrorReporter:      errorReporter,
+			CommandLog:         commandLogWriter,
+			CommandLogReadOnly: cfg.CommandLogReadOnly,
+
+			AllowUnmanagedOverwrite: cfg.AllowUnmanagedOverwrite,
+			ApplyMode:               cfg.ApplyMode,
+			VerifyInterval:          cfg.VerifyInterval,
+		})
+		client.DetectVersionMismatch(context.Background())
+		client.RefreshNodeInfo(context.Background())
+		socketClients[name] = client
+		reconcilerSockets[name] = client
+
+		log.Info().
+			Str("socket", name).
+			Str("path", cfg.TailscaleSockets[name]).
+			Msg("Tailscale socket client initialized")
+	}
+
+	// Open the audit log. Running without it when one was asked for would
+	// leave changes unrecorded, so failing to open it is fatal.
+	var auditLog io.Writer
+	if cfg.AuditLog != "" {
+		auditFile, err := os.OpenFile(cfg.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatal().Err(err).Str("key", "AUDIT_LOG").Msg("Failed to open audit log")
+		}
+		defer func() { _ = auditFile.Close() }()
+		auditLog = auditFile
+	}
+
+	// Notify systemd of readiness and liveness when run as a Type=notify
+	// service; a no-op otherwise
+	notify := newNotifier(os.Getenv)
+
 	// Create reconciler
-	rec := reconciler.NewReconciler(dockerClient, tailscaleClient, reconcileInterval)
+	rec := reconciler.NewReconciler(dockerClient, tailscaleClient, reconciler.Options{
+		Interval:          cfg.ReconcileInterval,
+		DryRun:            cfg.DryRun,
+		Maintenance:       cfg.MaintenanceMode,
+		Sockets:           reconcilerSockets,
+		AuditLog:          auditLog,
+		StateDir:          stateDir,
+		ErrorReporter:     errorReporter,
+		HistorySize:       cfg.HistorySize,
+		ShadowCycles:      cfg.ShadowCycles,
+		ApplyMinInterval:  cfg.ApplyMinInterval,
+		Attempts:          cfg.ReconcileRetries,
+		OnReconcile:       func(result apptypes.ReconcileResult) { notify.Status(reconcileStatus(result)) },
+		OnStarted:         notify.Ready,
+		Heartbeat:         notify.Watchdog,
+		HeartbeatInterval: notify.WatchdogInterval(),
+	})
 
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
@@ -108,15 +284,127 @@ func main() {
 	go func() {
 		sig := <-sigChan
 		log.Info().Str("signal", sig.String()).Msg("Received shutdown signal, initiating graceful shutdown")
+		notify.Stopping()
 		cancel()
 	}()
 
+	// SIGUSR1 toggles debug logging without a restart
+	logLevelChan := make(chan os.Signal, 1)
+	signal.Notify(logLevelChan, syscall.SIGUSR1)
+
+	go func() {
+		for range logLevelChan {
+			level := logLevels.toggleDebug()
+			// Logged without a level so the change is visible at every level
+			log.Log().Str("level", level.String()).Msg("Log level changed by SIGUSR1")
+		}
+	}()
+
+	// SIGUSR2 pauses and resumes applying changes, like the /pause and /resume endpoints
+	pauseChan := make(chan os.Signal, 1)
+	signal.Notify(pauseChan, syscall.SIGUSR2)
+
+	go func() {
+		for range pauseChan {
+			if rec.Paused() {
+				rec.Resume()
+				log.Info().Msg("Reconciliation resumed by SIGUSR2")
+			} else {
+				rec.Pause()
+				log.Warn().Msg("Reconciliation paused by SIGUSR2, changes are logged but not applied")
+			}
+		}
+	}()
+
+	// SIGHUP reloads the config file and the static services file and
+	// applies them right away
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	go func() {
+		current := cfg
+		for range reloadChan {
+			// logrotate moves the file away before sending SIGHUP
+			if logOutput != nil {
+				if err := logOutput.Reopen(); err != nil {
+					log.Error().Err(err).Str("key", "LOG_FILE").Msg("Failed to reopen log file")
+				}
+			}
+			if commandLog != nil {
+				if err := commandLog.Reopen(); err != nil {
+					log.Error().Err(err).Str("key", "COMMAND_LOG").Msg("Failed to reopen command log")
+				}
+			}
+			if current.ConfigFile != "" {
+				current = reloadConfig(current, rec, logLevels)
+			}
+			if err := dockerClient.ReloadStaticServices(); err != nil {
+				log.Error().Err(err).Str("key", "STATIC_SERVICES_FILE").Msg("Failed to reload static services, keeping the previous ones")
+				continue
+			}
+			rec.TriggerReconcile()
+		}
+	}()
+
+	// Touching TRIGGER_FILE requests a reconciliation, like POST /reconcile.
+	// The watcher is closed once ctx is cancelled on shutdown.
+	if cfg.TriggerFile != "" {
+		go func() {
+			if err := watchTriggerFile(ctx, cfg.TriggerFile, triggerFileDebounce, rec.TriggerReconcile); err != nil {
+				log.Error().Err(err).Str("key", "TRIGGER_FILE").Msg("Failed to watch trigger file")
+			}
+		}()
+	}
+
+	// Start HTTP API
+	if cfg.APIAddr != "" {
+		if cfg.APIToken == "" {
+			log.Warn().Msg("API_TOKEN is not set; mutating HTTP API endpoints are disabled")
+		}
+		apiServer := api.NewServer(rec, cfg.APIToken)
+		apiServer.HandleServices(dockerClient)
+		go func() {
+			if err := apiServer.ListenAndServe(ctx, cfg.APIAddr); err != nil {
+				log.Error().Err(err).Str("addr", cfg.APIAddr).Msg("HTTP API stopped")
+			}
+		}()
+	}
+
 	// Run reconciler
 	log.Info().Msg("Starting reconciliation loop")
-	if err := rec.Run(ctx); err != nil && err != context.Canceled {
+	err = runWithShutdownTimeout(ctx, cfg.ShutdownTimeout, func(ctx context.Context) error {
+		err := rec.Run(ctx)
+		// Certificate prefetches run in the background and may outlive the
+		// reconciler, so they share its shutdown timeout
+		tailscaleClient.StopCertPrefetches()
+		for _, name := range socketNames {
+			socketClients[name].StopCertPrefetches()
+		}
+		return err
+	})
+	if errors.Is(err, errShutdownTimeout) {
+		// A CLI or Docker call is ignoring cancellation; exit before the
+		// container runtime kills us, leaving services for the next start
+		log.Warn().
+			Dur("shutdown_timeout", cfg.ShutdownTimeout).
+			Msg("Reconciler or certificate prefetches did not stop within SHUTDOWN_TIMEOUT, exiting without cleaning up services")
+		os.Exit(1)
+	}
+	if err != nil && err != context.Canceled {
 		log.Fatal().Err(err).Msg("Reconciler failed")
 	}
 
+	if cfg.DryRun || rec.Paused() || rec.Shadowing() {
+		log.Info().Msg("Reconciler stopped, dry run, pause or shadow mode enabled so Tailscale services are left untouched")
+		log.Info().Msg("DockTail stopped gracefully")
+		return
+	}
+	if rec.Maintenance() {
+		log.Info().Msg("Reconciler stopped, maintenance mode enabled so Tailscale services are left untouched")
+		log.Info().Msg("DockTail stopped gracefully")
+		return
+	}
+
 	// Graceful shutdown: clean up all Tailscale services
 	log.Info().Msg("Reconciler stopped, cleaning up Tailscale services")
 
@@ -130,58 +418,89 @@ func main() {
 		log.Info().Msg("Successfully cleaned up all services")
 	}
 
+	for _, name := range socketNames {
+		if err := socketClients[name].CleanupAllServices(cleanupCtx); err != nil {
+			log.Error().Err(err).Str("socket", name).Msg("Failed to clean up all services during shutdown")
+		} else {
+			log.Info().Str("socket", name).Msg("Successfully cleaned up all services")
+		}
+	}
+
 	log.Info().Msg("DockTail stopped gracefully")
 }
 
-func setupLogging() {
-	// Configure zerolog
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	out := os.Stdout
-	_, noColor := os.LookupEnv("NO_COLOR") // adheres no-color.org
-	isTTY := term.IsTerminal(int(out.Fd()))
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        out,
-		TimeFormat: time.RFC3339,
-		NoColor:    noColor || !isTTY || os.Getenv("TERM") == "dumb",
-	})
+// runWithShutdownTimeout runs run until it returns. Once ctx is cancelled, run
+// has at most timeout to return before errShutdownTimeout is returned and run
+// is left behind.
+func runWithShutdownTimeout(ctx context.Context, timeout time.Duration, run func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx)
+	}()
 
-	// Set log level from environment
-	logLevel := getEnv("LOG_LEVEL", "info")
-	switch logLevel {
-	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
-	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	}
-
-	log.Debug().Str("level", logLevel).Msg("Log level set")
-}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errShutdownTimeout
 	}
-	return defaultValue
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
+// reloadConfig reads the configuration again for SIGHUP and applies the
+// options that can change without a restart, the log levels,
+// MAINTENANCE_MODE and RECONCILE_INTERVAL. It returns the new configuration, or current when the
+// new one is invalid.
+func reloadConfig(current *config, rec *reconciler.Reconciler, logLevels *logLevels) *config {
+	updated, err := loadConfig(os.Args[1:], os.LookupEnv, io.Discard)
+	if err != nil {
+		log.Error().Err(err).Str("key", "CONFIG_FILE").Msg("Failed to reload configuration, keeping the previous one")
+		return current
+	}
+
+	var restart []string
+	for _, env := range changedOptions(current, updated) {
+		switch {
+		case env == "LOG_LEVEL" || strings.HasPrefix(env, "LOG_LEVEL_"):
+			logLevels.set(parseLogLevel(updated.logLevel("info")), updated.moduleLogLevels())
+		case env == "MAINTENANCE_MODE":
+			rec.SetMaintenance(updated.MaintenanceMode)
+		case env == "RECONCILE_INTERVAL":
+			if err := rec.SetInterval(updated.ReconcileInterval); err != nil {
+				log.Error().Err(err).Msg("Failed to change the reconcile interval")
+			}
+		default:
+			restart = append(restart, env)
 		}
-		log.Warn().
-			Str("key", key).
-			Str("value", value).
-			Dur("default", defaultValue).
-			Msg("Failed to parse duration, using default")
 	}
-	return defaultValue
+	if len(restart) > 0 {
+		log.Warn().Strs("keys", restart).Msg("Configuration changes that only take effect after a restart")
+	}
+	log.Info().
+		Str("level", updated.logLevel("info")).
+		Dur("reconcile_interval", updated.ReconcileInterval).
+		Msg("Configuration reloaded")
+	return updated
+}
+
+// subcommandConfig loads the configuration of a subcommand from the
+// environment, exiting on errors, and sends logs to stderr so the output can
+// be piped
+func subcommandConfig() *config {
+	cfg, err := loadConfig(nil, os.LookupEnv, os.Stderr)
+	if err != nil {
+		os.Exit(2)
+	}
+	setupLogging(os.Stderr, cfg.LogFormat, nil, newLogLevels(parseLogLevel(cfg.logLevel("error")), cfg.moduleLogLevels()))
+	return cfg
 }
 
 func logCredentialWarnings(tailscaleAPIKey, tailscaleOAuthClientID, tailscaleOAuthClientSecret string) {
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunWithShutdownTimeout(t *testing.T) {
+	t.Run("run returns after cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := runWithShutdownTimeout(ctx, time.Second, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("runWithShutdownTimeout() error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("slow run is abandoned", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		release := make(chan struct{})
+		defer close(release)
+
+		// The run ignores cancellation, like a CLI call stuck on a dead socket
+		slowRun := func(context.Context) error {
+			<-release
+			return nil
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		start := time.Now()
+		err := runWithShutdownTimeout(ctx, 50*time.Millisecond, slowRun)
+		if !errors.Is(err, errShutdownTimeout) {
+			t.Errorf("runWithShutdownTimeout() error = %v, want %v", err, errShutdownTimeout)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("shutdown took %v, want it bounded by the timeout", elapsed)
+		}
+	})
+
+	t.Run("run error before shutdown", func(t *testing.T) {
+		want := errors.New("docker unavailable")
+		err := runWithShutdownTimeout(context.Background(), time.Millisecond, func(context.Context) error {
+			return want
+		})
+		if !errors.Is(err, want) {
+			t.Errorf("runWithShutdownTimeout() error = %v, want %v", err, want)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package reconciler
+
+import (
+	"expvar"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+var (
+	// appliesDeferred counts cycles held back by APPLY_MIN_INTERVAL
+	appliesDeferred = expvar.NewInt("docktail_applies_deferred_total")
+	// applyDelaySeconds adds up how long deferred applies waited
+	applyDelaySeconds = expvar.NewFloat("docktail_apply_delay_seconds_total")
+)
+
+// changedAnything reports whether a cycle changed services or funnels
+func changedAnything(result *apptypes.ReconcileResult) bool {
+	return len(result.Added)+len(result.Removed)+len(result.Changed)+len(result.FunnelsAdded)+len(result.FunnelsRemoved) > 0
+}
+
+// deferApply holds back a cycle that could apply changes within the minimum
+// apply interval of the last cycle that did, and schedules one for when the
+// interval is over. Requests made in the meantime are coalesced into it; since
+// that cycle discovers the containers afresh, it applies the latest desired
+// state. Cycles that apply nothing, such as dry runs, are never held back.
+// Called with mu held.
+func (r *Reconciler) deferApply() bool {
+	if r.applyMinInterval <= 0 || r.lastApplyAt.IsZero() || r.dryRun || r.paused || r.shadowLeft > 0 {
+		return false
+	}
+	now := r.now()
+	dueAt := r.lastApplyAt.Add(r.applyMinInterval)
+	if !now.Before(dueAt) {
+		return false
+	}
+
+	appliesDeferred.Add(1)
+	if r.applyDueAt.Equal(dueAt) {
+		log.Debug().Time("apply_at", dueAt).Msg("Apply already pending, coalescing request into it")
+		return true
+	}
+
+	wait := dueAt.Sub(now)
+	applyDelaySeconds.Add(wait.Seconds())
+	r.applyDueAt = dueAt
+	if r.applyTimer != nil {
+		r.applyTimer.Stop()
+	}
+	r.applyTimer = time.AfterFunc(wait, r.TriggerReconcile)
+	log.Info().
+		Dur("apply_in", wait).
+		Dur("apply_min_interval", r.applyMinInterval).
+		Msg("Changes were applied recently, holding back the next apply")
+	return true
+}
+
+// recordApply notes that a cycle ending now changed something, which the
+// minimum apply interval counts from. Called with mu held.
+func (r *Reconciler) recordApply(result *apptypes.ReconcileResult) {
+	if r.applyMinInterval <= 0 || result.DryRun || !changedAnything(result) {
+		return
+	}
+	r.lastApplyAt = r.now()
+}
@@ -0,0 +1,78 @@
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestApplyMinIntervalCoalescesToLastDesiredState(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	web := &apptypes.ContainerService{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443"}
+	api := &apptypes.ContainerService{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443"}
+	docker := &fakeDockerClient{containers: []*apptypes.ContainerService{web}}
+	ts := &fakeTailscaleClient{result: &apptypes.ReconcileResult{Added: []string{"svc:web:443"}}}
+	r := NewReconciler(docker, ts, Options{ApplyMinInterval: time.Minute})
+	r.now = func() time.Time { return now }
+	t.Cleanup(func() {
+		if r.applyTimer != nil {
+			r.applyTimer.Stop()
+		}
+	})
+
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	// Changes arriving within the interval are held back and coalesced
+	deferred := appliesDeferred.Value()
+	now = start.Add(10 * time.Second)
+	docker.containers = []*apptypes.ContainerService{web, api}
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	now = start.Add(20 * time.Second)
+	docker.containers = []*apptypes.ContainerService{api}
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ts.calls != 1 {
+		t.Fatalf("tailscale called %d times within the interval, want 1", ts.calls)
+	}
+	if got := appliesDeferred.Value() - deferred; got != 2 {
+		t.Errorf("docktail_applies_deferred_total increased by %d, want 2", got)
+	}
+	if r.applyTimer == nil || !r.applyDueAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("pending apply due at %v, want %v", r.applyDueAt, start.Add(time.Minute))
+	}
+
+	// The pending apply lands the latest desired state once the interval has
+	// passed, as its timer's reconciliation would
+	now = start.Add(time.Minute)
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ts.calls != 2 || len(ts.desired) != 1 || ts.desired[0].ServiceName != "api" {
+		t.Errorf("after the interval: %d calls, desired %v, want api applied", ts.calls, ts.desired)
+	}
+}
+
+func TestApplyMinIntervalCountsOnlyChanges(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := &fakeTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{ApplyMinInterval: time.Minute})
+	r.now = func() time.Time { return now }
+
+	// Cycles that change nothing don't start the interval
+	for range 3 {
+		if err := r.Reconcile(context.Background()); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	if ts.calls != 3 || !r.lastApplyAt.IsZero() {
+		t.Errorf("%d calls, last apply at %v, want 3 calls and no apply", ts.calls, r.lastApplyAt)
+	}
+}
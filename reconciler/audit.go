@@ -0,0 +1,55 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// AuditEntry is one line of the audit log: the changes a cycle applied
+type AuditEntry struct {
+	Time           time.Time `json:"time"`
+	Trigger        string    `json:"trigger"`
+	Added          []string  `json:"added,omitempty"`
+	Removed        []string  `json:"removed,omitempty"`
+	Changed        []string  `json:"changed,omitempty"`
+	FunnelsAdded   []string  `json:"funnels_added,omitempty"`
+	FunnelsRemoved []string  `json:"funnels_removed,omitempty"`
+	// Error is set when the cycle failed after applying some of the changes
+	Error string `json:"error,omitempty"`
+}
+
+// writeAudit appends the changes of a cycle to the audit log. Dry-run and
+// paused cycles change nothing and cycles without changes are skipped.
+func (r *Reconciler) writeAudit(start time.Time, result *apptypes.ReconcileResult) {
+	if r.auditLog == nil || result.DryRun {
+		return
+	}
+	if !changedAnything(result) {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:           start.UTC(),
+		Trigger:        result.Trigger,
+		Added:          result.Added,
+		Removed:        result.Removed,
+		Changed:        result.Changed,
+		FunnelsAdded:   result.FunnelsAdded,
+		FunnelsRemoved: result.FunnelsRemoved,
+	}
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+
+	// Each entry is written with a single call so lines never interleave
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode audit entry")
+		return
+	}
+	if _, err := r.auditLog.Write(append(line, '\n')); err != nil {
+		log.Error().Err(err).Msg("Failed to write audit entry")
+	}
+}
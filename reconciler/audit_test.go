@@ -0,0 +1,79 @@
+package reconciler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestAuditLog(t *testing.T) {
+	var audit bytes.Buffer
+	ts := &fakeTailscaleClient{
+		result: &apptypes.ReconcileResult{
+			Added:          []string{"svc:web:443"},
+			Removed:        []string{"svc:old:80"},
+			Changed:        []string{"svc:api:443"},
+			FunnelsAdded:   []string{"8443"},
+			FunnelsRemoved: []string{"10000"},
+		},
+	}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{AuditLog: &audit})
+
+	// A mixed cycle for each trigger, then a dry run and a cycle without changes
+	if err := r.reconcileFor(context.Background(), TriggerEvent); err != nil {
+		t.Fatalf("reconcileFor() error = %v", err)
+	}
+	ts.err = errors.New("tailscale serve failed")
+	if err := r.reconcileFor(context.Background(), TriggerPeriodic); err == nil {
+		t.Fatal("reconcileFor() should return the tailscale error")
+	}
+	ts.err = nil
+	r.Pause()
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	r.Resume()
+	ts.result = &apptypes.ReconcileResult{}
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d entries, want 2:\n%s", len(lines), audit.String())
+	}
+
+	var entries []AuditEntry
+	for _, line := range lines {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("audit line %q is not JSON: %v", line, err)
+		}
+		if entry.Time.IsZero() {
+			t.Errorf("audit entry %q has no time", line)
+		}
+		entries = append(entries, entry)
+	}
+
+	want := AuditEntry{
+		Time:           entries[0].Time,
+		Trigger:        TriggerEvent,
+		Added:          []string{"svc:web:443"},
+		Removed:        []string{"svc:old:80"},
+		Changed:        []string{"svc:api:443"},
+		FunnelsAdded:   []string{"8443"},
+		FunnelsRemoved: []string{"10000"},
+	}
+	if !reflect.DeepEqual(entries[0], want) {
+		t.Errorf("entry = %+v, want %+v", entries[0], want)
+	}
+	if entries[1].Trigger != TriggerPeriodic || !strings.Contains(entries[1].Error, "tailscale serve failed") {
+		t.Errorf("entry = %+v, want a periodic entry with the error", entries[1])
+	}
+}
@@ -0,0 +1,51 @@
+package reconciler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marvinvr/docktail/atomicfile"
+)
+
+// lastSuccessFile is the file (inside the state directory) holding the start
+// of the last successful cycle, for `docktail healthcheck` to read
+const lastSuccessFile = "last-success"
+
+// staleCycles is how many reconcile intervals may pass without a successful
+// cycle before DockTail is reported unhealthy
+const staleCycles = 3
+
+// Healthy reports whether a successful cycle started at lastSuccess is recent
+// enough for a reconciler running every interval
+func Healthy(lastSuccess time.Time, interval time.Duration, now time.Time) bool {
+	return !lastSuccess.IsZero() && now.Sub(lastSuccess) <= staleCycles*interval
+}
+
+// ReadLastSuccess returns the start of the last successful cycle recorded in
+// stateDir
+func ReadLastSuccess(stateDir string) (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, lastSuccessFile))
+	if err != nil {
+		return time.Time{}, err
+	}
+	lastSuccess, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %w", lastSuccessFile, err)
+	}
+	return lastSuccess, nil
+}
+
+// writeLastSuccess records the start of a successful cycle in the state directory
+func (r *Reconciler) writeLastSuccess(start time.Time) {
+	if r.stateDir == "" {
+		return
+	}
+
+	path := filepath.Join(r.stateDir, lastSuccessFile)
+	if err := atomicfile.Write(path, []byte(start.UTC().Format(time.RFC3339Nano)+"\n")); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to record last successful reconciliation")
+	}
+}
@@ -0,0 +1,62 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLastSuccessRecordedOnlyForSuccessfulCycles(t *testing.T) {
+	stateDir := t.TempDir()
+	ts := &fakeTailscaleClient{}
+	rec := NewReconciler(&fakeDockerClient{}, ts, Options{StateDir: stateDir})
+
+	if _, err := ReadLastSuccess(stateDir); err == nil {
+		t.Fatal("ReadLastSuccess() before the first cycle expected error")
+	}
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	first, err := ReadLastSuccess(stateDir)
+	if err != nil {
+		t.Fatalf("ReadLastSuccess() error = %v", err)
+	}
+	if !first.Equal(rec.State().LastSuccessAt) {
+		t.Errorf("recorded last success = %s, want %s", first, rec.State().LastSuccessAt)
+	}
+
+	// A failed cycle leaves the last success alone
+	ts.err = errors.New("tailscaled not running")
+	_ = rec.Reconcile(context.Background())
+	if got, _ := ReadLastSuccess(stateDir); !got.Equal(first) {
+		t.Errorf("last success after a failed cycle = %s, want %s", got, first)
+	}
+	if !rec.State().LastSuccessAt.Equal(first) {
+		t.Errorf("State().LastSuccessAt = %s, want %s", rec.State().LastSuccessAt, first)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		lastSuccess time.Time
+		want        bool
+	}{
+		{name: "fresh", lastSuccess: now.Add(-time.Minute), want: true},
+		{name: "three intervals ago", lastSuccess: now.Add(-3 * time.Minute), want: true},
+		{name: "stale", lastSuccess: now.Add(-4 * time.Minute), want: false},
+		{name: "never", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Healthy(tt.lastSuccess, time.Minute, now); got != tt.want {
+				t.Errorf("Healthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package reconciler
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// DefaultHistorySize is how many events the reconciler keeps by default
+const DefaultHistorySize = 200
+
+// Kinds of HistoryEvent
+const (
+	EventCycleStart   = "cycle_start"
+	EventCycleEnd     = "cycle_end"
+	EventAdd          = "add"
+	EventRemove       = "remove"
+	EventUpdate       = "update"
+	EventDefer        = "defer" // a removal deferred by maintenance mode
+	EventFunnelAdd    = "funnel_add"
+	EventFunnelRemove = "funnel_remove"
+	EventError        = "error"
+)
+
+// HistoryEvent is something the reconciler did, kept in memory so a running
+// process can tell what it recently did
+type HistoryEvent struct {
+	Time    time.Time
+	Kind    string
+	Trigger string
+	// Service is the service of add, remove, update and defer events, such
+	// as "svc:web"
+	Service string
+	// Key is the service key of service events, such as "svc:web:443", or the
+	// public port of funnel events
+	Key string
+	// DryRun is set for the changes of dry-run and paused cycles, which were
+	// computed but not applied
+	DryRun bool
+	// Error is the reason of error events
+	Error string
+	// Result is the result of the cycle, for cycle_end events
+	Result *apptypes.ReconcileResult
+}
+
+// history is a ring buffer of the most recent events. It has its own lock,
+// so it can be read while a cycle runs.
+type history struct {
+	mu     sync.Mutex
+	events []HistoryEvent
+	next   int  // where the next event goes
+	full   bool // events has wrapped, so next is also the oldest event
+}
+
+// newHistory returns a history keeping size events, or nil when size is not
+// positive, which keeps none
+func newHistory(size int) *history {
+	if size <= 0 {
+		return nil
+	}
+	return &history{events: make([]HistoryEvent, size)}
+}
+
+// add appends events, overwriting the oldest once the buffer is full
+func (h *history) add(events ...HistoryEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, event := range events {
+		h.events[h.next] = event
+		h.next = (h.next + 1) % len(h.events)
+		if h.next == 0 {
+			h.full = true
+		}
+	}
+}
+
+// list returns the events oldest first, only those of service when it is
+// not empty
+func (h *history) list(service string) []HistoryEvent {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ordered := h.events[:h.next]
+	if h.full {
+		ordered = append(append([]HistoryEvent(nil), h.events[h.next:]...), h.events[:h.next]...)
+	}
+	events := make([]HistoryEvent, 0, len(ordered))
+	for _, event := range ordered {
+		if service == "" || event.Service == service {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// History returns the events of the most recent cycles, oldest first. With
+// a service name, such as "web" or "svc:web", only the changes to that
+// service are returned.
+func (r *Reconciler) History(service string) []HistoryEvent {
+	if service != "" && !strings.HasPrefix(service, "svc:") {
+		service = "svc:" + service
+	}
+	return r.history.list(service)
+}
+
+// cycleEvents returns the events describing what a cycle did, ending with its
+// cycle_end event
+func cycleEvents(now time.Time, result *apptypes.ReconcileResult) []HistoryEvent {
+	var events []HistoryEvent
+	change := func(kind string, keys []string, service bool) {
+		for _, key := range keys {
+			event := HistoryEvent{Time: now, Kind: kind, Trigger: result.Trigger, Key: key, DryRun: result.DryRun}
+			if service {
+				event.Service = serviceOfKey(key)
+			}
+			events = append(events, event)
+		}
+	}
+	change(EventAdd, result.Added, true)
+	change(EventUpdate, result.Changed, true)
+	change(EventRemove, result.Removed, true)
+	change(EventDefer, result.Deferred, true)
+	change(EventFunnelAdd, result.FunnelsAdded, false)
+	change(EventFunnelRemove, result.FunnelsRemoved, false)
+
+	if result.Err != nil {
+		events = append(events, HistoryEvent{Time: now, Kind: EventError, Trigger: result.Trigger, DryRun: result.DryRun, Error: result.Err.Error()})
+	}
+	events = append(events, HistoryEvent{Time: now, Kind: EventCycleEnd, Trigger: result.Trigger, DryRun: result.DryRun, Result: result})
+	return events
+}
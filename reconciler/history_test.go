@@ -0,0 +1,145 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// historyKeys returns the keys of events
+func historyKeys(events []HistoryEvent) []string {
+	keys := make([]string, len(events))
+	for i, event := range events {
+		keys[i] = event.Key
+	}
+	return keys
+}
+
+func TestHistoryWraps(t *testing.T) {
+	h := newHistory(3)
+	if got := h.list(""); len(got) != 0 {
+		t.Fatalf("list() = %v, want nothing", got)
+	}
+
+	tests := []struct {
+		add  []string
+		want []string
+	}{
+		{add: []string{"a"}, want: []string{"a"}},
+		{add: []string{"b", "c"}, want: []string{"a", "b", "c"}},
+		{add: []string{"d"}, want: []string{"b", "c", "d"}},
+		{add: []string{"e", "f", "g"}, want: []string{"e", "f", "g"}},
+		{add: []string{"h", "i", "j", "k"}, want: []string{"i", "j", "k"}},
+	}
+	for _, tt := range tests {
+		for _, key := range tt.add {
+			h.add(HistoryEvent{Key: key})
+		}
+		if got := historyKeys(h.list("")); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("after adding %v, list() = %v, want %v", tt.add, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	h := newHistory(0)
+	h.add(HistoryEvent{Key: "a"})
+	if got := h.list(""); got != nil {
+		t.Errorf("list() = %v, want nil", got)
+	}
+}
+
+func TestHistoryConcurrentAdds(t *testing.T) {
+	h := newHistory(50)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 20 {
+				h.add(HistoryEvent{Key: fmt.Sprintf("%d-%d", i, j)})
+				h.list("")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := h.list(""); len(got) != 50 {
+		t.Errorf("list() has %d events, want 50", len(got))
+	}
+}
+
+func TestReconcilerHistory(t *testing.T) {
+	docker := &fakeDockerClient{}
+	ts := &fakeTailscaleClient{result: &apptypes.ReconcileResult{
+		Added:        []string{"svc:web:443"},
+		Removed:      []string{"svc:old:80"},
+		FunnelsAdded: []string{"8443"},
+	}}
+	rec := NewReconciler(docker, ts, Options{})
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	ts.result, ts.err = nil, errors.New("tailscaled unreachable")
+	_ = rec.Reconcile(context.Background())
+
+	var kinds []string
+	for _, event := range rec.History("") {
+		kinds = append(kinds, event.Kind)
+		if event.Time.IsZero() || event.Trigger != TriggerForced {
+			t.Errorf("event %+v should have a time and the forced trigger", event)
+		}
+	}
+	want := []string{
+		EventCycleStart, EventAdd, EventRemove, EventFunnelAdd, EventCycleEnd,
+		EventCycleStart, EventError, EventCycleEnd,
+	}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Fatalf("kinds = %v, want %v", kinds, want)
+	}
+
+	events := rec.History("")
+	if end := events[4]; end.Result == nil || len(end.Result.Added) != 1 || end.Result.Duration <= 0 {
+		t.Errorf("cycle_end event = %+v, want the result of the cycle", end)
+	}
+	if events[6].Error == "" {
+		t.Errorf("error event = %+v, want the error", events[6])
+	}
+
+	for _, service := range []string{"web", "svc:web"} {
+		got := rec.History(service)
+		if len(got) != 1 || got[0].Kind != EventAdd || got[0].Key != "svc:web:443" || got[0].Service != "svc:web" {
+			t.Errorf("History(%q) = %+v, want the addition of svc:web", service, got)
+		}
+	}
+}
+
+func TestReconcilerHistorySize(t *testing.T) {
+	rec := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{HistorySize: 4})
+	for range 5 {
+		if err := rec.Reconcile(context.Background()); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	events := rec.History("")
+	if len(events) != 4 {
+		t.Fatalf("History() has %d events, want 4", len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Time.Before(events[i-1].Time) {
+			t.Errorf("History() is not oldest first: %v before %v", events[i-1].Time, events[i].Time)
+		}
+	}
+
+	if got := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{HistorySize: -1}).History(""); got != nil {
+		t.Errorf("History() = %v with a negative size, want nil", got)
+	}
+	if got := len(NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{}).history.events); got != DefaultHistorySize {
+		t.Errorf("default history size = %d, want %d", got, DefaultHistorySize)
+	}
+}
@@ -2,45 +2,407 @@ package reconciler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/docker/docker/api/types/events"
 
-	"github.com/marvinvr/docktail/docker"
+	"github.com/marvinvr/docktail/report"
 	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
 )
 
+// DefaultInterval is the periodic reconciliation interval used when none is configured
+const DefaultInterval = 60 * time.Second
+
+// startupRetryDelays are the waits before retrying a failed initial
+// reconciliation, so a tailscaled or Docker daemon that is still starting
+// doesn't hold services back for a whole interval
+var startupRetryDelays = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+
+// Reasons a reconciliation cycle runs, recorded in ReconcileResult.Trigger
+const (
+	TriggerStartup  = "startup"  // The first cycle when Run starts
+	TriggerEvent    = "event"    // A Docker container event
+	TriggerPeriodic = "periodic" // The reconcile interval elapsed
+	TriggerForced   = "forced"   // TriggerReconcile, Reconcile, or a follow-up to one of them
+)
+
+// DockerClient is the subset of the Docker client used by the reconciler
+type DockerClient interface {
+	GetEnabledContainers(ctx context.Context) ([]*apptypes.ContainerService, error)
+	WatchEvents(ctx context.Context) (<-chan events.Message, <-chan error)
+}
+
+// TailscaleClient is the subset of the Tailscale client used by the reconciler
+type TailscaleClient interface {
+	ReconcileServices(ctx context.Context, desiredServices []*apptypes.ContainerService, opts tailscale.ReconcileOptions) (*apptypes.ReconcileResult, error)
+}
+
+// nodeInfoProvider is implemented by Tailscale clients that know their node's identity
+type nodeInfoProvider interface {
+	NodeInfo() tailscale.NodeInfo
+}
+
+// configDiffProvider is implemented by Tailscale clients that keep the
+// difference between the node's config and the desired one
+type configDiffProvider interface {
+	LastConfigDiff() tailscale.ConfigDiff
+}
+
+// skipProvider is implemented by Docker clients that keep the containers
+// their last discovery skipped
+type skipProvider interface {
+	SkippedContainers() []apptypes.SkippedContainer
+}
+
+// State is a snapshot of the reconciler exposed over the HTTP API
+type State struct {
+	Interval time.Duration
+	DryRun   bool
+	// Paused is set while changes are held back by Pause
+	Paused bool
+	// ShadowCyclesLeft is how many shadow cycles are left before changes are
+	// applied
+	ShadowCyclesLeft int
+	// Maintenance is set while removals are deferred by SetMaintenance
+	Maintenance bool
+	// Node is the identity of the default Tailscale node, if known
+	Node tailscale.NodeInfo
+	// LastReconcile is the result of the most recent cycle (nil before the first one)
+	LastReconcile *apptypes.ReconcileResult
+	// LastReconcileAt is when the most recent cycle started
+	LastReconcileAt time.Time
+	// LastSuccessAt is when the most recent cycle without an error started
+	LastSuccessAt time.Time
+	// Aliases maps each alias service to its primary service, as of the last cycle
+	Aliases map[string]string
+	// Unadvertised lists services that are configured on the node but not
+	// advertised (docktail.service.advertise=false), as of the last cycle
+	Unadvertised []string
+	// Services holds when each managed service was first advertised and last changed
+	Services map[string]ServiceTimes
+	// ConfigDiff is how the default node's config differed from the desired
+	// one in the last cycle, if known
+	ConfigDiff tailscale.ConfigDiff
+	// Skipped lists the containers the last discovery left out, if known
+	Skipped []apptypes.SkippedContainer
+}
+
+// Options configures a Reconciler
+type Options struct {
+	// Interval between periodic reconciliations (default: DefaultInterval)
+	Interval time.Duration
+	// DryRun computes and logs changes without applying them to Tailscale
+	DryRun bool
+	// Maintenance starts the reconciler in maintenance mode (see SetMaintenance)
+	Maintenance bool
+	// OnReconcile, if set, is invoked with the result of every reconciliation cycle
+	OnReconcile func(apptypes.ReconcileResult)
+	// OnStarted, if set, is invoked by Run once the initial reconciliation and
+	// its retries are done, whether or not they succeeded
+	OnStarted func()
+	// Heartbeat, if set, is invoked by Run every HeartbeatInterval. It is
+	// called from the loop itself, so it stops while the loop is stuck.
+	Heartbeat         func()
+	HeartbeatInterval time.Duration
+	// Sockets holds additional Tailscale clients keyed by socket name. Services whose
+	// docktail.socket label names one of them are advertised through it instead of
+	// the default client.
+	Sockets map[string]TailscaleClient
+	// AuditLog, if set, receives a JSON line for every cycle that changed
+	// services or funnels
+	AuditLog io.Writer
+	// StateDir, if set, is where service times are kept across restarts
+	StateDir string
+	// ErrorReporter, if set, receives failed cycles, except failures of the
+	// initial cycle that one of its retries recovers from
+	ErrorReporter report.Reporter
+	// HistorySize is how many events History keeps (default:
+	// DefaultHistorySize); negative keeps none
+	HistorySize int
+	// ShadowCycles is how many successful cycles compute and log changes
+	// without applying them, before the reconciler starts applying them. The
+	// countdown is kept in StateDir across restarts.
+	ShadowCycles int
+	// ApplyMinInterval is the least time between the end of a cycle that
+	// changed services or funnels and the start of the next cycle that may.
+	// Requests made sooner are coalesced into a cycle run once it has passed.
+	ApplyMinInterval time.Duration
+	// Attempts is how many times a failed cycle is run before it is given up
+	// until the next trigger (default: 1, no retries)
+	Attempts int
+}
+
 // Reconciler manages the reconciliation loop
 type Reconciler struct {
-	dockerClient    *docker.Client
-	tailscaleClient *tailscale.Client
-	interval        time.Duration
+	dockerClient    DockerClient
+	tailscaleClient TailscaleClient
+	dryRun          bool
+	onReconcile     func(apptypes.ReconcileResult)
+	onStarted       func()
+	heartbeat       func()
+	heartbeatEvery  time.Duration
+	sockets         map[string]TailscaleClient
+	auditLog        io.Writer
+	stateDir        string
+	reporter        report.Reporter
+	// startupRetryDelays are the waits between attempts of the initial cycle
+	startupRetryDelays []time.Duration
+	// attempts is how many times a failed cycle runs, retryDelay apart
+	attempts   int
+	retryDelay time.Duration
+
+	mu           sync.Mutex
+	interval     time.Duration
+	lastResult   *apptypes.ReconcileResult
+	lastRunAt    time.Time
+	lastSuccess  time.Time
+	aliases      map[string]string
+	unadvertised []string
+	// desiredServices holds the services managed as of the last cycle
+	desiredServices map[string]bool
+	serviceTimes    map[string]ServiceTimes
+	running         bool // a cycle is in progress
+	paused          bool // changes are computed and logged but not applied
+	maintenance     bool // services are added and updated but not removed
+	pending         bool // another cycle was requested while one was running
+	// pendingTrigger is the reason of the latest request coalesced into the
+	// follow-up cycle
+	pendingTrigger string
+	// destroyed holds the short IDs of containers destroyed since the last cycle
+	destroyed map[string]bool
+	// cycleContainers holds the containers the running cycle discovered, nil
+	// until it has them
+	cycleContainers []*apptypes.ContainerService
+	metrics         *serviceMetrics
+	// history holds the events of the most recent cycles
+	history *history
+	// sightings holds when containers with a startup delay were first seen,
+	// by container ID; startupTimer reconciles once the next delay is over
+	sightings    map[string]sighting
+	startupTimer *time.Timer
+	// shadowLeft is how many of the shadowConfigured shadow cycles are left
+	shadowLeft       int
+	shadowConfigured int
+	// lastApplyAt is when the last cycle that changed something ended;
+	// applyTimer runs the cycle held back until applyDueAt
+	applyMinInterval time.Duration
+	lastApplyAt      time.Time
+	applyDueAt       time.Time
+	applyTimer       *time.Timer
+	// now is the clock of the minimum apply interval
+	now func() time.Time
+
+	// trigger requests an immediate reconciliation from Run
+	trigger chan struct{}
+	// intervalChanged tells Run to reset its ticker
+	intervalChanged chan struct{}
 }
 
 // NewReconciler creates a new reconciler
-func NewReconciler(dockerClient *docker.Client, tailscaleClient *tailscale.Client, interval time.Duration) *Reconciler {
-	return &Reconciler{
-		dockerClient:    dockerClient,
-		tailscaleClient: tailscaleClient,
-		interval:        interval,
+func NewReconciler(dockerClient DockerClient, tailscaleClient TailscaleClient, opts Options) *Reconciler {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	historySize := opts.HistorySize
+	if historySize == 0 {
+		historySize = DefaultHistorySize
 	}
+	attempts := opts.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	r := &Reconciler{
+		dockerClient:       dockerClient,
+		tailscaleClient:    tailscaleClient,
+		interval:           interval,
+		dryRun:             opts.DryRun,
+		maintenance:        opts.Maintenance,
+		onReconcile:        opts.OnReconcile,
+		onStarted:          opts.OnStarted,
+		heartbeat:          opts.Heartbeat,
+		heartbeatEvery:     opts.HeartbeatInterval,
+		sockets:            opts.Sockets,
+		auditLog:           opts.AuditLog,
+		stateDir:           opts.StateDir,
+		reporter:           report.OrNop(opts.ErrorReporter),
+		metrics:            defaultServiceMetrics,
+		history:            newHistory(historySize),
+		sightings:          make(map[string]sighting),
+		startupRetryDelays: startupRetryDelays,
+		attempts:           attempts,
+		retryDelay:         retryDelay,
+		applyMinInterval:   opts.ApplyMinInterval,
+		now:                time.Now,
+		trigger:            make(chan struct{}, 1),
+		intervalChanged:    make(chan struct{}, 1),
+	}
+	r.loadServiceTimes()
+	r.loadShadowCycles(opts.ShadowCycles)
+	return r
+}
+
+// TriggerReconcile requests an immediate reconciliation from the running loop.
+// Requests made while one is already pending are coalesced.
+func (r *Reconciler) TriggerReconcile() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops applying changes: cycles keep running and log what they would
+// change, as in dry-run mode, until Resume is called
+func (r *Reconciler) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = true
+}
+
+// Resume applies changes again and requests a reconciliation, so everything
+// held back while paused is applied right away
+func (r *Reconciler) Resume() {
+	r.mu.Lock()
+	r.paused = false
+	r.mu.Unlock()
+	r.TriggerReconcile()
+}
+
+// SetMaintenance turns maintenance mode on or off. In maintenance mode cycles
+// still add and update services, but removals, including drains of stopped
+// containers and stale funnels, are deferred, so a flaky deploy or a
+// transiently empty discovery can't tear services down. Turning it off
+// requests a reconciliation that carries out the deferred removals.
+func (r *Reconciler) SetMaintenance(on bool) {
+	r.mu.Lock()
+	changed := r.maintenance != on
+	r.maintenance = on
+	r.mu.Unlock()
+	if changed && !on {
+		r.TriggerReconcile()
+	}
+}
+
+// Maintenance reports whether removals are deferred by SetMaintenance
+func (r *Reconciler) Maintenance() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.maintenance
+}
+
+// Paused reports whether changes are held back by Pause
+func (r *Reconciler) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// Interval returns the current periodic reconciliation interval
+func (r *Reconciler) Interval() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.interval
+}
+
+// State returns a snapshot of the reconciler state
+func (r *Reconciler) State() State {
+	r.mu.Lock()
+	state := State{
+		Interval:        r.interval,
+		DryRun:          r.dryRun,
+		Paused:          r.paused,
+		Maintenance:     r.maintenance,
+		LastReconcileAt: r.lastRunAt,
+		LastSuccessAt:   r.lastSuccess,
+	}
+	state.ShadowCyclesLeft = r.shadowLeft
+	if r.lastResult != nil {
+		last := *r.lastResult
+		state.LastReconcile = &last
+	}
+	if len(r.aliases) > 0 {
+		state.Aliases = make(map[string]string, len(r.aliases))
+		for alias, primary := range r.aliases {
+			state.Aliases[alias] = primary
+		}
+	}
+	if len(r.unadvertised) > 0 {
+		state.Unadvertised = append([]string(nil), r.unadvertised...)
+	}
+	if len(r.serviceTimes) > 0 {
+		state.Services = make(map[string]ServiceTimes, len(r.serviceTimes))
+		for serviceName, times := range r.serviceTimes {
+			state.Services[serviceName] = times
+		}
+	}
+	r.mu.Unlock()
+
+	if provider, ok := r.tailscaleClient.(nodeInfoProvider); ok {
+		state.Node = provider.NodeInfo()
+	}
+	if provider, ok := r.tailscaleClient.(configDiffProvider); ok {
+		state.ConfigDiff = provider.LastConfigDiff()
+	}
+	if provider, ok := r.dockerClient.(skipProvider); ok {
+		state.Skipped = provider.SkippedContainers()
+	}
+	return state
+}
+
+// SetInterval changes the periodic reconciliation interval. The running loop
+// resets its ticker so the new interval takes effect immediately.
+func (r *Reconciler) SetInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", interval)
+	}
+
+	r.mu.Lock()
+	r.interval = interval
+	r.mu.Unlock()
+
+	select {
+	case r.intervalChanged <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
 // Run starts the reconciliation loop
 func (r *Reconciler) Run(ctx context.Context) error {
 	// Initial reconciliation
-	if err := r.Reconcile(ctx); err != nil {
+	if err := r.reconcileAtStartup(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		log.Error().Err(err).Msg("Initial reconciliation failed")
+		r.reportFailure(ctx, TriggerStartup, err)
+	}
+	if r.onStarted != nil {
+		r.onStarted()
 	}
 
 	// Start event watcher
 	eventsChan, errChan := r.dockerClient.WatchEvents(ctx)
 
 	// Start periodic reconciliation ticker
-	ticker := time.NewTicker(r.interval)
+	ticker := time.NewTicker(r.Interval())
 	defer ticker.Stop()
 
+	// A nil channel never fires, so without a heartbeat the case is idle
+	var heartbeat <-chan time.Time
+	if r.heartbeat != nil && r.heartbeatEvery > 0 {
+		heartbeatTicker := time.NewTicker(r.heartbeatEvery)
+		defer heartbeatTicker.Stop()
+		heartbeat = heartbeatTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -60,34 +422,203 @@ func (r *Reconciler) Run(ctx context.Context) error {
 				Str("container", event.Actor.ID[:12]).
 				Msg("Docker event received")
 
+			// A destroyed container won't come back, so its services are removed
+			// without waiting for the grace period that covers restarts
+			if event.Action == events.ActionDestroy {
+				r.markDestroyed(event.Actor.ID)
+			}
+
 			// Trigger reconciliation on relevant events
-			if err := r.Reconcile(ctx); err != nil {
+			if err := r.reconcileFor(ctx, TriggerEvent); err != nil {
 				log.Error().Err(err).Msg("Event-triggered reconciliation failed")
 			}
 
+		case <-r.trigger:
+			log.Info().Msg("Running requested reconciliation")
+			if err := r.Reconcile(ctx); err != nil {
+				log.Error().Err(err).Msg("Requested reconciliation failed")
+			}
+
+		case <-r.intervalChanged:
+			interval := r.Interval()
+			ticker.Reset(interval)
+			log.Info().Dur("interval", interval).Msg("Reconcile interval changed")
+
 		case <-ticker.C:
 			log.Debug().Msg("Running periodic reconciliation")
-			if err := r.Reconcile(ctx); err != nil {
+			if err := r.reconcileFor(ctx, TriggerPeriodic); err != nil {
 				log.Error().Err(err).Msg("Periodic reconciliation failed")
 			}
+
+		case <-heartbeat:
+			r.heartbeat()
+		}
+	}
+}
+
+// reconcileAtStartup runs the initial reconciliation, retrying a failed one a
+// few times with increasing delays before Run falls back to its ticker. It
+// returns the error of the last attempt.
+func (r *Reconciler) reconcileAtStartup(ctx context.Context) error {
+	err := r.reconcileFor(ctx, TriggerStartup)
+	for _, delay := range r.startupRetryDelays {
+		if err == nil {
+			return nil
+		}
+		log.Warn().Err(err).Dur("retry_in", delay).Msg("Initial reconciliation failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
 		}
+		err = r.reconcileFor(ctx, TriggerStartup)
 	}
+	return err
 }
 
-// Reconcile performs a single reconciliation cycle
+// Reconcile performs a reconciliation cycle and reports its result to the
+// OnReconcile hook, if one is configured. At most one cycle runs at a time:
+// calls made while one is running are coalesced into a single follow-up cycle
+// run by the caller that holds the cycle, and return immediately.
 func (r *Reconciler) Reconcile(ctx context.Context) error {
-	log.Info().Msg("Starting reconciliation")
+	return r.reconcileFor(ctx, TriggerForced)
+}
+
+// reconcileFor is Reconcile with the reason the cycle runs. A follow-up cycle
+// takes the reason of the last request coalesced into it.
+func (r *Reconciler) reconcileFor(ctx context.Context, trigger string) error {
+	r.mu.Lock()
+	if r.running {
+		r.pending = true
+		r.pendingTrigger = trigger
+		r.mu.Unlock()
+		log.Debug().Msg("Reconciliation already running, coalescing request into a follow-up cycle")
+		return nil
+	}
+	if r.deferApply() {
+		r.mu.Unlock()
+		return nil
+	}
+	r.running = true
+	r.mu.Unlock()
+
+	for {
+		err := r.runAttempts(ctx, trigger)
+
+		r.mu.Lock()
+		if !r.pending || ctx.Err() != nil || r.deferApply() {
+			r.running = false
+			r.pending = false
+			r.mu.Unlock()
+			return err
+		}
+		r.pending = false
+		trigger = r.pendingTrigger
+		r.mu.Unlock()
+	}
+}
+
+// runCycle performs a single reconciliation cycle and records its result
+func (r *Reconciler) runCycle(ctx context.Context, trigger string) error {
+	start := time.Now()
+
+	r.mu.Lock()
+	shadowLeft := r.shadowLeft
+	shadow := shadowLeft > 0
+	dryRun := r.dryRun || r.paused || shadow
+	maintenance := r.maintenance
+	r.mu.Unlock()
+
+	if shadow {
+		log.Info().Int("shadow_cycles_left", shadowLeft).Msg("Shadow mode, computing changes without applying them")
+	}
+
+	r.history.add(HistoryEvent{Time: start, Kind: EventCycleStart, Trigger: trigger, DryRun: dryRun})
+
+	// Only periodic cycles may trust the serve config applied last
+	// (VERIFY_INTERVAL); anything else may have changed the containers
+	result, err := r.reconcile(ctx, dryRun, maintenance, trigger != TriggerPeriodic)
+	if result == nil {
+		result = &apptypes.ReconcileResult{DryRun: dryRun}
+	}
+	result.Err = err
+	result.Duration = time.Since(start)
+	result.Trigger = trigger
+
+	r.mu.Lock()
+	r.lastResult = result
+	r.lastRunAt = start
+	if err == nil {
+		r.lastSuccess = start
+	}
+	r.updateServiceTimes(start, result)
+	r.updateServiceMetrics(start, result)
+	r.recordApply(result)
+	if shadow && err == nil {
+		r.countShadowCycle()
+	}
+	r.mu.Unlock()
+
+	if err == nil {
+		r.writeLastSuccess(start)
+	}
+
+	r.writeAudit(start, result)
+	r.history.add(cycleEvents(start.Add(result.Duration), result)...)
+	if r.onReconcile != nil {
+		r.onReconcile(*result)
+	}
+
+	return err
+}
+
+// reportFailure passes the error of a failed cycle on to the error reporter,
+// unless the cycle failed because ctx was cancelled
+func (r *Reconciler) reportFailure(ctx context.Context, trigger string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	r.reporter.Report(ctx, err, map[string]string{"trigger": trigger})
+}
+
+// reconcile computes the desired services and reconciles every socket. With
+// dryRun set, changes are only logged; with maintenance set, removals are
+// deferred; with verify set, the serve config is read even when the desired
+// services are as last applied.
+func (r *Reconciler) reconcile(ctx context.Context, dryRun, maintenance, verify bool) (*apptypes.ReconcileResult, error) {
+	log.Info().Bool("dry_run", dryRun).Bool("maintenance", maintenance).Msg("Starting reconciliation")
+
+	r.mu.Lock()
+	r.cycleContainers = nil
+	r.mu.Unlock()
 
 	// Get all enabled containers from Docker
 	containers, err := r.dockerClient.GetEnabledContainers(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get enabled containers: %w", err)
+		return nil, fmt.Errorf("failed to get enabled containers: %w", err)
 	}
 
 	log.Info().
 		Int("count", len(containers)).
 		Msg("Found enabled containers")
 
+	containers = r.holdStartingContainers(time.Now(), containers)
+
+	aliases := make(map[string]string)
+	for _, container := range containers {
+		if container.AliasOf != "" {
+			aliases["svc:"+container.ServiceName] = "svc:" + container.AliasOf
+		}
+	}
+	unadvertised := unadvertisedServices(containers)
+	r.mu.Lock()
+	r.aliases = aliases
+	r.unadvertised = unadvertised
+	r.desiredServices = desiredServiceNames(containers)
+	r.cycleContainers = containers
+	r.mu.Unlock()
+
 	for _, container := range containers {
 		event := log.Debug().
 			Str("container", container.ContainerName).
@@ -95,7 +626,16 @@ func (r *Reconciler) Reconcile(ctx context.Context) error {
 			Bool("funnel_enabled", container.FunnelEnabled).
 			Str("ip", container.IPAddress)
 
+		if container.DockerHost != "" {
+			event = event.Str("docker_host", container.DockerHost)
+		}
 		if container.ServiceEnabled {
+			if container.AliasOf != "" {
+				event = event.Str("alias_of", container.AliasOf)
+			}
+			if container.Role != "" {
+				event = event.Str("role", container.Role)
+			}
 			event = event.
 				Str("service", container.ServiceName).
 				Str("port", container.Port).
@@ -115,12 +655,143 @@ func (r *Reconciler) Reconcile(ctx context.Context) error {
 
 	// Reconcile services using CLI commands
 	// This will compare current state with desired state and make incremental changes
-	// When containers stop, their services are gracefully drained (existing connections complete)
-	// then cleared (configuration removed) for security
-	if err := r.tailscaleClient.ReconcileServices(ctx, containers); err != nil {
-		return fmt.Errorf("failed to reconcile services: %w", err)
+	// When containers stop, their services are drained (existing connections complete)
+	// and cleared (configuration removed) once they are destroyed or stay gone
+	opts := tailscale.ReconcileOptions{DryRun: dryRun, Maintenance: maintenance, Verify: verify}
+	// Destroyed containers are kept for the first cycle after maintenance, so
+	// their services are removed right away then
+	if !maintenance {
+		opts.Destroyed = r.takeDestroyed()
+	}
+	groups := r.groupBySocket(containers)
+	if len(r.sockets) == 0 {
+		result, err := r.tailscaleClient.ReconcileServices(ctx, groups[""], opts)
+		if err != nil {
+			return result, fmt.Errorf("failed to reconcile services: %w", err)
+		}
+
+		log.Info().Msg("Reconciliation completed successfully")
+		return result, nil
+	}
+
+	// Every socket is reconciled, even without desired services, so services
+	// removed from a socket are cleaned up there
+	names := make([]string, 0, len(r.sockets))
+	for name := range r.sockets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := &apptypes.ReconcileResult{DryRun: dryRun}
+	var errs []error
+
+	res, err := r.tailscaleClient.ReconcileServices(ctx, groups[""], opts)
+	mergeResult(result, res)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("default socket: %w", err))
+	}
+
+	for _, name := range names {
+		res, err := r.sockets[name].ReconcileServices(ctx, groups[name], opts)
+		mergeResult(result, res)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("socket %s: %w", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return result, fmt.Errorf("failed to reconcile services: %w", errors.Join(errs...))
 	}
 
 	log.Info().Msg("Reconciliation completed successfully")
-	return nil
+	return result, nil
+}
+
+// unadvertisedServices returns the sorted services whose containers all set
+// docktail.service.advertise=false. A service is advertised as long as one of
+// its containers wants it to be.
+func unadvertisedServices(containers []*apptypes.ContainerService) []string {
+	wanted := make(map[string]bool)
+	for _, container := range containers {
+		if !container.ServiceEnabled || container.Preserve {
+			continue
+		}
+		serviceName := "svc:" + container.ServiceName
+		wanted[serviceName] = wanted[serviceName] || !container.Unadvertised
+	}
+
+	var names []string
+	for serviceName, advertise := range wanted {
+		if !advertise {
+			names = append(names, serviceName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markDestroyed records a destroyed container for the next cycle
+func (r *Reconciler) markDestroyed(containerID string) {
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.destroyed == nil {
+		r.destroyed = make(map[string]bool)
+	}
+	r.destroyed[containerID] = true
+}
+
+// takeDestroyed returns the containers destroyed since the last cycle and resets the set
+func (r *Reconciler) takeDestroyed() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	destroyed := r.destroyed
+	r.destroyed = nil
+	return destroyed
+}
+
+// groupBySocket splits the desired services by the socket they are advertised on.
+// Services naming an unknown socket are dropped with a warning rather than being
+// advertised on the default tailnet.
+func (r *Reconciler) groupBySocket(containers []*apptypes.ContainerService) map[string][]*apptypes.ContainerService {
+	groups := make(map[string][]*apptypes.ContainerService)
+	for _, container := range containers {
+		if container.Socket != "" {
+			if _, ok := r.sockets[container.Socket]; !ok {
+				log.Warn().
+					Str("container", container.ContainerName).
+					Str("socket", container.Socket).
+					Msg("Container references unknown Tailscale socket, skipping")
+				continue
+			}
+		}
+		groups[container.Socket] = append(groups[container.Socket], container)
+	}
+	return groups
+}
+
+// mergeResult appends the changes from src to dst
+func mergeResult(dst, src *apptypes.ReconcileResult) {
+	if src == nil {
+		return
+	}
+	dst.Added = append(dst.Added, src.Added...)
+	dst.Removed = append(dst.Removed, src.Removed...)
+	dst.Changed = append(dst.Changed, src.Changed...)
+	dst.FunnelsAdded = append(dst.FunnelsAdded, src.FunnelsAdded...)
+	dst.FunnelsRemoved = append(dst.FunnelsRemoved, src.FunnelsRemoved...)
+	dst.Collisions = append(dst.Collisions, src.Collisions...)
+	dst.Deferred = append(dst.Deferred, src.Deferred...)
+	if src.Applied != nil {
+		dst.Applied = append(make([]string, 0, len(dst.Applied)+len(src.Applied)), dst.Applied...)
+		dst.Applied = append(dst.Applied, src.Applied...)
+	}
+	for key, reason := range src.Failed {
+		if dst.Failed == nil {
+			dst.Failed = make(map[string]string)
+		}
+		dst.Failed[key] = reason
+	}
 }
@@ -0,0 +1,683 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+
+	"github.com/marvinvr/docktail/tailscale"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+type fakeDockerClient struct {
+	containers []*apptypes.ContainerService
+	err        error
+}
+
+func (f *fakeDockerClient) GetEnabledContainers(ctx context.Context) ([]*apptypes.ContainerService, error) {
+	return f.containers, f.err
+}
+
+func (f *fakeDockerClient) WatchEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	return make(chan events.Message), make(chan error)
+}
+
+type fakeTailscaleClient struct {
+	result   *apptypes.ReconcileResult
+	err      error
+	calls    int
+	lastOpts tailscale.ReconcileOptions
+	desired  []*apptypes.ContainerService
+}
+
+func (f *fakeTailscaleClient) ReconcileServices(ctx context.Context, desired []*apptypes.ContainerService, opts tailscale.ReconcileOptions) (*apptypes.ReconcileResult, error) {
+	f.calls++
+	f.lastOpts = opts
+	f.desired = desired
+	res := apptypes.ReconcileResult{}
+	if f.result != nil {
+		res = *f.result
+	}
+	res.DryRun = opts.DryRun
+	if res.Applied == nil {
+		res.Applied = appliedKeys(desired)
+	}
+	return &res, f.err
+}
+
+// appliedKeys returns the keys of the desired services, as the Tailscale
+// client reports them applied
+func appliedKeys(desired []*apptypes.ContainerService) []string {
+	keys := []string{}
+	for _, svc := range desired {
+		if svc.ServiceEnabled && !svc.Preserve {
+			keys = append(keys, "svc:"+svc.ServiceName+":"+svc.Port+svc.Path)
+		}
+	}
+	return keys
+}
+
+func TestNewReconcilerDefaults(t *testing.T) {
+	rec := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{})
+	if rec.interval != DefaultInterval {
+		t.Errorf("interval = %v, want %v", rec.interval, DefaultInterval)
+	}
+	if rec.dryRun {
+		t.Error("dryRun should default to false")
+	}
+}
+
+func TestOnReconcileReceivesResult(t *testing.T) {
+	docker := &fakeDockerClient{
+		containers: []*apptypes.ContainerService{
+			{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443"},
+		},
+	}
+	ts := &fakeTailscaleClient{
+		result: &apptypes.ReconcileResult{
+			Added:        []string{"svc:web:443"},
+			Removed:      []string{"svc:old:80"},
+			Changed:      []string{"svc:api:443"},
+			FunnelsAdded: []string{"8443"},
+		},
+	}
+
+	var got []apptypes.ReconcileResult
+	rec := NewReconciler(docker, ts, Options{
+		DryRun:      true,
+		OnReconcile: func(r apptypes.ReconcileResult) { got = append(got, r) },
+	})
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("hook called %d times, want 1", len(got))
+	}
+	res := got[0]
+	if len(res.Added) != 1 || res.Added[0] != "svc:web:443" {
+		t.Errorf("Added = %v, want [svc:web:443]", res.Added)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != "svc:old:80" {
+		t.Errorf("Removed = %v, want [svc:old:80]", res.Removed)
+	}
+	if len(res.Changed) != 1 || res.Changed[0] != "svc:api:443" {
+		t.Errorf("Changed = %v, want [svc:api:443]", res.Changed)
+	}
+	if len(res.FunnelsAdded) != 1 || res.FunnelsAdded[0] != "8443" {
+		t.Errorf("FunnelsAdded = %v, want [8443]", res.FunnelsAdded)
+	}
+	if !res.DryRun || !ts.lastOpts.DryRun {
+		t.Error("expected dry run to be propagated to the tailscale client and result")
+	}
+	if res.Err != nil {
+		t.Errorf("Err = %v, want nil", res.Err)
+	}
+	if res.Duration <= 0 {
+		t.Errorf("Duration = %v, want > 0", res.Duration)
+	}
+	if len(ts.desired) != 1 {
+		t.Errorf("tailscale client received %d services, want 1", len(ts.desired))
+	}
+}
+
+func TestOnReconcileReceivesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		docker *fakeDockerClient
+		ts     *fakeTailscaleClient
+		calls  int
+	}{
+		{
+			name:   "docker discovery failure",
+			docker: &fakeDockerClient{err: errors.New("docker unavailable")},
+			ts:     &fakeTailscaleClient{},
+			calls:  0,
+		},
+		{
+			name:   "tailscale failure keeps partial result",
+			docker: &fakeDockerClient{},
+			ts: &fakeTailscaleClient{
+				result: &apptypes.ReconcileResult{Added: []string{"svc:web:443"}},
+				err:    errors.New("failed to add 1 services"),
+			},
+			calls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *apptypes.ReconcileResult
+			rec := NewReconciler(tt.docker, tt.ts, Options{
+				OnReconcile: func(r apptypes.ReconcileResult) { got = &r },
+			})
+
+			err := rec.Reconcile(context.Background())
+			if err == nil {
+				t.Fatal("expected error but got nil")
+			}
+			if got == nil {
+				t.Fatal("hook was not called")
+			}
+			if !errors.Is(got.Err, err) {
+				t.Errorf("result Err = %v, want %v", got.Err, err)
+			}
+			if tt.ts.calls != tt.calls {
+				t.Errorf("tailscale calls = %d, want %d", tt.ts.calls, tt.calls)
+			}
+			if tt.ts.result != nil && len(got.Added) != len(tt.ts.result.Added) {
+				t.Errorf("Added = %v, want %v", got.Added, tt.ts.result.Added)
+			}
+		})
+	}
+}
+
+func TestReconcileRoutesServicesBySocket(t *testing.T) {
+	docker := &fakeDockerClient{
+		containers: []*apptypes.ContainerService{
+			{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443"},
+			{ContainerName: "wiki", ServiceEnabled: true, ServiceName: "wiki", Port: "443", Socket: "work"},
+			{ContainerName: "media", ServiceEnabled: true, ServiceName: "media", Port: "443", Socket: "home"},
+			{ContainerName: "stray", ServiceEnabled: true, ServiceName: "stray", Port: "443", Socket: "unknown"},
+		},
+	}
+	defaultTS := &fakeTailscaleClient{}
+	workTS := &fakeTailscaleClient{result: &apptypes.ReconcileResult{Added: []string{"svc:wiki:443"}}}
+	homeTS := &fakeTailscaleClient{result: &apptypes.ReconcileResult{Added: []string{"svc:media:443"}}}
+
+	var got apptypes.ReconcileResult
+	rec := NewReconciler(docker, defaultTS, Options{
+		Sockets:     map[string]TailscaleClient{"work": workTS, "home": homeTS},
+		OnReconcile: func(r apptypes.ReconcileResult) { got = r },
+	})
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		client   *fakeTailscaleClient
+		expected string
+	}{
+		{"default socket", defaultTS, "web"},
+		{"work socket", workTS, "wiki"},
+		{"home socket", homeTS, "media"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.client.calls != 1 {
+				t.Fatalf("calls = %d, want 1", tt.client.calls)
+			}
+			if len(tt.client.desired) != 1 || tt.client.desired[0].ContainerName != tt.expected {
+				t.Errorf("desired = %v, want only %s", tt.client.desired, tt.expected)
+			}
+		})
+	}
+
+	if len(got.Added) != 2 {
+		t.Errorf("Added = %v, want results from both sockets", got.Added)
+	}
+}
+
+func TestReconcileSocketErrorsDoNotBlockOtherSockets(t *testing.T) {
+	docker := &fakeDockerClient{
+		containers: []*apptypes.ContainerService{
+			{ContainerName: "wiki", ServiceEnabled: true, ServiceName: "wiki", Port: "443", Socket: "work"},
+		},
+	}
+	defaultTS := &fakeTailscaleClient{err: errors.New("tailscaled unreachable")}
+	workTS := &fakeTailscaleClient{}
+
+	rec := NewReconciler(docker, defaultTS, Options{
+		Sockets: map[string]TailscaleClient{"work": workTS},
+	})
+
+	if err := rec.Reconcile(context.Background()); err == nil {
+		t.Fatal("expected error from default socket")
+	}
+	if workTS.calls != 1 || len(workTS.desired) != 1 {
+		t.Errorf("work socket calls = %d desired = %d, want 1 and 1", workTS.calls, len(workTS.desired))
+	}
+}
+
+// runReconciler starts Run in the background and returns a channel receiving
+// one value per completed reconciliation
+func runReconciler(t *testing.T, interval time.Duration) (*Reconciler, <-chan struct{}) {
+	t.Helper()
+
+	done := make(chan struct{}, 16)
+	rec := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{
+		Interval: interval,
+		OnReconcile: func(apptypes.ReconcileResult) {
+			select {
+			case done <- struct{}{}:
+			default:
+			}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = rec.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	waitReconcile(t, done, "initial")
+	return rec, done
+}
+
+func waitReconcile(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s reconciliation", what)
+	}
+}
+
+func TestTriggerReconcile(t *testing.T) {
+	rec, done := runReconciler(t, time.Hour)
+
+	rec.TriggerReconcile()
+	waitReconcile(t, done, "triggered")
+}
+
+func TestSetIntervalResetsTicker(t *testing.T) {
+	rec, done := runReconciler(t, time.Hour)
+
+	if err := rec.SetInterval(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetInterval() error = %v", err)
+	}
+	if got := rec.Interval(); got != 10*time.Millisecond {
+		t.Errorf("Interval() = %v, want 10ms", got)
+	}
+
+	waitReconcile(t, done, "periodic")
+}
+
+func TestSetIntervalRejectsNonPositive(t *testing.T) {
+	rec := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{})
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := rec.SetInterval(interval); err == nil {
+			t.Errorf("SetInterval(%v) expected error", interval)
+		}
+	}
+	if rec.Interval() != DefaultInterval {
+		t.Errorf("Interval() = %v, want %v", rec.Interval(), DefaultInterval)
+	}
+}
+
+// flakyTailscaleClient fails its first reconciliation, like a tailscaled that
+// is still starting. onCall, if set, runs at the start of every call.
+type flakyTailscaleClient struct {
+	calls  int
+	onCall func()
+}
+
+func (f *flakyTailscaleClient) ReconcileServices(ctx context.Context, desired []*apptypes.ContainerService, opts tailscale.ReconcileOptions) (*apptypes.ReconcileResult, error) {
+	if f.onCall != nil {
+		f.onCall()
+	}
+	f.calls++
+	if f.calls == 1 {
+		return nil, errors.New("tailscaled not running")
+	}
+	return &apptypes.ReconcileResult{}, nil
+}
+
+func TestRunRetriesFailedInitialReconcile(t *testing.T) {
+	results := make(chan apptypes.ReconcileResult, 16)
+	rec := NewReconciler(&fakeDockerClient{}, &flakyTailscaleClient{}, Options{
+		Interval:    time.Hour,
+		OnReconcile: func(result apptypes.ReconcileResult) { results <- result },
+	})
+	rec.startupRetryDelays = []time.Duration{10 * time.Millisecond, time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = rec.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	for i, wantErr := range []bool{true, false} {
+		select {
+		case result := <-results:
+			if (result.Err != nil) != wantErr || result.Trigger != TriggerStartup {
+				t.Errorf("attempt %d: err = %v trigger = %q, want error %v and trigger %q", i+1, result.Err, result.Trigger, wantErr, TriggerStartup)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for attempt %d", i+1)
+		}
+	}
+
+	// A successful retry ends the startup loop instead of waiting out the
+	// remaining delays
+	select {
+	case result := <-results:
+		t.Errorf("unexpected reconciliation after a successful retry: %+v", result)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type fakeNodeTailscaleClient struct {
+	fakeTailscaleClient
+	node tailscale.NodeInfo
+}
+
+func (f *fakeNodeTailscaleClient) NodeInfo() tailscale.NodeInfo {
+	return f.node
+}
+
+func TestStateReportsLastReconcileAndNode(t *testing.T) {
+	ts := &fakeNodeTailscaleClient{
+		fakeTailscaleClient: fakeTailscaleClient{
+			result: &apptypes.ReconcileResult{Added: []string{"svc:web:443"}},
+		},
+		node: tailscale.NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"},
+	}
+	rec := NewReconciler(&fakeDockerClient{}, ts, Options{Interval: time.Minute})
+
+	state := rec.State()
+	if state.LastReconcile != nil || !state.LastReconcileAt.IsZero() {
+		t.Errorf("state before first reconcile = %+v, want no last reconcile", state)
+	}
+	if state.Node != ts.node || state.Interval != time.Minute {
+		t.Errorf("state = %+v, want node %+v and interval 1m", state, ts.node)
+	}
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	state = rec.State()
+	if state.LastReconcile == nil || len(state.LastReconcile.Added) != 1 {
+		t.Fatalf("LastReconcile = %+v, want one added service", state.LastReconcile)
+	}
+	if state.LastReconcileAt.IsZero() {
+		t.Error("LastReconcileAt should be set after a reconcile")
+	}
+}
+
+func TestStateReportsUnadvertisedServices(t *testing.T) {
+	docker := &fakeDockerClient{containers: []*apptypes.ContainerService{
+		{ContainerName: "staging", ServiceEnabled: true, ServiceName: "staging", Port: "443", Unadvertised: true},
+		{ContainerName: "web-1", ServiceEnabled: true, ServiceName: "web", Port: "443", Unadvertised: true},
+		{ContainerName: "web-2", ServiceEnabled: true, ServiceName: "web", Port: "80"},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443"},
+	}}
+	rec := NewReconciler(docker, &fakeTailscaleClient{}, Options{})
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	// web stays advertised because one of its containers wants it
+	if got, want := rec.State().Unadvertised, []string{"svc:staging"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unadvertised = %v, want %v", got, want)
+	}
+
+	// Dropping the label clears the status on the next cycle
+	docker.containers[0].Unadvertised = false
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if got := rec.State().Unadvertised; len(got) != 0 {
+		t.Errorf("Unadvertised = %v, want none", got)
+	}
+}
+
+// blockingDockerClient records how many cycles run at once and holds the first
+// cycle until released
+type blockingDockerClient struct {
+	fakeDockerClient
+	entered  chan struct{}
+	release  chan struct{}
+	mu       sync.Mutex
+	inFlight int
+	maxSeen  int
+	cycles   int
+}
+
+func (f *blockingDockerClient) GetEnabledContainers(ctx context.Context) ([]*apptypes.ContainerService, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.cycles++
+	first := f.cycles == 1
+	f.maxSeen = max(f.maxSeen, f.inFlight)
+	f.mu.Unlock()
+
+	if first {
+		close(f.entered)
+		<-f.release
+	}
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+	return nil, nil
+}
+
+func TestReconcileSerializesAndCoalesces(t *testing.T) {
+	docker := &blockingDockerClient{entered: make(chan struct{}), release: make(chan struct{})}
+	r := NewReconciler(docker, &fakeTailscaleClient{}, Options{})
+
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- r.Reconcile(context.Background()) }()
+	<-docker.entered
+
+	// Requests made while the first cycle runs return immediately
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.Reconcile(context.Background()); err != nil {
+				t.Errorf("coalesced Reconcile() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(docker.release)
+	if err := <-firstDone; err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	docker.mu.Lock()
+	defer docker.mu.Unlock()
+	if docker.maxSeen != 1 {
+		t.Errorf("max concurrent cycles = %d, want 1", docker.maxSeen)
+	}
+	if docker.cycles != 2 {
+		t.Errorf("cycles = %d, want the first plus one coalesced follow-up", docker.cycles)
+	}
+}
+
+func TestReconcilePassesDestroyedContainersOnce(t *testing.T) {
+	ts := &fakeTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{})
+
+	r.markDestroyed("aaa111bbb222ccc333ddd444")
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !ts.lastOpts.Destroyed["aaa111bbb222"] || len(ts.lastOpts.Destroyed) != 1 {
+		t.Errorf("Destroyed = %v, want the short ID aaa111bbb222", ts.lastOpts.Destroyed)
+	}
+
+	// Each destroy is reported to a single cycle
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(ts.lastOpts.Destroyed) != 0 {
+		t.Errorf("Destroyed = %v, want none on the next cycle", ts.lastOpts.Destroyed)
+	}
+}
+
+func TestOnlyPeriodicCyclesSkipVerification(t *testing.T) {
+	ts := &fakeTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{})
+
+	for _, trigger := range []string{TriggerStartup, TriggerEvent, TriggerForced, TriggerPeriodic} {
+		if err := r.reconcileFor(context.Background(), trigger); err != nil {
+			t.Fatalf("reconcileFor(%s) error = %v", trigger, err)
+		}
+		if want := trigger != TriggerPeriodic; ts.lastOpts.Verify != want {
+			t.Errorf("%s cycle: Verify = %v, want %v", trigger, ts.lastOpts.Verify, want)
+		}
+	}
+}
+
+func TestPauseHoldsBackChanges(t *testing.T) {
+	ts := &fakeTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{})
+
+	r.Pause()
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !ts.lastOpts.DryRun || !r.State().Paused {
+		t.Errorf("paused cycle DryRun = %v, state paused = %v, want both set", ts.lastOpts.DryRun, r.State().Paused)
+	}
+	if last := r.State().LastReconcile; last == nil || !last.DryRun {
+		t.Errorf("paused cycle result = %+v, want a dry-run result", last)
+	}
+
+	// Resuming requests a cycle that applies the held-back changes
+	r.Resume()
+	select {
+	case <-r.trigger:
+	default:
+		t.Error("Resume() did not request a reconciliation")
+	}
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ts.lastOpts.DryRun || r.State().Paused {
+		t.Errorf("resumed cycle DryRun = %v, state paused = %v, want both cleared", ts.lastOpts.DryRun, r.State().Paused)
+	}
+}
+
+func TestMaintenanceDefersRemovals(t *testing.T) {
+	ts := &fakeTailscaleClient{result: &apptypes.ReconcileResult{Deferred: []string{"svc:web:443"}}}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{Maintenance: true})
+
+	r.markDestroyed("aaa111bbb222ccc333")
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !ts.lastOpts.Maintenance || len(ts.lastOpts.Destroyed) != 0 {
+		t.Errorf("maintenance cycle opts = %+v, want maintenance without destroyed containers", ts.lastOpts)
+	}
+	if state := r.State(); !state.Maintenance || len(state.LastReconcile.Deferred) != 1 {
+		t.Errorf("state = %+v, want maintenance with a deferred removal", state)
+	}
+
+	// Ending maintenance requests a cycle that carries out the deferred removals,
+	// including those of containers destroyed meanwhile
+	r.SetMaintenance(false)
+	select {
+	case <-r.trigger:
+	default:
+		t.Error("SetMaintenance(false) did not request a reconciliation")
+	}
+	ts.result = nil
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ts.lastOpts.Maintenance || !ts.lastOpts.Destroyed["aaa111bbb222"] {
+		t.Errorf("cycle after maintenance opts = %+v, want the destroyed container and no maintenance", ts.lastOpts)
+	}
+}
+
+func TestRunNotifiesStartedAndHeartbeats(t *testing.T) {
+	var reconciled bool
+	started := make(chan bool, 1)
+	heartbeats := make(chan struct{}, 16)
+	rec := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{
+		Interval:    time.Hour,
+		OnReconcile: func(apptypes.ReconcileResult) { reconciled = true },
+		OnStarted:   func() { started <- reconciled },
+		Heartbeat: func() {
+			select {
+			case heartbeats <- struct{}{}:
+			default:
+			}
+		},
+		HeartbeatInterval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = rec.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	select {
+	case afterReconcile := <-started:
+		if !afterReconcile {
+			t.Error("OnStarted was called before the initial reconciliation")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for OnStarted")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-heartbeats:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for heartbeat %d", i+1)
+		}
+	}
+}
+
+type fakeReporter struct {
+	fields []map[string]string
+}
+
+func (f *fakeReporter) Report(_ context.Context, _ error, fields map[string]string) {
+	f.fields = append(f.fields, fields)
+}
+
+func TestFailedCyclesAreReported(t *testing.T) {
+	reporter := &fakeReporter{}
+	rec := NewReconciler(&fakeDockerClient{err: errors.New("docker down")}, &fakeTailscaleClient{}, Options{ErrorReporter: reporter})
+
+	if err := rec.Reconcile(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(reporter.fields) != 1 || reporter.fields[0]["trigger"] != TriggerForced {
+		t.Fatalf("reports = %v, want one with trigger %s", reporter.fields, TriggerForced)
+	}
+
+	// Failures of the initial cycle are left to Run, which reports them once
+	// its retries are done
+	if err := rec.reconcileFor(context.Background(), TriggerStartup); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(reporter.fields) != 1 {
+		t.Errorf("reports = %v, want the startup failure not reported", reporter.fields)
+	}
+}
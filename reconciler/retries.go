@@ -0,0 +1,49 @@
+package reconciler
+
+import (
+	"context"
+	"time"
+)
+
+// retryDelay is the wait before attempting a failed cycle again
+var retryDelay = 2 * time.Second
+
+// runAttempts runs a cycle and attempts a failed one again, after a short
+// delay, until the attempts of Options.Attempts are used up. The caller holds
+// the cycle, so requests made in the meantime are coalesced into its
+// follow-up cycle instead of overlapping the retries. A retry that the
+// minimum apply interval holds back is left to the cycle it schedules.
+func (r *Reconciler) runAttempts(ctx context.Context, trigger string) error {
+	err := r.runCycle(ctx, trigger)
+	for attempt := 2; err != nil && attempt <= r.attempts; attempt++ {
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Int("attempts", r.attempts).
+			Dur("retry_in", r.retryDelay).
+			Msg("Reconciliation failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(r.retryDelay):
+		}
+
+		r.mu.Lock()
+		deferred := r.deferApply()
+		r.mu.Unlock()
+		if deferred {
+			return err
+		}
+		err = r.runCycle(ctx, trigger)
+	}
+
+	// The initial cycle is retried, so Run reports it once the retries are done
+	if err != nil && trigger != TriggerStartup {
+		r.reportFailure(ctx, trigger, err)
+	}
+	return err
+}
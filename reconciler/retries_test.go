@@ -0,0 +1,54 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFailedCycleIsRetriedWithinBudget(t *testing.T) {
+	reporter := &fakeReporter{}
+	ts := &flakyTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{Attempts: 3, ErrorReporter: reporter})
+	r.retryDelay = 0
+
+	// A request made during the failed attempt waits for the retry
+	ts.onCall = func() {
+		if ts.calls == 0 {
+			if err := r.Reconcile(context.Background()); err != nil {
+				t.Errorf("coalesced Reconcile() error = %v", err)
+			}
+			if ts.calls != 0 {
+				t.Error("a request made during a cycle ran right away")
+			}
+		}
+	}
+
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v, want the retry to succeed", err)
+	}
+	// The failed attempt, its retry, and the coalesced follow-up cycle
+	if ts.calls != 3 {
+		t.Errorf("tailscale called %d times, want 3", ts.calls)
+	}
+	if len(reporter.fields) != 0 {
+		t.Errorf("reports = %v, want none for a failure the retry recovered from", reporter.fields)
+	}
+}
+
+func TestFailedCycleGivesUpAfterBudget(t *testing.T) {
+	reporter := &fakeReporter{}
+	ts := &fakeTailscaleClient{err: errors.New("tailscaled not running")}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{Attempts: 2, ErrorReporter: reporter})
+	r.retryDelay = 0
+
+	if err := r.Reconcile(context.Background()); err == nil {
+		t.Fatal("Reconcile() error = nil, want the last attempt's error")
+	}
+	if ts.calls != 2 {
+		t.Errorf("tailscale called %d times, want 2", ts.calls)
+	}
+	if len(reporter.fields) != 1 {
+		t.Errorf("reports = %v, want one once the attempts are used up", reporter.fields)
+	}
+}
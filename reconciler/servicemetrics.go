@@ -0,0 +1,201 @@
+package reconciler
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// ServiceMetricsRetention is how long the metrics of a service or container
+// that is no longer configured stay at 0 before they are deleted, so a graph
+// shows the withdrawal but removed services don't pile up
+const ServiceMetricsRetention = time.Hour
+
+// defaultServiceMetrics publishes the per-service metrics of the reconciler
+var defaultServiceMetrics = newServiceMetrics(
+	expvar.NewMap("docktail_service_up"),
+	expvar.NewMap("docktail_service_transitions_total"),
+)
+
+func init() {
+	expvar.Publish("docktail_service_seconds_since_change", expvar.Func(func() any {
+		return defaultServiceMetrics.secondsSinceChange(time.Now())
+	}))
+}
+
+// serviceMetrics tracks whether each service is configured, by the
+// containers backing it, and publishes it as expvar metrics. Containers are
+// tracked by name rather than ID, so recreating a container doesn't add a
+// series.
+type serviceMetrics struct {
+	// up maps each service to a map of its containers, each 1 while the
+	// container backs the service and 0 once it no longer does
+	up *expvar.Map
+	// transitions counts how often each service was added or withdrawn
+	transitions *expvar.Map
+	retention   time.Duration
+
+	mu       sync.Mutex
+	services map[string]*serviceState
+}
+
+// serviceState is what serviceMetrics knows about a service
+type serviceState struct {
+	up         bool
+	lastChange time.Time
+	// containers maps the containers of the service to when they stopped
+	// backing it, zero while they still do
+	containers map[string]time.Time
+}
+
+func newServiceMetrics(up, transitions *expvar.Map) *serviceMetrics {
+	return &serviceMetrics{
+		up:          up,
+		transitions: transitions,
+		retention:   ServiceMetricsRetention,
+		services:    make(map[string]*serviceState),
+	}
+}
+
+// serviceContainers returns the names of the containers backing each service
+// DockTail manages, such as {"svc:web": {"web-1": true}}. A container backs
+// its service only while its own endpoint is in applied, so containers whose
+// endpoint failed or was skipped don't count.
+func serviceContainers(containers []*apptypes.ContainerService, applied []string) map[string]map[string]bool {
+	configured := make(map[string]bool, len(applied))
+	for _, key := range applied {
+		configured[key] = true
+	}
+
+	services := make(map[string]map[string]bool)
+	for _, container := range containers {
+		if !container.ServiceEnabled || container.Preserve {
+			continue
+		}
+		if !configured["svc:"+container.ServiceName+":"+container.Port+container.Path] {
+			continue
+		}
+		serviceName := "svc:" + container.ServiceName
+		if services[serviceName] == nil {
+			services[serviceName] = make(map[string]bool)
+		}
+		services[serviceName][container.ContainerName] = true
+	}
+	return services
+}
+
+// updateServiceMetrics records the services configured after a cycle.
+// Dry-run and paused cycles change nothing, and a cycle that ended before it
+// knew what is configured tells nothing, so they leave the metrics alone.
+// Must be called with r.mu held.
+func (r *Reconciler) updateServiceMetrics(now time.Time, result *apptypes.ReconcileResult) {
+	if result.DryRun || result.Applied == nil {
+		return
+	}
+
+	changed := make(map[string]bool)
+	for _, keys := range [][]string{result.Added, result.Changed, result.Removed} {
+		for _, key := range keys {
+			changed[serviceOfKey(key)] = true
+		}
+	}
+	kept := make(map[string]bool)
+	for _, key := range result.Deferred {
+		kept[serviceOfKey(key)] = true
+	}
+	r.metrics.update(now, serviceContainers(r.cycleContainers, result.Applied), changed, kept)
+}
+
+// update records the services configured as of now, by the containers
+// backing them. Services in changed count as changed even if they stayed up;
+// services in kept, such as removals deferred by maintenance mode, stay as
+// they were.
+func (m *serviceMetrics) update(now time.Time, backing map[string]map[string]bool, changed, kept map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for serviceName, containers := range backing {
+		state := m.services[serviceName]
+		if state == nil {
+			state = &serviceState{containers: make(map[string]time.Time)}
+			m.services[serviceName] = state
+		}
+		if !state.up {
+			state.up = true
+			state.lastChange = now
+			m.transitions.Add(serviceName, 1)
+		} else if changed[serviceName] {
+			state.lastChange = now
+		}
+
+		for container := range containers {
+			state.containers[container] = time.Time{}
+			m.setUp(serviceName, container, 1)
+		}
+		for container, goneAt := range state.containers {
+			if containers[container] {
+				continue
+			}
+			switch {
+			case goneAt.IsZero():
+				state.containers[container] = now
+				m.setUp(serviceName, container, 0)
+			case now.Sub(goneAt) >= m.retention:
+				delete(state.containers, container)
+				if series, ok := m.up.Get(serviceName).(*expvar.Map); ok {
+					series.Delete(container)
+				}
+			}
+		}
+	}
+
+	for serviceName, state := range m.services {
+		if backing[serviceName] != nil || kept[serviceName] {
+			continue
+		}
+		if state.up {
+			state.up = false
+			state.lastChange = now
+			m.transitions.Add(serviceName, 1)
+			for container, goneAt := range state.containers {
+				if goneAt.IsZero() {
+					state.containers[container] = now
+					m.setUp(serviceName, container, 0)
+				}
+			}
+			continue
+		}
+		if now.Sub(state.lastChange) >= m.retention {
+			delete(m.services, serviceName)
+			m.up.Delete(serviceName)
+			m.transitions.Delete(serviceName)
+		}
+	}
+}
+
+// setUp sets the up gauge of a container of a service
+func (m *serviceMetrics) setUp(serviceName, container string, value int64) {
+	series, ok := m.up.Get(serviceName).(*expvar.Map)
+	if !ok {
+		series = new(expvar.Map)
+		m.up.Set(serviceName, series)
+	}
+	gauge := new(expvar.Int)
+	gauge.Set(value)
+	series.Set(container, gauge)
+}
+
+// secondsSinceChange returns how long ago each tracked service was added,
+// withdrawn or changed, in seconds
+func (m *serviceMetrics) secondsSinceChange(now time.Time) map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := make(map[string]int64, len(m.services))
+	for serviceName, state := range m.services {
+		seconds[serviceName] = int64(now.Sub(state.lastChange) / time.Second)
+	}
+	return seconds
+}
@@ -0,0 +1,163 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// gauge returns the up gauge of a container of a service, or -1 when it is
+// not published
+func gauge(m *serviceMetrics, serviceName, container string) int64 {
+	series, ok := m.up.Get(serviceName).(*expvar.Map)
+	if !ok {
+		return -1
+	}
+	value, ok := series.Get(container).(*expvar.Int)
+	if !ok {
+		return -1
+	}
+	return value.Value()
+}
+
+// transitions returns the transitions of a service, or -1 when it is not published
+func transitions(m *serviceMetrics, serviceName string) int64 {
+	value, ok := m.transitions.Get(serviceName).(*expvar.Int)
+	if !ok {
+		return -1
+	}
+	return value.Value()
+}
+
+func TestServiceMetricsLifecycle(t *testing.T) {
+	m := newServiceMetrics(new(expvar.Map), new(expvar.Map))
+	t0 := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	both := map[string]map[string]bool{
+		"svc:gitea": {"gitea": true},
+		"svc:web":   {"web-1": true, "web-2": true},
+	}
+
+	m.update(t0, both, nil, nil)
+	if gauge(m, "svc:gitea", "gitea") != 1 || gauge(m, "svc:web", "web-2") != 1 || transitions(m, "svc:gitea") != 1 {
+		t.Fatal("added services should be up with one transition")
+	}
+
+	// A cycle without changes leaves everything alone
+	m.update(t0.Add(time.Minute), both, nil, nil)
+	if transitions(m, "svc:gitea") != 1 {
+		t.Errorf("transitions = %d after a no-op cycle, want 1", transitions(m, "svc:gitea"))
+	}
+	if got := m.secondsSinceChange(t0.Add(2 * time.Minute))["svc:gitea"]; got != 120 {
+		t.Errorf("seconds since change = %d, want 120", got)
+	}
+
+	// Withdrawing gitea and a replica of web sets their gauges to 0
+	withdrawn := map[string]map[string]bool{"svc:web": {"web-1": true}}
+	m.update(t0.Add(10*time.Minute), withdrawn, nil, nil)
+	if gauge(m, "svc:gitea", "gitea") != 0 || gauge(m, "svc:web", "web-2") != 0 || gauge(m, "svc:web", "web-1") != 1 {
+		t.Error("withdrawn services and containers should be 0, the rest 1")
+	}
+	if transitions(m, "svc:gitea") != 2 || transitions(m, "svc:web") != 1 {
+		t.Errorf("transitions = %d, %d, want 2 for gitea and 1 for web", transitions(m, "svc:gitea"), transitions(m, "svc:web"))
+	}
+	if got := m.secondsSinceChange(t0.Add(11 * time.Minute))["svc:gitea"]; got != 60 {
+		t.Errorf("seconds since change = %d, want 60", got)
+	}
+
+	// Flapping back counts another transition
+	m.update(t0.Add(20*time.Minute), both, nil, nil)
+	m.update(t0.Add(21*time.Minute), withdrawn, nil, nil)
+	if transitions(m, "svc:gitea") != 4 {
+		t.Errorf("transitions = %d after flapping, want 4", transitions(m, "svc:gitea"))
+	}
+
+	// Deferred removals keep the service up
+	m.update(t0.Add(22*time.Minute), map[string]map[string]bool{}, nil, map[string]bool{"svc:web": true})
+	if gauge(m, "svc:web", "web-1") != 1 {
+		t.Error("a service whose removal was deferred should stay up")
+	}
+
+	// Withdrawn services and containers are deleted after the retention window
+	m.update(t0.Add(22*time.Minute+ServiceMetricsRetention), withdrawn, nil, nil)
+	if gauge(m, "svc:gitea", "gitea") != -1 || transitions(m, "svc:gitea") != -1 {
+		t.Error("metrics of svc:gitea should be deleted after the retention window")
+	}
+	if _, ok := m.secondsSinceChange(t0)["svc:gitea"]; ok {
+		t.Error("seconds since change of svc:gitea should be deleted after the retention window")
+	}
+	if gauge(m, "svc:web", "web-2") != -1 || gauge(m, "svc:web", "web-1") != 1 {
+		t.Error("web-2 should be deleted after the retention window and web-1 kept")
+	}
+}
+
+func TestReconcileUpdatesServiceMetrics(t *testing.T) {
+	docker := &fakeDockerClient{containers: []*apptypes.ContainerService{
+		{ContainerName: "gitea", ServiceEnabled: true, ServiceName: "gitea", Port: "443"},
+	}}
+	rec := NewReconciler(docker, &fakeTailscaleClient{}, Options{})
+	rec.metrics = newServiceMetrics(new(expvar.Map), new(expvar.Map))
+
+	// Paused cycles apply nothing, so they don't count
+	rec.Pause()
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if gauge(rec.metrics, "svc:gitea", "gitea") != -1 {
+		t.Error("a paused cycle should not publish metrics")
+	}
+	rec.Resume()
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if gauge(rec.metrics, "svc:gitea", "gitea") != 1 {
+		t.Error("svc:gitea should be up after a cycle")
+	}
+
+	docker.containers = nil
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if gauge(rec.metrics, "svc:gitea", "gitea") != 0 || transitions(rec.metrics, "svc:gitea") != 2 {
+		t.Error("svc:gitea should be down with two transitions after its container is gone")
+	}
+}
+
+func TestServiceMetricsCountOnlyAppliedServices(t *testing.T) {
+	docker := &fakeDockerClient{containers: []*apptypes.ContainerService{
+		{ContainerName: "gitea", ServiceEnabled: true, ServiceName: "gitea", Port: "443"},
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443"},
+	}}
+	ts := &fakeTailscaleClient{}
+	rec := NewReconciler(docker, ts, Options{})
+	rec.metrics = newServiceMetrics(new(expvar.Map), new(expvar.Map))
+
+	// A service that failed to apply isn't up
+	ts.result = &apptypes.ReconcileResult{Applied: []string{"svc:gitea:443"}}
+	ts.err = errors.New("failed to add 1 services")
+	rec.Reconcile(context.Background())
+	if gauge(rec.metrics, "svc:gitea", "gitea") != 1 {
+		t.Error("svc:gitea should be up after it was applied")
+	}
+	if got := gauge(rec.metrics, "svc:web", "web"); got == 1 {
+		t.Error("svc:web should not be up after it failed to apply")
+	}
+
+	// A cycle that fails before discovering containers leaves the metrics
+	// as they were rather than reusing the previous cycle's containers
+	ts.result, ts.err = nil, nil
+	rec.Reconcile(context.Background())
+	if gauge(rec.metrics, "svc:web", "web") != 1 {
+		t.Fatal("svc:web should be up once it was applied")
+	}
+	docker.err = errors.New("docker unavailable")
+	docker.containers = nil
+	rec.Reconcile(context.Background())
+	if gauge(rec.metrics, "svc:gitea", "gitea") != 1 || gauge(rec.metrics, "svc:web", "web") != 1 {
+		t.Error("a cycle that couldn't list containers should leave the metrics alone")
+	}
+}
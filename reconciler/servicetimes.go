@@ -0,0 +1,140 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marvinvr/docktail/atomicfile"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// serviceTimesFile is the file (inside the state directory) recording when
+// each service was first advertised and last changed
+const serviceTimesFile = "service-times.json"
+
+// serviceLastChangeVar publishes the unix time each service last changed
+var serviceLastChangeVar = expvar.NewMap("docktail_service_last_change_timestamp")
+
+// ServiceTimes records when a service was first advertised and when its
+// configuration last changed. Services already configured when DockTail first
+// saw them count from that moment.
+type ServiceTimes struct {
+	FirstAdvertised time.Time `json:"first_advertised"`
+	LastChanged     time.Time `json:"last_changed"`
+}
+
+// desiredServiceNames returns the services DockTail manages for containers,
+// such as "svc:web"
+func desiredServiceNames(containers []*apptypes.ContainerService) map[string]bool {
+	names := make(map[string]bool)
+	for _, container := range containers {
+		if container.ServiceEnabled && !container.Preserve {
+			names["svc:"+container.ServiceName] = true
+		}
+	}
+	return names
+}
+
+// serviceOfKey returns the service of a result key, "svc:web" for both
+// "svc:web:443/grafana" and "svc:web"
+func serviceOfKey(key string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(key, "svc:"), ":")
+	return "svc:" + name
+}
+
+// updateServiceTimes records the services a cycle added, changed or removed.
+// Dry-run and paused cycles change nothing, so they leave the times alone.
+// Must be called with r.mu held.
+func (r *Reconciler) updateServiceTimes(now time.Time, result *apptypes.ReconcileResult) {
+	if result.DryRun {
+		return
+	}
+
+	changed := make(map[string]bool)
+	for _, keys := range [][]string{result.Added, result.Changed, result.Removed} {
+		for _, key := range keys {
+			changed[serviceOfKey(key)] = true
+		}
+	}
+
+	updated := false
+	for serviceName := range r.desiredServices {
+		times, ok := r.serviceTimes[serviceName]
+		switch {
+		case !ok:
+			times = ServiceTimes{FirstAdvertised: now, LastChanged: now}
+		case changed[serviceName]:
+			times.LastChanged = now
+		default:
+			continue
+		}
+		r.serviceTimes[serviceName] = times
+		serviceLastChangeVar.Set(serviceName, unixTime(now))
+		updated = true
+	}
+	for serviceName := range r.serviceTimes {
+		if !r.desiredServices[serviceName] {
+			delete(r.serviceTimes, serviceName)
+			serviceLastChangeVar.Delete(serviceName)
+			updated = true
+		}
+	}
+
+	if updated {
+		r.saveServiceTimes()
+	}
+}
+
+// unixTime returns t as an expvar value in unix seconds
+func unixTime(t time.Time) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(t.Unix())
+	return v
+}
+
+// loadServiceTimes reads the times recorded by a previous run from the state
+// directory, so a restart doesn't reset them
+func (r *Reconciler) loadServiceTimes() {
+	r.serviceTimes = make(map[string]ServiceTimes)
+	if r.stateDir == "" {
+		return
+	}
+
+	path := filepath.Join(r.stateDir, serviceTimesFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to read service times")
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &r.serviceTimes); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to parse service times")
+		r.serviceTimes = make(map[string]ServiceTimes)
+		return
+	}
+	for serviceName, times := range r.serviceTimes {
+		serviceLastChangeVar.Set(serviceName, unixTime(times.LastChanged))
+	}
+}
+
+// saveServiceTimes writes the service times to the state directory
+func (r *Reconciler) saveServiceTimes() {
+	if r.stateDir == "" {
+		return
+	}
+
+	data, err := json.Marshal(r.serviceTimes)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(r.stateDir, serviceTimesFile)
+	if err := atomicfile.Write(path, data); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to record service times")
+	}
+}
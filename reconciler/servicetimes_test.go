@@ -0,0 +1,71 @@
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestServiceTimesUpdateOnlyOnChange(t *testing.T) {
+	stateDir := t.TempDir()
+	docker := &fakeDockerClient{containers: []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443"},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443"},
+	}}
+	ts := &fakeTailscaleClient{result: &apptypes.ReconcileResult{Added: []string{"svc:web:443", "svc:api:443"}}}
+	rec := NewReconciler(docker, ts, Options{StateDir: stateDir})
+
+	reconcile := func() map[string]ServiceTimes {
+		t.Helper()
+		// Cycles start at least a clock tick apart, so an update shows
+		time.Sleep(time.Millisecond)
+		if err := rec.Reconcile(context.Background()); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		return rec.State().Services
+	}
+
+	first := reconcile()
+	web := first["svc:web"]
+	if web.FirstAdvertised.IsZero() || !web.LastChanged.Equal(web.FirstAdvertised) {
+		t.Fatalf("svc:web times = %+v, want both set to the first cycle", web)
+	}
+
+	// A cycle without changes leaves the times alone
+	ts.result = &apptypes.ReconcileResult{}
+	if got := reconcile(); got["svc:web"] != web || got["svc:api"] != first["svc:api"] {
+		t.Errorf("times after a no-op cycle = %+v, want %+v", got, first)
+	}
+
+	// A dry-run cycle changes nothing either
+	ts.result = &apptypes.ReconcileResult{Changed: []string{"svc:web:443"}}
+	rec.Pause()
+	if got := reconcile(); got["svc:web"] != web {
+		t.Errorf("svc:web times after a paused cycle = %+v, want %+v", got["svc:web"], web)
+	}
+	rec.Resume()
+
+	// A change moves LastChanged of that service only
+	got := reconcile()
+	if !got["svc:web"].FirstAdvertised.Equal(web.FirstAdvertised) || !got["svc:web"].LastChanged.After(web.LastChanged) {
+		t.Errorf("svc:web times after a change = %+v, want LastChanged after %s", got["svc:web"], web.LastChanged)
+	}
+	if got["svc:api"] != first["svc:api"] {
+		t.Errorf("svc:api times = %+v, want %+v", got["svc:api"], first["svc:api"])
+	}
+
+	// Times survive a restart through the state directory
+	restarted := NewReconciler(docker, &fakeTailscaleClient{}, Options{StateDir: stateDir})
+	if times := restarted.State().Services["svc:web"]; !times.LastChanged.Equal(got["svc:web"].LastChanged) {
+		t.Errorf("svc:web times after restart = %+v, want %+v", times, got["svc:web"])
+	}
+
+	// Removed services are forgotten
+	docker.containers = docker.containers[:1]
+	ts.result = &apptypes.ReconcileResult{Removed: []string{"svc:api:443"}}
+	if got := reconcile(); len(got) != 1 || got["svc:web"].FirstAdvertised.IsZero() {
+		t.Errorf("times after removing svc:api = %+v, want only svc:web", got)
+	}
+}
@@ -0,0 +1,91 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/marvinvr/docktail/atomicfile"
+)
+
+// shadowFile is the file (inside the state directory) counting down the
+// shadow cycles, so restarts don't start them over
+const shadowFile = "shadow-cycles.json"
+
+// shadowState is the content of shadowFile
+type shadowState struct {
+	// Configured is the SHADOW_CYCLES the countdown started from
+	Configured int `json:"configured"`
+	// Remaining is how many shadow cycles are left
+	Remaining int `json:"remaining"`
+}
+
+// loadShadowCycles sets how many shadow cycles are left out of configured,
+// carrying on with the countdown of a previous run. Changing the number of
+// shadow cycles starts the countdown over.
+func (r *Reconciler) loadShadowCycles(configured int) {
+	r.shadowConfigured = configured
+	r.shadowLeft = configured
+	if configured <= 0 {
+		r.shadowLeft = 0
+		return
+	}
+	if r.stateDir == "" {
+		log.Warn().Msg("STATE_DIR is not set, so a restart starts the shadow cycles over")
+		return
+	}
+
+	path := filepath.Join(r.stateDir, shadowFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to read shadow cycles")
+		}
+		return
+	}
+	var state shadowState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to parse shadow cycles")
+		return
+	}
+	if state.Configured == configured && state.Remaining >= 0 && state.Remaining < configured {
+		r.shadowLeft = state.Remaining
+	}
+}
+
+// countShadowCycle counts down a successful shadow cycle, switching to
+// applying changes after the last one. Must be called with r.mu held.
+func (r *Reconciler) countShadowCycle() {
+	r.shadowLeft--
+	r.saveShadowCycles()
+	if r.shadowLeft > 0 {
+		log.Info().Int("shadow_cycles_left", r.shadowLeft).Msg("Shadow cycle done, no changes applied")
+		return
+	}
+	log.Info().Int("shadow_cycles", r.shadowConfigured).Msg("Shadow cycles done, applying changes from the next cycle on")
+}
+
+// saveShadowCycles writes the shadow countdown to the state directory
+func (r *Reconciler) saveShadowCycles() {
+	if r.stateDir == "" {
+		return
+	}
+
+	data, err := json.Marshal(shadowState{Configured: r.shadowConfigured, Remaining: r.shadowLeft})
+	if err != nil {
+		return
+	}
+	path := filepath.Join(r.stateDir, shadowFile)
+	if err := atomicfile.Write(path, data); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to record shadow cycles")
+	}
+}
+
+// Shadowing reports whether the reconciler is still in its shadow cycles,
+// computing and logging changes without applying them
+func (r *Reconciler) Shadowing() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.shadowLeft > 0
+}
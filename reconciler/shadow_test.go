@@ -0,0 +1,69 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestShadowCyclesHoldBackChanges(t *testing.T) {
+	stateDir := t.TempDir()
+	ts := &fakeTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{StateDir: stateDir, ShadowCycles: 3})
+
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !ts.lastOpts.DryRun || !r.Shadowing() || r.State().ShadowCyclesLeft != 2 {
+		t.Fatalf("shadow cycle DryRun = %v, shadow cycles left = %d, want a dry run with 2 left", ts.lastOpts.DryRun, r.State().ShadowCyclesLeft)
+	}
+
+	// Failed cycles showed nothing, so they don't count
+	ts.err = errors.New("tailscaled unreachable")
+	_ = r.Reconcile(context.Background())
+	if !ts.lastOpts.DryRun || r.State().ShadowCyclesLeft != 2 {
+		t.Errorf("failed shadow cycle DryRun = %v, shadow cycles left = %d, want a dry run with 2 left", ts.lastOpts.DryRun, r.State().ShadowCyclesLeft)
+	}
+	ts.err = nil
+
+	// A restart carries on with the countdown
+	restarted := NewReconciler(&fakeDockerClient{}, ts, Options{StateDir: stateDir, ShadowCycles: 3})
+	if left := restarted.State().ShadowCyclesLeft; left != 2 {
+		t.Fatalf("shadow cycles left after a restart = %d, want 2", left)
+	}
+	for range 2 {
+		if err := restarted.Reconcile(context.Background()); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if !ts.lastOpts.DryRun {
+			t.Error("shadow cycle was not a dry run")
+		}
+	}
+
+	// Changes are applied once the shadow cycles are done, also after a restart
+	if err := restarted.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ts.lastOpts.DryRun || restarted.Shadowing() {
+		t.Errorf("cycle after the shadow cycles DryRun = %v, shadowing = %v, want both cleared", ts.lastOpts.DryRun, restarted.Shadowing())
+	}
+	if NewReconciler(&fakeDockerClient{}, ts, Options{StateDir: stateDir, ShadowCycles: 3}).Shadowing() {
+		t.Error("restart after the shadow cycles went back to shadow mode")
+	}
+
+	// Changing the number of shadow cycles starts the countdown over
+	if left := NewReconciler(&fakeDockerClient{}, ts, Options{StateDir: stateDir, ShadowCycles: 5}).State().ShadowCyclesLeft; left != 5 {
+		t.Errorf("shadow cycles left with a new SHADOW_CYCLES = %d, want 5", left)
+	}
+}
+
+func TestNoShadowCycles(t *testing.T) {
+	ts := &fakeTailscaleClient{}
+	r := NewReconciler(&fakeDockerClient{}, ts, Options{StateDir: t.TempDir()})
+	if err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ts.lastOpts.DryRun || r.Shadowing() {
+		t.Errorf("DryRun = %v, shadowing = %v, want both cleared without shadow cycles", ts.lastOpts.DryRun, r.Shadowing())
+	}
+}
@@ -0,0 +1,75 @@
+package reconciler
+
+import (
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// sighting is when the reconciler first saw a container, since its last start
+type sighting struct {
+	startedAt time.Time
+	firstSeen time.Time
+}
+
+// holdStartingContainers leaves out the services and funnels of containers
+// whose docktail.service.startup-delay hasn't passed since they were first
+// seen, and schedules a reconciliation for when the next one has. A container
+// counts as seen from when it started if that is earlier, so a restart of
+// DockTail doesn't hold back containers that have been running all along,
+// while a restart of the container starts the delay over.
+func (r *Reconciler) holdStartingContainers(now time.Time, containers []*apptypes.ContainerService) []*apptypes.ContainerService {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	running := make(map[string]bool, len(containers))
+	held := make(map[string]bool)
+	kept := make([]*apptypes.ContainerService, 0, len(containers))
+	var next time.Time
+	for _, container := range containers {
+		if container.StartupDelay <= 0 || container.Preserve || container.ContainerID == "" {
+			kept = append(kept, container)
+			continue
+		}
+
+		running[container.ContainerID] = true
+		seen, ok := r.sightings[container.ContainerID]
+		if !ok || !seen.startedAt.Equal(container.StartedAt) {
+			seen = sighting{startedAt: container.StartedAt, firstSeen: now}
+			if !container.StartedAt.IsZero() && container.StartedAt.Before(now) {
+				seen.firstSeen = container.StartedAt
+			}
+			r.sightings[container.ContainerID] = seen
+		}
+
+		readyAt := seen.firstSeen.Add(container.StartupDelay)
+		if !now.Before(readyAt) {
+			kept = append(kept, container)
+			continue
+		}
+		if !held[container.ContainerID] {
+			held[container.ContainerID] = true
+			log.Info().
+				Str("container", container.ContainerName).
+				Dur("ready_in", readyAt.Sub(now)).
+				Msg("Container is within its startup delay, not serving it yet")
+		}
+		if next.IsZero() || readyAt.Before(next) {
+			next = readyAt
+		}
+	}
+
+	for containerID := range r.sightings {
+		if !running[containerID] {
+			delete(r.sightings, containerID)
+		}
+	}
+
+	if !next.IsZero() {
+		if r.startupTimer != nil {
+			r.startupTimer.Stop()
+		}
+		r.startupTimer = time.AfterFunc(next.Sub(now), r.TriggerReconcile)
+	}
+	return kept
+}
@@ -0,0 +1,84 @@
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestStartupDelayHoldsBackServices(t *testing.T) {
+	started := time.Now()
+	docker := &fakeDockerClient{containers: []*apptypes.ContainerService{
+		{ContainerID: "aaaaaaaaaaaa", ContainerName: "slow", ServiceEnabled: true, ServiceName: "slow", Port: "443", StartedAt: started, StartupDelay: time.Hour},
+		{ContainerID: "bbbbbbbbbbbb", ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", StartedAt: started},
+	}}
+	ts := &fakeTailscaleClient{}
+	rec := NewReconciler(docker, ts, Options{})
+
+	if err := rec.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(ts.desired) != 1 || ts.desired[0].ContainerName != "web" {
+		t.Fatalf("desired = %v, want only web while slow waits out its delay", ts.desired)
+	}
+	if rec.startupTimer == nil {
+		t.Error("expected a reconciliation to be scheduled for the end of the delay")
+	} else {
+		rec.startupTimer.Stop()
+	}
+
+	// Still held just before the delay ends, served once it has
+	if kept := rec.holdStartingContainers(started.Add(time.Hour-time.Second), docker.containers); len(kept) != 1 {
+		t.Errorf("kept %d containers a second before the delay ends, want 1", len(kept))
+	}
+	rec.startupTimer.Stop()
+	if kept := rec.holdStartingContainers(started.Add(time.Hour), docker.containers); len(kept) != 2 {
+		t.Errorf("kept %d containers once the delay ended, want 2", len(kept))
+	}
+}
+
+func TestStartupDelayCountsFromFirstSighting(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec := NewReconciler(&fakeDockerClient{}, &fakeTailscaleClient{}, Options{})
+	t.Cleanup(func() {
+		if rec.startupTimer != nil {
+			rec.startupTimer.Stop()
+		}
+	})
+
+	container := &apptypes.ContainerService{ContainerID: "aaaaaaaaaaaa", ContainerName: "slow", ServiceEnabled: true, ServiceName: "slow", StartupDelay: 30 * time.Second}
+	containers := []*apptypes.ContainerService{container}
+
+	// Without a start time, the delay counts from when the container is first seen
+	if kept := rec.holdStartingContainers(now, containers); len(kept) != 0 {
+		t.Fatalf("kept %v when first seen, want nothing", kept)
+	}
+	if kept := rec.holdStartingContainers(now.Add(20*time.Second), containers); len(kept) != 0 {
+		t.Errorf("kept %v 20s after first seen, want nothing", kept)
+	}
+	if kept := rec.holdStartingContainers(now.Add(30*time.Second), containers); len(kept) != 1 {
+		t.Errorf("kept %v 30s after first seen, want the container", kept)
+	}
+
+	// A container that has been running since before it was first seen is
+	// served right away, as after a restart of DockTail
+	longRunning := &apptypes.ContainerService{ContainerID: "bbbbbbbbbbbb", ContainerName: "old", ServiceEnabled: true, ServiceName: "old", StartupDelay: 30 * time.Second, StartedAt: now.Add(-time.Hour)}
+	if kept := rec.holdStartingContainers(now, []*apptypes.ContainerService{longRunning}); len(kept) != 1 {
+		t.Errorf("kept %v, want the long running container", kept)
+	}
+
+	// Restarting the container starts the delay over
+	restarted := *longRunning
+	restarted.StartedAt = now.Add(time.Minute)
+	if kept := rec.holdStartingContainers(now.Add(time.Minute), []*apptypes.ContainerService{&restarted}); len(kept) != 0 {
+		t.Errorf("kept %v right after a restart, want nothing", kept)
+	}
+
+	// Containers that are gone are forgotten
+	rec.holdStartingContainers(now, nil)
+	if len(rec.sightings) != 0 {
+		t.Errorf("sightings = %v, want none once the containers are gone", rec.sightings)
+	}
+}
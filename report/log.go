@@ -0,0 +1,16 @@
+package report
+
+import (
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+)
+
+// log is the logger of the package: zerolog's global logger, unless SetLogger
+// replaced it
+var log = &zlog.Logger
+
+// SetLogger makes the package log to logger instead of zerolog's global
+// logger, for programs embedding DockTail. Call it before using the package.
+func SetLogger(logger zerolog.Logger) {
+	log = &logger
+}
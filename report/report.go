@@ -0,0 +1,112 @@
+// Package report sends failures DockTail can't recover from on its own to an
+// error tracker, with the context of the service or container involved.
+package report
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of RateLimit
+const (
+	// DefaultWindow is how long repeats of the same error are held back
+	DefaultWindow = 10 * time.Minute
+	// DefaultMaxPerWindow caps the reports of all errors within a window
+	DefaultMaxPerWindow = 20
+)
+
+// Reporter receives failures that need attention, such as a service that
+// can't be configured, along with the fields DockTail logs them with
+// (container, service, key, ...). Report must not block for long, since it
+// is called during reconciliation.
+type Reporter interface {
+	Report(ctx context.Context, err error, fields map[string]string)
+}
+
+// Nop is a Reporter that discards every report
+type Nop struct{}
+
+// Report discards the report
+func (Nop) Report(context.Context, error, map[string]string) {}
+
+// OrNop returns r, or Nop when r is nil, so callers don't check for nil
+func OrNop(r Reporter) Reporter {
+	if r == nil {
+		return Nop{}
+	}
+	return r
+}
+
+// RateLimited passes reports on to a Reporter, holding back repeats of the
+// same error with the same fields within a window and capping the reports
+// of all errors within a window, so a failure repeated every cycle doesn't
+// flood the error tracker
+type RateLimited struct {
+	next   Reporter
+	window time.Duration
+	max    int
+	now    func() time.Time
+
+	mu          sync.Mutex
+	reported    map[string]time.Time // last report of each error
+	windowStart time.Time
+	count       int // reports since windowStart
+}
+
+// RateLimit wraps next so each distinct error is reported at most once per
+// window, and at most max errors are reported per window
+func RateLimit(next Reporter, window time.Duration, max int) *RateLimited {
+	return &RateLimited{next: next, window: window, max: max, now: time.Now, reported: make(map[string]time.Time)}
+}
+
+// Report passes the report on unless it is rate-limited
+func (r *RateLimited) Report(ctx context.Context, err error, fields map[string]string) {
+	if err == nil || !r.allow(reportKey(err, fields)) {
+		return
+	}
+	r.next.Report(ctx, err, fields)
+}
+
+// allow records a report of the error with key and reports whether it may be sent
+func (r *RateLimited) allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.windowStart) >= r.window {
+		r.windowStart, r.count = now, 0
+		for k, at := range r.reported {
+			if now.Sub(at) >= r.window {
+				delete(r.reported, k)
+			}
+		}
+	}
+	if at, ok := r.reported[key]; ok && now.Sub(at) < r.window {
+		return false
+	}
+	if r.count >= r.max {
+		return false
+	}
+	r.reported[key] = now
+	r.count++
+	return true
+}
+
+// reportKey identifies an error and the fields it is reported with
+func reportKey(err error, fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(err.Error())
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + fields[k])
+	}
+	return b.String()
+}
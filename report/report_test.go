@@ -0,0 +1,71 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recorder is a Reporter keeping the errors it receives
+type recorder struct {
+	errs []string
+}
+
+func (r *recorder) Report(_ context.Context, err error, _ map[string]string) {
+	r.errs = append(r.errs, err.Error())
+}
+
+func TestRateLimitHoldsBackRepeats(t *testing.T) {
+	next := &recorder{}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	limited := RateLimit(next, time.Minute, 10)
+	limited.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	web := map[string]string{"service": "svc:web"}
+	limited.Report(ctx, errors.New("boom"), web)
+	limited.Report(ctx, errors.New("boom"), map[string]string{"service": "svc:web"})
+	limited.Report(ctx, errors.New("boom"), map[string]string{"service": "svc:api"})
+	limited.Report(ctx, nil, web)
+	if len(next.errs) != 2 {
+		t.Fatalf("reported %d errors, want 2 (repeat held back): %v", len(next.errs), next.errs)
+	}
+
+	now = now.Add(time.Minute)
+	limited.Report(ctx, errors.New("boom"), web)
+	if len(next.errs) != 3 {
+		t.Errorf("reported %d errors, want the repeat reported again after the window", len(next.errs))
+	}
+}
+
+func TestRateLimitCapsReportsPerWindow(t *testing.T) {
+	next := &recorder{}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	limited := RateLimit(next, time.Minute, 2)
+	limited.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for _, msg := range []string{"a", "b", "c"} {
+		limited.Report(ctx, errors.New(msg), nil)
+	}
+	if len(next.errs) != 2 {
+		t.Fatalf("reported %d errors, want 2", len(next.errs))
+	}
+
+	now = now.Add(time.Minute)
+	limited.Report(ctx, errors.New("c"), nil)
+	if len(next.errs) != 3 {
+		t.Errorf("reported %d errors, want a report in the next window", len(next.errs))
+	}
+}
+
+func TestOrNop(t *testing.T) {
+	if _, ok := OrNop(nil).(Nop); !ok {
+		t.Error("OrNop(nil) should return Nop")
+	}
+	r := &recorder{}
+	if OrNop(r) != Reporter(r) {
+		t.Error("OrNop(r) should return r")
+	}
+}
@@ -0,0 +1,176 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marvinvr/docktail/version"
+)
+
+// sentryTimeout bounds the delivery of a single event
+const sentryTimeout = 10 * time.Second
+
+// sentryQueueSize is how many events may wait for delivery before new ones
+// are dropped
+const sentryQueueSize = 16
+
+// Sentry is a Reporter sending events to the store endpoint of a Sentry
+// compatible error tracker, such as Sentry itself or GlitchTip. Events are
+// delivered in the background; Close waits for the queued ones.
+type Sentry struct {
+	endpoint string
+	auth     string
+	client   *http.Client
+	hostname string
+
+	queue chan sentryEvent
+	done  sync.WaitGroup
+}
+
+// sentryEvent is the subset of the Sentry event payload DockTail fills in
+type sentryEvent struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  string            `json:"timestamp"`
+	Level      string            `json:"level"`
+	Logger     string            `json:"logger"`
+	Platform   string            `json:"platform"`
+	Release    string            `json:"release,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	Message    string            `json:"message"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// NewSentry returns a Reporter for a DSN of the form
+// https://<public key>@<host>/<project id>
+func NewSentry(dsn string) (*Sentry, error) {
+	endpoint, key, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	s := &Sentry{
+		endpoint: endpoint,
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=docktail/%s, sentry_key=%s", version.Get().Version, key),
+		client:   &http.Client{Timeout: sentryTimeout},
+		hostname: hostname,
+		queue:    make(chan sentryEvent, sentryQueueSize),
+	}
+	s.done.Add(1)
+	go s.deliver()
+	return s, nil
+}
+
+// ValidateDSN returns an error if dsn is not a DSN NewSentry accepts
+func ValidateDSN(dsn string) error {
+	_, _, err := parseDSN(dsn)
+	return err
+}
+
+// parseDSN returns the store endpoint and public key of a DSN
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSpace(dsn))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid DSN: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", fmt.Errorf("invalid DSN: scheme must be http or https")
+	}
+	key := u.User.Username()
+	if key == "" {
+		return "", "", fmt.Errorf("invalid DSN: missing public key before @")
+	}
+	path := strings.Trim(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return "", "", fmt.Errorf("invalid DSN: missing project ID")
+	}
+
+	// Sentry may be served below a path, which precedes /api/
+	prefix := ""
+	if slash >= 0 {
+		prefix = "/" + path[:slash]
+	}
+	return fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, projectID), key, nil
+}
+
+// Report queues an event for err, tagged with fields. It drops the event when
+// the queue is full rather than holding up reconciliation.
+func (s *Sentry) Report(_ context.Context, err error, fields map[string]string) {
+	if err == nil {
+		return
+	}
+	event := sentryEvent{
+		EventID:    newEventID(),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Level:      "error",
+		Logger:     "docktail",
+		Platform:   "go",
+		Release:    version.Get().Version,
+		ServerName: s.hostname,
+		Message:    err.Error(),
+		Tags:       fields,
+	}
+
+	select {
+	case s.queue <- event:
+	default:
+		log.Warn().Str("error", event.Message).Msg("Error report queue is full, dropping report")
+	}
+}
+
+// Close delivers the queued events and stops the reporter
+func (s *Sentry) Close() {
+	close(s.queue)
+	s.done.Wait()
+}
+
+func (s *Sentry) deliver() {
+	defer s.done.Done()
+	for event := range s.queue {
+		if err := s.send(event); err != nil {
+			log.Warn().Err(err).Str("error", event.Message).Msg("Failed to send error report")
+		}
+	}
+}
+
+func (s *Sentry) send(event sentryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("error tracker answered %s", resp.Status)
+	}
+	return nil
+}
+
+// newEventID returns a random event ID, 32 hex digits
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		endpoint string
+		key      string
+		wantErr  bool
+	}{
+		{dsn: "https://abc@sentry.example.com/42", endpoint: "https://sentry.example.com/api/42/store/", key: "abc"},
+		{dsn: "http://abc@glitchtip:8000/sub/path/7", endpoint: "http://glitchtip:8000/sub/path/api/7/store/", key: "abc"},
+		{dsn: "https://sentry.example.com/42", wantErr: true},
+		{dsn: "https://abc@sentry.example.com/", wantErr: true},
+		{dsn: "ftp://abc@sentry.example.com/42", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			endpoint, key, err := parseDSN(tt.dsn)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseDSN(%q) error = nil, want an error", tt.dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDSN(%q) error = %v", tt.dsn, err)
+			}
+			if endpoint != tt.endpoint || key != tt.key {
+				t.Errorf("parseDSN(%q) = %q, %q, want %q, %q", tt.dsn, endpoint, key, tt.endpoint, tt.key)
+			}
+		})
+	}
+}
+
+func TestSentrySendsEvents(t *testing.T) {
+	var (
+		auth  string
+		event sentryEvent
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("X-Sentry-Auth")
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "://", "://public@", 1) + "/42"
+	sentry, err := NewSentry(dsn)
+	if err != nil {
+		t.Fatalf("NewSentry() error = %v", err)
+	}
+	sentry.Report(context.Background(), errors.New("add failed"), map[string]string{"service": "svc:web"})
+	sentry.Close()
+
+	if !strings.Contains(auth, "sentry_key=public") {
+		t.Errorf("X-Sentry-Auth = %q, want the public key", auth)
+	}
+	if event.Message != "add failed" || event.Tags["service"] != "svc:web" || len(event.EventID) != 32 {
+		t.Errorf("event = %+v, want the error tagged with its fields", event)
+	}
+}
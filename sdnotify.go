@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// notifier sends readiness, status and watchdog notifications to systemd
+// when DockTail runs as a Type=notify service. A nil notifier, which
+// newNotifier returns when NOTIFY_SOCKET is not set, does nothing.
+type notifier struct {
+	addr *net.UnixAddr
+	// watchdog is the WatchdogSec of the unit, zero when it is not set
+	watchdog time.Duration
+}
+
+// newNotifier returns a notifier for the socket systemd passes in
+// NOTIFY_SOCKET, or nil when DockTail was not started by systemd with
+// Type=notify
+func newNotifier(getenv func(string) string) *notifier {
+	socket := getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	n := &notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+	n.watchdog = watchdogInterval(getenv)
+	return n
+}
+
+// watchdogInterval returns the WatchdogSec systemd passes in WATCHDOG_USEC,
+// or zero when the watchdog is disabled or meant for another process
+func watchdogInterval(getenv func(string) string) time.Duration {
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// WatchdogInterval is how often Watchdog should be called: half the
+// WatchdogSec, as systemd recommends, or zero without a watchdog
+func (n *notifier) WatchdogInterval() time.Duration {
+	if n == nil {
+		return 0
+	}
+	return n.watchdog / 2
+}
+
+// Ready tells systemd DockTail finished starting up. The status line keeps
+// the summary of the initial reconciliation.
+func (n *notifier) Ready() {
+	n.send("READY=1")
+}
+
+// Status updates the status line systemctl status shows
+func (n *notifier) Status(status string) {
+	n.send("STATUS=" + status)
+}
+
+// Watchdog tells systemd the reconcile loop is alive
+func (n *notifier) Watchdog() {
+	n.send("WATCHDOG=1")
+}
+
+// Stopping tells systemd DockTail is shutting down
+func (n *notifier) Stopping() {
+	n.send("STOPPING=1")
+}
+
+// send writes a notification. Failures are logged but otherwise ignored:
+// systemd not hearing from DockTail is no reason to stop serving.
+func (n *notifier) send(state string) {
+	if n == nil {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		log.Warn().Err(err).Str("key", "NOTIFY_SOCKET").Msg("Failed to notify systemd")
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Warn().Err(err).Str("key", "NOTIFY_SOCKET").Msg("Failed to notify systemd")
+	}
+}
+
+// reconcileStatus summarizes a cycle for the status line
+func reconcileStatus(result apptypes.ReconcileResult) string {
+	if result.Err != nil {
+		return "Last reconciliation failed: " + result.Err.Error()
+	}
+	status := fmt.Sprintf("Last reconciliation: %d added, %d changed, %d removed",
+		len(result.Added), len(result.Changed), len(result.Removed))
+	if len(result.Deferred) > 0 {
+		status += fmt.Sprintf(", %d removals deferred", len(result.Deferred))
+	}
+	if result.DryRun {
+		status += " (dry run)"
+	}
+	return status
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// listenNotify listens on a unixgram socket like systemd's and returns its
+// path and a function reading the next datagram
+func listenNotify(t *testing.T) (string, func() string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", path, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return path, func() string {
+		t.Helper()
+		buf := make([]byte, 4096)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read notification: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestNotifierSendsDatagrams(t *testing.T) {
+	path, read := listenNotify(t)
+	env := map[string]string{"NOTIFY_SOCKET": path}
+	n := newNotifier(func(key string) string { return env[key] })
+
+	n.Status("Last reconciliation: 1 added, 0 changed, 0 removed")
+	n.Ready()
+	n.Watchdog()
+	n.Stopping()
+
+	for _, want := range []string{
+		"STATUS=Last reconciliation: 1 added, 0 changed, 0 removed",
+		"READY=1",
+		"WATCHDOG=1",
+		"STOPPING=1",
+	} {
+		if got := read(); got != want {
+			t.Errorf("notification = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNotifierWithoutSocket(t *testing.T) {
+	n := newNotifier(func(string) string { return "" })
+	if n != nil {
+		t.Fatalf("newNotifier() = %+v, want nil without NOTIFY_SOCKET", n)
+	}
+
+	// Every method is a no-op on the nil notifier
+	n.Ready()
+	n.Status("ok")
+	n.Watchdog()
+	n.Stopping()
+	if got := n.WatchdogInterval(); got != 0 {
+		t.Errorf("WatchdogInterval() = %v, want 0", got)
+	}
+}
+
+func TestNotifierWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want time.Duration
+	}{
+		{name: "no watchdog", env: map[string]string{}, want: 0},
+		{name: "watchdog", env: map[string]string{"WATCHDOG_USEC": "30000000"}, want: 15 * time.Second},
+		{name: "watchdog for this process", env: map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": pid}, want: 15 * time.Second},
+		{name: "watchdog for another process", env: map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "1"}, want: 0},
+		{name: "invalid", env: map[string]string{"WATCHDOG_USEC": "soon"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["NOTIFY_SOCKET"] = "/run/systemd/notify"
+			n := newNotifier(func(key string) string { return tt.env[key] })
+			if got := n.WatchdogInterval(); got != tt.want {
+				t.Errorf("WatchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		result apptypes.ReconcileResult
+		want   string
+	}{
+		{
+			name:   "changes",
+			result: apptypes.ReconcileResult{Added: []string{"svc:web:443"}, Removed: []string{"svc:api:443", "svc:db:443"}},
+			want:   "Last reconciliation: 1 added, 0 changed, 2 removed",
+		},
+		{
+			name:   "deferred in dry run",
+			result: apptypes.ReconcileResult{Deferred: []string{"svc:api:443"}, DryRun: true},
+			want:   "Last reconciliation: 0 added, 0 changed, 0 removed, 1 removals deferred (dry run)",
+		},
+		{
+			name:   "failed",
+			result: apptypes.ReconcileResult{Err: errors.New("tailscaled not running")},
+			want:   "Last reconciliation failed: tailscaled not running",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reconcileStatus(tt.result); got != tt.want {
+				t.Errorf("reconcileStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// advertisedKeys returns the sorted keys of the desired endpoints whose
+// service is advertised, never nil
+func (c *Client) advertisedKeys(desiredServices []*apptypes.ContainerService) []string {
+	keys := make([]string, 0, len(desiredServices))
+	for _, svc := range desiredServices {
+		serviceName := "svc:" + svc.ServiceName
+		if svc.ServiceEnabled && !svc.Preserve && !svc.Unadvertised && c.advertised[serviceName] {
+			keys = append(keys, serviceKey(serviceName, svc.Port, svc.Path))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reconcileAdvertisements keeps service advertisement in sync with running
+// containers while leaving serve configuration to the user. Services that have
+// a serve config and a running container are advertised; services whose
+// container is gone are drained. Serve and funnel config is never written.
+func (c *Client) reconcileAdvertisements(ctx context.Context, desiredServices []*apptypes.ContainerService, opts ReconcileOptions) (*apptypes.ReconcileResult, error) {
+	result := &apptypes.ReconcileResult{DryRun: opts.DryRun}
+
+	currentServices, err := c.GetCurrentServices(ctx)
+	if err != nil {
+		// Without the current config we can't tell what exists, and advertising
+		// a service that has no serve config would fail anyway
+		return result, fmt.Errorf("failed to get current services: %w", err)
+	}
+
+	configured := make(map[string]struct{})
+	https := make(map[string]bool)
+	for _, svc := range currentServices {
+		configured[svc.ServiceName] = struct{}{}
+		if svc.Protocol == "https" {
+			https[svc.ServiceName] = true
+		}
+	}
+
+	wanted := make(map[string]struct{})
+	preserved := make(map[string]struct{})
+	for _, svc := range desiredServices {
+		serviceName := "svc:" + svc.ServiceName
+		if svc.Preserve {
+			preserved[serviceName] = struct{}{}
+			continue
+		}
+		if !svc.ServiceEnabled || svc.Unadvertised {
+			continue
+		}
+		wanted[serviceName] = struct{}{}
+		if _, ok := configured[serviceName]; !ok {
+			log.Warn().
+				Str("service", serviceName).
+				Str("container", svc.ContainerName).
+				Msg("Advertise-only mode: service has no serve config on this node, skipping advertisement")
+		}
+	}
+
+	names := make([]string, 0, len(configured))
+	for serviceName := range configured {
+		names = append(names, serviceName)
+	}
+	sort.Strings(names)
+
+	var changes []advertisement
+	for _, serviceName := range names {
+		if c.shouldIgnoreService(serviceName) {
+			continue
+		}
+		if _, ok := preserved[serviceName]; ok {
+			continue
+		}
+
+		_, advertise := wanted[serviceName]
+		if known, ok := c.advertised[serviceName]; ok && known == advertise {
+			continue
+		}
+		if !advertise && opts.Maintenance {
+			log.Warn().
+				Str("service", serviceName).
+				Msg("Maintenance mode: deferring drain of service until it ends")
+			result.Deferred = append(result.Deferred, serviceName)
+			continue
+		}
+		changes = append(changes, advertisement{serviceName: serviceName, advertise: advertise})
+	}
+
+	var mu sync.Mutex
+	failCount := 0
+	forEachConcurrently(changes, c.concurrency, func(change advertisement) {
+		if opts.DryRun {
+			log.Info().
+				Str("service", change.serviceName).
+				Str("action", change.action()).
+				Msg("Dry run: would change service advertisement")
+			c.planCommand("serve", change.action(), change.serviceName)
+		} else {
+			if err := c.setAdvertised(ctx, change.serviceName, change.advertise); err != nil {
+				log.Error().
+					Err(err).
+					Str("service", change.serviceName).
+					Str("action", change.action()).
+					Msg("Failed to change service advertisement")
+				mu.Lock()
+				failCount++
+				mu.Unlock()
+				return
+			}
+			if change.advertise && https[change.serviceName] {
+				c.prefetchCert(strings.TrimPrefix(change.serviceName, "svc:"))
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !opts.DryRun {
+			c.advertised[change.serviceName] = change.advertise
+		}
+		if change.advertise {
+			result.Added = append(result.Added, change.serviceName)
+		} else {
+			result.Removed = append(result.Removed, change.serviceName)
+		}
+	})
+	sortResult(result)
+	if !opts.DryRun {
+		result.Applied = c.advertisedKeys(desiredServices)
+	}
+
+	log.Info().
+		Int("advertised", len(result.Added)).
+		Int("drained", len(result.Removed)).
+		Int("failed", failCount).
+		Bool("dry_run", opts.DryRun).
+		Msg("Service advertisement reconciliation completed")
+
+	if failCount > 0 {
+		return result, fmt.Errorf("failed to change advertisement of %d services", failCount)
+	}
+
+	if c.apiSyncEnabled && !opts.DryRun {
+		if err := c.syncServiceDefinitions(ctx, desiredServices); err != nil {
+			log.Error().Err(err).Msg("Failed to sync service definitions to Tailscale API")
+		}
+	}
+
+	return result, nil
+}
+
+// applyAdvertiseLabels drains services whose containers set
+// docktail.service.advertise=false and advertises them again once the label is
+// gone. tailscale serve advertises a service whenever a handler is added, so
+// the serve config is kept and only the advertisement changes.
+func (c *Client) applyAdvertiseLabels(ctx context.Context, desiredServices []*apptypes.ContainerService, opts ReconcileOptions) error {
+	wanted := make(map[string]bool)
+	for _, svc := range desiredServices {
+		if !svc.ServiceEnabled || svc.Preserve {
+			continue
+		}
+		serviceName := "svc:" + svc.ServiceName
+		wanted[serviceName] = wanted[serviceName] || !svc.Unadvertised
+	}
+
+	names := make([]string, 0, len(wanted))
+	for serviceName := range wanted {
+		names = append(names, serviceName)
+	}
+	sort.Strings(names)
+
+	var changes []advertisement
+	for _, serviceName := range names {
+		advertise := wanted[serviceName]
+		known, ok := c.advertised[serviceName]
+		// Served services are advertised unless DockTail drained them, so only
+		// unadvertised services and previously drained ones need a change
+		if advertise && (!ok || known) || !advertise && ok && !known {
+			continue
+		}
+		changes = append(changes, advertisement{serviceName: serviceName, advertise: advertise})
+	}
+
+	var mu sync.Mutex
+	failCount := 0
+	forEachConcurrently(changes, c.concurrency, func(change advertisement) {
+		if opts.DryRun {
+			log.Info().
+				Str("service", change.serviceName).
+				Bool("advertise", change.advertise).
+				Msg("Dry run: would change service advertisement")
+			c.planCommand("serve", change.action(), change.serviceName)
+			return
+		}
+
+		err := c.setAdvertised(ctx, change.serviceName, change.advertise)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failCount++
+			log.Error().
+				Err(err).
+				Str("service", change.serviceName).
+				Msg("Failed to change service advertisement")
+			return
+		}
+		c.advertised[change.serviceName] = change.advertise
+	})
+
+	if failCount > 0 {
+		return fmt.Errorf("failed to change advertisement of %d services", failCount)
+	}
+	return nil
+}
+
+// advertisement is a pending advertise or drain of a service
+type advertisement struct {
+	serviceName string
+	advertise   bool
+}
+
+// action names the tailscale serve subcommand that applies the change
+func (a advertisement) action() string {
+	if a.advertise {
+		return "advertise"
+	}
+	return "drain"
+}
+
+// setAdvertised advertises or drains a service without changing its serve config
+func (c *Client) setAdvertised(ctx context.Context, serviceName string, advertise bool) error {
+	action := "drain"
+	if advertise {
+		action = "advertise"
+	}
+
+	cmd := c.tailscaleCmd(ctx, "serve", action, serviceName)
+	log.Debug().
+		Str("command", cmd.String()).
+		Str("service", serviceName).
+		Msg("Executing tailscale serve " + action + " command")
+
+	if output, err := c.combinedOutput(cmd); err != nil {
+		return fmt.Errorf("failed to %s service %s: %w\nOutput: %s", action, serviceName, err, string(output))
+	}
+
+	log.Info().
+		Str("service", serviceName).
+		Str("action", action).
+		Msg("Service advertisement updated")
+	return nil
+}
+
+// drainAdvertisedServices drains every service this process advertised. It is
+// the advertise-only counterpart of removing services on shutdown.
+func (c *Client) drainAdvertisedServices(ctx context.Context) error {
+	failCount := 0
+	for serviceName, advertised := range c.advertised {
+		if !advertised {
+			continue
+		}
+		if err := c.setAdvertised(ctx, serviceName, false); err != nil {
+			failCount++
+			log.Error().Err(err).Str("service", serviceName).Msg("Failed to drain service")
+			continue
+		}
+		c.advertised[serviceName] = false
+	}
+
+	if failCount > 0 {
+		return fmt.Errorf("cleanup completed with %d errors", failCount)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package tailscale
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Apply modes (APPLY_MODE)
+const (
+	// ApplyModeBulk refuses the whole cycle when any desired service is
+	// invalid, and stops before funnels once a service fails to apply
+	ApplyModeBulk = "bulk"
+	// ApplyModePerService leaves invalid services untouched and carries on
+	// past services that fail to apply, so one bad service can't hold back
+	// the others
+	ApplyModePerService = "per-service"
+)
+
+// ParseApplyMode validates an APPLY_MODE value
+func ParseApplyMode(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case ApplyModeBulk, ApplyModePerService:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown apply mode %q: must be %s or %s", value, ApplyModeBulk, ApplyModePerService)
+	}
+}
+
+// isolateInvalidServices validates every service of cfg on its own. The
+// services that fail are left untouched on the node, as if their containers
+// weren't managed, and recorded in result.Failed; the others are returned to
+// be applied as usual.
+func isolateInvalidServices(services []*apptypes.ContainerService, cfg *apptypes.TailscaleServiceConfig, result *apptypes.ReconcileResult) []*apptypes.ContainerService {
+	names := make([]string, 0, len(cfg.Services))
+	for name := range cfg.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	invalid := make(map[string]struct{})
+	for _, name := range names {
+		single := &apptypes.TailscaleServiceConfig{
+			Version:  cfg.Version,
+			Services: map[string]apptypes.ServiceDefinition{name: cfg.Services[name]},
+		}
+		if err := single.Validate(); err != nil {
+			invalid[name] = struct{}{}
+			recordFailure(result, name, err)
+			log.Error().
+				Err(err).
+				Str("service", name).
+				Msg("Service configuration is invalid, leaving the service untouched")
+		}
+	}
+	if len(invalid) == 0 {
+		return services
+	}
+
+	isolated := make([]*apptypes.ContainerService, 0, len(services))
+	for _, svc := range services {
+		if _, ok := invalid["svc:"+svc.ServiceName]; ok && svc.ServiceEnabled {
+			preserved := *svc
+			preserved.ServiceEnabled = false
+			preserved.Preserve = true
+			svc = &preserved
+		}
+		isolated = append(isolated, svc)
+	}
+	return isolated
+}
+
+// recordFailure records why a service or service key failed to apply
+func recordFailure(result *apptypes.ReconcileResult, key string, err error) {
+	if result.Failed == nil {
+		result.Failed = make(map[string]string)
+	}
+	result.Failed[key] = err.Error()
+}
+
+// failedError is the error of a per-service cycle in which services failed
+func failedError(result *apptypes.ReconcileResult) error {
+	return fmt.Errorf("failed to apply %d services", len(result.Failed))
+}
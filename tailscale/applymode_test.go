@@ -0,0 +1,90 @@
+package tailscale
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// poisonedServices returns web and api, which apply, and bad, whose
+// destination is given
+func poisonedServices(badDestination string) []*apptypes.ContainerService {
+	return []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		{ContainerName: "bad", ServiceEnabled: true, ServiceName: "bad", Port: "443", ServiceProtocol: "https", Destination: badDestination},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "80"},
+	}
+}
+
+func TestReconcileServicesApplyModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string // destination of svc:bad
+		failing     bool   // the tailscale CLI rejects svc:bad
+		wantFailed  string // key of svc:bad in result.Failed
+	}{
+		{name: "invalid config", destination: "http://", wantFailed: "svc:bad"},
+		{name: "rejected by tailscale", destination: "http://172.17.0.4:80", failing: true, wantFailed: "svc:bad:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/per-service", func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			if tt.failing {
+				fake.failCalls(t, "serve --service=svc:bad --https=443 "+tt.destination)
+			}
+			client := NewClient(ClientConfig{ApplyMode: ApplyModePerService})
+			client.serveRetryDelays = nil
+
+			result, err := client.ReconcileServices(context.Background(), poisonedServices(tt.destination), ReconcileOptions{})
+			if err == nil {
+				t.Fatal("ReconcileServices() error = nil, want svc:bad failed")
+			}
+			if want := []string{"svc:api:443", "svc:web:443"}; !reflect.DeepEqual(result.Added, want) {
+				t.Errorf("Added = %v, want %v", result.Added, want)
+			}
+			if len(result.Failed) != 1 || result.Failed[tt.wantFailed] == "" {
+				t.Errorf("Failed = %v, want %s only", result.Failed, tt.wantFailed)
+			}
+		})
+
+		t.Run(tt.name+"/bulk", func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			if tt.failing {
+				fake.failCalls(t, "serve --service=svc:bad --https=443 "+tt.destination)
+			}
+			client := NewClient(ClientConfig{ApplyMode: ApplyModeBulk})
+			client.serveRetryDelays = nil
+
+			result, err := client.ReconcileServices(context.Background(), poisonedServices(tt.destination), ReconcileOptions{})
+			if err == nil {
+				t.Fatal("ReconcileServices() error = nil, want the cycle failed")
+			}
+			if tt.failing {
+				return
+			}
+			if len(result.Added) != 0 {
+				t.Errorf("Added = %v, want nothing applied with an invalid config", result.Added)
+			}
+			for _, call := range mutatingCalls(fake) {
+				if strings.HasPrefix(call, "serve --service") {
+					t.Errorf("invalid config changed the node: %s", call)
+				}
+			}
+		})
+	}
+}
+
+func TestParseApplyMode(t *testing.T) {
+	for value, want := range map[string]string{"bulk": ApplyModeBulk, " Per-Service ": ApplyModePerService} {
+		if got, err := ParseApplyMode(value); err != nil || got != want {
+			t.Errorf("ParseApplyMode(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseApplyMode("atomic"); err == nil {
+		t.Error("ParseApplyMode(\"atomic\") succeeded, want an error")
+	}
+}
@@ -0,0 +1,151 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"expvar"
+	"strings"
+	"time"
+)
+
+// certPrefetchTimeout bounds a background certificate fetch
+const certPrefetchTimeout = 2 * time.Minute
+
+// certRenewBefore is how long before its expiry a certificate is fetched
+// again. tailscaled renews certificates it is asked for in that window.
+const certRenewBefore = 30 * 24 * time.Hour
+
+// certPrefetchesVar counts certificate prefetches by outcome ("success" or "failure")
+var certPrefetchesVar = expvar.NewMap("docktail_cert_prefetches")
+
+// prefetchCert fetches the TLS certificate of an https service in the
+// background unless the certificate fetched earlier is still valid. Failures
+// are logged and never affect reconciliation.
+func (c *Client) prefetchCert(serviceName string) {
+	if !c.prefetchCerts {
+		return
+	}
+
+	domain := c.serviceDomain(serviceName)
+	if domain == "" {
+		log.Debug().
+			Str("service", serviceName).
+			Msg("Tailnet domain unknown, skipping certificate prefetch")
+		return
+	}
+	c.prefetchDomainCert(domain)
+}
+
+// prefetchNodeCert fetches the TLS certificate of the node itself, which
+// funnels serve from the node's name rather than a service name
+func (c *Client) prefetchNodeCert() {
+	if !c.prefetchCerts {
+		return
+	}
+
+	domain := c.NodeInfo().DNSName
+	if domain == "" {
+		log.Debug().Msg("Node name unknown, skipping certificate prefetch")
+		return
+	}
+	c.prefetchDomainCert(domain)
+}
+
+// prefetchDomainCert runs tailscale cert for a domain in the background,
+// unless a fetch is already running or the domain's certificate is valid for
+// longer than certRenewBefore
+func (c *Client) prefetchDomainCert(domain string) {
+	c.certMu.Lock()
+	if _, pending := c.certsPending[domain]; pending {
+		c.certMu.Unlock()
+		return
+	}
+	if expiry, ok := c.certExpiry[domain]; ok && time.Until(expiry) > certRenewBefore {
+		c.certMu.Unlock()
+		log.Debug().
+			Str("domain", domain).
+			Time("expiry", expiry).
+			Msg("TLS certificate still valid, skipping prefetch")
+		return
+	}
+	c.certsPending[domain] = struct{}{}
+	c.certMu.Unlock()
+
+	c.certWG.Add(1)
+	go func() {
+		defer c.certWG.Done()
+
+		ctx, cancel := context.WithTimeout(c.certCtx, certPrefetchTimeout)
+		defer cancel()
+
+		// The certificate and key go to stdout. Only the certificate's expiry
+		// is kept: tailscaled keeps its own copy of both and serves it.
+		var stdout, stderr bytes.Buffer
+		cmd := c.tailscaleCmd(ctx, "cert", "--cert-file=-", "--key-file=-", domain)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		start := time.Now()
+		err := cmd.Run()
+		expiry, found := certExpiry(stdout.Bytes())
+
+		c.certMu.Lock()
+		delete(c.certsPending, domain)
+		if err == nil && found {
+			c.certExpiry[domain] = expiry
+		}
+		c.certMu.Unlock()
+
+		if err != nil {
+			if c.certCtx.Err() != nil {
+				log.Debug().Str("domain", domain).Msg("Certificate prefetch cancelled by shutdown")
+				return
+			}
+			certPrefetchesVar.Add("failure", 1)
+			log.Warn().
+				Err(err).
+				Str("domain", domain).
+				Str("output", strings.TrimSpace(stderr.String())).
+				Msg("Failed to prefetch TLS certificate")
+			return
+		}
+
+		certPrefetchesVar.Add("success", 1)
+		event := log.Info().
+			Str("domain", domain).
+			Dur("duration", time.Since(start))
+		if found {
+			event = event.Time("expiry", expiry)
+		}
+		event.Msg("Prefetched TLS certificate")
+	}()
+}
+
+// certExpiry returns the expiry of the first certificate in the PEM output of
+// tailscale cert, skipping the private key
+func certExpiry(output []byte) (time.Time, bool) {
+	for {
+		var block *pem.Block
+		block, output = pem.Decode(output)
+		if block == nil {
+			return time.Time{}, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return cert.NotAfter, true
+	}
+}
+
+// StopCertPrefetches cancels the certificate prefetches still running and
+// waits for them to return
+func (c *Client) StopCertPrefetches() {
+	c.certCancel()
+	c.certWG.Wait()
+}
@@ -0,0 +1,200 @@
+package tailscale
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"expvar"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestPrefetchCertSkipsValidCert(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setCert(t, time.Now().Add(90*24*time.Hour))
+
+	client := NewClient(ClientConfig{PrefetchCerts: true})
+	client.node = NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"}
+
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080"},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "80", ServiceProtocol: "http", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "8080"},
+	}
+
+	// The fake status stays empty, so every cycle adds the services again
+	for range 2 {
+		if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		client.certWG.Wait()
+	}
+
+	var certCalls []string
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "cert ") {
+			certCalls = append(certCalls, call)
+		}
+	}
+	want := "cert --cert-file=- --key-file=- web.tail1234.ts.net"
+	if len(certCalls) != 1 || certCalls[0] != want {
+		t.Errorf("cert calls = %q, want [%q]", certCalls, want)
+	}
+}
+
+func TestPrefetchCertRefetchesExpiringCert(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setCert(t, time.Now().Add(10*24*time.Hour))
+
+	client := NewClient(ClientConfig{PrefetchCerts: true})
+	client.node = NodeInfo{MagicDNSSuffix: "tail1234.ts.net"}
+
+	for range 2 {
+		client.prefetchCert("web")
+		client.certWG.Wait()
+	}
+
+	var certCalls int
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "cert ") {
+			certCalls++
+		}
+	}
+	// tailscaled renews the certificate when asked for it again
+	if certCalls != 2 {
+		t.Errorf("cert calls = %d, want 2", certCalls)
+	}
+}
+
+func TestPrefetchCertDisabled(t *testing.T) {
+	fake := installFakeTailscale(t)
+
+	client := NewClient(ClientConfig{})
+	client.node = NodeInfo{MagicDNSSuffix: "tail1234.ts.net"}
+	client.prefetchCert("web")
+	client.certWG.Wait()
+
+	if calls := fake.calls(); len(calls) != 0 {
+		t.Errorf("calls = %q, want none", calls)
+	}
+}
+
+func TestPrefetchCertForFunnels(t *testing.T) {
+	fake := installFakeTailscale(t)
+
+	fake.setCert(t, time.Now().Add(90*24*time.Hour))
+
+	client := NewClient(ClientConfig{PrefetchCerts: true})
+	client.node = NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"}
+
+	desired := []*apptypes.ContainerService{funnelService("web", "443", "8080"), funnelService("api", "8443", "9090")}
+
+	successes := certPrefetchCount("success")
+	result := &apptypes.ReconcileResult{}
+	if err := client.reconcileFunnels(context.Background(), desired, ReconcileOptions{}, result); err != nil {
+		t.Fatalf("reconcileFunnels() error = %v", err)
+	}
+	client.certWG.Wait()
+
+	var certCalls []string
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "cert ") {
+			certCalls = append(certCalls, call)
+		}
+	}
+	// Funnels share the node's name, so its certificate is fetched once
+	want := "cert --cert-file=- --key-file=- docker-host.tail1234.ts.net"
+	if len(certCalls) != 1 || certCalls[0] != want {
+		t.Errorf("cert calls = %q, want [%q]", certCalls, want)
+	}
+	if got := certPrefetchCount("success") - successes; got != 1 {
+		t.Errorf("successful prefetches = %d, want 1", got)
+	}
+}
+
+func TestPrefetchCertRecordsFailures(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.failCalls(t, "cert --cert-file=- --key-file=- web.tail1234.ts.net")
+
+	client := NewClient(ClientConfig{PrefetchCerts: true})
+	client.node = NodeInfo{MagicDNSSuffix: "tail1234.ts.net"}
+
+	failures := certPrefetchCount("failure")
+	client.prefetchCert("web")
+	client.certWG.Wait()
+
+	if got := certPrefetchCount("failure") - failures; got != 1 {
+		t.Errorf("failed prefetches = %d, want 1", got)
+	}
+	// A failed fetch is retried the next time the service is added
+	if _, fetched := client.certExpiry["web.tail1234.ts.net"]; fetched {
+		t.Error("failed domain should not be marked as fetched")
+	}
+	if _, pending := client.certsPending["web.tail1234.ts.net"]; pending {
+		t.Error("failed domain should not be marked as pending")
+	}
+}
+
+func TestStopCertPrefetches(t *testing.T) {
+	installScript(t, `exec sleep 10`)
+
+	client := NewClient(ClientConfig{PrefetchCerts: true})
+	client.node = NodeInfo{MagicDNSSuffix: "tail1234.ts.net"}
+
+	failures := certPrefetchCount("failure")
+	client.prefetchCert("web")
+
+	start := time.Now()
+	client.StopCertPrefetches()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("StopCertPrefetches() took %s, want the fetch cancelled", elapsed)
+	}
+	// A cancelled fetch is not a failure
+	if got := certPrefetchCount("failure") - failures; got != 0 {
+		t.Errorf("failed prefetches = %d, want 0", got)
+	}
+}
+
+// setCert makes `tailscale cert` print a certificate expiring at notAfter,
+// followed by its key
+func (f *fakeTailscale) setCert(t *testing.T, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	if err := os.WriteFile(filepath.Join(f.dir, "cert.pem"), output, 0o644); err != nil {
+		t.Fatalf("failed to write fake certificate: %v", err)
+	}
+}
+
+// certPrefetchCount reads a counter of the certificate prefetch metric
+func certPrefetchCount(outcome string) int64 {
+	if v, ok := certPrefetchesVar.Get(outcome).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/marvinvr/docktail/atomicfile"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// managedFunnelsFile is the file (inside the state directory) listing the
+// public ports of the funnels DockTail enabled
+const managedFunnelsFile = "managed-funnels.json"
+
+// saveManagedFunnels records the funnels DockTail manages, so a later
+// `docktail cleanup` knows which funnels it may remove
+func (c *Client) saveManagedFunnels() {
+	c.writeManagedFunnels(c.managedFunnels)
+}
+
+// writeManagedFunnels writes the public ports of managed funnels to the state
+// directory
+func (c *Client) writeManagedFunnels(funnels map[string]struct{}) {
+	if c.stateDir == "" {
+		return
+	}
+
+	ports := make([]string, 0, len(funnels))
+	for port := range funnels {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	data, err := json.Marshal(ports)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(c.stateDir, managedFunnelsFile)
+	if err := atomicfile.Write(path, data); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to record managed funnels")
+	}
+}
+
+// CleanupPlan lists what Cleanup removes from a node, and what it leaves alone
+type CleanupPlan struct {
+	Services []string        // managed services, e.g. "svc:web"
+	Funnels  []CurrentFunnel // managed funnels
+	// Skipped explains why services and funnels found on the node are kept
+	Skipped []string
+}
+
+// PlanCleanup finds the services and funnels DockTail owns on the node.
+// Services must carry the svc: prefix and SERVICE_NAME_PREFIX, must not be
+// ignored and must appear in the desired configuration DockTail last wrote to
+// the state directory; funnels must be recorded as enabled by DockTail.
+// Everything else on the node is skipped.
+func (c *Client) PlanCleanup(ctx context.Context) (*CleanupPlan, error) {
+	if c.stateDir == "" {
+		return nil, errors.New("no state directory: set STATE_DIR to the directory DockTail ran with, since only services recorded there are removed")
+	}
+
+	owned, err := c.ownedServices()
+	if err != nil {
+		return nil, err
+	}
+	ownedFunnels, err := c.ownedFunnels()
+	if err != nil {
+		return nil, err
+	}
+
+	currentServices, err := c.GetCurrentServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currentFunnels, err := c.getCurrentFunnels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CleanupPlan{}
+	seen := make(map[string]struct{})
+	for _, endpoint := range currentServices {
+		serviceName := endpoint.ServiceName
+		if _, ok := seen[serviceName]; ok {
+			continue
+		}
+		seen[serviceName] = struct{}{}
+
+		switch _, recorded := owned[serviceName]; {
+		case !isManagedService(serviceName):
+			plan.Skipped = append(plan.Skipped, serviceName+": not a svc: service")
+		case c.shouldIgnoreService(serviceName):
+			plan.Skipped = append(plan.Skipped, serviceName+": ignored or outside SERVICE_NAME_PREFIX")
+		case !recorded:
+			plan.Skipped = append(plan.Skipped, serviceName+": not created by DockTail")
+		default:
+			plan.Services = append(plan.Services, serviceName)
+		}
+	}
+
+	for port, funnel := range currentFunnels {
+		if _, ok := ownedFunnels[port]; !ok {
+			plan.Skipped = append(plan.Skipped, "funnel "+port+": not enabled by DockTail")
+			continue
+		}
+		plan.Funnels = append(plan.Funnels, funnel)
+	}
+
+	sort.Strings(plan.Services)
+	sort.Slice(plan.Funnels, func(i, j int) bool { return plan.Funnels[i].PublicPort < plan.Funnels[j].PublicPort })
+	sort.Strings(plan.Skipped)
+	return plan, nil
+}
+
+// ownedServices returns the services of the desired configuration in the
+// state directory
+func (c *Client) ownedServices() (map[string]struct{}, error) {
+	path := filepath.Join(c.stateDir, desiredConfigFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]struct{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg apptypes.TailscaleServiceConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	owned := make(map[string]struct{}, len(cfg.Services))
+	for serviceName := range cfg.Services {
+		owned[serviceName] = struct{}{}
+	}
+	return owned, nil
+}
+
+// ownedFunnels returns the public ports of the funnels recorded in the state
+// directory
+func (c *Client) ownedFunnels() (map[string]struct{}, error) {
+	path := filepath.Join(c.stateDir, managedFunnelsFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]struct{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []string
+	if err := json.Unmarshal(data, &ports); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	owned := make(map[string]struct{}, len(ports))
+	for _, port := range ports {
+		owned[port] = struct{}{}
+	}
+	return owned, nil
+}
+
+// Cleanup drains and clears the services of a plan and turns its funnels
+// off. It carries on past failures and returns them together.
+func (c *Client) Cleanup(ctx context.Context, plan *CleanupPlan) error {
+	// Removed services aren't external changes
+	c.applied = nil
+
+	var errs []error
+	for _, serviceName := range plan.Services {
+		// removeService checks the svc: prefix and ignore list once more
+		if err := c.removeService(ctx, serviceName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(plan.Funnels) == 0 {
+		return errors.Join(errs...)
+	}
+	remaining, err := c.ownedFunnels()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, funnel := range plan.Funnels {
+		if err := c.removeFunnel(ctx, funnel); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(remaining, funnel.PublicPort)
+		delete(c.managedFunnels, funnel.PublicPort)
+	}
+	// Funnels that failed to turn off stay recorded for another attempt
+	c.writeManagedFunnels(remaining)
+
+	return errors.Join(errs...)
+}
+
+// removeFunnel turns off the funnel on one public port
+func (c *Client) removeFunnel(ctx context.Context, funnel CurrentFunnel) error {
+	protocol := funnel.Protocol
+	if protocol == "" || protocol == "http" {
+		protocol = "https"
+	}
+
+	cmd := c.tailscaleCmd(ctx, "funnel", fmt.Sprintf("--%s=%s", protocol, funnel.PublicPort), "off")
+	if output, err := c.combinedOutput(cmd); err != nil && !isNotFoundError(string(output)) {
+		return fmt.Errorf("failed to turn off funnel on port %s: %w\nOutput: %s", funnel.PublicPort, err, string(output))
+	}
+
+	log.Info().
+		Str("public_port", funnel.PublicPort).
+		Str("protocol", protocol).
+		Msg("Funnel removed")
+	return nil
+}
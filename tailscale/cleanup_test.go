@@ -0,0 +1,153 @@
+package tailscale
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// mutatingCalls returns the recorded CLI invocations that change the node
+func mutatingCalls(fake *fakeTailscale) []string {
+	var calls []string
+	for _, call := range fake.calls() {
+		if strings.HasSuffix(call, "status --json") || call == "" {
+			continue
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+func writeStateFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanupRemovesOnlyOwnedServices(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}},
+		"svc:db":{"TCP":{"5432":{"TCPForward":"172.17.0.3:5432"}}},
+		"svc:manual":{"TCP":{"443":{"HTTPS":true}},"Web":{"manual.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.9:80"}}}}},
+		"svc:kept":{"TCP":{"443":{"HTTPS":true}},"Web":{"kept.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.4:80"}}}}}
+	}}`)
+	writeStateFile(t, fake.dir, "funnels", "443 http://172.17.0.2:80\n8443 http://10.0.0.9:80\n")
+
+	stateDir := t.TempDir()
+	writeStateFile(t, stateDir, desiredConfigFile, `{"version":"0.0.1","services":{
+		"svc:web":{"endpoints":{"tcp:443":"http://172.17.0.2:80"}},
+		"svc:db":{"endpoints":{"tcp:5432":"tcp://172.17.0.3:5432"}},
+		"svc:kept":{"endpoints":{"tcp:443":"http://172.17.0.4:80"}},
+		"svc:gone":{"endpoints":{"tcp:443":"http://172.17.0.5:80"}}
+	}}`)
+	writeStateFile(t, stateDir, managedFunnelsFile, `["443"]`)
+
+	client := NewClient(ClientConfig{StateDir: stateDir, IgnoreServiceNames: []string{"kept"}})
+	plan, err := client.PlanCleanup(context.Background())
+	if err != nil {
+		t.Fatalf("PlanCleanup() error = %v", err)
+	}
+
+	if want := []string{"svc:db", "svc:web"}; !reflect.DeepEqual(plan.Services, want) {
+		t.Errorf("Services = %v, want %v", plan.Services, want)
+	}
+	if len(plan.Funnels) != 1 || plan.Funnels[0].PublicPort != "443" {
+		t.Errorf("Funnels = %+v, want the funnel on 443", plan.Funnels)
+	}
+	wantSkipped := []string{
+		"funnel 8443: not enabled by DockTail",
+		"svc:kept: ignored or outside SERVICE_NAME_PREFIX",
+		"svc:manual: not created by DockTail",
+	}
+	if !reflect.DeepEqual(plan.Skipped, wantSkipped) {
+		t.Errorf("Skipped = %v, want %v", plan.Skipped, wantSkipped)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("planning changed the node: %v", calls)
+	}
+
+	if err := client.Cleanup(context.Background(), plan); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	wantCalls := []string{
+		"serve drain svc:db",
+		"serve clear svc:db",
+		"serve drain svc:web",
+		"serve clear svc:web",
+		"funnel --https=443 off",
+	}
+	if calls := mutatingCalls(fake); !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", calls, wantCalls)
+	}
+
+	data, err := os.ReadFile(filepath.Join(stateDir, managedFunnelsFile))
+	if err != nil || string(data) != "[]" {
+		t.Errorf("managed funnels = %q, %v; want them forgotten", data, err)
+	}
+}
+
+func TestCleanupHonorsServiceNamePrefix(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:prod-web":{"TCP":{"443":{"HTTPS":true}},"Web":{"prod-web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}},
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.3:80"}}}}}
+	}}`)
+
+	// Even a service recorded as owned is left alone without the prefix
+	stateDir := t.TempDir()
+	writeStateFile(t, stateDir, desiredConfigFile, `{"version":"0.0.1","services":{
+		"svc:prod-web":{"endpoints":{"tcp:443":"http://172.17.0.2:80"}},
+		"svc:web":{"endpoints":{"tcp:443":"http://172.17.0.3:80"}}
+	}}`)
+
+	client := NewClient(ClientConfig{StateDir: stateDir, ServiceNamePrefix: "prod-"})
+	plan, err := client.PlanCleanup(context.Background())
+	if err != nil {
+		t.Fatalf("PlanCleanup() error = %v", err)
+	}
+	if err := client.Cleanup(context.Background(), plan); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	wantCalls := []string{"serve drain svc:prod-web", "serve clear svc:prod-web"}
+	if calls := mutatingCalls(fake); !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", calls, wantCalls)
+	}
+}
+
+func TestPlanCleanupRequiresStateDir(t *testing.T) {
+	fake := installFakeTailscale(t)
+
+	if _, err := NewClient(ClientConfig{}).PlanCleanup(context.Background()); err == nil {
+		t.Fatal("expected an error without a state directory")
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestReconcileFunnelsRecordsManagedFunnels(t *testing.T) {
+	installFakeTailscale(t)
+	stateDir := t.TempDir()
+
+	client := NewClient(ClientConfig{StateDir: stateDir})
+	desired := []*apptypes.ContainerService{funnelService("web", "443", "8080")}
+	if err := client.reconcileFunnels(context.Background(), desired, ReconcileOptions{}, &apptypes.ReconcileResult{}); err != nil {
+		t.Fatalf("reconcileFunnels() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(stateDir, managedFunnelsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `["443"]` {
+		t.Errorf("managed funnels = %s, want [\"443\"]", data)
+	}
+}
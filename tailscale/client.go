@@ -8,25 +8,119 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2/clientcredentials"
 
+	"github.com/marvinvr/docktail/report"
 	apptypes "github.com/marvinvr/docktail/types"
 )
 
 // Client handles Tailscale CLI interactions and API calls
 type Client struct {
-	socketPath      string
-	tailnet         string
-	baseURL         string
-	httpClient      *http.Client
-	apiSyncEnabled  bool
-	serverVersion   string // set when CLI/daemon version mismatch detected
+	socketPath     string
+	tailnet        string
+	baseURL        string
+	httpClient     *http.Client
+	apiSyncEnabled bool
+	serverVersion  string // set when CLI/daemon version mismatch detected
+	// skipPrompts is set when the CLI supports --yes on serve and funnel
+	skipPrompts bool
+	// commandTimeout bounds each tailscale CLI call
+	commandTimeout  time.Duration
 	managedFunnels  map[string]struct{}
 	ignoredServices map[string]struct{}
+	// serviceNamePrefix limits the services DockTail manages to those starting
+	// with it (SERVICE_NAME_PREFIX)
+	serviceNamePrefix string
+	stateDir          string // optional directory for debug/state files
+	dumpConfigPath    string // file receiving the desired config every cycle (DUMP_CONFIG)
+	// maxServicesDetail is the service count above which configs are logged as a summary
+	maxServicesDetail int
+	// advertiseOnly limits DockTail to advertising and draining services whose
+	// serve config is managed elsewhere
+	advertiseOnly bool
+	advertised    map[string]bool // last advertisement state set per service
+	// redirectUnsupported is set once tailscale rejects a redirect handler;
+	// http redirect endpoints then proxy to the backend instead
+	redirectUnsupported bool
+	// serveRetryDelays are the waits before retrying a serve command that
+	// failed for a reason that may be transient
+	serveRetryDelays []time.Duration
+
+	nodeMu       sync.RWMutex
+	node         NodeInfo            // identity of the node, refreshed every reconciliation
+	peerServices map[string][]string // services advertised by other nodes, with their names
+
+	collisionPolicy string              // SERVICE_COLLISION_POLICY
+	concurrency     int                 // advertise and funnel commands run at once (RECONCILE_CONCURRENCY)
+	collisions      map[string][]string // desired services other nodes advertise, from the last cycle
+
+	// prefetchCerts fetches the TLS certificate of new https services in the
+	// background so the first request doesn't wait for it
+	prefetchCerts bool
+	certMu        sync.Mutex
+	certExpiry    map[string]time.Time // expiry of the certificate last fetched per domain
+	certsPending  map[string]struct{}  // domains being fetched
+	certWG        sync.WaitGroup
+	// certCtx is cancelled by StopCertPrefetches
+	certCtx    context.Context
+	certCancel context.CancelFunc
+
+	// removeGrace keeps services of stopped containers drained instead of
+	// cleared, so a restart doesn't churn their config (REMOVE_GRACE_PERIOD)
+	removeGrace time.Duration
+	drainedAt   map[string]time.Time           // services drained because their containers stopped
+	owners      map[string]map[string]struct{} // container IDs last backing each service
+
+	// reporter receives services and funnels that fail to apply
+	reporter report.Reporter
+
+	// commandLog, if set, receives a JSON line per tailscale CLI call
+	commandLog         io.Writer
+	commandLogReadOnly bool // also record commands that don't change the node
+	commandLogMu       sync.Mutex
+
+	// lastDiff is how the node's config differed from the desired config in
+	// the last cycle
+	diffMu   sync.Mutex
+	lastDiff ConfigDiff
+
+	// allowUnmanagedOverwrite lets DockTail remove services the previous run
+	// didn't record (ALLOW_UNMANAGED_OVERWRITE)
+	allowUnmanagedOverwrite bool
+	// ownershipKnown is set once holdUnmanagedRemovals held nothing back;
+	// until then recorded holds the services the previous run recorded, and
+	// those desired since. It is nil without a state file.
+	ownershipKnown bool
+	recordedLoaded bool
+	recorded       map[string]struct{}
+
+	// applyMode is ApplyModeBulk or ApplyModePerService (APPLY_MODE)
+	applyMode string
+
+	// snapshot shares the serve config read by a reconciliation
+	snapshot statusSnapshot
+
+	// instance identifies this process in the state directory; otherInstance
+	// is the last other instance found there, already warned about
+	instance        instanceMarker
+	instanceClaimed bool
+	otherInstance   string
+	// applied holds the endpoints the previous cycle left as desired, and
+	// externalStreaks the consecutive cycles each was found changed since
+	applied         map[string]ServiceEndpoint
+	externalStreaks map[string]int
+
+	// verifyInterval is how long periodic cycles may trust appliedHash, the
+	// desired services last applied without failures, instead of reading the
+	// serve config, which was last read at verifiedAt (VERIFY_INTERVAL)
+	verifyInterval time.Duration
+	appliedHash    string
+	verifiedAt     time.Time
 }
 
 // ClientConfig holds configuration for creating a Tailscale client
@@ -37,19 +131,81 @@ type ClientConfig struct {
 	OAuthClientID      string
 	OAuthClientSecret  string
 	IgnoreServiceNames []string
+	// Concurrency bounds how many independent advertise and funnel commands run
+	// at once (default: DefaultConcurrency)
+	Concurrency int
+	// CollisionPolicy decides what happens to services another node already
+	// advertises: CollisionPolicyWarn (default) or CollisionPolicySkip
+	CollisionPolicy string
+	// ServiceNamePrefix is the SERVICE_NAME_PREFIX added to every service name.
+	// Services without it are left untouched.
+	ServiceNamePrefix string
+	StateDir          string
+	// DumpConfigPath is a file the desired configuration is written to on
+	// every reconciliation, for inspection and tooling
+	DumpConfigPath    string
+	MaxServicesDetail int
+	AdvertiseOnly     bool
+	PrefetchCerts     bool
+	// RemoveGracePeriod is how long services of stopped containers stay drained
+	// before they are removed. Zero removes them right away.
+	RemoveGracePeriod time.Duration
+	// ErrorReporter receives services and funnels that fail to apply, with
+	// the service and container involved. Nil discards them.
+	ErrorReporter report.Reporter
+	// CommandLog, if set, receives a CommandLogEntry as a JSON line for every
+	// mutating tailscale CLI call, and for every one a dry run would make.
+	// CommandLogReadOnly also records commands that only read the node's state.
+	CommandLog         io.Writer
+	CommandLogReadOnly bool
+	// AllowUnmanagedOverwrite lets DockTail remove services that the desired
+	// configuration in StateDir doesn't record, whose removal it otherwise
+	// holds back
+	AllowUnmanagedOverwrite bool
+	// ApplyMode is ApplyModeBulk (default) or ApplyModePerService, which
+	// applies the valid services even when others are invalid or fail
+	ApplyMode string
+	// VerifyInterval lets cycles without opts.Verify skip reading the serve
+	// config for that long while the desired services stay as last applied.
+	// Zero reads it every cycle.
+	VerifyInterval time.Duration
 }
 
 // NewClient creates a new Tailscale client
 // Prefers OAuth credentials over API key if both are provided
 func NewClient(cfg ClientConfig) *Client {
 	client := &Client{
-		socketPath:      cfg.SocketPath,
-		tailnet:         cfg.Tailnet,
-		baseURL:         "https://api.tailscale.com",
-		managedFunnels:  make(map[string]struct{}),
-		ignoredServices: make(map[string]struct{}),
+		socketPath:        cfg.SocketPath,
+		tailnet:           cfg.Tailnet,
+		baseURL:           "https://api.tailscale.com",
+		managedFunnels:    make(map[string]struct{}),
+		ignoredServices:   make(map[string]struct{}),
+		serviceNamePrefix: cfg.ServiceNamePrefix,
+		collisionPolicy:   cfg.CollisionPolicy,
+		concurrency:       cfg.Concurrency,
+		stateDir:          cfg.StateDir,
+		dumpConfigPath:    cfg.DumpConfigPath,
+		maxServicesDetail: cfg.MaxServicesDetail,
+		advertiseOnly:     cfg.AdvertiseOnly,
+		advertised:        make(map[string]bool),
+		prefetchCerts:     cfg.PrefetchCerts,
+		certExpiry:        make(map[string]time.Time),
+		certsPending:      make(map[string]struct{}),
+		removeGrace:       cfg.RemoveGracePeriod,
+		drainedAt:         make(map[string]time.Time),
+		owners:            make(map[string]map[string]struct{}),
+		commandTimeout:    DefaultCommandTimeout,
+		serveRetryDelays:  serveRetryDelays,
+		reporter:          report.OrNop(cfg.ErrorReporter),
 	}
 
+	client.commandLog = cfg.CommandLog
+	client.commandLogReadOnly = cfg.CommandLogReadOnly
+	client.allowUnmanagedOverwrite = cfg.AllowUnmanagedOverwrite
+	client.applyMode = cfg.ApplyMode
+	client.verifyInterval = cfg.VerifyInterval
+	client.certCtx, client.certCancel = context.WithCancel(context.Background())
+
 	for _, serviceName := range cfg.IgnoreServiceNames {
 		normalized := normalizeServiceName(serviceName)
 		if normalized != "" {
@@ -105,6 +261,16 @@ type ServiceEndpoint struct {
 	Port        string // e.g., "443"
 	Protocol    string // e.g., "http", "https", "tcp"
 	Destination string // e.g., "http://localhost:9080"
+	Path        string // Mount path below "/" (e.g., "/grafana"), empty for the root
+	// ProxyProtocol is the PROXY protocol version a TCP endpoint sends to its
+	// backend ("1" or "2"), empty when it sends none
+	ProxyProtocol string
+}
+
+// serviceKey identifies a service endpoint, e.g. "svc:web:443" or, for a handler
+// mounted below the root, "svc:web:443/grafana"
+func serviceKey(serviceName, port, path string) string {
+	return serviceName + ":" + port + path
 }
 
 // TailscaleStatus represents the structure of 'tailscale serve status --json'
@@ -120,6 +286,11 @@ type TailscaleService struct {
 type TailscaleTCPConfig struct {
 	HTTP  bool `json:"HTTP"`
 	HTTPS bool `json:"HTTPS"`
+	// TCPForward is the host:port a TCP endpoint forwards to, and
+	// TerminateTLS the name whose certificate terminates TLS before it
+	TCPForward    string `json:"TCPForward,omitempty"`
+	TerminateTLS  string `json:"TerminateTLS,omitempty"`
+	ProxyProtocol int    `json:"ProxyProtocol,omitempty"`
 }
 
 type TailscaleWebConfig struct {
@@ -127,13 +298,78 @@ type TailscaleWebConfig struct {
 }
 
 type TailscaleHandler struct {
-	Proxy string `json:"Proxy"`
+	Proxy    string `json:"Proxy,omitempty"`
+	Text     string `json:"Text,omitempty"`
+	Path     string `json:"Path,omitempty"`
+	Redirect string `json:"Redirect,omitempty"`
+}
+
+// destination returns the handler target in the form BuildDestination produces,
+// so static handlers compare equal to the desired config
+func (h TailscaleHandler) destination() string {
+	switch {
+	case h.Proxy != "":
+		return h.Proxy
+	case h.Text != "":
+		return "text:" + h.Text
+	case h.Redirect != "":
+		return "redirect:" + h.Redirect
+	default:
+		return h.Path
+	}
 }
 
-// ReconcileServices compares desired services with current services and makes necessary changes
-func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*apptypes.ContainerService) error {
+// ReconcileOptions controls how a single reconciliation is applied
+type ReconcileOptions struct {
+	// DryRun computes and logs the changes without executing any mutating commands
+	DryRun bool
+	// Destroyed holds the short IDs of containers destroyed since the last
+	// cycle. Their services are removed without waiting for the grace period.
+	Destroyed map[string]bool
+	// Maintenance keeps every managed service and funnel: additions and updates
+	// are applied, but removals and drains are deferred to the first cycle
+	// without it
+	Maintenance bool
+	// Verify reads the serve config even when VerifyInterval would trust the
+	// last applied one, such as after Docker events
+	Verify bool
+}
+
+// ReconcileServices compares desired services with current services and makes necessary changes.
+// The returned result describes the changes that were made (or would be made in dry-run mode).
+func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*apptypes.ContainerService, opts ReconcileOptions) (*apptypes.ReconcileResult, error) {
+	result := &apptypes.ReconcileResult{DryRun: opts.DryRun}
+
 	// Re-detect version mismatch each cycle in case tailscaled was updated
 	c.DetectVersionMismatch(ctx)
+	c.RefreshNodeInfo(ctx)
+	c.claimInstance()
+
+	c.beginSnapshot()
+	defer c.endSnapshot()
+
+	if c.advertiseOnly {
+		return c.reconcileAdvertisements(ctx, desiredServices, opts)
+	}
+
+	desiredServices = c.redirectFallbacks(desiredServices)
+	desiredServices = c.checkCollisions(desiredServices, result)
+	desiredServices = dedupeEndpoints(desiredServices)
+	desiredServices = resolveProtocolConflicts(desiredServices)
+	c.recordOwners(desiredServices)
+
+	// Nothing changed since the last cycle applied these services, so the
+	// serve config isn't read again until VERIFY_INTERVAL has passed
+	hash := desiredHash(desiredServices)
+	if c.skipVerify(hash, opts) {
+		verifySkips.Add(1)
+		result.Applied = appliedKeys(c.applied)
+		log.Debug().
+			Time("verified_at", c.verifiedAt).
+			Msg("Desired services unchanged since they were applied, skipping the serve config read")
+		return result, nil
+	}
+	c.forgetVerified()
 
 	serviceDesiredCount := 0
 	for _, svc := range desiredServices {
@@ -146,13 +382,29 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 		Int("desired_count", serviceDesiredCount).
 		Msg("Starting service reconciliation using CLI commands")
 
+	desiredConfig := BuildConfig(desiredServices)
+	c.loadRecordedServices()
+	c.logDesiredConfig(desiredConfig)
+	c.dumpConfig(desiredConfig)
+
+	// An empty desired set is valid (everything is removed); otherwise refuse to
+	// touch a working config with one that Tailscale would reject. Per-service
+	// mode only leaves the invalid services untouched.
+	if len(desiredConfig.Services) > 0 {
+		if c.applyMode == ApplyModePerService {
+			desiredServices = isolateInvalidServices(desiredServices, desiredConfig, result)
+		} else if err := desiredConfig.Validate(); err != nil {
+			return result, err
+		}
+	}
+
 	// Build map of desired services for easy lookup
 	desiredMap := make(map[string]*apptypes.ContainerService)
 	for _, svc := range desiredServices {
 		if !svc.ServiceEnabled {
 			continue
 		}
-		key := fmt.Sprintf("svc:%s:%s", svc.ServiceName, svc.Port)
+		key := serviceKey("svc:"+svc.ServiceName, svc.Port, svc.Path)
 		desiredMap[key] = svc
 	}
 
@@ -161,6 +413,8 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to get current services, will apply all desired services")
 		currentServices = make(map[string]ServiceEndpoint)
+	} else {
+		c.detectExternalChanges(currentServices)
 	}
 
 	log.Info().
@@ -170,6 +424,7 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 	// Track what we need to add and remove
 	toAdd := make(map[string]*apptypes.ContainerService)
 	toRemove := make(map[string]ServiceEndpoint)
+	changed := make(map[string]bool)
 
 	// Find services to add (in desired but not in current, or changed)
 	for key, desired := range desiredMap {
@@ -182,9 +437,11 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 				Msg("Service not found in current state, will add")
 		} else {
 			// Service exists - check if configuration changed
-			expectedDest := buildDestination(desired)
-			if current.Destination != expectedDest || current.Protocol != desired.ServiceProtocol {
+			expectedDest := BuildDestination(desired)
+			if current.Destination != expectedDest || current.Protocol != endpointProtocol(desired.ServiceProtocol) ||
+				current.ProxyProtocol != desired.ProxyProtocol {
 				toAdd[key] = desired
+				changed[key] = true
 				log.Info().
 					Str("key", key).
 					Str("service", desired.ServiceName).
@@ -192,6 +449,8 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 					Str("expected_dest", expectedDest).
 					Str("current_protocol", current.Protocol).
 					Str("expected_protocol", desired.ServiceProtocol).
+					Str("current_proxy_protocol", current.ProxyProtocol).
+					Str("expected_proxy_protocol", desired.ProxyProtocol).
 					Msg("Service configuration changed, will update")
 			} else {
 				// Service exists and matches - no action needed
@@ -205,6 +464,15 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 		}
 	}
 
+	// Services of containers DockTail is told not to manage are left untouched
+	preserved := make(map[string]struct{})
+	for _, svc := range desiredServices {
+		if svc.Preserve {
+			preserved["svc:"+svc.ServiceName] = struct{}{}
+		}
+	}
+	c.setLastDiff(DiffConfigs(c.endpointsConfig(currentServices, preserved), desiredConfig))
+
 	// Find services to remove (in current but not in desired)
 	for key, current := range currentServices {
 		if _, exists := desiredMap[key]; !exists {
@@ -215,29 +483,128 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 					Msg("Skipping removal for ignored service")
 				continue
 			}
+			if _, ok := preserved[current.ServiceName]; ok {
+				log.Info().
+					Str("service", current.ServiceName).
+					Str("port", current.Port).
+					Msg("Skipping removal for service of an unmanaged container")
+				continue
+			}
 			toRemove[key] = current
 		}
 	}
 
+	// Services that keep at least one endpoint only lose the stale handlers
+	stillDesired := make(map[string]struct{})
+	for _, svc := range desiredMap {
+		stillDesired["svc:"+svc.ServiceName] = struct{}{}
+	}
+
+	// Maintenance defers every removal, so the ownership check waits for a
+	// cycle that carries them out. Services whose containers stopped are
+	// drained rather than removed until the grace period ends or a container
+	// is destroyed.
+	now := time.Now()
+	var held []string
+	if opts.Maintenance {
+		result.Deferred = deferRemovals(toRemove)
+	} else {
+		result.Deferred = c.holdUnmanagedRemovals(toRemove, stillDesired, opts.DryRun)
+		held = c.holdRemovals(toRemove, stillDesired, opts.Destroyed, now)
+	}
+
 	log.Info().
 		Int("to_add", len(toAdd)).
 		Int("to_remove", len(toRemove)).
+		Int("to_drain", len(held)).
 		Msg("Calculated reconciliation actions")
 
-	// Remove old services first
+	if opts.DryRun {
+		for key, svc := range toAdd {
+			if changed[key] {
+				result.Changed = append(result.Changed, key)
+			} else {
+				result.Added = append(result.Added, key)
+			}
+			log.Info().
+				Str("key", key).
+				Str("service", svc.ServiceName).
+				Str("container", svc.ContainerName).
+				Bool("update", changed[key]).
+				Msg("Dry run: would add service")
+			if args, err := buildServeArgs(svc); err == nil {
+				c.planCommand(args...)
+			}
+		}
+		planned := make(map[string]bool)
+		for key, svc := range toRemove {
+			result.Removed = append(result.Removed, key)
+			log.Info().
+				Str("key", key).
+				Str("service", svc.ServiceName).
+				Msg("Dry run: would remove service")
+			if _, keep := stillDesired[svc.ServiceName]; keep {
+				if args, err := removeHandlerArgs(svc); err == nil {
+					c.planCommand(args...)
+				}
+			} else if !planned[svc.ServiceName] {
+				planned[svc.ServiceName] = true
+				c.planCommand("serve", "drain", svc.ServiceName)
+				c.planCommand("serve", "clear", svc.ServiceName)
+			}
+		}
+		for _, serviceName := range held {
+			if _, ok := c.drainedAt[serviceName]; !ok {
+				log.Info().
+					Str("service", serviceName).
+					Msg("Dry run: would drain service of stopped containers")
+				c.planCommand("serve", "drain", serviceName)
+			}
+		}
+		sortResult(result)
+
+		if err := c.applyAdvertiseLabels(ctx, desiredServices, opts); err != nil {
+			log.Error().Err(err).Msg("Failed to apply advertise labels")
+		}
+		if err := c.reconcileFunnels(ctx, desiredServices, opts, result); err != nil {
+			return result, fmt.Errorf("funnel reconciliation failed: %w", err)
+		}
+		return result, nil
+	}
+
+	c.forgetDrained(stillDesired, currentServices)
+	c.drainHeldServices(ctx, held, now)
+
+	// Remove old services first. A service that goes away entirely is drained
+	// and cleared once, which removes all of its endpoints together.
+	cleared := make(map[string]struct{})
 	for key, svc := range toRemove {
 		log.Info().
 			Str("service", svc.ServiceName).
 			Str("port", svc.Port).
+			Str("path", svc.Path).
 			Msg("Removing service")
 
-		if err := c.removeService(ctx, svc.ServiceName); err != nil {
+		var err error
+		if _, keep := stillDesired[svc.ServiceName]; keep {
+			err = c.removeHandler(ctx, svc)
+		} else if _, done := cleared[svc.ServiceName]; !done {
+			err = c.removeService(ctx, svc.ServiceName)
+			if err == nil {
+				cleared[svc.ServiceName] = struct{}{}
+				delete(c.advertised, svc.ServiceName)
+				delete(c.drainedAt, svc.ServiceName)
+				delete(c.owners, svc.ServiceName)
+			}
+		}
+		if err != nil {
 			log.Error().
 				Err(err).
 				Str("service", svc.ServiceName).
 				Msg("Failed to remove service")
 			// Continue with other services
 		} else {
+			result.Removed = append(result.Removed, key)
 			log.Info().
 				Str("key", key).
 				Str("service", svc.ServiceName).
@@ -261,37 +628,73 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 
 		if err := c.addService(ctx, svc); err != nil {
 			failCount++
+			recordFailure(result, key, err)
 			log.Error().
 				Err(err).
 				Str("service", svc.ServiceName).
 				Str("container", svc.ContainerName).
 				Msg("Failed to add service")
+			c.reporter.Report(ctx, err, map[string]string{
+				"service":     svc.ServiceName,
+				"key":         key,
+				"destination": BuildDestination(svc),
+				"container":   svc.ContainerName,
+			})
 			// Continue with other services
 		} else {
 			successCount++
-			log.Info().
+			// Adding a handler advertises the service again
+			c.advertised["svc:"+svc.ServiceName] = true
+			if changed[key] {
+				result.Changed = append(result.Changed, key)
+			} else {
+				result.Added = append(result.Added, key)
+			}
+			event := log.Info().
 				Str("key", key).
 				Str("service", svc.ServiceName).
-				Str("container", svc.ContainerName).
-				Msg("Successfully added service")
+				Str("container", svc.ContainerName)
+			if url := c.serviceURL(svc); url != "" {
+				event = event.Str("url", url)
+			}
+			if svc.AliasOf != "" {
+				event = event.Str("alias_of", "svc:"+svc.AliasOf)
+			}
+			event.Msg("Successfully added service")
+
+			if svc.ServiceProtocol == "https" {
+				c.prefetchCert(svc.ServiceName)
+			}
 		}
 	}
 
+	c.recordApplied(desiredMap, result.Failed)
+	result.Applied = appliedKeys(c.applied)
+
 	log.Info().
 		Int("added", successCount).
 		Int("failed", failCount).
 		Int("removed", len(toRemove)).
 		Msg("Service reconciliation completed")
 
-	if failCount > 0 {
-		return fmt.Errorf("failed to add %d services", failCount)
+	sortResult(result)
+
+	// Advertisement failures don't affect serving, so they don't fail the cycle
+	if err := c.applyAdvertiseLabels(ctx, desiredServices, opts); err != nil {
+		log.Error().Err(err).Msg("Failed to apply advertise labels")
+	}
+
+	// Per-service mode carries on with funnels and the API sync, and fails the
+	// cycle at the end
+	if failCount > 0 && c.applyMode != ApplyModePerService {
+		return result, fmt.Errorf("failed to add %d services", failCount)
 	}
 
 	// Reconcile funnel configuration (independent of serve)
 	// Funnel and serve are separate features that can be used together or independently
-	if err := c.reconcileFunnels(ctx, desiredServices); err != nil {
+	if err := c.reconcileFunnels(ctx, desiredServices, opts, result); err != nil {
 		log.Error().Err(err).Msg("Failed to reconcile funnel configurations")
-		return fmt.Errorf("funnel reconciliation failed: %w", err)
+		return result, fmt.Errorf("funnel reconciliation failed: %w", err)
 	}
 
 	// Sync Service Definitions to Control Plane (API)
@@ -304,7 +707,40 @@ func (c *Client) ReconcileServices(ctx context.Context, desiredServices []*appty
 		}
 	}
 
-	return nil
+	if len(result.Failed) > 0 {
+		return result, failedError(result)
+	}
+	// Deferred and held removals are still to be made
+	if !opts.Maintenance && len(result.Deferred) == 0 && len(held) == 0 {
+		c.markVerified(hash)
+	}
+	return result, nil
+}
+
+// sortResult orders the service keys in a result for deterministic output
+func sortResult(result *apptypes.ReconcileResult) {
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Changed)
+	sort.Strings(result.Deferred)
+}
+
+// deferRemovals empties toRemove for maintenance mode and returns the keys
+// whose removal it deferred
+func deferRemovals(toRemove map[string]ServiceEndpoint) []string {
+	if len(toRemove) == 0 {
+		return nil
+	}
+	deferred := make([]string, 0, len(toRemove))
+	for key := range toRemove {
+		deferred = append(deferred, key)
+		delete(toRemove, key)
+	}
+	sort.Strings(deferred)
+	log.Warn().
+		Strs("services", deferred).
+		Msg("Maintenance mode: deferring service removals until it ends")
+	return deferred
 }
 
 // syncServiceDefinitions syncs all desired services to the Tailscale Control Plane
@@ -497,7 +933,13 @@ func (c *Client) getService(ctx context.Context, serviceName string) (*apiServic
 // CleanupAllServices removes all services and funnels managed by DockTail
 // This is called on shutdown to ensure no orphaned services remain advertised
 func (c *Client) CleanupAllServices(ctx context.Context) error {
+	if c.advertiseOnly {
+		log.Info().Msg("Starting cleanup: draining services advertised by DockTail")
+		return c.drainAdvertisedServices(ctx)
+	}
+
 	log.Info().Msg("Starting cleanup: removing all managed Tailscale services and funnels")
+	c.applied = nil
 
 	var totalErrors []error
 	funnelsCleaned := 0
@@ -535,6 +977,7 @@ func (c *Client) CleanupAllServices(ctx context.Context) error {
 			} else {
 				funnelsCleaned = len(currentFunnels)
 				c.managedFunnels = make(map[string]struct{})
+				c.saveManagedFunnels()
 			}
 		}
 	}
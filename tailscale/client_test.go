@@ -0,0 +1,550 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestReconcileServicesLeavesPreservedServices(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:legacy":{"TCP":{"443":{"HTTPS":true}},"Web":{"legacy.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.9:8080"}}}}},
+		"svc:gone":{"TCP":{"443":{"HTTPS":true}},"Web":{"gone.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.8:8080"}}}}}
+	}}`)
+
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{
+		{ContainerID: "abcdef123456", ContainerName: "legacy", ServiceName: "legacy", Preserve: true},
+	}
+
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+
+	if want := []string{"svc:gone:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	for _, call := range fake.calls() {
+		if strings.Contains(call, "svc:legacy") {
+			t.Errorf("preserved service was touched: %q", call)
+		}
+	}
+}
+
+func TestReconcileServicesAdvertiseOnly(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.5:8080"}}}}},
+		"svc:old":{"TCP":{"443":{"HTTPS":true}},"Web":{"old.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.6:8080"}}}}}
+	}}`)
+
+	client := NewClient(ClientConfig{AdvertiseOnly: true})
+	desired := []*apptypes.ContainerService{
+		// The destination differs from the serve config, which must be left alone
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "80"},
+		{ContainerName: "pub", IPAddress: "172.17.0.4", FunnelEnabled: true, FunnelTargetPort: "80", FunnelFunnelPort: "443", FunnelProtocol: "https"},
+	}
+
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:web"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("Added = %v, want %v", result.Added, want)
+	}
+	if want := []string{"svc:old"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+
+	// A second pass with unchanged containers issues no commands, and stopping
+	// the container drains its service
+	desired = desired[1:]
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+
+	var mutating []string
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "serve --service") || strings.HasPrefix(call, "serve clear") ||
+			strings.HasPrefix(call, "serve set-config") || strings.HasPrefix(call, "funnel") {
+			t.Errorf("advertise-only mode changed serve config: %q", call)
+		}
+		if strings.HasPrefix(call, "serve advertise") || strings.HasPrefix(call, "serve drain") {
+			mutating = append(mutating, call)
+		}
+	}
+	want := []string{"serve drain svc:old", "serve advertise svc:web", "serve drain svc:web"}
+	if !reflect.DeepEqual(mutating, want) {
+		t.Errorf("advertisement calls = %v, want %v", mutating, want)
+	}
+}
+
+func TestGetCurrentServicesParsesPaths(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:tools":{"TCP":{"443":{"HTTPS":true}},"Web":{"tools.ts.net:443":{"Handlers":{
+			"/":{"Proxy":"http://172.17.0.6:8080"},
+			"/grafana/":{"Proxy":"http://172.17.0.4:3000"},
+			"/prometheus":{"Proxy":"http://172.17.0.5:9090"}
+		}},"tools.ts.net:4430":{"Handlers":{"/":{"Proxy":"http://172.17.0.9:1"}}}}},
+		"svc:db":{"TCP":{"5432":{}}}
+	}}`)
+
+	services, err := NewClient(ClientConfig{}).GetCurrentServices(context.Background())
+	if err != nil {
+		t.Fatalf("GetCurrentServices() error = %v", err)
+	}
+
+	want := map[string]ServiceEndpoint{
+		"svc:tools:443":            {ServiceName: "svc:tools", Port: "443", Protocol: "https", Destination: "http://172.17.0.6:8080"},
+		"svc:tools:443/grafana":    {ServiceName: "svc:tools", Port: "443", Protocol: "https", Destination: "http://172.17.0.4:3000", Path: "/grafana"},
+		"svc:tools:443/prometheus": {ServiceName: "svc:tools", Port: "443", Protocol: "https", Destination: "http://172.17.0.5:9090", Path: "/prometheus"},
+		"svc:db:5432":              {ServiceName: "svc:db", Port: "5432", Protocol: "tcp"},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Errorf("GetCurrentServices() = %+v, want %+v", services, want)
+	}
+}
+
+func TestGetCurrentServicesParsesStaticHandlers(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:health":{"TCP":{"443":{"HTTPS":true}},"Web":{"health.ts.net:443":{"Handlers":{
+			"/":{"Text":"ok"},
+			"/static":{"Path":"/srv/www"}
+		}}}}
+	}}`)
+
+	client := NewClient(ClientConfig{})
+	services, err := client.GetCurrentServices(context.Background())
+	if err != nil {
+		t.Fatalf("GetCurrentServices() error = %v", err)
+	}
+
+	want := map[string]ServiceEndpoint{
+		"svc:health:443":        {ServiceName: "svc:health", Port: "443", Protocol: "https", Destination: "text:ok"},
+		"svc:health:443/static": {ServiceName: "svc:health", Port: "443", Protocol: "https", Destination: "/srv/www", Path: "/static"},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Errorf("GetCurrentServices() = %+v, want %+v", services, want)
+	}
+
+	// The desired services match the status, so reconciling must not rewrite them
+	desired := []*apptypes.ContainerService{
+		{ServiceEnabled: true, ServiceName: "health", Port: "443", ServiceProtocol: "https", Handler: apptypes.HandlerText, HandlerContent: "ok"},
+		{ServiceEnabled: true, ServiceName: "health", Port: "443", ServiceProtocol: "https", Path: "/static", Handler: apptypes.HandlerFile, HandlerContent: "/srv/www"},
+	}
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if len(result.Added) != 0 || len(result.Changed) != 0 || len(result.Removed) != 0 {
+		t.Errorf("ReconcileServices() = %+v, want no changes", result)
+	}
+}
+
+func TestReconcileServicesRemovesOnlyStalePaths(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:tools":{"TCP":{"443":{"HTTPS":true}},"Web":{"tools.ts.net:443":{"Handlers":{
+			"/grafana":{"Proxy":"http://172.17.0.4:3000"},
+			"/old":{"Proxy":"http://172.17.0.8:80"}
+		}}}}
+	}}`)
+
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "grafana", ServiceEnabled: true, ServiceName: "tools", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.4", TargetPort: "3000", Path: "/grafana"},
+		{ContainerName: "prometheus", ServiceEnabled: true, ServiceName: "tools", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.5", TargetPort: "9090", Path: "/prometheus"},
+	}
+
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:tools:443/prometheus"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("Added = %v, want %v", result.Added, want)
+	}
+	if want := []string{"svc:tools:443/old"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+
+	calls := strings.Join(fake.calls(), "\n")
+	if !strings.Contains(calls, "serve --service=svc:tools --https=443 --set-path=/old off") {
+		t.Errorf("stale path was not turned off, calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "serve clear") || strings.Contains(calls, "serve drain") {
+		t.Errorf("service with remaining paths was cleared, calls:\n%s", calls)
+	}
+}
+
+func TestReconcileServicesRedirectHTTP(t *testing.T) {
+	web := func(port, protocol, handler, content string) *apptypes.ContainerService {
+		return &apptypes.ContainerService{
+			ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: port, ServiceProtocol: protocol,
+			Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080", Handler: handler, HandlerContent: content,
+		}
+	}
+	desired := []*apptypes.ContainerService{
+		web("443", "https", "", ""),
+		web("80", "http", apptypes.HandlerRedirect, "https://${HOST}${REQUEST_URI}"),
+	}
+
+	t.Run("redirect handler", func(t *testing.T) {
+		fake := installFakeTailscale(t)
+
+		result, err := NewClient(ClientConfig{}).ReconcileServices(context.Background(), desired, ReconcileOptions{})
+		if err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		if want := []string{"svc:web:443", "svc:web:80"}; !reflect.DeepEqual(result.Added, want) {
+			t.Errorf("Added = %v, want %v", result.Added, want)
+		}
+		calls := strings.Join(fake.calls(), "\n")
+		if !strings.Contains(calls, "serve --service=svc:web --http=80 redirect:https://${HOST}${REQUEST_URI}") {
+			t.Errorf("redirect endpoint was not added, calls:\n%s", calls)
+		}
+	})
+
+	t.Run("falls back to the backend", func(t *testing.T) {
+		fake := installFakeTailscale(t)
+		if err := os.WriteFile(filepath.Join(fake.dir, "no-redirect"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		client := NewClient(ClientConfig{})
+		if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		calls := strings.Join(fake.calls(), "\n")
+		if !strings.Contains(calls, "serve --service=svc:web --http=80 http://172.17.0.2:8080") {
+			t.Errorf("redirect endpoint did not fall back to the backend, calls:\n%s", calls)
+		}
+
+		// Once the fallback is active it is compared against the status as a proxy
+		fake.setServeStatus(t, `{"Services":{"svc:web":{
+			"TCP":{"443":{"HTTPS":true},"80":{"HTTP":true}},
+			"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:8080"}}},
+				"web.ts.net:80":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:8080"}}}}
+		}}}`)
+		result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+		if err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		if len(result.Added) != 0 || len(result.Changed) != 0 {
+			t.Errorf("ReconcileServices() = %+v, want no changes", result)
+		}
+	})
+
+	t.Run("removed together", func(t *testing.T) {
+		fake := installFakeTailscale(t)
+		fake.setServeStatus(t, `{"Services":{"svc:web":{
+			"TCP":{"443":{"HTTPS":true},"80":{"HTTP":true}},
+			"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:8080"}}},
+				"web.ts.net:80":{"Handlers":{"/":{"Redirect":"https://${HOST}${REQUEST_URI}"}}}}
+		}}}`)
+
+		result, err := NewClient(ClientConfig{}).ReconcileServices(context.Background(), nil, ReconcileOptions{})
+		if err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		if want := []string{"svc:web:443", "svc:web:80"}; !reflect.DeepEqual(result.Removed, want) {
+			t.Errorf("Removed = %v, want %v", result.Removed, want)
+		}
+		var clears int
+		for _, call := range fake.calls() {
+			if strings.HasPrefix(call, "serve clear svc:web") {
+				clears++
+			}
+		}
+		if clears != 1 {
+			t.Errorf("service cleared %d times, want once; calls:\n%s", clears, strings.Join(fake.calls(), "\n"))
+		}
+	})
+
+	t.Run("redirect dropped alone", func(t *testing.T) {
+		fake := installFakeTailscale(t)
+		fake.setServeStatus(t, `{"Services":{"svc:web":{
+			"TCP":{"443":{"HTTPS":true},"80":{"HTTP":true}},
+			"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:8080"}}},
+				"web.ts.net:80":{"Handlers":{"/":{"Redirect":"https://${HOST}${REQUEST_URI}"}}}}
+		}}}`)
+
+		if _, err := NewClient(ClientConfig{}).ReconcileServices(context.Background(), desired[:1], ReconcileOptions{}); err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		calls := strings.Join(fake.calls(), "\n")
+		if !strings.Contains(calls, "serve --service=svc:web --http=80 off") {
+			t.Errorf("redirect endpoint was not turned off, calls:\n%s", calls)
+		}
+		if strings.Contains(calls, "serve clear") {
+			t.Errorf("service with a remaining endpoint was cleared, calls:\n%s", calls)
+		}
+	})
+}
+
+func TestReconcileServicesRemovesDroppedAliases(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:grafana":{"TCP":{"443":{"HTTPS":true}},"Web":{"grafana.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.4:3000"}}}}},
+		"svc:monitoring":{"TCP":{"443":{"HTTPS":true}},"Web":{"monitoring.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.4:3000"}}}}}
+	}}`)
+
+	// The aliases label was removed, so only the primary service is desired
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "grafana", ServiceEnabled: true, ServiceName: "grafana", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.4", TargetPort: "3000"},
+	}
+
+	result, err := NewClient(ClientConfig{}).ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:monitoring:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	if len(result.Added) != 0 || len(result.Changed) != 0 {
+		t.Errorf("primary service should be untouched, got %+v", result)
+	}
+
+	calls := strings.Join(fake.calls(), "\n")
+	if !strings.Contains(calls, "serve clear svc:monitoring") {
+		t.Errorf("alias service was not cleared, calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "svc:grafana") {
+		t.Errorf("primary service was touched, calls:\n%s", calls)
+	}
+}
+
+func TestReconcileServicesUnadvertised(t *testing.T) {
+	fake := installFakeTailscale(t)
+	client := NewClient(ClientConfig{})
+
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80", Unadvertised: true},
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "80"},
+	}
+
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:api:443", "svc:web:443"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("Added = %v, want %v", result.Added, want)
+	}
+
+	calls := strings.Join(fake.calls(), "\n")
+	if !strings.Contains(calls, "serve --service=svc:web") {
+		t.Errorf("unadvertised service was not configured, calls:\n%s", calls)
+	}
+	if !strings.Contains(calls, "serve drain svc:web") {
+		t.Errorf("unadvertised service was not drained, calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "drain svc:api") || strings.Contains(calls, "serve advertise") {
+		t.Errorf("advertised service should be left to serve, calls:\n%s", calls)
+	}
+
+	// Once configured and drained, later cycles leave the advertisement alone
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}},
+		"svc:api":{"TCP":{"443":{"HTTPS":true}},"Web":{"api.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.3:80"}}}}}
+	}}`)
+	before := len(fake.calls())
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if later := strings.Join(fake.calls()[before:], "\n"); strings.Contains(later, "drain") || strings.Contains(later, "advertise") {
+		t.Errorf("advertisement changed again, calls:\n%s", later)
+	}
+
+	// Dropping the label advertises the service without touching its config
+	desired[0].Unadvertised = false
+	before = len(fake.calls())
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	later := strings.Join(fake.calls()[before:], "\n")
+	if !strings.Contains(later, "serve advertise svc:web") {
+		t.Errorf("service was not advertised again, calls:\n%s", later)
+	}
+	if strings.Contains(later, "serve --service") {
+		t.Errorf("serve config was rewritten, calls:\n%s", later)
+	}
+}
+
+func TestReconcileServicesServiceNamePrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefix      string
+		service     string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{"without prefix every service is managed", "", "api", []string{"svc:api:443"}, []string{"svc:nas-web:443", "svc:web:443"}},
+		{"with prefix other services are left alone", "nas-", "nas-api", []string{"svc:nas-api:443"}, []string{"svc:nas-web:443"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeTailscale(t).setServeStatus(t, `{"Services":{
+				"svc:nas-web":{"TCP":{"443":{"HTTPS":true}},"Web":{"nas-web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}},
+				"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.5:80"}}}}}
+			}}`)
+
+			desired := []*apptypes.ContainerService{
+				{ContainerName: "api", ServiceEnabled: true, ServiceName: tt.service, Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "80"},
+			}
+
+			client := NewClient(ClientConfig{ServiceNamePrefix: tt.prefix})
+			result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+			if err != nil {
+				t.Fatalf("ReconcileServices() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Added, tt.wantAdded) {
+				t.Errorf("Added = %v, want %v", result.Added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(result.Removed, tt.wantRemoved) {
+				t.Errorf("Removed = %v, want %v", result.Removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestReconcileServicesRenamedService(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:oldname":{"TCP":{"443":{"HTTPS":true}},"Web":{"oldname.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}}
+	}}`)
+
+	// The container's service label changed from oldname to newname
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "newname", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+	}
+
+	result, err := NewClient(ClientConfig{}).ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:oldname:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	if want := []string{"svc:newname:443"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("Added = %v, want %v", result.Added, want)
+	}
+
+	// The old name is un-advertised and cleared before the new one is added
+	var order []string
+	for _, call := range fake.calls() {
+		switch {
+		case call == "serve drain svc:oldname", call == "serve clear svc:oldname":
+			order = append(order, call)
+		case strings.HasPrefix(call, "serve --service=svc:newname"):
+			order = append(order, "add svc:newname")
+		}
+	}
+	if want := []string{"serve drain svc:oldname", "serve clear svc:oldname", "add svc:newname"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("calls = %v, want %v", order, want)
+	}
+}
+
+func TestReconcileServicesReplicaScaleDown(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{"svc:api":{
+		"TCP":{"80":{"HTTP":true},"81":{"HTTP":true},"82":{"HTTP":true}},
+		"Web":{
+			"api.ts.net:80":{"Handlers":{"/":{"Proxy":"http://localhost:18081"}}},
+			"api.ts.net:81":{"Handlers":{"/":{"Proxy":"http://localhost:18082"}}},
+			"api.ts.net:82":{"Handlers":{"/":{"Proxy":"http://localhost:18083"}}}
+		}
+	}}}`)
+
+	// Scaled from three replicas to two
+	replica := func(n int) *apptypes.ContainerService {
+		return &apptypes.ContainerService{
+			ContainerName: fmt.Sprintf("shop-api-%d", n), ServiceEnabled: true, ServiceName: "api",
+			Port: fmt.Sprint(79 + n), ServiceProtocol: "http", Protocol: "http", IPAddress: "localhost", TargetPort: fmt.Sprint(18080 + n),
+		}
+	}
+	desired := []*apptypes.ContainerService{replica(1), replica(2)}
+
+	result, err := NewClient(ClientConfig{}).ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:api:82"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	if len(result.Added) != 0 || len(result.Changed) != 0 {
+		t.Errorf("remaining replicas should be untouched, got %+v", result)
+	}
+
+	calls := strings.Join(fake.calls(), "\n")
+	if !strings.Contains(calls, "serve --service=svc:api --http=82 off") {
+		t.Errorf("departed replica's handler was not removed, calls:\n%s", calls)
+	}
+	if strings.Contains(calls, "serve clear") || strings.Contains(calls, "serve drain") {
+		t.Errorf("service was cleared instead of losing one handler, calls:\n%s", calls)
+	}
+}
+
+func TestReconcileServicesProtocolConflictIsStable(t *testing.T) {
+	alpha := &apptypes.ContainerService{
+		ContainerID: "aaaaaaaaaaaa", ContainerName: "alpha", ServiceEnabled: true, ServiceName: "mixed",
+		Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080", Path: "/a",
+	}
+	beta := &apptypes.ContainerService{
+		ContainerID: "bbbbbbbbbbbb", ContainerName: "beta", ServiceEnabled: true, ServiceName: "mixed",
+		Port: "443", ServiceProtocol: "http", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "8080", Path: "/b",
+	}
+
+	for name, desired := range map[string][]*apptypes.ContainerService{
+		"alpha first": {alpha, beta},
+		"beta first":  {beta, alpha},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			// The losing endpoint is what tailscale serve currently has
+			fake.setServeStatus(t, `{"Services":{"svc:mixed":{"TCP":{"443":{"HTTP":true}},
+				"Web":{"mixed.ts.net:443":{"Handlers":{"/b":{"Proxy":"http://172.17.0.3:8080"}}}}}}}`)
+			client := NewClient(ClientConfig{})
+
+			result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+			if err != nil {
+				t.Fatalf("ReconcileServices() error = %v", err)
+			}
+			if want := []string{"svc:mixed:443/a"}; !reflect.DeepEqual(result.Added, want) {
+				t.Errorf("Added = %v, want %v", result.Added, want)
+			}
+			if want := []string{"svc:mixed:443/b"}; !reflect.DeepEqual(result.Removed, want) {
+				t.Errorf("Removed = %v, want %v", result.Removed, want)
+			}
+
+			// Further cycles keep the winner instead of flipping between the two
+			fake.setServeStatus(t, `{"Services":{"svc:mixed":{"TCP":{"443":{"HTTPS":true}},
+				"Web":{"mixed.ts.net:443":{"Handlers":{"/a":{"Proxy":"http://172.17.0.2:8080"}}}}}}}`)
+			for cycle := 0; cycle < 3; cycle++ {
+				result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+				if err != nil {
+					t.Fatalf("ReconcileServices() error = %v", err)
+				}
+				if len(result.Added) != 0 || len(result.Changed) != 0 || len(result.Removed) != 0 {
+					t.Errorf("cycle %d = %+v, want no changes", cycle, result)
+				}
+			}
+
+			for _, call := range fake.calls() {
+				if strings.Contains(call, "--http=443 --set-path=/b http") || strings.HasPrefix(call, "serve clear") {
+					t.Errorf("losing endpoint was applied: %q", call)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package tailscale
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Policies for desired services that another node already advertises
+const (
+	// CollisionPolicyWarn logs the collision and configures the service anyway
+	CollisionPolicyWarn = "warn"
+	// CollisionPolicySkip leaves the service's local config as it is, so a
+	// service the other node owns is not added here
+	CollisionPolicySkip = "skip"
+)
+
+// ParseCollisionPolicy validates a SERVICE_COLLISION_POLICY value
+func ParseCollisionPolicy(value string) (string, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(value)); policy {
+	case CollisionPolicyWarn, CollisionPolicySkip:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown collision policy %q: must be %s or %s", value, CollisionPolicyWarn, CollisionPolicySkip)
+	}
+}
+
+// serviceHostCap is the node capability listing the services a node hosts
+const serviceHostCap = "service-host"
+
+// collisionsVar exposes the services that other nodes also advertise, with the
+// number of such nodes
+var collisionsVar = expvar.NewMap("docktail_service_collisions")
+
+// peerStatus is the subset of 'tailscale status --json' describing peers
+type peerStatus struct {
+	Peer map[string]struct {
+		HostName string                       `json:"HostName"`
+		DNSName  string                       `json:"DNSName"`
+		CapMap   map[string][]json.RawMessage `json:"CapMap"`
+	} `json:"Peer"`
+}
+
+// parsePeerServices returns the peers advertising each service, keyed by
+// service name, from 'tailscale status --json' output. Peers list their
+// services in the service-host capability as {"svc:name": [addresses]}.
+func parsePeerServices(output []byte) (map[string][]string, error) {
+	var status peerStatus
+	if err := json.Unmarshal([]byte(stripWarnings(output)), &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status JSON: %w", err)
+	}
+
+	services := make(map[string][]string)
+	for _, peer := range status.Peer {
+		name := strings.TrimSuffix(peer.DNSName, ".")
+		if name == "" {
+			name = peer.HostName
+		}
+		for _, raw := range peer.CapMap[serviceHostCap] {
+			var mappings map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &mappings); err != nil {
+				continue
+			}
+			for serviceName := range mappings {
+				if isManagedService(serviceName) {
+					services[serviceName] = append(services[serviceName], name)
+				}
+			}
+		}
+	}
+
+	for _, peers := range services {
+		sort.Strings(peers)
+	}
+	return services, nil
+}
+
+// checkCollisions records desired services that other nodes already advertise.
+// With the skip policy such services are preserved instead of configured, so
+// neither a new config is added nor an existing one removed.
+func (c *Client) checkCollisions(desiredServices []*apptypes.ContainerService, result *apptypes.ReconcileResult) []*apptypes.ContainerService {
+	c.nodeMu.RLock()
+	peerServices := c.peerServices
+	c.nodeMu.RUnlock()
+
+	collisions := make(map[string][]string)
+	for _, svc := range desiredServices {
+		serviceName := "svc:" + svc.ServiceName
+		if !svc.ServiceEnabled || c.shouldIgnoreService(serviceName) {
+			continue
+		}
+		if peers := peerServices[serviceName]; len(peers) > 0 {
+			collisions[serviceName] = peers
+		}
+	}
+
+	for serviceName := range c.collisions {
+		if _, ok := collisions[serviceName]; !ok {
+			collisionsVar.Delete(serviceName)
+		}
+	}
+	c.collisions = collisions
+
+	if len(collisions) == 0 {
+		return desiredServices
+	}
+
+	for serviceName, peers := range collisions {
+		count := new(expvar.Int)
+		count.Set(int64(len(peers)))
+		collisionsVar.Set(serviceName, count)
+		result.Collisions = append(result.Collisions, serviceName)
+		log.Warn().
+			Str("service", serviceName).
+			Strs("peers", peers).
+			Str("policy", c.collisionPolicy).
+			Msg("Service is already advertised by another node in the tailnet")
+	}
+	sort.Strings(result.Collisions)
+
+	if c.collisionPolicy != CollisionPolicySkip {
+		return desiredServices
+	}
+
+	filtered := make([]*apptypes.ContainerService, 0, len(desiredServices))
+	for _, svc := range desiredServices {
+		if _, ok := collisions["svc:"+svc.ServiceName]; ok && svc.ServiceEnabled {
+			preserved := *svc
+			preserved.ServiceEnabled = false
+			preserved.Preserve = true
+			svc = &preserved
+		}
+		filtered = append(filtered, svc)
+	}
+	return filtered
+}
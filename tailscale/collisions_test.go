@@ -0,0 +1,138 @@
+package tailscale
+
+import (
+	"reflect"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// peerStatusFixture is 'tailscale status --json' output with two peers hosting
+// services and one that hosts none
+const peerStatusFixture = `{
+	"Self": {"DNSName": "nas.tail1234.ts.net.", "CapMap": {"service-host": [{"svc:web": ["100.100.1.1"]}]}},
+	"Peer": {
+		"nodekey:aaa": {
+			"HostName": "pi",
+			"DNSName": "pi.tail1234.ts.net.",
+			"CapMap": {"service-host": [{"svc:gitea": ["100.100.1.2", "fd7a:115c:a1e0::2"], "svc:grafana": ["100.100.1.3"]}]}
+		},
+		"nodekey:bbb": {
+			"HostName": "vps",
+			"DNSName": "",
+			"CapMap": {"service-host": [{"svc:gitea": ["100.100.1.2"]}], "https://tailscale.com/cap/funnel": null}
+		},
+		"nodekey:ccc": {"HostName": "laptop", "DNSName": "laptop.tail1234.ts.net."}
+	}
+}`
+
+func TestParsePeerServices(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		expected  map[string][]string
+		wantError bool
+	}{
+		{
+			name:   "peers hosting services",
+			output: peerStatusFixture,
+			expected: map[string][]string{
+				"svc:gitea":   {"pi.tail1234.ts.net", "vps"},
+				"svc:grafana": {"pi.tail1234.ts.net"},
+			},
+		},
+		{
+			name:     "leading warning",
+			output:   "Warning: client version \"1.80.0\" != tailscaled server version \"1.82.0\"\n" + `{"Peer":{"nodekey:aaa":{"HostName":"pi","CapMap":{"service-host":[{"svc:web":[]}]}}}}`,
+			expected: map[string][]string{"svc:web": {"pi"}},
+		},
+		{
+			name:     "malformed capability ignored",
+			output:   `{"Peer":{"nodekey:aaa":{"HostName":"pi","CapMap":{"service-host":["svc:web"]}}}}`,
+			expected: map[string][]string{},
+		},
+		{name: "no peers", output: `{"Self":{"DNSName":"nas.tail1234.ts.net."}}`, expected: map[string][]string{}},
+		{name: "invalid JSON", output: `not json`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePeerServices([]byte(tt.output))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("parsePeerServices() error = %v, wantError %v", err, tt.wantError)
+			}
+			if !tt.wantError && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parsePeerServices() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{"warn", CollisionPolicyWarn, false},
+		{" Skip ", CollisionPolicySkip, false},
+		{"ignore", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseCollisionPolicy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCollisionPolicy(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseCollisionPolicy(%q) = %q, want %q", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckCollisions(t *testing.T) {
+	peerServices, err := parsePeerServices([]byte(peerStatusFixture))
+	if err != nil {
+		t.Fatalf("parsePeerServices() error = %v", err)
+	}
+
+	tests := []struct {
+		policy        string
+		wantPreserved bool
+	}{
+		{CollisionPolicyWarn, false},
+		{CollisionPolicySkip, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			client := NewClient(ClientConfig{CollisionPolicy: tt.policy})
+			client.peerServices = peerServices
+
+			desired := []*apptypes.ContainerService{
+				{ContainerName: "gitea", ServiceEnabled: true, ServiceName: "gitea", Port: "443"},
+				{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443"},
+			}
+			result := &apptypes.ReconcileResult{}
+			got := client.checkCollisions(desired, result)
+
+			if want := []string{"svc:gitea"}; !reflect.DeepEqual(result.Collisions, want) {
+				t.Errorf("Collisions = %v, want %v", result.Collisions, want)
+			}
+			if got[0].Preserve != tt.wantPreserved || got[0].ServiceEnabled == tt.wantPreserved {
+				t.Errorf("colliding service = %+v, want preserved %v", got[0], tt.wantPreserved)
+			}
+			if got[1].Preserve || !got[1].ServiceEnabled {
+				t.Errorf("service only this node hosts was changed: %+v", got[1])
+			}
+			if desired[0].Preserve {
+				t.Error("checkCollisions modified the caller's service")
+			}
+			if collisionsVar.Get("svc:gitea") == nil {
+				t.Error("collision is missing from the metrics")
+			}
+		})
+	}
+}
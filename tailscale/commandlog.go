@@ -0,0 +1,119 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// commandLogOutputLimit is how much of a command's output the command log keeps
+const commandLogOutputLimit = 512
+
+// Statuses of a CommandLogEntry
+const (
+	CommandOK      = "ok"      // The command exited with status 0
+	CommandFailed  = "failed"  // The command failed to start, exited non-zero or timed out
+	CommandPlanned = "planned" // A dry run would have run the command
+)
+
+// CommandLogEntry is one line of the command log: a tailscale CLI call
+type CommandLogEntry struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	// Args is the full argv, starting with "tailscale"
+	Args []string `json:"args"`
+	// ExitCode is -1 when the command didn't exit on its own; it and the
+	// fields below are omitted for planned commands
+	ExitCode   *int  `json:"exit_code,omitempty"`
+	DurationMS int64 `json:"duration_ms,omitempty"`
+	// Output is the start of the combined stdout and stderr
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// mutatingCommand reports whether the tailscale arguments args change the
+// node, as opposed to reading its state like serve status or version
+func mutatingCommand(args []string) bool {
+	var positional []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 || positional[0] != "serve" && positional[0] != "funnel" {
+		return false
+	}
+	if len(positional) > 1 && (positional[1] == "status" || positional[1] == "get-config") {
+		return false
+	}
+	return true
+}
+
+// logCommand records a command that ran in the command log. Read-only
+// commands are only recorded when the client was configured to.
+func (c *Client) logCommand(cmd *exec.Cmd, start time.Time, output []byte, err error) {
+	if c.commandLog == nil || !c.commandLogReadOnly && !mutatingCommand(cmd.Args[1:]) {
+		return
+	}
+
+	exitCode := 0
+	entry := CommandLogEntry{
+		Time:       start.UTC(),
+		Status:     CommandOK,
+		Args:       cmd.Args,
+		ExitCode:   &exitCode,
+		DurationMS: time.Since(start).Milliseconds(),
+		Output:     truncateOutput(output),
+	}
+	if err != nil {
+		entry.Status = CommandFailed
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.Exited() {
+			exitCode = exitErr.ExitCode()
+		} else {
+			entry.Error = err.Error()
+		}
+	}
+	c.writeCommandLog(entry)
+}
+
+// planCommand records a mutating command a dry run would have run
+func (c *Client) planCommand(args ...string) {
+	if c.commandLog == nil {
+		return
+	}
+	c.writeCommandLog(CommandLogEntry{
+		Time:   time.Now().UTC(),
+		Status: CommandPlanned,
+		Args:   c.tailscaleCmd(context.Background(), args...).Args,
+	})
+}
+
+// writeCommandLog appends an entry to the command log. Each entry is written
+// with a single call so lines never interleave.
+func (c *Client) writeCommandLog(entry CommandLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode command log entry")
+		return
+	}
+
+	c.commandLogMu.Lock()
+	defer c.commandLogMu.Unlock()
+	if _, err := c.commandLog.Write(append(line, '\n')); err != nil {
+		log.Error().Err(err).Msg("Failed to write command log entry")
+	}
+}
+
+// truncateOutput returns the start of a command's output for the command log
+func truncateOutput(output []byte) string {
+	s := strings.TrimSpace(string(output))
+	if len(s) <= commandLogOutputLimit {
+		return s
+	}
+	return strings.ToValidUTF8(s[:commandLogOutputLimit], "") + "..."
+}
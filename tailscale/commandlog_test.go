@@ -0,0 +1,174 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestMutatingCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"serve", "--service=svc:web", "--https=443", "http://172.17.0.2:80"}, true},
+		{[]string{"serve", "--yes", "--service=svc:web", "--https=443", "off"}, true},
+		{[]string{"serve", "drain", "svc:web"}, true},
+		{[]string{"serve", "clear", "svc:web"}, true},
+		{[]string{"serve", "advertise", "svc:web"}, true},
+		{[]string{"funnel", "--bg", "--https=443", "http://172.17.0.2:80"}, true},
+		{[]string{"--socket=/run/ts.sock", "funnel", "reset"}, true},
+		{[]string{"serve", "status", "--json"}, false},
+		{[]string{"--socket=/run/ts.sock", "serve", "get-config", "--all"}, false},
+		{[]string{"funnel", "status", "--json"}, false},
+		{[]string{"status", "--json"}, false},
+		{[]string{"version"}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := mutatingCommand(tt.args); got != tt.want {
+			t.Errorf("mutatingCommand(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+// commandLogEntries decodes the lines of a command log
+func commandLogEntries(t *testing.T, data []byte) []CommandLogEntry {
+	t.Helper()
+	var entries []CommandLogEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry CommandLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("command log line %q is not JSON: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestCommandLogRecordsMutatingCommands(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.failCalls(t, "serve clear svc:broken")
+
+	var commandLog bytes.Buffer
+	client := NewClient(ClientConfig{CommandLog: &commandLog})
+	ctx := context.Background()
+
+	start := time.Now().UTC()
+	for _, args := range [][]string{
+		{"serve", "status", "--json"},
+		{"serve", "--service=svc:web", "--https=443", "http://172.17.0.2:80"},
+		{"serve", "clear", "svc:broken"},
+	} {
+		_, _ = client.combinedOutput(client.tailscaleCmd(ctx, args...))
+	}
+
+	entries := commandLogEntries(t, commandLog.Bytes())
+	if len(entries) != 2 {
+		t.Fatalf("command log = %s, want the 2 mutating commands", commandLog.String())
+	}
+
+	ok := entries[0]
+	if want := []string{"tailscale", "serve", "--service=svc:web", "--https=443", "http://172.17.0.2:80"}; strings.Join(ok.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("Args = %q, want %q", ok.Args, want)
+	}
+	if ok.Status != CommandOK || ok.ExitCode == nil || *ok.ExitCode != 0 || ok.Error != "" {
+		t.Errorf("entry = %+v, want status ok with exit code 0", ok)
+	}
+	if ok.Time.Before(start.Add(-time.Second)) || ok.Time.Location() != time.UTC {
+		t.Errorf("Time = %v, want the start of the command in UTC", ok.Time)
+	}
+
+	failed := entries[1]
+	if failed.Status != CommandFailed || failed.ExitCode == nil || *failed.ExitCode != 1 {
+		t.Errorf("entry = %+v, want status failed with exit code 1", failed)
+	}
+	if !strings.Contains(failed.Output, "injected failure") {
+		t.Errorf("Output = %q, want the output of the command", failed.Output)
+	}
+}
+
+func TestCommandLogReadOnly(t *testing.T) {
+	installFakeTailscale(t)
+
+	var commandLog bytes.Buffer
+	client := NewClient(ClientConfig{CommandLog: &commandLog, CommandLogReadOnly: true})
+	if _, err := client.combinedOutput(client.tailscaleCmd(context.Background(), "serve", "status", "--json")); err != nil {
+		t.Fatalf("combinedOutput() error = %v", err)
+	}
+
+	entries := commandLogEntries(t, commandLog.Bytes())
+	if len(entries) != 1 || strings.Join(entries[0].Args, " ") != "tailscale serve status --json" {
+		t.Errorf("command log = %s, want the read-only command", commandLog.String())
+	}
+}
+
+func TestCommandLogTruncatesOutput(t *testing.T) {
+	installScript(t, `head -c 2000 /dev/zero | tr '\0' 'x'`)
+
+	var commandLog bytes.Buffer
+	client := NewClient(ClientConfig{CommandLog: &commandLog})
+	if _, err := client.combinedOutput(client.tailscaleCmd(context.Background(), "funnel", "reset")); err != nil {
+		t.Fatalf("combinedOutput() error = %v", err)
+	}
+
+	entries := commandLogEntries(t, commandLog.Bytes())
+	if len(entries) != 1 {
+		t.Fatalf("command log = %s, want one entry", commandLog.String())
+	}
+	if want := strings.Repeat("x", commandLogOutputLimit) + "..."; entries[0].Output != want {
+		t.Errorf("Output has %d bytes, want %d", len(entries[0].Output), len(want))
+	}
+}
+
+func TestCommandLogDryRunPlansCommands(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:gone":{"TCP":{"443":{"HTTPS":true}},"Web":{"gone.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.8:8080"}}}}}
+	}}`)
+
+	var commandLog bytes.Buffer
+	client := NewClient(ClientConfig{CommandLog: &commandLog})
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080"},
+	}
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{DryRun: true}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+
+	for _, call := range fake.calls() {
+		if mutatingCommand(strings.Fields(call)) {
+			t.Errorf("dry run ran %q", call)
+		}
+	}
+
+	entries := commandLogEntries(t, commandLog.Bytes())
+	var planned []string
+	for _, entry := range entries {
+		if entry.Status != CommandPlanned || entry.ExitCode != nil || entry.DurationMS != 0 {
+			t.Errorf("entry = %+v, want a planned command", entry)
+		}
+		planned = append(planned, strings.Join(entry.Args, " "))
+	}
+	for _, want := range []string{
+		"tailscale serve --service=svc:web --https=443 http://172.17.0.2:8080",
+		"tailscale serve drain svc:gone",
+	} {
+		found := false
+		for _, p := range planned {
+			found = found || p == want
+		}
+		if !found {
+			t.Errorf("planned commands = %q, want %q", planned, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package tailscale
+
+import "sync"
+
+// DefaultConcurrency is the default number of independent advertise and funnel
+// commands run at once. Each command reads and rewrites tailscaled's serve
+// config or prefs, so parallel commands can race on older releases and the
+// default keeps them sequential.
+const DefaultConcurrency = 1
+
+// forEachConcurrently calls fn for every item with at most limit calls in
+// flight and returns once all of them are done. fn must synchronize access to
+// shared state.
+func forEachConcurrently[T any](items []T, limit int, fn func(T)) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, item := range items {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(item T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(item)
+		}(item)
+	}
+	wg.Wait()
+}
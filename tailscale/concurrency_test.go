@@ -0,0 +1,101 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestForEachConcurrently(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		wantMax int32
+	}{
+		{"sequential", 1, 1},
+		{"bounded", 3, 3},
+		{"invalid limit runs sequentially", 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := make([]int, 12)
+			for i := range items {
+				items[i] = i
+			}
+
+			var inFlight, maxInFlight atomic.Int32
+			var mu sync.Mutex
+			seen := make(map[int]bool)
+			forEachConcurrently(items, tt.limit, func(item int) {
+				n := inFlight.Add(1)
+				for {
+					highest := maxInFlight.Load()
+					if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				inFlight.Add(-1)
+
+				mu.Lock()
+				seen[item] = true
+				mu.Unlock()
+			})
+
+			if len(seen) != len(items) {
+				t.Errorf("processed %d items, want %d", len(seen), len(items))
+			}
+			if got := maxInFlight.Load(); got != tt.wantMax {
+				t.Errorf("max in flight = %d, want %d", got, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestReconcileAdvertisementsConcurrentErrors(t *testing.T) {
+	fake := installFakeTailscale(t)
+
+	var status []string
+	var desired []*apptypes.ContainerService
+	for i := range 6 {
+		name := fmt.Sprintf("app%d", i)
+		status = append(status, fmt.Sprintf(`"svc:%s":{"TCP":{"443":{"HTTPS":true}},"Web":{"%s.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.%d:80"}}}}}`, name, name, i+2))
+		desired = append(desired, &apptypes.ContainerService{ContainerName: name, ServiceEnabled: true, ServiceName: name, Port: "443", ServiceProtocol: "https", Protocol: "http"})
+	}
+	fake.setServeStatus(t, `{"Services":{`+strings.Join(status, ",")+`}}`)
+	fake.failCalls(t, "serve advertise svc:app1", "serve advertise svc:app4")
+
+	client := NewClient(ClientConfig{AdvertiseOnly: true, Concurrency: 4})
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err == nil || !strings.Contains(err.Error(), "failed to change advertisement of 2 services") {
+		t.Fatalf("ReconcileServices() error = %v, want both failures counted", err)
+	}
+	if want := []string{"svc:app0", "svc:app2", "svc:app3", "svc:app5"}; !reflect.DeepEqual(result.Added, want) {
+		t.Errorf("Added = %v, want %v", result.Added, want)
+	}
+	if _, known := client.advertised["svc:app1"]; known {
+		t.Error("failed advertisement was recorded as applied")
+	}
+}
+
+func BenchmarkForEachConcurrently(b *testing.B) {
+	// Each item stands in for one tailscale CLI round trip
+	items := make([]int, 16)
+	for _, limit := range []int{1, 4} {
+		b.Run(fmt.Sprintf("concurrency=%d", limit), func(b *testing.B) {
+			for b.Loop() {
+				forEachConcurrently(items, limit, func(int) {
+					time.Sleep(time.Millisecond)
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package tailscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marvinvr/docktail/atomicfile"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// ServiceConfigVersion is the Tailscale service configuration file format version
+const ServiceConfigVersion = "0.0.1"
+
+// DefaultMaxServicesDetail is the number of services above which the desired
+// configuration is logged as a summary instead of in full
+const DefaultMaxServicesDetail = 20
+
+// desiredConfigFile is the file name (inside the state directory) that receives
+// the full desired configuration on every reconciliation
+const desiredConfigFile = "desired-config.json"
+
+// BuildConfig builds the Tailscale service configuration describing the desired services.
+// Endpoints of services sharing a name are merged into a single service definition,
+// with handlers mounted below "/" listed under Paths, which the JSON leaves out.
+func BuildConfig(services []*apptypes.ContainerService) *apptypes.TailscaleServiceConfig {
+	cfg := &apptypes.TailscaleServiceConfig{
+		Version:  ServiceConfigVersion,
+		Services: make(map[string]apptypes.ServiceDefinition),
+	}
+
+	for _, svc := range services {
+		if !svc.ServiceEnabled {
+			continue
+		}
+
+		name := fmt.Sprintf("svc:%s", svc.ServiceName)
+		def, exists := cfg.Services[name]
+		if !exists {
+			def = apptypes.ServiceDefinition{Endpoints: make(map[string]string)}
+		}
+		endpoint := fmt.Sprintf("tcp:%s", svc.Port)
+		if svc.Path == "" {
+			def.Endpoints[endpoint] = BuildDestination(svc)
+		} else {
+			// Containers sharing a service name are merged by mount path
+			if def.Paths == nil {
+				def.Paths = make(map[string]map[string]string)
+			}
+			if def.Paths[endpoint] == nil {
+				def.Paths[endpoint] = make(map[string]string)
+			}
+			def.Paths[endpoint][svc.Path] = BuildDestination(svc)
+		}
+		if svc.ProxyProtocol != "" {
+			if def.ProxyProtocols == nil {
+				def.ProxyProtocols = make(map[string]string)
+			}
+			def.ProxyProtocols[endpoint] = svc.ProxyProtocol
+		}
+		cfg.Services[name] = def
+	}
+
+	return cfg
+}
+
+// dedupeEndpoints keeps one entry per service endpoint (name, port and path)
+// so the desired config doesn't depend on the order containers were listed in.
+// Of repeated entries the one with the smallest container name and ID is kept.
+// Endpoints of one service on different ports that proxy to the same
+// destination are distinct listeners, so they are kept and only logged.
+func dedupeEndpoints(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	kept := make(map[string]*apptypes.ContainerService)
+	for _, svc := range services {
+		if !svc.ServiceEnabled || svc.Preserve {
+			continue
+		}
+		key := serviceKey("svc:"+svc.ServiceName, svc.Port, svc.Path)
+		current, exists := kept[key]
+		if !exists {
+			kept[key] = svc
+			continue
+		}
+
+		winner, dropped := current, svc
+		if svc.ContainerName < current.ContainerName ||
+			svc.ContainerName == current.ContainerName && svc.ContainerID < current.ContainerID {
+			winner, dropped = svc, current
+		}
+		kept[key] = winner
+		log.Warn().
+			Str("key", key).
+			Str("container", winner.ContainerName).
+			Str("dropped_container", dropped.ContainerName).
+			Str("dropped_destination", BuildDestination(dropped)).
+			Msg("Duplicate service endpoint, keeping a single entry")
+	}
+
+	destinations := make(map[string][]string)
+	result := make([]*apptypes.ContainerService, 0, len(services))
+	for _, svc := range services {
+		if !svc.ServiceEnabled || svc.Preserve {
+			result = append(result, svc)
+			continue
+		}
+		key := serviceKey("svc:"+svc.ServiceName, svc.Port, svc.Path)
+		if kept[key] != svc {
+			continue
+		}
+		result = append(result, svc)
+		destKey := "svc:" + svc.ServiceName + " " + BuildDestination(svc)
+		destinations[destKey] = append(destinations[destKey], key)
+	}
+
+	var shared []string
+	for destKey, keys := range destinations {
+		if len(keys) > 1 {
+			shared = append(shared, destKey)
+		}
+	}
+	sort.Strings(shared)
+	for _, destKey := range shared {
+		serviceName, destination, _ := strings.Cut(destKey, " ")
+		keys := destinations[destKey]
+		sort.Strings(keys)
+		log.Debug().
+			Str("service", serviceName).
+			Str("destination", destination).
+			Strs("endpoints", keys).
+			Msg("Service endpoints share a destination")
+	}
+
+	return result
+}
+
+// resolveProtocolConflicts keeps a single service protocol per service port.
+// Endpoints mounted at different paths of one port must share a protocol:
+// tailscale serve rejects the second one, and clearing the service to add it
+// removes the first, which the next cycle adds back. The protocol of the
+// preferred endpoint wins (the root handler, then the smallest container name,
+// container ID and path) so every cycle makes the same choice; endpoints with
+// another protocol are dropped and logged.
+func resolveProtocolConflicts(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	winners := make(map[string]*apptypes.ContainerService)
+	for _, svc := range services {
+		if !svc.ServiceEnabled || svc.Preserve {
+			continue
+		}
+		key := serviceKey("svc:"+svc.ServiceName, svc.Port, "")
+		if current, ok := winners[key]; !ok || preferredEndpoint(svc, current) {
+			winners[key] = svc
+		}
+	}
+
+	result := make([]*apptypes.ContainerService, 0, len(services))
+	for _, svc := range services {
+		if svc.ServiceEnabled && !svc.Preserve {
+			winner := winners[serviceKey("svc:"+svc.ServiceName, svc.Port, "")]
+			if svc.ServiceProtocol != winner.ServiceProtocol {
+				log.Warn().
+					Str("key", serviceKey("svc:"+svc.ServiceName, svc.Port, svc.Path)).
+					Str("protocol", svc.ServiceProtocol).
+					Str("container", svc.ContainerName).
+					Str("winner_protocol", winner.ServiceProtocol).
+					Str("winner_container", winner.ContainerName).
+					Str("winner_path", normalizeMountPath(winner.Path)).
+					Msg("Service port is claimed with conflicting protocols, skipping the endpoint that lost")
+				continue
+			}
+		}
+		result = append(result, svc)
+	}
+	return result
+}
+
+// preferredEndpoint reports whether a decides the protocol of a service port
+// over b
+func preferredEndpoint(a, b *apptypes.ContainerService) bool {
+	if (a.Path == "") != (b.Path == "") {
+		return a.Path == ""
+	}
+	if a.ContainerName != b.ContainerName {
+		return a.ContainerName < b.ContainerName
+	}
+	if a.ContainerID != b.ContainerID {
+		return a.ContainerID < b.ContainerID
+	}
+	return a.Path < b.Path
+}
+
+// logDesiredConfig logs the desired configuration in full when it is small, or as a
+// summary (counts and service names) once it exceeds the configured detail limit.
+// When a state directory is configured the full configuration is also written there.
+// Returns true when the summary was logged instead of the full configuration.
+func (c *Client) logDesiredConfig(cfg *apptypes.TailscaleServiceConfig) bool {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to marshal desired service configuration")
+		return false
+	}
+
+	if c.stateDir != "" {
+		path := filepath.Join(c.stateDir, desiredConfigFile)
+		if err := atomicfile.Write(path, data); err != nil {
+			log.Warn().
+				Err(err).
+				Str("path", path).
+				Msg("Failed to write desired service configuration to state directory")
+		}
+	}
+
+	if c.maxServicesDetail <= 0 || len(cfg.Services) <= c.maxServicesDetail {
+		log.Debug().
+			RawJSON("config", data).
+			Msg("Desired service configuration")
+		return false
+	}
+
+	names := make([]string, 0, len(cfg.Services))
+	endpoints := 0
+	for name, def := range cfg.Services {
+		names = append(names, name)
+		endpoints += len(def.Endpoints)
+	}
+	sort.Strings(names)
+
+	event := log.Debug().
+		Int("services", len(cfg.Services)).
+		Int("endpoints", endpoints).
+		Int("detail_limit", c.maxServicesDetail).
+		Strs("service_names", names)
+	if c.stateDir != "" {
+		event = event.Str("full_config", filepath.Join(c.stateDir, desiredConfigFile))
+	}
+	event.Msg("Desired service configuration (summary, too many services to log in full)")
+
+	return true
+}
+
+// dumpConfig writes the desired configuration to DUMP_CONFIG. The file is
+// replaced atomically, so readers never see a partial configuration.
+func (c *Client) dumpConfig(cfg *apptypes.TailscaleServiceConfig) {
+	if c.dumpConfigPath == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to marshal desired service configuration")
+		return
+	}
+	if err := atomicfile.Write(c.dumpConfigPath, append(data, '\n')); err != nil {
+		log.Warn().
+			Err(err).
+			Str("path", c.dumpConfigPath).
+			Msg("Failed to dump desired service configuration")
+	}
+}
@@ -0,0 +1,304 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestBuildConfig(t *testing.T) {
+	services := []*apptypes.ContainerService{
+		{ServiceEnabled: true, ServiceName: "web", Port: "443", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080"},
+		{ServiceEnabled: true, ServiceName: "web", Port: "80", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8081"},
+		{ServiceEnabled: true, ServiceName: "db", Port: "5432", Protocol: "tcp", IPAddress: "172.17.0.3", TargetPort: "5432"},
+		{ServiceEnabled: true, ServiceName: "pve", Port: "443", Protocol: "https", IPAddress: "192.168.1.40", TargetPort: "8006", InsecureSkipVerify: true, Destination: "https+insecure://192.168.1.40:8006"},
+		{ServiceEnabled: true, ServiceName: "unifi", Port: "443", Protocol: "https+insecure", IPAddress: "172.17.0.4", TargetPort: "8443"},
+		{ServiceEnabled: true, ServiceName: "pve", Port: "80", ServiceProtocol: "http", Handler: apptypes.HandlerRedirect, HandlerContent: "https://${HOST}${REQUEST_URI}"},
+		{ServiceEnabled: false, FunnelEnabled: true, FunnelFunnelPort: "443"},
+	}
+
+	cfg := BuildConfig(services)
+
+	if cfg.Version != ServiceConfigVersion {
+		t.Errorf("Version = %q, want %q", cfg.Version, ServiceConfigVersion)
+	}
+	if len(cfg.Services) != 4 {
+		t.Fatalf("got %d services, want 4", len(cfg.Services))
+	}
+
+	web := cfg.Services["svc:web"]
+	if got := web.Endpoints["tcp:443"]; got != "http://172.17.0.2:8080" {
+		t.Errorf("svc:web tcp:443 = %q", got)
+	}
+	if got := web.Endpoints["tcp:80"]; got != "http://172.17.0.2:8081" {
+		t.Errorf("svc:web tcp:80 = %q", got)
+	}
+	if got := cfg.Services["svc:db"].Endpoints["tcp:5432"]; got != "tcp://172.17.0.3:5432" {
+		t.Errorf("svc:db tcp:5432 = %q", got)
+	}
+	if got := cfg.Services["svc:pve"].Endpoints["tcp:443"]; got != "https+insecure://192.168.1.40:8006" {
+		t.Errorf("svc:pve tcp:443 = %q, want the destination override", got)
+	}
+	if got := cfg.Services["svc:unifi"].Endpoints["tcp:443"]; got != "https+insecure://172.17.0.4:8443" {
+		t.Errorf("svc:unifi tcp:443 = %q, want an https+insecure destination", got)
+	}
+	if got := cfg.Services["svc:pve"].Endpoints["tcp:80"]; got != "redirect:https://${HOST}${REQUEST_URI}" {
+		t.Errorf("svc:pve tcp:80 = %q, want the http redirect", got)
+	}
+}
+
+func TestBuildConfigAliases(t *testing.T) {
+	primary := &apptypes.ContainerService{ServiceEnabled: true, ServiceName: "grafana", Port: "443", Protocol: "http", IPAddress: "172.17.0.4", TargetPort: "3000"}
+	alias := *primary
+	alias.ServiceName = "monitoring"
+	alias.AliasOf = "grafana"
+
+	cfg := BuildConfig([]*apptypes.ContainerService{primary, &alias})
+
+	if !reflect.DeepEqual(cfg.Services["svc:grafana"], cfg.Services["svc:monitoring"]) {
+		t.Errorf("alias endpoints = %+v, want the primary's %+v", cfg.Services["svc:monitoring"], cfg.Services["svc:grafana"])
+	}
+}
+
+func TestBuildConfigMergesPaths(t *testing.T) {
+	services := []*apptypes.ContainerService{
+		{ServiceEnabled: true, ServiceName: "tools", Port: "443", Protocol: "http", IPAddress: "172.17.0.4", TargetPort: "3000", Path: "/grafana"},
+		{ServiceEnabled: true, ServiceName: "tools", Port: "443", Protocol: "http", IPAddress: "172.17.0.5", TargetPort: "9090", Path: "/prometheus"},
+		{ServiceEnabled: true, ServiceName: "tools", Port: "443", Protocol: "http", IPAddress: "172.17.0.6", TargetPort: "8080"},
+		{ServiceEnabled: true, ServiceName: "metrics", Port: "443", Protocol: "http", IPAddress: "172.17.0.7", TargetPort: "9100", Path: "/node"},
+		{ServiceEnabled: true, ServiceName: "db", Port: "5432", ServiceProtocol: "tcp", Protocol: "tcp", IPAddress: "172.17.0.8", TargetPort: "5432", ProxyProtocol: "2"},
+	}
+
+	cfg := BuildConfig(services)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	wantPaths := map[string]map[string]string{"tcp:443": {
+		"/grafana":    "http://172.17.0.4:3000",
+		"/prometheus": "http://172.17.0.5:9090",
+	}}
+	if got := cfg.Services["svc:tools"].Paths; !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("svc:tools Paths = %v, want %v", got, wantPaths)
+	}
+	if got := cfg.Services["svc:metrics"].Paths["tcp:443"]["/node"]; got != "http://172.17.0.7:9100" {
+		t.Errorf("svc:metrics /node = %q, want http://172.17.0.7:9100", got)
+	}
+	if got := cfg.Services["svc:db"].ProxyProtocols["tcp:5432"]; got != "2" {
+		t.Errorf("svc:db PROXY protocol = %q, want 2", got)
+	}
+
+	got, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+
+	// Only what a Tailscale service configuration file holds is written out
+	const golden = `{
+  "version": "0.0.1",
+  "services": {
+    "svc:db": {
+      "endpoints": {
+        "tcp:5432": "tcp://172.17.0.8:5432"
+      }
+    },
+    "svc:metrics": {
+      "endpoints": {}
+    },
+    "svc:tools": {
+      "endpoints": {
+        "tcp:443": "http://172.17.0.6:8080"
+      }
+    }
+  }
+}`
+	if string(got) != golden {
+		t.Errorf("BuildConfig() JSON =\n%s\nwant\n%s", got, golden)
+	}
+}
+
+func configWithServices(n int) *apptypes.TailscaleServiceConfig {
+	var services []*apptypes.ContainerService
+	for i := 0; i < n; i++ {
+		services = append(services, &apptypes.ContainerService{
+			ServiceEnabled: true,
+			ServiceName:    fmt.Sprintf("svc-%d", i),
+			Port:           "443",
+			Protocol:       "http",
+			IPAddress:      "localhost",
+			TargetPort:     fmt.Sprintf("%d", 8000+i),
+		})
+	}
+	return BuildConfig(services)
+}
+
+func TestDedupeEndpoints(t *testing.T) {
+	blue := &apptypes.ContainerService{ContainerName: "web-blue", ContainerID: "bbb", ServiceEnabled: true, ServiceName: "web", Port: "443", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080"}
+	green := &apptypes.ContainerService{ContainerName: "web-green", ContainerID: "ggg", ServiceEnabled: true, ServiceName: "web", Port: "443", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "8080"}
+	// Another port proxying to the same backend is a separate listener
+	alt := &apptypes.ContainerService{ContainerName: "web-blue", ContainerID: "bbb", ServiceEnabled: true, ServiceName: "web", Port: "8443", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "8080"}
+	preserved := &apptypes.ContainerService{ContainerName: "legacy", ServiceName: "web", Preserve: true}
+
+	tests := []struct {
+		name     string
+		services []*apptypes.ContainerService
+	}{
+		{"listed first", []*apptypes.ContainerService{blue, green, alt, preserved}},
+		{"listed last", []*apptypes.ContainerService{preserved, alt, green, blue}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dedupeEndpoints(tt.services)
+			if len(got) != 3 {
+				t.Fatalf("got %d services, want 3: %+v", len(got), got)
+			}
+			for _, svc := range got {
+				if svc == green {
+					t.Errorf("duplicate endpoint of %s was kept", green.ContainerName)
+				}
+			}
+
+			want := map[string]string{"tcp:443": "http://172.17.0.2:8080", "tcp:8443": "http://172.17.0.2:8080"}
+			if endpoints := BuildConfig(got).Services["svc:web"].Endpoints; !reflect.DeepEqual(endpoints, want) {
+				t.Errorf("endpoints = %v, want %v", endpoints, want)
+			}
+		})
+	}
+}
+
+func TestLogDesiredConfigSummary(t *testing.T) {
+	tests := []struct {
+		name          string
+		services      int
+		limit         int
+		wantSummarize bool
+	}{
+		{"below limit logs full config", 3, 5, false},
+		{"at limit logs full config", 5, 5, false},
+		{"above limit logs summary", 6, 5, true},
+		{"zero limit disables summary", 50, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			client := &Client{stateDir: stateDir, maxServicesDetail: tt.limit}
+			cfg := configWithServices(tt.services)
+
+			if got := client.logDesiredConfig(cfg); got != tt.wantSummarize {
+				t.Errorf("logDesiredConfig() summarized = %v, want %v", got, tt.wantSummarize)
+			}
+
+			// The full config is always written to the state directory
+			data, err := os.ReadFile(filepath.Join(stateDir, desiredConfigFile))
+			if err != nil {
+				t.Fatalf("failed to read debug config: %v", err)
+			}
+			var written apptypes.TailscaleServiceConfig
+			if err := json.Unmarshal(data, &written); err != nil {
+				t.Fatalf("failed to parse debug config: %v", err)
+			}
+			if len(written.Services) != tt.services {
+				t.Errorf("debug config has %d services, want %d", len(written.Services), tt.services)
+			}
+		})
+	}
+}
+
+func TestResolveProtocolConflicts(t *testing.T) {
+	endpoint := func(container, path, protocol string) *apptypes.ContainerService {
+		return &apptypes.ContainerService{
+			ContainerName: container, ServiceEnabled: true, ServiceName: "tools", Port: "443",
+			ServiceProtocol: protocol, Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80", Path: path,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		services []*apptypes.ContainerService
+		want     []string // containers kept
+	}{
+		{
+			name:     "same protocol",
+			services: []*apptypes.ContainerService{endpoint("grafana", "/grafana", "https"), endpoint("prometheus", "/prometheus", "https")},
+			want:     []string{"grafana", "prometheus"},
+		},
+		{
+			name:     "smallest container name wins",
+			services: []*apptypes.ContainerService{endpoint("prometheus", "/prometheus", "http"), endpoint("grafana", "/grafana", "https")},
+			want:     []string{"grafana"},
+		},
+		{
+			name:     "root handler wins",
+			services: []*apptypes.ContainerService{endpoint("grafana", "/grafana", "https"), endpoint("web", "", "http"), endpoint("api", "/api", "http")},
+			want:     []string{"web", "api"},
+		},
+		{
+			name: "other ports and disabled services are kept",
+			services: []*apptypes.ContainerService{
+				endpoint("grafana", "/grafana", "https"),
+				{ContainerName: "web", ServiceEnabled: true, ServiceName: "tools", Port: "80", ServiceProtocol: "http"},
+				{ContainerName: "funnel", FunnelEnabled: true},
+			},
+			want: []string{"grafana", "web", "funnel"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, svc := range resolveProtocolConflicts(tt.services) {
+				got = append(got, svc.ContainerName)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveProtocolConflicts() kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileServicesDumpsConfig(t *testing.T) {
+	installFakeTailscale(t)
+	path := filepath.Join(t.TempDir(), "desired.json")
+	// A leftover from an earlier run is replaced
+	if err := os.WriteFile(path, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(ClientConfig{DumpConfigPath: path})
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		{ContainerName: "db", ServiceEnabled: true, ServiceName: "db", Port: "5432", ServiceProtocol: "tcp", Protocol: "tcp", IPAddress: "172.17.0.3", TargetPort: "5432"},
+	}
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read dumped config: %v", err)
+	}
+	var dumped apptypes.TailscaleServiceConfig
+	if err := json.Unmarshal(data, &dumped); err != nil {
+		t.Fatalf("failed to parse dumped config: %v", err)
+	}
+	if want := BuildConfig(desired); !reflect.DeepEqual(&dumped, want) {
+		t.Errorf("dumped config = %+v, want %+v", dumped, want)
+	}
+
+	// No temporary files are left next to it
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the dump", len(entries))
+	}
+}
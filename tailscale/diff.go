@@ -0,0 +1,206 @@
+package tailscale
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// Kinds of ConfigChange
+const (
+	ChangeAdd    = "add"
+	ChangeRemove = "remove"
+	ChangeUpdate = "update"
+)
+
+// ConfigChange is a service endpoint that differs between two configurations
+type ConfigChange struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`            // e.g. "svc:web:443" or "svc:web:443/grafana"
+	From string `json:"from,omitempty"` // destination in the current configuration, empty for an add
+	To   string `json:"to,omitempty"`   // destination in the desired configuration, empty for a remove
+	// FromProxyProtocol and ToProxyProtocol are the PROXY protocol versions
+	// sent to the backend before and after, empty for none
+	FromProxyProtocol string `json:"from_proxy_protocol,omitempty"`
+	ToProxyProtocol   string `json:"to_proxy_protocol,omitempty"`
+}
+
+// String formats the change as a diff line
+func (c ConfigChange) String() string {
+	from := withProxyProtocol(c.From, c.FromProxyProtocol)
+	to := withProxyProtocol(c.To, c.ToProxyProtocol)
+	switch c.Kind {
+	case ChangeAdd:
+		return fmt.Sprintf("+ %s -> %s", c.Key, to)
+	case ChangeRemove:
+		return fmt.Sprintf("- %s -> %s", c.Key, from)
+	default:
+		return fmt.Sprintf("~ %s -> %s (was %s)", c.Key, to, from)
+	}
+}
+
+// withProxyProtocol appends the PROXY protocol version to a destination
+func withProxyProtocol(destination, proxyProtocol string) string {
+	if proxyProtocol == "" {
+		return destination
+	}
+	return destination + " [proxy-protocol " + proxyProtocol + "]"
+}
+
+// ConfigDiff is the difference between two configurations by service. The
+// zero value means they are the same.
+type ConfigDiff struct {
+	// Added lists the services only in the desired configuration, sorted
+	Added []string `json:"added,omitempty"`
+	// Removed lists the services only in the current configuration, sorted
+	Removed []string `json:"removed,omitempty"`
+	// Changed lists the services in both whose endpoints differ, sorted
+	Changed []string `json:"changed,omitempty"`
+	// Endpoints holds the endpoint changes of every added, removed and
+	// changed service, by service and sorted by key
+	Endpoints map[string][]ConfigChange `json:"endpoints,omitempty"`
+}
+
+// Empty reports whether the configurations were the same
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Changes returns the endpoint changes of every service, sorted by key
+func (d ConfigDiff) Changes() []ConfigChange {
+	var changes []ConfigChange
+	for _, serviceChanges := range d.Endpoints {
+		changes = append(changes, serviceChanges...)
+	}
+	sortChanges(changes)
+	return changes
+}
+
+// DiffConfigs compares current with desired, the configuration reconciling
+// current would lead to. Either may be nil, for an empty configuration. A
+// service present in both without endpoint changes is left out, even when
+// its definition has no endpoints at all.
+func DiffConfigs(current, desired *apptypes.TailscaleServiceConfig) ConfigDiff {
+	from := serviceDestinations(current)
+	to := serviceDestinations(desired)
+
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diff ConfigDiff
+	for _, name := range names {
+		old, inCurrent := from[name]
+		dest, inDesired := to[name]
+		changes := diffDestinations(old, dest)
+		switch {
+		case !inCurrent:
+			diff.Added = append(diff.Added, name)
+		case !inDesired:
+			diff.Removed = append(diff.Removed, name)
+		case len(changes) > 0:
+			diff.Changed = append(diff.Changed, name)
+		default:
+			continue
+		}
+		if len(changes) > 0 {
+			if diff.Endpoints == nil {
+				diff.Endpoints = make(map[string][]ConfigChange)
+			}
+			diff.Endpoints[name] = changes
+		}
+	}
+	return diff
+}
+
+// DiffConfig lists the endpoints that reconciling current towards desired
+// adds, removes or points elsewhere, keyed like ReconcileResult and sorted by
+// key
+func DiffConfig(current, desired *apptypes.TailscaleServiceConfig) []ConfigChange {
+	return DiffConfigs(current, desired).Changes()
+}
+
+// LastConfigDiff returns how the services configured on the node differed
+// from the desired ones at the start of the last cycle, which is what the
+// cycle changed, or would have in dry-run mode. Ignored services and those of
+// unmanaged containers are left out.
+func (c *Client) LastConfigDiff() ConfigDiff {
+	c.diffMu.Lock()
+	defer c.diffMu.Unlock()
+	return c.lastDiff
+}
+
+func (c *Client) setLastDiff(diff ConfigDiff) {
+	c.diffMu.Lock()
+	defer c.diffMu.Unlock()
+	c.lastDiff = diff
+}
+
+// diffDestinations lists the changes between the destinations of a service
+// in two configurations, sorted by key
+func diffDestinations(from, to map[string]endpointTarget) []ConfigChange {
+	var changes []ConfigChange
+	for key, dest := range to {
+		switch old, ok := from[key]; {
+		case !ok:
+			changes = append(changes, ConfigChange{Kind: ChangeAdd, Key: key, To: dest.destination, ToProxyProtocol: dest.proxyProtocol})
+		case old != dest:
+			changes = append(changes, ConfigChange{
+				Kind:              ChangeUpdate,
+				Key:               key,
+				From:              old.destination,
+				To:                dest.destination,
+				FromProxyProtocol: old.proxyProtocol,
+				ToProxyProtocol:   dest.proxyProtocol,
+			})
+		}
+	}
+	for key, dest := range from {
+		if _, ok := to[key]; !ok {
+			changes = append(changes, ConfigChange{Kind: ChangeRemove, Key: key, From: dest.destination, FromProxyProtocol: dest.proxyProtocol})
+		}
+	}
+	sortChanges(changes)
+	return changes
+}
+
+func sortChanges(changes []ConfigChange) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+}
+
+// endpointTarget is where an endpoint sends its connections
+type endpointTarget struct {
+	destination   string
+	proxyProtocol string
+}
+
+// serviceDestinations flattens a configuration into the destinations of
+// each service, keyed by service endpoint
+func serviceDestinations(cfg *apptypes.TailscaleServiceConfig) map[string]map[string]endpointTarget {
+	services := make(map[string]map[string]endpointTarget)
+	if cfg == nil {
+		return services
+	}
+	for serviceName, def := range cfg.Services {
+		destinations := make(map[string]endpointTarget)
+		for endpoint, dest := range def.Endpoints {
+			destinations[serviceKey(serviceName, strings.TrimPrefix(endpoint, "tcp:"), "")] = endpointTarget{dest, def.ProxyProtocols[endpoint]}
+		}
+		for endpoint, paths := range def.Paths {
+			for path, dest := range paths {
+				destinations[serviceKey(serviceName, strings.TrimPrefix(endpoint, "tcp:"), path)] = endpointTarget{destination: dest}
+			}
+		}
+		services[serviceName] = destinations
+	}
+	return services
+}
@@ -0,0 +1,170 @@
+package tailscale
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// serviceConfig builds a configuration from service definitions
+func serviceConfig(services map[string]apptypes.ServiceDefinition) *apptypes.TailscaleServiceConfig {
+	return &apptypes.TailscaleServiceConfig{Version: ServiceConfigVersion, Services: services}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	web := apptypes.ServiceDefinition{Endpoints: map[string]string{"tcp:443": "http://172.17.0.2:80"}}
+	db := apptypes.ServiceDefinition{Endpoints: map[string]string{"tcp:5432": "tcp://172.17.0.3:5432"}}
+
+	tests := []struct {
+		name             string
+		current, desired *apptypes.TailscaleServiceConfig
+		want             ConfigDiff
+	}{
+		{
+			name:    "no change",
+			current: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web, "svc:db": db}),
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web, "svc:db": db}),
+		},
+		{
+			name: "both empty",
+		},
+		{
+			name:    "service added",
+			current: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:db": db}),
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web, "svc:db": db}),
+			want: ConfigDiff{
+				Added: []string{"svc:web"},
+				Endpoints: map[string][]ConfigChange{
+					"svc:web": {{Kind: ChangeAdd, Key: "svc:web:443", To: "http://172.17.0.2:80"}},
+				},
+			},
+		},
+		{
+			name:    "everything added to a nil config",
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web, "svc:db": db}),
+			want: ConfigDiff{
+				Added: []string{"svc:db", "svc:web"},
+				Endpoints: map[string][]ConfigChange{
+					"svc:db":  {{Kind: ChangeAdd, Key: "svc:db:5432", To: "tcp://172.17.0.3:5432"}},
+					"svc:web": {{Kind: ChangeAdd, Key: "svc:web:443", To: "http://172.17.0.2:80"}},
+				},
+			},
+		},
+		{
+			name:    "service removed",
+			current: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web, "svc:db": db}),
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web}),
+			want: ConfigDiff{
+				Removed: []string{"svc:db"},
+				Endpoints: map[string][]ConfigChange{
+					"svc:db": {{Kind: ChangeRemove, Key: "svc:db:5432", From: "tcp://172.17.0.3:5432"}},
+				},
+			},
+		},
+		{
+			name:    "destination changed",
+			current: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web}),
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{
+				"svc:web": {Endpoints: map[string]string{"tcp:443": "http://172.17.0.4:80"}},
+			}),
+			want: ConfigDiff{
+				Changed: []string{"svc:web"},
+				Endpoints: map[string][]ConfigChange{
+					"svc:web": {{Kind: ChangeUpdate, Key: "svc:web:443", From: "http://172.17.0.2:80", To: "http://172.17.0.4:80"}},
+				},
+			},
+		},
+		{
+			name:    "endpoints added and removed",
+			current: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:web": web}),
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{
+				"svc:web": {
+					Endpoints: map[string]string{"tcp:80": "http://172.17.0.2:80"},
+					Paths:     map[string]map[string]string{"tcp:80": {"/grafana": "http://172.17.0.5:3000"}},
+				},
+			}),
+			want: ConfigDiff{
+				Changed: []string{"svc:web"},
+				Endpoints: map[string][]ConfigChange{
+					"svc:web": {
+						{Kind: ChangeRemove, Key: "svc:web:443", From: "http://172.17.0.2:80"},
+						{Kind: ChangeAdd, Key: "svc:web:80", To: "http://172.17.0.2:80"},
+						{Kind: ChangeAdd, Key: "svc:web:80/grafana", To: "http://172.17.0.5:3000"},
+					},
+				},
+			},
+		},
+		{
+			name:    "service without endpoints added",
+			current: serviceConfig(map[string]apptypes.ServiceDefinition{}),
+			desired: serviceConfig(map[string]apptypes.ServiceDefinition{"svc:empty": {}}),
+			want:    ConfigDiff{Added: []string{"svc:empty"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffConfigs(tt.current, tt.desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffConfigs() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != (len(tt.want.Added)+len(tt.want.Removed)+len(tt.want.Changed) == 0) {
+				t.Errorf("Empty() = %v for %+v", got.Empty(), got)
+			}
+		})
+	}
+}
+
+func TestDiffConfigsIsDeterministic(t *testing.T) {
+	current := serviceConfig(map[string]apptypes.ServiceDefinition{})
+	desired := serviceConfig(map[string]apptypes.ServiceDefinition{})
+	for _, name := range []string{"svc:a", "svc:b", "svc:c", "svc:d", "svc:e", "svc:f"} {
+		desired.Services[name] = apptypes.ServiceDefinition{Endpoints: map[string]string{
+			"tcp:443": "http://172.17.0.2:80",
+			"tcp:80":  "http://172.17.0.2:80",
+			"tcp:22":  "tcp://172.17.0.2:22",
+		}}
+	}
+
+	first := DiffConfigs(current, desired)
+	for range 20 {
+		if got := DiffConfigs(current, desired); !reflect.DeepEqual(got, first) {
+			t.Fatalf("DiffConfigs() = %+v, then %+v", first, got)
+		}
+	}
+	changes := first.Changes()
+	for i := 1; i < len(changes); i++ {
+		if changes[i-1].Key >= changes[i].Key {
+			t.Fatalf("Changes() not sorted by key: %v", changes)
+		}
+	}
+}
+
+func TestReconcileServicesRecordsConfigDiff(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.9:80"}}}}},
+		"svc:legacy":{"TCP":{"443":{"HTTPS":true}},"Web":{"legacy.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.8:80"}}}}}
+	}}`)
+
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		{ContainerName: "legacy", ServiceName: "legacy", Preserve: true},
+	}
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{DryRun: true}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+
+	want := ConfigDiff{
+		Changed: []string{"svc:web"},
+		Endpoints: map[string][]ConfigChange{
+			"svc:web": {{Kind: ChangeUpdate, Key: "svc:web:443", From: "http://172.17.0.9:80", To: "http://172.17.0.2:80"}},
+		},
+	}
+	if got := client.LastConfigDiff(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LastConfigDiff() = %+v, want %+v", got, want)
+	}
+}
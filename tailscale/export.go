@@ -0,0 +1,67 @@
+package tailscale
+
+import (
+	"context"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// DesiredConfig builds the configuration ReconcileServices would apply for the
+// desired services, without changing the node. Services DockTail would refuse
+// because another tailnet node serves them are not known here and are kept.
+func (c *Client) DesiredConfig(desiredServices []*apptypes.ContainerService) *apptypes.TailscaleServiceConfig {
+	desiredServices = c.redirectFallbacks(desiredServices)
+	desiredServices = dedupeEndpoints(desiredServices)
+	desiredServices = resolveProtocolConflicts(desiredServices)
+	return BuildConfig(desiredServices)
+}
+
+// CurrentConfig reads the services configured on the node into the format of
+// BuildConfig. Services DockTail ignores are left out, as reconciliation
+// leaves them alone.
+func (c *Client) CurrentConfig(ctx context.Context) (*apptypes.TailscaleServiceConfig, error) {
+	current, err := c.GetCurrentServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.endpointsConfig(current, nil), nil
+}
+
+// endpointsConfig builds a configuration in the format of BuildConfig from
+// the endpoints configured on the node, leaving out ignored services and the
+// services in skip
+func (c *Client) endpointsConfig(endpoints map[string]ServiceEndpoint, skip map[string]struct{}) *apptypes.TailscaleServiceConfig {
+	cfg := &apptypes.TailscaleServiceConfig{
+		Version:  ServiceConfigVersion,
+		Services: make(map[string]apptypes.ServiceDefinition),
+	}
+	for _, endpoint := range endpoints {
+		if _, skipped := skip[endpoint.ServiceName]; skipped || c.shouldIgnoreService(endpoint.ServiceName) {
+			continue
+		}
+		def, exists := cfg.Services[endpoint.ServiceName]
+		if !exists {
+			def = apptypes.ServiceDefinition{Endpoints: make(map[string]string)}
+		}
+		key := "tcp:" + endpoint.Port
+		if endpoint.Path == "" {
+			def.Endpoints[key] = endpoint.Destination
+		} else {
+			if def.Paths == nil {
+				def.Paths = make(map[string]map[string]string)
+			}
+			if def.Paths[key] == nil {
+				def.Paths[key] = make(map[string]string)
+			}
+			def.Paths[key][endpoint.Path] = endpoint.Destination
+		}
+		if endpoint.ProxyProtocol != "" {
+			if def.ProxyProtocols == nil {
+				def.ProxyProtocols = make(map[string]string)
+			}
+			def.ProxyProtocols[key] = endpoint.ProxyProtocol
+		}
+		cfg.Services[endpoint.ServiceName] = def
+	}
+	return cfg
+}
@@ -0,0 +1,73 @@
+package tailscale
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestCurrentConfigMatchesDesiredConfig(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{
+			"/":{"Proxy":"http://172.17.0.2:80"},
+			"/grafana":{"Proxy":"http://172.17.0.3:3000"}
+		}}}},
+		"svc:kept":{"TCP":{"443":{"HTTPS":true}},"Web":{"kept.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.9:80"}}}}}
+	}}`)
+
+	client := NewClient(ClientConfig{IgnoreServiceNames: []string{"kept"}})
+	desired := client.DesiredConfig([]*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		{ContainerName: "grafana", ServiceEnabled: true, ServiceName: "web", Port: "443", Path: "/grafana", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "3000"},
+	})
+
+	current, err := client.CurrentConfig(context.Background())
+	if err != nil {
+		t.Fatalf("CurrentConfig() error = %v", err)
+	}
+	if !reflect.DeepEqual(current, desired) {
+		t.Errorf("CurrentConfig() = %+v, want %+v", current, desired)
+	}
+	if changes := DiffConfig(current, desired); len(changes) != 0 {
+		t.Errorf("DiffConfig() = %v, want no changes", changes)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	current := &apptypes.TailscaleServiceConfig{Services: map[string]apptypes.ServiceDefinition{
+		"svc:web": {
+			Endpoints: map[string]string{"tcp:443": "http://172.17.0.2:80"},
+			Paths:     map[string]map[string]string{"tcp:443": {"/old": "http://172.17.0.5:80"}},
+		},
+		"svc:db": {Endpoints: map[string]string{"tcp:5432": "tcp://172.17.0.3:5432"}},
+	}}
+	desired := &apptypes.TailscaleServiceConfig{Services: map[string]apptypes.ServiceDefinition{
+		"svc:web": {Endpoints: map[string]string{"tcp:443": "http://172.17.0.4:80"}},
+		"svc:db":  {Endpoints: map[string]string{"tcp:5432": "tcp://172.17.0.3:5432"}},
+		"svc:api": {Endpoints: map[string]string{"tcp:443": "http://172.17.0.6:8080"}},
+	}}
+
+	got := DiffConfig(current, desired)
+	want := []ConfigChange{
+		{Kind: ChangeAdd, Key: "svc:api:443", To: "http://172.17.0.6:8080"},
+		{Kind: ChangeUpdate, Key: "svc:web:443", From: "http://172.17.0.2:80", To: "http://172.17.0.4:80"},
+		{Kind: ChangeRemove, Key: "svc:web:443/old", From: "http://172.17.0.5:80"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffConfig() = %+v, want %+v", got, want)
+	}
+
+	lines := []string{
+		"+ svc:api:443 -> http://172.17.0.6:8080",
+		"~ svc:web:443 -> http://172.17.0.4:80 (was http://172.17.0.2:80)",
+		"- svc:web:443/old -> http://172.17.0.5:80",
+	}
+	for i, change := range got {
+		if change.String() != lines[i] {
+			t.Errorf("change %d = %q, want %q", i, change.String(), lines[i])
+		}
+	}
+}
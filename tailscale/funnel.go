@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os/exec"
+	"net"
+	"sort"
 	"strings"
-
-	"github.com/rs/zerolog/log"
+	"sync"
 
 	apptypes "github.com/marvinvr/docktail/types"
 )
@@ -28,6 +28,11 @@ type FunnelHandler struct {
 	Proxy string `json:"Proxy"`
 }
 
+// errFunnelStatusFormat is returned by getCurrentFunnels when the funnel
+// status is not in a format DockTail understands, such as after a Tailscale
+// upgrade changed it
+var errFunnelStatusFormat = errors.New("unrecognized funnel status format")
+
 type CurrentFunnel struct {
 	PublicPort  string
 	Protocol    string
@@ -78,12 +83,27 @@ func normalizeDesiredFunnelProtocol(protocol string) string {
 	return protocol
 }
 
-func desiredFunnelDestination(svc *apptypes.ContainerService) string {
+// funnelAddress returns the backend address for a service's funnel
+func funnelAddress(svc *apptypes.ContainerService) string {
+	if svc.FunnelIPAddress != "" {
+		return svc.FunnelIPAddress
+	}
+	return svc.IPAddress
+}
+
+// FunnelDestination returns the backend URL a service's funnel proxies to
+func FunnelDestination(svc *apptypes.ContainerService) string {
+	hostPort := net.JoinHostPort(funnelAddress(svc), svc.FunnelTargetPort)
 	switch svc.FunnelProtocol {
 	case "tcp", "tls-terminated-tcp":
-		return fmt.Sprintf("tcp://%s:%s", svc.IPAddress, svc.FunnelTargetPort)
+		return "tcp://" + hostPort
 	default:
-		return fmt.Sprintf("http://%s:%s", svc.IPAddress, svc.FunnelTargetPort)
+		// Self-signed https backends use https+insecure, as with serve destinations
+		scheme := svc.FunnelTargetProtocol
+		if scheme == "" {
+			scheme = "http"
+		}
+		return scheme + "://" + hostPort
 	}
 }
 
@@ -100,7 +120,7 @@ func currentFunnelMatchesDesired(current CurrentFunnel, svc *apptypes.ContainerS
 		return false
 	}
 
-	if current.Destination != "" && current.Destination != desiredFunnelDestination(svc) {
+	if current.Destination != "" && current.Destination != FunnelDestination(svc) {
 		return false
 	}
 
@@ -120,11 +140,11 @@ func managedFunnelPortSet(ports map[string]struct{}) map[string]struct{} {
 	return cloned
 }
 
-// getCurrentFunnels retrieves the current funnel status
+// getCurrentFunnels retrieves the current funnel status from the serve
+// config, which is what `funnel status --json` prints too.
 // Returns a map keyed by public port (for example "443").
 func (c *Client) getCurrentFunnels(ctx context.Context) (map[string]CurrentFunnel, error) {
-	cmd := c.tailscaleCmd(ctx, "funnel", "status", "--json")
-	output, err := cmd.CombinedOutput()
+	output, err := c.serveStatus(ctx)
 
 	if err != nil {
 		outputStr := string(output)
@@ -152,8 +172,13 @@ func (c *Client) getCurrentFunnels(ctx context.Context) (map[string]CurrentFunne
 	// Parse JSON output
 	var status FunnelStatus
 	if err := json.Unmarshal([]byte(outputStr), &status); err != nil {
-		log.Warn().Err(err).Str("output", outputStr).Msg("Failed to parse funnel status JSON, assuming no funnels")
-		return make(map[string]CurrentFunnel), nil
+		// Assuming no funnels would have them removed or re-added, so callers
+		// get an error and act conservatively instead
+		log.Error().
+			Err(err).
+			Str("output", outputStr).
+			Msg("Failed to parse funnel status JSON, the format may have changed; please report this output")
+		return nil, fmt.Errorf("%w: %v", errFunnelStatusFormat, err)
 	}
 
 	funnels := make(map[string]CurrentFunnel)
@@ -207,7 +232,7 @@ func (c *Client) getCurrentFunnels(ctx context.Context) (map[string]CurrentFunne
 
 // reconcileFunnels manages funnel configuration for all desired services
 // Funnel is INDEPENDENT of serve and can be configured separately
-func (c *Client) reconcileFunnels(ctx context.Context, desiredServices []*apptypes.ContainerService) error {
+func (c *Client) reconcileFunnels(ctx context.Context, desiredServices []*apptypes.ContainerService, opts ReconcileOptions, result *apptypes.ReconcileResult) error {
 	log.Debug().
 		Int("service_count", len(desiredServices)).
 		Msg("Reconciling funnel configurations")
@@ -215,52 +240,24 @@ func (c *Client) reconcileFunnels(ctx context.Context, desiredServices []*apptyp
 	// Get current funnel status
 	currentFunnels, err := c.getCurrentFunnels(ctx)
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to get current funnels, will proceed with desired state")
-		currentFunnels = make(map[string]CurrentFunnel)
-	}
-
-	// Build map of desired funnels and check for duplicate funnel-ports
-	// Tailscale limitation: only ONE funnel can be active per funnel-port
-	desiredFunnels := make(map[string]*apptypes.ContainerService)
-	funnelPortUsage := make(map[string]string) // funnel-port -> container name
-	var duplicatePortErrors []string
-
-	for _, svc := range desiredServices {
-		if svc.FunnelEnabled {
-			key := svc.FunnelFunnelPort
-			desiredFunnels[key] = svc
-
-			// Check for duplicate funnel-port usage
-			if existingContainer, exists := funnelPortUsage[svc.FunnelFunnelPort]; exists {
-				errMsg := fmt.Sprintf(
-					"funnel-port %s conflict: containers '%s' and '%s' cannot share the same funnel-port (Tailscale limitation: only ONE funnel per port)",
-					svc.FunnelFunnelPort, existingContainer, svc.ContainerName,
-				)
-				duplicatePortErrors = append(duplicatePortErrors, errMsg)
-				log.Error().
-					Str("funnel_port", svc.FunnelFunnelPort).
-					Str("container1", existingContainer).
-					Str("container2", svc.ContainerName).
-					Msg("Duplicate funnel-port detected - only one funnel can be active per port")
-			} else {
-				funnelPortUsage[svc.FunnelFunnelPort] = svc.ContainerName
-			}
-		}
+		return c.reconcileFunnelsConservatively(ctx, desiredServices, opts, result, err)
 	}
 
-	// If there are duplicate port errors, log them all and return error
-	if len(duplicatePortErrors) > 0 {
-		for _, errMsg := range duplicatePortErrors {
-			log.Error().Msg(errMsg)
-		}
-		return fmt.Errorf("funnel configuration error: %d containers have conflicting funnel-ports (only ONE funnel allowed per port)", len(duplicatePortErrors))
-	}
+	desiredFunnels, conflictErrors := desiredFunnelsByPort(desiredServices)
+	preserved := preservedFunnelPorts(desiredServices)
 
 	previouslyManaged := managedFunnelPortSet(c.managedFunnels)
 	staleManagedFunnels := make([]string, 0)
 	unmanagedCurrentFunnels := make([]string, 0)
+	preservedCurrentFunnels := make([]string, 0)
 
 	for publicPort := range currentFunnels {
+		if _, ok := preserved[publicPort]; ok {
+			if _, desired := desiredFunnels[publicPort]; !desired {
+				preservedCurrentFunnels = append(preservedCurrentFunnels, publicPort)
+				continue
+			}
+		}
 		if _, managed := previouslyManaged[publicPort]; managed {
 			if _, desired := desiredFunnels[publicPort]; !desired {
 				staleManagedFunnels = append(staleManagedFunnels, publicPort)
@@ -276,6 +273,23 @@ func (c *Client) reconcileFunnels(ctx context.Context, desiredServices []*apptyp
 				Strs("stale_public_ports", staleManagedFunnels).
 				Strs("unmanaged_public_ports", unmanagedCurrentFunnels).
 				Msg("Skipping stale funnel cleanup because unmanaged funnels exist on this node")
+		} else if len(preservedCurrentFunnels) > 0 {
+			// Resetting would remove the funnels of skipped containers too
+			log.Warn().
+				Strs("stale_public_ports", staleManagedFunnels).
+				Strs("preserved_public_ports", preservedCurrentFunnels).
+				Msg("Skipping stale funnel cleanup because funnels of skipped containers exist on this node")
+		} else if opts.Maintenance {
+			log.Warn().
+				Strs("public_ports", staleManagedFunnels).
+				Msg("Maintenance mode: deferring stale funnel cleanup until it ends")
+		} else if opts.DryRun {
+			log.Info().
+				Strs("public_ports", staleManagedFunnels).
+				Msg("Dry run: would reset DockTail-managed funnel configuration")
+			c.planCommand("funnel", "reset")
+			result.FunnelsRemoved = append(result.FunnelsRemoved, staleManagedFunnels...)
+			currentFunnels = make(map[string]CurrentFunnel)
 		} else {
 			log.Info().
 				Strs("public_ports", staleManagedFunnels).
@@ -284,14 +298,16 @@ func (c *Client) reconcileFunnels(ctx context.Context, desiredServices []*apptyp
 			if err := c.resetFunnels(ctx, "reconcile"); err != nil {
 				return err
 			}
+			result.FunnelsRemoved = append(result.FunnelsRemoved, staleManagedFunnels...)
 			currentFunnels = make(map[string]CurrentFunnel)
 			staleManagedFunnels = nil
 		}
 	}
 
-	// Find funnels to add or update.
-	var applyErrors []error
+	// Find funnels to add or update. Desired funnels are keyed by public port,
+	// so no two concurrent commands touch the same port.
 	successfulFunnels := make(map[string]struct{}, len(desiredFunnels)+len(staleManagedFunnels))
+	var pending []string
 	for publicPort, svc := range desiredFunnels {
 		current, exists := currentFunnels[publicPort]
 
@@ -304,33 +320,198 @@ func (c *Client) reconcileFunnels(ctx context.Context, desiredServices []*apptyp
 			continue
 		}
 
+		if opts.DryRun {
+			log.Info().
+				Str("container", svc.ContainerName).
+				Str("public_port", svc.FunnelFunnelPort).
+				Msg("Dry run: would enable funnel")
+			if args, err := funnelArgs(svc); err == nil {
+				c.planCommand(args...)
+			}
+			result.FunnelsAdded = append(result.FunnelsAdded, publicPort)
+			continue
+		}
+		pending = append(pending, publicPort)
+	}
+	applyErrors := c.enableFunnels(ctx, pending, desiredFunnels, successfulFunnels, result)
+	sort.Strings(result.FunnelsAdded)
+	sort.Strings(result.FunnelsRemoved)
+
+	// Ownership only changes when funnels are actually applied. Preserved
+	// funnels DockTail enabled stay its own, for when their container is
+	// managed again or goes away.
+	if !opts.DryRun {
+		for _, publicPort := range staleManagedFunnels {
+			successfulFunnels[publicPort] = struct{}{}
+		}
+		for _, publicPort := range preservedCurrentFunnels {
+			if _, managed := previouslyManaged[publicPort]; managed {
+				successfulFunnels[publicPort] = struct{}{}
+			}
+		}
+		c.managedFunnels = successfulFunnels
+		c.saveManagedFunnels()
+	}
+
+	return funnelErrors(conflictErrors, applyErrors)
+}
+
+// preservedFunnelPorts returns the public ports of the funnels of containers
+// DockTail is told not to manage, which are left as they are. Services kept
+// back for other reasons still enable their funnel, so they aren't included.
+func preservedFunnelPorts(desiredServices []*apptypes.ContainerService) map[string]struct{} {
+	ports := make(map[string]struct{})
+	for _, svc := range desiredServices {
+		if svc.Preserve && !svc.FunnelEnabled && svc.FunnelFunnelPort != "" {
+			ports[svc.FunnelFunnelPort] = struct{}{}
+		}
+	}
+	return ports
+}
+
+// desiredFunnelsByPort returns the desired funnels keyed by public port.
+// Tailscale limitation: only ONE funnel can be active per funnel-port.
+// The first container to claim a port keeps it; later claimers are skipped
+// so one misconfigured container doesn't block every other funnel.
+func desiredFunnelsByPort(desiredServices []*apptypes.ContainerService) (map[string]*apptypes.ContainerService, []error) {
+	desiredFunnels := make(map[string]*apptypes.ContainerService)
+	var conflictErrors []error
+
+	for _, svc := range desiredServices {
+		if !svc.FunnelEnabled {
+			continue
+		}
+
+		if existing, exists := desiredFunnels[svc.FunnelFunnelPort]; exists {
+			conflictErrors = append(conflictErrors, fmt.Errorf(
+				"funnel-port %s conflict: containers '%s' and '%s' cannot share the same funnel-port (Tailscale limitation: only ONE funnel per port)",
+				svc.FunnelFunnelPort, existing.ContainerName, svc.ContainerName,
+			))
+			log.Error().
+				Str("funnel_port", svc.FunnelFunnelPort).
+				Str("container1", existing.ContainerName).
+				Str("container2", svc.ContainerName).
+				Msg("Duplicate funnel-port detected - only one funnel can be active per port, skipping the later container")
+			continue
+		}
+
+		desiredFunnels[svc.FunnelFunnelPort] = svc
+	}
+	return desiredFunnels, conflictErrors
+}
+
+// enableFunnels enables the pending funnels concurrently, recording the ones
+// that succeeded in successful and result. It returns the failures.
+func (c *Client) enableFunnels(ctx context.Context, pending []string, desiredFunnels map[string]*apptypes.ContainerService, successful map[string]struct{}, result *apptypes.ReconcileResult) []error {
+	sort.Strings(pending)
+
+	var applyErrors []error
+	var mu sync.Mutex
+	forEachConcurrently(pending, c.concurrency, func(publicPort string) {
+		svc := desiredFunnels[publicPort]
 		log.Info().
 			Str("container", svc.ContainerName).
 			Str("public_port", svc.FunnelFunnelPort).
 			Msg("Enabling funnel")
 
-		if err := c.addFunnel(ctx, svc); err != nil {
+		err := c.addFunnel(ctx, svc)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
 			log.Error().
 				Err(err).
 				Str("container", svc.ContainerName).
 				Msg("Failed to enable funnel")
+			c.reporter.Report(ctx, err, map[string]string{
+				"container":   svc.ContainerName,
+				"public_port": publicPort,
+			})
 			applyErrors = append(applyErrors, fmt.Errorf("%s:%s: %w", svc.ContainerName, publicPort, err))
-			continue
+			return
 		}
 
-		successfulFunnels[publicPort] = struct{}{}
+		successful[publicPort] = struct{}{}
+		result.FunnelsAdded = append(result.FunnelsAdded, publicPort)
+
+		// TCP funnels pass TLS through to the backend and need no certificate
+		if svc.FunnelProtocol != "tcp" {
+			c.prefetchNodeCert()
+		}
+	})
+	return applyErrors
+}
+
+// reconcileFunnelsConservatively reconciles funnels when their current status
+// can't be read. DockTail can't tell which funnels exist, so nothing is reset
+// or removed and only desired funnels it doesn't already manage are enabled.
+// The managed funnels stay recorded for the next cycle that reads the status.
+func (c *Client) reconcileFunnelsConservatively(ctx context.Context, desiredServices []*apptypes.ContainerService, opts ReconcileOptions, result *apptypes.ReconcileResult, statusErr error) error {
+	log.Warn().
+		Err(statusErr).
+		Msg("Failed to get current funnels, enabling new funnels only and removing none")
+
+	desiredFunnels, conflictErrors := desiredFunnelsByPort(desiredServices)
+
+	var pending []string
+	for publicPort, svc := range desiredFunnels {
+		if _, managed := c.managedFunnels[publicPort]; managed {
+			continue
+		}
+		if opts.DryRun {
+			log.Info().
+				Str("container", svc.ContainerName).
+				Str("public_port", svc.FunnelFunnelPort).
+				Msg("Dry run: would enable funnel")
+			if args, err := funnelArgs(svc); err == nil {
+				c.planCommand(args...)
+			}
+			result.FunnelsAdded = append(result.FunnelsAdded, publicPort)
+			continue
+		}
+		pending = append(pending, publicPort)
 	}
 
-	for _, publicPort := range staleManagedFunnels {
-		successfulFunnels[publicPort] = struct{}{}
+	successfulFunnels := managedFunnelPortSet(c.managedFunnels)
+	applyErrors := c.enableFunnels(ctx, pending, desiredFunnels, successfulFunnels, result)
+	sort.Strings(result.FunnelsAdded)
+
+	if !opts.DryRun && len(successfulFunnels) > len(c.managedFunnels) {
+		c.managedFunnels = successfulFunnels
+		c.saveManagedFunnels()
 	}
-	c.managedFunnels = successfulFunnels
 
+	return funnelErrors(conflictErrors, applyErrors)
+}
+
+// funnelErrors joins the errors of a funnel reconciliation
+func funnelErrors(conflictErrors, applyErrors []error) error {
+	var errs []error
+	if len(conflictErrors) > 0 {
+		errs = append(errs, fmt.Errorf("funnel configuration error: %d containers have conflicting funnel-ports (only ONE funnel allowed per port): %w", len(conflictErrors), errors.Join(conflictErrors...)))
+	}
 	if len(applyErrors) > 0 {
-		return fmt.Errorf("failed to enable %d funnel(s): %w", len(applyErrors), errors.Join(applyErrors...))
+		errs = append(errs, fmt.Errorf("failed to enable %d funnel(s): %w", len(applyErrors), errors.Join(applyErrors...)))
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// funnelArgs returns the tailscale arguments enabling the funnel of svc
+// Note: Funnel uses machine hostname, NOT service names
+func funnelArgs(svc *apptypes.ContainerService) ([]string, error) {
+	switch svc.FunnelProtocol {
+	case "https", "http":
+		// HTTPS funnel: tailscale funnel --bg --https=<funnel-port> http://localhost:<host-port>
+		return []string{"funnel", "--bg", "--https=" + svc.FunnelFunnelPort, FunnelDestination(svc)}, nil
+	case "tcp":
+		// TCP funnel: tailscale funnel --bg --tcp=<funnel-port> tcp://localhost:<host-port>
+		return []string{"funnel", "--bg", "--tcp=" + svc.FunnelFunnelPort, FunnelDestination(svc)}, nil
+	case "tls-terminated-tcp":
+		// TLS-terminated TCP funnel
+		return []string{"funnel", "--bg", "--tls-terminated-tcp=" + svc.FunnelFunnelPort, FunnelDestination(svc)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported funnel protocol: %s", svc.FunnelProtocol)
+	}
 }
 
 // addFunnel enables Tailscale Funnel for a service (public internet access)
@@ -342,33 +523,13 @@ func (c *Client) addFunnel(ctx context.Context, svc *apptypes.ContainerService)
 	}
 
 	// Build destination using funnel's own target port
-	funnelDestination := desiredFunnelDestination(svc)
-
-	var cmd *exec.Cmd
-
-	// Build funnel command based on protocol
-	// Note: Funnel uses machine hostname, NOT service names
-	switch svc.FunnelProtocol {
-	case "https", "http":
-		// HTTPS funnel: tailscale funnel --bg --https=<funnel-port> http://localhost:<host-port>
-		portArg := fmt.Sprintf("--https=%s", svc.FunnelFunnelPort)
-		cmd = c.tailscaleCmd(ctx, "funnel", "--bg", portArg, funnelDestination)
-
-	case "tcp":
-		// TCP funnel: tailscale funnel --bg --tcp=<funnel-port> tcp://localhost:<host-port>
-		portArg := fmt.Sprintf("--tcp=%s", svc.FunnelFunnelPort)
-		tcpDest := fmt.Sprintf("tcp://%s:%s", svc.IPAddress, svc.FunnelTargetPort)
-		cmd = c.tailscaleCmd(ctx, "funnel", "--bg", portArg, tcpDest)
-
-	case "tls-terminated-tcp":
-		// TLS-terminated TCP funnel
-		portArg := fmt.Sprintf("--tls-terminated-tcp=%s", svc.FunnelFunnelPort)
-		tcpDest := fmt.Sprintf("tcp://%s:%s", svc.IPAddress, svc.FunnelTargetPort)
-		cmd = c.tailscaleCmd(ctx, "funnel", "--bg", portArg, tcpDest)
+	funnelDestination := FunnelDestination(svc)
 
-	default:
-		return fmt.Errorf("unsupported funnel protocol: %s", svc.FunnelProtocol)
+	args, err := funnelArgs(svc)
+	if err != nil {
+		return err
 	}
+	cmd := c.tailscaleCmd(ctx, args...)
 
 	log.Debug().
 		Str("command", cmd.String()).
@@ -380,7 +541,7 @@ func (c *Client) addFunnel(ctx context.Context, svc *apptypes.ContainerService)
 		Str("destination", funnelDestination).
 		Msg("Executing tailscale funnel command (uses machine hostname, not service name)")
 
-	output, err := cmd.CombinedOutput()
+	output, err := c.combinedOutput(cmd)
 	if err != nil {
 		stderr := string(output)
 		if isFunnelACLError(stderr) {
@@ -395,6 +556,14 @@ func (c *Client) addFunnel(ctx context.Context, svc *apptypes.ContainerService)
 	}
 
 	currentFunnels, verifyErr := c.getCurrentFunnels(ctx)
+	if errors.Is(verifyErr, errFunnelStatusFormat) {
+		// The command succeeded; only the status can't be read to confirm it
+		log.Warn().
+			Str("container", svc.ContainerName).
+			Str("public_port", svc.FunnelFunnelPort).
+			Msg("Funnel command succeeded, but the funnel status can't be read to verify it")
+		return nil
+	}
 	if verifyErr != nil {
 		return fmt.Errorf("funnel command succeeded but status verification failed: %w", verifyErr)
 	}
@@ -416,11 +585,16 @@ func (c *Client) addFunnel(ctx context.Context, svc *apptypes.ContainerService)
 		)
 	}
 
+	url := c.funnelURL(svc)
+	if url == "" {
+		url = "https://<machine-hostname>.<tailnet>.ts.net:" + svc.FunnelFunnelPort
+	}
 	log.Info().
 		Str("container", svc.ContainerName).
 		Str("public_port", svc.FunnelFunnelPort).
 		Str("protocol", svc.FunnelProtocol).
-		Msg("Funnel enabled - publicly accessible at https://<machine-hostname>.<tailnet>.ts.net:" + svc.FunnelFunnelPort)
+		Str("url", url).
+		Msg("Funnel enabled - publicly accessible at " + url)
 
 	return nil
 }
@@ -438,7 +612,7 @@ func (c *Client) resetFunnels(ctx context.Context, reason string) error {
 		Str("reason", reason).
 		Msg("Executing tailscale funnel reset command")
 
-	output, err := cmd.CombinedOutput()
+	output, err := c.combinedOutput(cmd)
 	if err != nil {
 		stderr := string(output)
 		// Ignore errors if funnel doesn't exist
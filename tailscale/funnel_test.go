@@ -0,0 +1,266 @@
+package tailscale
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// fakeTailscaleScript is a minimal stand-in for the tailscale CLI. Like the
+// real one, `serve status --json` and `funnel status --json` print the same
+// config: serve-status.json with the HTTPS funnels enabled with `funnel --bg`,
+// which are recorded in a state file (or funnel-status replaces it all), and
+// every invocation is appended to a log file. When a no-redirect file exists,
+// redirect targets are rejected like older releases do, and commands listed in
+// a fail file exit with an error. Each line of a fail-once file fails the
+// command it lists once.
+const fakeTailscaleScript = `#!/bin/sh
+dir=$(dirname "$0")
+state="$dir/funnels"
+echo "$*" >> "$dir/calls.log"
+if [ -e "$dir/fail" ] && grep -qxF -- "$*" "$dir/fail"; then
+	echo "error: injected failure" >&2; exit 1
+fi
+if [ -e "$dir/fail-once" ] && grep -qxF -- "$*" "$dir/fail-once"; then
+	awk -v call="$*" '!done && $0 == call { done = 1; next } { print }' "$dir/fail-once" > "$dir/fail-once.tmp"
+	mv "$dir/fail-once.tmp" "$dir/fail-once"
+	echo "error: injected failure" >&2; exit 1
+fi
+if [ -e "$dir/no-redirect" ]; then
+	case "$*" in *redirect:*) echo "error: invalid target" >&2; exit 1 ;; esac
+fi
+case "$1 $2" in
+"serve status"|"funnel status")
+	if [ -e "$dir/funnel-status" ]; then cat "$dir/funnel-status"; exit 0; fi
+	serve=$(cat "$dir/serve-status.json" 2>/dev/null || printf '{}')
+	serve=${serve%\}}
+	printf '%s' "$serve"
+	case "$serve" in *[!{[:space:]]*) printf ',' ;; esac
+	printf '"AllowFunnel":{'
+	sep=""
+	while read -r port dest; do printf '%s"node.ts.net:%s":true' "$sep" "$port"; sep=","; done < "$state"
+	printf '},"Web":{'
+	sep=""
+	while read -r port dest; do printf '%s"node.ts.net:%s":{"Handlers":{"/":{"Proxy":"%s"}}}' "$sep" "$port" "$dest"; sep=","; done < "$state"
+	printf '}}'
+	;;
+"funnel --bg")
+	echo "${3#--https=} $4" >> "$state"
+	;;
+"cert --cert-file=-")
+	cat "$dir/cert.pem" 2>/dev/null
+	;;
+esac
+`
+
+// fakeTailscale is a fake tailscale CLI installed on PATH for a test
+type fakeTailscale struct {
+	dir string
+}
+
+// installFakeTailscale puts the fake tailscale CLI first on PATH
+func installFakeTailscale(t *testing.T) *fakeTailscale {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tailscale CLI requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tailscale"), []byte(fakeTailscaleScript), 0o755); err != nil {
+		t.Fatalf("failed to write fake tailscale CLI: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "funnels"), nil, 0o644); err != nil {
+		t.Fatalf("failed to write fake funnel state: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return &fakeTailscale{dir: dir}
+}
+
+// setServeStatus sets the output of `serve status --json`
+func (f *fakeTailscale) setServeStatus(t *testing.T, status string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, "serve-status.json"), []byte(status), 0o644); err != nil {
+		t.Fatalf("failed to write fake serve status: %v", err)
+	}
+}
+
+// setFunnelStatus replaces the output of `serve status --json` and
+// `funnel status --json`
+func (f *fakeTailscale) setFunnelStatus(t *testing.T, status string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, "funnel-status"), []byte(status), 0o644); err != nil {
+		t.Fatalf("failed to write fake funnel status: %v", err)
+	}
+}
+
+// failCalls makes the given invocations fail
+func (f *fakeTailscale) failCalls(t *testing.T, calls ...string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, "fail"), []byte(strings.Join(calls, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write fake failures: %v", err)
+	}
+}
+
+// failCallsOnce makes each given invocation fail once; list an invocation
+// twice to fail it twice
+func (f *fakeTailscale) failCallsOnce(t *testing.T, calls ...string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, "fail-once"), []byte(strings.Join(calls, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write fake failures: %v", err)
+	}
+}
+
+// calls returns the recorded CLI invocations
+func (f *fakeTailscale) calls() []string {
+	data, err := os.ReadFile(filepath.Join(f.dir, "calls.log"))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func funnelService(container, publicPort, targetPort string) *apptypes.ContainerService {
+	return &apptypes.ContainerService{
+		ContainerName:    container,
+		IPAddress:        "172.17.0.2",
+		FunnelEnabled:    true,
+		FunnelTargetPort: targetPort,
+		FunnelFunnelPort: publicPort,
+		FunnelProtocol:   "https",
+	}
+}
+
+func TestReconcileFunnelsSkipsOnlyConflictingContainers(t *testing.T) {
+	fake := installFakeTailscale(t)
+
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{
+		funnelService("first", "443", "8080"),
+		funnelService("second", "8443", "8081"),
+		funnelService("conflicting", "443", "8082"),
+		funnelService("third", "10000", "8083"),
+	}
+	result := &apptypes.ReconcileResult{}
+
+	err := client.reconcileFunnels(context.Background(), desired, ReconcileOptions{}, result)
+	if err == nil {
+		t.Fatal("expected conflict error but got nil")
+	}
+	if !strings.Contains(err.Error(), "'first' and 'conflicting'") {
+		t.Errorf("error %q should name the conflicting containers", err.Error())
+	}
+
+	if want := []string{"10000", "443", "8443"}; !reflect.DeepEqual(result.FunnelsAdded, want) {
+		t.Errorf("FunnelsAdded = %v, want %v", result.FunnelsAdded, want)
+	}
+
+	var enabled []string
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "funnel --bg") {
+			enabled = append(enabled, call)
+		}
+	}
+	want := []string{
+		"funnel --bg --https=443 http://172.17.0.2:8080",
+		"funnel --bg --https=8443 http://172.17.0.2:8081",
+		"funnel --bg --https=10000 http://172.17.0.2:8083",
+	}
+	if len(enabled) != len(want) {
+		t.Fatalf("funnel commands = %v, want %v", enabled, want)
+	}
+	for _, w := range want {
+		found := false
+		for _, e := range enabled {
+			if e == w {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing funnel command %q in %v", w, enabled)
+		}
+	}
+
+	for _, port := range []string{"443", "8443", "10000"} {
+		if _, ok := client.managedFunnels[port]; !ok {
+			t.Errorf("funnel on port %s should be tracked as managed", port)
+		}
+	}
+}
+
+func TestReconcileFunnelsKeepsPreservedFunnels(t *testing.T) {
+	fake := installFakeTailscale(t)
+
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{
+		funnelService("web", "443", "8080"),
+		funnelService("app", "8443", "8081"),
+	}
+	if err := client.reconcileFunnels(context.Background(), desired, ReconcileOptions{}, &apptypes.ReconcileResult{}); err != nil {
+		t.Fatalf("reconcileFunnels() error = %v", err)
+	}
+
+	// app's image is no longer allowed, so its funnel is left alone, while
+	// web went away and its funnel is stale
+	preserved := &apptypes.ContainerService{ContainerName: "app", FunnelFunnelPort: "8443", Preserve: true}
+	result := &apptypes.ReconcileResult{}
+	if err := client.reconcileFunnels(context.Background(), []*apptypes.ContainerService{preserved}, ReconcileOptions{}, result); err != nil {
+		t.Fatalf("reconcileFunnels() error = %v", err)
+	}
+
+	for _, call := range fake.calls() {
+		if call == "funnel reset" || strings.HasSuffix(call, " off") {
+			t.Errorf("unexpected call %q with the funnel of a skipped container on the node", call)
+		}
+	}
+	if len(result.FunnelsRemoved) != 0 {
+		t.Errorf("FunnelsRemoved = %v, want none", result.FunnelsRemoved)
+	}
+	want := map[string]struct{}{"443": {}, "8443": {}}
+	if !reflect.DeepEqual(client.managedFunnels, want) {
+		t.Errorf("managedFunnels = %v, want %v", client.managedFunnels, want)
+	}
+}
+
+func TestReconcileFunnelsWithUnreadableStatusRemovesNothing(t *testing.T) {
+	fake := installFakeTailscale(t)
+	// A format this release doesn't understand
+	fake.setFunnelStatus(t, `{"AllowFunnel":["node.ts.net:443","node.ts.net:8443"]}`)
+
+	client := NewClient(ClientConfig{})
+	client.managedFunnels = map[string]struct{}{"443": {}, "8443": {}}
+	desired := []*apptypes.ContainerService{
+		funnelService("web", "443", "8080"),
+		funnelService("new", "10000", "8083"),
+	}
+	result := &apptypes.ReconcileResult{}
+
+	if err := client.reconcileFunnels(context.Background(), desired, ReconcileOptions{}, result); err != nil {
+		t.Fatalf("reconcileFunnels() error = %v", err)
+	}
+
+	// 8443 is no longer desired, but can't be seen to exist, so it stays
+	if len(result.FunnelsRemoved) != 0 {
+		t.Errorf("FunnelsRemoved = %v, want none", result.FunnelsRemoved)
+	}
+	if want := []string{"10000"}; !reflect.DeepEqual(result.FunnelsAdded, want) {
+		t.Errorf("FunnelsAdded = %v, want %v", result.FunnelsAdded, want)
+	}
+	for _, call := range fake.calls() {
+		if call == "funnel reset" || strings.HasSuffix(call, " off") || strings.Contains(call, "--https=443 ") {
+			t.Errorf("unexpected call %q with an unreadable funnel status", call)
+		}
+	}
+
+	// Ownership is kept for the cycle that can read the status again
+	want := map[string]struct{}{"443": {}, "8443": {}, "10000": {}}
+	if !reflect.DeepEqual(client.managedFunnels, want) {
+		t.Errorf("managedFunnels = %v, want %v", client.managedFunnels, want)
+	}
+}
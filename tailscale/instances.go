@@ -0,0 +1,162 @@
+package tailscale
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/marvinvr/docktail/atomicfile"
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// instanceFile records the DockTail instance that last reconciled from the
+// state directory
+const instanceFile = "instance.json"
+
+// externalChanges counts service endpoints found changed since DockTail
+// applied them
+var externalChanges = expvar.NewInt("docktail_external_changes_total")
+
+// instanceMarker identifies a running DockTail
+type instanceMarker struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// newInstanceMarker returns the marker of this process
+func newInstanceMarker() instanceMarker {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		id = fmt.Appendf(nil, "%d-%d", os.Getpid(), time.Now().UnixNano())
+	}
+	hostname, _ := os.Hostname()
+	return instanceMarker{
+		ID:        hex.EncodeToString(id),
+		PID:       os.Getpid(),
+		Hostname:  hostname,
+		StartedAt: time.Now().UTC(),
+	}
+}
+
+// claimInstance records this instance in the state directory, first warning
+// when the marker there names another instance, which means two DockTail
+// processes reconcile from the same state directory. The marker a previous
+// run left behind is replaced silently; a foreign marker written after this
+// instance claimed the directory, or after it started, is not. Each other
+// instance is warned about once.
+func (c *Client) claimInstance() {
+	if c.stateDir == "" {
+		return
+	}
+	if c.instance.ID == "" {
+		c.instance = newInstanceMarker()
+	}
+
+	path := filepath.Join(c.stateDir, instanceFile)
+	var recorded instanceMarker
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		log.Warn().Err(err).Str("path", path).Msg("Failed to read the DockTail instance marker")
+	case json.Unmarshal(data, &recorded) != nil:
+		log.Warn().Str("path", path).Msg("Ignoring an unreadable DockTail instance marker")
+	case recorded.ID == c.instance.ID || recorded.ID == c.otherInstance:
+	case c.instanceClaimed || recorded.StartedAt.After(c.instance.StartedAt):
+		c.otherInstance = recorded.ID
+		log.Error().
+			Str("instance", c.instance.ID).
+			Str("other_instance", recorded.ID).
+			Int("other_pid", recorded.PID).
+			Str("other_hostname", recorded.Hostname).
+			Time("other_started_at", recorded.StartedAt).
+			Str("state_dir", c.stateDir).
+			Msg("Another DockTail instance reconciles from the same STATE_DIR; the two will undo each other's changes, so stop one or give each its own STATE_DIR and SERVICE_NAME_PREFIX")
+	}
+
+	data, err = json.Marshal(c.instance)
+	if err != nil {
+		return
+	}
+	if err := atomicfile.Write(path, data); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to write the DockTail instance marker")
+		return
+	}
+	c.instanceClaimed = true
+}
+
+// detectExternalChanges compares the serve config with the endpoints this
+// client applied in its previous cycle. An endpoint that changed in between
+// was changed by something else, typically another DockTail instance
+// managing the same service, which would otherwise go unnoticed while the
+// two keep reverting each other.
+func (c *Client) detectExternalChanges(current map[string]ServiceEndpoint) []string {
+	var changedKeys []string
+	for key, applied := range c.applied {
+		now, ok := current[key]
+		if ok && now.Destination == applied.Destination && now.Protocol == applied.Protocol && now.ProxyProtocol == applied.ProxyProtocol {
+			continue
+		}
+		changedKeys = append(changedKeys, key)
+	}
+	sort.Strings(changedKeys)
+
+	streaks := make(map[string]int, len(changedKeys))
+	for _, key := range changedKeys {
+		streaks[key] = c.externalStreaks[key] + 1
+		externalChanges.Add(1)
+
+		now := current[key]
+		event := log.Warn()
+		if streaks[key] > 1 {
+			event = log.Error()
+		}
+		event.
+			Str("key", key).
+			Str("applied_destination", c.applied[key].Destination).
+			Str("current_destination", now.Destination).
+			Int("consecutive_cycles", streaks[key]).
+			Msg("Service endpoint changed outside DockTail since it was applied; if another DockTail instance manages this service, give each instance its own SERVICE_NAME_PREFIX")
+	}
+	c.externalStreaks = streaks
+	return changedKeys
+}
+
+// appliedKeys returns the sorted keys of applied endpoints, never nil
+func appliedKeys(applied map[string]ServiceEndpoint) []string {
+	keys := make([]string, 0, len(applied))
+	for key := range applied {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// recordApplied keeps the endpoints a cycle left configured as desired, for
+// detectExternalChanges in the next cycle. Failed services may be in any
+// state, so they aren't kept.
+func (c *Client) recordApplied(desired map[string]*apptypes.ContainerService, failed map[string]string) {
+	c.applied = make(map[string]ServiceEndpoint, len(desired))
+	for key, svc := range desired {
+		if _, ok := failed[key]; ok {
+			continue
+		}
+		c.applied[key] = ServiceEndpoint{
+			ServiceName:   "svc:" + svc.ServiceName,
+			Port:          svc.Port,
+			Path:          svc.Path,
+			Protocol:      endpointProtocol(svc.ServiceProtocol),
+			Destination:   BuildDestination(svc),
+			ProxyProtocol: svc.ProxyProtocol,
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestReconcileDetectsExternalChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		service *apptypes.ContainerService
+		// status returns the serve config with the endpoint proxying to ip
+		status func(ip string) string
+	}{
+		{
+			name:    "https",
+			key:     "svc:web:443",
+			service: &apptypes.ContainerService{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+			status: func(ip string) string {
+				return `{"Services":{"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://` + ip + `:80"}}}}}}}`
+			},
+		},
+		{
+			name:    "tcp",
+			key:     "svc:db:5432",
+			service: &apptypes.ContainerService{ContainerName: "db", ServiceEnabled: true, ServiceName: "db", Port: "5432", ServiceProtocol: "tcp", Protocol: "tcp", IPAddress: "172.17.0.2", TargetPort: "5432"},
+			status: func(ip string) string {
+				return `{"Services":{"svc:db":{"TCP":{"5432":{"TCPForward":"` + ip + `:5432"}}}}}`
+			},
+		},
+		{
+			name:    "tls-terminated-tcp",
+			key:     "svc:db:5432",
+			service: &apptypes.ContainerService{ContainerName: "db", ServiceEnabled: true, ServiceName: "db", Port: "5432", ServiceProtocol: "tls-terminated-tcp", Protocol: "tcp", IPAddress: "172.17.0.2", TargetPort: "5432"},
+			status: func(ip string) string {
+				return `{"Services":{"svc:db":{"TCP":{"5432":{"TCPForward":"` + ip + `:5432"}}}}}`
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			fake.setServeStatus(t, tt.status("172.17.0.2"))
+			client := NewClient(ClientConfig{})
+			client.serveRetryDelays = nil
+			desired := []*apptypes.ContainerService{tt.service}
+
+			saved := externalChanges.Value()
+			reconcile := func() *apptypes.ReconcileResult {
+				t.Helper()
+				result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+				if err != nil {
+					t.Fatalf("ReconcileServices() error = %v", err)
+				}
+				return result
+			}
+
+			// The config matches what DockTail applied, twice in a row
+			for range 2 {
+				result := reconcile()
+				if len(result.Added)+len(result.Changed) > 0 {
+					t.Errorf("Added = %v, Changed = %v with the endpoint configured as desired, want none", result.Added, result.Changed)
+				}
+				if len(result.Applied) != 1 || result.Applied[0] != tt.key {
+					t.Errorf("Applied = %v, want [%s]", result.Applied, tt.key)
+				}
+			}
+			if got := externalChanges.Value() - saved; got != 0 {
+				t.Fatalf("docktail_external_changes_total increased by %d with an unchanged config, want 0", got)
+			}
+
+			// Another instance points the service elsewhere between two cycles,
+			// and again after DockTail restored it
+			for cycle := 1; cycle <= 2; cycle++ {
+				fake.setServeStatus(t, tt.status("172.17.0.9"))
+				reconcile()
+				if got := externalChanges.Value() - saved; got != int64(cycle) {
+					t.Errorf("cycle %d: docktail_external_changes_total increased by %d, want %d", cycle, got, cycle)
+				}
+				if got := client.externalStreaks[tt.key]; got != cycle {
+					t.Errorf("cycle %d: consecutive changes of %s = %d, want %d", cycle, tt.key, got, cycle)
+				}
+			}
+
+			// Once the other instance stops, the streak ends
+			fake.setServeStatus(t, tt.status("172.17.0.2"))
+			reconcile()
+			if got := client.externalStreaks[tt.key]; got != 0 {
+				t.Errorf("consecutive changes after the config stayed put = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestClaimInstanceDetectsAnotherInstance(t *testing.T) {
+	stateDir := t.TempDir()
+	readMarker := func() instanceMarker {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(stateDir, instanceFile))
+		if err != nil {
+			t.Fatalf("failed to read the instance marker: %v", err)
+		}
+		var marker instanceMarker
+		if err := json.Unmarshal(data, &marker); err != nil {
+			t.Fatalf("failed to decode the instance marker: %v", err)
+		}
+		return marker
+	}
+	writeMarker := func(marker instanceMarker) {
+		t.Helper()
+		data, err := json.Marshal(marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeStateFile(t, stateDir, instanceFile, string(data))
+	}
+
+	// The marker of a previous run is taken over silently
+	writeMarker(instanceMarker{ID: "previous", PID: 1, StartedAt: time.Now().Add(-time.Hour)})
+	client := NewClient(ClientConfig{StateDir: stateDir})
+	client.claimInstance()
+	if client.otherInstance != "" {
+		t.Errorf("otherInstance = %q after replacing a previous run's marker, want none", client.otherInstance)
+	}
+	if got := readMarker().ID; got != client.instance.ID || got == "" {
+		t.Errorf("marker ID = %q, want this instance's %q", got, client.instance.ID)
+	}
+
+	// Another instance rewrites the marker between two cycles
+	writeMarker(instanceMarker{ID: "other", PID: 2, StartedAt: time.Now().Add(-time.Hour)})
+	client.claimInstance()
+	if client.otherInstance != "other" {
+		t.Errorf("otherInstance = %q, want other", client.otherInstance)
+	}
+	if got := readMarker().ID; got != client.instance.ID {
+		t.Errorf("marker ID = %q, want this instance's %q", got, client.instance.ID)
+	}
+}
@@ -0,0 +1,116 @@
+package tailscale
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// DefaultRemoveGracePeriod is how long a service whose containers stopped is
+// kept drained before its serve config is cleared
+const DefaultRemoveGracePeriod = 30 * time.Second
+
+// recordOwners remembers which containers back each desired service, so a
+// later destroy event can be matched to the services it ends. Services that
+// are no longer desired keep their last owners until they are cleared.
+func (c *Client) recordOwners(desiredServices []*apptypes.ContainerService) {
+	owners := make(map[string]map[string]struct{})
+	for _, svc := range desiredServices {
+		if !svc.ServiceEnabled || svc.Preserve || svc.ContainerID == "" {
+			continue
+		}
+		serviceName := "svc:" + svc.ServiceName
+		if owners[serviceName] == nil {
+			owners[serviceName] = make(map[string]struct{})
+		}
+		owners[serviceName][svc.ContainerID] = struct{}{}
+	}
+	for serviceName, ids := range owners {
+		c.owners[serviceName] = ids
+	}
+}
+
+// holdRemovals takes the services that are going away entirely out of
+// toRemove while their grace period runs, and returns their names. A service
+// is held until the period has passed since it was drained, unless one of its
+// containers was destroyed.
+func (c *Client) holdRemovals(toRemove map[string]ServiceEndpoint, stillDesired map[string]struct{}, destroyed map[string]bool, now time.Time) []string {
+	if c.removeGrace <= 0 {
+		return nil
+	}
+
+	held := make(map[string]struct{})
+	for key, svc := range toRemove {
+		if _, keep := stillDesired[svc.ServiceName]; keep {
+			continue
+		}
+		if c.ownerDestroyed(svc.ServiceName, destroyed) {
+			continue
+		}
+		if since, ok := c.drainedAt[svc.ServiceName]; ok && now.Sub(since) >= c.removeGrace {
+			continue
+		}
+		held[svc.ServiceName] = struct{}{}
+		delete(toRemove, key)
+	}
+
+	names := make([]string, 0, len(held))
+	for serviceName := range held {
+		names = append(names, serviceName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ownerDestroyed reports whether any container last backing the service was destroyed
+func (c *Client) ownerDestroyed(serviceName string, destroyed map[string]bool) bool {
+	for id := range c.owners[serviceName] {
+		if destroyed[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// drainHeldServices drains held services that aren't drained yet and starts
+// their grace period. Their serve config stays in place, so a restarted
+// container only needs the service advertised again.
+func (c *Client) drainHeldServices(ctx context.Context, held []string, now time.Time) {
+	for _, serviceName := range held {
+		if _, ok := c.drainedAt[serviceName]; ok {
+			continue
+		}
+		if err := c.setAdvertised(ctx, serviceName, false); err != nil {
+			log.Error().
+				Err(err).
+				Str("service", serviceName).
+				Msg("Failed to drain service of stopped container")
+			continue
+		}
+		c.advertised[serviceName] = false
+		c.drainedAt[serviceName] = now
+		log.Info().
+			Str("service", serviceName).
+			Dur("grace_period", c.removeGrace).
+			Msg("Containers stopped, service drained until they return or the grace period ends")
+	}
+}
+
+// forgetDrained drops the grace period of services that are desired again or
+// no longer configured. Desired services are advertised again by
+// applyAdvertiseLabels, since draining marked them as not advertised.
+func (c *Client) forgetDrained(stillDesired map[string]struct{}, currentServices map[string]ServiceEndpoint) {
+	configured := make(map[string]struct{})
+	for _, svc := range currentServices {
+		configured[svc.ServiceName] = struct{}{}
+	}
+	for serviceName := range c.drainedAt {
+		_, desired := stillDesired[serviceName]
+		_, exists := configured[serviceName]
+		if desired || !exists {
+			delete(c.drainedAt, serviceName)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tailscale
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestReconcileServicesContainerLifecycle(t *testing.T) {
+	type step struct {
+		running     bool // the container is running
+		destroyed   bool // the container was destroyed since the last cycle
+		expired     bool // the grace period has passed since the service was drained
+		maintenance bool // maintenance mode defers removals
+		want        []string
+		wantNot     []string
+		wantRemoved bool
+		// wantDeferred is set when maintenance mode holds back the removal
+		wantDeferred bool
+	}
+
+	tests := []struct {
+		name  string
+		grace time.Duration
+		steps []step
+	}{
+		{
+			name:  "restart drains and advertises again",
+			grace: time.Hour,
+			steps: []step{
+				{running: true},
+				{want: []string{"serve drain svc:web"}, wantNot: []string{"serve clear svc:web"}},
+				{running: true, want: []string{"serve advertise svc:web"}, wantNot: []string{"serve --service", "serve clear"}},
+			},
+		},
+		{
+			name:  "stop and rm removes the service",
+			grace: time.Hour,
+			steps: []step{
+				{running: true},
+				{want: []string{"serve drain svc:web"}, wantNot: []string{"serve clear svc:web"}},
+				{destroyed: true, want: []string{"serve clear svc:web"}, wantRemoved: true},
+			},
+		},
+		{
+			name:  "crash is removed after the grace period",
+			grace: time.Hour,
+			steps: []step{
+				{running: true},
+				{want: []string{"serve drain svc:web"}, wantNot: []string{"serve clear svc:web"}},
+				{wantNot: []string{"serve drain", "serve clear"}},
+				{expired: true, want: []string{"serve clear svc:web"}, wantRemoved: true},
+			},
+		},
+		{
+			name: "without a grace period the service is removed right away",
+			steps: []step{
+				{running: true},
+				{want: []string{"serve drain svc:web", "serve clear svc:web"}, wantRemoved: true},
+			},
+		},
+		{
+			name: "maintenance defers the removal until it ends",
+			steps: []step{
+				{running: true},
+				{maintenance: true, wantNot: []string{"serve drain", "serve clear"}, wantDeferred: true},
+				{maintenance: true, wantNot: []string{"serve drain", "serve clear"}, wantDeferred: true},
+				{want: []string{"serve drain svc:web", "serve clear svc:web"}, wantRemoved: true},
+			},
+		},
+		{
+			name:  "maintenance defers draining and the grace period starts after it",
+			grace: time.Hour,
+			steps: []step{
+				{running: true},
+				{maintenance: true, destroyed: true, wantNot: []string{"serve drain", "serve clear"}, wantDeferred: true},
+				{want: []string{"serve drain svc:web"}, wantNot: []string{"serve clear svc:web"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			fake.setServeStatus(t, `{"Services":{"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}}}}`)
+			client := NewClient(ClientConfig{RemoveGracePeriod: tt.grace})
+
+			web := &apptypes.ContainerService{ContainerID: "aaa111bbb222", ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"}
+
+			for i, s := range tt.steps {
+				var desired []*apptypes.ContainerService
+				if s.running {
+					desired = append(desired, web)
+				}
+				opts := ReconcileOptions{Maintenance: s.maintenance}
+				if s.destroyed {
+					opts.Destroyed = map[string]bool{web.ContainerID: true}
+				}
+				if s.expired {
+					client.drainedAt["svc:web"] = time.Now().Add(-tt.grace)
+				}
+
+				before := len(fake.calls())
+				result, err := client.ReconcileServices(context.Background(), desired, opts)
+				if err != nil {
+					t.Fatalf("step %d: ReconcileServices() error = %v", i, err)
+				}
+
+				calls := strings.Join(fake.calls()[before:], "\n")
+				for _, want := range s.want {
+					if !strings.Contains(calls, want) {
+						t.Errorf("step %d: missing %q, calls:\n%s", i, want, calls)
+					}
+				}
+				for _, unwanted := range s.wantNot {
+					if strings.Contains(calls, unwanted) {
+						t.Errorf("step %d: unexpected %q, calls:\n%s", i, unwanted, calls)
+					}
+				}
+				if removed := len(result.Removed) > 0; removed != s.wantRemoved {
+					t.Errorf("step %d: Removed = %v, want removed %v", i, result.Removed, s.wantRemoved)
+				}
+				if deferred := len(result.Deferred) > 0; deferred != s.wantDeferred {
+					t.Errorf("step %d: Deferred = %v, want deferred %v", i, result.Deferred, s.wantDeferred)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// NodeInfo identifies the Tailscale node DockTail configures
+type NodeInfo struct {
+	DNSName        string `json:"dns_name"`         // Node FQDN, e.g. "docker-host.tail1234.ts.net"
+	MagicDNSSuffix string `json:"magic_dns_suffix"` // Tailnet domain, e.g. "tail1234.ts.net"
+}
+
+// nodeStatus is the subset of 'tailscale status --json' used to identify the node
+type nodeStatus struct {
+	Self *struct {
+		DNSName string `json:"DNSName"`
+	} `json:"Self"`
+	MagicDNSSuffix string `json:"MagicDNSSuffix"`
+	CurrentTailnet *struct {
+		MagicDNSSuffix string `json:"MagicDNSSuffix"`
+	} `json:"CurrentTailnet"`
+}
+
+// parseNodeInfo extracts the node identity from 'tailscale status --json' output
+func parseNodeInfo(output []byte) (NodeInfo, error) {
+	var status nodeStatus
+	if err := json.Unmarshal([]byte(stripWarnings(output)), &status); err != nil {
+		return NodeInfo{}, fmt.Errorf("failed to parse status JSON: %w", err)
+	}
+	if status.Self == nil || status.Self.DNSName == "" {
+		return NodeInfo{}, errors.New("status has no node DNS name (is MagicDNS enabled?)")
+	}
+
+	info := NodeInfo{
+		DNSName:        strings.TrimSuffix(status.Self.DNSName, "."),
+		MagicDNSSuffix: status.MagicDNSSuffix,
+	}
+	if info.MagicDNSSuffix == "" && status.CurrentTailnet != nil {
+		info.MagicDNSSuffix = status.CurrentTailnet.MagicDNSSuffix
+	}
+	if info.MagicDNSSuffix == "" {
+		// The tailnet domain is everything after the node's host label
+		if _, suffix, ok := strings.Cut(info.DNSName, "."); ok {
+			info.MagicDNSSuffix = suffix
+		}
+	}
+	return info, nil
+}
+
+// RefreshNodeInfo queries tailscaled for the node's DNS name and the services
+// its peers advertise. Failures keep the previously known identity.
+func (c *Client) RefreshNodeInfo(ctx context.Context) {
+	output, err := c.combinedOutput(c.tailscaleCmd(ctx, "status", "--json"))
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to query tailscale status for node identity")
+		// tailscaled may be restarting, so the serve config is read again
+		c.forgetVerified()
+		return
+	}
+
+	peerServices, err := parsePeerServices(output)
+	if err != nil {
+		log.Debug().Err(err).Msg("Could not determine services advertised by peers")
+	}
+	c.nodeMu.Lock()
+	c.peerServices = peerServices
+	c.nodeMu.Unlock()
+
+	info, err := parseNodeInfo(output)
+	if err != nil {
+		log.Debug().Err(err).Msg("Could not determine Tailscale node identity")
+		return
+	}
+
+	c.nodeMu.Lock()
+	previous := c.node
+	c.node = info
+	c.nodeMu.Unlock()
+
+	if previous != info {
+		log.Info().
+			Str("dns_name", info.DNSName).
+			Str("magic_dns_suffix", info.MagicDNSSuffix).
+			Msg("Tailscale node identity detected")
+	}
+}
+
+// NodeInfo returns the last known identity of the Tailscale node
+func (c *Client) NodeInfo() NodeInfo {
+	c.nodeMu.RLock()
+	defer c.nodeMu.RUnlock()
+	return c.node
+}
+
+// serviceDomain returns the DNS name of a service (name without the "svc:"
+// prefix), or "" when the tailnet domain is unknown
+func (c *Client) serviceDomain(serviceName string) string {
+	suffix := c.NodeInfo().MagicDNSSuffix
+	if suffix == "" {
+		return ""
+	}
+	return serviceName + "." + suffix
+}
+
+// serviceURL returns the address clients use to reach a service, or "" when the
+// tailnet domain is unknown
+func (c *Client) serviceURL(svc *apptypes.ContainerService) string {
+	domain := c.serviceDomain(svc.ServiceName)
+	if domain == "" {
+		return ""
+	}
+	host := net.JoinHostPort(domain, svc.Port)
+	switch svc.ServiceProtocol {
+	case "http", "https":
+		return svc.ServiceProtocol + "://" + host
+	default:
+		return host
+	}
+}
+
+// funnelURL returns the public address of a funnel, or "" when the node's DNS
+// name is unknown
+func (c *Client) funnelURL(svc *apptypes.ContainerService) string {
+	dnsName := c.NodeInfo().DNSName
+	if dnsName == "" {
+		return ""
+	}
+	host := net.JoinHostPort(dnsName, svc.FunnelFunnelPort)
+	switch svc.FunnelProtocol {
+	case "tcp", "tls-terminated-tcp":
+		return host
+	default:
+		return "https://" + host
+	}
+}
@@ -0,0 +1,91 @@
+package tailscale
+
+import (
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestParseNodeInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		expected  NodeInfo
+		wantError bool
+	}{
+		{
+			name:     "suffix from status",
+			output:   `{"Self":{"DNSName":"docker-host.tail1234.ts.net."},"MagicDNSSuffix":"tail1234.ts.net"}`,
+			expected: NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"},
+		},
+		{
+			name:     "suffix from current tailnet",
+			output:   `{"Self":{"DNSName":"docker-host.tail1234.ts.net."},"CurrentTailnet":{"MagicDNSSuffix":"tail1234.ts.net"}}`,
+			expected: NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"},
+		},
+		{
+			name:     "suffix derived from DNS name",
+			output:   `{"Self":{"DNSName":"docker-host.tail1234.ts.net."}}`,
+			expected: NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"},
+		},
+		{
+			name:     "leading warning",
+			output:   "Warning: client version \"1.80.0\" != tailscaled server version \"1.82.0\"\n" + `{"Self":{"DNSName":"nas.example.ts.net."}}`,
+			expected: NodeInfo{DNSName: "nas.example.ts.net", MagicDNSSuffix: "example.ts.net"},
+		},
+		{name: "no self", output: `{"MagicDNSSuffix":"tail1234.ts.net"}`, wantError: true},
+		{name: "MagicDNS disabled", output: `{"Self":{"DNSName":""}}`, wantError: true},
+		{name: "invalid JSON", output: `not json`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNodeInfo([]byte(tt.output))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("parseNodeInfo() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.expected {
+				t.Errorf("parseNodeInfo() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestServiceAndFunnelURLs(t *testing.T) {
+	client := NewClient(ClientConfig{})
+	svc := &apptypes.ContainerService{
+		ServiceName:      "web",
+		Port:             "443",
+		ServiceProtocol:  "https",
+		FunnelFunnelPort: "8443",
+		FunnelProtocol:   "https",
+	}
+
+	if got := client.serviceURL(svc); got != "" {
+		t.Errorf("serviceURL() with unknown node = %q, want empty", got)
+	}
+	if got := client.funnelURL(svc); got != "" {
+		t.Errorf("funnelURL() with unknown node = %q, want empty", got)
+	}
+
+	client.node = NodeInfo{DNSName: "docker-host.tail1234.ts.net", MagicDNSSuffix: "tail1234.ts.net"}
+
+	tests := []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"https service", client.serviceURL(svc), "https://web.tail1234.ts.net:443"},
+		{"tcp service", client.serviceURL(&apptypes.ContainerService{ServiceName: "db", Port: "5432", ServiceProtocol: "tcp"}), "db.tail1234.ts.net:5432"},
+		{"https funnel", client.funnelURL(svc), "https://docker-host.tail1234.ts.net:8443"},
+		{"tcp funnel", client.funnelURL(&apptypes.ContainerService{FunnelFunnelPort: "10000", FunnelProtocol: "tcp"}), "docker-host.tail1234.ts.net:10000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("got %q, want %q", tt.got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package tailscale
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// loadRecordedServices reads the services the previous run recorded in the
+// state directory, before this cycle's desired configuration replaces them.
+// They are read once and kept until ownership is known. Without a state file
+// recorded stays nil, since nothing is known about the services on the node.
+func (c *Client) loadRecordedServices() {
+	if c.ownershipKnown || c.recordedLoaded {
+		return
+	}
+	c.recordedLoaded = true
+	if c.stateDir == "" {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(c.stateDir, desiredConfigFile)); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	owned, err := c.ownedServices()
+	if err != nil {
+		// An unreadable record protects every service, like an empty one
+		log.Warn().Err(err).Msg("Failed to read the services recorded by the previous run")
+		owned = map[string]struct{}{}
+	}
+	c.recorded = owned
+}
+
+// holdUnmanagedRemovals takes the removals of services the previous run didn't
+// record out of toRemove, since they may have been made by hand, and returns
+// their keys. ALLOW_UNMANAGED_OVERWRITE removes them anyway, and so does a
+// first run without a state file, which can't tell them apart, with a warning
+// for each endpoint. The services in desired are DockTail's from now on. Once
+// nothing is held back, DockTail knows which services are its own and later
+// cycles skip the check. Dry runs leave what is known unchanged.
+func (c *Client) holdUnmanagedRemovals(toRemove map[string]ServiceEndpoint, desired map[string]struct{}, dryRun bool) []string {
+	if c.ownershipKnown {
+		return nil
+	}
+
+	var unmanaged []string
+	for key, endpoint := range toRemove {
+		if _, ok := c.recorded[endpoint.ServiceName]; !ok {
+			unmanaged = append(unmanaged, key)
+		}
+	}
+	sort.Strings(unmanaged)
+
+	var held []string
+	message := "Removing service endpoint not created by DockTail, ALLOW_UNMANAGED_OVERWRITE is set"
+	switch {
+	case c.allowUnmanagedOverwrite:
+	case c.recorded == nil && c.stateDir == "":
+		message = "Removing service endpoint DockTail has no record of; set STATE_DIR so services made by hand are kept after a restart"
+	case c.recorded == nil:
+		message = "Removing service endpoint DockTail has no record of, STATE_DIR holds no desired configuration of a previous run"
+	default:
+		message = "Keeping service endpoint not created by DockTail; set ALLOW_UNMANAGED_OVERWRITE=true to remove it"
+		held = unmanaged
+	}
+	for _, key := range unmanaged {
+		endpoint := toRemove[key]
+		log.Warn().
+			Str("key", key).
+			Str("service", endpoint.ServiceName).
+			Str("destination", endpoint.Destination).
+			Bool("dry_run", dryRun).
+			Msg(message)
+	}
+	for _, key := range held {
+		delete(toRemove, key)
+	}
+
+	switch {
+	case dryRun:
+	case len(held) == 0:
+		c.ownershipKnown = true
+		c.recorded = nil
+	default:
+		for serviceName := range desired {
+			c.recorded[serviceName] = struct{}{}
+		}
+	}
+	return held
+}
@@ -0,0 +1,168 @@
+package tailscale
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// manualServeStatus has svc:web, recorded by the previous run, and svc:manual,
+// made by hand
+const manualServeStatus = `{"Services":{
+	"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}},
+	"svc:manual":{"TCP":{"443":{"HTTPS":true}},"Web":{"manual.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.9:80"}}}}}
+}}`
+
+// desiredAPI returns the desired services of a single api container
+func desiredAPI() []*apptypes.ContainerService {
+	return []*apptypes.ContainerService{
+		{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "80"},
+	}
+}
+
+func TestReconcileServicesHoldsBackUnmanagedRemovals(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, manualServeStatus)
+	stateDir := t.TempDir()
+	writeStateFile(t, stateDir, desiredConfigFile, `{"version":"0.0.1","services":{
+		"svc:web":{"endpoints":{"tcp:443":"http://172.17.0.2:80"}}
+	}}`)
+	client := NewClient(ClientConfig{StateDir: stateDir})
+
+	// A dry run shows what the apply would hold back
+	result, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:web:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("dry run Removed = %v, want %v", result.Removed, want)
+	}
+	if want := []string{"svc:manual:443"}; !reflect.DeepEqual(result.Deferred, want) {
+		t.Errorf("dry run Deferred = %v, want %v", result.Deferred, want)
+	}
+
+	// The apply adds and removes the other services, also once the state file
+	// records this cycle's desired configuration
+	for range 2 {
+		result, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{})
+		if err != nil {
+			t.Fatalf("ReconcileServices() error = %v", err)
+		}
+		if want := []string{"svc:manual:443"}; !reflect.DeepEqual(result.Deferred, want) {
+			t.Errorf("Deferred = %v, want %v", result.Deferred, want)
+		}
+	}
+	calls := strings.Join(mutatingCalls(fake), "\n")
+	if strings.Contains(calls, "svc:manual") {
+		t.Errorf("held back service was changed, calls:\n%s", calls)
+	}
+	for _, want := range []string{"serve clear svc:web", "--service=svc:api"} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("calls don't contain %q:\n%s", want, calls)
+		}
+	}
+	if client.ownershipKnown {
+		t.Fatal("ownership should stay unknown while a removal is held back")
+	}
+
+	// A service added by this run is DockTail's, although the previous run
+	// didn't record it
+	fake.setServeStatus(t, `{"Services":{
+		"svc:api":{"TCP":{"443":{"HTTPS":true}},"Web":{"api.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.3:80"}}}}},
+		"svc:manual":{"TCP":{"443":{"HTTPS":true}},"Web":{"manual.ts.net:443":{"Handlers":{"/":{"Proxy":"http://10.0.0.9:80"}}}}}
+	}}`)
+	result, err = client.ReconcileServices(context.Background(), nil, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:api:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	if want := []string{"svc:manual:443"}; !reflect.DeepEqual(result.Deferred, want) {
+		t.Errorf("Deferred = %v, want %v", result.Deferred, want)
+	}
+
+	// Once the service made by hand is gone, ownership is known
+	fake.setServeStatus(t, `{}`)
+	if _, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if !client.ownershipKnown {
+		t.Error("ownership should be known once nothing is held back")
+	}
+}
+
+func TestReconcileServicesWithoutStateFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		stateDir func(t *testing.T) string
+	}{
+		{name: "no state directory", stateDir: func(*testing.T) string { return "" }},
+		{name: "first run", stateDir: func(t *testing.T) string { return t.TempDir() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			fake.setServeStatus(t, manualServeStatus)
+			client := NewClient(ClientConfig{StateDir: tt.stateDir(t)})
+
+			// Nothing tells the services apart, so they are removed with a warning
+			result, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{})
+			if err != nil {
+				t.Fatalf("ReconcileServices() error = %v", err)
+			}
+			if want := []string{"svc:manual:443", "svc:web:443"}; !reflect.DeepEqual(result.Removed, want) {
+				t.Errorf("Removed = %v, want %v", result.Removed, want)
+			}
+			if len(result.Deferred) != 0 {
+				t.Errorf("Deferred = %v, want none", result.Deferred)
+			}
+			if !client.ownershipKnown {
+				t.Error("ownership should be known after the first apply")
+			}
+		})
+	}
+}
+
+func TestReconcileServicesAllowUnmanagedOverwrite(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, manualServeStatus)
+	client := NewClient(ClientConfig{AllowUnmanagedOverwrite: true})
+
+	result, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:manual:443", "svc:web:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	if calls := strings.Join(fake.calls(), "\n"); !strings.Contains(calls, "serve clear svc:manual") {
+		t.Errorf("svc:manual was not cleared, calls:\n%s", calls)
+	}
+}
+
+func TestReconcileServicesEmptyConfigNeedsNoCheck(t *testing.T) {
+	fake := installFakeTailscale(t)
+	client := NewClient(ClientConfig{})
+
+	if _, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if !client.ownershipKnown {
+		t.Fatal("ownership should be known after applying to an empty config")
+	}
+
+	// Later applies of the same run remove services without the check
+	fake.setServeStatus(t, manualServeStatus)
+	result, err := client.ReconcileServices(context.Background(), desiredAPI(), ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if want := []string{"svc:manual:443", "svc:web:443"}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+}
@@ -4,17 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
-
-	"github.com/rs/zerolog/log"
+	"time"
 
 	apptypes "github.com/marvinvr/docktail/types"
 )
 
 // GetCurrentServices retrieves the current Tailscale service status using CLI
 func (c *Client) GetCurrentServices(ctx context.Context) (map[string]ServiceEndpoint, error) {
-	cmd := c.tailscaleCmd(ctx, "serve", "status", "--json")
-	output, err := cmd.CombinedOutput()
+	output, err := c.serveStatus(ctx)
 	if err != nil {
 		stderr := string(output)
 		// Empty config is not an error
@@ -59,41 +58,62 @@ func (c *Client) GetCurrentServices(ctx context.Context) (map[string]ServiceEndp
 				protocol = "https"
 			} else if tcpConfig.HTTP {
 				protocol = "http"
+			} else if tcpConfig.TerminateTLS != "" {
+				protocol = "tls-terminated-tcp"
 			} else {
 				protocol = "tcp"
 			}
 
-			// Get destination from Web config
-			var destination string
+			// HTTP(S) services have a handler per mount path in the Web config
+			// of their host:port; TCP services have a single entry, written
+			// like BuildDestination
+			handlers := map[string]string{"/": ""}
+			if tcpConfig.TCPForward != "" {
+				handlers["/"] = "tcp://" + tcpConfig.TCPForward
+			}
+			proxyProtocol := ""
+			if tcpConfig.ProxyProtocol > 0 {
+				proxyProtocol = strconv.Itoa(tcpConfig.ProxyProtocol)
+			}
 			for webKey, webConfig := range svcConfig.Web {
-				// Find the matching port in the web key
-				if strings.Contains(webKey, ":"+port) {
-					for _, handler := range webConfig.Handlers {
-						if handler.Proxy != "" {
-							destination = handler.Proxy
-							break
-						}
-					}
-					break
+				if !strings.HasSuffix(webKey, ":"+port) {
+					continue
+				}
+				if len(webConfig.Handlers) > 0 {
+					handlers = make(map[string]string, len(webConfig.Handlers))
 				}
+				for mountPath, handler := range webConfig.Handlers {
+					handlers[normalizeMountPath(mountPath)] = handler.destination()
+				}
+				break
 			}
 
-			// Create a unique key for this service+port combination
-			key := fmt.Sprintf("%s:%s", serviceName, port)
+			for mountPath, destination := range handlers {
+				path := ""
+				if mountPath != "/" {
+					path = mountPath
+				}
 
-			services[key] = ServiceEndpoint{
-				ServiceName: serviceName,
-				Port:        port,
-				Protocol:    protocol,
-				Destination: destination,
-			}
+				// Create a unique key for this service+port+path combination
+				key := serviceKey(serviceName, port, path)
 
-			log.Debug().
-				Str("service", serviceName).
-				Str("port", port).
-				Str("protocol", protocol).
-				Str("destination", destination).
-				Msg("Parsed existing service")
+				services[key] = ServiceEndpoint{
+					ServiceName:   serviceName,
+					Port:          port,
+					Protocol:      protocol,
+					Destination:   destination,
+					Path:          path,
+					ProxyProtocol: proxyProtocol,
+				}
+
+				log.Debug().
+					Str("service", serviceName).
+					Str("port", port).
+					Str("path", mountPath).
+					Str("protocol", protocol).
+					Str("destination", destination).
+					Msg("Parsed existing service")
+			}
 		}
 	}
 
@@ -109,26 +129,22 @@ func (c *Client) GetCurrentServices(ctx context.Context) (map[string]ServiceEndp
 // If adding fails due to config conflict, it clears (with drain) and retries
 func (c *Client) addService(ctx context.Context, svc *apptypes.ContainerService) error {
 	serviceName := fmt.Sprintf("svc:%s", svc.ServiceName)
-	destination := buildDestination(svc)
+	destination := BuildDestination(svc)
 
-	// Map service protocol to CLI flag (this is what Tailscale exposes)
-	var protocolFlag string
-	switch svc.ServiceProtocol {
-	case "http":
-		protocolFlag = "--http"
-	case "https":
-		protocolFlag = "--https"
-	case "tcp", "tls-terminated-tcp":
-		protocolFlag = "--tcp"
-	default:
-		return fmt.Errorf("unsupported service protocol: %s", svc.ServiceProtocol)
+	args, err := buildServeArgs(svc)
+	if err != nil {
+		return err
 	}
 
-	// Build the command: tailscale serve --service=svc:<name> --<protocol>=<port> <destination>
-	portArg := fmt.Sprintf("%s=%s", protocolFlag, svc.Port)
-	serviceArg := fmt.Sprintf("--service=%s", serviceName)
+	if svc.InsecureSkipVerify || svc.Protocol == "https+insecure" {
+		log.Warn().
+			Str("service", serviceName).
+			Str("container", svc.ContainerName).
+			Str("destination", destination).
+			Msg("INSECURE: backend TLS certificate verification is disabled for this service")
+	}
 
-	cmd := c.tailscaleCmd(ctx, "serve", serviceArg, portArg, destination)
+	cmd := c.tailscaleCmd(ctx, args...)
 
 	log.Debug().
 		Str("command", cmd.String()).
@@ -139,7 +155,10 @@ func (c *Client) addService(ctx context.Context, svc *apptypes.ContainerService)
 		Str("destination", destination).
 		Msg("Executing tailscale serve command")
 
-	output, err := cmd.CombinedOutput()
+	output, err := c.combinedOutput(cmd)
+	if err != nil && isTransientServeError(svc, string(output)) {
+		output, err = c.retryServe(ctx, serviceName, args, err, output)
+	}
 	if err != nil {
 		stderr := string(output)
 
@@ -160,8 +179,8 @@ func (c *Client) addService(ctx context.Context, svc *apptypes.ContainerService)
 				Str("service", serviceName).
 				Msg("Retrying add after clearing conflicting config")
 
-			retryCmd := c.tailscaleCmd(ctx, "serve", serviceArg, portArg, destination)
-			retryOutput, retryErr := retryCmd.CombinedOutput()
+			retryCmd := c.tailscaleCmd(ctx, args...)
+			retryOutput, retryErr := c.combinedOutput(retryCmd)
 			if retryErr != nil {
 				return fmt.Errorf("failed to add service after clearing: %w\nOutput: %s", retryErr, string(retryOutput))
 			}
@@ -187,6 +206,13 @@ func (c *Client) addService(ctx context.Context, svc *apptypes.ContainerService)
 				"Full setup guide: https://github.com/marvinvr/docktail#tailscale-admin-setup")
 		}
 
+		if svc.Handler == apptypes.HandlerRedirect {
+			return c.addRedirectFallback(ctx, svc, stderr)
+		}
+
+		if c.serveLandedDespiteError(ctx, svc) {
+			return nil
+		}
 		return fmt.Errorf("failed to add service: %w\nOutput: %s", err, stderr)
 	}
 
@@ -198,6 +224,151 @@ func (c *Client) addService(ctx context.Context, svc *apptypes.ContainerService)
 	return nil
 }
 
+// buildServeArgs builds the tailscale CLI arguments that configure a service:
+// serve --service=svc:<name> --<protocol>=<port> [--set-path=<path>] [--proxy-protocol=<version>] <destination>
+func buildServeArgs(svc *apptypes.ContainerService) ([]string, error) {
+	// Map service protocol to CLI flag (this is what Tailscale exposes)
+	var protocolFlag string
+	switch svc.ServiceProtocol {
+	case "http":
+		protocolFlag = "--http"
+	case "https":
+		protocolFlag = "--https"
+	case "tcp", "tls-terminated-tcp":
+		protocolFlag = "--tcp"
+	default:
+		return nil, fmt.Errorf("unsupported service protocol: %s", svc.ServiceProtocol)
+	}
+
+	serviceArg := fmt.Sprintf("--service=svc:%s", svc.ServiceName)
+	portArg := fmt.Sprintf("%s=%s", protocolFlag, svc.Port)
+
+	args := []string{"serve", serviceArg, portArg}
+	if svc.Path != "" {
+		if protocolFlag == "--tcp" {
+			return nil, fmt.Errorf("mount paths are not supported for %s services", svc.ServiceProtocol)
+		}
+		args = append(args, "--set-path="+svc.Path)
+	}
+	if svc.Handler != "" && svc.Handler != apptypes.HandlerProxy {
+		if protocolFlag == "--tcp" {
+			return nil, fmt.Errorf("%s handlers are not supported for %s services", svc.Handler, svc.ServiceProtocol)
+		}
+	}
+	if svc.ProxyProtocol != "" {
+		if protocolFlag != "--tcp" {
+			return nil, fmt.Errorf("proxy protocol is not supported for %s services", svc.ServiceProtocol)
+		}
+		args = append(args, "--proxy-protocol="+svc.ProxyProtocol)
+	}
+
+	return append(args, BuildDestination(svc)), nil
+}
+
+// endpointProtocol returns the protocol GetCurrentServices reports for an
+// endpoint of a service protocol. buildServeArgs configures tls-terminated-tcp
+// services with --tcp, so they read back as tcp.
+func endpointProtocol(serviceProtocol string) string {
+	if serviceProtocol == "tls-terminated-tcp" {
+		return "tcp"
+	}
+	return serviceProtocol
+}
+
+// addRedirectFallback proxies an http redirect endpoint to the service's backend
+// after the installed Tailscale rejected the redirect handler. Later cycles
+// proxy right away (see redirectFallbacks).
+func (c *Client) addRedirectFallback(ctx context.Context, svc *apptypes.ContainerService, output string) error {
+	log.Warn().
+		Str("service", "svc:"+svc.ServiceName).
+		Str("container", svc.ContainerName).
+		Str("output", strings.TrimSpace(output)).
+		Msg("Tailscale does not support redirect handlers, proxying http to the backend instead")
+
+	c.redirectUnsupported = true
+	return c.addService(ctx, redirectFallback(svc))
+}
+
+// redirectFallbacks replaces redirect endpoints with proxies to the same
+// backend once the installed Tailscale is known to lack redirect handlers
+func (c *Client) redirectFallbacks(services []*apptypes.ContainerService) []*apptypes.ContainerService {
+	if !c.redirectUnsupported {
+		return services
+	}
+	result := make([]*apptypes.ContainerService, len(services))
+	for i, svc := range services {
+		result[i] = svc
+		if svc.Handler == apptypes.HandlerRedirect {
+			result[i] = redirectFallback(svc)
+		}
+	}
+	return result
+}
+
+// redirectFallback returns a copy of a redirect endpoint that proxies to its backend
+func redirectFallback(svc *apptypes.ContainerService) *apptypes.ContainerService {
+	fallback := *svc
+	fallback.Handler = ""
+	fallback.HandlerContent = ""
+	return &fallback
+}
+
+// normalizeMountPath returns a handler mount path without a trailing slash,
+// keeping "/" for the root
+func normalizeMountPath(mountPath string) string {
+	if trimmed := strings.TrimRight(mountPath, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
+
+// removeHandlerArgs returns the tailscale arguments turning off a single
+// endpoint or path handler
+func removeHandlerArgs(endpoint ServiceEndpoint) ([]string, error) {
+	protocolFlag := "--" + endpoint.Protocol
+	if endpoint.Protocol != "http" && endpoint.Protocol != "https" && endpoint.Path != "" {
+		return nil, fmt.Errorf("cannot remove path %s of %s service %s", endpoint.Path, endpoint.Protocol, endpoint.ServiceName)
+	}
+
+	args := []string{"serve", "--service=" + endpoint.ServiceName, protocolFlag + "=" + endpoint.Port}
+	if endpoint.Path != "" {
+		args = append(args, "--set-path="+endpoint.Path)
+	}
+	return append(args, "off"), nil
+}
+
+// removeHandler turns off a single endpoint or path handler, leaving the rest
+// of the service (and its advertisement) in place
+func (c *Client) removeHandler(ctx context.Context, endpoint ServiceEndpoint) error {
+	args, err := removeHandlerArgs(endpoint)
+	if err != nil {
+		return err
+	}
+	cmd := c.tailscaleCmd(ctx, args...)
+
+	log.Debug().
+		Str("command", cmd.String()).
+		Str("service", endpoint.ServiceName).
+		Str("port", endpoint.Port).
+		Str("path", endpoint.Path).
+		Msg("Removing service handler")
+
+	if output, err := c.combinedOutput(cmd); err != nil {
+		stderr := string(output)
+		if isNotFoundError(stderr) {
+			return nil
+		}
+		return fmt.Errorf("failed to remove %s:%s%s: %w\nOutput: %s", endpoint.ServiceName, endpoint.Port, endpoint.Path, err, stderr)
+	}
+
+	log.Info().
+		Str("service", endpoint.ServiceName).
+		Str("port", endpoint.Port).
+		Str("path", endpoint.Path).
+		Msg("Service handler removed")
+	return nil
+}
+
 // clearServiceOnly clears a service configuration without draining
 // Used when updating service config (protocol change, etc) where service continues running
 func (c *Client) clearServiceOnly(ctx context.Context, serviceName string) error {
@@ -212,7 +383,7 @@ func (c *Client) clearServiceOnly(ctx context.Context, serviceName string) error
 		Str("service", serviceName).
 		Msg("Executing tailscale serve clear command")
 
-	output, err := cmd.CombinedOutput()
+	output, err := c.combinedOutput(cmd)
 	if err != nil {
 		stderr := string(output)
 		// Ignore errors if service doesn't exist
@@ -266,7 +437,7 @@ func (c *Client) removeService(ctx context.Context, serviceName string) error {
 		Str("service", serviceName).
 		Msg("Draining service to close existing connections")
 
-	drainOutput, drainErr := drainCmd.CombinedOutput()
+	drainOutput, drainErr := c.combinedOutput(drainCmd)
 	if drainErr != nil {
 		stderr := string(drainOutput)
 		// Only warn if drain fails - we'll still try to clear
@@ -295,7 +466,7 @@ func (c *Client) removeService(ctx context.Context, serviceName string) error {
 		Str("service", serviceName).
 		Msg("Clearing service configuration")
 
-	clearOutput, clearErr := clearCmd.CombinedOutput()
+	clearOutput, clearErr := c.combinedOutput(clearCmd)
 	if clearErr != nil {
 		stderr := string(clearOutput)
 		// Ignore errors if service doesn't exist
@@ -319,9 +490,93 @@ func (c *Client) removeService(ctx context.Context, serviceName string) error {
 func (c *Client) DrainService(ctx context.Context, serviceName string) error {
 	fullName := fmt.Sprintf("svc:%s", serviceName)
 	cmd := c.tailscaleCmd(ctx, "serve", "drain", fullName)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := c.combinedOutput(cmd); err != nil {
 		return fmt.Errorf("failed to drain service %s: %w\nOutput: %s", fullName, err, string(output))
 	}
 	log.Info().Str("service", fullName).Msg("Drained service")
 	return nil
 }
+
+// serveRetryDelays are the waits before retrying a failed serve command, so a
+// transient tailscaled or control plane error doesn't fail the service for a
+// whole cycle
+var serveRetryDelays = []time.Duration{time.Second, 2 * time.Second}
+
+// isTransientServeError reports whether a failed serve command is worth
+// retrying. Config conflicts, untagged nodes and rejected redirects have
+// handling of their own and fail the same way every time.
+func isTransientServeError(svc *apptypes.ContainerService, output string) bool {
+	return !isConfigConflictError(output) && !isUntaggedNodeError(output) && svc.Handler != apptypes.HandlerRedirect
+}
+
+// retryServe runs a failed serve command again after each of the retry
+// delays, until it succeeds. It returns the output and error of the last
+// attempt. serve commands replace the handler they configure, so running one
+// again is safe even if an earlier attempt took effect.
+func (c *Client) retryServe(ctx context.Context, serviceName string, args []string, err error, output []byte) ([]byte, error) {
+	for attempt, delay := range c.serveRetryDelays {
+		log.Warn().
+			Err(err).
+			Str("service", serviceName).
+			Str("output", strings.TrimSpace(string(output))).
+			Int("attempt", attempt+1).
+			Dur("retry_in", delay).
+			Msg("Serve command failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return output, err
+		case <-time.After(delay):
+		}
+
+		output, err = c.combinedOutput(c.tailscaleCmd(ctx, args...))
+		if err == nil {
+			log.Info().
+				Str("service", serviceName).
+				Int("attempts", attempt+2).
+				Msg("Serve command succeeded after retrying")
+			return output, nil
+		}
+	}
+	return output, err
+}
+
+// serveLandedDespiteError reads the serve config again after a serve command
+// kept failing, since a command reported as failed may still have changed the
+// node. It logs how the endpoint differs from the desired one and reports
+// whether it is configured as desired after all.
+func (c *Client) serveLandedDespiteError(ctx context.Context, svc *apptypes.ContainerService) bool {
+	serviceName := "svc:" + svc.ServiceName
+	key := serviceKey(serviceName, svc.Port, svc.Path)
+
+	current, err := c.GetCurrentServices(ctx)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to read serve config after a failed serve command")
+		return false
+	}
+
+	expected := BuildDestination(svc)
+	endpoint, ok := current[key]
+	switch {
+	case !ok:
+		log.Warn().
+			Str("key", key).
+			Msg("Serve command failed and the endpoint is not configured")
+	case endpoint.Destination == expected && endpoint.Protocol == endpointProtocol(svc.ServiceProtocol) &&
+		endpoint.ProxyProtocol == svc.ProxyProtocol:
+		log.Warn().
+			Str("key", key).
+			Str("destination", expected).
+			Msg("Serve command failed, but the endpoint is configured as desired")
+		return true
+	default:
+		log.Warn().
+			Str("key", key).
+			Str("current_destination", endpoint.Destination).
+			Str("expected_destination", expected).
+			Str("current_protocol", endpoint.Protocol).
+			Str("expected_protocol", svc.ServiceProtocol).
+			Msg("Serve command failed and left the endpoint with a different configuration")
+	}
+	return false
+}
@@ -1,8 +1,12 @@
 package tailscale
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	apptypes "github.com/marvinvr/docktail/types"
 )
@@ -344,3 +348,429 @@ func TestDetectFunnelProtocol(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildServeArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		svc         *apptypes.ContainerService
+		expected    []string
+		expectError bool
+	}{
+		{
+			name: "https service to http backend",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "web",
+				Port:            "443",
+				ServiceProtocol: "https",
+				Protocol:        "http",
+				IPAddress:       "172.17.0.2",
+				TargetPort:      "8080",
+			},
+			expected: []string{"serve", "--service=svc:web", "--https=443", "http://172.17.0.2:8080"},
+		},
+		{
+			name: "https backend with insecure-skip-verify",
+			svc: &apptypes.ContainerService{
+				ServiceName:        "proxmox",
+				Port:               "443",
+				ServiceProtocol:    "https",
+				Protocol:           "https",
+				InsecureSkipVerify: true,
+				IPAddress:          "172.17.0.3",
+				TargetPort:         "8006",
+			},
+			expected: []string{"serve", "--service=svc:proxmox", "--https=443", "https+insecure://172.17.0.3:8006"},
+		},
+		{
+			name: "destination override used verbatim",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "db",
+				Port:            "5432",
+				ServiceProtocol: "tcp",
+				Protocol:        "tcp",
+				IPAddress:       "10.0.0.2",
+				TargetPort:      "5432",
+				Destination:     "tcp://10.0.0.2:5432",
+			},
+			expected: []string{"serve", "--service=svc:db", "--tcp=5432", "tcp://10.0.0.2:5432"},
+		},
+		{
+			name: "static text handler",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "health",
+				Port:            "443",
+				ServiceProtocol: "https",
+				Handler:         apptypes.HandlerText,
+				HandlerContent:  "ok",
+			},
+			expected: []string{"serve", "--service=svc:health", "--https=443", "text:ok"},
+		},
+		{
+			name: "static file handler on a path",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "docs",
+				Port:            "80",
+				ServiceProtocol: "http",
+				Path:            "/static",
+				Handler:         apptypes.HandlerFile,
+				HandlerContent:  "/srv/www",
+			},
+			expected: []string{"serve", "--service=svc:docs", "--http=80", "--set-path=/static", "/srv/www"},
+		},
+		{
+			name: "static handler on tcp service",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "health",
+				Port:            "80",
+				ServiceProtocol: "tcp",
+				Handler:         apptypes.HandlerText,
+				HandlerContent:  "ok",
+			},
+			expectError: true,
+		},
+		{
+			name: "https backend without insecure-skip-verify",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "proxmox",
+				Port:            "443",
+				ServiceProtocol: "https",
+				Protocol:        "https",
+				IPAddress:       "172.17.0.3",
+				TargetPort:      "8006",
+			},
+			expected: []string{"serve", "--service=svc:proxmox", "--https=443", "https://172.17.0.3:8006"},
+		},
+		{
+			name: "tls-terminated-tcp uses tcp flag",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "db",
+				Port:            "5432",
+				ServiceProtocol: "tls-terminated-tcp",
+				Protocol:        "tcp",
+				IPAddress:       "172.17.0.4",
+				TargetPort:      "5432",
+			},
+			expected: []string{"serve", "--service=svc:db", "--tcp=5432", "tcp://172.17.0.4:5432"},
+		},
+		{
+			name: "tcp service with proxy protocol",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "smtp",
+				Port:            "25",
+				ServiceProtocol: "tcp",
+				Protocol:        "tcp",
+				ProxyProtocol:   "2",
+				IPAddress:       "172.17.0.5",
+				TargetPort:      "2525",
+			},
+			expected: []string{"serve", "--service=svc:smtp", "--tcp=25", "--proxy-protocol=2", "tcp://172.17.0.5:2525"},
+		},
+		{
+			name: "proxy protocol on http service",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "web",
+				Port:            "80",
+				ServiceProtocol: "http",
+				Protocol:        "http",
+				ProxyProtocol:   "1",
+			},
+			expectError: true,
+		},
+		{
+			name: "https service mounted at a path",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "tools",
+				Port:            "443",
+				ServiceProtocol: "https",
+				Protocol:        "http",
+				Path:            "/grafana",
+				IPAddress:       "172.17.0.4",
+				TargetPort:      "3000",
+			},
+			expected: []string{"serve", "--service=svc:tools", "--https=443", "--set-path=/grafana", "http://172.17.0.4:3000"},
+		},
+		{
+			name: "path on tcp service",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "db",
+				Port:            "5432",
+				ServiceProtocol: "tcp",
+				Protocol:        "tcp",
+				Path:            "/db",
+			},
+			expectError: true,
+		},
+		{
+			name: "unsupported service protocol",
+			svc: &apptypes.ContainerService{
+				ServiceName:     "web",
+				Port:            "443",
+				ServiceProtocol: "quic",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildServeArgs(tt.svc)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(args, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("buildServeArgs() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestServeAndFunnelFieldsOnOneService(t *testing.T) {
+	// A single ContainerService carries both serve and funnel configuration;
+	// each layer must read only its own fields.
+	svc := &apptypes.ContainerService{
+		ServiceEnabled:   true,
+		ServiceName:      "web",
+		Port:             "443",
+		TargetPort:       "18080",
+		ServiceProtocol:  "https",
+		Protocol:         "http",
+		IPAddress:        "localhost",
+		FunnelEnabled:    true,
+		FunnelPort:       "3000",
+		FunnelTargetPort: "13000",
+		FunnelFunnelPort: "8443",
+		FunnelProtocol:   "https",
+	}
+
+	args, err := buildServeArgs(svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := strings.Join(args, " "), "serve --service=svc:web --https=443 http://localhost:18080"; got != want {
+		t.Errorf("serve args = %q, want %q", got, want)
+	}
+
+	if got, want := FunnelDestination(svc), "http://localhost:13000"; got != want {
+		t.Errorf("funnel destination = %q, want %q", got, want)
+	}
+
+	current := CurrentFunnel{PublicPort: "8443", Protocol: "https", Destination: "http://localhost:13000"}
+	if !currentFunnelMatchesDesired(current, svc) {
+		t.Error("expected funnel state to match desired service")
+	}
+}
+
+func TestFunnelDestinationUsesFunnelAddress(t *testing.T) {
+	svc := &apptypes.ContainerService{
+		IPAddress:        "127.0.0.1",
+		FunnelEnabled:    true,
+		FunnelTargetPort: "13000",
+		FunnelIPAddress:  "::1",
+		FunnelProtocol:   "tcp",
+	}
+
+	if got, want := FunnelDestination(svc), "tcp://[::1]:13000"; got != want {
+		t.Errorf("funnel destination = %q, want %q", got, want)
+	}
+
+	svc.FunnelIPAddress = ""
+	if got, want := FunnelDestination(svc), "tcp://127.0.0.1:13000"; got != want {
+		t.Errorf("funnel destination without funnel address = %q, want %q", got, want)
+	}
+}
+
+func TestDesiredFunnelDestinationScheme(t *testing.T) {
+	tests := []struct {
+		name           string
+		protocol       string
+		targetProtocol string
+		expected       string
+	}{
+		{"https funnel defaults to http backend", "https", "", "http://172.17.0.2:8006"},
+		{"https backend", "https", "https", "https://172.17.0.2:8006"},
+		{"self-signed https backend", "https", "https+insecure", "https+insecure://172.17.0.2:8006"},
+		{"http funnel with self-signed backend", "http", "https+insecure", "https+insecure://172.17.0.2:8006"},
+		{"tcp funnel", "tcp", "", "tcp://172.17.0.2:8006"},
+		{"tls-terminated-tcp funnel", "tls-terminated-tcp", "", "tcp://172.17.0.2:8006"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &apptypes.ContainerService{
+				IPAddress:            "172.17.0.2",
+				FunnelEnabled:        true,
+				FunnelTargetPort:     "8006",
+				FunnelProtocol:       tt.protocol,
+				FunnelTargetProtocol: tt.targetProtocol,
+			}
+			if got := FunnelDestination(svc); got != tt.expected {
+				t.Errorf("FunnelDestination() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAddServiceRetriesTransientFailures(t *testing.T) {
+	add := "serve --service=svc:web --https=443 http://172.17.0.2:80"
+	web := &apptypes.ContainerService{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"}
+
+	tests := []struct {
+		name      string
+		failures  int    // times the add fails
+		status    string // serve status once the add gave up
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "fails twice then succeeds", failures: 2, wantCalls: 3},
+		{name: "keeps failing", failures: 3, wantErr: true, wantCalls: 3},
+		{
+			// The CLI reported an error although the change landed
+			name:      "keeps failing but landed",
+			failures:  3,
+			status:    `{"Services":{"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}}}}`,
+			wantCalls: 3,
+		},
+		{
+			name:      "keeps failing and landed partially",
+			failures:  3,
+			status:    `{"Services":{"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.9:80"}}}}}}}`,
+			wantErr:   true,
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := installFakeTailscale(t)
+			var failures []string
+			for i := 0; i < tt.failures; i++ {
+				failures = append(failures, add)
+			}
+			fake.failCallsOnce(t, failures...)
+
+			client := NewClient(ClientConfig{})
+			client.serveRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+			if tt.status != "" {
+				fake.setServeStatus(t, tt.status)
+			}
+
+			err := client.addService(context.Background(), web)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("addService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var adds []string
+			for _, call := range fake.calls() {
+				if call == add {
+					adds = append(adds, call)
+				}
+			}
+			if len(adds) != tt.wantCalls {
+				t.Errorf("add ran %d times, want %d", len(adds), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIsTransientServeError(t *testing.T) {
+	proxy := &apptypes.ContainerService{ServiceName: "web", Port: "443", ServiceProtocol: "https"}
+	redirect := &apptypes.ContainerService{ServiceName: "web", Port: "80", ServiceProtocol: "http", Handler: apptypes.HandlerRedirect}
+
+	tests := []struct {
+		name   string
+		svc    *apptypes.ContainerService
+		output string
+		want   bool
+	}{
+		{name: "daemon error", svc: proxy, output: "error: context deadline exceeded", want: true},
+		{name: "untagged node", svc: proxy, output: "service hosts must be tagged nodes", want: false},
+		{name: "config conflict", svc: proxy, output: "port 443 is already serving", want: false},
+		{name: "redirect handler", svc: redirect, output: "unknown flag", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientServeError(tt.svc, tt.output); got != tt.want {
+				t.Errorf("isTransientServeError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolChangeReconfiguresService(t *testing.T) {
+	fake := installFakeTailscale(t)
+	client := NewClient(ClientConfig{})
+	client.serveRetryDelays = nil
+	db := func(proxyProtocol string) []*apptypes.ContainerService {
+		return []*apptypes.ContainerService{{
+			ContainerName: "db", ServiceEnabled: true, ServiceName: "db", Port: "5432",
+			ServiceProtocol: "tcp", Protocol: "tcp", IPAddress: "172.17.0.2", TargetPort: "5432",
+			ProxyProtocol: proxyProtocol,
+		}}
+	}
+	running := func(proxyProtocol int) {
+		fake.setServeStatus(t, fmt.Sprintf(`{"Services":{"svc:db":{"TCP":{"5432":{"TCPForward":"172.17.0.2:5432","ProxyProtocol":%d}}}}}`, proxyProtocol))
+	}
+
+	tests := []struct {
+		name          string
+		running       int    // PROXY protocol version on the node, 0 for none
+		label         string // docktail.service.proxy-protocol
+		wantServe     string // the serve command run, empty for none
+		wantChangeTo  string // ToProxyProtocol in the config diff
+		wantUnchanged bool
+	}{
+		{name: "label added", running: 0, label: "2", wantServe: "serve --service=svc:db --tcp=5432 --proxy-protocol=2 tcp://172.17.0.2:5432", wantChangeTo: "2"},
+		{name: "label unchanged", running: 2, label: "2", wantUnchanged: true},
+		{name: "version changed", running: 2, label: "1", wantServe: "serve --service=svc:db --tcp=5432 --proxy-protocol=1 tcp://172.17.0.2:5432", wantChangeTo: "1"},
+		{name: "label removed", running: 1, label: "", wantServe: "serve --service=svc:db --tcp=5432 tcp://172.17.0.2:5432"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running(tt.running)
+			before := len(fake.calls())
+
+			result, err := client.ReconcileServices(context.Background(), db(tt.label), ReconcileOptions{})
+			if err != nil {
+				t.Fatalf("ReconcileServices() error = %v", err)
+			}
+
+			var served []string
+			for _, call := range fake.calls()[before:] {
+				if strings.HasPrefix(call, "serve --service=") {
+					served = append(served, call)
+				}
+			}
+			if tt.wantUnchanged {
+				if len(result.Changed) != 0 || len(served) != 0 {
+					t.Errorf("Changed = %v, serve calls = %v, want none", result.Changed, served)
+				}
+				return
+			}
+			if len(result.Changed) != 1 || result.Changed[0] != "svc:db:5432" {
+				t.Errorf("Changed = %v, want [svc:db:5432]", result.Changed)
+			}
+			if len(served) != 1 || served[0] != tt.wantServe {
+				t.Errorf("serve calls = %v, want [%s]", served, tt.wantServe)
+			}
+			changes := client.LastConfigDiff().Changes()
+			if len(changes) != 1 || changes[0].ToProxyProtocol != tt.wantChangeTo || changes[0].FromProxyProtocol != proxyProtocolLabel(tt.running) {
+				t.Errorf("config diff = %+v, want the PROXY protocol changing from %q to %q", changes, proxyProtocolLabel(tt.running), tt.wantChangeTo)
+			}
+		})
+	}
+}
+
+// proxyProtocolLabel formats a PROXY protocol version like the label
+func proxyProtocolLabel(version int) string {
+	if version == 0 {
+		return ""
+	}
+	return fmt.Sprint(version)
+}
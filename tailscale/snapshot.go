@@ -0,0 +1,83 @@
+package tailscale
+
+import (
+	"context"
+	"expvar"
+	"sync"
+)
+
+// snapshotReuses counts serve config reads answered from the cycle's snapshot
+// instead of running the tailscale CLI
+var snapshotReuses = expvar.NewInt("docktail_status_reads_saved_total")
+
+// statusSnapshot is the serve config read during a reconciliation. The
+// tailscale CLI prints the same config for `serve status --json` and
+// `funnel status --json`, so the service and funnel reconciliation share a
+// single read. Commands that change the node discard it, so reads after a
+// change, such as verifications, see the new config.
+type statusSnapshot struct {
+	mu     sync.Mutex
+	active bool // a reconciliation is running
+	// generation counts discards, so a read that raced a change isn't kept
+	generation uint64
+	fetched    bool
+	output     []byte
+	err        error
+}
+
+// beginSnapshot shares serve config reads until endSnapshot
+func (c *Client) beginSnapshot() {
+	c.snapshot.mu.Lock()
+	defer c.snapshot.mu.Unlock()
+	c.snapshot.active = true
+	c.discardSnapshotLocked()
+}
+
+// endSnapshot stops sharing serve config reads
+func (c *Client) endSnapshot() {
+	c.snapshot.mu.Lock()
+	defer c.snapshot.mu.Unlock()
+	c.snapshot.active = false
+	c.discardSnapshotLocked()
+}
+
+// discardSnapshot drops the shared read after a command that may have
+// changed the node, whether or not it succeeded
+func (c *Client) discardSnapshot() {
+	c.snapshot.mu.Lock()
+	defer c.snapshot.mu.Unlock()
+	c.discardSnapshotLocked()
+}
+
+func (c *Client) discardSnapshotLocked() {
+	c.snapshot.generation++
+	c.snapshot.fetched = false
+	c.snapshot.output = nil
+	c.snapshot.err = nil
+}
+
+// serveStatus returns the output of `tailscale serve status --json`, read
+// once per reconciliation unless the node changed in between
+func (c *Client) serveStatus(ctx context.Context) ([]byte, error) {
+	c.snapshot.mu.Lock()
+	if c.snapshot.active && c.snapshot.fetched {
+		output, err := c.snapshot.output, c.snapshot.err
+		c.snapshot.mu.Unlock()
+		snapshotReuses.Add(1)
+		log.Trace().Msg("Reusing the serve config read this reconciliation")
+		return output, err
+	}
+	generation := c.snapshot.generation
+	c.snapshot.mu.Unlock()
+
+	output, err := c.combinedOutput(c.tailscaleCmd(ctx, "serve", "status", "--json"))
+
+	c.snapshot.mu.Lock()
+	defer c.snapshot.mu.Unlock()
+	if c.snapshot.active && c.snapshot.generation == generation && ctx.Err() == nil {
+		c.snapshot.fetched = true
+		c.snapshot.output = output
+		c.snapshot.err = err
+	}
+	return output, err
+}
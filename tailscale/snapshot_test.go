@@ -0,0 +1,83 @@
+package tailscale
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// statusReads returns how many times the fake CLI printed the serve config
+func statusReads(fake *fakeTailscale) (reads int, order []string) {
+	for _, call := range fake.calls() {
+		switch call {
+		case "serve status --json", "funnel status --json":
+			reads++
+			order = append(order, "read")
+		case "version", "status --json":
+		default:
+			order = append(order, "change")
+		}
+	}
+	return reads, order
+}
+
+func TestReconcileServicesReadsServeConfigOnce(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}}
+	}}`)
+	if err := os.WriteFile(filepath.Join(fake.dir, "funnels"), []byte("443 http://172.17.0.2:8080\n"), 0o644); err != nil {
+		t.Fatalf("failed to write fake funnel state: %v", err)
+	}
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{
+		{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"},
+		funnelService("app", "443", "8080"),
+	}
+
+	saved := snapshotReuses.Value()
+	if _, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{}); err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+
+	// Nothing changed, so the services and funnels come from one read
+	if reads, order := statusReads(fake); reads != 1 {
+		t.Errorf("serve config read %d times (%v), want once", reads, order)
+	}
+	if got := snapshotReuses.Value() - saved; got != 1 {
+		t.Errorf("docktail_status_reads_saved_total increased by %d, want 1", got)
+	}
+}
+
+func TestServeConfigIsReadAgainAfterChanges(t *testing.T) {
+	fake := installFakeTailscale(t)
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{funnelService("app", "443", "8080")}
+
+	result, err := client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("ReconcileServices() error = %v", err)
+	}
+	if len(result.FunnelsAdded) != 1 {
+		t.Fatalf("FunnelsAdded = %v, want 443", result.FunnelsAdded)
+	}
+
+	// The funnel is verified with a read made after enabling it
+	reads, order := statusReads(fake)
+	if reads != 2 || order[len(order)-1] != "read" || order[len(order)-2] != "change" {
+		t.Errorf("calls = %v, want the funnel verified with a fresh read", order)
+	}
+
+	// Outside a reconciliation every read runs the CLI
+	for range 2 {
+		if _, err := client.GetCurrentServices(context.Background()); err != nil {
+			t.Fatalf("GetCurrentServices() error = %v", err)
+		}
+	}
+	if got, _ := statusReads(fake); got != reads+2 {
+		t.Errorf("serve config read %d times after two reads outside a reconciliation, want %d", got, reads+2)
+	}
+}
@@ -1,30 +1,121 @@
 package tailscale
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
-
-	"github.com/rs/zerolog/log"
+	"sync/atomic"
+	"time"
 
 	apptypes "github.com/marvinvr/docktail/types"
 )
 
+// DefaultCommandTimeout bounds a tailscale CLI call. Serve and funnel changes
+// finish in seconds, so a call still running is most likely stuck on a prompt.
+const DefaultCommandTimeout = 60 * time.Second
+
+// commandWaitDelay is how long a killed command may keep its output open
+const commandWaitDelay = time.Second
+
+// ErrCommandTimeout is returned for tailscale CLI calls that didn't finish in
+// time
+var ErrCommandTimeout = errors.New("tailscale command timed out, it may be waiting for an interactive prompt")
+
+// skipPromptsSince is the first CLI release with --yes on serve and funnel
+var skipPromptsSince = [2]int{1, 52}
+
 // tailscaleCmd creates an exec.Cmd for the tailscale CLI with the correct
 // environment. When a version mismatch between the bundled CLI and the host's
 // tailscaled has been detected, it sets TS_DEBUG_FAKE_IPC_VERSION so the CLI
-// doesn't reject the connection.
+// doesn't reject the connection. Commands are sent to the client's tailscaled
+// socket when one is configured.
+//
+// Stdin is left nil, which connects it to the null device, so a prompt reads
+// EOF instead of waiting for an answer. Serve and funnel changes also get --yes
+// when the CLI supports it.
 func (c *Client) tailscaleCmd(ctx context.Context, args ...string) *exec.Cmd {
+	if c.skipPrompts && len(args) > 1 && (args[0] == "serve" || args[0] == "funnel") && strings.HasPrefix(args[1], "--") {
+		args = append([]string{args[0], "--yes"}, args[1:]...)
+	}
+	if c.socketPath != "" {
+		args = append([]string{"--socket=" + c.socketPath}, args...)
+	}
 	cmd := exec.CommandContext(ctx, "tailscale", args...)
+	cmd.WaitDelay = commandWaitDelay
 	if c.serverVersion != "" {
 		cmd.Env = append(os.Environ(), "TS_DEBUG_FAKE_IPC_VERSION="+c.serverVersion)
 	}
 	return cmd
 }
 
+// combinedOutput runs cmd like exec.Cmd.CombinedOutput, killing it when it
+// runs longer than the command timeout. A killed command returns
+// ErrCommandTimeout along with the output it wrote so far.
+func (c *Client) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		c.logCommand(cmd, start, nil, err)
+		return nil, err
+	}
+
+	timeout := c.commandTimeout
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	if mutatingCommand(cmd.Args[1:]) {
+		c.discardSnapshot()
+	}
+
+	if timedOut.Load() {
+		c.logCommand(cmd, start, output.Bytes(), ErrCommandTimeout)
+		log.Error().
+			Str("command", cmd.String()).
+			Dur("timeout", timeout).
+			Str("output", output.String()).
+			Msg("Tailscale command timed out, it may be waiting for an interactive prompt")
+		return output.Bytes(), fmt.Errorf("%w: %s after %s", ErrCommandTimeout, strings.Join(cmd.Args[1:], " "), timeout)
+	}
+	c.logCommand(cmd, start, output.Bytes(), err)
+	return output.Bytes(), err
+}
+
+// cliVersionAtLeast reports whether the first line of `tailscale version`
+// output, such as "1.90.0", is at least major.minor
+func cliVersionAtLeast(output string, want [2]int) bool {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	parts := strings.SplitN(strings.TrimSpace(line), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return major > want[0] || major == want[0] && minor >= want[1]
+}
+
 // versionMismatchRe matches the tailscale CLI warning about version mismatch
 // and captures the server version string.
 var versionMismatchRe = regexp.MustCompile(`tailscaled server version "([^"]+)"`)
@@ -35,9 +126,15 @@ var versionMismatchRe = regexp.MustCompile(`tailscaled server version "([^"]+)"`
 // found, the server version is stored so that subsequent CLI calls use
 // TS_DEBUG_FAKE_IPC_VERSION to bypass the check.
 func (c *Client) DetectVersionMismatch(ctx context.Context) {
-	cmd := exec.CommandContext(ctx, "tailscale", "version")
-	output, _ := cmd.CombinedOutput()
+	args := []string{"version"}
+	if c.socketPath != "" {
+		args = append([]string{"--socket=" + c.socketPath}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "tailscale", args...)
+	cmd.WaitDelay = commandWaitDelay
+	output, _ := c.combinedOutput(cmd)
 	outStr := string(output)
+	c.skipPrompts = cliVersionAtLeast(outStr, skipPromptsSince)
 
 	if !strings.Contains(outStr, "!= tailscaled server version") {
 		// Clear stale override so normal matched-version setups use default behavior.
@@ -66,17 +163,55 @@ func (c *Client) DetectVersionMismatch(ctx context.Context) {
 }
 
 // stripWarnings removes warning messages from Tailscale CLI output
-// Warnings appear before the JSON and need to be stripped for parsing
+// Warnings appear before the JSON and need to be stripped for parsing. Warnings
+// may themselves contain braces, so the JSON is the first brace that starts a
+// complete, valid object; braces at the start of a line are tried first.
+// Output without a valid object is returned unchanged.
 func stripWarnings(output []byte) string {
 	outputStr := string(output)
-	jsonStart := strings.Index(outputStr, "{")
-	if jsonStart > 0 {
-		outputStr = outputStr[jsonStart:]
+
+	start, end, ok := findJSONObject(outputStr, true)
+	if !ok {
+		start, end, ok = findJSONObject(outputStr, false)
+	}
+	if !ok {
+		return outputStr
+	}
+
+	if start > 0 || end < len(strings.TrimRight(outputStr, " \t\r\n")) {
 		log.Debug().
-			Int("stripped_bytes", jsonStart).
+			Int("stripped_bytes", len(outputStr)-(end-start)).
 			Msg("Stripped warning message from tailscale output")
 	}
-	return outputStr
+	return outputStr[start:end]
+}
+
+// findJSONObject returns the bounds of the first valid JSON object in s. With
+// lineStart set, only objects that begin a line (after indentation) are considered.
+func findJSONObject(s string, lineStart bool) (int, int, bool) {
+	for offset := 0; offset < len(s); {
+		idx := strings.IndexByte(s[offset:], '{')
+		if idx < 0 {
+			break
+		}
+		start := offset + idx
+		offset = start + 1
+
+		if lineStart {
+			lineBegin := strings.LastIndexByte(s[:start], '\n') + 1
+			if strings.TrimSpace(s[lineBegin:start]) != "" {
+				continue
+			}
+		}
+
+		dec := json.NewDecoder(strings.NewReader(s[start:]))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			continue
+		}
+		return start, start + int(dec.InputOffset()), true
+	}
+	return 0, 0, false
 }
 
 // isNotFoundError checks if an error message indicates a resource doesn't exist
@@ -111,7 +246,12 @@ func normalizeServiceName(serviceName string) string {
 	return strings.TrimPrefix(normalized, "svc:")
 }
 
+// shouldIgnoreService reports whether DockTail must leave a service alone: it
+// is listed in IGNORE_SERVICE_NAMES or lacks this host's SERVICE_NAME_PREFIX
 func (c *Client) shouldIgnoreService(serviceName string) bool {
+	if c.serviceNamePrefix != "" && !strings.HasPrefix(normalizeServiceName(serviceName), c.serviceNamePrefix) {
+		return true
+	}
 	if len(c.ignoredServices) == 0 {
 		return false
 	}
@@ -120,9 +260,52 @@ func (c *Client) shouldIgnoreService(serviceName string) bool {
 	return ok
 }
 
-// buildDestination constructs the destination URL for a service
-func buildDestination(svc *apptypes.ContainerService) string {
-	// Use the service protocol directly in the destination URL
-	// The protocol flag and destination protocol should match the service configuration
-	return fmt.Sprintf("%s://%s:%s", svc.Protocol, svc.IPAddress, svc.TargetPort)
+// BuildDestination constructs the destination URL for a service
+func BuildDestination(svc *apptypes.ContainerService) string {
+	switch svc.Handler {
+	case apptypes.HandlerText:
+		return "text:" + svc.HandlerContent
+	case apptypes.HandlerRedirect:
+		return "redirect:" + svc.HandlerContent
+	case apptypes.HandlerFile:
+		return svc.HandlerContent
+	}
+	if svc.Destination != "" {
+		return svc.Destination
+	}
+	// The backend protocol picks the destination scheme. TLS termination happens
+	// in tailscaled, so tls-terminated-tcp backends receive plain TCP, as with
+	// funnel destinations.
+	scheme := svc.Protocol
+	switch {
+	case scheme == "tcp" || scheme == "tls-terminated-tcp":
+		scheme = "tcp"
+	case svc.InsecureSkipVerify && scheme == "https":
+		// Tailscale skips backend certificate verification for https+insecure destinations
+		scheme = "https+insecure"
+	}
+	return scheme + "://" + net.JoinHostPort(svc.IPAddress, svc.TargetPort)
+}
+
+// ParseSockets parses a comma-separated list of name=path pairs naming additional
+// tailscaled sockets (e.g. "work=/run/ts-work.sock,home=/run/ts-home.sock")
+func ParseSockets(value string) (map[string]string, error) {
+	sockets := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		path = strings.TrimSpace(path)
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid socket entry %q: expected name=path", entry)
+		}
+		if _, exists := sockets[name]; exists {
+			return nil, fmt.Errorf("duplicate socket name %q", name)
+		}
+		sockets[name] = path
+	}
+	return sockets, nil
 }
@@ -1,7 +1,15 @@
 package tailscale
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	apptypes "github.com/marvinvr/docktail/types"
 )
@@ -37,6 +45,41 @@ func TestStripWarnings(t *testing.T) {
 			input:    []byte(""),
 			expected: "",
 		},
+		{
+			name:     "warning containing braces",
+			input:    []byte("Warning: {see https://tailscale.com/s/serve} for details\n{\"Services\":{}}"),
+			expected: `{"Services":{}}`,
+		},
+		{
+			name:     "warning containing a JSON object",
+			input:    []byte("Warning: daemon replied {\"error\":\"stale\"}\n{\"Services\":{\"svc:web\":{}}}"),
+			expected: `{"Services":{"svc:web":{}}}`,
+		},
+		{
+			name:     "unbalanced brace in warning",
+			input:    []byte("# note: missing } or {\n{\"key\":\"value\"}"),
+			expected: `{"key":"value"}`,
+		},
+		{
+			name:     "indented JSON after warnings",
+			input:    []byte("Warning: first\n  {\n    \"key\": \"value\"\n  }\n"),
+			expected: "{\n    \"key\": \"value\"\n  }",
+		},
+		{
+			name:     "trailing output after JSON",
+			input:    []byte("{\"key\":\"value\"}\nWarning: after"),
+			expected: `{"key":"value"}`,
+		},
+		{
+			name:     "JSON on the same line as a warning",
+			input:    []byte("Warning: x {\"key\":\"value\"}"),
+			expected: `{"key":"value"}`,
+		},
+		{
+			name:     "only invalid braces",
+			input:    []byte("Warning: {not json}"),
+			expected: "Warning: {not json}",
+		},
 		{
 			name:     "brace at position 0 unchanged",
 			input:    []byte("{\"already\":\"clean\"}"),
@@ -204,6 +247,33 @@ func TestShouldIgnoreService(t *testing.T) {
 	}
 }
 
+func TestShouldIgnoreServicePrefix(t *testing.T) {
+	client := &Client{
+		serviceNamePrefix: "nas-",
+		ignoredServices:   map[string]struct{}{"nas-manual": {}},
+	}
+
+	tests := []struct {
+		serviceName string
+		expected    bool
+	}{
+		{"svc:nas-web", false},
+		{"nas-web", false},
+		{"SVC:NAS-Web", false},
+		{"svc:web", true},
+		{"svc:other-nas-web", true},
+		{"svc:nas-manual", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serviceName, func(t *testing.T) {
+			if got := client.shouldIgnoreService(tt.serviceName); got != tt.expected {
+				t.Errorf("shouldIgnoreService(%q) = %v, want %v", tt.serviceName, got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestNewClientNormalizesIgnoredServices(t *testing.T) {
 	client := NewClient(ClientConfig{
 		IgnoreServiceNames: []string{
@@ -272,6 +342,15 @@ func TestBuildDestination(t *testing.T) {
 			},
 			expected: "http://localhost:9080",
 		},
+		{
+			name: "IPv6 destination is bracketed",
+			svc: &apptypes.ContainerService{
+				Protocol:   "http",
+				IPAddress:  "::1",
+				TargetPort: "8080",
+			},
+			expected: "http://[::1]:8080",
+		},
 		{
 			name: "https+insecure protocol",
 			svc: &apptypes.ContainerService{
@@ -281,13 +360,242 @@ func TestBuildDestination(t *testing.T) {
 			},
 			expected: "https+insecure://172.17.0.4:8443",
 		},
+		{
+			name: "https with insecure-skip-verify",
+			svc: &apptypes.ContainerService{
+				Protocol:           "https",
+				InsecureSkipVerify: true,
+				IPAddress:          "172.17.0.5",
+				TargetPort:         "8006",
+			},
+			expected: "https+insecure://172.17.0.5:8006",
+		},
+		{
+			name: "insecure-skip-verify ignored for http",
+			svc: &apptypes.ContainerService{
+				Protocol:           "http",
+				InsecureSkipVerify: true,
+				IPAddress:          "172.17.0.6",
+				TargetPort:         "8080",
+			},
+			expected: "http://172.17.0.6:8080",
+		},
+		{
+			name: "TLS-terminated TCP service proxies plain TCP",
+			svc: &apptypes.ContainerService{
+				ServiceProtocol: "tls-terminated-tcp",
+				Protocol:        "tls-terminated-tcp",
+				IPAddress:       "10.0.0.7",
+				TargetPort:      "5432",
+			},
+			expected: "tcp://10.0.0.7:5432",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := buildDestination(tt.svc)
+			result := BuildDestination(tt.svc)
 			if result != tt.expected {
-				t.Errorf("buildDestination() = %q, want %q", result, tt.expected)
+				t.Errorf("BuildDestination() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildDestinationScheme(t *testing.T) {
+	// The destination scheme follows the backend protocol, while the serve
+	// flag follows the service protocol
+	tests := []struct {
+		protocol       string
+		expectedScheme string
+		expectedFlag   string
+	}{
+		{"http", "http", "--http=80"},
+		{"https", "https", "--https=80"},
+		{"https+insecure", "https+insecure", "--https=80"},
+		{"tcp", "tcp", "--tcp=80"},
+		{"tls-terminated-tcp", "tcp", "--tcp=80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			serviceProtocol := tt.protocol
+			if serviceProtocol == "https+insecure" {
+				serviceProtocol = "https"
+			}
+			svc := &apptypes.ContainerService{
+				ServiceName:     "svc",
+				Port:            "80",
+				ServiceProtocol: serviceProtocol,
+				Protocol:        tt.protocol,
+				IPAddress:       "10.0.0.8",
+				TargetPort:      "9000",
+			}
+
+			destination := BuildDestination(svc)
+			if want := tt.expectedScheme + "://10.0.0.8:9000"; destination != want {
+				t.Errorf("BuildDestination() = %q, want %q", destination, want)
+			}
+
+			args, err := buildServeArgs(svc)
+			if err != nil {
+				t.Fatalf("buildServeArgs() error = %v", err)
+			}
+			if args[2] != tt.expectedFlag {
+				t.Errorf("serve flag = %q, want %q", args[2], tt.expectedFlag)
+			}
+		})
+	}
+}
+
+func TestTailscaleCmdSocket(t *testing.T) {
+	tests := []struct {
+		name       string
+		socketPath string
+		expected   []string
+	}{
+		{"no socket", "", []string{"tailscale", "serve", "status"}},
+		{"custom socket", "/run/ts-work.sock", []string{"tailscale", "--socket=/run/ts-work.sock", "serve", "status"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{socketPath: tt.socketPath}
+			cmd := client.tailscaleCmd(context.Background(), "serve", "status")
+			if !reflect.DeepEqual(cmd.Args, tt.expected) {
+				t.Errorf("tailscaleCmd() args = %v, want %v", cmd.Args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTailscaleCmdSkipsPrompts(t *testing.T) {
+	tests := []struct {
+		name        string
+		skipPrompts bool
+		args        []string
+		expected    []string
+	}{
+		{"serve change", true, []string{"serve", "--service=svc:web", "--https=443", "http://172.17.0.2:80"},
+			[]string{"tailscale", "serve", "--yes", "--service=svc:web", "--https=443", "http://172.17.0.2:80"}},
+		{"funnel change", true, []string{"funnel", "--bg", "--https=443", "http://172.17.0.2:80"},
+			[]string{"tailscale", "funnel", "--yes", "--bg", "--https=443", "http://172.17.0.2:80"}},
+		{"subcommand", true, []string{"serve", "clear", "svc:web"}, []string{"tailscale", "serve", "clear", "svc:web"}},
+		{"other command", true, []string{"status", "--json"}, []string{"tailscale", "status", "--json"}},
+		{"older CLI", false, []string{"funnel", "--bg", "--https=443", "http://172.17.0.2:80"},
+			[]string{"tailscale", "funnel", "--bg", "--https=443", "http://172.17.0.2:80"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{skipPrompts: tt.skipPrompts}
+			cmd := client.tailscaleCmd(context.Background(), tt.args...)
+			if !reflect.DeepEqual(cmd.Args, tt.expected) {
+				t.Errorf("tailscaleCmd() args = %v, want %v", cmd.Args, tt.expected)
+			}
+		})
+	}
+}
+
+// installScript puts a tailscale CLI running the given shell script first on PATH
+func installScript(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tailscale CLI requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tailscale"), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCombinedOutputStdinIsEmpty(t *testing.T) {
+	// Answers a prompt like the CLI would, if anything could be read
+	installScript(t, `if read -r answer; then echo "answered $answer"; else echo "eof"; fi`)
+
+	client := NewClient(ClientConfig{})
+	output, err := client.combinedOutput(client.tailscaleCmd(context.Background(), "serve", "--service=svc:web", "--https=443", "off"))
+	if err != nil {
+		t.Fatalf("combinedOutput() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(output)); got != "eof" {
+		t.Errorf("output = %q, want the command to read EOF from stdin", got)
+	}
+}
+
+func TestCombinedOutputTimesOut(t *testing.T) {
+	installScript(t, `echo "Enable funnel? [y/N]"; exec sleep 10`)
+
+	client := NewClient(ClientConfig{})
+	client.commandTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	output, err := client.combinedOutput(client.tailscaleCmd(context.Background(), "funnel", "--bg", "--https=443", "http://172.17.0.2:80"))
+	if !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("combinedOutput() error = %v, want ErrCommandTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("combinedOutput() took %s, want it to stop at the timeout", elapsed)
+	}
+	if !strings.Contains(string(output), "Enable funnel?") {
+		t.Errorf("output = %q, want the prompt", output)
+	}
+}
+
+func TestDetectVersionMismatchEnablesSkipPrompts(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"1.90.0\n  tailscale commit: abc", true},
+		{"1.52.1", true},
+		{"1.50.0", false},
+		{"2.0.0", true},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			installScript(t, "printf '%s\\n' '"+strings.ReplaceAll(tt.version, "\n", "' '")+"'")
+
+			client := NewClient(ClientConfig{})
+			client.DetectVersionMismatch(context.Background())
+			if client.skipPrompts != tt.want {
+				t.Errorf("skipPrompts = %v, want %v", client.skipPrompts, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSockets(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  map[string]string
+		wantError bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"single", "work=/run/ts-work.sock", map[string]string{"work": "/run/ts-work.sock"}, false},
+		{
+			name:     "multiple with whitespace",
+			value:    " work = /run/ts-work.sock , home=/run/ts-home.sock,",
+			expected: map[string]string{"work": "/run/ts-work.sock", "home": "/run/ts-home.sock"},
+		},
+		{"missing path", "work=", nil, true},
+		{"missing name", "=/run/ts.sock", nil, true},
+		{"no separator", "/run/ts.sock", nil, true},
+		{"duplicate name", "work=/a.sock,work=/b.sock", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSockets(tt.value)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseSockets(%q) error = %v, wantError %v", tt.value, err, tt.wantError)
+			}
+			if !tt.wantError && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseSockets(%q) = %v, want %v", tt.value, got, tt.expected)
 			}
 		})
 	}
@@ -0,0 +1,65 @@
+package tailscale
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"sort"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+// verifySkips counts reconciliations that trusted the last applied serve
+// config instead of reading it back (VERIFY_INTERVAL)
+var verifySkips = expvar.NewInt("docktail_status_reads_skipped_total")
+
+// desiredHash identifies a set of desired services regardless of their order
+func desiredHash(desired []*apptypes.ContainerService) string {
+	encoded := make([]string, 0, len(desired))
+	for _, svc := range desired {
+		data, err := json.Marshal(svc)
+		if err != nil {
+			return ""
+		}
+		encoded = append(encoded, string(data))
+	}
+	sort.Strings(encoded)
+
+	sum := sha256.New()
+	for _, data := range encoded {
+		sum.Write([]byte(data))
+		sum.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// skipVerify reports whether a cycle can leave the node alone: the desired
+// services hash to what the last cycle applied without failures, and that
+// cycle read the serve config less than VERIFY_INTERVAL ago. Cycles that must
+// look at the node anyway, such as those after Docker events (opts.Verify),
+// dry runs, maintenance cycles and cycles with removals waiting out their
+// grace period, always read it.
+func (c *Client) skipVerify(hash string, opts ReconcileOptions) bool {
+	if c.verifyInterval <= 0 || opts.Verify || opts.DryRun || opts.Maintenance || len(opts.Destroyed) > 0 {
+		return false
+	}
+	if hash == "" || hash != c.appliedHash || len(c.drainedAt) > 0 {
+		return false
+	}
+	// time.Since uses the monotonic clock, so wall clock jumps don't matter
+	return time.Since(c.verifiedAt) < c.verifyInterval
+}
+
+// markVerified records that the serve config was read and now matches hash
+func (c *Client) markVerified(hash string) {
+	c.appliedHash = hash
+	c.verifiedAt = time.Now()
+}
+
+// forgetVerified makes the next cycle read the serve config, after it failed
+// to apply or tailscaled couldn't be reached and may have restarted
+func (c *Client) forgetVerified() {
+	c.appliedHash = ""
+}
@@ -0,0 +1,72 @@
+package tailscale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apptypes "github.com/marvinvr/docktail/types"
+)
+
+func TestVerifyIntervalSkipsUnchangedReads(t *testing.T) {
+	fake := installFakeTailscale(t)
+	fake.setServeStatus(t, `{"Services":{
+		"svc:web":{"TCP":{"443":{"HTTPS":true}},"Web":{"web.ts.net:443":{"Handlers":{"/":{"Proxy":"http://172.17.0.2:80"}}}}}
+	}}`)
+	client := NewClient(ClientConfig{VerifyInterval: time.Hour})
+	client.serveRetryDelays = nil
+	web := &apptypes.ContainerService{ContainerName: "web", ServiceEnabled: true, ServiceName: "web", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.2", TargetPort: "80"}
+	api := &apptypes.ContainerService{ContainerName: "api", ServiceEnabled: true, ServiceName: "api", Port: "443", ServiceProtocol: "https", Protocol: "http", IPAddress: "172.17.0.3", TargetPort: "80"}
+
+	reads := 0
+	cycle := func(name string, desired []*apptypes.ContainerService, opts ReconcileOptions, wantRead bool) {
+		t.Helper()
+		client.ReconcileServices(context.Background(), desired, opts)
+		got, _ := statusReads(fake)
+		if read := got > reads; read != wantRead {
+			t.Errorf("%s: serve config read = %v, want %v", name, read, wantRead)
+		}
+		reads = got
+	}
+
+	saved := verifySkips.Value()
+	cycle("first cycle", []*apptypes.ContainerService{web}, ReconcileOptions{}, true)
+	cycle("unchanged", []*apptypes.ContainerService{web}, ReconcileOptions{}, false)
+	cycle("unchanged again", []*apptypes.ContainerService{web}, ReconcileOptions{}, false)
+	cycle("after a Docker event", []*apptypes.ContainerService{web}, ReconcileOptions{Verify: true}, true)
+	cycle("unchanged after the event", []*apptypes.ContainerService{web}, ReconcileOptions{}, false)
+	if got := verifySkips.Value() - saved; got != 3 {
+		t.Errorf("docktail_status_reads_skipped_total increased by %d, want 3", got)
+	}
+
+	// A new service that fails to apply leaves the config unverified
+	fake.failCalls(t, "serve --service=svc:api --https=443 http://172.17.0.3:80")
+	cycle("changed", []*apptypes.ContainerService{web, api}, ReconcileOptions{}, true)
+	cycle("after a failed apply", []*apptypes.ContainerService{web, api}, ReconcileOptions{}, true)
+
+	// tailscaled couldn't be reached, so it may have lost the config
+	fake.failCalls(t)
+	cycle("applied", []*apptypes.ContainerService{web, api}, ReconcileOptions{}, true)
+	fake.failCalls(t, "status --json")
+	cycle("tailscaled unreachable", []*apptypes.ContainerService{web, api}, ReconcileOptions{}, true)
+	fake.failCalls(t)
+	cycle("tailscaled back", []*apptypes.ContainerService{web, api}, ReconcileOptions{}, false)
+
+	// Once the interval has passed the config is read again
+	client.verifiedAt = time.Now().Add(-2 * time.Hour)
+	cycle("interval passed", []*apptypes.ContainerService{web, api}, ReconcileOptions{}, true)
+}
+
+func TestVerifyIntervalDisabledReadsEveryCycle(t *testing.T) {
+	fake := installFakeTailscale(t)
+	client := NewClient(ClientConfig{})
+	desired := []*apptypes.ContainerService{funnelService("app", "443", "8080")}
+
+	for range 3 {
+		before, _ := statusReads(fake)
+		client.ReconcileServices(context.Background(), desired, ReconcileOptions{})
+		if after, _ := statusReads(fake); after == before {
+			t.Fatal("serve config not read with VERIFY_INTERVAL unset")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// triggerFileDebounce is how long the TRIGGER_FILE has to stay untouched
+// before a reconciliation is requested, so a tool writing it in several
+// steps or touching it in a loop requests one reconciliation
+const triggerFileDebounce = 500 * time.Millisecond
+
+// watchTriggerFile calls trigger whenever path is created, written or
+// touched, once changes have settled for debounce. The directory of path is
+// watched rather than the file, so the file may be created later, or be
+// replaced by editors and tools that write a new file and rename it over the
+// old one. It returns once ctx is done, closing the watcher, or with an
+// error if the directory can't be watched.
+func watchTriggerFile(ctx context.Context, path string, debounce time.Duration, trigger func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer func() { _ = watcher.Close() }()
+
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(path), err)
+	}
+
+	// A stopped timer whose channel is drained, reset by every change
+	timer := time.NewTimer(debounce)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) {
+				continue
+			}
+			log.Trace().Str("file", path).Str("op", event.Op.String()).Msg("Trigger file changed")
+			timer.Reset(debounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Str("key", "TRIGGER_FILE").Msg("Error watching trigger file")
+
+		case <-timer.C:
+			log.Info().Str("file", path).Msg("Trigger file changed, requesting reconciliation")
+			trigger()
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchTriggerFileTriggersOnTouch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reconcile")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	triggered := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- watchTriggerFile(ctx, path, 50*time.Millisecond, func() { triggered <- struct{}{} })
+	}()
+	// Give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// Rapid touches are debounced into one reconciliation
+	for i := 0; i < 3; i++ {
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Other files in the directory are ignored
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "other"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-triggered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("touching the trigger file did not request a reconciliation")
+	}
+	select {
+	case <-triggered:
+		t.Error("rapid touches requested more than one reconciliation")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("watchTriggerFile() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchTriggerFile() did not return after cancellation")
+	}
+}
+
+func TestWatchTriggerFileMissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "reconcile")
+	if err := watchTriggerFile(context.Background(), path, time.Millisecond, func() {}); err == nil {
+		t.Error("watchTriggerFile() error = nil, want an error for a missing directory")
+	}
+}
@@ -1,22 +1,52 @@
 package types
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // ContainerService represents a parsed container with its Tailscale service configuration
 type ContainerService struct {
-	ContainerID      string
-	ContainerName    string
-	ServiceEnabled   bool
-	ServiceName      string
-	Port             string   // Tailscale service port (e.g., "443")
-	TargetPort       string   // Container/host port to proxy to (e.g., "9080")
-	ServiceProtocol  string   // Protocol Tailscale uses (e.g., "https", "http", "tcp")
-	Protocol         string   // Protocol the container speaks (e.g., "http", "https", "tcp")
-	Tags             []string // Tailscale service tags (e.g., ["tag:container", "tag:web"])
-	IPAddress        string
-	FunnelEnabled    bool   // Enable Tailscale Funnel (public internet access)
-	FunnelPort       string // Container port for funnel (separate from service port)
-	FunnelTargetPort string // Host port that maps to FunnelPort
-	FunnelFunnelPort string // Public-facing port (443, 8443, or 10000 for HTTPS)
-	FunnelProtocol   string // Funnel protocol (https, tcp, tls-terminated-tcp)
+	ContainerID          string
+	ContainerName        string
+	ServiceEnabled       bool
+	ServiceName          string
+	Port                 string   // Tailscale service port (e.g., "443")
+	TargetPort           string   // Container/host port to proxy to (e.g., "9080")
+	ServiceProtocol      string   // Protocol Tailscale uses (e.g., "https", "http", "tcp")
+	Protocol             string   // Protocol the container speaks (e.g., "http", "https", "tcp")
+	InsecureSkipVerify   bool     // Skip backend TLS certificate verification (https backends only)
+	ProxyProtocol        string   // PROXY protocol version sent to TCP backends ("1" or "2", empty = disabled)
+	Tags                 []string // Tailscale service tags (e.g., ["tag:container", "tag:web"])
+	IPAddress            string
+	Destination          string    // Backend URL used verbatim instead of IPAddress and TargetPort (docktail.service.destination)
+	Path                 string    // Mount path of an http/https service (e.g. "/grafana", empty = "/")
+	AliasOf              string    // Primary service name when this service is an alias (docktail.service.aliases)
+	Handler              string    // Serve handler type: HandlerProxy, HandlerText, HandlerFile or HandlerRedirect (empty = HandlerProxy)
+	HandlerContent       string    // Response text for HandlerText, absolute path for HandlerFile, target URL for HandlerRedirect
+	FunnelEnabled        bool      // Enable Tailscale Funnel (public internet access)
+	FunnelPort           string    // Container port for funnel (separate from service port)
+	FunnelTargetPort     string    // Host port that maps to FunnelPort
+	FunnelIPAddress      string    // Funnel backend address when it differs from IPAddress (empty = IPAddress)
+	FunnelFunnelPort     string    // Public-facing port (443, 8443, or 10000 for HTTPS)
+	FunnelProtocol       string    // Funnel protocol (https, tcp, tls-terminated-tcp)
+	FunnelTargetProtocol string    // Backend protocol of an https funnel (http, https, https+insecure; empty = http)
+	Socket               string    // Named tailscaled socket to advertise on (empty = default socket)
+	Health               string    // Docker health status of the container (healthy, starting, unhealthy; empty = no health check)
+	Role                 string    // Failover role among containers claiming the same endpoint (primary, backup; empty = none)
+	StartedAt            time.Time // When the container was last started
+	DockerHost           string    // Docker endpoint the container runs on when several hosts are watched (empty = the only host)
+	Unadvertised         bool      // Keep the serve config but don't advertise the service (docktail.service.advertise=false)
+	Preserve             bool      // Container is not managed (e.g. image not allowlisted); leave its existing services untouched
+
+	// StartupDelay is how long the services and funnel of the container wait
+	// after the reconciler first sees it (docktail.service.startup-delay)
+	StartupDelay time.Duration
 }
 
 // TailscaleServiceConfig represents the JSON structure for Tailscale service configuration
@@ -28,21 +58,164 @@ type TailscaleServiceConfig struct {
 // ServiceDefinition defines a single Tailscale service
 type ServiceDefinition struct {
 	Endpoints map[string]string `json:"endpoints"`
+
+	// The Tailscale service configuration file format has no mount paths or
+	// PROXY protocol, so the fields below are left out of its JSON. They only
+	// serve comparing configurations.
+
+	// Paths holds handlers mounted below "/", keyed by endpoint and then mount
+	// path (e.g. {"tcp:443": {"/grafana": "http://172.17.0.2:3000"}})
+	Paths map[string]map[string]string `json:"-"`
+	// ProxyProtocols holds the PROXY protocol version TCP endpoints send to
+	// their backend, keyed by endpoint (e.g. {"tcp:5432": "2"})
+	ProxyProtocols map[string]string `json:"-"`
 }
 
+// Serve handler types of a ContainerService
+const (
+	HandlerProxy    = "proxy"    // Reverse proxy to the container (default)
+	HandlerText     = "text"     // Respond with static text
+	HandlerFile     = "file"     // Serve a file or directory from the tailscaled host
+	HandlerRedirect = "redirect" // Redirect requests to HandlerContent
+)
+
 // Labels for container discovery
 const (
-	LabelEnable           = "docktail.service.enable"
-	LabelService          = "docktail.service.name"
-	LabelPort             = "docktail.service.service-port"
-	LabelServiceProtocol  = "docktail.service.service-protocol"
-	LabelTarget           = "docktail.service.port"
-	LabelTargetProtocol   = "docktail.service.protocol"
-	LabelTags             = "docktail.tags"
-	LabelFunnelEnable     = "docktail.funnel.enable"
-	LabelFunnelPort       = "docktail.funnel.port"        // Container port (like service.port)
-	LabelFunnelFunnelPort = "docktail.funnel.funnel-port" // Public port (443, 8443, 10000)
-	LabelFunnelProtocol   = "docktail.funnel.protocol"
-	LabelDirect           = "docktail.service.direct"  // Direct container IP proxying (default: true, set to "false" to use published ports)
-	LabelNetwork          = "docktail.service.network" // Docker network to use for container IP (default: bridge or first available)
+	LabelEnable               = "docktail.service.enable"
+	LabelService              = "docktail.service.name"
+	LabelPort                 = "docktail.service.service-port"
+	LabelServiceProtocol      = "docktail.service.service-protocol"
+	LabelTarget               = "docktail.service.port"
+	LabelTargetProtocol       = "docktail.service.protocol"
+	LabelInsecureSkipVerify   = "docktail.service.insecure-skip-verify" // Skip TLS verification for https backends (default: false)
+	LabelProxyProtocol        = "docktail.service.proxy-protocol"       // PROXY protocol version (1 or 2) for tcp and tls-terminated-tcp services
+	LabelTags                 = "docktail.tags"
+	LabelFunnelEnable         = "docktail.funnel.enable"
+	LabelFunnelConfirm        = "docktail.funnel.confirm"     // Required alongside docktail.funnel.enable, so a funnel is never enabled by accident
+	LabelFunnelPort           = "docktail.funnel.port"        // Container port (like service.port)
+	LabelFunnelFunnelPort     = "docktail.funnel.funnel-port" // Public port (443, 8443, 10000)
+	LabelFunnelProtocol       = "docktail.funnel.protocol"
+	LabelFunnelTargetProtocol = "docktail.funnel.target-protocol" // Backend protocol of https funnels (http, https, https+insecure; default: http)
+	LabelDirect               = "docktail.service.direct"         // Direct container IP proxying (default: true, set to "false" to use published ports)
+	LabelNetwork              = "docktail.service.network"        // Docker network to use for container IP (default: bridge or first available)
+	LabelPath                 = "docktail.service.path"           // Mount path for http/https services (default: "/")
+	LabelDestination          = "docktail.service.destination"    // Backend URL overriding container port lookup (requires ALLOW_DESTINATION_OVERRIDE)
+	LabelHostGateway          = "docktail.service.host-gateway"   // Address of the Docker host for published ports (default: detected)
+	LabelServeText            = "docktail.service.serve-text"     // Respond with static text instead of proxying to the container
+	LabelServeFile            = "docktail.service.serve-file"     // Serve a file or directory on the tailscaled host instead of proxying
+	LabelAliases              = "docktail.service.aliases"        // Comma-separated additional service names for the primary service
+	LabelRedirectHTTP         = "docktail.service.redirect-http"  // Answer http:80 with a redirect to the https service (default: false)
+	LabelAllPorts             = "docktail.service.all-ports"      // Also serve every other published port of the container under the service name (default: false)
+	LabelScaleMode            = "docktail.service.scale-mode"     // How compose replicas share the service: single or port-offset (default: single)
+	LabelRole                 = "docktail.service.role"           // Failover role when several containers claim the service: primary or backup (default: none)
+	LabelStartupDelay         = "docktail.service.startup-delay"  // How long to wait after first seeing the container before serving it, such as 30s (default: 0)
+	LabelAdvertise            = "docktail.service.advertise"      // Advertise the service to the tailnet; false keeps only the local serve config (default: true)
+	LabelRemoveOnPause        = "docktail.remove-on-pause"        // Remove the service and funnel while the container is paused (default: false)
+	LabelSocket               = "docktail.socket"                 // Named tailscaled socket from TAILSCALE_SOCKETS (default: TAILSCALE_SOCKET)
+
+	// Readiness check of http and https backends
+	LabelReadinessPath    = "docktail.service.readiness-path"    // Path the backend must answer before the container's services are advertised (http/https backends)
+	LabelReadinessTimeout = "docktail.service.readiness-timeout" // Timeout of the readiness request (default: 2s)
+	LabelReadinessStatus  = "docktail.service.readiness-status"  // Status codes counting as ready, such as "200-399,401" (default: 200-399)
 )
+
+// ReconcileResult summarizes the changes made during a single reconciliation cycle
+type ReconcileResult struct {
+	Added          []string      // Service keys added (e.g., "svc:web:443")
+	Removed        []string      // Service keys removed
+	Changed        []string      // Service keys whose configuration was updated
+	FunnelsAdded   []string      // Funnel public ports enabled
+	FunnelsRemoved []string      // Funnel public ports removed
+	Collisions     []string      // Desired services another node already advertises
+	Deferred       []string      // Service keys whose removal maintenance mode or the ownership check deferred
+	DryRun         bool          // Changes were computed but not applied
+	Trigger        string        // What started the cycle (startup, event, periodic, forced)
+	Err            error         // Error that ended the cycle, if any
+	Duration       time.Duration // Wall time of the cycle
+
+	// Failed maps the services and service keys that failed to apply to what
+	// went wrong (APPLY_MODE=per-service)
+	Failed map[string]string
+	// Applied lists the service keys configured as desired when the cycle
+	// ended. It is nil when the cycle ended before it knew, such as when the
+	// serve config couldn't be checked.
+	Applied []string
+}
+
+// SkippedContainer is a container discovery left out, and why
+type SkippedContainer struct {
+	ContainerID   string // Short container ID
+	ContainerName string
+	DockerHost    string // Docker endpoint when several hosts are watched
+	Reason        string // Why it was skipped, such as "parse_error"
+	Error         string // What went wrong, in detail
+}
+
+// endpointKeyRegex matches service endpoint keys such as "tcp:443"
+var endpointKeyRegex = regexp.MustCompile(`^([a-z]+):(\d+)$`)
+
+// validateEndpoint checks an endpoint key and its destination URL
+func validateEndpoint(name, key, destination string) error {
+	matches := endpointKeyRegex.FindStringSubmatch(key)
+	if matches == nil {
+		return fmt.Errorf("invalid service config: service %s endpoint %q must have the form proto:port", name, key)
+	}
+	if port, err := strconv.Atoi(matches[2]); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid service config: service %s endpoint %q has port out of range 1-65535", name, key)
+	}
+
+	// Static handlers serve text, a redirect or a file on the node instead of proxying
+	if strings.HasPrefix(destination, "text:") || strings.HasPrefix(destination, "redirect:") || strings.HasPrefix(destination, "/") {
+		return nil
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return fmt.Errorf("invalid service config: service %s endpoint %s destination %q: %w", name, key, destination, err)
+	}
+	if parsed.Scheme == "" || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid service config: service %s endpoint %s destination %q must be a URL with scheme and host", name, key, destination)
+	}
+	return nil
+}
+
+// Validate checks the configuration against the Tailscale service configuration
+// file format so malformed configs are caught before being applied.
+func (c *TailscaleServiceConfig) Validate() error {
+	if c.Version == "" {
+		return errors.New("invalid service config: missing version")
+	}
+
+	if len(c.Services) == 0 {
+		return errors.New("invalid service config: no services defined")
+	}
+
+	for name, def := range c.Services {
+		if !strings.HasPrefix(name, "svc:") || len(name) == len("svc:") {
+			return fmt.Errorf("invalid service config: service name %q must have the form svc:<name>", name)
+		}
+
+		if len(def.Endpoints) == 0 && len(def.Paths) == 0 {
+			return fmt.Errorf("invalid service config: service %s has no endpoints", name)
+		}
+
+		for key, destination := range def.Endpoints {
+			if err := validateEndpoint(name, key, destination); err != nil {
+				return err
+			}
+		}
+
+		for key, handlers := range def.Paths {
+			for path, destination := range handlers {
+				if !strings.HasPrefix(path, "/") {
+					return fmt.Errorf("invalid service config: service %s endpoint %s path %q must start with /", name, key, path)
+				}
+				if err := validateEndpoint(name, key, destination); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTailscaleServiceConfigValidate(t *testing.T) {
+	valid := func() *TailscaleServiceConfig {
+		return &TailscaleServiceConfig{
+			Version: "0.0.1",
+			Services: map[string]ServiceDefinition{
+				"svc:web": {Endpoints: map[string]string{"tcp:443": "http://172.17.0.2:8080"}},
+				"svc:db":  {Endpoints: map[string]string{"tcp:5432": "tcp://localhost:5432"}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		mutate    func(c *TailscaleServiceConfig)
+		wantError string
+	}{
+		{
+			name:   "valid config",
+			mutate: func(c *TailscaleServiceConfig) {},
+		},
+		{
+			name: "static handlers",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:health"] = ServiceDefinition{
+					Endpoints: map[string]string{"tcp:443": "text:ok"},
+					Paths:     map[string]map[string]string{"tcp:443": {"/static": "/srv/www"}},
+				}
+			},
+		},
+		{
+			name:      "missing version",
+			mutate:    func(c *TailscaleServiceConfig) { c.Version = "" },
+			wantError: "missing version",
+		},
+		{
+			name:      "no services",
+			mutate:    func(c *TailscaleServiceConfig) { c.Services = nil },
+			wantError: "no services",
+		},
+		{
+			name: "service without svc prefix",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["web"] = ServiceDefinition{Endpoints: map[string]string{"tcp:80": "http://localhost:80"}}
+			},
+			wantError: "svc:<name>",
+		},
+		{
+			name: "service without endpoints",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:empty"] = ServiceDefinition{}
+			},
+			wantError: "no endpoints",
+		},
+		{
+			name: "endpoint key without protocol",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:web"].Endpoints["443"] = "http://localhost:8080"
+			},
+			wantError: "proto:port",
+		},
+		{
+			name: "endpoint key with non-numeric port",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:web"].Endpoints["tcp:https"] = "http://localhost:8080"
+			},
+			wantError: "proto:port",
+		},
+		{
+			name: "endpoint port out of range",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:web"].Endpoints["tcp:70000"] = "http://localhost:8080"
+			},
+			wantError: "out of range",
+		},
+		{
+			name: "destination without scheme",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:web"].Endpoints["tcp:443"] = "localhost:8080"
+			},
+			wantError: "scheme and host",
+		},
+		{
+			name: "destination with empty host",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:web"].Endpoints["tcp:443"] = "http://:8080"
+			},
+			wantError: "scheme and host",
+		},
+		{
+			name: "service with only path handlers",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:tools"] = ServiceDefinition{Paths: map[string]map[string]string{
+					"tcp:443": {"/grafana": "http://172.17.0.4:3000"},
+				}}
+			},
+		},
+		{
+			name: "path without leading slash",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:tools"] = ServiceDefinition{Paths: map[string]map[string]string{
+					"tcp:443": {"grafana": "http://172.17.0.4:3000"},
+				}}
+			},
+			wantError: "must start with /",
+		},
+		{
+			name: "path handler with invalid destination",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:tools"] = ServiceDefinition{Paths: map[string]map[string]string{
+					"tcp:443": {"/grafana": "http://:3000"},
+				}}
+			},
+			wantError: "scheme and host",
+		},
+		{
+			name: "unparseable destination",
+			mutate: func(c *TailscaleServiceConfig) {
+				c.Services["svc:web"].Endpoints["tcp:443"] = "http://[::1"
+			},
+			wantError: "destination",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.mutate(cfg)
+			err := cfg.Validate()
+
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error but got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tt.wantError)
+			}
+		})
+	}
+}
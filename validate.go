@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/marvinvr/docktail/docker"
+)
+
+// runValidate checks the DockTail labels of compose files without a Docker
+// daemon and prints the problems it finds. It returns 1 when any is an error.
+func runValidate(cfg *config, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: docktail validate [compose file or directory ...]")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	// Only the labels of the compose files are validated
+	dockerConfig := cfg.Docker
+	dockerConfig.Hosts = nil
+	dockerConfig.StaticServicesFile = ""
+	dockerConfig.StateDir = ""
+
+	return writeValidateIssues(out, docker.ValidateCompose(dockerConfig, paths))
+}
+
+// writeValidateIssues prints the issues and a summary, returning the exit code
+func writeValidateIssues(w io.Writer, issues []docker.ComposeIssue) int {
+	errs, warnings := 0, 0
+	for _, issue := range issues {
+		fmt.Fprintln(w, issue)
+		if issue.Warning {
+			warnings++
+		} else {
+			errs++
+		}
+	}
+	fmt.Fprintf(w, "%d error(s), %d warning(s)\n", errs, warnings)
+	if errs > 0 {
+		return 1
+	}
+	return 0
+}
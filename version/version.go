@@ -0,0 +1,66 @@
+// Package version holds the build information of the DockTail binary. Release
+// builds set it with ldflags:
+//
+//	go build -ldflags "-X github.com/marvinvr/docktail/version.Version=v1.2.3 \
+//	  -X github.com/marvinvr/docktail/version.Commit=abc1234 \
+//	  -X github.com/marvinvr/docktail/version.BuildDate=2025-01-01T00:00:00Z"
+package version
+
+import (
+	"expvar"
+	"fmt"
+	"runtime/debug"
+)
+
+// Set at build time with -ldflags "-X ..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// Get returns the build information. Builds without ldflags fall back to the
+// VCS revision and time Go embeds, so `go build` and `go install` binaries are
+// identifiable too.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate}
+	if info.Commit != "" && info.BuildDate != "" {
+		return info
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// String formats the build information for `docktail version`
+func (i Info) String() string {
+	return fmt.Sprintf("docktail %s (commit %s, built %s)", i.Version, i.Commit, i.BuildDate)
+}
+
+func init() {
+	// A constant gauge carrying the build as labels, in the style of
+	// Prometheus build_info metrics
+	expvar.Publish("docktail_build_info", expvar.Func(func() any { return Get() }))
+}
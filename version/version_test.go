@@ -0,0 +1,23 @@
+package version
+
+import "testing"
+
+func TestGet(t *testing.T) {
+	oldVersion, oldCommit, oldBuildDate := Version, Commit, BuildDate
+	t.Cleanup(func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldBuildDate })
+
+	Version, Commit, BuildDate = "v1.2.3", "abc1234", "2026-01-02T03:04:05Z"
+	info := Get()
+	if want := (Info{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-01-02T03:04:05Z"}); info != want {
+		t.Errorf("Get() = %+v, want %+v", info, want)
+	}
+	if want := "docktail v1.2.3 (commit abc1234, built 2026-01-02T03:04:05Z)"; info.String() != want {
+		t.Errorf("String() = %q, want %q", info.String(), want)
+	}
+
+	// Test binaries carry no VCS information, so nothing is filled in
+	Version, Commit, BuildDate = "dev", "", ""
+	if info := Get(); info.Commit != "unknown" || info.BuildDate != "unknown" {
+		t.Errorf("Get() without ldflags = %+v, want unknown commit and build date", info)
+	}
+}